@@ -0,0 +1,162 @@
+package goimpcore
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// DefaultBootstrapSamples is used when Bootstrap is called with nSamples <= 0
+const DefaultBootstrapSamples = 200
+
+// Refit settings for the bootstrap samples, the fits start from the primary
+// result so they need far less work than the original solve
+const (
+	bootstrapMinFunc       = 1.35e-2
+	bootstrapMaxIterations = 10
+)
+
+// BootstrapResult holds parameter statistics over the bootstrap refits
+type BootstrapResult struct {
+	Params  []float64    `json:"params"` // medians
+	CI95    [][2]float64 `json:"ci95"`   // lower, upper percentile bounds
+	Bias    []float64    `json:"bias"`   // mean minus the primary fit value
+	StdDev  []float64    `json:"std_dev"`
+	Samples int          `json:"samples"` // successful refits
+}
+
+// Bootstrap estimates parameter confidence intervals with a residual bootstrap.
+// Residuals of result are resampled onto the fitted spectrum to generate
+// nSamples synthetic datasets, which are refitted with the solver configuration
+// of s on up to runtime.NumCPU() goroutines.
+func Bootstrap(s *Solver, result Result, nSamples int) BootstrapResult {
+	if nSamples <= 0 {
+		nSamples = DefaultBootstrapSamples
+	}
+
+	nParams := len(result.Params)
+	fitted := s.impedance(s.Freqs, result.Params)
+
+	// With modulus weighting the noise is proportional to |Z|, so resample
+	// residuals relative to the fitted modulus
+	residuals := make([][2]float64, len(fitted))
+	moduli := GetModulo(fitted)
+	for i, c := range fitted {
+		r := [2]float64{s.Observed[i][0] - c[0], s.Observed[i][1] - c[1]}
+		if s.Weighting == MODULUS && moduli[i] > 0 {
+			r[0] /= moduli[i]
+			r[1] /= moduli[i]
+		}
+		residuals[i] = r
+	}
+
+	workers := runtime.NumCPU()
+	if workers > nSamples {
+		workers = nSamples
+	}
+
+	jobs := make(chan int, nSamples)
+	samples := make(chan []float64, nSamples)
+	seed := s.seed()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for range jobs {
+				synthetic := make([][2]float64, len(fitted))
+				for i, c := range fitted {
+					r := residuals[rnd.Intn(len(residuals))]
+					if s.Weighting == MODULUS {
+						r = [2]float64{r[0] * moduli[i], r[1] * moduli[i]}
+					}
+					synthetic[i] = [2]float64{c[0] + r[0], c[1] + r[1]}
+				}
+
+				sCopy := s.Clone()
+				sCopy.Observed = synthetic
+				sCopy.InitValues = make([]float64, nParams)
+				copy(sCopy.InitValues, result.Params)
+
+				res := sCopy.Solve(bootstrapMinFunc, bootstrapMaxIterations)
+				if res.Status == OK && len(res.Params) == nParams {
+					samples <- res.Params
+				}
+			}
+		}(rand.New(rand.NewSource(seed + int64(w))))
+	}
+
+	for i := 0; i < nSamples; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(samples)
+	}()
+
+	perParam := make([][]float64, nParams)
+	for p := range samples {
+		for i, v := range p {
+			perParam[i] = append(perParam[i], v)
+		}
+	}
+
+	bs := BootstrapResult{
+		Params: make([]float64, nParams),
+		CI95:   make([][2]float64, nParams),
+		Bias:   make([]float64, nParams),
+		StdDev: make([]float64, nParams),
+	}
+	if nParams > 0 {
+		bs.Samples = len(perParam[0])
+	}
+	if bs.Samples == 0 {
+		log.Printf("Bootstrap: no successful refits out of %d samples", nSamples)
+		return bs
+	}
+
+	for i, values := range perParam {
+		sort.Float64s(values)
+		mean := 0.0
+		for _, v := range values {
+			mean += v
+		}
+		mean /= float64(len(values))
+
+		variance := 0.0
+		for _, v := range values {
+			variance += math.Pow(v-mean, 2)
+		}
+		if len(values) > 1 {
+			variance /= float64(len(values) - 1)
+		}
+
+		bs.Params[i] = percentile(values, 0.5)
+		bs.CI95[i] = [2]float64{percentile(values, 0.025), percentile(values, 0.975)}
+		bs.Bias[i] = mean - result.Params[i]
+		bs.StdDev[i] = math.Sqrt(variance)
+	}
+
+	log.Printf("Bootstrap: %d/%d successful refits", bs.Samples, nSamples)
+	return bs
+}
+
+// percentile returns the p-th quantile (0..1) of sorted values using linear interpolation
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[upper]-sorted[lower])
+}
@@ -0,0 +1,54 @@
+package goimpcore
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// The 95 % bootstrap intervals of an RC circuit hold its true parameters in
+// at least 90 % of repeated noisy measurements
+func TestBootstrapCoverage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("50 bootstrapped fits")
+	}
+	log.SetOutput(io.Discard) // every refit logs its iterations
+	defer log.SetOutput(os.Stderr)
+	const repetitions, samples = 50, 100
+	params := []float64{10, 1e-5, 100}
+
+	covered := make([]int, len(params))
+	for rep := 0; rep < repetitions; rep++ {
+		freqs, impData, err := Simulate("R(CR)", params, SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 5,
+			Noise: GAUSSIAN, NoiseLevel: 0.01, Rand: rand.New(rand.NewSource(int64(rep + 1)))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewSolver("R(CR)", freqs, impData)
+		s.SmartMode = "eis"
+		s.InitValues = []float64{5, 1e-6, 50}
+		s.Seed = int64(rep + 1)
+		s.Diagnostics.Disabled = true
+		res := s.Solve(1e-9, 10)
+		if res.Status != OK {
+			t.Fatalf("repetition %d: fit status %s", rep, res.Status)
+		}
+
+		bs := Bootstrap(s, res, samples)
+		if bs.Samples < samples*9/10 {
+			t.Fatalf("repetition %d: %d of %d refits succeeded", rep, bs.Samples, samples)
+		}
+		for i, p := range params {
+			if bs.CI95[i][0] <= p && p <= bs.CI95[i][1] {
+				covered[i]++
+			}
+		}
+	}
+	for i, n := range covered {
+		if n < repetitions*9/10 {
+			t.Errorf("parameter %d within its 95%% interval in %d of %d repetitions", i, n, repetitions)
+		}
+	}
+}
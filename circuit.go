@@ -1,113 +1,491 @@
 package goimpcore
 
 import (
+	"fmt"
 	"math"
 	"math/cmplx"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-type mode int
+// Mode is how impedances combine, summed in SERIES or as reciprocals of the
+// summed admittances in PARALLEL
+type Mode int
 
 const (
-	SERIES mode = iota
+	SERIES Mode = iota
 	PARALLEL
 )
 
+// CircuitImpedance calculates the impedance of the circuit described by code at
+// every frequency. Callers evaluating the same circuit repeatedly should compile
+// it once with CompileCircuit.
 func CircuitImpedance(code string, freqs []float64, values []float64) [][2]float64 {
-	var res [][2]float64
-	for _, freq := range freqs {
-		var (
-			mode           = SERIES
-			stack          []complex128
-			fromStack, tmp complex128 = 0, 0
-			i              uint       = 0
-			w                         = 2 * math.Pi * freq
-		)
-		for _, char := range code {
-			switch char {
-			case 40: // (
-				stack = append(stack, tmp)
-				tmp = 0
-				changeMode(&mode)
-				continue
-			case 41: // )
-				if stack == nil {
-					panic("circuit: nil slice")
-				}
-				fromStack = stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-				changeMode(&mode)
-				tmp = sum(tmp, fromStack, mode)
-				continue
-			case 114: // R
-				tmp = sum(tmp, complex(values[i], 0), mode)
-			case 99: // C
-				tmp = sum(tmp, complex(1, 0)/(complex(0, 1)*complex(w, 0)*complex(values[i], 0)), mode)
-			case 108: // L
-				tmp = sum(tmp, complex(0, 1)*complex(w, 0)*complex(values[i], 0), mode)
-			case 119: // W (Infinite Warburg)
-				tmp = sum(tmp, complex(1, 0)/(cmplx.Sqrt(complex(0, 1)*complex(w, 0))*complex(values[i], 0)), mode)
-			case 113: // Q (CPE)
-				tmp = sum(tmp, complex(1, 0)/(cmplx.Pow(complex(0, 1)*complex(w, 0), complex(values[i+1], 0))*complex(values[i], 0)), mode)
-				i++
-			case 111: // O (FLW Finite Length Warburg) first parameter Y0, second B
-				tanh := cmplx.Tanh(cmplx.Sqrt(complex(0, 1)*complex(w, 0)) * complex(values[i+1], 0))
-				if cmplx.IsNaN(tanh) {
-					tanh = complex(1, 0)
-				}
-				tmp = sum(tmp, tanh/(cmplx.Sqrt(complex(0, 1)*complex(w, 0))*complex(values[i], 0)), mode)
-				i++
-			case 116: // T (FSW Finite Space Warburg) first parameter Y0, second B
-				coth := 1 / (cmplx.Tanh(cmplx.Sqrt(complex(0, 1)*complex(w, 0)) * complex(values[i+1], 0)))
-				tmp = sum(tmp, coth/(cmplx.Sqrt(complex(0, 1)*complex(w, 0))*complex(values[i], 0)), mode)
-				i++
-			case 103: // G (Gerischer) first parameter Y0, second k
-				tmp = sum(tmp, (cmplx.Pow(complex(values[i+1], 0)+(complex(0, 1)*complex(w, 0)), complex(-0.5, 0)))/complex(values[i], 0), mode)
-				i++
-			case 102: // F (Fractal Gerischer) first parameter Y0, second k, third a
-				tmp = sum(tmp, (cmplx.Pow(complex(values[i+1], 0)+(complex(0, 1)*complex(w, 0)), complex(-values[i+2], 0)))/complex(values[i], 0), mode)
-				i++
-				i++
+	c, err := CompileCircuit(code)
+	if err != nil {
+		panic("circuit: " + err.Error())
+	}
+	return c.Impedance(freqs, values)
+}
+
+// CircuitImpedanceWithOmegas is CircuitImpedance at the angular frequencies
+// omegas, see PrecomputeOmegas
+func CircuitImpedanceWithOmegas(code string, omegas []float64, values []float64) [][2]float64 {
+	c, err := CompileCircuit(code)
+	if err != nil {
+		panic("circuit: " + err.Error())
+	}
+	return c.ImpedanceWithOmegas(omegas, values)
+}
+
+// PrecomputeOmegas returns the angular frequency 2*pi*f of every frequency, for
+// repeated evaluations at the same frequencies
+func PrecomputeOmegas(freqs []float64) []float64 {
+	omegas := make([]float64, len(freqs))
+	for i, freq := range freqs {
+		omegas[i] = 2 * math.Pi * freq
+	}
+	return omegas
+}
+
+// CircuitImpedanceParallel is CircuitImpedance spread over up to workers goroutines
+func CircuitImpedanceParallel(code string, freqs []float64, values []float64, workers int) [][2]float64 {
+	c, err := CompileCircuit(code)
+	if err != nil {
+		panic("circuit: " + err.Error())
+	}
+	return c.ImpedanceParallel(freqs, values, workers)
+}
+
+type opKind uint8
+
+const (
+	opPush    opKind = iota // ( save the running impedance and start a new branch
+	opPop                   // ) combine the branch with the saved impedance
+	opElement               // combine an element with the running impedance
+)
+
+type op struct {
+	kind    opKind
+	mode    Mode
+	element rune // element code for opElement
+	param   int  // index of the first element parameter
+	pos     int  // byte offset in the code
+}
+
+// CompiledCircuit is a parsed Boukamp circuit description code, evaluated
+// without re-parsing the code for every frequency
+type CompiledCircuit struct {
+	code     string
+	ops      []op
+	params   int
+	maxDepth int
+}
+
+// elementParams is the number of parameters of each element code
+var elementParams = map[rune]int{
+	114: 1, // R
+	99:  1, // C
+	108: 1, // L
+	119: 1, // W
+	113: 2, // Q
+	111: 2, // O
+	116: 2, // T
+	103: 2, // G
+	112: 2, // P
+	102: 3, // F
+}
+
+// NodeType is the kind of a CircuitNode
+type NodeType int
+
+const (
+	NodeElement  NodeType = iota // a single element
+	NodeSeries                   // elements and groups summed in series
+	NodeParallel                 // elements and groups combined in parallel
+)
+
+// CircuitNode is a node of the topology of a circuit description code. The
+// root is a NodeSeries holding the top level, every bracketed group is a node
+// of the other type than its parent: "R(Q(RC))" is series[R, parallel[Q,
+// series[R, C]]], printed without brackets around the root.
+type CircuitNode struct {
+	Type     NodeType
+	Element  rune           // lowercase element code of a NodeElement, e.g. 'q'
+	Children []*CircuitNode // elements and groups of a NodeSeries or NodeParallel, in code order
+	Mode     Mode           // how the node combines with its siblings, the mode of its parent
+
+	pos, end int // byte offsets of the node in the parsed code, end exclusive
+}
+
+// ParseError is a malformed circuit description code
+type ParseError struct {
+	Code string // the lowercased code
+	Pos  int    // byte offset of the offending character
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d in %q", e.Msg, e.Pos, e.Code)
+}
+
+// ParseCircuit parses a circuit description code, e.g. "R(QR)", into its
+// topology. It is the parser behind every function taking a circuit code.
+func ParseCircuit(code string) (*CircuitNode, error) {
+	p := circuitParser{code: strings.ToLower(code)}
+	root := &CircuitNode{Type: NodeSeries, Mode: SERIES}
+	if err := p.parseChildren(root); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.code) {
+		return nil, &ParseError{Code: p.code, Pos: p.pos, Msg: "unbalanced ')'"}
+	}
+	root.end = len(p.code)
+	return root, nil
+}
+
+// circuitParser is a recursive descent parser of circuit description codes
+type circuitParser struct {
+	code string
+	pos  int
+}
+
+// parseChildren appends the elements and groups following p.pos to parent,
+// up to the ')' closing parent or the end of the code
+func (p *circuitParser) parseChildren(parent *CircuitNode) error {
+	childMode, groupType := SERIES, NodeParallel
+	if parent.Type == NodeParallel {
+		childMode, groupType = PARALLEL, NodeSeries
+	}
+
+	for p.pos < len(p.code) {
+		char, size := utf8.DecodeRuneInString(p.code[p.pos:])
+		switch char {
+		case '(':
+			group := &CircuitNode{Type: groupType, Mode: childMode, pos: p.pos}
+			p.pos += size
+			if err := p.parseChildren(group); err != nil {
+				return err
 			}
-			i++
+			if p.pos >= len(p.code) {
+				return &ParseError{Code: p.code, Pos: group.pos, Msg: "unclosed '('"}
+			}
+			p.pos++ // )
+			group.end = p.pos
+			parent.Children = append(parent.Children, group)
+		case ')':
+			return nil
+		case ' ', '\t':
+			p.pos += size
+		default:
+			if _, ok := elementParams[char]; !ok {
+				return &ParseError{Code: p.code, Pos: p.pos, Msg: fmt.Sprintf("unknown element %q", char)}
+			}
+			parent.Children = append(parent.Children, &CircuitNode{Type: NodeElement, Element: char, Mode: childMode, pos: p.pos, end: p.pos + size})
+			p.pos += size
+		}
+	}
+	return nil
+}
+
+// String returns the circuit description code of the subtree of n, with
+// uppercase elements. Parsing it gives back the same topology.
+func (n *CircuitNode) String() string {
+	var b strings.Builder
+	n.writeCode(&b)
+	return b.String()
+}
+
+// writeCode writes the code of n to b. A series node combined in series can
+// only be the root, the one node without brackets.
+func (n *CircuitNode) writeCode(b *strings.Builder) {
+	if n.Type == NodeElement {
+		b.WriteRune(unicode.ToUpper(n.Element))
+		return
+	}
+	root := n.Type == NodeSeries && n.Mode == SERIES
+	if !root {
+		b.WriteByte('(')
+	}
+	for _, child := range n.Children {
+		child.writeCode(b)
+	}
+	if !root {
+		b.WriteByte(')')
+	}
+}
+
+// Elements returns the element codes of the subtree of n in code order
+func (n *CircuitNode) Elements() []rune {
+	if n.Type == NodeElement {
+		return []rune{n.Element}
+	}
+	var elements []rune
+	for _, child := range n.Children {
+		elements = append(elements, child.Elements()...)
+	}
+	return elements
+}
+
+// circuitElements returns the element codes of code in code order, nil when
+// code does not parse
+func circuitElements(code string) []rune {
+	root, err := ParseCircuit(code)
+	if err != nil {
+		return nil
+	}
+	return root.Elements()
+}
+
+// CompileCircuit parses a circuit description code, e.g. "R(QR)"
+func CompileCircuit(code string) (*CompiledCircuit, error) {
+	root, err := ParseCircuit(code)
+	if err != nil {
+		return nil, err
+	}
+	c := &CompiledCircuit{code: strings.ToLower(code)}
+	c.compile(root, 0)
+	return c, nil
+}
+
+// compile appends the ops of the children of n, nested depth groups deep
+func (c *CompiledCircuit) compile(n *CircuitNode, depth int) {
+	for _, child := range n.Children {
+		if child.Type == NodeElement {
+			c.ops = append(c.ops, op{kind: opElement, mode: child.Mode, element: child.Element, param: c.params, pos: child.pos})
+			c.params += elementParams[child.Element]
+			continue
 		}
+		c.ops = append(c.ops, op{kind: opPush, mode: child.Mode, pos: child.pos})
+		if depth+1 > c.maxDepth {
+			c.maxDepth = depth + 1
+		}
+		c.compile(child, depth+1)
+		c.ops = append(c.ops, op{kind: opPop, mode: child.Mode, pos: child.end - 1})
+	}
+}
+
+// ValidateCircuit reports whether code is a valid circuit description code
+func ValidateCircuit(code string) error {
+	_, err := ParseCircuit(code)
+	return err
+}
+
+// Code returns the normalized circuit description code
+func (c *CompiledCircuit) Code() string {
+	return c.code
+}
+
+// NumParams returns the number of parameter values the circuit needs
+func (c *CompiledCircuit) NumParams() int {
+	return c.params
+}
+
+// Impedance calculates the impedance of the circuit at every frequency
+func (c *CompiledCircuit) Impedance(freqs []float64, values []float64) [][2]float64 {
+	if len(values) < c.params {
+		panic(fmt.Sprintf("circuit: %s needs %d values, got %d", c.code, c.params, len(values)))
+	}
+
+	res := make([][2]float64, len(freqs))
+	c.impedanceInto(res, freqs, values)
+	return res
+}
+
+// ImpedanceWithOmegas calculates the impedance of the circuit at every angular
+// frequency, see PrecomputeOmegas
+func (c *CompiledCircuit) ImpedanceWithOmegas(omegas []float64, values []float64) [][2]float64 {
+	if len(values) < c.params {
+		panic(fmt.Sprintf("circuit: %s needs %d values, got %d", c.code, c.params, len(values)))
+	}
+
+	res := make([][2]float64, len(omegas))
+	c.impedanceOmegasInto(res, omegas, values)
+	return res
+}
 
-		tmpSlc := [2]float64{real(tmp), imag(tmp)}
-		res = append(res, tmpSlc)
+// ImpedanceParallel calculates the impedance like Impedance, splitting the
+// frequencies into contiguous chunks evaluated by up to workers goroutines
+func (c *CompiledCircuit) ImpedanceParallel(freqs []float64, values []float64, workers int) [][2]float64 {
+	if len(values) < c.params {
+		panic(fmt.Sprintf("circuit: %s needs %d values, got %d", c.code, c.params, len(values)))
 	}
+
+	res := make([][2]float64, len(freqs))
+	c.impedanceParallelInto(res, freqs, values, workers)
 	return res
 }
 
+// impedanceParallelInto is impedanceInto split across up to workers goroutines
+func (c *CompiledCircuit) impedanceParallelInto(res [][2]float64, freqs []float64, values []float64, workers int) {
+	splitInto(res, freqs, values, workers, c.impedanceInto)
+}
+
+// impedanceOmegasParallelInto is impedanceOmegasInto split across up to
+// workers goroutines
+func (c *CompiledCircuit) impedanceOmegasParallelInto(res [][2]float64, omegas []float64, values []float64, workers int) {
+	splitInto(res, omegas, values, workers, c.impedanceOmegasInto)
+}
+
+// splitInto runs into over contiguous chunks of xs on up to workers goroutines
+func splitInto(res [][2]float64, xs []float64, values []float64, workers int, into func(res [][2]float64, xs []float64, values []float64)) {
+	if workers > len(xs) {
+		workers = len(xs)
+	}
+	if workers <= 1 {
+		into(res, xs, values)
+		return
+	}
+
+	chunk := (len(xs) + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(xs); start += chunk {
+		end := start + chunk
+		if end > len(xs) {
+			end = len(xs)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			into(res[start:end], xs[start:end], values)
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// impedanceInto writes the impedance at every frequency into res
+func (c *CompiledCircuit) impedanceInto(res [][2]float64, freqs []float64, values []float64) {
+	// Circuits rarely nest deeper than this, keep the stack off the heap
+	var stackBuf [8]complex128
+	for f, freq := range freqs {
+		res[f] = c.impedanceAt(2*math.Pi*freq, values, stackBuf[:0])
+	}
+}
+
+// impedanceOmegasInto writes the impedance at every angular frequency into res
+func (c *CompiledCircuit) impedanceOmegasInto(res [][2]float64, omegas []float64, values []float64) {
+	var stackBuf [8]complex128
+	for f, w := range omegas {
+		res[f] = c.impedanceAt(w, values, stackBuf[:0])
+	}
+}
+
+// impedanceAt returns the impedance at the angular frequency w, stack is the
+// empty scratch space of the branches
+func (c *CompiledCircuit) impedanceAt(w float64, values []float64, stack []complex128) [2]float64 {
+	var tmp complex128
+	for _, o := range c.ops {
+		switch o.kind {
+		case opPush:
+			stack = append(stack, tmp)
+			tmp = 0
+		case opPop:
+			fromStack := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			tmp = sum(tmp, fromStack, o.mode)
+		case opElement:
+			tmp = sum(tmp, elementImpedance(o.element, w, values[o.param:]), o.mode)
+		}
+	}
+	return [2]float64{real(tmp), imag(tmp)}
+}
+
+// elementImpedance returns the impedance of a single element at angular frequency w,
+// p starts at the element's first parameter
+func elementImpedance(element rune, w float64, p []float64) complex128 {
+	jw := complex(0, w)
+	switch element {
+	case 114: // R
+		return complex(p[0], 0)
+	case 99: // C
+		return complex(1, 0) / (jw * complex(p[0], 0))
+	case 108: // L
+		return jw * complex(p[0], 0)
+	case 119: // W (Infinite Warburg) parameter Y0
+		return warburg(w, p[0])
+	case 113: // Q (CPE)
+		return complex(1, 0) / (cmplx.Pow(jw, complex(p[1], 0)) * complex(p[0], 0))
+	case 111: // O (FLW Finite Length Warburg) first parameter Y0, second B
+		return tanh(cmplx.Sqrt(jw)*complex(p[1], 0)) / (cmplx.Sqrt(jw) * complex(p[0], 0))
+	case 116: // T (FSW Finite Space Warburg) first parameter Y0, second B
+		return coth(cmplx.Sqrt(jw)*complex(p[1], 0)) / (cmplx.Sqrt(jw) * complex(p[0], 0))
+	case 103: // G (Gerischer) first parameter Y0, second k
+		return cmplx.Pow(complex(p[1], 0)+jw, complex(-0.5, 0)) / complex(p[0], 0)
+	case 112: // P (De Levie porous electrode) first parameter Ri, second Yi, length normalized to 1
+		// De Levie (1963): a pore of length l, ionic resistance Ri and wall
+		// admittance y per unit length has Z = sqrt(Ri/y) * coth(l*sqrt(Ri*y)).
+		// The wall is capacitive, y = jw*Yi with Yi the specific capacitance,
+		// and l = 1, so Z = sqrt(Ri/(jwYi)) * coth(sqrt(Ri*jwYi)). It tends to
+		// sqrt(Ri/(jwYi)) at high frequency and Ri/3 + 1/(jwYi) at low.
+		jwY := jw * complex(p[1], 0)
+		return cmplx.Sqrt(complex(p[0], 0)/jwY) * coth(cmplx.Sqrt(complex(p[0], 0)*jwY))
+	case 102: // F (Fractal Gerischer) first parameter Y0, second k, third a
+		return cmplx.Pow(complex(p[1], 0)+jw, complex(-p[2], 0)) / complex(p[0], 0)
+	}
+	return 0
+}
+
+// warburgMinOmega is the angular frequency below which the infinite Warburg
+// impedance, unbounded as w goes to 0, is returned as a very large impedance
+const warburgMinOmega = 1e-300
+
+// warburg returns the impedance of the infinite Warburg element of admittance
+// y0, Z = 1/(y0·sqrt(jw)) as defined by Boukamp, the parameter being the
+// admittance Y0 in S·s^0.5 and not the Warburg coefficient sigma = 1/(y0·√2).
+// Its real part equals minus its imaginary part, the 45° line of the Nyquist
+// plot of -Im(Z) against Re(Z). At w = 0 sqrt(jw) vanishes and the division
+// would give infinity and NaN.
+func warburg(w, y0 float64) complex128 {
+	if !(w >= warburgMinOmega) {
+		return complex(math.MaxFloat64/2, -math.MaxFloat64/2)
+	}
+	return complex(1, 0) / (cmplx.Sqrt(complex(0, w)) * complex(y0, 0))
+}
+
+// CircuitImpedanceNoisy calculates the impedance and adds uniform noise of
+// noiseLevel to noisyPoints random points, and 1% noise everywhere when
+// littleNoise is set. The noise is seeded from the clock, use
+// CircuitImpedanceNoisySeeded or Simulate for reproducible data.
 func CircuitImpedanceNoisy(code string, freqs []float64, values []float64, noisyPoints uint, noiseLevel float64, littleNoise bool) [][2]float64 {
-	rand.Seed(time.Now().Unix())
+	return CircuitImpedanceNoisySeeded(code, freqs, values, noisyPoints, noiseLevel, littleNoise, time.Now().UnixNano())
+}
+
+// CircuitImpedanceNoisySeeded is CircuitImpedanceNoisy with the noise drawn
+// from a source seeded with seed, the same seed gives the same spectrum
+func CircuitImpedanceNoisySeeded(code string, freqs []float64, values []float64, noisyPoints uint, noiseLevel float64, littleNoise bool, seed int64) [][2]float64 {
+	return CircuitImpedanceNoisyRand(code, freqs, values, noisyPoints, noiseLevel, littleNoise, UNIFORM, rand.New(rand.NewSource(seed)))
+}
+
+// CircuitImpedanceNoisyRand is CircuitImpedanceNoisy with a selectable noise
+// distribution and random source. For GAUSSIAN noise the levels are relative
+// standard deviations. A nil rnd is seeded from the global random source.
+func CircuitImpedanceNoisyRand(code string, freqs []float64, values []float64, noisyPoints uint, noiseLevel float64, littleNoise bool, dist NoiseDistribution, rnd *rand.Rand) [][2]float64 {
+	if rnd == nil {
+		rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
 	c := CircuitImpedance(code, freqs, values)
 
 	if littleNoise {
-		for i, v := range c {
-			noise(&v, 0.01)
-			c[i] = v
+		for i := range c {
+			addNoise(&c[i], 0.01, dist, rnd)
 		}
 	}
 
 	// set random noisy points
 	for i := uint(0); i < noisyPoints; i++ {
-		index := rand.Intn(len(c))
-		noise(&c[index], noiseLevel)
+		index := rnd.Intn(len(c))
+		addNoise(&c[index], noiseLevel, dist, rnd)
 	}
 
 	return c
 }
 
-func changeMode(mode *mode) {
-	if *mode == SERIES {
-		*mode = PARALLEL
-	} else {
-		*mode = SERIES
-	}
-}
-
-func sum(z1 complex128, z2 complex128, mode mode) complex128 {
+func sum(z1 complex128, z2 complex128, mode Mode) complex128 {
 	var res complex128 = 0
 	if mode == SERIES {
 		res = z1 + z2
@@ -130,15 +508,40 @@ func sum(z1 complex128, z2 complex128, mode mode) complex128 {
 	return res
 }
 
-func noise(v *[2]float64, nl float64) {
-	zrMaxNoise := math.Abs(v[0]) * nl
-	ziMaxNoise := math.Abs(v[1]) * nl
+// cothLimit is the argument real part above which coth(z) is 1 to double precision
+const cothLimit = 20
+
+// tanhTiny is the magnitude below which tanh(z) is treated as zero by coth
+const tanhTiny = 1e-300
 
-	zrMin := v[0] - zrMaxNoise
-	zrMax := v[0] + zrMaxNoise
-	ziMin := v[1] - ziMaxNoise
-	ziMax := v[1] + ziMaxNoise
+// coth returns the hyperbolic cotangent guarded against overflow for large
+// arguments. Where tanh(z) vanishes it returns the large argument limit as
+// well, so the T element falls back to the infinite Warburg impedance instead
+// of dividing by zero.
+func coth(z complex128) complex128 {
+	if math.Abs(real(z)) > cothLimit {
+		return complex(math.Copysign(1.0, real(z)), 0)
+	}
+	t := cmplx.Tanh(z)
+	if cmplx.Abs(t) < tanhTiny {
+		return complex(math.Copysign(1.0, real(z)), 0)
+	}
+	res := 1 / t
+	if cmplx.IsNaN(res) || cmplx.IsInf(res) {
+		return complex(math.Copysign(1.0, real(z)), 0)
+	}
+	return res
+}
 
-	v[0] = rand.Float64()*(zrMax-zrMin) + zrMin
-	v[1] = rand.Float64()*(ziMax-ziMin) + ziMin
+// tanh returns the hyperbolic tangent guarded against overflow for large
+// arguments, where cmplx.Tanh can return NaN or infinity
+func tanh(z complex128) complex128 {
+	if math.Abs(real(z)) > cothLimit {
+		return complex(math.Copysign(1.0, real(z)), 0)
+	}
+	res := cmplx.Tanh(z)
+	if cmplx.IsNaN(res) || cmplx.IsInf(res) {
+		return complex(math.Copysign(1.0, real(z)), 0)
+	}
+	return res
 }
@@ -0,0 +1,379 @@
+package goimpcore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// impedanceAt returns the impedance of code with params at angular frequency w
+func impedanceAt(code string, w float64, params []float64) complex128 {
+	z := CircuitImpedance(code, []float64{w / (2 * math.Pi)}, params)[0]
+	return complex(z[0], z[1])
+}
+
+func TestDeLevieLimits(t *testing.T) {
+	const ri, yi = 100.0, 1e-3
+	tests := []struct {
+		name  string
+		w     float64
+		limit func(jw complex128) complex128
+	}{
+		{"high frequency", 1e4, func(jw complex128) complex128 { return cmplx.Sqrt(complex(ri, 0) / (jw * complex(yi, 0))) }},
+		{"low frequency", 1e-3, func(jw complex128) complex128 { return complex(ri/3, 0) + 1/(jw*complex(yi, 0)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := impedanceAt("p", tt.w, []float64{ri, yi})
+			want := tt.limit(complex(0, tt.w))
+			if rel := cmplx.Abs(got-want) / cmplx.Abs(want); rel > 0.01 {
+				t.Errorf("Z(%v rad/s) = %v, want %v within 1%%, off by %.2g", tt.w, got, want, rel)
+			}
+		})
+	}
+}
+
+// parallelCode and parallelParams are the circuit of the parallel evaluation
+// tests, with every kind of branch
+const parallelCode = "r(qr)(q(rw))"
+
+var parallelParams = []float64{10, 1e-5, 0.9, 100, 1e-4, 0.8, 50, 1e-2}
+
+// A large spectrum evaluated by any number of workers, also by many callers
+// at once, equals the serial evaluation
+func TestCircuitImpedanceParallel(t *testing.T) {
+	freqs, _ := LogFrequencies(1e-3, 1e6, 1000)
+	want := CircuitImpedance(parallelCode, freqs, parallelParams)
+	circuit, err := CompileCircuit(parallelCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, workers := range []int{0, 1, 2, 3, runtime.NumCPU(), len(freqs) + 1} {
+		for caller := 0; caller < 4; caller++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got := circuit.ImpedanceParallel(freqs, parallelParams, workers)
+				for i := range want {
+					if got[i] != want[i] {
+						t.Errorf("%d workers: point %d = %v, want %v", workers, i, got[i], want[i])
+						return
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// A solver evaluating large spectra concurrently gives the serial impedance
+func TestSolverConcurrency(t *testing.T) {
+	freqs, _ := LogFrequencies(1e-3, 1e6, 100)
+	if len(freqs) < ParallelThreshold {
+		t.Fatalf("%d frequencies, below the parallel threshold %d", len(freqs), ParallelThreshold)
+	}
+	s := NewSolver(parallelCode, freqs, CircuitImpedance(parallelCode, freqs, parallelParams))
+	s.Concurrency = 4
+	want := CircuitImpedance(parallelCode, freqs, parallelParams)
+	got := s.impedance(freqs, parallelParams)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("point %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func BenchmarkCircuitImpedanceParallel(b *testing.B) {
+	freqs, _ := LogFrequencies(1e-3, 1e6, 1000)
+	circuit, err := CompileCircuit(parallelCode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				circuit.ImpedanceParallel(freqs, parallelParams, workers)
+			}
+		})
+	}
+}
+
+// benchCode and benchParams are the nested circuit of the evaluation
+// benchmarks
+const benchCode = "r(q(r(qr)))"
+
+var benchParams = []float64{10, 1e-5, 0.9, 100, 1e-4, 0.8, 50}
+
+// benchFreqs returns n frequencies spaced evenly in log frequency from 1 mHz
+// to 1 MHz
+func benchFreqs(n int) []float64 {
+	freqs := make([]float64, n)
+	for i := range freqs {
+		freqs[i] = math.Pow(10, -3+9*float64(i)/float64(n-1))
+	}
+	return freqs
+}
+
+// Parsing the circuit at every frequency, as before circuits were compiled,
+// and on every evaluation against compiling it once
+func BenchmarkCompiledCircuit(b *testing.B) {
+	freqs := benchFreqs(100)
+	b.Run("parse-per-frequency", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := range freqs {
+				CircuitImpedance(benchCode, freqs[j:j+1], benchParams)
+			}
+		}
+	})
+	b.Run("parse-per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CircuitImpedance(benchCode, freqs, benchParams)
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		circuit, err := CompileCircuit(benchCode)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			circuit.Impedance(freqs, benchParams)
+		}
+	})
+}
+
+// omegaEvaluations is the number of evaluations of one omega benchmark
+// operation, as in a fit
+const omegaEvaluations = 1000
+
+// Evaluating the compiled circuit at angular frequencies computed once
+func BenchmarkCircuitImpedanceWithOmegas(b *testing.B) {
+	circuit, err := CompileCircuit(benchCode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	omegas := PrecomputeOmegas(benchFreqs(200))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < omegaEvaluations; j++ {
+			circuit.ImpedanceWithOmegas(omegas, benchParams)
+		}
+	}
+}
+
+// Evaluating the compiled circuit at frequencies, computing 2πf every time
+func BenchmarkCircuitImpedanceWithoutOmegas(b *testing.B) {
+	circuit, err := CompileCircuit(benchCode)
+	if err != nil {
+		b.Fatal(err)
+	}
+	freqs := benchFreqs(200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < omegaEvaluations; j++ {
+			circuit.Impedance(freqs, benchParams)
+		}
+	}
+}
+
+// Arguments of tanh with a large real part, where cmplx.Tanh gives NaN, leave
+// the O and T elements finite at their limit 1/(Y0 sqrt(jw))
+func TestFiniteWarburgLargeArgument(t *testing.T) {
+	const y0 = 1e-3
+	tests := []struct {
+		code string
+		w, b float64
+	}{
+		{"o", 2 * math.Pi * 1e6, 1e3},
+		{"o", 2 * math.Pi * 1e4, 1e5},
+		{"t", 2 * math.Pi * 1e6, 1e3},
+		{"t", 2 * math.Pi * 1e4, 1e5},
+		{"ro", 2 * math.Pi * 1e6, 1e3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s w=%g B=%g", tt.code, tt.w, tt.b), func(t *testing.T) {
+			jw := complex(0, tt.w)
+			if !cmplx.IsNaN(cmplx.Tanh(cmplx.Sqrt(jw) * complex(tt.b, 0))) {
+				t.Skip("cmplx.Tanh is finite for this argument")
+			}
+			params := []float64{y0, tt.b}
+			var r complex128
+			if tt.code == "ro" {
+				params, r = []float64{10, y0, tt.b}, 10
+			}
+			got := impedanceAt(tt.code, tt.w, params)
+			if cmplx.IsNaN(got) || cmplx.IsInf(got) {
+				t.Fatalf("Z = %v", got)
+			}
+			want := r + 1/(cmplx.Sqrt(jw)*complex(y0, 0))
+			if cmplx.Abs(got-want) > 1e-12*cmplx.Abs(want) {
+				t.Errorf("Z = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// The guarded tanh and coth stay finite where cmplx.Tanh overflows or
+// vanishes
+func TestTanhCothEdgeCases(t *testing.T) {
+	tests := []struct {
+		z          complex128
+		tanh, coth complex128
+	}{
+		{complex(800, 800), 1, 1},
+		{complex(-800, 800), -1, -1},
+		{complex(25, 3), 1, 1},
+		{0, 0, 1},                  // coth has a pole, the large argument limit is returned
+		{complex(1e-310, 0), 0, 1}, // tanh below tanhTiny
+	}
+	for _, tt := range tests {
+		gotTanh, gotCoth := tanh(tt.z), coth(tt.z)
+		if cmplx.Abs(gotTanh-tt.tanh) > 1e-12 || cmplx.Abs(gotCoth-tt.coth) > 1e-12 {
+			t.Errorf("z = %v: tanh %v, coth %v, want %v and %v", tt.z, gotTanh, gotCoth, tt.tanh, tt.coth)
+		}
+	}
+	// Near the poles of tanh on the imaginary axis coth goes to 0
+	if c := coth(complex(0, math.Pi/2)); cmplx.IsNaN(c) || cmplx.Abs(c) > 1e-12 {
+		t.Errorf("coth(iπ/2) = %v, want about 0", c)
+	}
+}
+
+// The O and T elements are finite over 24 decades of frequency and reach
+// their analytical limits at both ends
+func TestFiniteWarburgLimits(t *testing.T) {
+	const y0 = 1e-3
+	for _, b := range []float64{1e-3, 1, 1e3} {
+		for e := -12; e <= 12; e++ {
+			w := math.Pow(10, float64(e))
+			jw := complex(0, w)
+			warburg := 1 / (cmplx.Sqrt(jw) * complex(y0, 0))
+			// tanh(z) ≈ z and coth(z) ≈ 1/z for |z| ≪ 1, both 1 for |z| ≫ 1
+			o, t0 := impedanceAt("o", w, []float64{y0, b}), impedanceAt("t", w, []float64{y0, b})
+			for _, z := range []complex128{o, t0} {
+				if cmplx.IsNaN(z) || cmplx.IsInf(z) {
+					t.Fatalf("B = %v, w = %v: Z = %v", b, w, z)
+				}
+			}
+			arg := math.Sqrt(w) * b
+			var wantO, wantT complex128
+			switch {
+			case arg < 1e-3:
+				wantO, wantT = complex(b/y0, 0), 1/(jw*complex(b*y0, 0))
+			case arg > 1e2:
+				wantO, wantT = warburg, warburg
+			default:
+				continue
+			}
+			if cmplx.Abs(o-wantO) > 0.01*cmplx.Abs(wantO) {
+				t.Errorf("O, B = %v, w = %v: Z = %v, want %v within 1%%", b, w, o, wantO)
+			}
+			if cmplx.Abs(t0-wantT) > 0.01*cmplx.Abs(wantT) {
+				t.Errorf("T, B = %v, w = %v: Z = %v, want %v within 1%%", b, w, t0, wantT)
+			}
+		}
+	}
+}
+
+// The infinite Warburg impedance lies on the 45° line, -Im(Z) = Re(Z) > 0
+// within 1e-10, with |Z| = 1/(Y0·sqrt(w)) at 100 frequencies from 1 µHz to
+// 1 MHz, and is very large but finite at w = 0
+func TestWarburg45Degrees(t *testing.T) {
+	const y0, n = 1e-3, 100
+	freqs := make([]float64, n)
+	for i := range freqs {
+		freqs[i] = math.Pow(10, -6+12*float64(i)/(n-1))
+	}
+	for i, z := range CircuitImpedance("w", freqs, []float64{y0}) {
+		if !(z[0] > 0) || math.Abs(z[0]+z[1]) > 1e-10*z[0] {
+			t.Errorf("f = %v Hz: Z = %v, want -Im(Z) = Re(Z) > 0", freqs[i], z)
+		}
+		want := 1 / (y0 * math.Sqrt(2*math.Pi*freqs[i]))
+		if m := math.Hypot(z[0], z[1]); math.Abs(m-want) > 1e-10*want {
+			t.Errorf("f = %v Hz: |Z| = %v, want %v", freqs[i], m, want)
+		}
+	}
+
+	for _, w := range []float64{0, 1e-310} {
+		z := impedanceAt("w", w, []float64{y0})
+		if cmplx.IsNaN(z) || cmplx.IsInf(z) || real(z) < 1e300 || imag(z) != -real(z) {
+			t.Errorf("w = %v: Z = %v, want a very large finite impedance at 45°", w, z)
+		}
+	}
+}
+
+// sameTopology reports whether the trees a and b have the same nodes,
+// regardless of where they were parsed from
+func sameTopology(a, b *CircuitNode) bool {
+	if a.Type != b.Type || a.Element != b.Element || a.Mode != b.Mode || len(a.Children) != len(b.Children) {
+		return false
+	}
+	for i := range a.Children {
+		if !sameTopology(a.Children[i], b.Children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Printing a parsed circuit and parsing it again gives the same topology, and
+// the printed code is the original in upper case
+func TestParseCircuitRoundTrip(t *testing.T) {
+	codes := []string{
+		"R",
+		"RC",
+		"R(CR)",
+		"R(QR)",
+		"r(qr)(qr)",
+		"R(Q(R(QR)))",
+		"R(C(RW))",
+		"L R (Q R) (C (R O))",
+		"R(QR(T)(G))F",
+		"(R(P(R(L(RC)))))",
+	}
+	for _, code := range codes {
+		t.Run(code, func(t *testing.T) {
+			node, err := ParseCircuit(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			printed := node.String()
+			if want := strings.ToUpper(strings.ReplaceAll(code, " ", "")); printed != want {
+				t.Errorf("printed %s, want %s", printed, want)
+			}
+			again, err := ParseCircuit(printed)
+			if err != nil {
+				t.Fatalf("parsing %s: %v", printed, err)
+			}
+			if !sameTopology(node, again) {
+				t.Errorf("%s parses to another topology than %s", printed, code)
+			}
+		})
+	}
+}
+
+// Malformed codes fail with a ParseError at the offending character
+func TestParseCircuitErrors(t *testing.T) {
+	tests := []struct {
+		code string
+		pos  int
+	}{
+		{"R(QR", 1},
+		{"R)Q", 1},
+		{"R(QX)", 3},
+		{"((R)", 0},
+	}
+	for _, tt := range tests {
+		_, err := ParseCircuit(tt.code)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Pos != tt.pos {
+			t.Errorf("%s: error %v, want a ParseError at %d", tt.code, err, tt.pos)
+		}
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/kacperjurak/goimpcore/internal/processing"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/formalism"
+	"github.com/kacperjurak/goimpcore/pkg/quasirandom"
 	"github.com/kacperjurak/goimpcore/pkg/server"
 )
 
@@ -19,14 +23,42 @@ func main() {
 	// Create EIS processor
 	processor := processing.NewEISProcessor()
 
+	workers := cfg.Workers
+	if workers == 0 {
+		workers = int(cfg.Threads)
+	}
+
 	// Create server configuration
 	serverConfig := &config.ServerConfig{
-		Port:            "8080",
-		WorkerCount:     int(cfg.Threads),
-		WebhookURL:      "http://webplot:3001/webhook",
-		EnableMetrics:   true,
-		EnableProfiling: cfg.EnableProfiling,
-		ProfilingPort:   "6060",
+		Port:                      strconv.Itoa(cfg.Port),
+		WorkerCount:               workers,
+		WebhookURL:                cfg.WebhookURL,
+		EnableMetrics:             cfg.Metrics,
+		EnableProfiling:           cfg.EnableProfiling,
+		ProfilingPort:             strconv.Itoa(cfg.ProfilingPort),
+		EnableProfilingOnMainPort: cfg.ProfileMainPort,
+		OTELEndpoint:              cfg.OTELEndpoint,
+		ShutdownTimeout:           cfg.ShutdownTimeout,
+		JobTimeout:                cfg.JobTimeout,
+		CORSAllowedOrigins:        cfg.CORSOrigins,
+		ResultTTL:                 cfg.ResultTTL,
+		ResultMaxEntries:          cfg.ResultMax,
+		QueueFile:                 cfg.QueueFile,
+		RateLimit:                 cfg.RateLimit,
+		RateBurst:                 cfg.RateBurst,
+		EnableDedup:               cfg.Dedup,
+		ReadyQueueWatermark:       cfg.ReadyWatermark,
+		LogFormat:                 cfg.LogFormat,
+		QuietRequests:             cfg.Quiet,
+		MaxRequestBodyBytes:       cfg.MaxBodyBytes,
+		MaxBatchSpectra:           cfg.MaxSpectra,
+		MaxFrequencyPoints:        cfg.MaxPoints,
+		WebhookMaxAttempts:        cfg.WebhookAttempts,
+		WebhookInitialBackoff:     cfg.WebhookBackoff,
+		WebhookConcurrency:        cfg.WebhookConcurrency,
+		WebhookDeadLetterFile:     cfg.DeadLetterFile,
+		WebhookSecret:             cfg.WebhookSecret,
+		WebhookCompression:        cfg.WebhookGzip,
 	}
 
 	// Create and start server
@@ -43,27 +75,135 @@ func main() {
 	if err := srv.Start(); err != nil {
 		log.Fatal("❌ Failed to start server:", err)
 	}
+
+	// The listener closed, the signal handler exits once the queued fits
+	// have drained
+	select {}
 }
 
-// parseFlags parses command line flags and returns configuration
+// parseFlags parses command line flags and returns configuration. The
+// port, webhook URL and webhook secret default to GOIMP_PORT,
+// GOIMP_WEBHOOK_URL and GOIMP_WEBHOOK_SECRET when set.
 func parseFlags() *config.Config {
 	cfg := config.DefaultConfig()
+	if err := cfg.ApplyEnv(); err != nil {
+		log.Fatal(err)
+	}
 
 	flag.StringVar(&cfg.Code, "R(QR)", cfg.Code, "Circuit code (e.g., R(RC))")
 	flag.StringVar(&cfg.File, "file", cfg.File, "Input file path")
 	flag.UintVar(&cfg.Threads, "threads", cfg.Threads, "Number of worker threads")
-	flag.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Suppress verbose output")
+	flag.IntVar(&cfg.Workers, "workers", cfg.Workers, "Number of fits run at once by the worker pool, -threads when 0")
+	flag.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Suppress verbose output, only failed requests are logged")
+	flag.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Request log format: text or json")
 	flag.BoolVar(&cfg.HTTPServer, "server", cfg.HTTPServer, "Start HTTP server")
+	flag.IntVar(&cfg.Port, "port", cfg.Port, "HTTP server port, 0 for a random free port (env "+config.EnvPort+")")
+	flag.IntVar(&cfg.WebhookAttempts, "webhook-attempts", cfg.WebhookAttempts, "Delivery attempts of a webhook failing with a network error or 5xx, 0 for 5")
+	flag.DurationVar(&cfg.WebhookBackoff, "webhook-backoff", cfg.WebhookBackoff, "Wait before the first webhook retry, doubled after each one, 0 for 500ms")
+	flag.IntVar(&cfg.WebhookConcurrency, "webhook-concurrency", cfg.WebhookConcurrency, "Webhooks sent at once, retries included, 0 for twice the workers")
+	flag.BoolVar(&cfg.WebhookGzip, "webhook-gzip", cfg.WebhookGzip, "Send webhooks of 1 KiB and more gzip compressed, uncompressed again when the receiver answers 415")
+	flag.StringVar(&cfg.DeadLetterFile, "dead-letter-file", cfg.DeadLetterFile, "Append webhooks undelivered after every attempt to this file as JSON lines")
+	// A Func flag, so that -h does not print a secret taken from the environment
+	flag.Func("webhook-secret", "Shared secret signing the webhooks with HMAC-SHA256, prefer env "+config.EnvWebhookSecret+" to keep it out of the process list", func(value string) error {
+		cfg.WebhookSecret = value
+		return nil
+	})
+	flag.IntVar(&cfg.ProfilingPort, "profiling-port", cfg.ProfilingPort, "Port of the pprof server started by -profile")
+	flag.StringVar(&cfg.WebhookURL, "webhook-url", cfg.WebhookURL, "Endpoint receiving the fit results (env "+config.EnvWebhookURL+")")
 	flag.BoolVar(&cfg.Benchmark, "benchmark", cfg.Benchmark, "Enable benchmark mode")
 	flag.BoolVar(&cfg.EnableProfiling, "profile", cfg.EnableProfiling, "Enable pprof profiling")
+	flag.BoolVar(&cfg.Metrics, "metrics", cfg.Metrics, "Serve Prometheus metrics on /metrics")
+	flag.BoolVar(&cfg.ProfileMainPort, "debug-main-port", cfg.ProfileMainPort, "Serve pprof under /debug/pprof/ on the main port instead of port 6060")
+	flag.StringVar(&cfg.OTELEndpoint, "otel-endpoint", cfg.OTELEndpoint, "OTLP/HTTP endpoint receiving traces, e.g. http://jaeger:4318, tracing is off when empty")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "How long a shutdown waits for queued fits before cancelling them, 0 for 30s")
+	flag.Func("cors-origins", "Comma separated origins allowed to call the API, e.g. https://lab.example.com, any origin when unset", func(value string) error {
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.CORSOrigins = append(cfg.CORSOrigins, origin)
+			}
+		}
+		return nil
+	})
+	flag.DurationVar(&cfg.SyncTimeout, "sync-timeout", cfg.SyncTimeout, "How long a synchronous fit (/eis-data/sync or ?sync=true) may run, 0 for 30s")
+	flag.IntVar(&cfg.LiveMaxFits, "ws-max-fits", cfg.LiveMaxFits, "Fits one /ws WebSocket connection may run at once, 0 for 4")
+	flag.DurationVar(&cfg.JobTimeout, "job-timeout", cfg.JobTimeout, "How long one fit may run in the worker pool before it is stopped as TIMEOUT, 0 for 5m, overridden by timeout_seconds of a request")
+	flag.DurationVar(&cfg.ResultTTL, "result-ttl", cfg.ResultTTL, "How long results stay retrievable under /results and /batches, 0 for 1h")
+	flag.IntVar(&cfg.ResultMax, "result-max", cfg.ResultMax, "Maximum number of stored results and batches, least recently used evicted first, 0 for 1000")
+	flag.StringVar(&cfg.TimingFile, "timing-file", cfg.TimingFile, "Append the timing of every batch to this CSV file, none when empty")
+	flag.StringVar(&cfg.QueueFile, "queue-file", cfg.QueueFile, "Keep accepted jobs and undelivered webhooks in this bbolt file and resume them after a restart, in memory only when empty")
+	flag.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "Requests per second accepted from one client IP, 0 for no limit")
+	flag.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "Requests one client IP may send at once under -rate-limit")
+	flag.BoolVar(&cfg.Dedup, "dedup", cfg.Dedup, "Answer identical EIS requests arriving within 1s of each other with one fit")
+	flag.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "Request body size limit in bytes, larger requests get 413, 0 for 10 MiB")
+	flag.IntVar(&cfg.MaxPoints, "max-points", cfg.MaxPoints, "Frequencies accepted per spectrum, 0 for 10000")
+	flag.IntVar(&cfg.MaxSpectra, "max-spectra", cfg.MaxSpectra, "Spectra accepted per batch, 0 for 1000")
+	flag.Float64Var(&cfg.ReadyWatermark, "ready-watermark", cfg.ReadyWatermark, "Fraction of the jobs queue above which /ready returns 503, 0 for 0.9")
+	flag.Float64Var(&cfg.DriftThreshold, "drift-threshold", cfg.DriftThreshold, "Warn when a parameter drifts more than this fraction over a batch (total variation / first value), 0 for 0.5")
 	flag.StringVar(&cfg.OptimMethod, "method", cfg.OptimMethod, "Optimization method")
+	flag.Float64Var(&cfg.FreqMin, "fmin", cfg.FreqMin, "Exclude frequencies below fmin (Hz) from the fit, 0 for no limit")
+	flag.Float64Var(&cfg.FreqMax, "fmax", cfg.FreqMax, "Exclude frequencies above fmax (Hz) from the fit, 0 for no limit")
+	flag.BoolVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Run Nelder-Mead as a parallel multi-start (same as -method parallel)")
+	flag.UintVar(&cfg.Starts, "starts", cfg.Starts, "Number of starting points for parallel multi-start")
+	flag.StringVar(&cfg.SobolTable, "sobol-table", cfg.SobolTable, "Joe-Kuo direction numbers file, e.g. new-joe-kuo-6.21201, for Sobol multi-starts of more than 37 parameters")
+	flag.BoolVar(&cfg.Robust, "robust", cfg.Robust, "Reject outliers (k*MAD of the weighted residuals) and refit")
+	flag.Float64Var(&cfg.RobustK, "robustk", cfg.RobustK, "Outlier threshold for -robust in robust standard deviations")
+	flag.UintVar(&cfg.RobustPasses, "robustpasses", cfg.RobustPasses, "Maximum number of outlier rejection refits for -robust")
+	flag.StringVar(&cfg.Weighting, "weighting", cfg.Weighting, "Weighting: modulus, unity, proportional or sigma (default sigma when the data has uncertainties, otherwise modulus)")
+	flag.StringVar(&cfg.Space, "space", cfg.Space, "Fit space: impedance or admittance (better conditioned for highly capacitive samples), results are reported as impedances")
+	flag.BoolVar(&cfg.LogScale, "logscale", cfg.LogScale, "Optimize capacitances and CPE/Warburg Y0 parameters in log space")
+	flag.Float64Var(&cfg.MaxCorrelation, "maxcorr", cfg.MaxCorrelation, "Warn when two fitted parameters are correlated above this absolute value")
+	flag.Float64Var(&cfg.Regularization, "regularization", cfg.Regularization, "Penalty pulling parameters towards their init values for ill-conditioned fits, e.g. 0.01")
+	flag.StringVar(&cfg.Formalism, "formalism", cfg.Formalism, "Output formalism: z (impedance), y (admittance), m (electric modulus)")
+	flag.Float64Var(&cfg.C0, "c0", cfg.C0, "Geometric capacitance C0 in Farads (required for -formalism m)")
+	flag.StringVar(&cfg.Criterion, "criterion", cfg.Criterion, "Selection criterion for -method all: chisq, aic or bic")
+	flag.BoolVar(&cfg.Sensitivity, "sensitivity", cfg.Sensitivity, "Report the sensitivity of the chi-square to each parameter in the webhooks")
 
 	flag.Parse()
 
+	if err := config.ValidatePort(cfg.Port); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidatePort(cfg.ProfilingPort); err != nil {
+		log.Fatalf("-profiling-port: %v", err)
+	}
+	if cfg.EnableProfiling && !cfg.ProfileMainPort && cfg.ProfilingPort != 0 && cfg.ProfilingPort == cfg.Port {
+		log.Fatalf("-profiling-port %d is the HTTP server port, use -debug-main-port to serve pprof there", cfg.ProfilingPort)
+	}
+	if cfg.Workers < 0 {
+		log.Fatalf("Invalid -workers %d", cfg.Workers)
+	}
+	if cfg.MaxBodyBytes < 0 || cfg.MaxPoints < 0 || cfg.MaxSpectra < 0 {
+		log.Fatalf("Invalid request limits -max-body-bytes %d -max-points %d -max-spectra %d", cfg.MaxBodyBytes, cfg.MaxPoints, cfg.MaxSpectra)
+	}
+	if cfg.LogFormat != config.LogFormatText && cfg.LogFormat != config.LogFormatJSON {
+		log.Fatalf("Unknown -log-format '%s', expected text or json", cfg.LogFormat)
+	}
+	if cfg.ReadyWatermark < 0 || cfg.ReadyWatermark > 1 {
+		log.Fatalf("Invalid -ready-watermark %v, expected a fraction between 0 and 1", cfg.ReadyWatermark)
+	}
+	if err := config.ValidateWebhookURL(cfg.WebhookURL); err != nil {
+		log.Fatal(err)
+	}
+	if !formalism.Valid(cfg.Formalism) {
+		log.Fatalf("Unknown formalism '%s', expected z, y or m", cfg.Formalism)
+	}
+	if cfg.Formalism == formalism.Modulus && cfg.C0 <= 0 {
+		log.Fatal("Electric modulus formalism requires a positive -c0")
+	}
+	if cfg.FreqMin < 0 || cfg.FreqMax < 0 || (cfg.FreqMax > 0 && cfg.FreqMin > cfg.FreqMax) {
+		log.Fatalf("Invalid frequency window -fmin %v -fmax %v", cfg.FreqMin, cfg.FreqMax)
+	}
+	if cfg.SobolTable != "" {
+		if err := quasirandom.LoadDirectionNumbersFile(cfg.SobolTable); err != nil {
+			log.Fatalf("-sobol-table: %v", err)
+		}
+	}
+
 	return cfg
 }
 
-// setupGracefulShutdown sets up graceful shutdown handling
+// setupGracefulShutdown shuts srv down on SIGINT or SIGTERM and exits the
+// process once it stopped serving
 func setupGracefulShutdown(srv *server.Server) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -74,6 +214,7 @@ func setupGracefulShutdown(srv *server.Server) {
 		if err := srv.Shutdown(); err != nil {
 			log.Printf("Error during shutdown: %v", err)
 		}
+		<-srv.Done()
 		os.Exit(0)
 	}()
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"strconv"
+
+	"github.com/kacperjurak/goimpcore"
 )
 
 // ArrayFlags replacement for removed goimp/cmd.ArrayFlags
@@ -22,33 +26,190 @@ func (a *ArrayFlags) Set(value string) error {
 }
 
 type Config struct {
-	Code        string
-	File        string
-	InitValues  ArrayFlags // Changed from cmd.ArrayFlags
-	CutLow      uint
-	CutHigh     uint
-	Unity       bool
-	SmartMode   string
-	OptimMethod string // New field for optimization method selection
-	Benchmark   bool   // Enable benchmark mode with timing
-	Flip        bool
-	ImgOut      bool
-	ImgSave     bool
-	ImgPath     string
-	ImgDPI      uint
-	ImgSize     uint
-	Concurrency bool
-	Threads     uint
-	Jobs        uint
-	Quiet       bool
-	HTTPServer  bool
+	Code           string
+	File           string
+	InitValues     ArrayFlags // Changed from cmd.ArrayFlags
+	CutLow         uint
+	CutHigh        uint
+	FreqMin        float64 // fit only frequencies >= FreqMin, 0 for no limit
+	FreqMax        float64 // fit only frequencies <= FreqMax, 0 for no limit
+	Robust         bool    // reject outliers and refit
+	RobustK        float64 // outlier threshold in robust standard deviations
+	RobustPasses   uint    // maximum number of outlier rejection refits
+	Weighting      string  // modulus, unity, proportional or sigma; empty selects sigma when uncertainties are supplied, else modulus
+	Space          string  // impedance or admittance, the representation the residuals are minimized in; empty is impedance
+	LogScale       bool    // optimize capacitances and Y0 parameters in log space
+	Regularization float64 // Tikhonov penalty strength pulling parameters towards their init values
+	MaxCorrelation float64 // parameter correlation reported as unidentifiable, goimpcore.DefaultMaxCorrelation when 0
+	SmartMode      string
+	OptimMethod    string // New field for optimization method selection
+	Benchmark      bool   // Enable benchmark mode with timing
+	Flip           bool
+	ImgOut         bool
+	ImgSave        bool
+	ImgPath        string
+	ImgFormat      string // nyquist or bode
+	ImgDPI         uint
+	ImgSize        uint
+	Concurrency    bool   // run Nelder-Mead as a parallel multi-start and evaluate large spectra concurrently
+	Starts         uint   // number of multi-start starting points
+	SobolTable     string // Joe-Kuo direction numbers file extending the Sobol dimensions of the multi-start
+	Threads        uint
+	Seed           int64 // seed of the multi-start and bootstrap random sources, the clock when 0
+	Jobs           uint
+	Quiet          bool
+	HTTPServer     bool
+	Port           int     // HTTP server port, 0 for a random free one
+	WebhookURL     string  // endpoint receiving the fit results
+	Workers        int     // worker pool size, Threads when 0
+	Metrics        bool    // serve Prometheus metrics on /metrics
+	Formalism      string  // Output representation: z (impedance), y (admittance), m (electric modulus)
+	C0             float64 // Geometric capacitance in Farads, required for the m formalism
+	Criterion      string  // Selection criterion when comparing fits: chisq, aic or bic
+	Circuits       string  // comma separated circuits to fit and compare, instead of Code
+	Bootstrap      bool    // Estimate parameter confidence intervals after the fit
+	BootSamples    uint    // Number of bootstrap refits
+	MaxIterations  int     // solver restarts per fit, maxIterations when 0
+
+	Sensitivity bool   // Differentiate the chi-square with respect to each parameter after the fit
+	ResultFiles bool   // Write the results of several input files to <input>_result.json instead of STDOUT
+	InputFormat string // text, zview, gamry, biologic or auto to detect it from the file extension
+	CodeSet     bool   // -c was given, circuits found in input files do not replace it
+	Resample    uint   // frequencies spaced evenly in log frequency a measurement is resampled to, 0 keeps the measured ones
+
+	Watch           string // directory whose new measurement files are fitted, see runWatch
+	ProcessExisting bool   // with Watch, fit the files already in the directory first
+	WatchWebhook    bool   // with Watch, send each result to WebhookURL too
 }
 
 // ImpedanceData matches the format sent by mockinput
 type ImpedanceData struct {
-	Timestamp   string               `json:"timestamp"`
-	Frequencies []float64            `json:"frequencies"`
-	Magnitude   []float64            `json:"magnitude"`
-	Phase       []float64            `json:"phase"`
-	Impedance   []map[string]float64 `json:"impedance"`
+	Timestamp    string               `json:"timestamp"`
+	Frequencies  []float64            `json:"frequencies"`
+	Magnitude    []float64            `json:"magnitude"`
+	Phase        []float64            `json:"phase"`
+	PhaseUnit    string               `json:"phase_unit,omitempty"` // deg or rad, detected from the values when empty
+	Impedance    []map[string]float64 `json:"impedance"`
+	Sigma        []map[string]float64 `json:"sigma,omitempty"`    // optional standard deviations per point
+	FreqMin      float64              `json:"freq_min,omitempty"` // optional fit window, overrides the server default
+	FreqMax      float64              `json:"freq_max,omitempty"`
+	Robust       bool                 `json:"robust,omitempty"`        // reject outliers, overrides the server default when set
+	Space        string               `json:"space,omitempty"`         // impedance or admittance, overrides the server default when set
+	CircuitCodes []string             `json:"circuit_codes,omitempty"` // candidate circuits to fit and rank by AIC instead of the configured one
+
+	// Fit settings overriding the server defaults when set
+	CircuitCode   string    `json:"circuit_code,omitempty"`
+	InitValues    []float64 `json:"init_values,omitempty"` // one per parameter of the circuit
+	OptimMethod   string    `json:"optim_method,omitempty"`
+	Weighting     string    `json:"weighting,omitempty"`
+	MaxIterations int       `json:"max_iterations,omitempty"` // solver restarts
+
+	// TimeoutSeconds mirrors models.ImpedanceData, the fits of this server
+	// have no job timeout
+	TimeoutSeconds float64 `json:"timeout_seconds,omitempty"`
+	// RequestKey mirrors models.ImpedanceData, this server does not
+	// recognize retries
+	RequestKey string `json:"request_key,omitempty"`
+}
+
+// Sigmas returns the per-point standard deviations as {real, imag} pairs,
+// nil when the request carries no uncertainties
+func (d ImpedanceData) Sigmas() [][2]float64 {
+	if len(d.Sigma) == 0 {
+		return nil
+	}
+	sigmas := make([][2]float64, len(d.Sigma))
+	for i, point := range d.Sigma {
+		sigmas[i] = [2]float64{point["real"], point["imag"]}
+	}
+	return sigmas
+}
+
+// polarTolerance is the relative difference allowed between the impedance
+// points and their magnitude and phase when a request carries both
+const polarTolerance = 1e-2
+
+// Phase units accepted in ImpedanceData.PhaseUnit
+const (
+	PhaseDegrees = "deg"
+	PhaseRadians = "rad"
+)
+
+// PhasesInDegrees returns the phases in degrees. Without a PhaseUnit they are
+// taken as radians, unless every |phase| exceeds π, which only degrees can.
+func (d ImpedanceData) PhasesInDegrees() ([]float64, error) {
+	unit := d.PhaseUnit
+	if unit == "" {
+		unit = PhaseDegrees
+		for _, p := range d.Phase {
+			if math.Abs(p) <= math.Pi {
+				unit = PhaseRadians
+				break
+			}
+		}
+	}
+
+	switch unit {
+	case PhaseDegrees:
+		return d.Phase, nil
+	case PhaseRadians:
+		degrees := make([]float64, len(d.Phase))
+		for i, p := range d.Phase {
+			degrees[i] = p * 180 / math.Pi
+		}
+		return degrees, nil
+	}
+	return nil, fmt.Errorf("unknown phase_unit %q, expected deg or rad", d.PhaseUnit)
+}
+
+// Points returns the impedance as {real, imag} pairs. Requests may give the
+// impedance, magnitude and phase, or both, in which case they have to agree
+// within polarTolerance. See PhasesInDegrees for the phase unit.
+func (d ImpedanceData) Points() ([][2]float64, error) {
+	n := len(d.Frequencies)
+	hasPolar := len(d.Magnitude) > 0 || len(d.Phase) > 0
+	var phase []float64
+	if hasPolar {
+		if len(d.Magnitude) != n {
+			return nil, fmt.Errorf("magnitude has %d values for %d frequencies", len(d.Magnitude), n)
+		}
+		if len(d.Phase) != n {
+			return nil, fmt.Errorf("phase has %d values for %d frequencies", len(d.Phase), n)
+		}
+		var err error
+		if phase, err = d.PhasesInDegrees(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(d.Impedance) == 0 {
+		if !hasPolar {
+			return nil, fmt.Errorf("no impedance or magnitude and phase provided")
+		}
+		return goimpcore.FromPolar(d.Magnitude, phase), nil
+	}
+	if len(d.Impedance) != n {
+		return nil, fmt.Errorf("impedance has %d values for %d frequencies", len(d.Impedance), n)
+	}
+
+	points := make([][2]float64, n)
+	for i, point := range d.Impedance {
+		re, reOk := point["real"]
+		im, imOk := point["imag"]
+		if !reOk || !imOk {
+			return nil, fmt.Errorf("impedance point %d lacks real or imag", i)
+		}
+		points[i] = [2]float64{re, im}
+	}
+
+	if hasPolar {
+		polar := goimpcore.FromPolar(d.Magnitude, phase)
+		for i, p := range points {
+			diff := math.Hypot(p[0]-polar[i][0], p[1]-polar[i][1])
+			if diff > polarTolerance*math.Hypot(p[0], p[1]) {
+				return nil, fmt.Errorf("impedance and magnitude/phase disagree at %g Hz", d.Frequencies[i])
+			}
+		}
+	}
+	return points, nil
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/fileio"
+	"github.com/kacperjurak/goimpcore/pkg/analysis"
+)
+
+// inputFiles splits the -f value into the measurement files to fit
+func inputFiles(list string) []string {
+	var files []string
+	for _, file := range strings.Split(list, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// readMeasurement reads file in the -informat format and returns the circuit
+// to fit it with, the CIRCUIT of a .z file unless -c was given
+func readMeasurement(cfg *Config, file string) (freqs []float64, impData [][2]float64, sigmas [][2]float64, code string, err error) {
+	format := cfg.InputFormat
+	if format == "" || format == "auto" {
+		format = fileio.DetectFormat(file)
+	}
+
+	f, err := fileio.Open(file)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	defer f.Close()
+	return readFormat(cfg, format, file, f)
+}
+
+// readFormat reads the measurement named file from r in format, see
+// readMeasurement
+func readFormat(cfg *Config, format, file string, r io.Reader) (freqs []float64, impData [][2]float64, sigmas [][2]float64, code string, err error) {
+	m, err := fileio.Read(format, r)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	return m.Freqs, m.ImpData, m.Sigmas, fileCircuit(cfg, file, m.CircuitCode), nil
+}
+
+// fileCircuit returns the circuit code found in file when it is valid and -c
+// was not given, cfg.Code otherwise
+func fileCircuit(cfg *Config, file, code string) string {
+	switch {
+	case code == "" || strings.EqualFold(code, cfg.Code):
+		return cfg.Code
+	case goimpcore.ValidateCircuit(code) != nil:
+		log.Printf("WARNING: %s: ignoring invalid circuit %s of the file: %v", file, code, goimpcore.ValidateCircuit(code))
+		return cfg.Code
+	case cfg.CodeSet:
+		log.Printf("WARNING: %s: ignoring circuit %s of the file, fitting -c %s", file, code, cfg.Code)
+		return cfg.Code
+	}
+	log.Printf("WARNING: %s: fitting circuit %s of the file instead of -c %s", file, code, cfg.Code)
+	return code
+}
+
+// cutData drops the -b first and -e last points of a measurement
+func cutData(cfg *Config, freqs []float64, impData [][2]float64, sigmas [][2]float64) ([]float64, [][2]float64, [][2]float64, error) {
+	low, high := int(cfg.CutLow), len(freqs)-int(cfg.CutHigh)
+	if low > high {
+		return nil, nil, nil, fmt.Errorf("cannot cut %d first and %d last of %d points", cfg.CutLow, cfg.CutHigh, len(freqs))
+	}
+	if sigmas != nil {
+		sigmas = sigmas[low:high]
+	}
+	return freqs[low:high], impData[low:high], sigmas, nil
+}
+
+// resampleData resamples a measurement to -resample frequencies spaced evenly
+// in log frequency, its uncertainties too when it has some
+func resampleData(cfg *Config, freqs []float64, impData [][2]float64, sigmas [][2]float64) ([]float64, [][2]float64, [][2]float64, error) {
+	if cfg.Resample == 0 {
+		return freqs, impData, sigmas, nil
+	}
+	grid, resampled, err := analysis.ResampleLogUniform(freqs, impData, int(cfg.Resample))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("resampling: %w", err)
+	}
+	if sigmas != nil {
+		if sigmas, err = analysis.InterpolateImpedance(freqs, sigmas, grid); err != nil {
+			return nil, nil, nil, fmt.Errorf("resampling uncertainties: %w", err)
+		}
+	}
+	return grid, resampled, sigmas, nil
+}
+
+// fileResult is the outcome of the fit of one of several input files
+type fileResult struct {
+	File           string                       `json:"file"`
+	Circuit        string                       `json:"circuit"`
+	Status         string                       `json:"status"`
+	Error          string                       `json:"error,omitempty"` // why the file could not be fitted
+	ChiSquare      float64                      `json:"chi_square"`
+	Parameters     []float64                    `json:"parameters,omitempty"`
+	ParameterNames []string                     `json:"parameter_names,omitempty"` // see goimpcore.ParamLabels
+	FitStats       *goimpcore.FitStats          `json:"fit_stats,omitempty"`
+	Warnings       []string                     `json:"warnings,omitempty"`
+	CircuitRanking []goimpcore.CircuitCandidate `json:"circuit_ranking,omitempty"`
+}
+
+// fileFit is the fitted measurement of a file and the solver result
+type fileFit struct {
+	freqs   []float64
+	impData [][2]float64
+	result  goimpcore.Result
+}
+
+// fitFile fits the measurement in file with cfg. The fit is nil when the file
+// could not be read.
+func fitFile(cfg *Config, file string) (fileResult, *fileFit) {
+	res := fileResult{File: file, Circuit: cfg.Code, Status: "ERROR"}
+	freqs, impData, sigmas, code, err := readMeasurement(cfg, file)
+	if err == nil {
+		freqs, impData, sigmas, err = cutData(cfg, freqs, impData, sigmas)
+	}
+	if err == nil {
+		freqs, impData, sigmas, err = resampleData(cfg, freqs, impData, sigmas)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+
+	fileCfg := *cfg
+	fileCfg.Code = code
+	result := processEISData(context.Background(), freqs, impData, sigmas, &fileCfg)
+	res.Circuit = result.BestCircuit(code)
+	res.Status = result.Status
+	res.ChiSquare = sanitizeFloat(result.Min)
+	res.Parameters = sanitizeSlice(result.Params)
+	res.ParameterNames = goimpcore.ParamLabels(strings.ToLower(res.Circuit))
+	res.FitStats = sanitizeStats(result.Stats)
+	res.Warnings = result.Warnings
+	res.CircuitRanking = sanitizeRanking(result.Ranking())
+	return res, &fileFit{freqs: freqs, impData: impData, result: result}
+}
+
+// runFiles fits every file with cfg one after another. The results are
+// written as CSV rows to STDOUT, or each to <input>_result.json with
+// -resultfiles. A file that cannot be read yields a result with status ERROR.
+func runFiles(cfg *Config, files []string) {
+	if cfg.ImgSave || cfg.ImgOut {
+		log.Printf("Plots are not generated for several input files")
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if !cfg.ResultFiles {
+		w.Write([]string{"file", "circuit", "status", "chi_square", "reduced_chi_square", "parameters", "error"})
+	}
+	for _, file := range files {
+		res, _ := fitFile(cfg, file)
+		if res.Error != "" {
+			log.Printf("%s: %s", file, res.Error)
+		}
+
+		if cfg.ResultFiles {
+			path := resultPath(file)
+			if err := writeResultFile(path, res); err != nil {
+				log.Printf("Failed to write %s: %v", path, err)
+			} else {
+				log.Printf("%s: result written to %s", file, path)
+			}
+			continue
+		}
+
+		params := make([]string, len(res.Parameters))
+		for i, p := range res.Parameters {
+			name := fmt.Sprint(i)
+			if i < len(res.ParameterNames) {
+				name = res.ParameterNames[i]
+			}
+			params[i] = fmt.Sprintf("%s=%.6e", name, p)
+		}
+		redChiSq := ""
+		if res.FitStats != nil {
+			redChiSq = fmt.Sprintf("%.6e", res.FitStats.ReducedChiSq)
+		}
+		w.Write([]string{file, res.Circuit, res.Status, fmt.Sprintf("%.6e", res.ChiSquare), redChiSq, strings.Join(params, " "), res.Error})
+		w.Flush()
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Failed to write results: %v", err)
+	}
+}
+
+// resultPath returns <input>_result.json for file, stdin_result.json for STDIN
+func resultPath(file string) string {
+	if file == fileio.Stdin {
+		return "stdin_result.json"
+	}
+	return strings.TrimSuffix(file, filepath.Ext(file)) + "_result.json"
+}
+
+func writeResultFile(path string, res fileResult) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
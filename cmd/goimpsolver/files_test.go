@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// withStdin runs f with input piped to os.Stdin
+func withStdin(t *testing.T, input string, f func()) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		io.WriteString(w, input)
+		w.Close()
+	}()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() {
+		os.Stdin = stdin
+		r.Close()
+	}()
+	f()
+}
+
+// A measurement piped through STDIN is read with -f -
+func TestReadMeasurementStdin(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	input := "1 100 -5\n10 90 -20 0.5 0.5\n100.5 50.25 -30\n"
+	cfg := &Config{Code: "R(QR)", InputFormat: "auto"}
+	var (
+		freqs   []float64
+		impData [][2]float64
+		sigmas  [][2]float64
+		code    string
+		err     error
+	)
+	withStdin(t, input, func() {
+		freqs, impData, sigmas, code, err = readMeasurement(cfg, "-")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(freqs, []float64{1, 10, 100.5}) || !slices.Equal(impData, [][2]float64{{100, -5}, {90, -20}, {50.25, -30}}) {
+		t.Errorf("read %v %v", freqs, impData)
+	}
+	if sigmas != nil || code != "R(QR)" {
+		t.Errorf("sigmas %v, circuit %s, want none and R(QR)", sigmas, code)
+	}
+
+	withStdin(t, "1 100\n", func() {
+		_, _, _, _, err = readMeasurement(cfg, "-")
+	})
+	if err == nil {
+		t.Error("a line without the imaginary column was read")
+	}
+}
+
+func TestInputFiles(t *testing.T) {
+	got := inputFiles(" a.txt, -,,b.z ")
+	if want := []string{"a.txt", "-", "b.z"}; !slices.Equal(got, want) {
+		t.Errorf("files %q, want %q", got, want)
+	}
+}
+
+// With -resultfiles every one of several files gets its own result, a file
+// that cannot be read one with status ERROR
+func TestRunFilesResultFiles(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	good := filepath.Join(dir, "cell1.txt")
+	if err := os.WriteFile(good, []byte("1 100 0\n10 100 0\n100 100 0\n1000 100 0\n10000 100 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "cell2.txt")
+
+	runFiles(&Config{Code: "R", InputFormat: "auto", OptimMethod: "nelder-mead", InitValues: ArrayFlags{50}, ResultFiles: true, Quiet: true}, []string{good, missing})
+
+	read := func(path string) fileResult {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res fileResult
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	if res := read(filepath.Join(dir, "cell1_result.json")); res.Status != goimpcore.OK || len(res.Parameters) != 1 || res.File != good {
+		t.Errorf("cell1: %+v", res)
+	}
+	if res := read(filepath.Join(dir, "cell2_result.json")); res.Status != "ERROR" || res.Error == "" {
+		t.Errorf("cell2: %+v", res)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
+	"github.com/kacperjurak/goimpcore/pkg/formalism"
+	"github.com/kacperjurak/goimpcore/pkg/plot"
+	"github.com/kacperjurak/goimpcore/pkg/quasirandom"
+	"io"
 	"log"
 	"math"
 	"os"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -20,58 +28,195 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		runSimulate(os.Args[2:])
+		return
+	}
+
 	config := new(Config)
 
-	flag.StringVar(&config.Code, "c", "R(QR)", "Boukamp Circuit Description code")
-	flag.StringVar(&config.File, "f", "ASTM0.txt", "Measurement data file")
+	flag.StringVar(&config.Code, "c", "R(QR)", "Boukamp Circuit Description code, or a comma separated list like \"R(QR),R(QR)(QR)\" to fit each and rank them by AIC")
+	flag.StringVar(&config.Circuits, "circuits", "", "Comma separated circuits to fit and compare by AIC, BIC and Akaike weights, e.g. \"R(CR),R(QR),R(Q(R(QR)))\"")
+	flag.StringVar(&config.File, "f", "ASTM0.txt", "Measurement data file, - for STDIN, or a comma separated list of files fitted one after another")
+	flag.StringVar(&config.InputFormat, "informat", "auto", "Measurement file format: text, zview (ZView/WinStar .z), gamry (.dta), biologic (EC-Lab .mpt) or auto by the file extension")
+	flag.BoolVar(&config.ResultFiles, "resultfiles", false, "Write the result of each of several -f files to <input>_result.json instead of CSV on STDOUT")
+	flag.StringVar(&config.Watch, "watch", "", "Watch this directory and fit every new .txt, .dta, .mpt or .z file into <input>_result.json until interrupted")
+	flag.BoolVar(&config.ProcessExisting, "process-existing", false, "With -watch, fit the files already in the directory first")
+	flag.BoolVar(&config.WatchWebhook, "watch-webhook", false, "With -watch, send each result to -webhook-url too")
 	flag.Var(&config.InitValues, "v", "Parameters init values (array)")               // for better fit the EIS
 	flag.UintVar(&config.CutLow, "b", 0, "Cut X of begining frequencies from a file") // am not using
 	flag.UintVar(&config.CutHigh, "e", 0, "Cut X of ending frequencies from a file")  // am not using
-	flag.BoolVar(&config.Unity, "unity", false, "Use Unity weighting intead Modulus") // UNITY problematic data more focused on small values
+	flag.UintVar(&config.Resample, "resample", 0, "Resample each measurement to N frequencies spaced evenly in log frequency before the fit, 0 keeps the measured ones")
+	flag.Float64Var(&config.FreqMin, "fmin", 0, "Exclude frequencies below fmin (Hz) from the fit, 0 for no limit")
+	flag.Float64Var(&config.FreqMax, "fmax", 0, "Exclude frequencies above fmax (Hz) from the fit, 0 for no limit")
+	flag.BoolVar(&config.Robust, "robust", false, "Reject outliers (k*MAD of the weighted residuals) and refit")
+	flag.Float64Var(&config.RobustK, "robustk", goimpcore.DefaultRobustK, "Outlier threshold for -robust in robust standard deviations")
+	flag.UintVar(&config.RobustPasses, "robustpasses", goimpcore.DefaultRobustPasses, "Maximum number of outlier rejection refits for -robust")
+	flag.StringVar(&config.Weighting, "weighting", "", "Weighting: modulus, unity, proportional or sigma (default sigma when the data has uncertainties, otherwise modulus)")
+	flag.StringVar(&config.Space, "space", "", "Fit space: impedance or admittance (better conditioned for highly capacitive samples), results are reported as impedances")
+	flag.BoolVar(&config.LogScale, "logscale", false, "Optimize capacitances and CPE/Warburg Y0 parameters in log space")
+	flag.Float64Var(&config.MaxCorrelation, "maxcorr", goimpcore.DefaultMaxCorrelation, "Warn when two fitted parameters are correlated above this absolute value")
+	flag.Float64Var(&config.Regularization, "regularization", 0, "Penalty pulling parameters towards their init values for ill-conditioned fits, e.g. 0.01")
 	flag.StringVar(&config.SmartMode, "m", "eis", "Smart mode")
-	flag.StringVar(&config.OptimMethod, "optim", "nelder-mead", "Optimization method: nelder-mead, levenberg-marquardt, gradient-descent, lbfgs, newton, or all")
+	flag.StringVar(&config.OptimMethod, "optim", "nelder-mead", "Optimization method: nelder-mead, levenberg-marquardt, gradient-descent, lbfgs, newton, hybrid, parallel, or all")
 	flag.BoolVar(&config.Benchmark, "benchmark", false, "Enable benchmark mode with timing (saves to benchmark_results.csv)")
 	flag.BoolVar(&config.Flip, "noflip", false, "Don't flip imaginary part on image")
 	flag.BoolVar(&config.ImgOut, "imgout", false, "Image data to STDOUT")
 	flag.BoolVar(&config.ImgSave, "imgsave", false, "Save image to file")
-	flag.StringVar(&config.ImgPath, "imgpath", "eis.svg", "Path to generated image")
+	flag.StringVar(&config.ImgPath, "imgpath", "eis.svg", "Path to generated image, .svg or .png")
+	flag.StringVar(&config.ImgFormat, "format", plot.FormatNyquist, "Image format: nyquist or bode")
 	flag.UintVar(&config.ImgDPI, "dpi", 96, "Image DPI")
 	flag.UintVar(&config.ImgSize, "imgsize", 4, "Image size (inches)")
-	flag.BoolVar(&config.Concurrency, "concurrency", false, "Use concurrency for calculations")
+	flag.BoolVar(&config.Concurrency, "concurrency", false, "Run Nelder-Mead as a parallel multi-start (same as -optim parallel) and evaluate spectra of 500+ points on all cores")
+	flag.UintVar(&config.Starts, "starts", goimpcore.DefaultStarts, "Number of starting points for parallel multi-start")
+	flag.StringVar(&config.SobolTable, "sobol-table", "", "Joe-Kuo direction numbers file, e.g. new-joe-kuo-6.21201, for Sobol multi-starts of more than 37 parameters")
 	flag.UintVar(&config.Jobs, "jobs", 10, "Number of how many times trigger the calculations")
+	flag.Int64Var(&config.Seed, "seed", 0, "Seed of the multi-start and bootstrap random sources for reproducible benchmark runs, 0 seeds from the clock")
 	flag.UintVar(&config.Threads, "threads", 10, "Number of threads to use for calculations")
-	flag.BoolVar(&config.HTTPServer, "http", false, "Start HTTP server on port 8080")
+	port, webhookURL := envDefaults()
+	flag.BoolVar(&config.HTTPServer, "http", false, "Start HTTP server on -port")
+	flag.IntVar(&config.Port, "port", port, "HTTP server port, 0 for a random free port (env GOIMP_PORT)")
+	flag.StringVar(&config.WebhookURL, "webhook-url", webhookURL, "Endpoint receiving the fit results (env GOIMP_WEBHOOK_URL)")
+	flag.IntVar(&config.Workers, "workers", 0, "Number of fits run at once by the HTTP server worker pool, -threads when 0")
+	flag.BoolVar(&config.Metrics, "metrics", true, "Serve Prometheus metrics on /metrics of the HTTP server")
 	flag.BoolVar(&config.Quiet, "q", false, "Quiet mode")
+	flag.StringVar(&config.Formalism, "formalism", formalism.Impedance, "Output formalism: z (impedance), y (admittance), m (electric modulus)")
+	flag.Float64Var(&config.C0, "c0", 0, "Geometric capacitance C0 in Farads (required for -formalism m)")
+	flag.BoolVar(&config.Bootstrap, "bootstrap", false, "Estimate 95% parameter confidence intervals with a residual bootstrap after the fit")
+	flag.UintVar(&config.BootSamples, "bootsamples", goimpcore.DefaultBootstrapSamples, "Number of bootstrap refits")
+	flag.BoolVar(&config.Sensitivity, "sensitivity", false, "Print the sensitivity of the chi-square to each parameter after the fit")
+	flag.StringVar(&config.Criterion, "criterion", goimpcore.CriterionChiSq, "Selection criterion for -optim all: chisq, aic or bic")
 	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			config.CodeSet = true
+		}
+	})
+
+	if _, err := goimpcore.ParseWeighting(config.Weighting); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := goimpcore.ParseSpace(config.Space); err != nil {
+		log.Fatal(err)
+	}
+	if !formalism.Valid(config.Formalism) {
+		log.Fatalf("Unknown formalism '%s', expected z, y or m", config.Formalism)
+	}
+	if config.Formalism == formalism.Modulus && config.C0 <= 0 {
+		log.Fatal("Electric modulus formalism requires a positive -c0")
+	}
+	if config.ImgFormat != plot.FormatNyquist && config.ImgFormat != plot.FormatBode {
+		log.Fatalf("Unknown image format '%s', expected nyquist or bode", config.ImgFormat)
+	}
+	if config.FreqMin < 0 || config.FreqMax < 0 || (config.FreqMax > 0 && config.FreqMin > config.FreqMax) {
+		log.Fatalf("Invalid frequency window -fmin %v -fmax %v", config.FreqMin, config.FreqMax)
+	}
+	if config.SobolTable != "" {
+		if err := quasirandom.LoadDirectionNumbersFile(config.SobolTable); err != nil {
+			log.Fatalf("-sobol-table: %v", err)
+		}
+	}
 
 	if config.HTTPServer {
 		startHTTPServer(config)
 		return
 	}
 
-	freqs, impData := parseFile(config.File)
-	freqs = freqs[config.CutLow : len(freqs)-int(config.CutHigh)]
-	impData = impData[config.CutLow : len(impData)-int(config.CutHigh)]
+	if config.Watch != "" {
+		if err := runWatch(config, config.Watch); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if files := inputFiles(config.File); len(files) > 1 {
+		runFiles(config, files)
+		return
+	}
+
+	freqs, impData, sigmas, code, err := readMeasurement(config, config.File)
+	if err != nil {
+		log.Fatal(err)
+	}
+	freqs, impData, sigmas, err = cutData(config, freqs, impData, sigmas)
+	if err == nil {
+		freqs, impData, sigmas, err = resampleData(config, freqs, impData, sigmas)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.Code = code
 
-	result := processEISData(freqs, impData, config)
+	result := processEISData(context.Background(), freqs, impData, sigmas, config)
 	log.Printf("Final result: %+v", result)
+	for _, w := range result.Warnings {
+		fmt.Println("Warning:", w)
+	}
+
+	if config.ImgSave || config.ImgOut {
+		generatePlot(config, freqs, impData, result)
+	}
+}
+
+// generatePlot writes the measured and fitted spectrum in cfg.ImgFormat to
+// cfg.ImgPath for -imgsave and to STDOUT for -imgout, SVG or PNG by the
+// extension of cfg.ImgPath
+func generatePlot(cfg *Config, freqs []float64, impData [][2]float64, result goimpcore.Result) {
+	var fitted [][2]float64
+	code := strings.ToLower(result.BestCircuit(cfg.Code))
+	if result.Status == goimpcore.OK && len(result.Params) == len(goimpcore.GetElements(code)) {
+		fitted = goimpcore.CircuitImpedance(code, freqs, result.Params)
+	}
+
+	opts := plot.Options{
+		Path:   cfg.ImgPath,
+		Format: cfg.ImgFormat,
+		DPI:    cfg.ImgDPI,
+		Size:   cfg.ImgSize,
+		Flip:   !cfg.Flip,
+	}
+
+	if cfg.ImgSave {
+		if err := plot.GeneratePlot(opts, freqs, impData, fitted); err != nil {
+			log.Printf("Failed to save %s plot: %v", cfg.ImgFormat, err)
+		} else {
+			log.Printf("%s plot saved to %s", cfg.ImgFormat, cfg.ImgPath)
+		}
+	}
+
+	if cfg.ImgOut {
+		encoding, err := plot.EncodingOf(cfg.ImgPath)
+		if err != nil {
+			encoding = plot.EncodingSVG
+		}
+		if err := plot.WritePlot(os.Stdout, encoding, opts, freqs, impData, fitted); err != nil {
+			log.Printf("Failed to write %s plot: %v", cfg.ImgFormat, err)
+		}
+	}
 }
 
 // processEISData function disabled due to goimp dependency removal
 // func processEISData(freqs []float64, impData [][2]float64, cfg *Config) goimp.Result {
-func processEISData(freqs []float64, impData [][2]float64, cfg *Config) goimpcore.Result {
+func processEISData(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *Config) goimpcore.Result {
 	log.Printf("Processing %d frequency points with config: %+v", len(freqs), cfg)
 
+	if cfg.Circuits != "" {
+		return compareCircuits(ctx, freqs, impData, sigmas, goimpcore.ParseCircuitCodes(cfg.Circuits), cfg)
+	}
+	if codes := goimpcore.ParseCircuitCodes(cfg.Code); len(codes) > 1 {
+		return compareCircuits(ctx, freqs, impData, sigmas, codes, cfg)
+	}
+
 	code := strings.ToLower(cfg.Code)
 
 	if cfg.OptimMethod == "all" {
-		return runAllOptimizationMethods(code, freqs, impData, cfg)
+		return runAllOptimizationMethods(ctx, code, freqs, impData, sigmas, cfg)
 	}
 
-	return runSingleOptimizationMethod(code, freqs, impData, cfg, cfg.OptimMethod)
+	return runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, cfg.OptimMethod)
 }
 
-func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]float64, cfg *Config, method string) goimpcore.Result {
+func runSingleOptimizationMethod(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *Config, method string) goimpcore.Result {
 	s := goimpcore.NewSolver(code, freqs, impData)
 
 	// Use provided InitValues or generate automatic ones
@@ -79,20 +224,49 @@ func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]floa
 		s.InitValues = []float64(cfg.InitValues)
 		log.Printf("Using provided initial values: %v", s.InitValues)
 	} else {
-		s.InitValues = generateInitialValues(code)
+		s.InitValues = generateInitialValues(code, freqs, impData)
 		log.Printf("Using auto-generated initial values: %v", s.InitValues)
 	}
 
-	if cfg.Unity {
-		s.Weighting = goimpcore.UNITY
-	} else {
+	if err := s.SetWeighting(cfg.Weighting, sigmas); err != nil {
+		log.Printf("%v, using modulus weighting", err)
 		s.Weighting = goimpcore.MODULUS
 	}
+	log.Printf("Using %s weighting", s.Weighting)
+
+	space, err := goimpcore.ParseSpace(cfg.Space)
+	if err != nil {
+		log.Printf("%v, fitting impedances", err)
+	}
+	s.Space = space
+
+	if cfg.Starts > 0 {
+		s.Starts = int(cfg.Starts)
+	}
+	s.Seed = cfg.Seed
+	if cfg.Concurrency {
+		s.Concurrency = runtime.NumCPU()
+	}
+	s.Robust = goimpcore.RobustSettings{Enabled: cfg.Robust, K: cfg.RobustK, Passes: int(cfg.RobustPasses)}
+	s.FreqMin = cfg.FreqMin
+	s.FreqMax = cfg.FreqMax
+	s.Regularization = cfg.Regularization
+	s.Diagnostics.MaxCorrelation = cfg.MaxCorrelation
+
+	if cfg.LogScale {
+		s.LogScale = goimpcore.DefaultLogScale(code)
+		log.Printf("Using log-scale parameters: %v", s.LogScale)
+	}
 
 	// Set the solver method based on the optimization method
 	switch method {
 	case "nelder-mead":
 		s.SmartMode = "eis" // Use EIS smart mode for multi-try approach
+		if cfg.Concurrency {
+			s.SmartMode = "parallel"
+		}
+	case "parallel", "multi-start":
+		s.SmartMode = "parallel" // EIS smart mode from several starting points at once
 	case "levenberg-marquardt", "lm":
 		s.SmartMode = "lm"
 	case "gradient-descent", "gd":
@@ -101,6 +275,8 @@ func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]floa
 		s.SmartMode = "lbfgs"
 	case "newton":
 		s.SmartMode = "newton"
+	case "hybrid", "nm+lm":
+		s.SmartMode = "nm+lm" // NM exploration followed by LM refinement
 	default:
 		log.Printf("Unknown optimization method '%s', using Nelder-Mead", method)
 		s.SmartMode = "eis"
@@ -110,28 +286,12 @@ func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]floa
 
 	// Time the optimization
 	startTime := time.Now()
-	res := s.Solve(minFunc, maxIterations)
+	res, err := s.SolveWithContext(ctx, minFunc, iterationLimit(cfg))
 	duration := time.Since(startTime)
-
-	// Ensure consistent chi-square calculation for all methods
-	// Skip recalculation for EIS mode as it handles scaling internally
-	if res.Status != "ERROR" && len(res.Params) > 0 && (res.MinUnit != "ChiSq" || method != "levenberg-marquardt") && cfg.SmartMode != "eis" {
-		// Debug the recalculation process
-		theoreticalImp := goimpcore.CircuitImpedance(code, freqs, res.Params)
-
-		actualChiSq := goimpcore.ChiSq(impData, theoreticalImp, s.Weighting)
-		log.Printf("DEBUG: ChiSq calculation result: %v (weighting: %v)", actualChiSq, s.Weighting)
-
-		// Check if recalculation produces NaN
-		if math.IsNaN(actualChiSq) || math.IsInf(actualChiSq, 0) {
-			log.Printf("WARNING: Recalculated chi-square is invalid (%v), keeping original result.Min (%v)", actualChiSq, res.Min)
-		} else {
-			log.Printf("INFO: Using recalculated chi-square (%v) instead of original (%v)", actualChiSq, res.Min)
-			res.Min = actualChiSq
-			res.MinUnit = "ChiSq"
-		}
-	} else if cfg.SmartMode == "eis" {
-		log.Printf("INFO: Skipping chi-square recalculation for EIS mode (scaling handled internally)")
+	if errors.Is(err, goimpcore.ErrEmptyWindow) {
+		log.Printf("Optimization skipped: %v", err)
+	} else if err != nil {
+		log.Printf("Optimization cancelled (%v), using best result found so far", err)
 	}
 
 	if res.Status == "ERROR" {
@@ -145,6 +305,36 @@ func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]floa
 			log.Printf("Method: %s FAILED - Status=%s", method, res.Status)
 		} else {
 			log.Printf("Method: %s, Min=%.12e, Params=%v, Status=%s", method, res.Min, res.Params, res.Status)
+			printParameters(code, res.Params)
+			log.Printf("Method: %s, RedChiSq=%.6e, R2=%.6f, AIC=%.4f, BIC=%.4f, DoF=%d",
+				method, res.Stats.ReducedChiSq, res.Stats.RSquared, res.Stats.AIC, res.Stats.BIC, res.Stats.DoF)
+		}
+	}
+
+	if cfg.Bootstrap && res.Status == goimpcore.OK && len(res.Params) > 0 {
+		bs := goimpcore.Bootstrap(s, res, int(cfg.BootSamples))
+		res.SetPayload("bootstrap", bs)
+		if !cfg.Quiet {
+			labels := goimpcore.ParamLabels(code)
+			for i := range bs.Params {
+				label := strconv.Itoa(i)
+				if i < len(labels) {
+					label = labels[i]
+				}
+				log.Printf("Bootstrap param %s: median=%.6e, CI95=[%.6e, %.6e], bias=%.3e, std=%.3e",
+					label, bs.Params[i], bs.CI95[i][0], bs.CI95[i][1], bs.Bias[i], bs.StdDev[i])
+			}
+		}
+	}
+
+	if cfg.Sensitivity && res.Status == goimpcore.OK && len(res.Params) > 0 {
+		sensitivity := goimpcore.SensitivityAnalysis(s, res, goimpcore.DefaultSensitivityStep)
+		res.SetPayload(goimpcore.PayloadSensitivity, sensitivity)
+		if !cfg.Quiet {
+			for _, sens := range sensitivity {
+				log.Printf("Sensitivity param %s: dChiSq/dParam=%.6e, relative=%.6e",
+					sens.ParamName, sens.DChiSqDParam, sens.RelativeSensitivity)
+			}
 		}
 	}
 
@@ -157,24 +347,31 @@ func runSingleOptimizationMethod(code string, freqs []float64, impData [][2]floa
 	return res
 }
 
-func runAllOptimizationMethods(code string, freqs []float64, impData [][2]float64, cfg *Config) goimpcore.Result {
+func runAllOptimizationMethods(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *Config) goimpcore.Result {
 	methods := []string{"nelder-mead", "levenberg-marquardt", "gradient-descent", "lbfgs", "newton"}
 	bestResult := goimpcore.Result{Min: 1e10} // Initialize with high value
+	bestScore := math.Inf(1)
 
 	log.Println("Running all optimization methods for comparison:")
 	log.Println(strings.Repeat("=", 60))
 
 	for _, method := range methods {
+		if ctx.Err() != nil {
+			log.Printf("Comparison cancelled, skipping remaining methods")
+			break
+		}
+
 		log.Printf("\n--- Testing %s ---", strings.ToUpper(method))
-		result := runSingleOptimizationMethod(code, freqs, impData, cfg, method)
+		result := runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, method)
 
 		if result.Status == "ERROR" {
 			log.Printf("Method: %-20s | FAILED", method)
 		} else {
-			log.Printf("Method: %-20s | Chi-square: %.12e | Params: %v",
-				method, result.Min, result.Params)
+			log.Printf("Method: %-20s | Chi-square: %.12e | AIC: %.4f | BIC: %.4f | Params: %v",
+				method, result.Min, result.Stats.AIC, result.Stats.BIC, result.Params)
 
-			if result.Min < bestResult.Min {
+			if score := result.Score(cfg.Criterion); score < bestScore {
+				bestScore = score
 				bestResult = result
 				bestResult.Code = method // Store the best method name
 			}
@@ -182,35 +379,109 @@ func runAllOptimizationMethods(code string, freqs []float64, impData [][2]float6
 	}
 
 	log.Println("\n" + strings.Repeat("=", 60))
-	log.Printf("BEST METHOD: %s with Chi-square: %.12e", bestResult.Code, bestResult.Min)
+	log.Printf("BEST METHOD: %s with Chi-square: %.12e, AIC: %.4f, BIC: %.4f (selected by %s)",
+		bestResult.Code, bestResult.Min, bestResult.Stats.AIC, bestResult.Stats.BIC, criterionName(cfg.Criterion))
 	log.Println(strings.Repeat("=", 60))
 
 	return bestResult
 }
 
-func parseFile(file string) (freqs []float64, impData [][2]float64) {
-	f, err := os.Open(file)
-	if err != nil {
-		log.Fatal(err)
+// compareCircuits fits the spectrum with every circuit of codes and returns the
+// fit of the best one by AIC, or BIC when -criterion bic, with the ranking in
+// its payload. A failed candidate does not stop the comparison.
+func compareCircuits(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, codes []string, cfg *Config) goimpcore.Result {
+	criterion := goimpcore.CriterionAIC
+	if cfg.Criterion == goimpcore.CriterionBIC {
+		criterion = goimpcore.CriterionBIC
 	}
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := scanner.Text()
-		var lineVals [3]float64
 
-		for i := 0; i < 3; i++ {
-			l := strings.Fields(line)
-			val, err := strconv.ParseFloat(l[i], 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			lineVals[i] = val
+	results, candidates := runAllCircuits(ctx, codes, freqs, impData, sigmas, cfg)
+	goimpcore.RankCandidates(candidates, criterion)
+	printComparison(candidates, criterion)
+	printModelSelection(goimpcore.ModelSelection(results, goimpcore.CountInWindow(freqs, cfg.FreqMin, cfg.FreqMax)))
+
+	best := goimpcore.Result{Status: "ERROR", Min: math.Inf(1), Params: []float64{}}
+	if len(candidates) > 0 && candidates[0].Rank == 1 {
+		best = results[candidates[0].Code]
+	}
+	best.SetPayload(goimpcore.PayloadCircuitRanking, candidates)
+	return best
+}
+
+// runAllCircuits fits the spectrum with every circuit of codes and returns the
+// fits by code, along with an unranked candidate per code. Invalid circuits
+// only get a failed candidate.
+func runAllCircuits(ctx context.Context, codes []string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *Config) (map[string]goimpcore.Result, []goimpcore.CircuitCandidate) {
+	results := make(map[string]goimpcore.Result, len(codes))
+	candidates := make([]goimpcore.CircuitCandidate, 0, len(codes))
+	for _, code := range codes {
+		code = strings.ToLower(code)
+		if ctx.Err() != nil {
+			candidates = append(candidates, goimpcore.NewCircuitCandidate(code, goimpcore.Result{}, ctx.Err()))
+			continue
 		}
-		//measData = append(measData, lineVals)
-		freqs = append(freqs, lineVals[0])
-		impData = append(impData, [2]float64{lineVals[1], lineVals[2]})
+		if err := goimpcore.ValidateCircuit(code); err != nil {
+			candidates = append(candidates, goimpcore.NewCircuitCandidate(code, goimpcore.Result{}, err))
+			continue
+		}
+
+		candCfg := *cfg
+		candCfg.Code = code
+		if len(candCfg.InitValues) != len(goimpcore.GetElements(code)) {
+			candCfg.InitValues = nil
+		}
+
+		var res goimpcore.Result
+		if cfg.OptimMethod == "all" {
+			res = runAllOptimizationMethods(ctx, code, freqs, impData, sigmas, &candCfg)
+		} else {
+			res = runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, &candCfg, cfg.OptimMethod)
+		}
+		results[code] = res
+		candidates = append(candidates, goimpcore.NewCircuitCandidate(code, res, nil))
+	}
+	return results, candidates
+}
+
+// printComparison prints the ranking of a circuit comparison as a table
+func printComparison(candidates []goimpcore.CircuitCandidate, criterion string) {
+	fmt.Println(strings.Repeat("=", 72))
+	fmt.Printf("%-4s  %-20s  %14s  %12s  %12s\n", "Rank", "Circuit", "Chi-square", "AIC", "BIC")
+	fmt.Println(strings.Repeat("-", 72))
+	for _, c := range candidates {
+		if c.Error != "" {
+			fmt.Printf("%-4s  %-20s  FAILED: %s\n", "-", strings.ToUpper(c.Code), c.Error)
+			continue
+		}
+		fmt.Printf("%-4d  %-20s  %14.6e  %12.4f  %12.4f\n", c.Rank, strings.ToUpper(c.Code), c.ChiSquare, c.AIC, c.BIC)
 	}
-	return freqs, impData
+	fmt.Println(strings.Repeat("=", 72))
+	if len(candidates) > 0 && candidates[0].Rank == 1 {
+		fmt.Printf("Best circuit: %s (selected by %s)\n", strings.ToUpper(candidates[0].Code), criterion)
+	} else {
+		fmt.Println("All candidate circuits failed")
+	}
+}
+
+// printModelSelection prints the Akaike weights of a model selection, the
+// probability of each circuit being the best of the set
+func printModelSelection(rank goimpcore.ModelRank) {
+	if len(rank) == 0 {
+		return
+	}
+	fmt.Printf("%-20s  %3s  %12s  %12s  %10s  %8s\n", "Circuit", "k", "AIC", "BIC", "dAIC", "Weight")
+	for _, m := range rank {
+		fmt.Printf("%-20s  %3d  %12.4f  %12.4f  %10.4f  %8.4f\n", strings.ToUpper(m.Code), m.NumParams, m.AIC, m.BIC, m.DeltaAIC, m.Weight)
+	}
+	fmt.Println(strings.Repeat("=", 72))
+}
+
+// criterionName returns the selection criterion, defaulting to chi-square
+func criterionName(criterion string) string {
+	if criterion == "" {
+		return goimpcore.CriterionChiSq
+	}
+	return criterion
 }
 
 // generateBenchmarkDescription creates a descriptive label for the benchmark test
@@ -236,6 +507,8 @@ func generateBenchmarkDescription(method, circuit string, initValues []float64,
 		description += ", Direct LM mode"
 	case "gd":
 		description += ", Direct GD mode"
+	case "nm+lm":
+		description += ", Hybrid NM+LM mode"
 	case "":
 		description += ", Base optimization mode"
 	default:
@@ -292,7 +565,7 @@ func getCircuitComplexityDescription(circuit string) string {
 			paramCount++
 		case 'q': // CPE has 2 parameters
 			paramCount += 2
-		case 'o', 't', 'g': // Two parameter elements
+		case 'o', 't', 'g', 'p': // Two parameter elements
 			paramCount += 2
 		case 'f': // Fractal has 3 parameters
 			paramCount += 3
@@ -308,14 +581,69 @@ func getCircuitComplexityDescription(circuit string) string {
 	}
 }
 
+// benchmarkHeader holds the columns of the benchmark file
+var benchmarkHeader = []string{
+	"Timestamp",
+	"Method",
+	"Circuit",
+	"Parameters",
+	"DataPoints",
+	"Duration_ms",
+	"ChiSquare",
+	"Success",
+	"Iterations",
+	"FuncEvals",
+	"Description",
+	"NMPhase_ms",
+	"LMPhase_ms",
+	"FittedParams",
+	"ReducedChiSquare",
+	"RMSE",
+	"NRMSE",
+	"R2",
+	"FitRating",
+}
+
+// rotateBenchmarkFile reports whether the benchmark file filename needs a
+// header. A file written with other columns, by an older version, is moved
+// aside with the time appended to its name so no rows are appended under a
+// header they do not match.
+func rotateBenchmarkFile(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	header, err := csv.NewReader(file).Read()
+	file.Close()
+	if err == io.EOF {
+		return true, nil
+	}
+	if err == nil && slices.Equal(header, benchmarkHeader) {
+		return false, nil
+	}
+
+	rotated := strings.TrimSuffix(filename, ".csv") + "." + time.Now().Format("20060102T150405") + ".csv"
+	if err := os.Rename(filename, rotated); err != nil {
+		return false, err
+	}
+	log.Printf("📊 Benchmark file %s has other columns, moved to %s", filename, rotated)
+	return true, nil
+}
+
 // saveBenchmarkResult saves timing and performance data to CSV
 func saveBenchmarkResult(method, circuit string, params, dataPoints int, duration time.Duration, result goimpcore.Result, description string) {
-	filename := "benchmark_results.csv"
+	writeBenchmarkResult("benchmark_results.csv", method, circuit, params, dataPoints, duration, result, description)
+}
 
-	// Check if file exists to decide on header
-	var writeHeader bool
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		writeHeader = true
+// writeBenchmarkResult appends the benchmark record of a fit to filename
+func writeBenchmarkResult(filename, method, circuit string, params, dataPoints int, duration time.Duration, result goimpcore.Result, description string) {
+	writeHeader, err := rotateBenchmarkFile(filename)
+	if err != nil {
+		log.Printf("Error checking benchmark file: %v", err)
+		return
 	}
 
 	// Open file for append
@@ -331,20 +659,7 @@ func saveBenchmarkResult(method, circuit string, params, dataPoints int, duratio
 
 	// Write header if new file
 	if writeHeader {
-		header := []string{
-			"Timestamp",
-			"Method",
-			"Circuit",
-			"Parameters",
-			"DataPoints",
-			"Duration_ms",
-			"ChiSquare",
-			"Success",
-			"Iterations",
-			"FuncEvals",
-			"Description",
-		}
-		if err := writer.Write(header); err != nil {
+		if err := writer.Write(benchmarkHeader); err != nil {
 			log.Printf("Error writing benchmark header: %v", err)
 			return
 		}
@@ -353,6 +668,8 @@ func saveBenchmarkResult(method, circuit string, params, dataPoints int, duratio
 	// Extract additional info from result payload
 	iterations := 0
 	funcEvals := 0
+	nmPhase := ""
+	lmPhase := ""
 	if result.Payload != nil {
 		if payload, ok := result.Payload.(map[string]interface{}); ok {
 			if iters, exists := payload["majorIterations"]; exists {
@@ -365,6 +682,13 @@ func saveBenchmarkResult(method, circuit string, params, dataPoints int, duratio
 					funcEvals = funcInt
 				}
 			}
+			// Phase durations are only reported by the hybrid NM+LM mode
+			if nm, ok := payload["nmDuration"].(time.Duration); ok {
+				nmPhase = fmt.Sprintf("%.6f", float64(nm.Nanoseconds())/1000000.0)
+			}
+			if lm, ok := payload["lmDuration"].(time.Duration); ok {
+				lmPhase = fmt.Sprintf("%.6f", float64(lm.Nanoseconds())/1000000.0)
+			}
 		}
 	}
 
@@ -381,6 +705,14 @@ func saveBenchmarkResult(method, circuit string, params, dataPoints int, duratio
 		strconv.Itoa(iterations),
 		strconv.Itoa(funcEvals),
 		description,
+		nmPhase,
+		lmPhase,
+		formatFittedParams(circuit, result.Params),
+		fmt.Sprintf("%.6e", result.Quality.ReducedChiSq),
+		fmt.Sprintf("%.6e", result.Quality.RMSE),
+		fmt.Sprintf("%.6e", result.Quality.NRMSE),
+		fmt.Sprintf("%.6f", result.Quality.R2),
+		result.Quality.Rating,
 	}
 
 	if err := writer.Write(record); err != nil {
@@ -388,31 +720,51 @@ func saveBenchmarkResult(method, circuit string, params, dataPoints int, duratio
 		return
 	}
 
-	log.Printf("📊 Benchmark: %s | %s | %d params | %.2f ms | Success: %v | %s",
-		method, circuit, params, float64(duration.Nanoseconds())/1000000.0, result.Status == "OK", description)
+	log.Printf("📊 Benchmark: %s | %s | %d params | %.2f ms | Success: %v | Fit: %s | %s",
+		method, circuit, params, float64(duration.Nanoseconds())/1000000.0, result.Status == "OK", result.Quality.Rating, description)
 }
 
-// generateInitialValues creates reasonable default initial values for different circuit codes
-func generateInitialValues(code string) []float64 {
-	switch strings.ToLower(code) {
-	case "r(cr)":
-		// R1, C1, R2
-		return []float64{50.0, 1e-6, 100.0}
-	case "r(qr)":
-		// R1, Q1_Y0, Q1_n, R2
-		return []float64{50.0, 1e-6, 0.8, 100.0}
-	case "r(cr)(cr)":
-		// R1, C1, R2, C2, R3 (5 parameters)
-		return []float64{50.0, 1e-6, 100.0, 1e-6, 100.0}
-	case "r(q(r(qr)))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	case "r(q(r(q(r(qr)))))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3, Q3_Y0, Q3_n, R4
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	default:
-		// Generic fallback: assume 7 parameters (medium complexity)
-		log.Printf("Warning: Unknown circuit code '%s', using generic 7-parameter defaults", code)
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
+// printParameters logs every fitted parameter with its name and unit
+func printParameters(code string, params []float64) {
+	info := goimpcore.ParameterInfo(code)
+	if len(info) != len(params) {
+		return
+	}
+	for i, p := range info {
+		log.Printf("  %-8s %-36s = %.6e %s", p.Label, p.Name, params[i], p.Unit)
+	}
+}
+
+// formatFittedParams formats params as label=value pairs separated by
+// semicolons, the benchmark file holding fits of different circuits
+func formatFittedParams(code string, params []float64) string {
+	info := goimpcore.ParameterInfo(code)
+	if len(info) != len(params) {
+		return ""
+	}
+	pairs := make([]string, len(params))
+	for i, p := range info {
+		pairs[i] = fmt.Sprintf("%s=%.12e", p.Label, params[i])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// generateInitialValues returns the circuit library defaults for code, or generic defaults for unknown circuits.
+// The first L element starts from the inductance of the inductive loop of the spectrum when it has one.
+func generateInitialValues(code string, freqs []float64, impData [][2]float64) []float64 {
+	if params := circuits.Default().DefaultParams(code); params != nil {
+		return goimpcore.InitInductance(code, params, freqs, impData)
+	}
+	// Generic fallback: assume 7 parameters (medium complexity)
+	log.Printf("Warning: Unknown circuit code '%s', using generic 7-parameter defaults", code)
+	return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
+}
+
+// iterationLimit returns the solver restarts of a fit, maxIterations unless
+// cfg sets them
+func iterationLimit(cfg *Config) int {
+	if cfg.MaxIterations > 0 {
+		return cfg.MaxIterations
 	}
+	return maxIterations
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// readBenchmark returns the records of the benchmark file filename
+func readBenchmark(t *testing.T, filename string) [][]string {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestWriteBenchmarkResult(t *testing.T) {
+	oldHeader := "Timestamp,Method,Circuit,Parameters,DataPoints,Duration_ms,ChiSquare,Success,Iterations,FuncEvals,Description\n" +
+		"2024-01-01T00:00:00Z,nelder-mead,R(QR),4,30,1.5,1e-3,true,100,200,old\n"
+	tests := []struct {
+		name     string
+		existing *string // contents of the file before the write, nil for none
+		writes   int
+		rows     int  // records below the header after the writes
+		rotated  bool // the existing file is moved aside
+	}{
+		{"new file", nil, 1, 1, false},
+		{"empty file", new(string), 1, 1, false},
+		{"current header", nil, 2, 2, false},
+		{"older header", &oldHeader, 1, 1, true},
+	}
+	result := goimpcore.Result{Status: goimpcore.OK, Params: []float64{10, 1e-5, 0.9, 100}, Min: 1e-6}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "benchmark_results.csv")
+			if tt.existing != nil {
+				if err := os.WriteFile(filename, []byte(*tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < tt.writes; i++ {
+				writeBenchmarkResult(filename, "nelder-mead", "R(QR)", 4, 30, time.Millisecond, result, "test")
+			}
+
+			records := readBenchmark(t, filename)
+			if !slices.Equal(records[0], benchmarkHeader) {
+				t.Fatalf("header %v, want %v", records[0], benchmarkHeader)
+			}
+			if len(records)-1 != tt.rows {
+				t.Fatalf("%d rows, want %d", len(records)-1, tt.rows)
+			}
+			for i, record := range records[1:] {
+				if len(record) != len(benchmarkHeader) {
+					t.Errorf("row %d has %d columns, the header %d", i+1, len(record), len(benchmarkHeader))
+				}
+			}
+
+			rotated, err := filepath.Glob(filepath.Join(dir, "benchmark_results.*.csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.rotated {
+				if len(rotated) != 0 {
+					t.Errorf("rotated files %v, want none", rotated)
+				}
+				return
+			}
+			if len(rotated) != 1 {
+				t.Fatalf("rotated files %v, want one", rotated)
+			}
+			if old, err := os.ReadFile(rotated[0]); err != nil || string(old) != *tt.existing {
+				t.Errorf("rotated file holds %q (%v), want the old contents", old, err)
+			}
+		})
+	}
+}
+
+// The simulate subcommand writes a spectrum the measurement reader reads back
+func TestRunSimulate(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "simulated.txt")
+	runSimulate([]string{"-c", "R(QR)", "-v", "10", "-v", "1e-5", "-v", "0.9", "-v", "100", "-fmin", "1", "-fmax", "1e5", "-ppd", "5", "-o", out})
+
+	freqs, impData, _, code, err := readMeasurement(&Config{Code: "R(QR)", InputFormat: "auto"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFreqs, want, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "R(QR)" || len(freqs) != len(wantFreqs) {
+		t.Fatalf("read %d points of %s, want %d of R(QR)", len(freqs), code, len(wantFreqs))
+	}
+	// Written with 6 significant digits
+	for i := range want {
+		got := [3]float64{freqs[i], impData[i][0], impData[i][1]}
+		exp := [3]float64{wantFreqs[i], want[i][0], want[i][1]}
+		for j := range got {
+			if math.Abs(got[j]-exp[j]) > 1e-5*math.Abs(exp[j]) {
+				t.Fatalf("point %d: %v, want %v", i, got, exp)
+			}
+		}
+	}
+}
+
+// A job panicking in processEISData fails with an error instead of taking the
+// worker down, and the next job is fitted
+func TestSafeProcessEISData(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	failed := safeProcessEISData(WorkItem{RequestID: "bad"}) // nil config
+	if failed.Status != "ERROR" {
+		t.Fatalf("status %s, want ERROR", failed.Status)
+	}
+	message, _ := failed.Payload.(map[string]interface{})[goimpcore.PayloadError].(string)
+	if !strings.Contains(message, "processing panicked") {
+		t.Errorf("error %q", message)
+	}
+
+	freqs := []float64{1, 10, 100, 1000}
+	impData := make([][2]float64, len(freqs))
+	for i := range impData {
+		impData[i] = [2]float64{100, 0}
+	}
+	result := safeProcessEISData(WorkItem{RequestID: "good", Freqs: freqs, ImpData: impData, Config: &Config{Code: "R", OptimMethod: "nelder-mead", InitValues: ArrayFlags{50}}})
+	if result.Status != goimpcore.OK || len(result.Params) != 1 || math.Abs(result.Params[0]-100) > 1e-3 {
+		t.Errorf("next job: status %s, params %v", result.Status, result.Params)
+	}
+}
+
+// Of circuits fitted to noisy R(QR) data, R(QR) has the lowest AIC: simpler
+// circuits fit worse and the extra parameters of larger ones do not pay off
+func TestModelSelectionPicksGenerator(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	freqs, err := goimpcore.LogFrequencies(0.1, 1e5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	impData := goimpcore.CircuitImpedanceNoisySeeded("r(qr)", freqs, []float64{10, 1e-5, 0.85, 1000}, 0, 0, true, 1)
+	codes := []string{"R(CR)", "R(QR)", "R(Q(R(QR)))", "R(QR)(QR)"}
+	cfg := &Config{OptimMethod: "nelder-mead"}
+
+	results, _ := runAllCircuits(context.Background(), codes, freqs, impData, nil, cfg)
+	rank := goimpcore.ModelSelection(results, len(freqs))
+	if len(rank) != len(codes) || rank[0].Code != "r(qr)" {
+		t.Fatalf("ranking %+v, want all %d circuits with r(qr) first", rank, len(codes))
+	}
+	if rank[0].Weight < 0.5 {
+		t.Errorf("r(qr) has Akaike weight %v", rank[0].Weight)
+	}
+}
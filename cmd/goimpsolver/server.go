@@ -1,18 +1,29 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
+	"github.com/kacperjurak/goimpcore/pkg/middleware"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/plot"
 )
 
 var (
@@ -27,6 +38,7 @@ type SpectrumTiming struct {
 	ChiSquare      float64       `json:"chi_square"`
 	Success        bool          `json:"success"`
 	CircuitCode    string        `json:"circuit_code"`
+	Outliers       int           `json:"outliers"` // points rejected by the robust mode
 }
 
 // WorkerPool manages a pool of workers for concurrent EIS processing
@@ -48,8 +60,12 @@ type WorkItem struct {
 	Iteration int
 	Freqs     []float64
 	ImpData   [][2]float64
+	Sigmas    [][2]float64
 	Config    *Config
 	StartTime time.Time
+	// Results, when set, receives the WorkResult instead of the pool's shared
+	// results channel so concurrent batches never see each other's results
+	Results chan<- WorkResult
 }
 
 // WorkResult contains the result of EIS processing
@@ -78,6 +94,13 @@ type WebhookItem struct {
 	Elements          []string
 	ElementImpedances []ElementImpedance
 	CircuitCode       string
+	Stats             goimpcore.FitStats
+	Quality           goimpcore.FitQuality
+	Residuals         [][2]float64
+	Warnings          []string
+	Ranking           []goimpcore.CircuitCandidate // set for circuit comparisons
+	Sensitivity       []goimpcore.Sensitivity      // set with -sensitivity
+	InductiveLoop     *goimpcore.InductiveLoopInfo // set when the spectrum has an inductive loop
 }
 
 // NewWorkerPool creates a new worker pool with specified number of workers
@@ -127,6 +150,9 @@ func (wp *WorkerPool) worker(id int) {
 	for {
 		select {
 		case job := <-wp.jobs:
+			metrics.QueueDepth.Dec()
+			metrics.ActiveWorkers.Inc()
+
 			// Get buffer from pool
 			buffers := wp.bufferPool.Get().(*BufferSet)
 
@@ -136,8 +162,10 @@ func (wp *WorkerPool) worker(id int) {
 
 			// Process EIS data
 			startTime := time.Now()
-			result := processEISData(job.Freqs, job.ImpData, job.Config)
+			result := safeProcessEISData(job)
 			processingTime := time.Since(startTime)
+			metrics.ObserveJob(job.Config.OptimMethod, processingTime, result.Status == goimpcore.OK, result.Min)
+			metrics.ActiveWorkers.Dec()
 
 			// Extract impedance data with pre-allocated buffers
 			if cap(buffers.Real) < len(job.ImpData) {
@@ -160,7 +188,7 @@ func (wp *WorkerPool) worker(id int) {
 			copy(imagCopy, buffers.Imag)
 
 			// Send result
-			wp.results <- WorkResult{
+			res := WorkResult{
 				ID:             job.ID,
 				RequestID:      job.RequestID,
 				BatchID:        job.BatchID,
@@ -171,7 +199,12 @@ func (wp *WorkerPool) worker(id int) {
 				Freqs:          job.Freqs,
 				RealImp:        realCopy,
 				ImagImp:        imagCopy,
-				CircuitCode:    job.Config.Code,
+				CircuitCode:    result.BestCircuit(job.Config.Code),
+			}
+			if job.Results != nil {
+				job.Results <- res
+			} else {
+				wp.results <- res
 			}
 
 			// Return buffers to pool
@@ -183,6 +216,24 @@ func (wp *WorkerPool) worker(id int) {
 	}
 }
 
+// safeProcessEISData fits job, converting a panic into a failed result so a
+// malformed job cannot kill the worker
+func safeProcessEISData(job WorkItem) (result goimpcore.Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Job %s (batch %s, iteration %d) panicked: %v\n%s", job.RequestID, job.BatchID, job.Iteration, r, debug.Stack())
+			result = goimpcore.Result{
+				Params:  []float64{},
+				Min:     math.Inf(1),
+				MinUnit: "ChiSq",
+				Status:  "ERROR",
+			}
+			result.SetPayload(goimpcore.PayloadError, fmt.Sprintf("processing panicked: %v", r))
+		}
+	}()
+	return processEISData(context.Background(), job.Freqs, job.ImpData, job.Sigmas, job.Config)
+}
+
 // webhookProcessor handles webhook requests asynchronously
 func (wp *WorkerPool) webhookProcessor() {
 	defer wp.wg.Done()
@@ -192,7 +243,7 @@ func (wp *WorkerPool) webhookProcessor() {
 		case webhook := <-wp.webhookQueue:
 			// Process webhook asynchronously without blocking workers
 			go sendWebhook(webhook.RequestID, webhook.ChiSquare, webhook.RealImp, webhook.ImagImp,
-				webhook.Freqs, webhook.Params, webhook.Elements, webhook.ElementImpedances, webhook.CircuitCode)
+				webhook.Freqs, webhook.Params, webhook.Elements, webhook.ElementImpedances, webhook.CircuitCode, webhook.Stats, webhook.Quality, webhook.Residuals, webhook.Warnings, webhook.Ranking, webhook.Sensitivity, webhook.InductiveLoop)
 
 		case <-wp.shutdown:
 			return
@@ -209,18 +260,27 @@ func (wp *WorkerPool) SubmitJob(job WorkItem) {
 		log.Printf("⚠️  Worker pool jobs channel full, job may be delayed")
 		wp.jobs <- job // Block until space available
 	}
+	metrics.JobsSubmitted.Inc()
+	metrics.QueueDepth.Inc()
 }
 
-// GetResult retrieves a result from the worker pool (non-blocking)
-func (wp *WorkerPool) GetResult() (WorkResult, bool) {
+// GetResultContext waits for the result of a job submitted without a result
+// channel, it returns ctx.Err() once ctx is done
+func (wp *WorkerPool) GetResultContext(ctx context.Context) (WorkResult, error) {
 	select {
 	case result := <-wp.results:
-		return result, true
-	default:
-		return WorkResult{}, false
+		return result, nil
+	case <-ctx.Done():
+		return WorkResult{}, ctx.Err()
 	}
 }
 
+// batchTimeout returns how long a batch of n spectra may take before its
+// missing spectra are recorded as failed
+func batchTimeout(n int) time.Duration {
+	return time.Minute + time.Duration(n)*10*time.Second
+}
+
 // QueueWebhook queues a webhook for async processing
 func (wp *WorkerPool) QueueWebhook(webhook WebhookItem) {
 	select {
@@ -252,12 +312,33 @@ type ImpedanceBatch struct {
 	Spectra   []BatchItem `json:"spectra"`
 }
 
+// envDefaults returns the default port and webhook URL, overridden by
+// GOIMP_PORT and GOIMP_WEBHOOK_URL when set. Flags take precedence over both.
+func envDefaults() (int, string) {
+	port, err := config.PortFromEnv(config.DefaultPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return port, config.WebhookURLFromEnv(config.DefaultWebhookURL)
+}
+
 func startHTTPServer(cfg *Config) {
+	if err := config.ValidatePort(cfg.Port); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ValidateWebhookURL(cfg.WebhookURL); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Workers < 0 {
+		log.Fatalf("Invalid -workers %d", cfg.Workers)
+	}
 	globalConfig = cfg
 
 	// Initialize optimized worker pool
 	workerCount := 5
-	if cfg.Threads > 0 {
+	if cfg.Workers > 0 {
+		workerCount = cfg.Workers
+	} else if cfg.Threads > 0 {
 		workerCount = int(cfg.Threads)
 	}
 	globalWorkerPool = NewWorkerPool(workerCount)
@@ -269,14 +350,31 @@ func startHTTPServer(cfg *Config) {
 	}()
 
 	http.HandleFunc("/eis-data", handleEISData)
+	http.HandleFunc("/eis-data/upload", handleEISDataUpload)
 	http.HandleFunc("/eis-data/batch", handleBatchEISData)
+	http.HandleFunc("/eis-data/bode", handleBodeData)
 
-	log.Println("🚀 Starting HTTP server on port 8080...")
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		log.Fatal("❌ Failed to start server:", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	log.Printf("🚀 Starting HTTP server on port %d...", port)
 	log.Println("📡 Endpoints available:")
-	log.Println("  - Single: http://localhost:8080/eis-data")
-	log.Println("  - Batch:  http://localhost:8080/eis-data/batch")
+	log.Printf("  - Single: http://localhost:%d/eis-data", port)
+	log.Printf("  - Upload: http://localhost:%d/eis-data/upload", port)
+	log.Printf("  - Batch:  http://localhost:%d/eis-data/batch", port)
+	log.Printf("  - Bode:   http://localhost:%d/eis-data/bode", port)
+
+	var handler http.Handler = middleware.BodyLimitMiddleware(config.DefaultMaxRequestBodyBytes)(http.DefaultServeMux)
+	if cfg.Metrics {
+		http.Handle("/metrics", metrics.Handler())
+		handler = metrics.Middleware(http.DefaultServeMux, handler)
+		log.Printf("  - Metrics: http://localhost:%d/metrics", port)
+	}
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.Serve(ln, handler); err != nil {
 		log.Fatal("❌ Failed to start server:", err)
 	}
 }
@@ -299,42 +397,62 @@ func handleEISData(w http.ResponseWriter, r *http.Request) {
 
 	var impedanceData ImpedanceData
 	if err := json.NewDecoder(r.Body).Decode(&impedanceData); err != nil {
-		http.Error(w, `{"error":"Invalid JSON format"}`, http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	acceptEISData(w, r, impedanceData)
+}
 
-	if len(impedanceData.Frequencies) == 0 {
-		http.Error(w, `{"error":"No data points provided"}`, http.StatusBadRequest)
+// acceptEISData validates impedanceData, starts its fit and answers 202 with
+// the request ID, the webhook carrying the result
+func acceptEISData(w http.ResponseWriter, r *http.Request, impedanceData ImpedanceData) {
+	if err := checkPoints(len(impedanceData.Frequencies)); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if errs := models.ValidateImpedanceData(models.ImpedanceData(impedanceData)); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	impData, err := impedanceData.Points()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	cfg := requestConfig(globalConfig, impedanceData)
+	if err := validateFit(impedanceData.Frequencies, cfg); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
 		return
 	}
 
 	// Generate unique ID for this request
 	requestID := generateID()
-
-	// Convert ImpedanceData to internal format
 	freqs := impedanceData.Frequencies
-	impData := make([][2]float64, len(impedanceData.Impedance))
 
-	for i, point := range impedanceData.Impedance {
-		impData[i] = [2]float64{point["real"], point["imag"]}
-	}
+	// The response is written before processing finishes, which cancels r.Context(),
+	// so keep its values but detach the cancellation from the request lifetime
+	ctx := context.WithoutCancel(r.Context())
 
 	// Process data asynchronously and send webhook
 	go func() {
-		result := processEISData(freqs, impData, globalConfig)
+		result := processEISData(ctx, freqs, impData, impedanceData.Sigmas(), cfg)
 
 		// Extract real and imaginary parts for webhook
-		realImp := make([]float64, len(impedanceData.Impedance))
-		imagImp := make([]float64, len(impedanceData.Impedance))
-		for i, imp := range impedanceData.Impedance {
-			realImp[i] = imp["real"]
-			imagImp[i] = imp["imag"]
+		realImp := make([]float64, len(impData))
+		imagImp := make([]float64, len(impData))
+		for i, imp := range impData {
+			realImp[i] = imp[0]
+			imagImp[i] = imp[1]
 		}
 
 		// Use actual chi-square from EIS processing result
-		elements := goimpcore.GetElements(strings.ToLower(globalConfig.Code))
-		elementImpedances := calculateElementImpedances(freqs, result.Params, elements)
-		sendWebhook(requestID, result.Min, realImp, imagImp, freqs, result.Params, elements, elementImpedances, globalConfig.Code)
+		code := result.BestCircuit(cfg.Code)
+		elements := goimpcore.GetElements(strings.ToLower(code))
+		elementImpedances := calculateElementImpedances(code, freqs, result.Params)
+		sendWebhook(requestID, result.Min, realImp, imagImp, freqs, result.Params, elements, elementImpedances, code, result.Stats, result.Quality, result.Residuals, result.Warnings, result.Ranking(), result.Sensitivity(), result.InductiveLoop)
 	}()
 
 	// Return immediate response with request ID
@@ -370,107 +488,167 @@ func handleBatchEISData(w http.ResponseWriter, r *http.Request) {
 
 	var batch ImpedanceBatch
 	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		http.Error(w, `{"error":"Invalid JSON format"}`, http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	if len(batch.Spectra) > config.DefaultMaxBatchSpectra {
+		http.Error(w, fmt.Sprintf(`{"error":"batch has %d spectra, at most %d are accepted"}`, len(batch.Spectra), config.DefaultMaxBatchSpectra), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for _, item := range batch.Spectra {
+		if err := checkPoints(len(item.ImpedanceData.Frequencies)); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"spectrum %d: %s"}`, item.Iteration, err), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
 
-	if len(batch.Spectra) == 0 {
-		http.Error(w, `{"error":"No spectra provided in batch"}`, http.StatusBadRequest)
+	spectra := make([]models.BatchItem, len(batch.Spectra))
+	for i, item := range batch.Spectra {
+		spectra[i] = models.BatchItem{ImpedanceData: models.ImpedanceData(item.ImpedanceData), Iteration: item.Iteration}
+	}
+	if errs := models.ValidateImpedanceBatch(models.ImpedanceBatch{Spectra: spectra}); len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
 
+	for _, item := range batch.Spectra {
+		cfg := requestConfig(globalConfig, item.ImpedanceData)
+		if err := validateFit(item.ImpedanceData.Frequencies, cfg); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"spectrum %d: %s"}`, item.Iteration, err), http.StatusBadRequest)
+			return
+		}
+		if _, err := item.ImpedanceData.Points(); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"spectrum %d: %s"}`, item.Iteration, err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	log.Printf("🔄 Batch processing started - ID: %s, Spectra: %d", batch.BatchID, len(batch.Spectra))
 
 	// Start timing for performance measurement
 	batchStartTime := time.Now()
 
-	// Prepare data structures for optimized processing
+	// Prepare data structures for optimized processing. Timings are kept in
+	// Iteration order, iterations need not start at 0 nor be dense.
 	spectrumTimings := make([]SpectrumTiming, len(batch.Spectra))
-	resultsReceived := 0
+	iterations := make([]int, len(batch.Spectra))
+	for i, item := range batch.Spectra {
+		iterations[i] = item.Iteration
+	}
+	sort.Ints(iterations)
+	timingIndex := make(map[int]int, len(iterations))
+	for i, iteration := range iterations {
+		timingIndex[iteration] = i
+		spectrumTimings[i].Iteration = iteration
+	}
+
+	// Batch-scoped result channel, buffered for the whole batch so workers never
+	// block on it, even after a timeout, and results from other concurrent
+	// batches cannot arrive here
+	batchResults := make(chan WorkResult, len(batch.Spectra))
 
 	// Process batch using optimized worker pool
 	go func() {
-		// Submit all jobs to worker pool
-		for _, item := range batch.Spectra {
-			// Convert to internal format with optimized data transformation
-			freqs := item.ImpedanceData.Frequencies
-			impData := make([][2]float64, len(item.ImpedanceData.Impedance))
-
-			log.Printf("DEBUG: Processing spectrum %d with %d frequencies and %d impedance points",
-				item.Iteration, len(freqs), len(item.ImpedanceData.Impedance))
-
-			// Optimized data conversion - single pass
-			for i, point := range item.ImpedanceData.Impedance {
-				realVal, realOk := point["real"]
-				imagVal, imagOk := point["imag"]
-
-				if !realOk || !imagOk {
-					log.Printf("ERROR: Invalid impedance point format at index %d: %+v", i, point)
-					continue
+		// Submit all jobs to worker pool, SubmitJob blocks while the queue is
+		// full so results are collected meanwhile
+		go func() {
+			for _, item := range batch.Spectra {
+				// Convert to internal format with optimized data transformation
+				freqs := item.ImpedanceData.Frequencies
+				impData, _ := item.ImpedanceData.Points() // validated when the batch was accepted
+
+				log.Printf("DEBUG: Processing spectrum %d with %d frequencies and %d impedance points",
+					item.Iteration, len(freqs), len(impData))
+
+				for i, point := range impData {
+					if math.IsNaN(point[0]) || math.IsInf(point[0], 0) || math.IsNaN(point[1]) || math.IsInf(point[1], 0) {
+						log.Printf("WARNING: Invalid impedance values at index %d: real=%v, imag=%v", i, point[0], point[1])
+					}
 				}
 
-				if math.IsNaN(realVal) || math.IsInf(realVal, 0) || math.IsNaN(imagVal) || math.IsInf(imagVal, 0) {
-					log.Printf("WARNING: Invalid impedance values at index %d: real=%v, imag=%v", i, realVal, imagVal)
+				// Create work item for worker pool
+				job := WorkItem{
+					ID:        item.Iteration,
+					RequestID: generateID(),
+					BatchID:   batch.BatchID,
+					Iteration: item.Iteration,
+					Freqs:     freqs,
+					ImpData:   impData,
+					Sigmas:    item.ImpedanceData.Sigmas(),
+					Config:    requestConfig(globalConfig, item.ImpedanceData),
+					StartTime: time.Now(),
+					Results:   batchResults,
 				}
 
-				impData[i] = [2]float64{realVal, imagVal}
+				// Submit to worker pool
+				globalWorkerPool.SubmitJob(job)
 			}
-
-			// Create work item for worker pool
-			job := WorkItem{
-				ID:        item.Iteration,
-				RequestID: generateID(),
-				BatchID:   batch.BatchID,
-				Iteration: item.Iteration,
-				Freqs:     freqs,
-				ImpData:   impData,
-				Config:    globalConfig,
-				StartTime: time.Now(),
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), batchTimeout(len(batch.Spectra)))
+		defer cancel()
+
+		// Collect results for this batch only
+		received := make(map[int]bool, len(batch.Spectra))
+	collect:
+		for resultsReceived := 0; resultsReceived < len(batch.Spectra); resultsReceived++ {
+			var result WorkResult
+			select {
+			case result = <-batchResults:
+			case <-ctx.Done():
+				log.Printf("⚠️ Batch %s timed out after %v with %d of %d results",
+					batch.BatchID, batchTimeout(len(batch.Spectra)), resultsReceived, len(batch.Spectra))
+				break collect
 			}
+			received[result.Iteration] = true
 
-			// Submit to worker pool
-			globalWorkerPool.SubmitJob(job)
-		}
-
-		// Collect results from worker pool
-		for resultsReceived < len(batch.Spectra) {
-			if result, ok := globalWorkerPool.GetResult(); ok {
-				// Record timing (lock-free via channels)
-				spectrumTimings[result.Iteration] = SpectrumTiming{
+			// Record timing (lock-free via channels)
+			if i, ok := timingIndex[result.Iteration]; ok {
+				spectrumTimings[i] = SpectrumTiming{
 					Iteration:      result.Iteration,
 					ProcessingTime: result.ProcessingTime,
 					ChiSquare:      result.Result.Min,
 					Success:        result.Success,
 					CircuitCode:    result.CircuitCode,
+					Outliers:       len(result.Result.Excluded),
 				}
+			}
 
-				// Queue webhook for async processing
-				elements := goimpcore.GetElements(strings.ToLower(globalConfig.Code))
-				elementImpedances := calculateElementImpedances(result.Freqs, result.Result.Params, elements)
-
-				webhook := WebhookItem{
-					RequestID:         fmt.Sprintf("%s_iter_%03d", result.RequestID, result.Iteration),
-					ChiSquare:         result.Result.Min,
-					RealImp:           result.RealImp,
-					ImagImp:           result.ImagImp,
-					Freqs:             result.Freqs,
-					Params:            result.Result.Params,
-					Elements:          elements,
-					ElementImpedances: elementImpedances,
-					CircuitCode:       result.CircuitCode,
-				}
+			// Queue webhook for async processing
+			elements := goimpcore.GetElements(strings.ToLower(result.CircuitCode))
+			elementImpedances := calculateElementImpedances(result.CircuitCode, result.Freqs, result.Result.Params)
+
+			webhook := WebhookItem{
+				RequestID:         fmt.Sprintf("%s_iter_%03d", result.RequestID, result.Iteration),
+				ChiSquare:         result.Result.Min,
+				RealImp:           result.RealImp,
+				ImagImp:           result.ImagImp,
+				Freqs:             result.Freqs,
+				Params:            result.Result.Params,
+				Elements:          elements,
+				ElementImpedances: elementImpedances,
+				CircuitCode:       result.CircuitCode,
+				Stats:             result.Result.Stats,
+				Quality:           result.Result.Quality,
+				Residuals:         result.Result.Residuals,
+				Warnings:          result.Result.Warnings,
+				Ranking:           result.Result.Ranking(),
+				Sensitivity:       result.Result.Sensitivity(),
+				InductiveLoop:     result.Result.InductiveLoop,
+			}
 
-				globalWorkerPool.QueueWebhook(webhook)
+			globalWorkerPool.QueueWebhook(webhook)
 
-				if !globalConfig.Quiet {
-					log.Printf("✅ Processed spectrum iteration %d - Chi-square: %.6e",
-						result.Iteration, result.Result.Min)
-				}
+			if !globalConfig.Quiet {
+				log.Printf("✅ Processed spectrum iteration %d - Chi-square: %.6e",
+					result.Iteration, result.Result.Min)
+			}
+		}
 
-				resultsReceived++
-			} else {
-				// No results available yet, small delay to prevent busy waiting
-				time.Sleep(1 * time.Millisecond)
+		// Spectra without a result are recorded as failed
+		for _, item := range batch.Spectra {
+			if !received[item.Iteration] {
+				spectrumTimings[timingIndex[item.Iteration]] = SpectrumTiming{Iteration: item.Iteration}
 			}
 		}
 
@@ -501,6 +679,199 @@ func handleBatchEISData(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// BodeRequest asks for the Bode plot of inline data, fitted with Code unless Params are given
+type BodeRequest struct {
+	RequestID string `json:"request_id,omitempty"`
+	ImpedanceData
+	Code   string    `json:"code,omitempty"`
+	Params []float64 `json:"params,omitempty"`
+}
+
+// writeDecodeError answers a request body that failed to decode, with 413 when
+// it exceeded the body size limit
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		http.Error(w, fmt.Sprintf(`{"error":"Request body exceeds %d bytes"}`, maxErr.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, `{"error":"Invalid JSON format"}`, http.StatusBadRequest)
+}
+
+// checkPoints checks the number of frequencies of one spectrum against the
+// default server limit
+func checkPoints(n int) error {
+	if n > config.DefaultMaxFrequencyPoints {
+		return fmt.Errorf("spectrum has %d frequencies, at most %d are accepted", n, config.DefaultMaxFrequencyPoints)
+	}
+	return nil
+}
+
+func handleBodeData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if err := checkPoints(len(req.Frequencies)); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if req.RequestID != "" {
+		// Results are only delivered by webhook, nothing is kept to look up
+		http.Error(w, `{"error":"Results are not stored, send the data inline"}`, http.StatusNotFound)
+		return
+	}
+
+	freqs := req.Frequencies
+	if len(freqs) == 0 {
+		http.Error(w, `{"error":"No data points provided"}`, http.StatusBadRequest)
+		return
+	}
+	impData, err := req.Points()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	code := req.Code
+	if code == "" {
+		code = globalConfig.Code
+	}
+
+	params := req.Params
+	if len(params) == 0 {
+		cfg := *requestConfig(globalConfig, req.ImpedanceData)
+		cfg.Code = code
+		if err := validateFit(freqs, &cfg); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		res := processEISData(r.Context(), freqs, impData, req.Sigmas(), &cfg)
+		if res.Status == goimpcore.OK {
+			params = res.Params
+		} else {
+			log.Printf("Bode: fit of %s failed, returning measured data only", code)
+		}
+	}
+
+	var fitted [][2]float64
+	if len(params) > 0 {
+		if len(params) != len(goimpcore.GetElements(strings.ToLower(code))) {
+			http.Error(w, `{"error":"Parameter count does not match the circuit code"}`, http.StatusBadRequest)
+			return
+		}
+		fitted = goimpcore.CircuitImpedance(strings.ToLower(code), freqs, params)
+	}
+
+	bode := plot.FittedBodeData(freqs, impData, fitted)
+	bode.Code = code
+	bode.Params = params
+
+	json.NewEncoder(w).Encode(bode)
+}
+
+// requestConfig returns cfg with the fit window and robust mode overridden by the
+// request, when set
+func requestConfig(cfg *Config, data ImpedanceData) *Config {
+	reqCfg := *cfg
+	if data.FreqMin != 0 || data.FreqMax != 0 {
+		reqCfg.FreqMin = data.FreqMin
+		reqCfg.FreqMax = data.FreqMax
+	}
+	if data.Robust {
+		reqCfg.Robust = true
+	}
+	if data.Space != "" {
+		reqCfg.Space = data.Space
+	}
+	if len(data.CircuitCodes) > 0 {
+		reqCfg.Code = strings.Join(data.CircuitCodes, ",")
+	} else if data.CircuitCode != "" {
+		reqCfg.Code = data.CircuitCode
+	}
+	switch {
+	case len(data.InitValues) > 0:
+		reqCfg.InitValues = append(ArrayFlags(nil), data.InitValues...)
+	case reqCfg.Code != cfg.Code:
+		// The server initial values belong to the server circuit
+		reqCfg.InitValues = nil
+	}
+	if data.OptimMethod != "" {
+		reqCfg.OptimMethod = data.OptimMethod
+	}
+	if data.Weighting != "" {
+		reqCfg.Weighting = data.Weighting
+	}
+	if data.MaxIterations != 0 {
+		reqCfg.MaxIterations = data.MaxIterations
+	}
+	return &reqCfg
+}
+
+// writeValidationErrors writes a 422 response listing every invalid field
+func writeValidationErrors(w http.ResponseWriter, errs []models.ValidationError) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":             "Invalid impedance data",
+		"validation_errors": errs,
+	})
+}
+
+// validateFit checks that the fit window of cfg keeps at least one frequency
+// and that its fit space is known
+func validateFit(freqs []float64, cfg *Config) error {
+	if cfg.FreqMin < 0 || cfg.FreqMax < 0 || (cfg.FreqMax > 0 && cfg.FreqMin > cfg.FreqMax) {
+		return fmt.Errorf("invalid frequency window freq_min %v freq_max %v", cfg.FreqMin, cfg.FreqMax)
+	}
+	if goimpcore.CountInWindow(freqs, cfg.FreqMin, cfg.FreqMax) == 0 {
+		return fmt.Errorf("%v: freq_min %v freq_max %v", goimpcore.ErrEmptyWindow, cfg.FreqMin, cfg.FreqMax)
+	}
+	if _, err := goimpcore.ParseSpace(cfg.Space); err != nil {
+		return err
+	}
+	codes := goimpcore.ParseCircuitCodes(cfg.Code)
+	if len(codes) == 0 {
+		return fmt.Errorf("no circuit code")
+	}
+	for _, c := range codes {
+		if err := goimpcore.ValidateCircuit(strings.ToLower(c)); err != nil {
+			return fmt.Errorf("invalid circuit code %q: %v", c, err)
+		}
+	}
+	if len(codes) == 1 && len(cfg.InitValues) > 0 {
+		if n := len(goimpcore.GetElements(strings.ToLower(codes[0]))); len(cfg.InitValues) != n {
+			return fmt.Errorf("init_values has %d values, circuit %s has %d parameters", len(cfg.InitValues), codes[0], n)
+		}
+	}
+	if cfg.OptimMethod != "" && !config.ValidOptimMethod(cfg.OptimMethod) {
+		return fmt.Errorf("unknown optim_method %q, expected one of %s", cfg.OptimMethod, strings.Join(config.OptimMethods, ", "))
+	}
+	if _, err := goimpcore.ParseWeighting(cfg.Weighting); err != nil {
+		return err
+	}
+	if cfg.MaxIterations < 0 {
+		return fmt.Errorf("max_iterations must not be negative, got %d", cfg.MaxIterations)
+	}
+	return nil
+}
+
 // saveConcurrentTimingResults saves timing data to a CSV file for performance analysis
 func saveConcurrentTimingResults(batchID string, totalTime time.Duration, spectrumTimings []SpectrumTiming, concurrency int) {
 	filename := "concurrent_timing_results.csv"
@@ -538,6 +909,8 @@ func saveConcurrentTimingResults(batchID string, totalTime time.Duration, spectr
 			"SpectraPerSecond",
 			"EfficiencyScore",
 			"CircuitCode",
+			"TotalOutliers",
+			"OutliersPerSpectrum",
 		}
 		if err := writer.Write(header); err != nil {
 			log.Printf("Error writing timing header: %v", err)
@@ -548,10 +921,13 @@ func saveConcurrentTimingResults(batchID string, totalTime time.Duration, spectr
 	// Calculate statistics
 	var totalSpectrumTime time.Duration
 	var minTime, maxTime time.Duration = time.Hour, 0
-	var successful int
+	var successful, totalOutliers int
 	var totalChiSq float64
+	outliers := make([]string, len(spectrumTimings))
 
-	for _, timing := range spectrumTimings {
+	for i, timing := range spectrumTimings {
+		totalOutliers += timing.Outliers
+		outliers[i] = strconv.Itoa(timing.Outliers)
 		totalSpectrumTime += timing.ProcessingTime
 		if timing.ProcessingTime < minTime {
 			minTime = timing.ProcessingTime
@@ -601,6 +977,8 @@ func saveConcurrentTimingResults(batchID string, totalTime time.Duration, spectr
 		fmt.Sprintf("%.2f", spectraPerSecond),
 		fmt.Sprintf("%.3f", efficiencyScore),
 		circuitCode,
+		fmt.Sprintf("%d", totalOutliers),
+		strings.Join(outliers, ";"),
 	}
 
 	if err := writer.Write(record); err != nil {
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resistorSpectrum is a 100 Ω resistor measured at five frequencies, fitted
+// as R
+func resistorSpectrum() ImpedanceData {
+	data := ImpedanceData{Frequencies: []float64{1, 10, 100, 1000, 10000}, CircuitCode: "R", InitValues: []float64{50}}
+	for range data.Frequencies {
+		data.Impedance = append(data.Impedance, map[string]float64{"real": 100, "imag": 0})
+	}
+	return data
+}
+
+// Batches numbered from 1 or with gaps are fitted completely, every webhook
+// carrying the client's iteration number, and repeated iterations are
+// rejected
+func TestBatchIterationNumbering(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil { // the timing CSV is written here
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	var mu sync.Mutex
+	var ids []string
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var webhook WebhookResponse
+		json.NewDecoder(r.Body).Decode(&webhook)
+		mu.Lock()
+		ids = append(ids, webhook.ID)
+		mu.Unlock()
+	}))
+	defer sink.Close()
+
+	// globalConfig is left in place, webhooks may still be sent when the
+	// test returns
+	oldPool := globalWorkerPool
+	globalConfig = &Config{Code: "R", OptimMethod: "nelder-mead", WebhookURL: sink.URL, Quiet: true}
+	globalWorkerPool = NewWorkerPool(2)
+	defer func() {
+		globalWorkerPool.Shutdown()
+		globalWorkerPool = oldPool
+	}()
+
+	duplicates := ImpedanceBatch{BatchID: "duplicates", Spectra: []BatchItem{
+		{ImpedanceData: resistorSpectrum(), Iteration: 1},
+		{ImpedanceData: resistorSpectrum(), Iteration: 1},
+	}}
+	body, _ := json.Marshal(duplicates)
+	rec := httptest.NewRecorder()
+	handleBatchEISData(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity || !bytes.Contains(rec.Body.Bytes(), []byte("duplicates iteration 1")) {
+		t.Errorf("repeated iterations: status %d, body %s", rec.Code, rec.Body)
+	}
+
+	iterationID := regexp.MustCompile(`_iter_(\d+)$`)
+	tests := []struct {
+		name       string
+		iterations []int
+	}{
+		{"one-based", []int{1, 2, 3}},
+		{"sparse", []int{40, 7, 1000, 15}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			ids = nil
+			mu.Unlock()
+
+			batch := ImpedanceBatch{BatchID: tt.name}
+			for _, iteration := range tt.iterations {
+				batch.Spectra = append(batch.Spectra, BatchItem{ImpedanceData: resistorSpectrum(), Iteration: iteration})
+			}
+			body, _ := json.Marshal(batch)
+			rec := httptest.NewRecorder()
+			handleBatchEISData(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body)))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+
+			var got []int
+			for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				mu.Lock()
+				n := len(ids)
+				mu.Unlock()
+				if n >= len(tt.iterations) {
+					break
+				}
+			}
+			mu.Lock()
+			for _, id := range ids {
+				if m := iterationID.FindStringSubmatch(id); m != nil {
+					iteration, _ := strconv.Atoi(m[1])
+					got = append(got, iteration)
+				}
+			}
+			mu.Unlock()
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(tt.iterations))
+			if !slices.Equal(got, want) {
+				t.Errorf("webhooks of iterations %v, want %v", got, want)
+			}
+
+			// The timing row is the batch's last act, every spectrum succeeded
+			var row []string
+			for deadline := time.Now().Add(5 * time.Second); row == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if records := readTimings(t); len(records) > 1+i && len(records[1+i]) > 8 {
+					row = records[1+i]
+				}
+			}
+			if row == nil {
+				t.Fatal("no timing row")
+			}
+			if row[1] != tt.name || row[2] != strconv.Itoa(len(tt.iterations)) || row[8] != "100.0" {
+				t.Errorf("timing row %v, want batch %s, %d spectra, all succeeded", row, tt.name, len(tt.iterations))
+			}
+		})
+	}
+}
+
+// readTimings returns the records of the timing CSV in the working directory
+func readTimings(t *testing.T) [][]string {
+	t.Helper()
+	file, err := os.Open("concurrent_timing_results.csv")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil
+	}
+	return records
+}
+
+// A job with its own result channel delivers there and leaves nothing on the
+// pool's shared channel for another batch to collect
+func TestJobResultsChannel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	pool := NewWorkerPool(1)
+	defer pool.Shutdown()
+
+	impData := make([][2]float64, 5)
+	for i := range impData {
+		impData[i] = [2]float64{100, 0}
+	}
+	results := make(chan WorkResult, 1)
+	pool.SubmitJob(WorkItem{
+		RequestID: "own",
+		BatchID:   "batch-a",
+		Iteration: 3,
+		Freqs:     []float64{1, 10, 100, 1000, 10000},
+		ImpData:   impData,
+		Config:    &Config{Code: "R", OptimMethod: "nelder-mead", InitValues: ArrayFlags{50}, Quiet: true},
+		Results:   results,
+	})
+
+	select {
+	case res := <-results:
+		if res.BatchID != "batch-a" || res.Iteration != 3 || !res.Success {
+			t.Errorf("result of batch %s, iteration %d, success %v", res.BatchID, res.Iteration, res.Success)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no result on the job's channel")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if res, err := pool.GetResultContext(ctx); err == nil {
+		t.Errorf("result of batch %s on the shared channel", res.BatchID)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/internal/fileio"
+)
+
+// uploadMemory is how much of an uploaded form is kept in memory, larger
+// files are buffered in temporary files
+const uploadMemory = 1 << 20
+
+// handleEISDataUpload fits a measurement file uploaded as multipart/form-data
+// in the file field, without a JSON client:
+//
+//	curl -F "file=@data.dta" -F "circuit=R(QR)" http://localhost:8080/eis-data/upload
+//
+// The format is detected from the file name, see fileio.DetectFormat. The
+// circuit, method and initvalues (comma separated) fields override the server
+// settings. The response is the one of /eis-data.
+func handleEISDataUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "multipart/form-data" {
+		http.Error(w, `{"error":"Expected a multipart/form-data upload with a file field"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := r.ParseMultipartForm(uploadMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, fmt.Sprintf(`{"error":"Request body exceeds %d bytes"}`, maxErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "Invalid multipart form: "+err.Error()), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, `{"error":"Missing file field"}`, http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	impedanceData, err := uploadedData(r, file, header)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	acceptEISData(w, r, impedanceData)
+}
+
+// uploadedData reads the measurement file of an upload and applies the fit
+// settings of its form fields
+func uploadedData(r *http.Request, file multipart.File, header *multipart.FileHeader) (ImpedanceData, error) {
+	freqs, impData, sigmas, code, err := readFormat(globalConfig, fileio.DetectFormat(header.Filename), header.Filename, file)
+	if err != nil {
+		return ImpedanceData{}, fmt.Errorf("%s: %v", header.Filename, err)
+	}
+
+	data := ImpedanceData{
+		Frequencies: freqs,
+		Impedance:   make([]map[string]float64, len(impData)),
+		CircuitCode: code,
+		OptimMethod: r.FormValue("method"),
+	}
+	for i, point := range impData {
+		data.Impedance[i] = map[string]float64{"real": point[0], "imag": point[1]}
+	}
+	for _, sigma := range sigmas {
+		data.Sigma = append(data.Sigma, map[string]float64{"real": sigma[0], "imag": sigma[1]})
+	}
+	if circuit := r.FormValue("circuit"); circuit != "" {
+		data.CircuitCode = circuit
+	}
+	if values := r.FormValue("initvalues"); values != "" {
+		for _, value := range strings.Split(values, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return ImpedanceData{}, fmt.Errorf("invalid initvalues %q: %v", values, err)
+			}
+			data.InitValues = append(data.InitValues, v)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// watchExtensions are the extensions of the measurement files fitted by -watch
+var watchExtensions = map[string]bool{".txt": true, ".dta": true, ".mpt": true, ".z": true}
+
+const (
+	// watchSettle is how long a file must stay unchanged before it is fitted,
+	// instruments writing to a network share create a file before filling it
+	watchSettle = 500 * time.Millisecond
+	// watchQueueSize is how many settled files may wait for the fit running
+	watchQueueSize = 64
+)
+
+// isWatchedFile reports whether path is a measurement file fitted by -watch
+func isWatchedFile(path string) bool {
+	return watchExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// runWatch fits every measurement file created, moved into or rewritten in dir
+// with cfg and writes its result to <input>_result.json, see runFiles. Files
+// are fitted one after another in the order they settle, the ones already in
+// dir first with -process-existing. With -watch-webhook each result is also sent to
+// -webhook-url. runWatch returns on SIGINT or SIGTERM.
+func runWatch(cfg *Config, dir string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watchDir(ctx, cfg, dir)
+}
+
+// watchDir is runWatch returning once ctx is done
+func watchDir(ctx context.Context, cfg *Config, dir string) error {
+	if cfg.WatchWebhook {
+		if err := config.ValidateWebhookURL(cfg.WebhookURL); err != nil {
+			return err
+		}
+		globalConfig = cfg
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating watcher: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	// The queue is never closed, settle timers may still fire while
+	// stopping, the fit running then finishes before runWatch returns
+	queue := make(chan string, watchQueueSize)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case file := <-queue:
+				processWatchedFile(cfg, file)
+			}
+		}
+	}()
+	pending := newSettleTimers(ctx, queue)
+	defer func() {
+		pending.stopAll()
+		wg.Wait()
+	}()
+
+	if cfg.ProcessExisting {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			log.Printf("Failed to list %s: %v", dir, err)
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && isWatchedFile(entry.Name()) {
+				if !enqueue(ctx, queue, filepath.Join(dir, entry.Name())) {
+					return nil
+				}
+			}
+		}
+	}
+
+	log.Printf("Watching %s for .txt, .dta, .mpt and .z files", dir)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped watching %s", dir)
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Watch error: %v", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !isWatchedFile(event.Name) {
+				continue
+			}
+			switch {
+			case event.Has(fsnotify.Create), event.Has(fsnotify.Write):
+				pending.reset(event.Name)
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				// A renamed file arrives again as a Create of its new name
+				if pending.cancel(event.Name) {
+					log.Printf("%s: removed before it settled, not fitted", event.Name)
+				}
+			}
+		}
+	}
+}
+
+// enqueue waits for room for file in queue, reporting false when ctx ended
+// first
+func enqueue(ctx context.Context, queue chan<- string, file string) bool {
+	select {
+	case queue <- file:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// settleTimers queues a file once watchSettle passed since its last change
+type settleTimers struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	queue  chan<- string
+}
+
+func newSettleTimers(ctx context.Context, queue chan<- string) *settleTimers {
+	return &settleTimers{ctx: ctx, timers: make(map[string]*time.Timer), queue: queue}
+}
+
+// reset restarts the settle time of file
+func (s *settleTimers) reset(file string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.timers[file]; ok {
+		t.Reset(watchSettle)
+		return
+	}
+	s.timers[file] = time.AfterFunc(watchSettle, func() {
+		s.mu.Lock()
+		_, ok := s.timers[file]
+		delete(s.timers, file)
+		s.mu.Unlock()
+		if ok {
+			enqueue(s.ctx, s.queue, file)
+		}
+	})
+}
+
+// cancel drops the pending fit of file, reporting whether there was one
+func (s *settleTimers) cancel(file string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.timers[file]
+	if ok {
+		t.Stop()
+		delete(s.timers, file)
+	}
+	return ok
+}
+
+// stopAll drops every pending fit, the files not settled yet are not fitted
+func (s *settleTimers) stopAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for file, t := range s.timers {
+		t.Stop()
+		delete(s.timers, file)
+	}
+}
+
+// processWatchedFile fits file and writes its result next to it. A file gone
+// before its turn is skipped.
+func processWatchedFile(cfg *Config, file string) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		log.Printf("%s: removed before it was fitted, skipped", file)
+		return
+	}
+
+	res, fit := fitFile(cfg, file)
+	if res.Error != "" {
+		log.Printf("%s: %s", file, res.Error)
+	}
+	path := resultPath(file)
+	if err := writeResultFile(path, res); err != nil {
+		log.Printf("Failed to write %s: %v", path, err)
+		return
+	}
+	log.Printf("%s: %s, result written to %s", file, res.Status, path)
+
+	if cfg.WatchWebhook && fit != nil {
+		result := fit.result
+		realImp := make([]float64, len(fit.impData))
+		imagImp := make([]float64, len(fit.impData))
+		for i, imp := range fit.impData {
+			realImp[i] = imp[0]
+			imagImp[i] = imp[1]
+		}
+		code := res.Circuit
+		elements := goimpcore.GetElements(strings.ToLower(code))
+		elementImpedances := calculateElementImpedances(code, fit.freqs, result.Params)
+		sendWebhook(generateID(), result.Min, realImp, imagImp, fit.freqs, result.Params, elements, elementImpedances, code, result.Stats, result.Quality, result.Residuals, result.Warnings, result.Ranking(), result.Sensitivity(), result.InductiveLoop)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// resistorLines is a 100 Ω resistor as FormatText lines
+const resistorLines = "1 100 0\n10 100 0\n100 100 0\n1000 100 0\n10000 100 0\n"
+
+// waitResultFile waits for the result written for file and decodes it
+func waitResultFile(t *testing.T, file string) fileResult {
+	t.Helper()
+	path := resultPath(file)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var res fileResult
+		if json.Unmarshal(data, &res) == nil {
+			return res
+		}
+	}
+	t.Fatalf("no result written to %s", path)
+	return fileResult{}
+}
+
+// Files present at startup with -process-existing, and files created in the
+// watched directory and filled in several writes, are fitted into
+// <input>_result.json. Other extensions and files removed before they
+// settle are not fitted.
+func TestWatchDir(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte(resistorLines), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{Code: "R", InputFormat: "auto", OptimMethod: "nelder-mead", InitValues: ArrayFlags{50}, ProcessExisting: true, Quiet: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchDir(ctx, cfg, dir) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Once it is fitted, the directory is watched
+	if res := waitResultFile(t, existing); res.Status != goimpcore.OK {
+		t.Fatalf("existing file: %+v", res)
+	}
+
+	ignored, err := os.CreateTemp(dir, "notes-*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignored.WriteString(resistorLines)
+	ignored.Close()
+
+	removed, err := os.CreateTemp(dir, "removed-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed.Close()
+	os.Remove(removed.Name())
+
+	created, err := os.CreateTemp(dir, "cell-*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(resistorLines, "\n")
+	created.WriteString(strings.Join(lines[:2], ""))
+	time.Sleep(watchSettle / 5)
+	created.WriteString(strings.Join(lines[2:], ""))
+	created.Close()
+
+	res := waitResultFile(t, created.Name())
+	if res.Status != goimpcore.OK || len(res.Parameters) != 1 || res.Parameters[0] < 99 || res.Parameters[0] > 101 {
+		t.Errorf("created file: %+v", res)
+	}
+	for _, file := range []string{ignored.Name(), removed.Name()} {
+		if _, err := os.Stat(resultPath(file)); !os.IsNotExist(err) {
+			t.Errorf("%s was fitted", filepath.Base(file))
+		}
+	}
+}
@@ -7,29 +7,48 @@ import (
 	"encoding/json"
 	"log"
 	"math"
-	"math/cmplx"
 	"net/http"
 	"time"
-)
 
-const webhookURL = "http://webplot:3001/webhook"
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/formalism"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
+)
 
 type ElementImpedance struct {
 	Name       string               `json:"name"`
+	Path       string               `json:"path,omitempty"` // enclosing groups, see goimpcore.ElementContribution
 	Impedances []map[string]float64 `json:"impedances"`
 }
 
 type WebhookResponse struct {
-	ID                 string             `json:"id"`
-	Time               string             `json:"time"`
-	ChiSquare          float64            `json:"chi_square"`
-	RealImpedance      []float64          `json:"real_impedance"`
-	ImaginaryImpedance []float64          `json:"imaginary_impedance"`
-	Frequencies        []float64          `json:"frequencies"`
-	Parameters         []float64          `json:"parameters"`
-	ElementNames       []string           `json:"element_names"`
-	ElementImpedances  []ElementImpedance `json:"element_impedances"`
-	CircuitType        string             `json:"circuit_type"`
+	Type               string                `json:"type"` // always spectrum_result
+	ID                 string                `json:"id"`
+	Time               string                `json:"time"`
+	ChiSquare          float64               `json:"chi_square"`
+	RealImpedance      []float64             `json:"real_impedance"`
+	ImaginaryImpedance []float64             `json:"imaginary_impedance"`
+	Frequencies        []float64             `json:"frequencies"`
+	Parameters         []float64             `json:"parameters"`
+	ElementNames       []string              `json:"element_names"`
+	ElementImpedances  []ElementImpedance    `json:"element_impedances"`
+	CircuitType        string                `json:"circuit_type"`
+	Formalism          string                `json:"formalism"`
+	FitStats           *goimpcore.FitStats   `json:"fit_stats,omitempty"`
+	FitQuality         *goimpcore.FitQuality `json:"fit_quality,omitempty"`
+	ResidualsReal      []float64             `json:"residuals_real,omitempty"`
+	ResidualsImag      []float64             `json:"residuals_imag,omitempty"`
+	Warnings           []string              `json:"warnings,omitempty"`
+
+	ElementContributions []goimpcore.ElementContrib   `json:"element_contributions,omitempty"` // always impedance, whatever the formalism
+	ParameterInfo        []goimpcore.ParamInfo        `json:"parameter_info,omitempty"`        // names and units of Parameters
+	CircuitRanking       []goimpcore.CircuitCandidate `json:"circuit_ranking,omitempty"`       // candidates of a circuit comparison, best first
+	Sensitivity          []goimpcore.Sensitivity      `json:"sensitivity,omitempty"`           // set with -sensitivity
+
+	// InductiveLoopDetected and EstimatedL report an inductive loop of the
+	// spectrum, see goimpcore.DetectInductiveLoop
+	InductiveLoopDetected bool     `json:"inductive_loop_detected,omitempty"`
+	EstimatedL            *float64 `json:"estimated_L,omitempty"`
 }
 
 func generateID() string {
@@ -40,104 +59,130 @@ func generateID() string {
 	return hex.EncodeToString(b)
 }
 
-// calculateElementImpedances calculates impedance for each circuit element
-func calculateElementImpedances(frequencies []float64, parameters []float64, elementNames []string) []ElementImpedance {
-	var result []ElementImpedance
+// calculateElementImpedances returns the impedance of every element and group
+// of the circuit, see goimpcore.DecomposeImpedance
+func calculateElementImpedances(code string, frequencies []float64, parameters []float64) []ElementImpedance {
+	contributions, err := goimpcore.DecomposeImpedance(code, frequencies, parameters)
+	if err != nil {
+		log.Printf("Warning: Element impedances of %s unavailable: %v", code, err)
+		return nil
+	}
 
-	for i, elementName := range elementNames {
-		if i >= len(parameters) {
-			break
+	result := make([]ElementImpedance, len(contributions))
+	for i, contrib := range contributions {
+		impedances := make([]map[string]float64, len(contrib.Impedance))
+		for j, z := range contrib.Impedance {
+			impedances[j] = map[string]float64{
+				"real": sanitizeFloat(z[0]),
+				"imag": sanitizeFloat(z[1]),
+			}
 		}
+		result[i] = ElementImpedance{Name: contrib.Name, Path: contrib.Path, Impedances: impedances}
+	}
+	return result
+}
 
-		var impedances []map[string]float64
-		for _, freq := range frequencies {
-			w := 2 * math.Pi * freq
-			var impedance complex128
-
-			// Calculate impedance based on element type
-			switch elementName {
-			case "r": // Resistance
-				impedance = complex(parameters[i], 0)
-			case "c": // Capacitance
-				if parameters[i] != 0 {
-					impedance = complex(1, 0) / (complex(0, 1) * complex(w, 0) * complex(parameters[i], 0))
-				}
-			case "l": // Inductance
-				impedance = complex(0, 1) * complex(w, 0) * complex(parameters[i], 0)
-			case "w": // Warburg
-				if parameters[i] != 0 {
-					sqrt_jw := complex(math.Sqrt(w/2), math.Sqrt(w/2))
-					impedance = complex(1, 0) / (complex(parameters[i], 0) * sqrt_jw)
-				}
-			case "qy": // CPE Y parameter - skip, will be combined with qn
-				continue
-			case "qn": // CPE n parameter - calculate full CPE impedance
-				if i > 0 && elementNames[i-1] == "qy" {
-					qY := parameters[i-1] // Previous parameter is Y0
-					qN := parameters[i]   // Current parameter is n
-					if qY != 0 {
-						// Z_CPE = 1 / (Q * (jω)^n)
-						jwPowN := cmplx.Pow(complex(0, w), complex(qN, 0))
-						impedance = complex(1, 0) / (complex(qY, 0) * jwPowN)
-					}
-				}
-			default:
-				// Handle other element types by first character
-				switch elementName[0] {
-				case 'r':
-					impedance = complex(parameters[i], 0)
-				case 'c':
-					if parameters[i] != 0 {
-						impedance = complex(1, 0) / (complex(0, 1) * complex(w, 0) * complex(parameters[i], 0))
-					}
-				case 'l':
-					impedance = complex(0, 1) * complex(w, 0) * complex(parameters[i], 0)
-				case 'w':
-					if parameters[i] != 0 {
-						sqrt_jw := complex(math.Sqrt(w/2), math.Sqrt(w/2))
-						impedance = complex(1, 0) / (complex(parameters[i], 0) * sqrt_jw)
-					}
-				default:
-					impedance = complex(0, 0)
-				}
+// convertElementImpedances transforms element impedances into the configured formalism
+func convertElementImpedances(elementImpedances []ElementImpedance, frequencies []float64) []ElementImpedance {
+	result := make([]ElementImpedance, len(elementImpedances))
+	for i, elem := range elementImpedances {
+		data := make([][2]float64, len(elem.Impedances))
+		for j, imp := range elem.Impedances {
+			data[j] = [2]float64{imp["real"], imp["imag"]}
+		}
+		converted, err := formalism.Convert(globalConfig.Formalism, data, frequencies, globalConfig.C0)
+		if err != nil {
+			log.Printf("Warning: Formalism conversion failed for element %s (%v)", elem.Name, err)
+			result[i] = elem
+			continue
+		}
+		impedances := make([]map[string]float64, len(converted))
+		for j, v := range converted {
+			impedances[j] = map[string]float64{
+				"real": sanitizeFloat(v[0]),
+				"imag": sanitizeFloat(v[1]),
 			}
+		}
+		result[i] = ElementImpedance{Name: elem.Name, Path: elem.Path, Impedances: impedances}
+	}
+	return result
+}
 
-			// Handle NaN, Inf values for JSON compatibility
-			realPart := real(impedance)
-			imagPart := imag(impedance)
+// sanitizeFloat replaces NaN and Inf values with 0.0 for JSON compatibility
+func sanitizeFloat(value float64) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0.0
+	}
+	return value
+}
 
-			if math.IsNaN(realPart) || math.IsInf(realPart, 0) {
-				log.Printf("Warning: Invalid real impedance (%v) for element %s at freq %.2f Hz, setting to 0.0", realPart, elementName, freq)
-				realPart = 0.0
-			}
-			if math.IsNaN(imagPart) || math.IsInf(imagPart, 0) {
-				log.Printf("Warning: Invalid imaginary impedance (%v) for element %s at freq %.2f Hz, setting to 0.0", imagPart, elementName, freq)
-				imagPart = 0.0
-			}
+// sanitizeStats cleans fit statistics for JSON, nil when no statistics were computed
+func sanitizeStats(stats goimpcore.FitStats) *goimpcore.FitStats {
+	if stats.N == 0 {
+		return nil
+	}
+	stats.WeightedSSR = sanitizeFloat(stats.WeightedSSR)
+	stats.ReducedChiSq = sanitizeFloat(stats.ReducedChiSq)
+	stats.RSquared = sanitizeFloat(stats.RSquared)
+	stats.AIC = sanitizeFloat(stats.AIC)
+	stats.BIC = sanitizeFloat(stats.BIC)
+	stats.RMSE = sanitizeFloat(stats.RMSE)
+	stats.NRMSE = sanitizeFloat(stats.NRMSE)
+	return &stats
+}
 
-			impedances = append(impedances, map[string]float64{
-				"real": realPart,
-				"imag": imagPart,
-			})
-		}
+// sanitizeQuality cleans the fit quality for JSON, nil when it was not rated
+func sanitizeQuality(quality goimpcore.FitQuality) *goimpcore.FitQuality {
+	if quality.Rating == "" {
+		return nil
+	}
+	quality.ChiSq = sanitizeFloat(quality.ChiSq)
+	quality.ReducedChiSq = sanitizeFloat(quality.ReducedChiSq)
+	quality.RMSE = sanitizeFloat(quality.RMSE)
+	quality.NRMSE = sanitizeFloat(quality.NRMSE)
+	quality.R2 = sanitizeFloat(quality.R2)
+	return &quality
+}
 
-		// Only add element if we calculated impedances (skip qy parameter)
-		if len(impedances) > 0 {
-			displayName := elementName
-			if elementName == "qn" {
-				displayName = "Q" // Show CPE as Q instead of qn
-			}
-			result = append(result, ElementImpedance{
-				Name:       displayName,
-				Impedances: impedances,
-			})
+// sanitizeSlice sanitizes every value of a slice in place
+// sanitizeContributions replaces invalid values of the element contributions
+func sanitizeContributions(contributions []goimpcore.ElementContrib) []goimpcore.ElementContrib {
+	for _, ec := range contributions {
+		for i, z := range ec.Impedances {
+			ec.Impedances[i] = [2]float64{sanitizeFloat(z[0]), sanitizeFloat(z[1])}
 		}
+		sanitizeSlice(ec.MagnitudeFraction)
 	}
+	return contributions
+}
 
-	return result
+// sanitizeRanking replaces invalid statistics of the circuit candidates
+func sanitizeRanking(ranking []goimpcore.CircuitCandidate) []goimpcore.CircuitCandidate {
+	for i := range ranking {
+		ranking[i].ChiSquare = sanitizeFloat(ranking[i].ChiSquare)
+		ranking[i].AIC = sanitizeFloat(ranking[i].AIC)
+		ranking[i].BIC = sanitizeFloat(ranking[i].BIC)
+	}
+	return ranking
+}
+
+// sanitizeSensitivity replaces invalid derivatives of the parameter sensitivities
+func sanitizeSensitivity(sensitivity []goimpcore.Sensitivity) []goimpcore.Sensitivity {
+	for i := range sensitivity {
+		sensitivity[i].DChiSqDParam = sanitizeFloat(sensitivity[i].DChiSqDParam)
+		sensitivity[i].RelativeSensitivity = sanitizeFloat(sensitivity[i].RelativeSensitivity)
+	}
+	return sensitivity
+}
+
+func sanitizeSlice(values []float64) []float64 {
+	for i, v := range values {
+		values[i] = sanitizeFloat(v)
+	}
+	return values
 }
 
-func sendWebhook(requestID string, chiSquare float64, realImp []float64, imagImp []float64, frequencies []float64, parameters []float64, elementNames []string, elementImpedances []ElementImpedance, circuitType string) {
+func sendWebhook(requestID string, chiSquare float64, realImp []float64, imagImp []float64, frequencies []float64, parameters []float64, elementNames []string, elementImpedances []ElementImpedance, circuitType string, stats goimpcore.FitStats, quality goimpcore.FitQuality, residuals [][2]float64, warnings []string, ranking []goimpcore.CircuitCandidate, sensitivity []goimpcore.Sensitivity, loop *goimpcore.InductiveLoopInfo) {
 	// Handle NaN, Inf and other invalid float64 values for JSON marshaling
 	validChiSquare := chiSquare
 	if math.IsNaN(chiSquare) || math.IsInf(chiSquare, 0) {
@@ -145,7 +190,21 @@ func sendWebhook(requestID string, chiSquare float64, realImp []float64, imagImp
 		log.Printf("Warning: Chi-square is invalid (%v), setting to 0.0 for JSON", chiSquare)
 	}
 
+	// Transform data into the configured formalism
+	outFormalism := formalism.Impedance
+	if globalConfig.Formalism != "" && globalConfig.Formalism != formalism.Impedance {
+		convReal, convImag, err := formalism.ConvertParts(globalConfig.Formalism, realImp, imagImp, frequencies, globalConfig.C0)
+		if err != nil {
+			log.Printf("Warning: Formalism conversion failed (%v), sending impedance data", err)
+		} else {
+			realImp, imagImp = sanitizeSlice(convReal), sanitizeSlice(convImag)
+			elementImpedances = convertElementImpedances(elementImpedances, frequencies)
+			outFormalism = globalConfig.Formalism
+		}
+	}
+
 	webhookData := WebhookResponse{
+		Type:               "spectrum_result",
 		ID:                 requestID,
 		Time:               time.Now().Format(time.RFC3339Nano),
 		ChiSquare:          validChiSquare,
@@ -156,6 +215,29 @@ func sendWebhook(requestID string, chiSquare float64, realImp []float64, imagImp
 		ElementNames:       elementNames,
 		ElementImpedances:  elementImpedances,
 		CircuitType:        circuitType,
+		Formalism:          outFormalism,
+		FitStats:           sanitizeStats(stats),
+		FitQuality:         sanitizeQuality(quality),
+		Warnings:           warnings,
+
+		ElementContributions: sanitizeContributions(goimpcore.ElementContributions(circuitType, frequencies, parameters)),
+		ParameterInfo:        goimpcore.ParameterInfo(circuitType),
+		CircuitRanking:       sanitizeRanking(ranking),
+		Sensitivity:          sanitizeSensitivity(sensitivity),
+	}
+
+	if loop != nil {
+		inductance := sanitizeFloat(loop.Inductance)
+		webhookData.InductiveLoopDetected, webhookData.EstimatedL = true, &inductance
+	}
+
+	if len(residuals) > 0 {
+		webhookData.ResidualsReal = make([]float64, len(residuals))
+		webhookData.ResidualsImag = make([]float64, len(residuals))
+		for i, r := range residuals {
+			webhookData.ResidualsReal[i] = sanitizeFloat(r[0])
+			webhookData.ResidualsImag[i] = sanitizeFloat(r[1])
+		}
 	}
 
 	jsonData, err := json.Marshal(webhookData)
@@ -166,16 +248,22 @@ func sendWebhook(requestID string, chiSquare float64, realImp []float64, imagImp
 
 	// Debug: Log a sample of the JSON payload to verify CircuitType is included
 	if !globalConfig.Quiet {
-		log.Printf("DEBUG: Webhook JSON sample - CircuitType: %s, ElementNames: %v", 
+		log.Printf("DEBUG: Webhook JSON sample - CircuitType: %s, ElementNames: %v",
 			webhookData.CircuitType, webhookData.ElementNames)
 	}
 
-	resp, err := http.Post(webhookURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := http.Post(globalConfig.WebhookURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
+		metrics.WebhooksFailed.Inc()
 		log.Printf("Error sending webhook: %v", err)
 		return
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		metrics.WebhooksFailed.Inc()
+	} else {
+		metrics.WebhooksDelivered.Inc()
+	}
 
 	if !globalConfig.Quiet {
 		log.Printf("Webhook sent - ID: %s, Chi-square: %.14e, CircuitType: %s, Status: %d", requestID, chiSquare, circuitType, resp.StatusCode)
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// The webhook impedance of every multi-parameter element matches the core
+// impedance of the element alone at 10 frequencies
+func TestCalculateElementImpedances(t *testing.T) {
+	freqs := []float64{1e-2, 1e-1, 1, 10, 100, 1e3, 1e4, 1e5, 1e6, 1e7}
+	tests := []struct {
+		code   string
+		params []float64
+	}{
+		{"Q", []float64{1e-5, 0.85}},
+		{"G", []float64{2e-3, 50}},
+		{"O", []float64{1e-2, 0.5}},
+		{"T", []float64{1e-2, 0.5}},
+		{"F", []float64{2e-3, 50, 0.4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			elements := calculateElementImpedances("R"+tt.code, freqs, append([]float64{100}, tt.params...))
+			want := goimpcore.CircuitImpedance(strings.ToLower(tt.code), freqs, tt.params)
+			for _, element := range elements {
+				if element.Name != tt.code+"1" {
+					continue
+				}
+				for i, z := range element.Impedances {
+					d := math.Hypot(z["real"]-want[i][0], z["imag"]-want[i][1])
+					if d > 1e-12*math.Hypot(want[i][0], want[i][1]) {
+						t.Errorf("at %g Hz: %v, want %v", freqs[i], z, want[i])
+					}
+				}
+				return
+			}
+			t.Errorf("no element %s1 in %d elements", tt.code, len(elements))
+		})
+	}
+}
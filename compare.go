@@ -0,0 +1,157 @@
+package goimpcore
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// PayloadCircuitRanking is the Result payload key of the candidate ranking of
+// a circuit comparison
+const PayloadCircuitRanking = "circuit_ranking"
+
+// CircuitCandidate is the outcome of fitting one candidate circuit of a
+// comparison
+type CircuitCandidate struct {
+	Code      string    `json:"code"`
+	Rank      int       `json:"rank"` // 1 for the best fit, 0 when the fit failed
+	ChiSquare float64   `json:"chi_square"`
+	AIC       float64   `json:"aic"`
+	BIC       float64   `json:"bic"`
+	Params    []float64 `json:"params,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NewCircuitCandidate summarizes the fit res of code, err marks a failed fit
+func NewCircuitCandidate(code string, res Result, err error) CircuitCandidate {
+	c := CircuitCandidate{Code: code}
+	switch {
+	case err != nil:
+		c.Error = err.Error()
+	case res.Status != OK:
+		c.Error = "fit failed with status " + res.Status
+	default:
+		c.ChiSquare = res.Min
+		c.AIC = res.Stats.AIC
+		c.BIC = res.Stats.BIC
+		c.Params = res.Params
+	}
+	return c
+}
+
+// Score returns the value used to rank c by criterion, lower is better.
+// Failed fits score +Inf.
+func (c CircuitCandidate) Score(criterion string) float64 {
+	if c.Error != "" {
+		return math.Inf(1)
+	}
+	switch criterion {
+	case CriterionChiSq:
+		return c.ChiSquare
+	case CriterionBIC:
+		return c.BIC
+	}
+	return c.AIC
+}
+
+// RankCandidates sorts candidates best first by criterion, AIC unless BIC or
+// chi-square is asked for, and numbers the successful ones from 1
+func RankCandidates(candidates []CircuitCandidate, criterion string) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score(criterion) < candidates[j].Score(criterion)
+	})
+	for i := range candidates {
+		if candidates[i].Error == "" {
+			candidates[i].Rank = i + 1
+		}
+	}
+}
+
+// ParseCircuitCodes splits a comma separated list of circuit codes like
+// "R(QR),R(QR)(QR)", dropping empty entries
+func ParseCircuitCodes(codes string) []string {
+	var res []string
+	for _, code := range strings.Split(codes, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			res = append(res, code)
+		}
+	}
+	return res
+}
+
+// ModelScore is the information criteria of one circuit fit of a model selection
+type ModelScore struct {
+	Code      string  `json:"code"`
+	ChiSq     float64 `json:"chi_square"`
+	NumParams int     `json:"num_params"`
+	AIC       float64 `json:"aic"`
+	BIC       float64 `json:"bic"`
+	DeltaAIC  float64 `json:"delta_aic"` // AIC above the best model
+	Weight    float64 `json:"weight"`    // Akaike weight, the probability that the model is the best of the set
+}
+
+// ModelRank is a model selection, sorted by AIC best first
+type ModelRank []ModelScore
+
+// ModelSelection scores the circuit fits of results, keyed by circuit code, of
+// a spectrum of nDataPoints points with
+//
+//	AIC = 2k + n·ln(χ²/n)
+//	BIC = k·ln(n) + n·ln(χ²/n)
+//
+// where k is the number of fitted parameters. Failed fits are left out.
+func ModelSelection(results map[string]Result, nDataPoints int) ModelRank {
+	n := float64(nDataPoints)
+	rank := make(ModelRank, 0, len(results))
+	for code, res := range results {
+		if res.Status != OK || nDataPoints <= 0 || math.IsNaN(res.Min) || math.IsInf(res.Min, 0) {
+			continue
+		}
+		k := float64(len(res.Params))
+		logLik := n * math.Log(res.Min/n)
+		rank = append(rank, ModelScore{
+			Code:      code,
+			ChiSq:     res.Min,
+			NumParams: len(res.Params),
+			AIC:       2*k + logLik,
+			BIC:       k*math.Log(n) + logLik,
+		})
+	}
+	sort.Slice(rank, func(i, j int) bool {
+		if rank[i].AIC != rank[j].AIC {
+			return rank[i].AIC < rank[j].AIC
+		}
+		return rank[i].Code < rank[j].Code
+	})
+	if len(rank) == 0 {
+		return rank
+	}
+
+	var total float64
+	for i := range rank {
+		rank[i].DeltaAIC = rank[i].AIC - rank[0].AIC
+		rank[i].Weight = math.Exp(-rank[i].DeltaAIC / 2)
+		total += rank[i].Weight
+	}
+	for i := range rank {
+		rank[i].Weight /= total
+	}
+	return rank
+}
+
+// Ranking returns the candidate ranking stored by a circuit comparison, nil
+// for a single circuit fit
+func (r Result) Ranking() []CircuitCandidate {
+	payload, _ := r.Payload.(map[string]interface{})
+	ranking, _ := payload[PayloadCircuitRanking].([]CircuitCandidate)
+	return ranking
+}
+
+// BestCircuit returns the winning code of a circuit comparison, code when r
+// is not a comparison or every candidate failed
+func (r Result) BestCircuit(code string) string {
+	if ranking := r.Ranking(); len(ranking) > 0 && ranking[0].Rank == 1 {
+		return ranking[0].Code
+	}
+	return code
+}
@@ -0,0 +1,42 @@
+package goimpcore
+
+import (
+	"math"
+	"testing"
+)
+
+// The scores follow the AIC and BIC formulas, sorted by AIC with Akaike
+// weights summing to 1, and failed fits are left out
+func TestModelSelection(t *testing.T) {
+	results := map[string]Result{
+		"r(cr)":       {Status: OK, Min: 2.0, Params: make([]float64, 3)},
+		"r(qr)":       {Status: OK, Min: 0.5, Params: make([]float64, 4)},
+		"r(qr)(qr)":   {Status: OK, Min: 0.49, Params: make([]float64, 7)},
+		"r(q(r(qr)))": {Status: "ERROR", Min: math.Inf(1)},
+	}
+	const n = 30
+	rank := ModelSelection(results, n)
+	if len(rank) != 3 {
+		t.Fatalf("%d scores, want the 3 successful fits", len(rank))
+	}
+	wantOrder := []string{"r(qr)", "r(qr)(qr)", "r(cr)"}
+	var total float64
+	for i, score := range rank {
+		if score.Code != wantOrder[i] {
+			t.Errorf("rank %d is %s, want %s", i+1, score.Code, wantOrder[i])
+		}
+		k := float64(score.NumParams)
+		aic := 2*k + n*math.Log(score.ChiSq/n)
+		bic := k*math.Log(n) + n*math.Log(score.ChiSq/n)
+		if math.Abs(score.AIC-aic) > 1e-12 || math.Abs(score.BIC-bic) > 1e-12 {
+			t.Errorf("%s: AIC %v BIC %v, want %v %v", score.Code, score.AIC, score.BIC, aic, bic)
+		}
+		if math.Abs(score.DeltaAIC-(score.AIC-rank[0].AIC)) > 1e-12 {
+			t.Errorf("%s: delta AIC %v", score.Code, score.DeltaAIC)
+		}
+		total += score.Weight
+	}
+	if math.Abs(total-1) > 1e-12 || rank[0].Weight <= rank[1].Weight {
+		t.Errorf("weights %v %v %v", rank[0].Weight, rank[1].Weight, rank[2].Weight)
+	}
+}
@@ -0,0 +1,191 @@
+package goimpcore
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"unicode"
+)
+
+// ElementContribution is the impedance of one element or bracketed group of a
+// circuit. Path lists the enclosing groups from the outside in, "series" for
+// the top level. Elements and groups on the same path combine in that path's
+// mode, so the top level entries sum to the circuit impedance.
+type ElementContribution struct {
+	Name      string       `json:"name"` // element label like Q1, or the group code like (QR)
+	Group     bool         `json:"group"`
+	Path      string       `json:"path"`
+	Params    []float64    `json:"params,omitempty"`
+	Impedance [][2]float64 `json:"impedance"`
+}
+
+// DecomposeImpedance returns the impedance of every element and bracketed
+// group of the circuit described by code, in code order
+func DecomposeImpedance(code string, freqs, params []float64) ([]ElementContribution, error) {
+	c, err := CompileCircuit(code)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) < c.params {
+		return nil, fmt.Errorf("circuit: %s needs %d values, got %d", c.code, c.params, len(params))
+	}
+
+	var (
+		entries = make([]ElementContribution, 0, len(c.ops))
+		entryOf = make([]int, len(c.ops)) // entry index of every push and element op
+		open    []int                     // entries of the enclosing groups
+		paths   = []string{"series"}
+		counts  = make(map[rune]int)
+		groups  int
+	)
+	for i, o := range c.ops {
+		switch o.kind {
+		case opPush:
+			groups++
+			entryOf[i] = len(entries)
+			open = append(open, len(entries))
+			entries = append(entries, ElementContribution{Group: true, Path: paths[len(paths)-1]})
+
+			inner := fmt.Sprintf("%s group %d", modeName(1-o.mode), groups)
+			if len(paths) > 1 {
+				inner = paths[len(paths)-1] + " > " + inner
+			}
+			paths = append(paths, inner)
+		case opPop:
+			group := open[len(open)-1]
+			open = open[:len(open)-1]
+			paths = paths[:len(paths)-1]
+			entryOf[i] = group
+			entries[group].Name = c.code[c.ops[pushOf(c.ops, i)].pos : o.pos+1]
+		case opElement:
+			counts[o.element]++
+			entryOf[i] = len(entries)
+			entries = append(entries, ElementContribution{
+				Name:   fmt.Sprintf("%c%d", o.element-'a'+'A', counts[o.element]),
+				Path:   paths[len(paths)-1],
+				Params: append([]float64(nil), params[o.param:o.param+elementParams[o.element]]...),
+			})
+		}
+	}
+	for i := range entries {
+		entries[i].Impedance = make([][2]float64, len(freqs))
+	}
+
+	stack := make([]complex128, 0, c.maxDepth)
+	for f, freq := range freqs {
+		var (
+			tmp complex128
+			w   = 2 * math.Pi * freq
+		)
+		stack = stack[:0]
+		for i, o := range c.ops {
+			switch o.kind {
+			case opPush:
+				stack = append(stack, tmp)
+				tmp = 0
+			case opPop:
+				entries[entryOf[i]].Impedance[f] = [2]float64{real(tmp), imag(tmp)}
+				fromStack := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				tmp = sum(tmp, fromStack, o.mode)
+			case opElement:
+				z := elementImpedance(o.element, w, params[o.param:])
+				entries[entryOf[i]].Impedance[f] = [2]float64{real(z), imag(z)}
+				tmp = sum(tmp, z, o.mode)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// pushOf returns the index of the push op matching the pop op at index pop
+func pushOf(ops []op, pop int) int {
+	depth := 0
+	for i := pop; i >= 0; i-- {
+		switch ops[i].kind {
+		case opPop:
+			depth++
+		case opPush:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+func modeName(m Mode) string {
+	if m == PARALLEL {
+		return "parallel"
+	}
+	return "series"
+}
+
+// TopLevel returns the contributions on the series path of the circuit, whose
+// impedances sum to the circuit impedance
+func TopLevel(contributions []ElementContribution) []ElementContribution {
+	var res []ElementContribution
+	for _, c := range contributions {
+		if c.Path == "series" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// ElementContrib is the isolated impedance of one element and its share of
+// the circuit impedance modulus at every frequency
+type ElementContrib struct {
+	Name              string       `json:"name"`
+	Impedances        [][2]float64 `json:"impedances"`
+	MagnitudeFraction []float64    `json:"magnitude_fraction"`
+}
+
+// ElementContributions returns the contribution of every element of the
+// circuit described by code, in code order. MagnitudeFraction is |Z element|
+// divided by |Z circuit|; elements in parallel can exceed 1 and the fractions
+// need not sum to 1. It returns nil for an invalid code or too few params.
+func ElementContributions(code string, freqs []float64, params []float64) []ElementContrib {
+	c, err := CompileCircuit(code)
+	if err != nil || len(params) < c.params {
+		return nil
+	}
+
+	total := c.Impedance(freqs, params)
+	var (
+		res    []ElementContrib
+		counts = make(map[rune]int)
+	)
+	for _, o := range c.ops {
+		if o.kind != opElement {
+			continue
+		}
+		counts[o.element]++
+		ec := ElementContrib{
+			Name:              fmt.Sprintf("%c%d", o.element-'a'+'A', counts[o.element]),
+			Impedances:        make([][2]float64, len(freqs)),
+			MagnitudeFraction: make([]float64, len(freqs)),
+		}
+		for f, freq := range freqs {
+			z := SingleElementImpedance(o.element, params[o.param:o.param+elementParams[o.element]], freq)
+			ec.Impedances[f] = [2]float64{real(z), imag(z)}
+			if mod := math.Hypot(total[f][0], total[f][1]); mod > 0 && !math.IsInf(mod, 0) {
+				ec.MagnitudeFraction[f] = cmplx.Abs(z) / mod
+			}
+		}
+		res = append(res, ec)
+	}
+	return res
+}
+
+// SingleElementImpedance returns the impedance at freq in Hz of the element
+// elementChar, e.g. 'Q', isolated from the rest of the circuit. params holds
+// the element's own parameters. Unknown elements and missing parameters give 0.
+func SingleElementImpedance(elementChar rune, params []float64, freq float64) complex128 {
+	element := unicode.ToLower(elementChar)
+	if n, ok := elementParams[element]; !ok || len(params) < n {
+		return 0
+	}
+	return elementImpedance(element, 2*math.Pi*freq, params)
+}
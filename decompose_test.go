@@ -0,0 +1,104 @@
+package goimpcore
+
+import (
+	"math/cmplx"
+	"strings"
+	"testing"
+)
+
+// The top level entries sum to the circuit impedance, and the entries of a
+// parallel group sum in admittance to the group impedance
+func TestDecomposeImpedance(t *testing.T) {
+	tests := []struct {
+		code   string
+		params []float64
+	}{
+		{"R(QR)", []float64{10, 1e-5, 0.9, 100}},
+		{"R(CR)(QR)W", []float64{10, 1e-6, 50, 1e-4, 0.8, 200, 1e-2}},
+		{"LR(Q(RW))", []float64{1e-6, 5, 1e-5, 0.9, 100, 1e-3}},
+		{"R(Q(R(CR)))", []float64{10, 1e-6, 0.9, 100, 1e-5, 1000}},
+	}
+	freqs, _ := LogFrequencies(1e-2, 1e5, 2)
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			entries, err := DecomposeImpedance(tt.code, freqs, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			total := CircuitImpedance(tt.code, freqs, tt.params)
+			top := TopLevel(entries)
+			for f := range freqs {
+				var series complex128
+				for _, e := range top {
+					series += complex(e.Impedance[f][0], e.Impedance[f][1])
+				}
+				if want := complex(total[f][0], total[f][1]); cmplx.Abs(series-want) > 1e-9*cmplx.Abs(want) {
+					t.Errorf("%v Hz: top level entries sum to %v, want %v", freqs[f], series, want)
+				}
+			}
+
+			// Every group combines the entries directly in it in the mode
+			// named by their path
+			for i, group := range entries {
+				if !group.Group {
+					continue
+				}
+				path := entries[i+1].Path
+				var inner []ElementContribution
+				for _, e := range entries[i+1:] {
+					if !strings.HasPrefix(e.Path, path) {
+						break
+					}
+					if e.Path == path {
+						inner = append(inner, e)
+					}
+				}
+				segments := strings.Split(path, " > ")
+				parallel := strings.HasPrefix(segments[len(segments)-1], "parallel")
+				for f := range freqs {
+					var got complex128
+					for _, e := range inner {
+						z := complex(e.Impedance[f][0], e.Impedance[f][1])
+						if parallel {
+							z = 1 / z
+						}
+						got += z
+					}
+					if parallel {
+						got = 1 / got
+					}
+					if want := complex(group.Impedance[f][0], group.Impedance[f][1]); cmplx.Abs(got-want) > 1e-9*cmplx.Abs(want) {
+						t.Fatalf("group %s on %q at %v Hz: entries combine to %v, want %v", group.Name, path, freqs[f], got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecomposeImpedanceNames(t *testing.T) {
+	entries, err := DecomposeImpedance("R(QR)(CR)", []float64{1}, []float64{10, 1e-5, 0.9, 100, 1e-6, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ name, path string }{
+		{"R1", "series"},
+		{"(qr)", "series"},
+		{"Q1", "parallel group 1"},
+		{"R2", "parallel group 1"},
+		{"(cr)", "series"},
+		{"C1", "parallel group 2"},
+		{"R3", "parallel group 2"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d entries, want %d", len(entries), len(want))
+	}
+	for i, w := range want {
+		if entries[i].Name != w.name || entries[i].Path != w.path {
+			t.Errorf("entry %d: %s on %q, want %s on %q", i, entries[i].Name, entries[i].Path, w.name, w.path)
+		}
+	}
+	if _, err := DecomposeImpedance("R(QR)", []float64{1}, []float64{10}); err == nil {
+		t.Error("no error for too few parameters")
+	}
+}
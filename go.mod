@@ -5,11 +5,16 @@ go 1.23.0
 toolchain go1.23.12
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/gorilla/websocket v1.5.4-0.20250319132907-e064f32e3674
 	github.com/maorshutman/lm v0.0.0-20190501150544-7c8d1397ebf3
+	go.etcd.io/bbolt v1.3.11
 	gonum.org/v1/gonum v0.16.0
 )
 
 require (
 	golang.org/x/exp v0.0.0-20241009180824-f66d83c29e7c // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
 	golang.org/x/tools v0.26.0 // indirect
 )
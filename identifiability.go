@@ -0,0 +1,198 @@
+package goimpcore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/diff/fd"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Identifiability defaults
+const (
+	DefaultMaxCorrelation = 0.95
+	DefaultMaxCondition   = 1e8
+)
+
+// ErrSingularFit is returned when the Jacobian of a fit has no full rank, so
+// its covariance is undefined
+var ErrSingularFit = errors.New("solver: singular Jacobian, parameters are not identifiable")
+
+// IdentifiabilitySettings configures the diagnostics run after a successful fit
+type IdentifiabilitySettings struct {
+	Disabled       bool
+	MaxCorrelation float64 // |correlation| above which a pair is reported, DefaultMaxCorrelation when 0
+	MaxCondition   float64 // Jacobian condition number above which the fit is reported, DefaultMaxCondition when 0
+}
+
+// Identifiability holds the parameter diagnostics of a fit. The condition
+// number is taken of the Jacobian with respect to relative parameter changes,
+// so it does not depend on the parameter units.
+type Identifiability struct {
+	Condition    float64     `json:"condition_number"`
+	StdErrors    []float64   `json:"std_errors"`
+	Correlations [][]float64 `json:"correlations"`
+	Warnings     []string    `json:"warnings,omitempty"`
+}
+
+// Jacobian returns the derivatives of the weighted residuals of the fitted
+// points with respect to params. Rows hold the real parts of all points
+// followed by the imaginary parts.
+func (s *Solver) Jacobian(params []float64) (*mat.Dense, error) {
+	freqs, observed, sigmas, err := s.windowData()
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(observed)
+	jac := mat.NewDense(2*n, len(params), nil)
+	fd.Jacobian(jac, func(y, x []float64) {
+		calculated := s.impedance(freqs, x)
+		for i, o := range observed {
+			dRe, dIm, wRe, wIm := pointResidual(o, calculated[i], sigmas, i, s.Weighting, s.Space)
+			y[i] = math.Sqrt(wRe) * dRe
+			y[n+i] = math.Sqrt(wIm) * dIm
+		}
+	}, params, &fd.JacobianSettings{Formula: fd.Central})
+	return jac, nil
+}
+
+// Covariance returns the parameter covariance s²(JᵀJ)⁻¹ at params, s² being
+// the weighted residual variance of the fit
+func (s *Solver) Covariance(params []float64) (*mat.SymDense, error) {
+	jac, err := s.Jacobian(params)
+	if err != nil {
+		return nil, err
+	}
+	return s.covariance(jac, params)
+}
+
+func (s *Solver) covariance(jac *mat.Dense, params []float64) (*mat.SymDense, error) {
+	rows, nParams := jac.Dims()
+	if rows <= nParams {
+		return nil, fmt.Errorf("solver: %d observations cannot determine %d parameters", rows, nParams)
+	}
+
+	var jtj mat.SymDense
+	jtj.SymOuterK(1, jac.T())
+
+	var chol mat.Cholesky
+	if ok := chol.Factorize(&jtj); !ok {
+		return nil, ErrSingularFit
+	}
+	cov := mat.NewSymDense(nParams, nil)
+	if err := chol.InverseTo(cov); err != nil {
+		return nil, ErrSingularFit
+	}
+
+	freqs, observed, sigmas, _ := s.windowData()
+	ssr := s.chiSq(observed, s.impedance(freqs, params), sigmas) * float64(len(observed))
+	cov.ScaleSym(ssr/float64(rows-nParams), cov)
+	return cov, nil
+}
+
+// Correlations converts a covariance matrix into parameter correlations
+func Correlations(cov *mat.SymDense) [][]float64 {
+	n := cov.SymmetricDim()
+	corr := make([][]float64, n)
+	for i := range corr {
+		corr[i] = make([]float64, n)
+		for j := range corr[i] {
+			corr[i][j] = cov.At(i, j) / math.Sqrt(cov.At(i, i)*cov.At(j, j))
+		}
+	}
+	return corr
+}
+
+// Identifiability computes the condition number and the parameter
+// correlations at params and warns about highly correlated parameter pairs
+func (s *Solver) Identifiability(params []float64) (*Identifiability, error) {
+	maxCorr := s.Diagnostics.MaxCorrelation
+	if maxCorr <= 0 {
+		maxCorr = DefaultMaxCorrelation
+	}
+	maxCond := s.Diagnostics.MaxCondition
+	if maxCond <= 0 {
+		maxCond = DefaultMaxCondition
+	}
+
+	jac, err := s.Jacobian(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scale the columns to relative parameter changes before taking the condition number
+	rows, nParams := jac.Dims()
+	rel := mat.NewDense(rows, nParams, nil)
+	rel.Apply(func(i, j int, v float64) float64 { return v * params[j] }, jac)
+
+	res := &Identifiability{Condition: mat.Cond(rel, 2)}
+	names := ParamLabels(s.code)
+	for len(names) < nParams {
+		names = append(names, fmt.Sprintf("p%d", len(names)))
+	}
+	if math.IsInf(res.Condition, 1) || res.Condition > maxCond {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Jacobian condition number %.3g exceeds %.3g, the circuit may be over-parameterized for this frequency range", res.Condition, maxCond))
+	}
+
+	cov, err := s.covariance(jac, params)
+	if err != nil {
+		res.Warnings = append(res.Warnings, err.Error())
+		return res, nil
+	}
+
+	res.StdErrors = make([]float64, nParams)
+	for i := range res.StdErrors {
+		res.StdErrors[i] = math.Sqrt(cov.At(i, i))
+	}
+	res.Correlations = Correlations(cov)
+	for i := 0; i < nParams; i++ {
+		for j := i + 1; j < nParams; j++ {
+			if c := res.Correlations[i][j]; math.Abs(c) > maxCorr {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("%s and %s are %.0f%% correlated, consider fixing one", names[i], names[j], 100*math.Abs(c)))
+			}
+		}
+	}
+	return res, nil
+}
+
+// ParamLabels returns a label for every parameter of code, e.g. R1, Q1_Y0,
+// Q1_n, R2 for R(QR). Elements are numbered per type in code order.
+func ParamLabels(code string) []string {
+	var (
+		labels []string
+		counts = make(map[rune]int)
+	)
+	for _, char := range circuitElements(code) {
+		suffixes, ok := paramSuffixes[char]
+		if !ok {
+			continue
+		}
+		counts[char]++
+		name := fmt.Sprintf("%c%d", char-'a'+'A', counts[char])
+		if len(suffixes) == 0 {
+			labels = append(labels, name)
+			continue
+		}
+		for _, suffix := range suffixes {
+			labels = append(labels, name+"_"+suffix)
+		}
+	}
+	return labels
+}
+
+// paramSuffixes names the parameters of multi-parameter elements, single
+// parameter elements have none
+var paramSuffixes = map[rune][]string{
+	114: nil,              // R
+	99:  nil,              // C
+	108: nil,              // L
+	119: nil,              // W
+	113: {"Y0", "n"},      // Q
+	111: {"Y0", "B"},      // O
+	116: {"Y0", "B"},      // T
+	103: {"Y0", "k"},      // G
+	112: {"Ri", "Yi"},     // P
+	102: {"Y0", "k", "a"}, // F
+}
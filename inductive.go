@@ -0,0 +1,105 @@
+package goimpcore
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// minLoopPoints is how many consecutive inductive points make a loop, a
+// single one is taken for noise
+const minLoopPoints = 2
+
+// InductiveLoopInfo describes the inductive loop of a spectrum, a range of
+// frequencies with a positive imaginary impedance as caused by adsorption or
+// side reactions. FrequencyRange holds its lowest and highest frequency, the
+// peak is the point of largest imaginary impedance and Inductance the
+// inductance Im(Z)/(2πf) at the peak, in H.
+type InductiveLoopInfo struct {
+	Detected       bool       `json:"detected"`
+	FrequencyRange [2]float64 `json:"frequency_range"`
+	PeakFrequency  float64    `json:"peak_frequency"`
+	Inductance     float64    `json:"inductance"`
+}
+
+// DetectInductiveLoop looks for ranges of consecutive frequencies with a
+// positive imaginary impedance and reports the one holding the largest. A
+// range needs minLoopPoints points, frequencies may be in any order.
+func DetectInductiveLoop(freqs []float64, impData [][2]float64) InductiveLoopInfo {
+	n := len(freqs)
+	if len(impData) < n {
+		n = len(impData)
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return freqs[order[a]] < freqs[order[b]] })
+
+	var loop InductiveLoopInfo
+	var peakImag float64
+	for start := 0; start < n; {
+		if !(impData[order[start]][1] > 0) {
+			start++
+			continue
+		}
+		end, peak := start, order[start]
+		for end < n && impData[order[end]][1] > 0 {
+			if impData[order[end]][1] > impData[peak][1] {
+				peak = order[end]
+			}
+			end++
+		}
+		if end-start >= minLoopPoints && freqs[peak] > 0 && impData[peak][1] > peakImag {
+			peakImag = impData[peak][1]
+			loop = InductiveLoopInfo{
+				Detected:       true,
+				FrequencyRange: [2]float64{freqs[order[start]], freqs[order[end-1]]},
+				PeakFrequency:  freqs[peak],
+				Inductance:     impData[peak][1] / (2 * math.Pi * freqs[peak]),
+			}
+		}
+		start = end
+	}
+	return loop
+}
+
+// Warning returns the fit warning of a detected loop, noting when code has no
+// inductance to model it
+func (l InductiveLoopInfo) Warning(code string) string {
+	msg := fmt.Sprintf("inductive loop detected between %.4g and %.4g Hz, peak at %.4g Hz, estimated L = %.4g H",
+		l.FrequencyRange[0], l.FrequencyRange[1], l.PeakFrequency, l.Inductance)
+	if inductanceIndex(code) < 0 {
+		msg += ", the circuit has no L element to model it"
+	}
+	return msg
+}
+
+// InitInductance returns params, initial values of code, with the value of
+// the first L element of code set to the inductance of the inductive loop of
+// the spectrum when one is detected. params is returned as it is otherwise.
+func InitInductance(code string, params []float64, freqs []float64, impData [][2]float64) []float64 {
+	i := inductanceIndex(code)
+	if i < 0 || i >= len(params) {
+		return params
+	}
+	loop := DetectInductiveLoop(freqs, impData)
+	if !loop.Detected {
+		return params
+	}
+	params = append([]float64(nil), params...)
+	params[i] = loop.Inductance
+	return params
+}
+
+// inductanceIndex returns the parameter index of the first L element of code,
+// -1 when it has none
+func inductanceIndex(code string) int {
+	for i, element := range GetElements(strings.ToLower(code)) {
+		if element == "l" {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,115 @@
+package goimpcore
+
+import (
+	"math"
+	"testing"
+)
+
+// inductiveSpectrum is the noise-free spectrum of code with params from 1 Hz
+// to 1 MHz
+func inductiveSpectrum(code string, params []float64) (freqs []float64, impData [][2]float64) {
+	freqs, _ = LogFrequencies(1, 1e6, 10)
+	return freqs, CircuitImpedance(code, freqs, params)
+}
+
+func TestDetectInductiveLoop(t *testing.T) {
+	// R(LR) peaks at w = R/L, Im(Z) = R/2
+	const l, r = 1e-3, 100.0
+	loopFreqs, loopData := inductiveSpectrum("r(lr)", []float64{10, l, r})
+	capFreqs, capData := inductiveSpectrum("r(cr)", []float64{10, 1e-5, r})
+
+	noisy := append([][2]float64(nil), capData...)
+	noisy[len(noisy)/2][1] = 1
+	reversedFreqs := make([]float64, len(loopFreqs))
+	reversedData := make([][2]float64, len(loopData))
+	for i := range loopFreqs {
+		reversedFreqs[len(loopFreqs)-1-i] = loopFreqs[i]
+		reversedData[len(loopData)-1-i] = loopData[i]
+	}
+
+	tests := []struct {
+		name     string
+		freqs    []float64
+		impData  [][2]float64
+		detected bool
+	}{
+		{"R(LR)", loopFreqs, loopData, true},
+		{"R(LR) descending frequencies", reversedFreqs, reversedData, true},
+		{"R(CR)", capFreqs, capData, false},
+		{"single inductive point", capFreqs, noisy, false},
+	}
+	peak := r / (2 * math.Pi * l)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := DetectInductiveLoop(tt.freqs, tt.impData)
+			if loop.Detected != tt.detected {
+				t.Fatalf("Detected = %v, want %v", loop.Detected, tt.detected)
+			}
+			if !tt.detected {
+				return
+			}
+			if loop.FrequencyRange != [2]float64{1, 1e6} {
+				t.Errorf("FrequencyRange = %v, want [1 1e6]", loop.FrequencyRange)
+			}
+			// Ten points per decade put a point within 12 % of the peak
+			if math.Abs(math.Log10(loop.PeakFrequency/peak)) > 0.05 {
+				t.Errorf("PeakFrequency = %v, want about %v", loop.PeakFrequency, peak)
+			}
+			if want := r / 2 / (2 * math.Pi * peak); math.Abs(loop.Inductance/want-1) > 0.1 {
+				t.Errorf("Inductance = %v, want about %v", loop.Inductance, want)
+			}
+		})
+	}
+}
+
+// The initial L of a fit is the inductance of the loop of the spectrum
+func TestInitValuesSeedInductance(t *testing.T) {
+	t.Run("findInitValues", func(t *testing.T) {
+		freqs, impData := inductiveSpectrum("r(lr)", []float64{10, 1e-3, 100})
+		want := DetectInductiveLoop(freqs, impData).Inductance
+		s := NewSolver("R(LR)", freqs, impData)
+		init := s.findInitValues(append([]float64(nil), freqs...), impData)
+		if init[1] != want {
+			t.Errorf("L = %v, want the loop inductance %v", init[1], want)
+		}
+	})
+
+	t.Run("series inductance", func(t *testing.T) {
+		const l = 2e-6
+		freqs, impData := inductiveSpectrum("rl", []float64{10, l})
+		s := NewSolver("RL", freqs, impData)
+		init := s.findInitValues(append([]float64(nil), freqs...), impData)
+		if math.Abs(init[1]/l-1) > 1e-9 {
+			t.Errorf("L = %v, want %v", init[1], l)
+		}
+	})
+
+	t.Run("R(L)", func(t *testing.T) {
+		const l = 1e-3
+		freqs, impData := inductiveSpectrum("r(l)", []float64{10, l})
+		s := NewSolver("R(L)", freqs, impData)
+		init := s.findInitValues(append([]float64(nil), freqs...), impData)
+		if math.Abs(init[1]/l-1) > 1e-9 {
+			t.Errorf("L = %v, want %v", init[1], l)
+		}
+	})
+
+	t.Run("library defaults", func(t *testing.T) {
+		params := []float64{1e-6, 0.05, 1e-3, 0.8, 0.02, 1e-2, 0.9, 0.05, 100.0}
+		freqs, impData := inductiveSpectrum("lr(qr)(q(rw))", params)
+		loop := DetectInductiveLoop(freqs, impData)
+		if !loop.Detected {
+			t.Fatal("no inductive loop in the spectrum")
+		}
+		s, err := NewSolverFromLibrary("BatteryCell", freqs, impData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.InitValues[0] != loop.Inductance {
+			t.Errorf("L = %v, want the loop inductance %v", s.InitValues[0], loop.Inductance)
+		}
+		if s.InitValues[1] != 0.05 {
+			t.Errorf("Rs = %v, want the default 0.05", s.InitValues[1])
+		}
+	})
+}
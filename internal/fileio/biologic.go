@@ -0,0 +1,74 @@
+package fileio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseBioLogic reads the EC-Lab .mpt file at path, STDIN for "-", see
+// ReadBioLogic
+func ParseBioLogic(path string) (freqs []float64, impData [][2]float64, err error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return ReadBioLogic(f)
+}
+
+// ReadBioLogic reads a BioLogic EC-Lab .mpt text export: header lines, the
+// last of which names the tab separated columns, then one row per point.
+// The columns freq/Hz, Re(Z)/Ohm and -Im(Z)/Ohm are used, decimal commas are
+// accepted.
+func ReadBioLogic(r io.Reader) (freqs []float64, impData [][2]float64, err error) {
+	freqCol, reCol, imCol := -1, -1, -1
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Split(strings.TrimRight(scanner.Text(), "\r\n"), "\t")
+		if freqCol < 0 {
+			for i, name := range fields {
+				switch strings.TrimSpace(name) {
+				case "freq/Hz":
+					freqCol = i
+				case "Re(Z)/Ohm":
+					reCol = i
+				case "-Im(Z)/Ohm":
+					imCol = i
+				}
+			}
+			if freqCol >= 0 && (reCol < 0 || imCol < 0) {
+				return nil, nil, fmt.Errorf("biologic: line %d: no Re(Z)/Ohm or -Im(Z)/Ohm column", line)
+			}
+			continue
+		}
+		if strings.TrimSpace(strings.Join(fields, "")) == "" {
+			continue
+		}
+
+		var values [3]float64
+		for i, col := range []int{freqCol, reCol, imCol} {
+			if col >= len(fields) {
+				return nil, nil, fmt.Errorf("biologic: line %d: expected at least %d columns, got %d", line, col+1, len(fields))
+			}
+			value := strings.ReplaceAll(strings.TrimSpace(fields[col]), ",", ".")
+			if values[i], err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, nil, fmt.Errorf("biologic: line %d: %v", line, err)
+			}
+		}
+		freqs = append(freqs, values[0])
+		impData = append(impData, [2]float64{values[1], -values[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if freqCol < 0 {
+		return nil, nil, fmt.Errorf("biologic: no freq/Hz column")
+	}
+	if len(freqs) == 0 {
+		return nil, nil, fmt.Errorf("biologic: no data points")
+	}
+	return freqs, impData, nil
+}
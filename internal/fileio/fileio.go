@@ -0,0 +1,43 @@
+package fileio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin is the file name standing for STDIN
+const Stdin = "-"
+
+// Input file formats, see DetectFormat
+const (
+	FormatText     = "text"     // "freq real imag [sigmaReal sigmaImag]" lines
+	FormatZView    = "zview"    // ZView/WinStar .z files, see ParseZView
+	FormatGamry    = "gamry"    // Gamry .dta files, see ParseGamry
+	FormatBioLogic = "biologic" // BioLogic EC-Lab .mpt exports, see ParseBioLogic
+)
+
+// Open opens a measurement file, STDIN for "-". Closing the returned reader
+// leaves STDIN open.
+func Open(path string) (io.ReadCloser, error) {
+	if path == Stdin {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// DetectFormat returns the format of path by its extension, FormatZView for
+// .z, FormatGamry for .dta and FormatBioLogic for .mpt files and FormatText
+// for anything else
+func DetectFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".z":
+		return FormatZView
+	case ".dta":
+		return FormatGamry
+	case ".mpt":
+		return FormatBioLogic
+	}
+	return FormatText
+}
@@ -0,0 +1,88 @@
+package fileio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ParseGamry reads the Gamry .dta file at path, STDIN for "-", see ReadGamry
+func ParseGamry(path string) (freqs []float64, impData [][2]float64, err error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return ReadGamry(f)
+}
+
+// ReadGamry reads the ZCURVE table of a Gamry .dta file: a tab separated
+// header row naming the columns, of which Freq, Zreal and Zimag are used, a
+// row of units, then one row per point. The table ends at the first row that
+// does not start with a number.
+func ReadGamry(r io.Reader) (freqs []float64, impData [][2]float64, err error) {
+	var (
+		columns       map[string]int
+		inTable, data bool
+	)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(text)
+		switch {
+		case !inTable:
+			inTable = len(fields) > 0 && strings.EqualFold(fields[0], "ZCURVE")
+			continue
+		case columns == nil:
+			columns = make(map[string]int, len(fields))
+			for i, name := range fields {
+				columns[strings.ToLower(name)] = i
+			}
+			for _, name := range []string{"freq", "zreal", "zimag"} {
+				if _, ok := columns[name]; !ok {
+					return nil, nil, fmt.Errorf("gamry: line %d: no %s column in the ZCURVE table", line, name)
+				}
+			}
+			continue
+		}
+
+		if len(fields) == 0 {
+			if data {
+				break
+			}
+			continue
+		}
+		if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+			if data {
+				break
+			}
+			continue // units
+		}
+		data = true
+
+		var values [3]float64
+		for i, name := range []string{"freq", "zreal", "zimag"} {
+			col := columns[name]
+			if col >= len(fields) {
+				return nil, nil, fmt.Errorf("gamry: line %d: missing %s value", line, name)
+			}
+			if values[i], err = strconv.ParseFloat(fields[col], 64); err != nil {
+				return nil, nil, fmt.Errorf("gamry: line %d: %v", line, err)
+			}
+		}
+		freqs = append(freqs, values[0])
+		impData = append(impData, [2]float64{values[1], values[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !inTable {
+		return nil, nil, fmt.Errorf("gamry: no ZCURVE table")
+	}
+	if len(freqs) == 0 {
+		return nil, nil, fmt.Errorf("gamry: no data points")
+	}
+	return freqs, impData, nil
+}
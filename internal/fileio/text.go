@@ -0,0 +1,86 @@
+package fileio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ReadText reads FormatText lines "freq real imag [sigmaReal sigmaImag]",
+// separated by spaces or tabs. Errors name the line they were found on, the
+// first one counted as 1. The uncertainties are kept only when every line has
+// them.
+func ReadText(r io.Reader) (freqs []float64, impData [][2]float64, sigmas [][2]float64, err error) {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		var values [3]float64
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, nil, nil, fmt.Errorf("text: line %d: expected frequency, real and imaginary columns: %q", line, text)
+		}
+		for i := range values {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("text: line %d: %v", line, err)
+			}
+			values[i] = v
+		}
+		freqs = append(freqs, values[0])
+		impData = append(impData, [2]float64{values[1], values[2]})
+
+		if len(fields) >= 5 {
+			sigmaRe, errRe := strconv.ParseFloat(fields[3], 64)
+			sigmaIm, errIm := strconv.ParseFloat(fields[4], 64)
+			if errRe != nil || errIm != nil {
+				return nil, nil, nil, fmt.Errorf("text: line %d: invalid sigma values: %q", line, text)
+			}
+			sigmas = append(sigmas, [2]float64{sigmaRe, sigmaIm})
+		}
+	}
+	if len(sigmas) != len(impData) {
+		if len(sigmas) > 0 {
+			log.Printf("WARNING: Only %d of %d lines have sigma columns, ignoring uncertainties", len(sigmas), len(impData))
+		}
+		sigmas = nil
+	}
+	return freqs, impData, sigmas, scanner.Err()
+}
+
+// Measurement is a spectrum read from a file of any format, see Read
+type Measurement struct {
+	Freqs       []float64
+	ImpData     [][2]float64
+	Sigmas      [][2]float64 // standard deviations per point, nil when the file has none
+	CircuitCode string       // circuit named by the file, empty when it names none
+}
+
+// Read reads a measurement file of format, one of the Format constants
+func Read(format string, r io.Reader) (Measurement, error) {
+	var (
+		m   Measurement
+		err error
+	)
+	switch format {
+	case FormatText:
+		m.Freqs, m.ImpData, m.Sigmas, err = ReadText(r)
+	case FormatZView:
+		var z ZViewFile
+		z, err = ReadZView(r)
+		m = Measurement{Freqs: z.Freqs, ImpData: z.ImpData, CircuitCode: z.CircuitCode}
+	case FormatGamry:
+		m.Freqs, m.ImpData, err = ReadGamry(r)
+	case FormatBioLogic:
+		m.Freqs, m.ImpData, err = ReadBioLogic(r)
+	default:
+		return Measurement{}, fmt.Errorf("unknown input format '%s', expected auto, text, zview, gamry or biologic", format)
+	}
+	if err != nil {
+		return Measurement{}, err
+	}
+	return m, nil
+}
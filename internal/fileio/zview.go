@@ -0,0 +1,96 @@
+package fileio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ZViewFile is the content of a ZView/WinStar .z file
+type ZViewFile struct {
+	Freqs       []float64
+	ImpData     [][2]float64 // real and imaginary parts, the imaginary sign as in the rest of goimpcore
+	CircuitCode string       // from the CIRCUIT= header line, empty when the file has none
+	Title       string
+	Notes       string
+}
+
+// ParseZView reads the .z file at path, STDIN for "-", see ReadZView
+func ParseZView(path string) (ZViewFile, error) {
+	f, err := Open(path)
+	if err != nil {
+		return ZViewFile{}, err
+	}
+	defer f.Close()
+	return ReadZView(f)
+}
+
+// ReadZView reads a .z file: a header of KEY=VALUE lines, of which TITLE,
+// NOTES and CIRCUIT are kept, then a ZCURVE line followed by the data
+// columns frequency, Zr, -Zi, |Z| and phase. Only the first three columns are
+// used, lines of the data section not starting with a number are skipped.
+func ReadZView(r io.Reader) (ZViewFile, error) {
+	var (
+		z      ZViewFile
+		inData bool
+	)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if !inData {
+			if strings.HasPrefix(strings.ToUpper(text), "ZCURVE") {
+				inData = true
+				continue
+			}
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.ToUpper(strings.TrimSpace(key)) {
+			case "TITLE":
+				z.Title = value
+			case "NOTES":
+				z.Notes = value
+			case "CIRCUIT":
+				z.CircuitCode = value
+			}
+			continue
+		}
+
+		fields := strings.Fields(strings.ReplaceAll(text, ",", " "))
+		freq, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue // column titles
+		}
+		if len(fields) < 3 {
+			return ZViewFile{}, fmt.Errorf("zview: line %d: expected frequency, Zr and -Zi columns, got %q", line, text)
+		}
+		re, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return ZViewFile{}, fmt.Errorf("zview: line %d: %v", line, err)
+		}
+		negIm, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return ZViewFile{}, fmt.Errorf("zview: line %d: %v", line, err)
+		}
+		z.Freqs = append(z.Freqs, freq)
+		z.ImpData = append(z.ImpData, [2]float64{re, -negIm})
+	}
+	if err := scanner.Err(); err != nil {
+		return ZViewFile{}, err
+	}
+	if !inData {
+		return ZViewFile{}, fmt.Errorf("zview: no ZCURVE data section")
+	}
+	if len(z.Freqs) == 0 {
+		return ZViewFile{}, fmt.Errorf("zview: no data points")
+	}
+	return z, nil
+}
@@ -0,0 +1,63 @@
+package fileio
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// samplePath is a minimal .z file of 5 points of R(QR) with R1=20, Q1
+// Y0=1e-5 n=0.85 and R2=200
+const samplePath = "testdata/sample.z"
+
+func TestParseZView(t *testing.T) {
+	if format := DetectFormat(samplePath); format != FormatZView {
+		t.Errorf("format %s, want %s", format, FormatZView)
+	}
+	z, err := ParseZView(samplePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.CircuitCode != "R(QR)" || z.Title != "ZView sample, R(QR) cell" || !strings.HasPrefix(z.Notes, "5 points") {
+		t.Errorf("header circuit %q, title %q, notes %q", z.CircuitCode, z.Title, z.Notes)
+	}
+	if len(z.Freqs) != 5 || len(z.ImpData) != 5 {
+		t.Fatalf("%d frequencies and %d points, want 5", len(z.Freqs), len(z.ImpData))
+	}
+	// -Zi is stored as the imaginary part, written with 7 significant digits
+	want := goimpcore.CircuitImpedance("r(qr)", z.Freqs, []float64{20, 1e-5, 0.85, 200})
+	for i, got := range z.ImpData {
+		if math.Abs(got[0]-want[i][0]) > 1e-6*math.Abs(want[i][0]) || math.Abs(got[1]-want[i][1]) > 1e-6*math.Abs(want[i][1]) {
+			t.Errorf("point at %g Hz: %v, want %v", z.Freqs[i], got, want[i])
+		}
+	}
+
+	data, err := os.ReadFile(samplePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Read(FormatZView, bytes.NewReader(data))
+	if err != nil || m.CircuitCode != "R(QR)" || len(m.Freqs) != 5 {
+		t.Errorf("Read: circuit %q, %d points, %v", m.CircuitCode, len(m.Freqs), err)
+	}
+}
+
+func TestReadZViewErrors(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"no data section", "TITLE=x\n1 2 3\n", "no ZCURVE"},
+		{"no points", "ZCURVE\n\"Freq(Hz)\"\n", "no data points"},
+		{"short line", "ZCURVE\n1 2\n", "line 2"},
+		{"bad value", "ZCURVE\n1 2 x\n", "line 2"},
+	}
+	for _, tt := range tests {
+		if _, err := ReadZView(strings.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want one with %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// ComparisonResult is the outcome of fitting one spectrum against several
+// candidate circuits
+type ComparisonResult struct {
+	Candidates []goimpcore.CircuitCandidate // best first
+	Best       goimpcore.Result             // fit of the winning circuit, its ranking in the payload
+	BestCode   string                       // empty when every candidate failed
+}
+
+// CompareCircuits fits the spectrum with every circuit of codes and ranks the
+// fits by cfg.Criterion, AIC unless BIC is asked for, since chi-square always
+// favours the circuit with more parameters. A failed candidate is reported in
+// the ranking and does not stop the comparison.
+func CompareCircuits(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, codes []string, cfg *config.Config) ComparisonResult {
+	p := NewEISProcessor()
+	criterion := comparisonCriterion(cfg.Criterion)
+
+	results := make(map[string]goimpcore.Result, len(codes))
+	candidates := make([]goimpcore.CircuitCandidate, 0, len(codes))
+	for _, code := range codes {
+		if ctx.Err() != nil {
+			candidates = append(candidates, goimpcore.NewCircuitCandidate(code, goimpcore.Result{}, ctx.Err()))
+			continue
+		}
+
+		code = strings.ToLower(code)
+		res, err := p.fitCandidate(ctx, code, freqs, impData, sigmas, cfg)
+		if err != nil {
+			log.Printf("Circuit %s failed: %v", code, err)
+		}
+		results[code] = res
+		candidates = append(candidates, goimpcore.NewCircuitCandidate(code, res, err))
+	}
+	goimpcore.RankCandidates(candidates, criterion)
+
+	cmp := ComparisonResult{
+		Candidates: candidates,
+		Best: goimpcore.Result{
+			Status: "ERROR",
+			Min:    math.Inf(1),
+			Params: []float64{},
+		},
+	}
+	if len(candidates) > 0 && candidates[0].Rank == 1 {
+		cmp.BestCode = candidates[0].Code
+		cmp.Best = results[cmp.BestCode]
+	}
+	cmp.Best.SetPayload(goimpcore.PayloadCircuitRanking, candidates)
+	return cmp
+}
+
+// fitCandidate fits one circuit of a comparison. The configured initial values
+// are used only when they fit the circuit.
+func (p *EISProcessor) fitCandidate(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) (goimpcore.Result, error) {
+	if err := goimpcore.ValidateCircuit(code); err != nil {
+		return goimpcore.Result{}, err
+	}
+
+	candCfg := *cfg
+	candCfg.Code = code
+	if len(candCfg.InitValues) != len(goimpcore.GetElements(code)) {
+		candCfg.InitValues = nil
+	}
+
+	var (
+		res goimpcore.Result
+		err error
+	)
+	if candCfg.OptimMethod == "all" {
+		res, err = p.runAllOptimizationMethods(ctx, code, freqs, impData, sigmas, &candCfg)
+	} else {
+		res, err = p.runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, &candCfg, candCfg.OptimMethod)
+	}
+	if err == nil && res.Status != goimpcore.OK {
+		err = fmt.Errorf("fit failed with status %s", res.Status)
+	}
+	return res, err
+}
+
+// comparisonCriterion returns the criterion ranking a circuit comparison
+func comparisonCriterion(criterion string) string {
+	if criterion == goimpcore.CriterionBIC {
+		return goimpcore.CriterionBIC
+	}
+	return goimpcore.CriterionAIC
+}
@@ -1,13 +1,16 @@
 package processing
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"math"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
 	"github.com/kacperjurak/goimpcore/pkg/config"
 )
 
@@ -25,7 +28,7 @@ func NewEISProcessor() *EISProcessor {
 }
 
 // Process processes EIS data and returns the result
-func (p *EISProcessor) Process(freqs []float64, impData [][2]float64, cfg *config.Config) (goimpcore.Result, error) {
+func (p *EISProcessor) Process(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) (goimpcore.Result, error) {
 	if len(freqs) == 0 {
 		return goimpcore.Result{}, fmt.Errorf("no frequency data provided")
 	}
@@ -38,6 +41,10 @@ func (p *EISProcessor) Process(freqs []float64, impData [][2]float64, cfg *confi
 		return goimpcore.Result{}, fmt.Errorf("frequency and impedance data length mismatch: %d vs %d", len(freqs), len(impData))
 	}
 
+	if len(sigmas) > 0 && len(sigmas) != len(impData) {
+		return goimpcore.Result{}, fmt.Errorf("sigma and impedance data length mismatch: %d vs %d", len(sigmas), len(impData))
+	}
+
 	// Validate impedance data
 	for i, imp := range impData {
 		if len(imp) != 2 {
@@ -47,16 +54,24 @@ func (p *EISProcessor) Process(freqs []float64, impData [][2]float64, cfg *confi
 
 	log.Printf("🔥 REAL EIS: Processing %d frequency points with config: %+v", len(freqs), cfg)
 
+	if codes := goimpcore.ParseCircuitCodes(cfg.Code); len(codes) > 1 {
+		cmp := CompareCircuits(ctx, freqs, impData, sigmas, codes, cfg)
+		if cmp.BestCode == "" {
+			return cmp.Best, fmt.Errorf("all %d candidate circuits failed", len(codes))
+		}
+		return cmp.Best, nil
+	}
+
 	code := strings.ToLower(cfg.Code)
 
 	if cfg.OptimMethod == "all" {
-		return p.runAllOptimizationMethods(code, freqs, impData, cfg)
+		return p.runAllOptimizationMethods(ctx, code, freqs, impData, sigmas, cfg)
 	}
 
-	return p.runSingleOptimizationMethod(code, freqs, impData, cfg, cfg.OptimMethod)
+	return p.runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, cfg.OptimMethod)
 }
 
-func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64, impData [][2]float64, cfg *config.Config, method string) (goimpcore.Result, error) {
+func (p *EISProcessor) runSingleOptimizationMethod(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config, method string) (goimpcore.Result, error) {
 	solver := goimpcore.NewSolver(code, freqs, impData)
 
 	// Use provided InitValues or generate automatic ones
@@ -64,20 +79,48 @@ func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64,
 		solver.InitValues = []float64(cfg.InitValues)
 		log.Printf("Using provided initial values: %v", solver.InitValues)
 	} else {
-		solver.InitValues = p.generateInitialValues(code)
+		solver.InitValues = p.generateInitialValues(code, freqs, impData)
 		log.Printf("Using auto-generated initial values: %v", solver.InitValues)
 	}
 
-	if cfg.Unity {
-		solver.Weighting = goimpcore.UNITY
-	} else {
+	if err := solver.SetWeighting(cfg.Weighting, sigmas); err != nil {
+		log.Printf("%v, using modulus weighting", err)
 		solver.Weighting = goimpcore.MODULUS
 	}
+	log.Printf("Using %s weighting", solver.Weighting)
+
+	space, err := goimpcore.ParseSpace(cfg.Space)
+	if err != nil {
+		log.Printf("%v, fitting impedances", err)
+	}
+	solver.Space = space
+
+	if cfg.Starts > 0 {
+		solver.Starts = int(cfg.Starts)
+	}
+	if cfg.Concurrency {
+		solver.Concurrency = runtime.NumCPU()
+	}
+	solver.Robust = goimpcore.RobustSettings{Enabled: cfg.Robust, K: cfg.RobustK, Passes: int(cfg.RobustPasses)}
+	solver.FreqMin = cfg.FreqMin
+	solver.FreqMax = cfg.FreqMax
+	solver.Regularization = cfg.Regularization
+	solver.Diagnostics.MaxCorrelation = cfg.MaxCorrelation
+
+	if cfg.LogScale {
+		solver.LogScale = goimpcore.DefaultLogScale(code)
+		log.Printf("Using log-scale parameters: %v", solver.LogScale)
+	}
 
 	// Set the solver method based on the optimization method
 	switch method {
 	case "nelder-mead":
 		solver.SmartMode = "eis" // Use EIS smart mode for multi-try approach
+		if cfg.Concurrency {
+			solver.SmartMode = "parallel"
+		}
+	case "parallel", "multi-start":
+		solver.SmartMode = "parallel" // EIS smart mode from several starting points at once
 	case "levenberg-marquardt", "lm":
 		solver.SmartMode = "lm"
 	case "gradient-descent", "gd":
@@ -86,6 +129,8 @@ func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64,
 		solver.SmartMode = "lbfgs"
 	case "newton":
 		solver.SmartMode = "newton"
+	case "hybrid", "nm+lm":
+		solver.SmartMode = "nm+lm" // NM exploration followed by LM refinement
 	default:
 		log.Printf("Unknown optimization method '%s', using Nelder-Mead", method)
 		solver.SmartMode = "eis"
@@ -95,30 +140,9 @@ func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64,
 
 	// Time the optimization
 	startTime := time.Now()
-	res := solver.Solve(minFunc, maxIterations)
+	res, _ := solver.SolveWithContext(ctx, minFunc, iterationLimit(cfg))
 	duration := time.Since(startTime)
 
-	// Ensure consistent chi-square calculation for all methods
-	// Skip recalculation for EIS mode as it handles scaling internally
-	if res.Status != "ERROR" && len(res.Params) > 0 && (res.MinUnit != "ChiSq" || method != "levenberg-marquardt") && cfg.SmartMode != "eis" {
-		// Debug the recalculation process
-		theoreticalImp := goimpcore.CircuitImpedance(code, freqs, res.Params)
-
-		actualChiSq := goimpcore.ChiSq(impData, theoreticalImp, solver.Weighting)
-		log.Printf("DEBUG: ChiSq calculation result: %v (weighting: %v)", actualChiSq, solver.Weighting)
-
-		// Check if recalculation produces NaN
-		if math.IsNaN(actualChiSq) || math.IsInf(actualChiSq, 0) {
-			log.Printf("WARNING: Recalculated chi-square is invalid (%v), keeping original result.Min (%v)", actualChiSq, res.Min)
-		} else {
-			log.Printf("INFO: Using recalculated chi-square (%v) instead of original (%v)", actualChiSq, res.Min)
-			res.Min = actualChiSq
-			res.MinUnit = "ChiSq"
-		}
-	} else if cfg.SmartMode == "eis" {
-		log.Printf("INFO: Skipping chi-square recalculation for EIS mode (scaling handled internally)")
-	}
-
 	if res.Status == "ERROR" {
 		log.Printf("EIS processing FAILED - Method: %s, Status: %s", method, res.Status)
 	} else {
@@ -130,6 +154,19 @@ func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64,
 			log.Printf("Method: %s FAILED - Status=%s", method, res.Status)
 		} else {
 			log.Printf("Method: %s, Min=%.12e, Params=%v, Status=%s", method, res.Min, res.Params, res.Status)
+			log.Printf("Method: %s, RedChiSq=%.6e, R2=%.6f, AIC=%.4f, BIC=%.4f, DoF=%d",
+				method, res.Stats.ReducedChiSq, res.Stats.RSquared, res.Stats.AIC, res.Stats.BIC, res.Stats.DoF)
+		}
+	}
+
+	if cfg.Sensitivity && res.Status == goimpcore.OK && len(res.Params) > 0 {
+		sensitivity := goimpcore.SensitivityAnalysis(solver, res, goimpcore.DefaultSensitivityStep)
+		res.SetPayload(goimpcore.PayloadSensitivity, sensitivity)
+		if !cfg.Quiet {
+			for _, sens := range sensitivity {
+				log.Printf("Sensitivity param %s: dChiSq/dParam=%.6e, relative=%.6e",
+					sens.ParamName, sens.DChiSqDParam, sens.RelativeSensitivity)
+			}
 		}
 	}
 
@@ -137,24 +174,29 @@ func (p *EISProcessor) runSingleOptimizationMethod(code string, freqs []float64,
 	return res, nil
 }
 
-func (p *EISProcessor) runAllOptimizationMethods(code string, freqs []float64, impData [][2]float64, cfg *config.Config) (goimpcore.Result, error) {
+func (p *EISProcessor) runAllOptimizationMethods(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) (goimpcore.Result, error) {
 	methods := []string{"nelder-mead", "levenberg-marquardt", "gradient-descent", "lbfgs", "newton"}
 	var bestResult goimpcore.Result
-	bestChiSq := math.Inf(1)
+	bestScore := math.Inf(1)
 
 	log.Printf("Running all optimization methods for comparison...")
 
 	for _, method := range methods {
+		if ctx.Err() != nil {
+			// Cancelled, keep the best of the methods run so far
+			log.Printf("Method comparison stopped before %s: %v", method, ctx.Err())
+			break
+		}
 		log.Printf("Testing method: %s", method)
-		result, err := p.runSingleOptimizationMethod(code, freqs, impData, cfg, method)
+		result, err := p.runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, method)
 		if err != nil {
 			continue
 		}
 
-		if result.Status != "ERROR" && result.Min < bestChiSq {
+		if score := result.Score(cfg.Criterion); result.Status != "ERROR" && score < bestScore {
 			bestResult = result
-			bestChiSq = result.Min
-			log.Printf("New best method: %s with chi-square: %.12e", method, result.Min)
+			bestScore = score
+			log.Printf("New best method: %s with chi-square: %.12e, AIC: %.4f", method, result.Min, result.Stats.AIC)
 		}
 	}
 
@@ -167,47 +209,43 @@ func (p *EISProcessor) runAllOptimizationMethods(code string, freqs []float64, i
 		}, fmt.Errorf("all optimization methods failed")
 	}
 
-	log.Printf("Best overall result: chi-square=%.12e", bestResult.Min)
+	log.Printf("Best overall result: chi-square=%.12e, AIC=%.4f, BIC=%.4f", bestResult.Min, bestResult.Stats.AIC, bestResult.Stats.BIC)
 	return bestResult, nil
 }
 
-// generateInitialValues creates reasonable default initial values for different circuit codes
-func (p *EISProcessor) generateInitialValues(code string) []float64 {
-	switch strings.ToLower(code) {
-	case "r(cr)":
-		// R1, C1, R2
-		return []float64{50.0, 1e-6, 100.0}
-	case "r(qr)":
-		// R1, Q1_Y0, Q1_n, R2
-		return []float64{50.0, 1e-6, 0.8, 100.0}
-	case "r(cr)(cr)":
-		// R1, C1, R2, C2, R3 (5 parameters)
-		return []float64{50.0, 1e-6, 100.0, 1e-6, 100.0}
-	case "r(q(r(qr)))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	case "r(q(r(q(r(qr)))))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3, Q3_Y0, Q3_n, R4
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	default:
-		// Generic fallback: assume 4 parameters for R(QR) since that's our default
-		log.Printf("Warning: Unknown circuit code '%s', using R(QR) 4-parameter defaults", code)
-		return []float64{50.0, 1e-6, 0.8, 100.0}
+// generateInitialValues returns the circuit library defaults for code, or generic defaults for unknown circuits.
+// The first L element starts from the inductance of the inductive loop of the spectrum when it has one.
+func (p *EISProcessor) generateInitialValues(code string, freqs []float64, impData [][2]float64) []float64 {
+	if params := circuits.Default().DefaultParams(code); params != nil {
+		return goimpcore.InitInductance(code, params, freqs, impData)
 	}
+	// Generic fallback: assume 4 parameters for R(QR) since that's our default
+	log.Printf("Warning: Unknown circuit code '%s', using R(QR) 4-parameter defaults", code)
+	return []float64{50.0, 1e-6, 0.8, 100.0}
 }
 
 // ProcessorFunc creates a function compatible with the worker pool
-func (p *EISProcessor) ProcessorFunc() func(freqs []float64, impData [][2]float64, config *config.Config) interface{} {
-	return func(freqs []float64, impData [][2]float64, config *config.Config) interface{} {
-		result, err := p.Process(freqs, impData, config)
+func (p *EISProcessor) ProcessorFunc() func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) interface{} {
+	return func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) interface{} {
+		result, err := p.Process(ctx, freqs, impData, sigmas, config)
 		if err != nil {
 			log.Printf("EIS processing error: %v", err)
 			return goimpcore.Result{
-				Status: "ERROR",
-				Min:    0.0,
-				Params: []float64{},
+				Status:  "ERROR",
+				Min:     0.0,
+				Params:  []float64{},
+				Payload: result.Payload, // keeps the ranking of a failed circuit comparison
 			}
 		}
 		return result
 	}
 }
+
+// iterationLimit returns the solver restarts of a fit, maxIterations unless
+// cfg sets them
+func iterationLimit(cfg *config.Config) int {
+	if cfg.MaxIterations > 0 {
+		return cfg.MaxIterations
+	}
+	return maxIterations
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "context"
+
+// RequestIDHeader carries the request ID of HTTP requests and of the
+// webhooks they lead to
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, empty when none or when
+// ctx is nil
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDOrNew returns the request ID carried by ctx, a new one when none
+func RequestIDOrNew(ctx context.Context) string {
+	if id := RequestID(ctx); id != "" {
+		return id
+	}
+	return GenerateID()
+}
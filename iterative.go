@@ -0,0 +1,54 @@
+package goimpcore
+
+import "context"
+
+// IterativeResult is a value sent by SolveIterative
+type IterativeResult struct {
+	Iteration  int    // number of iterations done, counted from 1
+	Result     Result // best result so far, the final result when IsComplete
+	IsComplete bool   // the solve is over, this is the last value
+}
+
+// SolveIterative runs SolveWithContext in the background and sends the best
+// result after every iteration of the EIS mode, then the final result with
+// IsComplete set before closing the channel. The other modes send the final
+// result only. When ctx is done the solve stops and the best result so far is
+// sent as the final one. The channel must be read until it is closed, and the
+// solver must not be used meanwhile.
+func (s *Solver) SolveIterative(ctx context.Context, minFunc float64, maxIterations int) <-chan IterativeResult {
+	results := make(chan IterativeResult)
+	go func() {
+		defer close(results)
+
+		iteration := 0
+		s.progress = func(res Result) {
+			iteration++
+			select {
+			case results <- IterativeResult{Iteration: iteration, Result: res}:
+			case <-ctx.Done():
+			}
+		}
+		res, _ := s.SolveWithContext(ctx, minFunc, maxIterations)
+		s.progress = nil
+
+		results <- IterativeResult{Iteration: iteration, Result: res, IsComplete: true}
+	}()
+	return results
+}
+
+// reportProgress passes res, found by eisSolve on the data normalized by
+// scaleCoef, to the progress callback in the units of the original data
+func (s *Solver) reportProgress(res Result, elements []string, scaleCoef float64, sigmas [][2]float64) {
+	if s.progress == nil || len(res.Params) != len(elements) {
+		return
+	}
+	res.Params = append([]float64(nil), res.Params...)
+	scaleParams(&res.Params, elements, scaleCoef)
+
+	observed := make([][2]float64, len(s.Observed))
+	copy(observed, s.Observed)
+	scaleData(&observed, scaleCoef)
+	res.Min = s.chiSq(observed, s.impedance(s.Freqs, res.Params), sigmas)
+	res.MinUnit = "ChiSq"
+	s.progress(res)
+}
@@ -0,0 +1,55 @@
+package goimpcore
+
+import "strings"
+
+// ParamInfo describes one fitted parameter of a circuit
+type ParamInfo struct {
+	Index      int     `json:"index"`
+	Token      string  `json:"token"` // as returned by GetElements
+	Label      string  `json:"label"` // as returned by ParamLabels, e.g. Q1_Y0
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	DefaultMin float64 `json:"default_min"`
+	DefaultMax float64 `json:"default_max"`
+}
+
+// paramTokens describes the parameter tokens of GetElements, Index and Label
+// are filled in by ParameterInfo
+var paramTokens = map[string]ParamInfo{
+	"r":  {Name: "Resistance", Unit: "Ω", DefaultMin: 0, DefaultMax: 1e7},
+	"c":  {Name: "Capacitance", Unit: "F", DefaultMin: 1e-12, DefaultMax: 1e-3},
+	"l":  {Name: "Inductance", Unit: "H", DefaultMin: 0, DefaultMax: 1e-3},
+	"w":  {Name: "Warburg Admittance", Unit: "S·s^0.5", DefaultMin: 1e-9, DefaultMax: 1},
+	"qy": {Name: "CPE Admittance", Unit: "S·s^n", DefaultMin: 1e-12, DefaultMax: 1e-2},
+	"qn": {Name: "CPE Exponent", Unit: "dimensionless", DefaultMin: 0, DefaultMax: 1},
+	"oy": {Name: "Finite Length Warburg Admittance", Unit: "S·s^0.5", DefaultMin: 1e-9, DefaultMax: 1},
+	"ob": {Name: "Finite Length Warburg Time Constant", Unit: "s^0.5", DefaultMin: 1e-3, DefaultMax: 1e2},
+	"ty": {Name: "Finite Space Warburg Admittance", Unit: "S·s^0.5", DefaultMin: 1e-9, DefaultMax: 1},
+	"tb": {Name: "Finite Space Warburg Time Constant", Unit: "s^0.5", DefaultMin: 1e-3, DefaultMax: 1e2},
+	"gy": {Name: "Gerischer Admittance", Unit: "S·s^0.5", DefaultMin: 1e-9, DefaultMax: 1},
+	"gk": {Name: "Gerischer Rate Constant", Unit: "1/s", DefaultMin: 1e-3, DefaultMax: 1e6},
+	"pr": {Name: "Ionic Resistance", Unit: "Ω", DefaultMin: 0, DefaultMax: 1e7},
+	"py": {Name: "Interfacial Capacitance", Unit: "F", DefaultMin: 1e-12, DefaultMax: 1e-1},
+	"fy": {Name: "Fractal Gerischer Admittance", Unit: "S·s^a", DefaultMin: 1e-9, DefaultMax: 1},
+	"fk": {Name: "Fractal Gerischer Rate Constant", Unit: "1/s", DefaultMin: 1e-3, DefaultMax: 1e6},
+	"fa": {Name: "Fractal Gerischer Exponent", Unit: "dimensionless", DefaultMin: 0, DefaultMax: 1},
+}
+
+// ParameterInfo returns the name, SI unit and default range of every fitted
+// parameter of the circuit described by code, in parameter order
+func ParameterInfo(code string) []ParamInfo {
+	code = strings.ToLower(code)
+	tokens := GetElements(code)
+	labels := ParamLabels(code)
+
+	info := make([]ParamInfo, len(tokens))
+	for i, token := range tokens {
+		info[i] = paramTokens[token]
+		info[i].Index = i
+		info[i].Token = token
+		if i < len(labels) {
+			info[i].Label = labels[i]
+		}
+	}
+	return info
+}
@@ -0,0 +1,64 @@
+package goimpcore
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Every element type has named parameters with a unit and a default range
+func TestParameterInfoElements(t *testing.T) {
+	tests := []struct {
+		code   string
+		tokens []string
+		labels []string
+		units  []string
+	}{
+		{"R", []string{"r"}, []string{"R1"}, []string{"Ω"}},
+		{"C", []string{"c"}, []string{"C1"}, []string{"F"}},
+		{"L", []string{"l"}, []string{"L1"}, []string{"H"}},
+		{"W", []string{"w"}, []string{"W1"}, []string{"S·s^0.5"}},
+		{"Q", []string{"qy", "qn"}, []string{"Q1_Y0", "Q1_n"}, []string{"S·s^n", "dimensionless"}},
+		{"O", []string{"oy", "ob"}, []string{"O1_Y0", "O1_B"}, []string{"S·s^0.5", "s^0.5"}},
+		{"T", []string{"ty", "tb"}, []string{"T1_Y0", "T1_B"}, []string{"S·s^0.5", "s^0.5"}},
+		{"G", []string{"gy", "gk"}, []string{"G1_Y0", "G1_k"}, []string{"S·s^0.5", "1/s"}},
+		{"P", []string{"pr", "py"}, []string{"P1_Ri", "P1_Yi"}, []string{"Ω", "F"}},
+		{"F", []string{"fy", "fk", "fa"}, []string{"F1_Y0", "F1_k", "F1_a"}, []string{"S·s^a", "1/s", "dimensionless"}},
+	}
+	covered := make(map[rune]bool)
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			covered[rune(tt.code[0]-'A'+'a')] = true
+			info := ParameterInfo(tt.code)
+			if len(info) != len(tt.tokens) {
+				t.Fatalf("%d parameters, want %d", len(info), len(tt.tokens))
+			}
+			for i, p := range info {
+				if p.Index != i || p.Token != tt.tokens[i] || p.Label != tt.labels[i] || p.Unit != tt.units[i] {
+					t.Errorf("parameter %d = %+v, want token %s, label %s, unit %s", i, p, tt.tokens[i], tt.labels[i], tt.units[i])
+				}
+				if p.Name == "" || !(p.DefaultMin < p.DefaultMax) {
+					t.Errorf("parameter %d = %+v, want a name and a range", i, p)
+				}
+			}
+		})
+	}
+	for element := range elementParams {
+		if !covered[element] {
+			t.Errorf("element %c not covered", element-'a'+'A')
+		}
+	}
+}
+
+func TestParameterInfoCircuit(t *testing.T) {
+	info := ParameterInfo("R(QR)(CR)")
+	var labels, names []string
+	for _, p := range info {
+		labels = append(labels, p.Label)
+		names = append(names, p.Name)
+	}
+	wantLabels := []string{"R1", "Q1_Y0", "Q1_n", "R2", "C1", "R3"}
+	wantNames := []string{"Resistance", "CPE Admittance", "CPE Exponent", "Resistance", "Capacitance", "Resistance"}
+	if !reflect.DeepEqual(labels, wantLabels) || !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("labels %v, names %v, want %v and %v", labels, names, wantLabels, wantNames)
+	}
+}
@@ -0,0 +1,196 @@
+package analysis
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// Constants converting the slope of ln(k) against 1/T into an activation
+// energy
+const (
+	BoltzmannEV = 8.617333262e-5 // Boltzmann constant, eV/K
+	KJPerMolEV  = 96.485332      // kJ/mol of 1 eV per particle, the Faraday constant / 1000
+)
+
+// ArrheniusResult is the straight line fitted to ln(k) against 1/T of a rate
+// k = A·exp(-Ea/(kB·T)). ActivationEnergy is Ea in eV, ActivationEnergyKJMol
+// the same in kJ/mol and PreFactor is A, in the unit of k. Residuals are
+// ln(k) minus the line, one per temperature.
+type ArrheniusResult struct {
+	ActivationEnergy      float64   `json:"activation_energy_ev"`
+	ActivationEnergyKJMol float64   `json:"activation_energy_kj_mol"`
+	PreFactor             float64   `json:"pre_factor"`
+	R2                    float64   `json:"r2"`
+	Residuals             []float64 `json:"residuals"`
+}
+
+// ArrheniusAnalysis fits the Arrhenius equation to the parameter paramIndex
+// of params, the fitted parameters at each of temperatures in kelvin. The
+// parameter is the rate, a conductance or a capacitance for example, the
+// inverse of a resistance has to be passed for a resistance. Fewer than 2
+// points, slices of different lengths, a missing parameter or a non
+// positive temperature or parameter give NaN fields.
+func ArrheniusAnalysis(temperatures []float64, params [][]float64, paramIndex int) ArrheniusResult {
+	x, y, ok := arrheniusPoints(temperatures, params, paramIndex, 2)
+	if !ok {
+		return nanArrhenius()
+	}
+
+	intercept, slope := stat.LinearRegression(x, y, nil, false)
+	ea := -slope * BoltzmannEV
+	return ArrheniusResult{
+		ActivationEnergy:      ea,
+		ActivationEnergyKJMol: ea * KJPerMolEV,
+		PreFactor:             math.Exp(intercept),
+		R2:                    rSquared(x, y, intercept, slope),
+		Residuals:             lineResiduals(x, y, intercept, slope),
+	}
+}
+
+// VTFResult is the Vogel-Tammann-Fulcher equation k = A·exp(-B/(kB·(T-T0)))
+// fitted to a rate, the conductivity of a polymer electrolyte for example,
+// whose ln(k) curves against 1/T. PseudoActivationEnergy is B in eV and
+// PseudoActivationEnergyKJMol the same in kJ/mol, T0 the Vogel temperature
+// in kelvin. Residuals are ln(k) minus the fit.
+type VTFResult struct {
+	PseudoActivationEnergy      float64   `json:"pseudo_activation_energy_ev"`
+	PseudoActivationEnergyKJMol float64   `json:"pseudo_activation_energy_kj_mol"`
+	PreFactor                   float64   `json:"pre_factor"`
+	T0                          float64   `json:"t0"`
+	R2                          float64   `json:"r2"`
+	Residuals                   []float64 `json:"residuals"`
+}
+
+// vtfGrid is the number of Vogel temperatures tried between 0 and the
+// lowest temperature before the best one is refined
+const vtfGrid = 200
+
+// VTFAnalysis fits the VTF equation to the parameter paramIndex of params
+// like ArrheniusAnalysis. For every Vogel temperature T0 below the lowest
+// temperature ln(k) is linear in 1/(T-T0), T0 is the one whose line leaves
+// the smallest squared residuals, searched on a grid then refined by golden
+// section. Fewer than 4 points give NaN fields, as the invalid inputs of
+// ArrheniusAnalysis.
+func VTFAnalysis(temperatures []float64, params [][]float64, paramIndex int) VTFResult {
+	invT, y, ok := arrheniusPoints(temperatures, params, paramIndex, 4)
+	if !ok {
+		return nanVTF()
+	}
+	minT := math.Inf(1)
+	for _, t := range temperatures {
+		minT = math.Min(minT, t)
+	}
+
+	x := make([]float64, len(y))
+	sse := func(t0 float64) float64 {
+		for i := range invT {
+			x[i] = 1 / (1/invT[i] - t0)
+		}
+		intercept, slope := stat.LinearRegression(x, y, nil, false)
+		var sum float64
+		for _, r := range lineResiduals(x, y, intercept, slope) {
+			sum += r * r
+		}
+		return sum
+	}
+
+	// T0 stays a kelvin below the lowest temperature, where 1/(T-T0) diverges
+	upper := minT - 1
+	if upper <= 0 {
+		return nanVTF()
+	}
+	step := upper / vtfGrid
+	best, bestSSE := 0.0, sse(0)
+	for i := 1; i <= vtfGrid; i++ {
+		if s := sse(float64(i) * step); s < bestSSE {
+			best, bestSSE = float64(i)*step, s
+		}
+	}
+	t0 := goldenSection(sse, math.Max(0, best-step), math.Min(upper, best+step))
+
+	for i := range invT {
+		x[i] = 1 / (1/invT[i] - t0)
+	}
+	intercept, slope := stat.LinearRegression(x, y, nil, false)
+	b := -slope * BoltzmannEV
+	return VTFResult{
+		PseudoActivationEnergy:      b,
+		PseudoActivationEnergyKJMol: b * KJPerMolEV,
+		PreFactor:                   math.Exp(intercept),
+		T0:                          t0,
+		R2:                          rSquared(x, y, intercept, slope),
+		Residuals:                   lineResiduals(x, y, intercept, slope),
+	}
+}
+
+// arrheniusPoints returns 1/T and ln(k) of the parameter paramIndex of
+// params, ok false for invalid inputs or fewer than minPoints points
+func arrheniusPoints(temperatures []float64, params [][]float64, paramIndex, minPoints int) (x, y []float64, ok bool) {
+	n := len(temperatures)
+	if n < minPoints || len(params) != n || paramIndex < 0 {
+		return nil, nil, false
+	}
+	x = make([]float64, n)
+	y = make([]float64, n)
+	for i, t := range temperatures {
+		if paramIndex >= len(params[i]) {
+			return nil, nil, false
+		}
+		k := params[i][paramIndex]
+		if !(t > 0) || !(k > 0) || math.IsInf(t, 0) || math.IsInf(k, 0) {
+			return nil, nil, false
+		}
+		x[i] = 1 / t
+		y[i] = math.Log(k)
+	}
+	return x, y, true
+}
+
+// lineResiduals returns y minus the line intercept + slope*x
+func lineResiduals(x, y []float64, intercept, slope float64) []float64 {
+	residuals := make([]float64, len(y))
+	for i := range y {
+		residuals[i] = y[i] - (intercept + slope*x[i])
+	}
+	return residuals
+}
+
+// rSquared is the coefficient of determination of the line, 1 for a constant
+// y which the line fits exactly
+func rSquared(x, y []float64, intercept, slope float64) float64 {
+	r2 := stat.RSquared(x, y, nil, intercept, slope)
+	if math.IsNaN(r2) && slope == 0 {
+		return 1
+	}
+	return r2
+}
+
+// goldenSection returns the minimum of f on [a, b], assumed unimodal there
+func goldenSection(f func(float64) float64, a, b float64) float64 {
+	ratio := (math.Sqrt(5) - 1) / 2
+	c, d := b-ratio*(b-a), a+ratio*(b-a)
+	fc, fd := f(c), f(d)
+	for iter := 0; iter < 100 && b-a > 1e-9*math.Max(1, math.Abs(b)); iter++ {
+		if fc < fd {
+			b, d, fd = d, c, fc
+			c = b - ratio*(b-a)
+			fc = f(c)
+		} else {
+			a, c, fc = c, d, fd
+			d = a + ratio*(b-a)
+			fd = f(d)
+		}
+	}
+	return (a + b) / 2
+}
+
+func nanArrhenius() ArrheniusResult {
+	nan := math.NaN()
+	return ArrheniusResult{ActivationEnergy: nan, ActivationEnergyKJMol: nan, PreFactor: nan, R2: nan}
+}
+
+func nanVTF() VTFResult {
+	nan := math.NaN()
+	return VTFResult{PseudoActivationEnergy: nan, PseudoActivationEnergyKJMol: nan, PreFactor: nan, T0: nan, R2: nan}
+}
@@ -0,0 +1,91 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+// arrheniusData returns the temperatures from 300 to 400 K and the rate
+// a·exp(-ea/(kB·T)) at each, as parameter 1 of a fit, scaled by 1+noise[i]
+func arrheniusData(a, ea float64, noise []float64) (temperatures []float64, params [][]float64) {
+	for i := 0; i < 11; i++ {
+		t := 300 + 10*float64(i)
+		k := a * math.Exp(-ea/(BoltzmannEV*t))
+		if noise != nil {
+			k *= 1 + noise[i]
+		}
+		temperatures = append(temperatures, t)
+		params = append(params, []float64{10, k})
+	}
+	return temperatures, params
+}
+
+// Rates of a known Ea = 0.5 eV give it back within 1%, with 1% scatter too
+func TestArrheniusActivationEnergy(t *testing.T) {
+	const a, ea = 1e6, 0.5
+	tests := []struct {
+		name  string
+		noise []float64
+	}{
+		{"exact", nil},
+		{"scatter", []float64{0.01, -0.01, 0.005, -0.008, 0, 0.01, -0.004, 0.007, -0.01, 0.002, -0.006}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			temperatures, params := arrheniusData(a, ea, tt.noise)
+			res := ArrheniusAnalysis(temperatures, params, 1)
+			if !within(res.ActivationEnergy, ea, 0.01) {
+				t.Errorf("Ea %v eV, want %v within 1%%", res.ActivationEnergy, ea)
+			}
+			if !within(res.ActivationEnergyKJMol, ea*KJPerMolEV, 0.01) {
+				t.Errorf("Ea %v kJ/mol, want %v within 1%%", res.ActivationEnergyKJMol, ea*KJPerMolEV)
+			}
+			if !within(res.PreFactor, a, 0.1) || res.R2 < 0.99 || len(res.Residuals) != 11 {
+				t.Errorf("A %v, R² %v, %d residuals, want %v, about 1, 11", res.PreFactor, res.R2, len(res.Residuals), a)
+			}
+		})
+	}
+}
+
+// Invalid inputs give NaN fields
+func TestArrheniusInvalid(t *testing.T) {
+	temperatures, params := arrheniusData(1e6, 0.5, nil)
+	tests := []struct {
+		name         string
+		temperatures []float64
+		params       [][]float64
+		index        int
+	}{
+		{"one point", temperatures[:1], params[:1], 1},
+		{"lengths", temperatures, params[1:], 1},
+		{"index", temperatures, params, 2},
+		{"zero kelvin", append([]float64{0}, temperatures[1:]...), params, 1},
+		{"negative rate", temperatures[:2], [][]float64{{1, -1}, {1, 1}}, 1},
+	}
+	for _, tt := range tests {
+		if res := ArrheniusAnalysis(tt.temperatures, tt.params, tt.index); !math.IsNaN(res.ActivationEnergy) {
+			t.Errorf("%s: Ea %v, want NaN", tt.name, res.ActivationEnergy)
+		}
+	}
+}
+
+// The conductivity of a VTF law gives back its pseudo activation energy and
+// Vogel temperature
+func TestVTFAnalysis(t *testing.T) {
+	const a, b, t0 = 10, 0.08, 200
+	var (
+		temperatures []float64
+		params       [][]float64
+	)
+	for temp := 260.0; temp <= 380; temp += 10 {
+		temperatures = append(temperatures, temp)
+		params = append(params, []float64{a * math.Exp(-b/(BoltzmannEV*(temp-t0)))})
+	}
+	res := VTFAnalysis(temperatures, params, 0)
+	if !within(res.PseudoActivationEnergy, b, 0.01) || !within(res.T0, t0, 0.01) || !within(res.PreFactor, a, 0.01) {
+		t.Errorf("B %v eV, T0 %v K, A %v, want %v, %v, %v", res.PseudoActivationEnergy, res.T0, res.PreFactor, b, t0, a)
+	}
+	if res := VTFAnalysis(temperatures[:3], params[:3], 0); !math.IsNaN(res.T0) {
+		t.Errorf("3 points: T0 %v, want NaN", res.T0)
+	}
+}
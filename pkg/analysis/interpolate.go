@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrExtrapolation is returned for frequencies outside the measured range
+var ErrExtrapolation = errors.New("frequency outside the measured range")
+
+// InterpolateImpedance returns the impedance srcImpData, measured at
+// srcFreqs, at dstFreqs. The real and imaginary parts are interpolated
+// separately, linearly in log frequency, the axis along which impedance
+// spectra vary smoothly. srcFreqs must be positive and strictly monotonic,
+// ascending or descending, and dstFreqs within [srcFreqs[0],
+// srcFreqs[last]], it fails with ErrExtrapolation otherwise. At a measured
+// frequency the measured impedance is returned exactly.
+func InterpolateImpedance(srcFreqs []float64, srcImpData [][2]float64, dstFreqs []float64) ([][2]float64, error) {
+	logF, imp, err := ascendingLog(srcFreqs, srcImpData)
+	if err != nil {
+		return nil, err
+	}
+
+	low, high := srcFreqs[0], srcFreqs[len(srcFreqs)-1]
+	if low > high {
+		low, high = high, low
+	}
+	out := make([][2]float64, len(dstFreqs))
+	for i, f := range dstFreqs {
+		if !(f >= low && f <= high) {
+			return nil, fmt.Errorf("%w: %v Hz, measured %v..%v Hz", ErrExtrapolation, f, low, high)
+		}
+		x := math.Log10(f)
+		// First measured frequency at or above f, the ends guard against
+		// the rounding of the logarithms
+		j := sort.SearchFloat64s(logF, x)
+		switch {
+		case j == len(logF):
+			out[i] = imp[j-1]
+		case j == 0 || logF[j] == x:
+			out[i] = imp[j]
+		default:
+			t := (x - logF[j-1]) / (logF[j] - logF[j-1])
+			for k := 0; k < 2; k++ {
+				out[i][k] = imp[j-1][k] + t*(imp[j][k]-imp[j-1][k])
+			}
+		}
+	}
+	return out, nil
+}
+
+// ResampleLogUniform resamples the spectrum impData, measured at freqs, to
+// nPoints frequencies spaced evenly in log frequency between the first and
+// the last one, in the order of freqs, see InterpolateImpedance
+func ResampleLogUniform(freqs []float64, impData [][2]float64, nPoints int) ([]float64, [][2]float64, error) {
+	if nPoints < 2 {
+		return nil, nil, fmt.Errorf("cannot resample to %d points, at least 2 are needed", nPoints)
+	}
+	if len(freqs) < 2 {
+		return nil, nil, fmt.Errorf("cannot resample %d points, at least 2 are needed", len(freqs))
+	}
+
+	first, last := freqs[0], freqs[len(freqs)-1]
+	start, step := math.Log10(first), (math.Log10(last)-math.Log10(first))/float64(nPoints-1)
+	grid := make([]float64, nPoints)
+	for i := range grid {
+		grid[i] = math.Pow(10, start+float64(i)*step)
+	}
+	// The ends are the measured frequencies, not their rounded logarithms
+	grid[0], grid[nPoints-1] = first, last
+
+	resampled, err := InterpolateImpedance(freqs, impData, grid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return grid, resampled, nil
+}
+
+// ascendingLog returns log10 of freqs and impData in ascending frequency
+// order, checking that freqs is positive and strictly monotonic
+func ascendingLog(freqs []float64, impData [][2]float64) ([]float64, [][2]float64, error) {
+	n := len(freqs)
+	if n < 2 {
+		return nil, nil, fmt.Errorf("cannot interpolate %d points, at least 2 are needed", n)
+	}
+	if len(impData) != n {
+		return nil, nil, fmt.Errorf("%d frequencies but %d impedance points", n, len(impData))
+	}
+
+	descending := freqs[0] > freqs[n-1]
+	logF := make([]float64, n)
+	imp := make([][2]float64, n)
+	for i, f := range freqs {
+		if !(f > 0) || math.IsInf(f, 0) {
+			return nil, nil, fmt.Errorf("frequency %v at index %d is not positive and finite", f, i)
+		}
+		if i > 0 && (f == freqs[i-1] || (f < freqs[i-1]) != descending) {
+			return nil, nil, fmt.Errorf("frequencies are not strictly monotonic at index %d", i)
+		}
+		j := i
+		if descending {
+			j = n - 1 - i
+		}
+		logF[j] = math.Log10(f)
+		imp[j] = impData[i]
+	}
+	return logF, imp, nil
+}
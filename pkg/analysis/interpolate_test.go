@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// residual is the largest distance between the points of got and want,
+// relative to the modulus of want
+func residual(got, want [][2]float64) float64 {
+	var worst float64
+	for i := range want {
+		d := math.Hypot(got[i][0]-want[i][0], got[i][1]-want[i][1]) / math.Hypot(want[i][0], want[i][1])
+		worst = math.Max(worst, d)
+	}
+	return worst
+}
+
+// Interpolating a spectrum back to its own frequencies, ascending or
+// descending, leaves residuals below 1e-12
+func TestInterpolateRoundTrip(t *testing.T) {
+	freqs, zr, zi := arc(t, "r(qr)", []float64{10, 1e-5, 0.85, 1000})
+	imp := make([][2]float64, len(freqs))
+	for i := range freqs {
+		imp[i] = [2]float64{zr[i], zi[i]}
+	}
+	descFreqs, descImp := slices.Clone(freqs), slices.Clone(imp)
+	slices.Reverse(descFreqs)
+	slices.Reverse(descImp)
+
+	for _, tt := range []struct {
+		name  string
+		freqs []float64
+		imp   [][2]float64
+	}{
+		{"ascending", freqs, imp},
+		{"descending", descFreqs, descImp},
+	} {
+		got, err := InterpolateImpedance(tt.freqs, tt.imp, tt.freqs)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r := residual(got, tt.imp); r >= 1e-12 {
+			t.Errorf("%s: residual %v, want below 1e-12", tt.name, r)
+		}
+	}
+}
+
+// Between two points the parts are linear in log frequency
+func TestInterpolateLogLinear(t *testing.T) {
+	got, err := InterpolateImpedance([]float64{1, 100}, [][2]float64{{10, -20}, {30, 0}}, []float64{10, math.Sqrt(10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]float64{{20, -10}, {15, -15}}
+	if r := residual(got, want); r >= 1e-12 {
+		t.Errorf("interpolated %v, want %v", got, want)
+	}
+}
+
+// Frequencies outside the measured range and invalid spectra are errors
+func TestInterpolateErrors(t *testing.T) {
+	freqs := []float64{1, 10, 100}
+	imp := [][2]float64{{1, 0}, {2, 0}, {3, 0}}
+	if _, err := InterpolateImpedance(freqs, imp, []float64{10, 1000}); !errors.Is(err, ErrExtrapolation) {
+		t.Errorf("above the range: %v, want ErrExtrapolation", err)
+	}
+	if _, err := InterpolateImpedance(freqs, imp, []float64{0.5}); !errors.Is(err, ErrExtrapolation) {
+		t.Errorf("below the range: %v, want ErrExtrapolation", err)
+	}
+	for name, src := range map[string][]float64{
+		"not monotonic": {1, 100, 10},
+		"duplicate":     {1, 10, 10},
+		"zero":          {0, 10, 100},
+	} {
+		if _, err := InterpolateImpedance(src, imp, []float64{10}); err == nil || errors.Is(err, ErrExtrapolation) {
+			t.Errorf("%s: %v, want an invalid spectrum error", name, err)
+		}
+	}
+	if _, err := InterpolateImpedance(freqs, imp[:2], []float64{10}); err == nil {
+		t.Error("2 points for 3 frequencies accepted")
+	}
+}
+
+// Resampling keeps the ends and spaces the frequencies evenly in log
+// frequency
+func TestResampleLogUniform(t *testing.T) {
+	freqs, zr, zi := arc(t, "r(qr)", []float64{10, 1e-5, 0.85, 1000})
+	imp := make([][2]float64, len(freqs))
+	for i := range freqs {
+		imp[i] = [2]float64{zr[i], zi[i]}
+	}
+	grid, resampled, err := ResampleLogUniform(freqs, imp, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grid) != 13 || len(resampled) != 13 || grid[0] != freqs[0] || grid[12] != freqs[len(freqs)-1] {
+		t.Fatalf("grid %v, want 13 frequencies from %v to %v", grid, freqs[0], freqs[len(freqs)-1])
+	}
+	for i := 1; i < len(grid); i++ {
+		if step := math.Log10(grid[i] / grid[i-1]); math.Abs(step-0.5) > 1e-12 {
+			t.Errorf("step %d is %v decades, want 0.5", i, step)
+		}
+	}
+	// 0.1 Hz to 100 kHz at 10 points per decade holds every half decade
+	want := goimpcore.CircuitImpedance("r(qr)", grid, []float64{10, 1e-5, 0.85, 1000})
+	if r := residual(resampled, want); r >= 1e-12 {
+		t.Errorf("residual %v at measured frequencies, want below 1e-12", r)
+	}
+
+	if _, _, err := ResampleLogUniform(freqs, imp, 1); err == nil {
+		t.Error("resampling to 1 point accepted")
+	}
+}
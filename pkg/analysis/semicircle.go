@@ -0,0 +1,200 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// SemicircleResult is a circle fitted to one arc of a Nyquist plot, in the
+// plot coordinates, the real part and minus the imaginary part of the
+// impedance. Arcs distorted by a CPE are depressed, their center lies below
+// the real axis, CenterY < 0.
+type SemicircleResult struct {
+	CenterX float64 `json:"center_x"`
+	CenterY float64 `json:"center_y"`
+	Radius  float64 `json:"radius"`
+	// Rs and Rp are the high frequency intercept of the arc with the real
+	// axis and its diameter along it, CenterX - Radius and 2*Radius for a
+	// circle centered on the axis
+	Rs float64 `json:"rs"`
+	Rp float64 `json:"rp"`
+	// C and Tau = Rp*C are the capacitance and time constant of the arc from
+	// its peak frequency, 0 when fitted without frequencies. C is an
+	// effective capacitance for depressed arcs.
+	C   float64 `json:"c"`
+	Tau float64 `json:"tau"`
+	// Fit is the root mean square distance of the points to the circle
+	Fit float64 `json:"fit"`
+}
+
+// FitSemicircle fits a circle to the impedance points zr + j*zi of one arc by
+// the algebraic fit of Taubin, which minimizes the squared distances of the
+// points to the circle, linearized. zi is the imaginary part as measured,
+// negative for capacitive arcs. The center is free, so depressed arcs are
+// fitted too. Fewer than 3 points, slices of different lengths or collinear
+// points give NaN fields.
+func FitSemicircle(zr, zi []float64) SemicircleResult {
+	n := len(zr)
+	if n < 3 || len(zi) != n {
+		return nanSemicircle()
+	}
+
+	var meanX, meanY float64
+	for i := range zr {
+		meanX += zr[i]
+		meanY -= zi[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+
+	// Moments of the centered points, z = x² + y²
+	var mxx, myy, mxy, mxz, myz, mzz float64
+	for i := range zr {
+		x, y := zr[i]-meanX, -zi[i]-meanY
+		z := x*x + y*y
+		mxx += x * x
+		myy += y * y
+		mxy += x * y
+		mxz += x * z
+		myz += y * z
+		mzz += z * z
+	}
+	mxx /= float64(n)
+	myy /= float64(n)
+	mxy /= float64(n)
+	mxz /= float64(n)
+	myz /= float64(n)
+	mzz /= float64(n)
+
+	// Smallest root of the characteristic polynomial of Taubin's fit by
+	// Newton's method, starting from 0
+	mz := mxx + myy
+	covXY := mxx*myy - mxy*mxy
+	varZ := mzz - mz*mz
+	a3 := 4 * mz
+	a2 := -3*mz*mz - mzz
+	a1 := varZ*mz + 4*covXY*mz - mxz*mxz - myz*myz
+	a0 := mxz*(mxz*myy-myz*mxy) + myz*(myz*mxx-mxz*mxy) - varZ*covXY
+
+	root, value := 0.0, a0
+	for iter := 0; iter < 100; iter++ {
+		slope := a1 + root*(2*a2+3*a3*root)
+		next := root - value/slope
+		if next == root || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		nextValue := a0 + next*(a1+next*(a2+next*a3))
+		if math.Abs(nextValue) >= math.Abs(value) {
+			break
+		}
+		root, value = next, nextValue
+	}
+
+	det := root*root - root*mz + covXY
+	if det == 0 {
+		return nanSemicircle()
+	}
+	cx := (mxz*(myy-root) - myz*mxy) / det / 2
+	cy := (myz*(mxx-root) - mxz*mxy) / det / 2
+
+	res := SemicircleResult{
+		CenterX: cx + meanX,
+		CenterY: cy + meanY,
+		Radius:  math.Sqrt(cx*cx + cy*cy + mz),
+	}
+	if math.IsNaN(res.Radius) || math.IsInf(res.Radius, 0) {
+		return nanSemicircle()
+	}
+
+	// The chord along the real axis, the whole circle when it does not
+	// cross it
+	halfChord := res.Radius
+	if h := res.Radius*res.Radius - res.CenterY*res.CenterY; h > 0 {
+		halfChord = math.Sqrt(h)
+	}
+	res.Rs = res.CenterX - halfChord
+	res.Rp = 2 * halfChord
+
+	var ss float64
+	for i := range zr {
+		d := math.Hypot(zr[i]-res.CenterX, -zi[i]-res.CenterY) - res.Radius
+		ss += d * d
+	}
+	res.Fit = math.Sqrt(ss / float64(n))
+	return res
+}
+
+// FitSemicircleWithFrequencies is FitSemicircle also estimating the time
+// constant of the arc from its peak frequency, 1/(2π Tau), where the arc is
+// at its top. The peak is interpolated between the measured points around
+// it in log frequency, the highest point is taken when the arc is not
+// measured on both sides of it.
+func FitSemicircleWithFrequencies(freqs, zr, zi []float64) SemicircleResult {
+	res := FitSemicircle(zr, zi)
+	if len(freqs) != len(zr) || math.IsNaN(res.Radius) {
+		return res
+	}
+
+	fPeak := peakFrequency(res, freqs, zr, zi)
+	if fPeak <= 0 || res.Rp <= 0 {
+		return res
+	}
+	res.Tau = 1 / (2 * math.Pi * fPeak)
+	res.C = res.Tau / res.Rp
+	return res
+}
+
+// peakFrequency returns the frequency at the top of the arc res, 0 when
+// there are no positive frequencies
+func peakFrequency(res SemicircleResult, freqs, zr, zi []float64) float64 {
+	type point struct {
+		logF, angle, y float64
+	}
+	points := make([]point, 0, len(freqs))
+	for i, f := range freqs {
+		if f <= 0 {
+			continue
+		}
+		y := -zi[i]
+		points = append(points, point{
+			logF:  math.Log(f),
+			angle: math.Atan2(y-res.CenterY, zr[i]-res.CenterX),
+			y:     y,
+		})
+	}
+	if len(points) == 0 {
+		return 0
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].logF < points[j].logF })
+
+	// The angle around the center passes π/2 at the top of the arc
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		if (a.angle-math.Pi/2)*(b.angle-math.Pi/2) <= 0 && a.angle != b.angle {
+			t := (math.Pi/2 - a.angle) / (b.angle - a.angle)
+			return math.Exp(a.logF + t*(b.logF-a.logF))
+		}
+	}
+
+	highest := points[0]
+	for _, p := range points[1:] {
+		if p.y > highest.y {
+			highest = p
+		}
+	}
+	return math.Exp(highest.logF)
+}
+
+func nanSemicircle() SemicircleResult {
+	nan := math.NaN()
+	return SemicircleResult{
+		CenterX: nan,
+		CenterY: nan,
+		Radius:  nan,
+		Rs:      nan,
+		Rp:      nan,
+		C:       nan,
+		Tau:     nan,
+		Fit:     nan,
+	}
+}
@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// arc returns the impedance of code with params from 0.1 Hz to 100 kHz as
+// separate real and imaginary parts
+func arc(t *testing.T, code string, params []float64) (freqs, zr, zi []float64) {
+	t.Helper()
+	freqs, err := goimpcore.LogFrequencies(0.1, 1e5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, z := range goimpcore.CircuitImpedance(code, freqs, params) {
+		zr = append(zr, z[0])
+		zi = append(zi, z[1])
+	}
+	return freqs, zr, zi
+}
+
+// within reports whether got is within rel of want
+func within(got, want, rel float64) bool {
+	return math.Abs(got-want) <= rel*math.Abs(want)
+}
+
+// The arc of Rs in series with R parallel to C gives back Rs, R and C
+func TestFitSemicircleRC(t *testing.T) {
+	const rs, r, c = 10, 100, 1e-5
+	freqs, zr, zi := arc(t, "r(rc)", []float64{rs, r, c})
+	res := FitSemicircleWithFrequencies(freqs, zr, zi)
+	if !within(res.Rs, rs, 0.01) || !within(res.Rp, r, 0.01) || !within(res.C, c, 0.01) || !within(res.Tau, r*c, 0.01) {
+		t.Errorf("Rs %v, Rp %v, C %v, Tau %v, want %v, %v, %v, %v", res.Rs, res.Rp, res.C, res.Tau, rs, r, c, r*c)
+	}
+	if math.Abs(res.CenterY) > 1e-6*r || res.Fit > 1e-6*r {
+		t.Errorf("center %v below the axis, fit %v, want a circle on the axis", res.CenterY, res.Fit)
+	}
+}
+
+// The arc of a resistor parallel to a CPE is depressed below the real axis,
+// with the same intercepts
+func TestFitSemicircleDepressed(t *testing.T) {
+	const rs, r = 10, 100
+	_, zr, zi := arc(t, "r(qr)", []float64{rs, 1e-5, 0.8, r})
+	res := FitSemicircle(zr, zi)
+	if res.CenterY >= 0 || !within(res.Rs, rs, 0.01) || !within(res.Rp, r, 0.01) {
+		t.Errorf("center %v, Rs %v, Rp %v, want a depressed arc from %v spanning %v", res.CenterY, res.Rs, res.Rp, rs, r)
+	}
+	if res.C != 0 || res.Tau != 0 {
+		t.Errorf("C %v and Tau %v without frequencies", res.C, res.Tau)
+	}
+}
+
+func TestFitSemicircleDegenerate(t *testing.T) {
+	for name, points := range map[string][2][]float64{
+		"two points": {{1, 2}, {-1, -2}},
+		"collinear":  {{1, 2, 3}, {-1, -2, -3}},
+		"lengths":    {{1, 2, 3}, {-1, -2}},
+	} {
+		if res := FitSemicircle(points[0], points[1]); !math.IsNaN(res.Radius) {
+			t.Errorf("%s: radius %v, want NaN", name, res.Radius)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package circuits
+
+import (
+	"sort"
+	"strings"
+)
+
+// CircuitDef describes a named equivalent circuit together with typical
+// starting values for its parameters, in circuit code order
+type CircuitDef struct {
+	Name          string    `json:"name"`
+	Code          string    `json:"code"`
+	Description   string    `json:"description"`
+	DefaultParams []float64 `json:"default_params"`
+	ParamNames    []string  `json:"param_names"`
+	ParamUnits    []string  `json:"param_units"`
+}
+
+// Library holds named circuits, names are matched case-insensitively
+type Library struct {
+	circuits map[string]CircuitDef
+}
+
+// builtin are the circuits of the default library
+var builtin = []CircuitDef{
+	{
+		Name:          "RC",
+		Code:          "R(CR)",
+		Description:   "Solution resistance in series with a parallel RC, ideal single time constant",
+		DefaultParams: []float64{50.0, 1e-6, 100.0},
+		ParamNames:    []string{"Rs", "C", "R"},
+		ParamUnits:    []string{"Ohm", "F", "Ohm"},
+	},
+	{
+		Name:          "RQ",
+		Code:          "R(QR)",
+		Description:   "Solution resistance in series with a parallel R-CPE, depressed semicircle",
+		DefaultParams: []float64{50.0, 1e-6, 0.8, 100.0},
+		ParamNames:    []string{"Rs", "Q_Y0", "Q_n", "R"},
+		ParamUnits:    []string{"Ohm", "S*s^n", "", "Ohm"},
+	},
+	{
+		Name:          "TwoRC",
+		Code:          "R(CR)(CR)",
+		Description:   "Two parallel RC elements in series, two separated time constants",
+		DefaultParams: []float64{50.0, 1e-6, 100.0, 1e-6, 100.0},
+		ParamNames:    []string{"Rs", "C1", "R1", "C2", "R2"},
+		ParamUnits:    []string{"Ohm", "F", "Ohm", "F", "Ohm"},
+	},
+	{
+		Name:          "Randles",
+		Code:          "R(C(RW))",
+		Description:   "Randles cell: double layer capacitance parallel to charge transfer resistance and semi-infinite diffusion",
+		DefaultParams: []float64{10.0, 1e-5, 100.0, 1e-3},
+		ParamNames:    []string{"Rs", "Cdl", "Rct", "W_Y0"},
+		ParamUnits:    []string{"Ohm", "F", "Ohm", "S*s^0.5"},
+	},
+	{
+		Name:          "RandlesCPE",
+		Code:          "R(Q(RW))",
+		Description:   "Randles cell with a CPE in place of the double layer capacitance",
+		DefaultParams: []float64{10.0, 1e-5, 0.9, 100.0, 1e-3},
+		ParamNames:    []string{"Rs", "Qdl_Y0", "Qdl_n", "Rct", "W_Y0"},
+		ParamUnits:    []string{"Ohm", "S*s^n", "", "Ohm", "S*s^0.5"},
+	},
+	{
+		Name:          "RandlesFLW",
+		Code:          "R(Q(RO))",
+		Description:   "Randles cell with finite length (transmissive) Warburg diffusion",
+		DefaultParams: []float64{10.0, 1e-5, 0.9, 100.0, 1e-3, 1.0},
+		ParamNames:    []string{"Rs", "Qdl_Y0", "Qdl_n", "Rct", "O_Y0", "O_B"},
+		ParamUnits:    []string{"Ohm", "S*s^n", "", "Ohm", "S*s^0.5", "s^0.5"},
+	},
+	{
+		Name:          "Porous",
+		Code:          "RP",
+		Description:   "Solution resistance in series with a De Levie porous electrode",
+		DefaultParams: []float64{10.0, 100.0, 1e-3},
+		ParamNames:    []string{"Rs", "P_Ri", "P_Yi"},
+		ParamUnits:    []string{"Ohm", "Ohm", "F"},
+	},
+	{
+		Name:          "CorrosionCoating",
+		Code:          "R(Q(R(QR)))",
+		Description:   "Coated metal: coating capacitance and pore resistance nested with the double layer and charge transfer at the metal",
+		DefaultParams: []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0},
+		ParamNames:    []string{"Rs", "Qc_Y0", "Qc_n", "Rpore", "Qdl_Y0", "Qdl_n", "Rct"},
+		ParamUnits:    []string{"Ohm", "S*s^n", "", "Ohm", "S*s^n", "", "Ohm"},
+	},
+	{
+		Name:          "MultiLayerCoating",
+		Code:          "R(Q(R(Q(R(QR)))))",
+		Description:   "Three nested R-CPE layers, e.g. topcoat, primer and metal interface",
+		DefaultParams: []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0},
+		ParamNames:    []string{"Rs", "Q1_Y0", "Q1_n", "R1", "Q2_Y0", "Q2_n", "R2", "Q3_Y0", "Q3_n", "R3"},
+		ParamUnits:    []string{"Ohm", "S*s^n", "", "Ohm", "S*s^n", "", "Ohm", "S*s^n", "", "Ohm"},
+	},
+	{
+		Name:          "BatteryCell",
+		Code:          "LR(QR)(Q(RW))",
+		Description:   "Li-ion cell: cable inductance, ohmic resistance, SEI layer and charge transfer with solid state diffusion",
+		DefaultParams: []float64{1e-7, 0.05, 1e-3, 0.8, 0.02, 1e-2, 0.9, 0.05, 100.0},
+		ParamNames:    []string{"L", "Rs", "Qsei_Y0", "Qsei_n", "Rsei", "Qdl_Y0", "Qdl_n", "Rct", "W_Y0"},
+		ParamUnits:    []string{"H", "Ohm", "S*s^n", "", "Ohm", "S*s^n", "", "Ohm", "S*s^0.5"},
+	},
+	{
+		Name:          "Gerischer",
+		Code:          "R(CR)G",
+		Description:   "Mixed conducting electrode: electrolyte arc in series with a Gerischer element",
+		DefaultParams: []float64{10.0, 1e-6, 50.0, 1.0, 1.0},
+		ParamNames:    []string{"Rs", "C", "R", "G_Y0", "G_k"},
+		ParamUnits:    []string{"Ohm", "F", "Ohm", "S*s^0.5", "1/s"},
+	},
+}
+
+var defaultLibrary = NewLibrary(builtin...)
+
+// Default returns the library of built-in circuits
+func Default() *Library {
+	return defaultLibrary
+}
+
+// NewLibrary creates a library holding defs
+func NewLibrary(defs ...CircuitDef) *Library {
+	l := &Library{circuits: make(map[string]CircuitDef, len(defs))}
+	for _, def := range defs {
+		l.Add(def)
+	}
+	return l
+}
+
+// Add registers def under its name, replacing any circuit with the same name
+func (l *Library) Add(def CircuitDef) {
+	l.circuits[strings.ToLower(def.Name)] = def
+}
+
+// Get returns the circuit called name
+func (l *Library) Get(name string) (CircuitDef, bool) {
+	def, ok := l.circuits[strings.ToLower(name)]
+	return def, ok
+}
+
+// ByCode returns the first circuit, by name, with the given circuit code
+func (l *Library) ByCode(code string) (CircuitDef, bool) {
+	code = strings.ToLower(code)
+	for _, def := range l.All() {
+		if strings.ToLower(def.Code) == code {
+			return def, true
+		}
+	}
+	return CircuitDef{}, false
+}
+
+// All returns every circuit sorted by name
+func (l *Library) All() []CircuitDef {
+	defs := make([]CircuitDef, 0, len(l.circuits))
+	for _, def := range l.circuits {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// DefaultParams returns a copy of the default parameters of the circuit with
+// the given code, nil when the library has no such circuit
+func (l *Library) DefaultParams(code string) []float64 {
+	def, ok := l.ByCode(code)
+	if !ok {
+		return nil
+	}
+	return append([]float64(nil), def.DefaultParams...)
+}
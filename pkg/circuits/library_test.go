@@ -0,0 +1,43 @@
+package circuits_test
+
+import (
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
+)
+
+// Every library circuit is a valid code with a default, name and unit for
+// each of its parameters
+func TestDefaultLibraryValid(t *testing.T) {
+	for _, def := range circuits.Default().All() {
+		t.Run(def.Name, func(t *testing.T) {
+			if err := goimpcore.ValidateCircuit(def.Code); err != nil {
+				t.Fatalf("%s: %v", def.Code, err)
+			}
+			circuit, err := goimpcore.CompileCircuit(def.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := circuit.NumParams()
+			if len(def.DefaultParams) != n || len(def.ParamNames) != n || len(def.ParamUnits) != n {
+				t.Errorf("%s needs %d parameters, got %d defaults, %d names and %d units",
+					def.Code, n, len(def.DefaultParams), len(def.ParamNames), len(def.ParamUnits))
+			}
+		})
+	}
+}
+
+func TestLibraryGet(t *testing.T) {
+	lib := circuits.Default()
+	def, ok := lib.Get("randles")
+	if !ok || def.Name != "Randles" {
+		t.Fatalf("Get(randles) = %v, %v, want Randles", def.Name, ok)
+	}
+	if _, ok := lib.Get("NoSuchCircuit"); ok {
+		t.Error("Get found an unknown circuit")
+	}
+	if params := lib.DefaultParams(def.Code); len(params) != len(def.DefaultParams) {
+		t.Errorf("DefaultParams(%s) = %v, want %v", def.Code, params, def.DefaultParams)
+	}
+}
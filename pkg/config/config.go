@@ -1,7 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net/url"
+	"os"
 	"strconv"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
 )
 
 // ArrayFlags replacement for removed goimp/cmd.ArrayFlags
@@ -27,7 +33,16 @@ type Config struct {
 	InitValues      ArrayFlags
 	CutLow          uint
 	CutHigh         uint
-	Unity           bool
+	FreqMin         float64 // fit only frequencies >= FreqMin, 0 for no limit
+	FreqMax         float64 // fit only frequencies <= FreqMax, 0 for no limit
+	Robust          bool    // reject outliers and refit
+	RobustK         float64 // outlier threshold in robust standard deviations
+	RobustPasses    uint    // maximum number of outlier rejection refits
+	Weighting       string  // modulus, unity, proportional or sigma; empty selects sigma when uncertainties are supplied, else modulus
+	Space           string  // impedance or admittance, the representation the residuals are minimized in; empty is impedance
+	LogScale        bool    // optimize capacitances and Y0 parameters in log space
+	Regularization  float64 // Tikhonov penalty strength pulling parameters towards their init values
+	MaxCorrelation  float64 // parameter correlation reported as unidentifiable, goimpcore.DefaultMaxCorrelation when 0
 	SmartMode       string
 	OptimMethod     string
 	Benchmark       bool
@@ -35,14 +50,151 @@ type Config struct {
 	ImgOut          bool
 	ImgSave         bool
 	ImgPath         string
+	ImgFormat       string // nyquist or bode
 	ImgDPI          uint
 	ImgSize         uint
-	Concurrency     bool
+	Concurrency     bool   // run Nelder-Mead as a parallel multi-start and evaluate large spectra concurrently
+	Starts          uint   // number of multi-start starting points
+	SobolTable      string // Joe-Kuo direction numbers file extending the Sobol dimensions of the multi-start
 	Threads         uint
 	Jobs            uint
 	Quiet           bool
 	HTTPServer      bool
+	Port            int    // HTTP server port, 0 for a random free one
+	ProfilingPort   int    // pprof server port
+	WebhookURL      string // endpoint receiving the fit results
+	Workers         int    // worker pool size, Threads when 0
 	EnableProfiling bool
+	ProfileMainPort bool          // serve pprof on the main HTTP server instead of a separate port
+	OTELEndpoint    string        // OTLP/HTTP trace collector, tracing is off when empty
+	ShutdownTimeout time.Duration // how long a shutdown waits for queued fits, 0 for the default
+	CORSOrigins     []string      // origins allowed to call the API, any when empty
+	SyncTimeout     time.Duration // bound of synchronous fits, 0 for the handler default
+	LiveMaxFits     int           // fits one /ws connection runs at once, 0 for the handler default
+	JobTimeout      time.Duration // bound of one fit in the worker pool, 0 for the pool default
+	ResultTTL       time.Duration // how long results stay retrievable over HTTP, 0 for the default
+	ResultMax       int           // maximum number of stored results, 0 for the default
+	QueueFile       string        // bbolt file persisting accepted jobs across restarts, in memory only when empty
+	DriftThreshold  float64       // parameter drift over a batch warned about as not steady, 0 for the default
+	TimingFile      string        // CSV the timing of every batch is appended to, none when empty
+	RateLimit       float64       // requests per second accepted from one client IP, 0 for no limit
+	RateBurst       int           // requests one client IP may send at once
+	Dedup           bool          // share the fit of identical concurrent EIS requests
+	Formalism       string        // Output representation: z (impedance), y (admittance), m (electric modulus)
+	C0              float64       // Geometric capacitance in Farads, required for the m formalism
+	Criterion       string        // Selection criterion when comparing fits: chisq, aic or bic
+	MaxIterations   int           // solver restarts per fit, the processor default when 0
+	Sensitivity     bool          // differentiate the chi-square with respect to each parameter after the fit
+
+	WebhookAttempts    int           // delivery attempts of a webhook, 0 for the default
+	WebhookBackoff     time.Duration // wait before the first webhook retry, 0 for the default
+	WebhookConcurrency int           // webhooks sent at once, 0 for the default
+	DeadLetterFile     string        // file receiving the undelivered webhooks, none when empty
+	WebhookSecret      string        // shared secret signing the webhooks, unsigned when empty
+	WebhookGzip        bool          // gzip the webhook bodies of 1 KiB and more
+
+	Metrics        bool    // serve Prometheus metrics on /metrics
+	ReadyWatermark float64 // fraction of the jobs queue above which /ready fails, 0 for the default
+	LogFormat      string  // request log format: text or json
+
+	MaxBodyBytes int64 // request body size limit, 0 for the default
+	MaxPoints    int   // frequencies accepted per spectrum, 0 for the default
+	MaxSpectra   int   // spectra accepted per batch, 0 for the default
+}
+
+// OptimMethods lists the accepted OptimMethod values, aliases included
+var OptimMethods = []string{
+	"nelder-mead", "parallel", "multi-start", "levenberg-marquardt", "lm",
+	"gradient-descent", "gd", "lbfgs", "newton", "hybrid", "nm+lm", "all",
+}
+
+// DefaultPort is the port the HTTP server listens on unless configured
+const DefaultPort = 8080
+
+// ValidatePort checks an HTTP server port, unprivileged ports are accepted
+// and 0 binds to a random free port
+func ValidatePort(port int) error {
+	if port != 0 && (port < 1024 || port > 65535) {
+		return fmt.Errorf("invalid port %d, expected 1024-65535 or 0 for a random free port", port)
+	}
+	return nil
+}
+
+// DefaultProfilingPort is the port of the separate pprof server
+const DefaultProfilingPort = 6060
+
+// DefaultWebhookURL is the endpoint receiving the fit results unless configured
+const DefaultWebhookURL = "http://webplot:3001/webhook"
+
+// Environment variables overriding the default port and webhook URL. Flags
+// take precedence over them: flag > environment > default.
+const (
+	EnvPort          = "GOIMP_PORT"
+	EnvWebhookURL    = "GOIMP_WEBHOOK_URL"
+	EnvWebhookSecret = "GOIMP_WEBHOOK_SECRET"
+)
+
+// PortFromEnv returns the port set by GOIMP_PORT, def when it is unset
+func PortFromEnv(def int) (int, error) {
+	value := os.Getenv(EnvPort)
+	if value == "" {
+		return def, nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", EnvPort, value, err)
+	}
+	if err := ValidatePort(port); err != nil {
+		return 0, fmt.Errorf("%s: %v", EnvPort, err)
+	}
+	return port, nil
+}
+
+// WebhookURLFromEnv returns the webhook URL set by GOIMP_WEBHOOK_URL, def when it
+// is unset
+func WebhookURLFromEnv(def string) string {
+	if value := os.Getenv(EnvWebhookURL); value != "" {
+		return value
+	}
+	return def
+}
+
+// ApplyEnv overrides the port, webhook URL and webhook secret of c with the
+// environment. Call it before registering the flags so that they keep
+// precedence.
+func (c *Config) ApplyEnv() error {
+	port, err := PortFromEnv(c.Port)
+	if err != nil {
+		return err
+	}
+	c.Port = port
+	c.WebhookURL = WebhookURLFromEnv(c.WebhookURL)
+	if secret := os.Getenv(EnvWebhookSecret); secret != "" {
+		c.WebhookSecret = secret
+	}
+	return nil
+}
+
+// ValidateWebhookURL checks that a webhook URL is an absolute http or https URL
+func ValidateWebhookURL(webhookURL string) error {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %v", webhookURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q, expected an http or https URL", webhookURL)
+	}
+	return nil
+}
+
+// ValidOptimMethod reports whether name is one of OptimMethods
+func ValidOptimMethod(name string) bool {
+	for _, m := range OptimMethods {
+		if m == name {
+			return true
+		}
+	}
+	return false
 }
 
 // ServerConfig holds server-specific configuration
@@ -53,19 +205,130 @@ type ServerConfig struct {
 	EnableMetrics   bool
 	EnableProfiling bool
 	ProfilingPort   string
+	// EnableProfilingOnMainPort mounts the pprof endpoints under /debug/pprof/
+	// of the main server, the separate profiling port is then not opened
+	EnableProfilingOnMainPort bool
+	// OTELEndpoint is the OTLP/HTTP collector receiving traces, e.g.
+	// http://jaeger:4318. Tracing is a no-op when empty.
+	OTELEndpoint string
+	// ShutdownTimeout bounds how long Shutdown waits for queued fits, the
+	// worker pool default when 0
+	ShutdownTimeout time.Duration
+	// JobTimeout bounds each fit run by the worker pool, the worker pool
+	// default when 0. Requests may set their own with timeout_seconds.
+	JobTimeout time.Duration
+	// CORSAllowedOrigins lists the origins allowed to call the API, "*" for
+	// any, which is also the default when empty. CORSAllowedMethods default to
+	// GET, POST, DELETE and OPTIONS, CORSAllowHeaders to Content-Type and
+	// Idempotency-Key.
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowHeaders   []string
+	// ResultTTL is how long completed results stay retrievable over HTTP and
+	// ResultMaxEntries how many are kept, least recently used evicted first.
+	// The store defaults apply when 0.
+	ResultTTL        time.Duration
+	ResultMaxEntries int
+	// QueueFile is the bbolt file keeping the accepted asynchronous jobs and
+	// their undelivered webhooks, a restarted server resumes them. Jobs are
+	// kept in memory only when empty.
+	QueueFile string
+	// MaxRequestBodyBytes caps request bodies, larger ones get 413.
+	// MaxBatchSpectra and MaxFrequencyPoints cap the decoded requests. The
+	// Default limits apply when 0.
+	MaxRequestBodyBytes int64
+	MaxBatchSpectra     int
+	MaxFrequencyPoints  int
+	// RateLimit is the sustained number of requests per second accepted from
+	// one client IP and RateBurst how many it may send at once, rate limiting
+	// is off when RateLimit is 0. RateBurst defaults to 1.
+	RateLimit float64
+	RateBurst int
+	// WebhookMaxAttempts and WebhookInitialBackoff control the retries of
+	// webhooks failing with a network error or a 5xx response, at most
+	// WebhookConcurrency of them are sent at once. Webhooks still failing are
+	// listed under /webhooks/failed and appended to WebhookDeadLetterFile when
+	// set. The webhook client and worker pool defaults apply when 0.
+	WebhookMaxAttempts    int
+	WebhookInitialBackoff time.Duration
+	WebhookConcurrency    int
+	WebhookDeadLetterFile string
+	// WebhookSecret, when set, signs every webhook with an HMAC-SHA256 of
+	// its body, see webhook.VerifySignature
+	WebhookSecret string
+	// WebhookCompression sends large webhooks gzip compressed, see
+	// webhook.WithCompression
+	WebhookCompression bool
+	// EnableDedup answers identical EIS requests arriving within a second of
+	// each other with the fit of the first one
+	EnableDedup bool
+	// ReadyQueueWatermark is the fraction of the jobs queue capacity above
+	// which /ready reports 503, health.DefaultQueueWatermark when 0
+	ReadyQueueWatermark float64
+	// LogFormat is the format of the request log lines, LogFormatText or
+	// LogFormatJSON. QuietRequests logs only the requests that failed.
+	LogFormat     string
+	QuietRequests bool
 }
 
+// Formats of the request log of the ServerConfig
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Default request limits of the ServerConfig
+const (
+	DefaultMaxRequestBodyBytes = 10 << 20
+	DefaultMaxBatchSpectra     = 1000
+	DefaultMaxFrequencyPoints  = 10000
+)
+
+// RequestLimits returns the body, batch and spectrum size limits of c with
+// the defaults filled in
+func (c *ServerConfig) RequestLimits() (maxBodyBytes int64, maxBatchSpectra, maxFrequencyPoints int) {
+	maxBodyBytes, maxBatchSpectra, maxFrequencyPoints = c.MaxRequestBodyBytes, c.MaxBatchSpectra, c.MaxFrequencyPoints
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxRequestBodyBytes
+	}
+	if maxBatchSpectra <= 0 {
+		maxBatchSpectra = DefaultMaxBatchSpectra
+	}
+	if maxFrequencyPoints <= 0 {
+		maxFrequencyPoints = DefaultMaxFrequencyPoints
+	}
+	return maxBodyBytes, maxBatchSpectra, maxFrequencyPoints
+}
+
+// DefaultTimingFile is the CSV the batch timings are appended to unless
+// configured
+const DefaultTimingFile = "concurrent_timing_results.csv"
+
 // DefaultConfig returns a configuration with sensible defaults
 func DefaultConfig() *Config {
 	return &Config{
-		Code:        "R(QR)",
-		Threads:     5,
-		OptimMethod: "nelder-mead",
-		SmartMode:   "eis",
-		ImgDPI:      300,
-		ImgSize:     800,
-		Quiet:       false,
-		HTTPServer:  true,
+		Code:           "R(QR)",
+		Threads:        5,
+		OptimMethod:    "nelder-mead",
+		SmartMode:      "eis",
+		ImgFormat:      "nyquist",
+		RobustK:        goimpcore.DefaultRobustK,
+		RobustPasses:   goimpcore.DefaultRobustPasses,
+		Starts:         goimpcore.DefaultStarts,
+		MaxCorrelation: goimpcore.DefaultMaxCorrelation,
+		ImgDPI:         300,
+		ImgSize:        800,
+		Quiet:          false,
+		HTTPServer:     true,
+		Port:           DefaultPort,
+		ProfilingPort:  DefaultProfilingPort,
+		WebhookURL:     DefaultWebhookURL,
+		Formalism:      "z",
+		Criterion:      "chisq",
+		LogFormat:      LogFormatText,
+		RateBurst:      10,
+		Metrics:        true,
+		TimingFile:     DefaultTimingFile,
 	}
 }
 
@@ -74,9 +337,13 @@ func DefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
 		Port:            "8080",
 		WorkerCount:     5,
-		WebhookURL:      "http://webplot:3001/webhook",
+		WebhookURL:      DefaultWebhookURL,
 		EnableMetrics:   true,
 		EnableProfiling: false,
 		ProfilingPort:   "6060",
+
+		MaxRequestBodyBytes: DefaultMaxRequestBodyBytes,
+		MaxBatchSpectra:     DefaultMaxBatchSpectra,
+		MaxFrequencyPoints:  DefaultMaxFrequencyPoints,
 	}
 }
@@ -0,0 +1,46 @@
+package config
+
+import "testing"
+
+func TestValidatePort(t *testing.T) {
+	tests := []struct {
+		port int
+		ok   bool
+	}{
+		{0, true},
+		{1024, true},
+		{8080, true},
+		{65535, true},
+		{80, false},
+		{1023, false},
+		{65536, false},
+		{-1, false},
+	}
+	for _, tt := range tests {
+		if err := ValidatePort(tt.port); (err == nil) != tt.ok {
+			t.Errorf("ValidatePort(%d) = %v, want ok %v", tt.port, err, tt.ok)
+		}
+	}
+}
+
+// GOIMP_PORT overrides the default port and is validated like the flag
+func TestPortFromEnv(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int
+		ok   bool
+	}{
+		{"", DefaultPort, true},
+		{"0", 0, true},
+		{"9090", 9090, true},
+		{"80", 0, false},
+		{"http", 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv(EnvPort, tt.env)
+		port, err := PortFromEnv(DefaultPort)
+		if (err == nil) != tt.ok || port != tt.want {
+			t.Errorf("%s=%q: %d, %v, want %d and ok %v", EnvPort, tt.env, port, err, tt.want, tt.ok)
+		}
+	}
+}
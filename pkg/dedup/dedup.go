@@ -0,0 +1,123 @@
+package dedup
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is how long after a request started an identical one joins it
+const DefaultWindow = time.Second
+
+// Deduplicator shares the result of a request with the identical requests
+// arriving while it is in flight. Requests are identified by the Key of their
+// spectrum and fit settings, one arriving within the window of an in-flight
+// request with the same key joins it instead of starting its own fit.
+type Deduplicator struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	inflight map[uint64]*Call
+}
+
+// Call is an in-flight request, shared by the requests that joined it
+type Call struct {
+	ID string // request ID of the first request, reported to the joined ones
+
+	key     uint64
+	started time.Time
+	done    chan struct{}
+	result  interface{}
+}
+
+// New creates a Deduplicator joining requests started within window of each
+// other, DefaultWindow when window is 0
+func New(window time.Duration) *Deduplicator {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Deduplicator{
+		window:   window,
+		inflight: make(map[uint64]*Call),
+	}
+}
+
+// Key hashes a spectrum and the settings of its fit with FNV-64a. sigmas may
+// be nil, settings must describe everything else changing the result.
+func Key(freqs []float64, points, sigmas [][2]float64, settings string) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(v float64) {
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		h.Write(buf[:])
+	}
+	// Lengths separate the sections, so shifting values between them changes the key
+	write(float64(len(freqs)))
+	for _, f := range freqs {
+		write(f)
+	}
+	write(float64(len(points)))
+	for _, p := range points {
+		write(p[0])
+		write(p[1])
+	}
+	write(float64(len(sigmas)))
+	for _, s := range sigmas {
+		write(s[0])
+		write(s[1])
+	}
+	h.Write([]byte(settings))
+	return h.Sum64()
+}
+
+// Join returns the in-flight call of key when it started within the window,
+// otherwise it registers a new call with the request ID id and reports first.
+// The first request must end its call with Done.
+func (d *Deduplicator) Join(key uint64, id string) (call *Call, first bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if call, ok := d.inflight[key]; ok && now.Sub(call.started) < d.window {
+		return call, false
+	}
+	call = &Call{
+		ID:      id,
+		key:     key,
+		started: now,
+		done:    make(chan struct{}),
+	}
+	d.inflight[key] = call
+	return call, true
+}
+
+// Done publishes the result of call to the requests waiting on it. A nil
+// Deduplicator or call is ignored, so callers need not check whether
+// deduplication is on.
+func (d *Deduplicator) Done(call *Call, result interface{}) {
+	if d == nil || call == nil {
+		return
+	}
+	d.mu.Lock()
+	if d.inflight[call.key] == call {
+		delete(d.inflight, call.key)
+	}
+	d.mu.Unlock()
+
+	call.result = result
+	close(call.done)
+}
+
+// Wait returns the result of call once the first request is done, or the
+// error of ctx when it ends first
+func (c *Call) Wait(ctx context.Context) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,108 @@
+package formalism
+
+import (
+	"fmt"
+	"math"
+)
+
+// Supported data representations
+const (
+	Impedance  = "z" // Z* = Z' + jZ''
+	Admittance = "y" // Y* = 1/Z*
+	Modulus    = "m" // M* = jωC0Z*
+)
+
+// Valid reports whether f is a supported formalism
+func Valid(f string) bool {
+	switch f {
+	case Impedance, Admittance, Modulus:
+		return true
+	}
+	return false
+}
+
+// ToAdmittance converts impedance data Z* into admittance Y* = 1/Z*
+func ToAdmittance(impData [][2]float64) [][2]float64 {
+	return reciprocal(impData)
+}
+
+// ToImpedance converts admittance data Y* back into impedance Z* = 1/Y*
+func ToImpedance(admData [][2]float64) [][2]float64 {
+	return reciprocal(admData)
+}
+
+// ToElectricModulus converts impedance data Z* into electric modulus M* = jωC0Z*.
+// C0 is the geometric (empty cell) capacitance in Farads.
+func ToElectricModulus(impData [][2]float64, freqs []float64, C0 float64) [][2]float64 {
+	if len(impData) != len(freqs) {
+		panic("formalism: slice length mismatch")
+	}
+	res := make([][2]float64, len(impData))
+	for i, z := range impData {
+		wc := 2 * math.Pi * freqs[i] * C0
+		// jωC0(Z' + jZ'') = -ωC0Z'' + jωC0Z'
+		res[i] = [2]float64{-wc * z[1], wc * z[0]}
+	}
+	return res
+}
+
+// FromElectricModulus converts electric modulus data M* back into impedance Z* = M*/(jωC0)
+func FromElectricModulus(modData [][2]float64, freqs []float64, C0 float64) [][2]float64 {
+	if len(modData) != len(freqs) {
+		panic("formalism: slice length mismatch")
+	}
+	res := make([][2]float64, len(modData))
+	for i, m := range modData {
+		wc := 2 * math.Pi * freqs[i] * C0
+		// (M' + jM'')/(jωC0) = M''/ωC0 - jM'/ωC0
+		res[i] = [2]float64{m[1] / wc, -m[0] / wc}
+	}
+	return res
+}
+
+// Convert transforms impedance data into the requested formalism
+func Convert(f string, impData [][2]float64, freqs []float64, C0 float64) ([][2]float64, error) {
+	switch f {
+	case Impedance, "":
+		return impData, nil
+	case Admittance:
+		return ToAdmittance(impData), nil
+	case Modulus:
+		if C0 <= 0 {
+			return nil, fmt.Errorf("electric modulus requires a positive C0, got %v", C0)
+		}
+		return ToElectricModulus(impData, freqs, C0), nil
+	}
+	return nil, fmt.Errorf("unknown formalism %q", f)
+}
+
+// ConvertParts is Convert for data held as separate real and imaginary slices
+func ConvertParts(f string, realPart, imagPart, freqs []float64, C0 float64) ([]float64, []float64, error) {
+	if len(realPart) != len(imagPart) {
+		return nil, nil, fmt.Errorf("real and imaginary data length mismatch: %d vs %d", len(realPart), len(imagPart))
+	}
+	data := make([][2]float64, len(realPart))
+	for i := range realPart {
+		data[i] = [2]float64{realPart[i], imagPart[i]}
+	}
+	converted, err := Convert(f, data, freqs, C0)
+	if err != nil {
+		return nil, nil, err
+	}
+	outReal := make([]float64, len(converted))
+	outImag := make([]float64, len(converted))
+	for i, v := range converted {
+		outReal[i] = v[0]
+		outImag[i] = v[1]
+	}
+	return outReal, outImag, nil
+}
+
+func reciprocal(data [][2]float64) [][2]float64 {
+	res := make([][2]float64, len(data))
+	for i, v := range data {
+		c := 1 / complex(v[0], v[1])
+		res[i] = [2]float64{real(c), imag(c)}
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package formalism
+
+import (
+	"math"
+	"testing"
+)
+
+// testFreqs are 1 mHz to 1 MHz, 5 points per decade
+func testFreqs() []float64 {
+	var freqs []float64
+	for e := -3.0; e <= 6; e += 0.2 {
+		freqs = append(freqs, math.Pow(10, e))
+	}
+	return freqs
+}
+
+// parallelRC is the impedance of a resistance r in parallel with a capacitance c
+func parallelRC(freqs []float64, r, c float64) [][2]float64 {
+	data := make([][2]float64, len(freqs))
+	for i, f := range freqs {
+		z := complex(r, 0) / complex(1, 2*math.Pi*f*r*c)
+		data[i] = [2]float64{real(z), imag(z)}
+	}
+	return data
+}
+
+// closeTo reports whether a and b agree within the relative tolerance tol
+func closeTo(a, b [2]float64, tol float64) bool {
+	scale := math.Max(math.Hypot(a[0], a[1]), math.Hypot(b[0], b[1]))
+	return math.Hypot(a[0]-b[0], a[1]-b[1]) <= tol*scale
+}
+
+func TestRoundTrip(t *testing.T) {
+	freqs := testFreqs()
+	impData := parallelRC(freqs, 100, 1e-6)
+	admData := ToAdmittance(impData)
+	const c0 = 1e-12
+
+	tests := []struct {
+		name string
+		want [][2]float64
+		got  [][2]float64
+	}{
+		{"admittance", admData, ToAdmittance(ToImpedance(admData))},
+		{"impedance through admittance", impData, ToImpedance(ToAdmittance(impData))},
+		{"impedance through modulus", impData, FromElectricModulus(ToElectricModulus(impData, freqs, c0), freqs, c0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.want {
+				if !closeTo(tt.got[i], tt.want[i], 1e-12) {
+					t.Fatalf("point %d: %v, want %v", i, tt.got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestElectricModulus(t *testing.T) {
+	freqs := testFreqs()
+	const r, c, c0 = 100.0, 1e-6, 1e-9
+
+	// A pure R gives M' = 0 and M'' = ωC0R
+	resistor := make([][2]float64, len(freqs))
+	for i := range resistor {
+		resistor[i] = [2]float64{r, 0}
+	}
+	for i, m := range ToElectricModulus(resistor, freqs, c0) {
+		if want := 2 * math.Pi * freqs[i] * c0 * r; m[0] != 0 || math.Abs(m[1]/want-1) > 1e-12 {
+			t.Fatalf("M* of R at %v Hz = %v, want [0 %v]", freqs[i], m, want)
+		}
+	}
+
+	// R with its capacitance C in parallel draws a semicircle of radius
+	// C0/(2C) centred on (C0/(2C), 0) in the M'' vs M' plane
+	radius := c0 / (2 * c)
+	for i, m := range ToElectricModulus(parallelRC(freqs, r, c), freqs, c0) {
+		if d := math.Hypot(m[0]-radius, m[1]); math.Abs(d/radius-1) > 1e-9 {
+			t.Fatalf("M* at %v Hz = %v is %v from the centre, want %v", freqs[i], m, d, radius)
+		}
+		if m[1] < 0 {
+			t.Fatalf("M* at %v Hz = %v below the real axis", freqs[i], m)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	freqs := testFreqs()
+	impData := parallelRC(freqs, 100, 1e-6)
+	tests := []struct {
+		formalism string
+		c0        float64
+		wantErr   bool
+	}{
+		{Impedance, 0, false},
+		{"", 0, false},
+		{Admittance, 0, false},
+		{Modulus, 1e-12, false},
+		{Modulus, 0, true},
+		{"x", 0, true},
+	}
+	for _, tt := range tests {
+		_, err := Convert(tt.formalism, impData, freqs, tt.c0)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Convert(%q, C0=%v) error %v, want error %v", tt.formalism, tt.c0, err, tt.wantErr)
+		}
+	}
+}
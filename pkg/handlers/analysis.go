@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/analysis"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// AnalysisHandler serves quick analyses of measured data that need no
+// circuit, POST /analysis/semicircle fits a circle to one arc of a Nyquist
+// plot given as an array of models.SemicirclePoint. POST /analysis/arrhenius
+// and /analysis/vtf fit the temperature dependence of a parameter of the fits
+// of a models.ArrheniusRequest.
+type AnalysisHandler struct {
+	limits Limits
+}
+
+// NewAnalysisHandler creates a new analysis handler
+func NewAnalysisHandler(limits Limits) *AnalysisHandler {
+	return &AnalysisHandler{
+		limits: limits,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *AnalysisHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var serve func(w http.ResponseWriter, r *http.Request)
+	switch strings.TrimRight(r.URL.Path, "/") {
+	case "/analysis/semicircle":
+		serve = h.serveSemicircle
+	case "/analysis/arrhenius":
+		serve = func(w http.ResponseWriter, r *http.Request) { h.serveTemperature(w, r, false) }
+	case "/analysis/vtf":
+		serve = func(w http.ResponseWriter, r *http.Request) { h.serveTemperature(w, r, true) }
+	default:
+		h.writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	serve(w, r)
+}
+
+// serveSemicircle fits a circle to the posted points
+func (h *AnalysisHandler) serveSemicircle(w http.ResponseWriter, r *http.Request) {
+	var points []models.SemicirclePoint
+	if err := json.NewDecoder(r.Body).Decode(&points); err != nil {
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	if err := h.limits.checkPoints(len(points)); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(points) < 3 {
+		h.writeError(w, fmt.Sprintf("a circle needs at least 3 points, got %d", len(points)), http.StatusUnprocessableEntity)
+		return
+	}
+
+	zr := make([]float64, len(points))
+	zi := make([]float64, len(points))
+	freqs := make([]float64, len(points))
+	withFreqs := true
+	for i, p := range points {
+		if math.IsNaN(p.ZR) || math.IsInf(p.ZR, 0) || math.IsNaN(p.ZI) || math.IsInf(p.ZI, 0) {
+			h.writeError(w, fmt.Sprintf("point %d is not finite", i), http.StatusUnprocessableEntity)
+			return
+		}
+		zr[i], zi[i], freqs[i] = p.ZR, p.ZI, p.Frequency
+		if p.Frequency <= 0 {
+			withFreqs = false
+		}
+	}
+
+	var res analysis.SemicircleResult
+	if withFreqs {
+		res = analysis.FitSemicircleWithFrequencies(freqs, zr, zi)
+	} else {
+		res = analysis.FitSemicircle(zr, zi)
+	}
+	if math.IsNaN(res.Radius) {
+		h.writeError(w, "no circle fits the points, they are collinear", http.StatusUnprocessableEntity)
+		return
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveTemperature fits the Arrhenius equation, or the VTF equation when vtf
+// is set, to the posted fits
+func (h *AnalysisHandler) serveTemperature(w http.ResponseWriter, r *http.Request, vtf bool) {
+	var req models.ArrheniusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	if err := h.limits.checkPoints(len(req.Temperatures)); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	minPoints := 2
+	if vtf {
+		minPoints = 4 // three parameters and a residual
+	}
+	if len(req.Temperatures) < minPoints {
+		h.writeError(w, fmt.Sprintf("at least %d temperatures are required, got %d", minPoints, len(req.Temperatures)), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(req.Results) != len(req.Temperatures) {
+		h.writeError(w, fmt.Sprintf("%d results for %d temperatures", len(req.Results), len(req.Temperatures)), http.StatusUnprocessableEntity)
+		return
+	}
+
+	varies := false
+	for _, t := range req.Temperatures {
+		varies = varies || t != req.Temperatures[0]
+	}
+	if !varies {
+		h.writeError(w, "temperatures must not all be equal", http.StatusUnprocessableEntity)
+		return
+	}
+
+	params := make([][]float64, len(req.Results))
+	for i, res := range req.Results {
+		t := req.Temperatures[i]
+		if !(t > 0) || math.IsInf(t, 0) {
+			h.writeError(w, fmt.Sprintf("temperature %d must be positive kelvin, got %v", i, t), http.StatusUnprocessableEntity)
+			return
+		}
+		if req.ParamIndex < 0 || req.ParamIndex >= len(res.Params) {
+			h.writeError(w, fmt.Sprintf("result %d has no parameter %d", i, req.ParamIndex), http.StatusUnprocessableEntity)
+			return
+		}
+		params[i] = append([]float64(nil), res.Params...)
+		if req.Inverse {
+			params[i][req.ParamIndex] = 1 / params[i][req.ParamIndex]
+		}
+		if k := params[i][req.ParamIndex]; !(k > 0) || math.IsInf(k, 0) {
+			h.writeError(w, fmt.Sprintf("parameter %d of result %d must be positive and finite, got %v", req.ParamIndex, i, k), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if !vtf {
+		json.NewEncoder(w).Encode(analysis.ArrheniusAnalysis(req.Temperatures, params, req.ParamIndex))
+		return
+	}
+	res := analysis.VTFAnalysis(req.Temperatures, params, req.ParamIndex)
+	if math.IsNaN(res.T0) {
+		h.writeError(w, "the lowest temperature must be above 1 K for a VTF fit", http.StatusUnprocessableEntity)
+		return
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// writeError writes an error response
+func (h *AnalysisHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/analysis"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// postJSON serves POST path with h and the JSON of v as body
+func postJSON(t *testing.T, h http.Handler, path string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+	return rec
+}
+
+// The points of an RC arc posted with their frequencies give back R and C
+func TestSemicircleEndpoint(t *testing.T) {
+	const r, c = 100, 1e-5
+	freqs, err := goimpcore.LogFrequencies(0.1, 1e5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points []models.SemicirclePoint
+	for i, z := range goimpcore.CircuitImpedance("r(rc)", freqs, []float64{10, r, c}) {
+		points = append(points, models.SemicirclePoint{ZR: z[0], ZI: z[1], Frequency: freqs[i]})
+	}
+	h := NewAnalysisHandler(Limits{})
+
+	rec := postJSON(t, h, "/analysis/semicircle", points)
+	var res analysis.SemicircleResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	if math.Abs(res.Rp-r) > 0.01*r || math.Abs(res.C-c) > 0.01*c {
+		t.Errorf("Rp %v, C %v, want %v and %v within 1%%", res.Rp, res.C, r, c)
+	}
+
+	if rec := postJSON(t, h, "/analysis/semicircle", points[:2]); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("two points: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/analysis/semicircle", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// Fitted resistances of a known Ea = 0.5 eV, posted with inverse, give it
+// back in eV and kJ/mol
+func TestArrheniusEndpoint(t *testing.T) {
+	const ea = 0.5
+	req := models.ArrheniusRequest{ParamIndex: 1, Inverse: true}
+	for temp := 300.0; temp <= 400; temp += 20 {
+		r := 1e-3 * math.Exp(ea/(analysis.BoltzmannEV*temp))
+		req.Temperatures = append(req.Temperatures, temp)
+		req.Results = append(req.Results, goimpcore.Result{Status: goimpcore.OK, Code: "R(RC)", Params: []float64{10, r, 1e-5}})
+	}
+	h := NewAnalysisHandler(Limits{})
+
+	rec := postJSON(t, h, "/analysis/arrhenius", req)
+	var res analysis.ArrheniusResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	if math.Abs(res.ActivationEnergy-ea) > 0.01*ea || math.Abs(res.ActivationEnergyKJMol-ea*analysis.KJPerMolEV) > 0.01*ea*analysis.KJPerMolEV {
+		t.Errorf("Ea %v eV, %v kJ/mol, want %v eV within 1%%", res.ActivationEnergy, res.ActivationEnergyKJMol, ea)
+	}
+
+	req.ParamIndex = 3
+	if rec := postJSON(t, h, "/analysis/arrhenius", req); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("missing parameter: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
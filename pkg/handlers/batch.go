@@ -1,46 +1,79 @@
 package handlers
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/kacperjurak/goimpcore"
 	"github.com/kacperjurak/goimpcore/internal/utils"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
 	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/queue"
+	"github.com/kacperjurak/goimpcore/pkg/store"
 	"github.com/kacperjurak/goimpcore/pkg/worker"
 )
 
-// BatchHandler handles batch EIS data processing requests
+// BatchHandler handles batch EIS data processing requests, POST
+// /eis-data/batch, and their cancellation, DELETE /eis-data/batch/{batch_id}
 type BatchHandler struct {
 	config     *config.Config
 	workerPool *worker.Pool
 	processor  ProcessorFunc
+	complete   BatchCompleteFunc
+	results    store.Store
+	limits     Limits
+	jobs       *jobs.Registry
+	queue      *queue.Queue
 }
 
-// NewBatchHandler creates a new batch handler
-func NewBatchHandler(cfg *config.Config, pool *worker.Pool, processor ProcessorFunc) *BatchHandler {
+// NewBatchHandler creates a new batch handler. complete may be nil when the
+// drift report of completed batches is only saved to a file, results when
+// they are not kept for retrieval, registry when batches cannot be cancelled
+// and q when they are not persisted.
+func NewBatchHandler(cfg *config.Config, pool *worker.Pool, processor ProcessorFunc, complete BatchCompleteFunc, results store.Store, limits Limits, registry *jobs.Registry, q *queue.Queue) *BatchHandler {
 	return &BatchHandler{
 		config:     cfg,
 		workerPool: pool,
 		processor:  processor,
+		complete:   complete,
+		results:    results,
+		limits:     limits,
+		jobs:       registry,
+		queue:      q,
 	}
 }
 
 // ServeHTTP implements the http.Handler interface
 func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.setupCORS(w)
+	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
+	if r.Method == "DELETE" {
+		h.cancel(w, r)
+		return
+	}
+
+	if r.URL.Path != "/eis-data/batch" {
+		h.writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
 	if r.Method != "POST" {
 		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -48,19 +81,75 @@ func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	var batch models.ImpedanceBatch
 	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
-		h.writeError(w, "Invalid JSON format", http.StatusBadRequest)
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	if err := h.limits.checkBatch(batch); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	if len(batch.Spectra) == 0 {
-		h.writeError(w, "No spectra provided in batch", http.StatusBadRequest)
+	if errs := models.ValidateImpedanceBatch(batch); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	for _, item := range batch.Spectra {
+		cfg := requestConfig(h.config, item.ImpedanceData)
+		if err := validateFit(item.ImpedanceData.Frequencies, cfg); err != nil {
+			h.writeError(w, fmt.Sprintf("Spectrum %d: %v", item.Iteration, err), http.StatusBadRequest)
+			return
+		}
+		if _, err := item.ImpedanceData.Points(); err != nil {
+			h.writeError(w, fmt.Sprintf("Spectrum %d: %v", item.Iteration, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A retry of an accepted batch, same ID and spectra, gets the first one
+	var retryKey string
+	if h.results != nil && requestKey(r, batch.RequestKey) != "" {
+		retryKey = batchKey(batch)
+		if owner, claimed := h.results.ClaimKey(retryKey, batch.BatchID); !claimed {
+			h.writeRetriedBatch(w, owner)
+			return
+		}
+	}
+
+	// The batch outlives the request, DELETE /jobs/{batch_id} or
+	// /eis-data/batch/{batch_id} cancels it
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	if h.jobs != nil {
+		if _, err := h.jobs.Add(batch.BatchID, jobs.KindBatch, len(batch.Spectra), cancel); err != nil {
+			cancel()
+			releaseKey(h.results, retryKey)
+			h.writeError(w, fmt.Sprintf("Batch %s: %v", batch.BatchID, err), http.StatusConflict)
+			return
+		}
+	}
+	if err := acceptJob(h.queue, batch.BatchID, jobs.KindBatch, utils.RequestIDOrNew(ctx), batch); err != nil {
+		cancel()
+		h.jobs.Finish(batch.BatchID, jobs.StateFailed)
+		releaseKey(h.results, retryKey)
+		h.writeError(w, fmt.Sprintf("Batch %s not queued: %v", batch.BatchID, err), http.StatusInternalServerError)
 		return
 	}
 
 	log.Printf("🔄 Batch processing started - ID: %s, Spectra: %d", batch.BatchID, len(batch.Spectra))
 
+	pending := models.BatchResult{
+		BatchID:     batch.BatchID,
+		Status:      models.StatusPending,
+		Spectra:     len(batch.Spectra),
+		SubmittedAt: time.Now(),
+	}
+	if h.results != nil {
+		h.results.PutBatch(pending)
+	}
+
 	// Process batch asynchronously
-	go h.processBatchAsync(batch)
+	go h.processBatchAsync(ctx, cancel, batch, pending)
 
 	// Return immediate response
 	response := map[string]interface{}{
@@ -69,104 +158,391 @@ func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"spectra":  len(batch.Spectra),
 		"message":  "Batch processing started with worker pool",
 	}
+	if h.results != nil {
+		response["result_url"] = "/batches/" + batch.BatchID
+	}
+	if h.jobs != nil {
+		response["job_url"] = "/jobs/" + batch.BatchID
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(response)
 }
 
-// processBatchAsync handles asynchronous batch processing
-func (h *BatchHandler) processBatchAsync(batch models.ImpedanceBatch) {
-	batchStartTime := time.Now()
-	spectrumTimings := make([]models.SpectrumTiming, len(batch.Spectra))
-	resultsReceived := 0
+// cancel serves DELETE /eis-data/batch/{batch_id}, the DELETE /jobs/{id} of
+// a batch answered with the number of its spectra completed, kept in its
+// results, and cancelled, queued or running when the batch was cancelled
+func (h *BatchHandler) cancel(w http.ResponseWriter, r *http.Request) {
+	batchID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/eis-data/batch/"), "/")
+	if batchID == "" || strings.Contains(batchID, "/") || h.jobs == nil {
+		h.writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	job, ok := h.jobs.Get(batchID)
+	if !ok || job.Kind != jobs.KindBatch {
+		h.writeError(w, "Unknown or expired batch ID", http.StatusNotFound)
+		return
+	}
 
-	// Submit all jobs to worker pool
-	for _, item := range batch.Spectra {
-		job := h.createWorkItem(item, batch.BatchID)
-		h.workerPool.SubmitJob(job)
+	job, err := h.jobs.Cancel(batchID)
+	completed := job.Succeeded + job.Failed
+	response := map[string]interface{}{
+		"batch_id":  batchID,
+		"cancelled": job.Spectra - completed,
+		"completed": completed,
+	}
+	if h.results != nil {
+		response["result_url"] = "/batches/" + batchID
+	}
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		h.writeError(w, "Unknown or expired batch ID", http.StatusNotFound)
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		response["error"] = err.Error()
+		response["cancelled"] = job.Cancelled
+		w.WriteHeader(http.StatusConflict)
+	default:
+		log.Printf("🛑 Batch cancelled - ID: %s, Completed: %d, Cancelled: %d", batchID, completed, job.Spectra-completed)
 	}
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Collect results from worker pool
-	for resultsReceived < len(batch.Spectra) {
-		if result, ok := h.workerPool.GetResult(); ok {
-			h.processResult(result, spectrumTimings)
-			resultsReceived++
-		} else {
-			// No results available yet, small delay to prevent busy waiting
-			time.Sleep(1 * time.Millisecond)
+// Resume restarts the batch job, accepted before a restart. Its spectra are
+// all fitted again, the webhooks of the ones sent before carry the same
+// idempotency key.
+func (h *BatchHandler) Resume(job queue.Job) error {
+	var batch models.ImpedanceBatch
+	if err := json.Unmarshal(job.Payload, &batch); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(utils.WithRequestID(context.Background(), job.RequestID))
+	if h.jobs != nil {
+		if _, err := h.jobs.Add(batch.BatchID, jobs.KindBatch, len(batch.Spectra), cancel); err != nil {
+			cancel()
+			return err
 		}
 	}
+	pending := models.BatchResult{
+		BatchID:     batch.BatchID,
+		Status:      models.StatusPending,
+		Spectra:     len(batch.Spectra),
+		SubmittedAt: job.AcceptedAt,
+	}
+	if h.results != nil {
+		h.results.PutBatch(pending)
+	}
+
+	go h.processBatchAsync(ctx, cancel, batch, pending)
+	return nil
+}
+
+// processBatchAsync handles asynchronous batch processing, pending is the
+// stored entry of the batch replaced once it completes. Cancelling ctx skips
+// the spectra not processed yet and stops the running ones. A persisted batch
+// cut short by the shutdown of the worker pool is left queued, unreported, to
+// run again after the restart.
+func (h *BatchHandler) processBatchAsync(ctx context.Context, cancel context.CancelFunc, batch models.ImpedanceBatch, pending models.BatchResult) {
+	defer cancel()
+	h.jobs.Start(batch.BatchID)
+	batchStartTime := time.Now()
+	spectrumTimings := newBatchTimings(batch)
+
+	var results []models.WorkResult
+	if batch.ChainInit {
+		results = h.processChained(ctx, batch, spectrumTimings)
+	} else {
+		results = h.processConcurrent(ctx, batch, spectrumTimings)
+	}
+
+	if h.queue != nil && len(results) < len(batch.Spectra) && ctx.Err() == nil && h.workerPool.Stats().Closed {
+		log.Printf("⏸️ Batch %s interrupted by shutdown with %d of %d results, resumed after the restart",
+			batch.BatchID, len(results), len(batch.Spectra))
+		return
+	}
+	defer finishQueued(h.queue, batch.BatchID)
+
+	h.finishBatch(ctx, batch, pending, results, spectrumTimings, batchStartTime)
+}
+
+// finishBatch reports the completed batch, started at batchStartTime, stores
+// it in place of pending and finishes its job
+func (h *BatchHandler) finishBatch(ctx context.Context, batch models.ImpedanceBatch, pending models.BatchResult, results []models.WorkResult, spectrumTimings *batchTimings, batchStartTime time.Time) {
+	markMissing(batch, results, spectrumTimings)
 
 	// All results collected
 	totalBatchTime := time.Since(batchStartTime)
 	concurrency := h.getConcurrency()
+	if batch.ChainInit {
+		concurrency = 1
+	}
 
 	// Save timing results to file
-	h.saveTimingResults(batch.BatchID, totalBatchTime, spectrumTimings, concurrency)
+	h.saveTimingResults(batch.BatchID, totalBatchTime, spectrumTimings.timings, concurrency)
+
+	event := h.reportBatchComplete(ctx, batch, results, totalBatchTime)
+	if h.results != nil {
+		completed := completedBatch(pending, results, event)
+		h.results.PutBatch(completed)
+
+		series := TimeSeriesFromBatch(completed.Results, nil)
+		series.BatchID = batch.BatchID
+		h.results.PutTimeSeries(series)
+		warnDrift(series, h.config.DriftThreshold)
+	}
+
+	state := jobs.StateDone
+	if event.Succeeded == 0 {
+		state = jobs.StateFailed
+	}
+	h.jobs.Finish(batch.BatchID, state)
 
 	log.Printf("🎉 Batch processing completed - ID: %s, Total time: %v", batch.BatchID, totalBatchTime)
 }
 
-// createWorkItem converts a batch item to a work item
-func (h *BatchHandler) createWorkItem(item models.BatchItem, batchID string) models.WorkItem {
-	// Convert to internal format with optimized data transformation
-	freqs := item.ImpedanceData.Frequencies
-	impData := make([][2]float64, len(item.ImpedanceData.Impedance))
+// reportBatchComplete saves the parameter drift report of a completed batch
+// and sends its aggregate statistics through the batch-complete webhook
+func (h *BatchHandler) reportBatchComplete(ctx context.Context, batch models.ImpedanceBatch, results []models.WorkResult, wallTime time.Duration) models.BatchCompleteEvent {
+	event := newBatchCompleteEvent(batch, results, wallTime)
+	event.Context = context.WithoutCancel(ctx) // a cancelled batch still reports its summary
 
-	log.Printf("DEBUG: Processing spectrum %d with %d frequencies and %d impedance points",
-		item.Iteration, len(freqs), len(item.ImpedanceData.Impedance))
+	if filename, err := saveBatchSummary(event.Drift); err != nil {
+		log.Printf("Error saving parameter drift of batch %s: %v", batch.BatchID, err)
+	} else {
+		log.Printf("📈 Parameter drift saved to %s", filename)
+	}
+
+	if h.complete != nil {
+		if err := h.complete(event); err != nil {
+			log.Printf("Batch complete webhook error for %s: %v", batch.BatchID, err)
+		}
+	}
+	return event
+}
 
-	// Optimized data conversion - single pass
-	for i, point := range item.ImpedanceData.Impedance {
-		realVal, realOk := point["real"]
-		imagVal, imagOk := point["imag"]
+// processConcurrent fits all spectra of batch at once on the worker pool and
+// returns their results in completion order
+func (h *BatchHandler) processConcurrent(ctx context.Context, batch models.ImpedanceBatch, spectrumTimings *batchTimings) []models.WorkResult {
+	results := make([]models.WorkResult, 0, len(batch.Spectra))
+	requestID := utils.RequestIDOrNew(ctx)
+
+	// Batch-scoped result channel, buffered for the whole batch so workers never
+	// block on it and results from other concurrent batches cannot arrive here
+	batchResults := make(chan models.WorkResult, len(batch.Spectra))
+
+	// Submit all jobs to worker pool, SubmitJob blocks while the queue is
+	// full so results are collected meanwhile
+	submitted := make(chan int, 1)
+	go func() {
+		n := 0
+		for _, item := range batch.Spectra {
+			job := h.createWorkItem(item, batch.BatchID, requestID)
+			job.Context = ctx
+			job.Results = batchResults
+			if ctx.Err() != nil {
+				// Cancelled, the rest of the batch is not submitted
+				batchResults <- worker.CancelledResult(job)
+				n++
+				continue
+			}
+			if err := h.workerPool.SubmitJob(job); err != nil {
+				log.Printf("⚠️ Spectrum %d of batch %s not processed: %v", item.Iteration, batch.BatchID, err)
+				continue
+			}
+			n++
+		}
+		submitted <- n
+	}()
+
+	deadline := time.NewTimer(batchTimeout(len(batch.Spectra)))
+	defer deadline.Stop()
+
+	// Collect results for this batch only
+	expected := -1
+	for expected < 0 || len(results) < expected {
+		select {
+		case result := <-batchResults:
+			h.processResult(result, spectrumTimings, batch.ElementImpedancesIncluded())
+			results = append(results, result)
+		case expected = <-submitted:
+		case <-deadline.C:
+			log.Printf("⚠️ Batch %s timed out after %v with %d of %d results",
+				batch.BatchID, batchTimeout(len(batch.Spectra)), len(results), len(batch.Spectra))
+			return results
+		}
+	}
+	return results
+}
+
+// Deadline of a batch, after which its missing spectra are reported as failed
+var (
+	batchTimeoutBase        = time.Minute
+	batchTimeoutPerSpectrum = 10 * time.Second
+)
+
+// batchTimeout returns how long a batch of n spectra may take
+func batchTimeout(n int) time.Duration {
+	return batchTimeoutBase + time.Duration(n)*batchTimeoutPerSpectrum
+}
 
-		if !realOk || !imagOk {
-			log.Printf("ERROR: Invalid impedance point format at index %d: %+v", i, point)
+// Statuses of the timing of spectra without a fit status of their own
+const (
+	statusCancelled = "CANCELLED"
+	statusMissing   = "MISSING" // not submitted or timed out with the batch
+)
+
+// markMissing records the spectra of batch without a result, not submitted
+// or timed out, as failed in spectrumTimings
+func markMissing(batch models.ImpedanceBatch, results []models.WorkResult, spectrumTimings *batchTimings) {
+	received := make(map[int]bool, len(results))
+	for _, r := range results {
+		received[r.Iteration] = true
+	}
+	for _, item := range batch.Spectra {
+		if received[item.Iteration] {
 			continue
 		}
+		log.Printf("⚠️ Spectrum %d of batch %s has no result, recorded as failed", item.Iteration, batch.BatchID)
+		spectrumTimings.record(models.SpectrumTiming{Iteration: item.Iteration, Status: statusMissing})
+	}
+}
+
+// processChained fits the spectra of batch one at a time in Iteration order,
+// each starting from the parameters of the previous fit. After a failed fit
+// the next spectrum starts from the default initial values again.
+func (h *BatchHandler) processChained(ctx context.Context, batch models.ImpedanceBatch, spectrumTimings *batchTimings) []models.WorkResult {
+	var collected []models.WorkResult
+	requestID := utils.RequestIDOrNew(ctx)
+
+	spectra := append([]models.BatchItem(nil), batch.Spectra...)
+	sort.SliceStable(spectra, func(i, j int) bool {
+		return spectra[i].Iteration < spectra[j].Iteration
+	})
+
+	results := make(chan models.WorkResult, 1)
+	deadline := time.NewTimer(batchTimeout(len(spectra)))
+	defer deadline.Stop()
+
+	var prev []float64
+	for _, item := range spectra {
+		job := h.createWorkItem(item, batch.BatchID, requestID)
+		job.Context = ctx
+		job.Results = results
+		job.InitSource = models.InitDefault
+		if prev != nil {
+			cfg := *job.Config.(*config.Config)
+			cfg.InitValues = append(config.ArrayFlags(nil), prev...)
+			job.Config = &cfg
+			job.InitSource = models.InitChained
+		}
 
-		if math.IsNaN(realVal) || math.IsInf(realVal, 0) || math.IsNaN(imagVal) || math.IsInf(imagVal, 0) {
-			log.Printf("WARNING: Invalid impedance values at index %d: real=%v, imag=%v", i, realVal, imagVal)
+		var result models.WorkResult
+		if ctx.Err() != nil {
+			// Cancelled, the rest of the chain is not submitted
+			result = worker.CancelledResult(job)
+		} else {
+			if err := h.workerPool.SubmitJob(job); err != nil {
+				log.Printf("⚠️ Batch %s stopped at spectrum %d: %v", batch.BatchID, item.Iteration, err)
+				return collected
+			}
+			select {
+			case result = <-results:
+			case <-deadline.C:
+				log.Printf("⚠️ Batch %s timed out at spectrum %d after %v",
+					batch.BatchID, item.Iteration, batchTimeout(len(spectra)))
+				return collected
+			}
+		}
+		h.processResult(result, spectrumTimings, batch.ElementImpedancesIncluded())
+		collected = append(collected, result)
+
+		prev = nil
+		if result.Success && len(result.Result.Params) > 0 {
+			prev = result.Result.Params
+		} else if !result.Cancelled {
+			log.Printf("⚠️ Chain broken at spectrum %d of batch %s, the next fit starts from the default initial values",
+				item.Iteration, batch.BatchID)
 		}
+	}
+	return collected
+}
 
-		impData[i] = [2]float64{realVal, imagVal}
+// createWorkItem converts a batch item to a work item of the batch request
+// requestID, spectra given as magnitude and phase are converted to real/imag
+// pairs
+func (h *BatchHandler) createWorkItem(item models.BatchItem, batchID, requestID string) models.WorkItem {
+	// Convert to internal format with optimized data transformation
+	freqs := item.ImpedanceData.Frequencies
+	impData, _ := item.ImpedanceData.Points() // validated when the batch was accepted
+
+	if !h.config.Quiet {
+		log.Printf("DEBUG: request_id=%s Processing spectrum %d with %d frequencies and %d impedance points",
+			requestID, item.Iteration, len(freqs), len(impData))
+	}
+
+	for i, point := range impData {
+		if math.IsNaN(point[0]) || math.IsInf(point[0], 0) || math.IsNaN(point[1]) || math.IsInf(point[1], 0) {
+			log.Printf("WARNING: Invalid impedance values at index %d: real=%v, imag=%v", i, point[0], point[1])
+		}
+	}
+
+	var measured time.Time
+	if t := measuredAt(item.ImpedanceData); t != nil {
+		measured = *t
 	}
 
 	return models.WorkItem{
 		ID:        item.Iteration,
-		RequestID: utils.GenerateID(),
+		RequestID: requestID,
 		BatchID:   batchID,
 		Iteration: item.Iteration,
 		Freqs:     freqs,
 		ImpData:   impData,
-		Config:    h.config,
+		Sigmas:    item.ImpedanceData.Sigmas(),
+		Config:    requestConfig(h.config, item.ImpedanceData),
 		StartTime: time.Now(),
+
+		MeasuredAt: measured,
 	}
 }
 
-// processResult processes a work result and updates timing
-func (h *BatchHandler) processResult(result models.WorkResult, spectrumTimings []models.SpectrumTiming) {
+// processResult processes a work result, updates timing and queues its
+// webhook, with element impedances when withElements is set. Cancelled
+// spectra send no webhook.
+func (h *BatchHandler) processResult(result models.WorkResult, spectrumTimings *batchTimings, withElements bool) {
 	// Record timing
-	spectrumTimings[result.Iteration] = models.SpectrumTiming{
+	status := result.Result.Status
+	if result.Cancelled {
+		status = statusCancelled
+	}
+	recorded := spectrumTimings.record(models.SpectrumTiming{
 		Iteration:      result.Iteration,
 		ProcessingTime: result.ProcessingTime,
 		ChiSquare:      result.Result.Min, // Extract chi-square from EIS result
 		Success:        result.Success,
 		CircuitCode:    result.CircuitCode,
+		Outliers:       len(result.Result.Excluded),
+		InitSource:     result.InitSource,
+		Status:         status,
+	})
+	if !recorded {
+		log.Printf("WARNING: Iteration %d is not part of batch %s, timing not recorded", result.Iteration, result.BatchID)
 	}
 
-	// Create webhook item
-	// TODO: Integrate with proper element calculation
-	webhook := models.WebhookItem{
-		RequestID:   fmt.Sprintf("%s_iter_%03d", result.RequestID, result.Iteration),
-		ChiSquare:   result.Result.Min, // Extract chi-square from EIS result
-		RealImp:     result.RealImp,
-		ImagImp:     result.ImagImp,
-		Freqs:       result.Freqs,
-		CircuitCode: result.CircuitCode,
+	h.jobs.Record(result.BatchID, resultState(result.Success, result.Cancelled))
+	if result.Cancelled {
+		if !h.config.Quiet {
+			log.Printf("🚫 Spectrum iteration %d of batch %s cancelled", result.Iteration, result.BatchID)
+		}
+		return
 	}
 
+	webhook := fitWebhook(fmt.Sprintf("%s_iter_%03d", result.RequestID, result.Iteration), result.Result,
+		result.CircuitCode, result.Freqs, result.RealImp, result.ImagImp, withElements)
+	webhook.InitSource = result.InitSource
+	// Only the trace context, the webhook is sent after the batch ended
+	webhook.Context = context.WithoutCancel(result.Context)
 	h.workerPool.QueueWebhook(webhook)
 
 	if !h.config.Quiet {
@@ -174,6 +550,57 @@ func (h *BatchHandler) processResult(result models.WorkResult, spectrumTimings [
 	}
 }
 
+// batchTimings holds the timing of every spectrum of a batch in Iteration
+// order. Iterations are numbered by the client, they need not start at 0
+// nor be dense, but are unique.
+type batchTimings struct {
+	timings []models.SpectrumTiming
+	index   map[int]int // Iteration -> position in timings
+}
+
+func newBatchTimings(batch models.ImpedanceBatch) *batchTimings {
+	iterations := make([]int, len(batch.Spectra))
+	for i, item := range batch.Spectra {
+		iterations[i] = item.Iteration
+	}
+	sort.Ints(iterations)
+
+	t := &batchTimings{
+		timings: make([]models.SpectrumTiming, len(iterations)),
+		index:   make(map[int]int, len(iterations)),
+	}
+	for i, iteration := range iterations {
+		t.timings[i].Iteration = iteration
+		t.index[iteration] = i
+	}
+	return t
+}
+
+// add makes room for the timing of iteration, for batches whose spectra
+// arrive while they are processed
+func (t *batchTimings) add(iteration int) {
+	if _, ok := t.index[iteration]; ok {
+		return
+	}
+	i := sort.Search(len(t.timings), func(i int) bool { return t.timings[i].Iteration > iteration })
+	t.timings = append(t.timings, models.SpectrumTiming{})
+	copy(t.timings[i+1:], t.timings[i:])
+	t.timings[i] = models.SpectrumTiming{Iteration: iteration}
+	for j := i; j < len(t.timings); j++ {
+		t.index[t.timings[j].Iteration] = j
+	}
+}
+
+// record stores timing at the position of its Iteration, false when the
+// iteration is not part of the batch
+func (t *batchTimings) record(timing models.SpectrumTiming) bool {
+	i, ok := t.index[timing.Iteration]
+	if ok {
+		t.timings[i] = timing
+	}
+	return ok
+}
+
 // getConcurrency returns the current concurrency level
 func (h *BatchHandler) getConcurrency() int {
 	concurrency := 5
@@ -183,9 +610,13 @@ func (h *BatchHandler) getConcurrency() int {
 	return concurrency
 }
 
-// saveTimingResults saves timing data to a CSV file for performance analysis
+// saveTimingResults appends timing data to the CSV file of config.TimingFile
+// for performance analysis, nothing is saved when it is empty
 func (h *BatchHandler) saveTimingResults(batchID string, totalTime time.Duration, spectrumTimings []models.SpectrumTiming, concurrency int) {
-	filename := "concurrent_timing_results.csv"
+	if h.config == nil || h.config.TimingFile == "" {
+		return
+	}
+	filename := h.config.TimingFile
 
 	// Check if file exists to decide on header
 	var writeHeader bool
@@ -220,6 +651,12 @@ func (h *BatchHandler) saveTimingResults(batchID string, totalTime time.Duration
 			"SpectraPerSecond",
 			"EfficiencyScore",
 			"CircuitCode",
+			"TotalOutliers",
+			"OutliersPerSpectrum",
+			"InitSources",
+			"Errors",
+			"Timeouts",
+			"Statuses",
 		}
 		if err := writer.Write(header); err != nil {
 			log.Printf("Error writing timing header: %v", err)
@@ -230,10 +667,23 @@ func (h *BatchHandler) saveTimingResults(batchID string, totalTime time.Duration
 	// Calculate statistics
 	var totalSpectrumTime time.Duration
 	var minTime, maxTime time.Duration = time.Hour, 0
-	var successful int
+	var successful, totalOutliers, errorCount, timeouts int
 	var totalChiSq float64
-
-	for _, timing := range spectrumTimings {
+	outliers := make([]string, len(spectrumTimings))
+	initSources := make([]string, len(spectrumTimings))
+	statuses := make([]string, len(spectrumTimings))
+
+	for i, timing := range spectrumTimings {
+		totalOutliers += timing.Outliers
+		outliers[i] = strconv.Itoa(timing.Outliers)
+		initSources[i] = timing.InitSource
+		statuses[i] = timing.Status
+		switch {
+		case timing.Status == goimpcore.TIMEOUT:
+			timeouts++
+		case !timing.Success && timing.Status != statusCancelled:
+			errorCount++
+		}
 		totalSpectrumTime += timing.ProcessingTime
 		if timing.ProcessingTime < minTime {
 			minTime = timing.ProcessingTime
@@ -283,6 +733,12 @@ func (h *BatchHandler) saveTimingResults(batchID string, totalTime time.Duration
 		fmt.Sprintf("%.2f", spectraPerSecond),
 		fmt.Sprintf("%.3f", efficiencyScore),
 		circuitCode,
+		fmt.Sprintf("%d", totalOutliers),
+		strings.Join(outliers, ";"),
+		strings.Join(initSources, ";"),
+		strconv.Itoa(errorCount),
+		strconv.Itoa(timeouts),
+		strings.Join(statuses, ";"),
 	}
 
 	if err := writer.Write(record); err != nil {
@@ -294,14 +750,6 @@ func (h *BatchHandler) saveTimingResults(batchID string, totalTime time.Duration
 		numSpectra, concurrency, float64(totalTime.Nanoseconds())/1000000.0, successRate, efficiencyScore)
 }
 
-// setupCORS sets up CORS headers
-func (h *BatchHandler) setupCORS(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-}
-
 // writeError writes an error response
 func (h *BatchHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
 	w.WriteHeader(statusCode)
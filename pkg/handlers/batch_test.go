@@ -0,0 +1,454 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// testTimings are the timings of a batch of two spectra
+var testTimings = []models.SpectrumTiming{
+	{Iteration: 1, ProcessingTime: 10 * time.Millisecond, ChiSquare: 1e-4, Success: true, CircuitCode: "R(QR)", Status: "OK"},
+	{Iteration: 2, ProcessingTime: 20 * time.Millisecond, ChiSquare: 2e-4, Success: true, CircuitCode: "R(QR)", Status: "OK"},
+}
+
+// readCSV returns the records of the CSV file name
+func readCSV(t *testing.T, name string) [][]string {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestSaveTimingResultsFile(t *testing.T) {
+	chdirTemp(t)
+	cfg := testConfig()
+	cfg.TimingFile = filepath.Join(t.TempDir(), "timings.csv")
+	h := NewBatchHandler(cfg, nil, nil, nil, nil, Limits{}, nil, nil)
+
+	h.saveTimingResults("b1", time.Second, testTimings, 2)
+	h.saveTimingResults("b2", time.Second, testTimings, 2)
+
+	records := readCSV(t, cfg.TimingFile)
+	if len(records) != 3 {
+		t.Fatalf("%d records, want a header and 2 rows", len(records))
+	}
+	for _, record := range records[1:] {
+		if len(record) != len(records[0]) {
+			t.Errorf("row of %d fields under a header of %d", len(record), len(records[0]))
+		}
+	}
+	if _, err := os.Stat(config.DefaultTimingFile); !os.IsNotExist(err) {
+		t.Errorf("timings also written to the working directory")
+	}
+}
+
+func TestSaveTimingResultsDisabled(t *testing.T) {
+	chdirTemp(t)
+	cfg := testConfig()
+	cfg.TimingFile = ""
+	h := NewBatchHandler(cfg, nil, nil, nil, nil, Limits{}, nil, nil)
+
+	h.saveTimingResults("b1", time.Second, testTimings, 2)
+
+	if entries, _ := os.ReadDir("."); len(entries) != 0 {
+		t.Errorf("%d files written without a timing file", len(entries))
+	}
+}
+
+// waitBatch waits for the batch batchID to complete in results
+func waitBatch(t *testing.T, results store.Store, batchID string) models.BatchResult {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if batch, ok := results.Batch(batchID); ok && batch.CompletedAt != nil {
+			return batch
+		}
+	}
+	t.Fatalf("batch %s did not complete", batchID)
+	return models.BatchResult{}
+}
+
+// Two batches fitted at once on the same worker pool, with circuits telling
+// their spectra apart, only receive their own results, timing rows and
+// webhooks
+func TestConcurrentBatchesIsolated(t *testing.T) {
+	chdirTemp(t)
+	const spectra = 10
+	circuits := map[string]string{"batch-a": "R(QR)", "batch-b": "R(CR)"}
+
+	var mu sync.Mutex
+	var webhooks []models.WebhookItem
+	pool := worker.New(worker.Options{
+		Workers: 4,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond) // interleave the batches
+			params := make([]float64, len(goimpcore.GetElements(strings.ToLower(cfg.Code))))
+			for i := range params {
+				params[i] = 1
+			}
+			return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: params, Min: 1e-6}
+		},
+		Webhook: func(webhook models.WebhookItem) error {
+			mu.Lock()
+			webhooks = append(webhooks, webhook)
+			mu.Unlock()
+			return nil
+		},
+	})
+	defer pool.Shutdown()
+
+	cfg := testConfig()
+	cfg.TimingFile = filepath.Join(t.TempDir(), "timings.csv")
+	results := store.NewMemory(time.Minute, 100)
+	events := make(chan models.BatchCompleteEvent, len(circuits))
+	complete := func(event models.BatchCompleteEvent) error {
+		events <- event
+		return nil
+	}
+	h := NewBatchHandler(cfg, pool, nil, complete, results, Limits{}, nil, nil)
+
+	var wg sync.WaitGroup
+	for batchID, code := range circuits {
+		batch := models.ImpedanceBatch{BatchID: batchID, Timestamp: time.Now()}
+		for i := 1; i <= spectra; i++ {
+			data := testSpectrum(t)
+			data.CircuitCode = code
+			batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: data, Iteration: i})
+		}
+		body, err := json.Marshal(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("batch %s: status %d, body %s", batchID, rec.Code, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for batchID, code := range circuits {
+		batch := waitBatch(t, results, batchID)
+		if len(batch.Results) != spectra {
+			t.Errorf("batch %s has %d results, want %d", batchID, len(batch.Results), spectra)
+		}
+		seen := make(map[int]bool)
+		for _, res := range batch.Results {
+			if res.CircuitCode != code {
+				t.Errorf("batch %s holds a %s result, want %s", batchID, res.CircuitCode, code)
+			}
+			seen[res.Iteration] = true
+		}
+		if len(seen) != spectra {
+			t.Errorf("batch %s has %d distinct iterations, want %d", batchID, len(seen), spectra)
+		}
+	}
+	for range circuits {
+		event := <-events
+		if event.Spectra != spectra || event.Succeeded != spectra {
+			t.Errorf("batch %s reported %d of %d spectra succeeded, want %d", event.BatchID, event.Succeeded, event.Spectra, spectra)
+		}
+	}
+
+	// One timing row per batch, with its own circuit
+	records := readCSV(t, cfg.TimingFile)
+	column := make(map[string]int)
+	for i, name := range records[0] {
+		column[name] = i
+	}
+	if len(records) != 1+len(circuits) {
+		t.Fatalf("%d timing rows, want %d", len(records)-1, len(circuits))
+	}
+	for _, record := range records[1:] {
+		batchID := record[column["BatchID"]]
+		if got := record[column["CircuitCode"]]; got != circuits[batchID] {
+			t.Errorf("timing row of %s has circuit %s, want %s", batchID, got, circuits[batchID])
+		}
+		if got := record[column["TotalSpectra"]]; got != fmt.Sprint(spectra) {
+			t.Errorf("timing row of %s has %s spectra, want %d", batchID, got, spectra)
+		}
+	}
+
+	// Webhooks are sent in the background, one per spectrum
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		mu.Lock()
+		n := len(webhooks)
+		mu.Unlock()
+		if n >= 2*spectra {
+			break
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	counts := make(map[string]int)
+	for _, webhook := range webhooks {
+		counts[webhook.CircuitCode]++
+	}
+	for batchID, code := range circuits {
+		if counts[code] != spectra {
+			t.Errorf("batch %s sent %d webhooks of circuit %s, want %d", batchID, counts[code], code, spectra)
+		}
+	}
+}
+
+// Two batches collected at once on the same worker pool each receive exactly
+// their own results
+func TestProcessConcurrentBatchIDs(t *testing.T) {
+	const spectra = 10
+	pool := worker.New(worker.Options{
+		Workers: 4,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond) // interleave the batches
+			return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{10, 1e-5, 0.9, 100}}
+		},
+	})
+	defer pool.Shutdown()
+	h := NewBatchHandler(testConfig(), pool, nil, nil, nil, Limits{}, nil, nil)
+
+	batchIDs := []string{"batch-a", "batch-b"}
+	results := make([][]models.WorkResult, len(batchIDs))
+	var wg sync.WaitGroup
+	for i, batchID := range batchIDs {
+		batch := models.ImpedanceBatch{BatchID: batchID}
+		for iteration := 1; iteration <= spectra; iteration++ {
+			batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: testSpectrum(t), Iteration: iteration})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.processConcurrent(context.Background(), batch, newBatchTimings(batch))
+		}()
+	}
+	wg.Wait()
+
+	for i, batchID := range batchIDs {
+		if len(results[i]) != spectra {
+			t.Errorf("batch %s received %d results, want %d", batchID, len(results[i]), spectra)
+		}
+		for _, result := range results[i] {
+			if result.BatchID != batchID {
+				t.Errorf("batch %s received a result of %s", batchID, result.BatchID)
+			}
+		}
+	}
+}
+
+// Batches numbered from 1 or with gaps get a result per spectrum under the
+// client's iteration number, in both the concurrent and the chained mode, and
+// repeated iterations are rejected
+func TestBatchIterationNumbering(t *testing.T) {
+	chdirTemp(t)
+	var mu sync.Mutex
+	var webhooks []string
+	pool := worker.New(worker.Options{
+		Workers: 2,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{1, 1, 1, 1}, Min: 1e-6}
+		},
+		Webhook: func(webhook models.WebhookItem) error {
+			mu.Lock()
+			webhooks = append(webhooks, webhook.RequestID)
+			mu.Unlock()
+			return nil
+		},
+	})
+	defer pool.Shutdown()
+	results := store.NewMemory(time.Minute, 100)
+	h := NewBatchHandler(testConfig(), pool, nil, nil, results, Limits{}, nil, nil)
+
+	tests := []struct {
+		name       string
+		iterations []int
+		chained    bool
+	}{
+		{"one-based", []int{1, 2, 3}, false},
+		{"sparse", []int{40, 7, 1000, 15}, false},
+		{"sparse-chained", []int{40, 7, 1000, 15}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			webhooks = nil
+			mu.Unlock()
+			batch := models.ImpedanceBatch{BatchID: tt.name, ChainInit: tt.chained}
+			for _, iteration := range tt.iterations {
+				batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: testSpectrum(t), Iteration: iteration})
+			}
+			body, _ := json.Marshal(batch)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body)))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+
+			done := waitBatch(t, results, tt.name)
+			var got []int
+			for _, res := range done.Results {
+				if res.Status != models.StatusCompleted {
+					t.Errorf("iteration %d: status %s", res.Iteration, res.Status)
+				}
+				got = append(got, res.Iteration)
+			}
+			slices.Sort(got)
+			want := slices.Sorted(slices.Values(tt.iterations))
+			if !slices.Equal(got, want) {
+				t.Errorf("results of iterations %v, want %v", got, want)
+			}
+			if done.Summary == nil || done.Summary.Succeeded != len(tt.iterations) {
+				t.Errorf("summary %+v, want %d succeeded", done.Summary, len(tt.iterations))
+			}
+
+			// Webhooks are sent in the background
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				mu.Lock()
+				n := len(webhooks)
+				mu.Unlock()
+				if n >= len(tt.iterations) {
+					break
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, iteration := range tt.iterations {
+				suffix := fmt.Sprintf("_iter_%03d", iteration)
+				if !slices.ContainsFunc(webhooks, func(id string) bool { return strings.HasSuffix(id, suffix) }) {
+					t.Errorf("no webhook for iteration %d in %v", iteration, webhooks)
+				}
+			}
+		})
+	}
+
+	batch := models.ImpedanceBatch{BatchID: "duplicates", Spectra: []models.BatchItem{
+		{ImpedanceData: testSpectrum(t), Iteration: 1},
+		{ImpedanceData: testSpectrum(t), Iteration: 1},
+	}}
+	body, _ := json.Marshal(batch)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "duplicates iteration 1") {
+		t.Errorf("repeated iterations: status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+// Cancelling a batch of 100 spectra after 30 fits keeps those results, the
+// rest is stored cancelled
+func TestCancelBatchKeepsPartialResults(t *testing.T) {
+	chdirTemp(t)
+	const spectra, done = 100, 30
+
+	var fits atomic.Int32
+	stalled := make(chan struct{})
+	pool := worker.New(worker.Options{
+		Workers: 1,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			if n := fits.Add(1); n > done {
+				if n == done+1 {
+					close(stalled)
+				}
+				<-ctx.Done() // running when the batch is cancelled
+			}
+			return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{10, 1e-5, 0.9, 100}, Min: 1e-6}
+		},
+	})
+	defer pool.Shutdown()
+
+	results := store.NewMemory(time.Minute, 100)
+	registry := jobs.NewRegistry(time.Minute)
+	h := NewBatchHandler(testConfig(), pool, nil, nil, results, Limits{}, registry, nil)
+
+	batch := models.ImpedanceBatch{BatchID: "cancel-me"}
+	for i := 1; i <= spectra; i++ {
+		batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: testSpectrum(t), Iteration: i})
+	}
+	if rec := postJSON(t, h, "/eis-data/batch", batch); rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	select {
+	case <-stalled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the batch did not reach its 31st fit")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if job, _ := registry.Get(batch.BatchID); job.Succeeded == done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d fits were not recorded", done)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/eis-data/batch/"+batch.BatchID, nil))
+	var cancelled struct {
+		Cancelled int `json:"cancelled"`
+		Completed int `json:"completed"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &cancelled); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	if cancelled.Completed != done || cancelled.Cancelled != spectra-done {
+		t.Errorf("%d completed and %d cancelled, want %d and %d", cancelled.Completed, cancelled.Cancelled, done, spectra-done)
+	}
+
+	stored := waitBatch(t, results, batch.BatchID)
+	if stored.Status != models.StatusCancelled || len(stored.Results) != spectra {
+		t.Fatalf("batch %s with %d results, want %s with %d", stored.Status, len(stored.Results), models.StatusCancelled, spectra)
+	}
+	var got models.BatchResult
+	if code := getJSON(t, NewResultsHandler(results), "/batches/"+batch.BatchID, &got); code != http.StatusOK || len(got.Results) != spectra {
+		t.Fatalf("GET /batches: status %d with %d results", code, len(got.Results))
+	}
+	for i, res := range got.Results {
+		want := models.StatusCompleted
+		if i >= done {
+			want = models.StatusCancelled
+		}
+		if res.Iteration != i+1 || res.Status != want {
+			t.Errorf("result %d: iteration %d %s, want %d %s", i, res.Iteration, res.Status, i+1, want)
+		}
+		if want == models.StatusCompleted && len(res.Parameters) != 4 {
+			t.Errorf("result %d: %d parameters kept, want 4", i, len(res.Parameters))
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/eis-data/batch/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown batch: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/plot"
+)
+
+// BodeLookup returns the Bode plot of a completed request
+type BodeLookup func(requestID string) (plot.FittedBodePlot, bool)
+
+// BodeHandler returns measured and fitted Bode plot series
+type BodeHandler struct {
+	config    *config.Config
+	processor ProcessorFunc
+	lookup    BodeLookup
+	limits    Limits
+}
+
+// NewBodeHandler creates a new Bode plot handler, lookup may be nil when
+// completed results are not kept
+func NewBodeHandler(cfg *config.Config, processor ProcessorFunc, lookup BodeLookup, limits Limits) *BodeHandler {
+	return &BodeHandler{
+		config:    cfg,
+		processor: processor,
+		lookup:    lookup,
+		limits:    limits,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *BodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.BodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	if err := h.limits.checkPoints(len(req.Frequencies)); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if req.RequestID != "" {
+		if h.lookup == nil {
+			h.writeError(w, "Results are not stored, send the data inline", http.StatusNotFound)
+			return
+		}
+		bode, ok := h.lookup(req.RequestID)
+		if !ok {
+			h.writeError(w, "Unknown request_id", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(bode)
+		return
+	}
+
+	freqs := req.Frequencies
+	if len(freqs) == 0 {
+		h.writeError(w, "No data points provided", http.StatusBadRequest)
+		return
+	}
+	impData, err := req.Points()
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code := req.Code
+	if code == "" {
+		code = h.config.Code
+	}
+
+	params := req.Params
+	if len(params) == 0 {
+		cfg := *requestConfig(h.config, req.ImpedanceData)
+		cfg.Code = code
+		if err := validateFit(freqs, &cfg); err != nil {
+			h.writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if res, ok := h.processor(r.Context(), freqs, impData, req.Sigmas(), &cfg).(goimpcore.Result); ok && res.Status == goimpcore.OK {
+			params = res.Params
+		} else {
+			log.Printf("Bode: fit of %s failed, returning measured data only", code)
+		}
+	}
+
+	var fitted [][2]float64
+	if len(params) > 0 {
+		if len(params) != len(goimpcore.GetElements(strings.ToLower(code))) {
+			h.writeError(w, "Parameter count does not match the circuit code", http.StatusBadRequest)
+			return
+		}
+		fitted = goimpcore.CircuitImpedance(strings.ToLower(code), freqs, params)
+	}
+
+	bode := plot.FittedBodeData(freqs, impData, fitted)
+	bode.Code = code
+	bode.Params = params
+
+	json.NewEncoder(w).Encode(bode)
+}
+
+// writeError writes an error response
+func (h *BodeHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
+)
+
+// CircuitsHandler serves the circuit library, /circuits lists every circuit
+// and /circuits/{name} returns a single one
+type CircuitsHandler struct {
+	library *circuits.Library
+}
+
+// NewCircuitsHandler creates a new circuit library handler
+func NewCircuitsHandler(library *circuits.Library) *CircuitsHandler {
+	return &CircuitsHandler{
+		library: library,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *CircuitsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/circuits"), "/")
+	if name == "" {
+		json.NewEncoder(w).Encode(h.library.All())
+		return
+	}
+
+	def, ok := h.library.Get(name)
+	if !ok {
+		h.writeError(w, "Unknown circuit", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(def)
+}
+
+// writeError writes an error response
+func (h *CircuitsHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
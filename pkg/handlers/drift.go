@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// BatchCompleteFunc delivers the batch-complete event of a processed batch
+type BatchCompleteFunc func(event models.BatchCompleteEvent) error
+
+// newBatchCompleteEvent aggregates the results of batch, processed in
+// wallTime. Best and worst spectra are picked among the successful fits by
+// chi-square, cancelled spectra are counted apart from the failed ones.
+func newBatchCompleteEvent(batch models.ImpedanceBatch, results []models.WorkResult, wallTime time.Duration) models.BatchCompleteEvent {
+	event := models.BatchCompleteEvent{
+		Type:       models.EventBatchComplete,
+		BatchID:    batch.BatchID,
+		Time:       time.Now().Format(time.RFC3339Nano),
+		Spectra:    len(batch.Spectra),
+		WallTimeMs: float64(wallTime.Nanoseconds()) / 1e6,
+		Drift:      newBatchSummary(batch, results),
+	}
+
+	var sum, best, worst float64
+	for _, r := range results {
+		if r.Cancelled {
+			event.CancelledIterations = append(event.CancelledIterations, r.Iteration)
+			continue
+		}
+		if !r.Success || finite(r.Result.Min) == nil {
+			continue
+		}
+		iteration, chiSq := r.Iteration, r.Result.Min
+		if event.Succeeded == 0 || chiSq < best {
+			best, event.BestIteration = chiSq, &iteration
+		}
+		if event.Succeeded == 0 || chiSq > worst {
+			worst, event.WorstIteration = chiSq, &iteration
+		}
+		sum += chiSq
+		event.Succeeded++
+	}
+	sort.Ints(event.CancelledIterations)
+	event.Cancelled = len(event.CancelledIterations)
+	event.Failed = event.Spectra - event.Succeeded - event.Cancelled
+	if event.Succeeded > 0 {
+		event.AvgChiSquare = sum / float64(event.Succeeded)
+	}
+	return event
+}
+
+// newBatchSummary aggregates the results of batch into one series per fitted
+// parameter, named by goimpcore.ParamLabels. Spectra without a successful
+// result keep their row with nil values.
+func newBatchSummary(batch models.ImpedanceBatch, results []models.WorkResult) models.BatchSummary {
+	byIteration := make(map[int]models.WorkResult, len(results))
+	for _, r := range results {
+		byIteration[r.Iteration] = r
+	}
+
+	iterations := make([]int, len(batch.Spectra))
+	for i, item := range batch.Spectra {
+		iterations[i] = item.Iteration
+	}
+	sort.Ints(iterations)
+
+	summary := models.BatchSummary{
+		BatchID:    batch.BatchID,
+		Iterations: iterations,
+		ChiSquare:  make([]*float64, len(iterations)),
+		Success:    make([]bool, len(iterations)),
+	}
+
+	// Circuit comparisons may pick different circuits per spectrum, so the
+	// series are the union of their parameters in order of appearance
+	series := make(map[string]int)
+	for row, iteration := range iterations {
+		r, ok := byIteration[iteration]
+		if !ok || !r.Success {
+			continue
+		}
+		summary.Success[row] = true
+		summary.ChiSquare[row] = finite(r.Result.Min)
+
+		names := goimpcore.ParamLabels(r.CircuitCode)
+		if len(names) != len(r.Result.Params) {
+			continue
+		}
+		for i, name := range names {
+			idx, ok := series[name]
+			if !ok {
+				idx = len(summary.Parameters)
+				series[name] = idx
+				summary.Parameters = append(summary.Parameters, models.ParamSeries{
+					Name:   name,
+					Values: make([]*float64, len(iterations)),
+				})
+			}
+			summary.Parameters[idx].Values[row] = finite(r.Result.Params[i])
+		}
+	}
+	return summary
+}
+
+// finite returns a pointer to v, nil for NaN and infinities
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// unsafeFileChars matches what may not appear in a batch ID used in a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// saveBatchSummary writes summary to batch_<id>_params.csv, one row per
+// iteration with blank cells for missing values
+func saveBatchSummary(summary models.BatchSummary) (string, error) {
+	filename := fmt.Sprintf("batch_%s_params.csv", unsafeFileChars.ReplaceAllString(summary.BatchID, "_"))
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"Iteration", "ChiSquare", "Success"}
+	for _, p := range summary.Parameters {
+		header = append(header, p.Name)
+	}
+	if err := writer.Write(header); err != nil {
+		return "", err
+	}
+
+	for row, iteration := range summary.Iterations {
+		record := []string{
+			strconv.Itoa(iteration),
+			formatCell(summary.ChiSquare[row]),
+			strconv.FormatBool(summary.Success[row]),
+		}
+		for _, p := range summary.Parameters {
+			record = append(record, formatCell(p.Values[row]))
+		}
+		if err := writer.Write(record); err != nil {
+			return "", err
+		}
+	}
+
+	writer.Flush()
+	return filename, writer.Error()
+}
+
+// formatCell formats a CSV value, blank for nil
+func formatCell(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'e', 12, 64)
+}
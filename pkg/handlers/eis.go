@@ -1,13 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kacperjurak/goimpcore"
 	"github.com/kacperjurak/goimpcore/internal/utils"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/dedup"
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
 	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/queue"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/webhook"
 	"github.com/kacperjurak/goimpcore/pkg/worker"
 )
 
@@ -16,23 +29,80 @@ type EISHandler struct {
 	config     *config.Config
 	workerPool *worker.Pool
 	processor  ProcessorFunc
+	results    store.Store
+	limits     Limits
+	dedup      *dedup.Deduplicator
+	jobs       *jobs.Registry
+	queue      *queue.Queue
 }
 
+// Limits caps the size of decoded requests, 0 for no limit
+type Limits struct {
+	MaxBatchSpectra    int
+	MaxFrequencyPoints int
+	MaxLineBytes       int64 // one line of an NDJSON batch stream, one /ws message
+}
+
+// checkPoints checks the number of frequencies of one spectrum
+func (l Limits) checkPoints(n int) error {
+	if l.MaxFrequencyPoints > 0 && n > l.MaxFrequencyPoints {
+		return fmt.Errorf("spectrum has %d frequencies, at most %d are accepted", n, l.MaxFrequencyPoints)
+	}
+	return nil
+}
+
+// checkBatch checks the number of spectra of a batch and their frequencies
+func (l Limits) checkBatch(batch models.ImpedanceBatch) error {
+	if l.MaxBatchSpectra > 0 && len(batch.Spectra) > l.MaxBatchSpectra {
+		return fmt.Errorf("batch has %d spectra, at most %d are accepted", len(batch.Spectra), l.MaxBatchSpectra)
+	}
+	for _, item := range batch.Spectra {
+		if err := l.checkPoints(len(item.ImpedanceData.Frequencies)); err != nil {
+			return fmt.Errorf("spectrum %d: %v", item.Iteration, err)
+		}
+	}
+	return nil
+}
+
+// decodeError returns the error message and status of a request body that
+// failed to decode, 413 when it exceeded the body size limit
+func decodeError(err error) (string, int) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge
+	}
+	return "Invalid JSON format", http.StatusBadRequest
+}
+
+// DefaultSyncTimeout bounds synchronous fits when the config sets none
+const DefaultSyncTimeout = 30 * time.Second
+
+// syncWriteMargin is the time left to write the response of a synchronous fit
+const syncWriteMargin = 5 * time.Second
+
 // ProcessorFunc defines the signature for EIS data processing
-type ProcessorFunc func(freqs []float64, impData [][2]float64, config *config.Config) interface{}
+type ProcessorFunc func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) interface{}
 
-// NewEISHandler creates a new EIS handler
-func NewEISHandler(cfg *config.Config, pool *worker.Pool, processor ProcessorFunc) *EISHandler {
+// NewEISHandler creates a new EIS handler, results may be nil when completed
+// fits are not kept for retrieval, dedup nil when identical concurrent
+// requests are fitted separately, registry nil when asynchronous fits
+// cannot be cancelled and q nil when they are not persisted
+func NewEISHandler(cfg *config.Config, pool *worker.Pool, processor ProcessorFunc, results store.Store, limits Limits, dedup *dedup.Deduplicator, registry *jobs.Registry, q *queue.Queue) *EISHandler {
 	return &EISHandler{
 		config:     cfg,
 		workerPool: pool,
 		processor:  processor,
+		results:    results,
+		limits:     limits,
+		dedup:      dedup,
+		jobs:       registry,
+		queue:      q,
 	}
 }
 
 // ServeHTTP implements the http.Handler interface
 func (h *EISHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.setupCORS(w)
+	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -46,20 +116,105 @@ func (h *EISHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	var impedanceData models.ImpedanceData
 	if err := json.NewDecoder(r.Body).Decode(&impedanceData); err != nil {
-		h.writeError(w, "Invalid JSON format", http.StatusBadRequest)
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	h.serveData(w, r, impedanceData)
+}
+
+// serveData fits the decoded spectrum of r, asynchronously unless r asks for
+// a synchronous fit
+func (h *EISHandler) serveData(w http.ResponseWriter, r *http.Request, impedanceData models.ImpedanceData) {
+	if err := h.limits.checkPoints(len(impedanceData.Frequencies)); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if errs := models.ValidateImpedanceData(impedanceData); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	impData, err := impedanceData.Points()
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if len(impedanceData.Frequencies) == 0 {
-		h.writeError(w, "No data points provided", http.StatusBadRequest)
+	cfg := requestConfig(h.config, impedanceData)
+	if err := validateFit(impedanceData.Frequencies, cfg); err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Generate unique ID for this request
-	requestID := utils.GenerateID()
+	// The ID of the request log, a new one outside the server middleware
+	requestID := utils.RequestIDOrNew(r.Context())
+	sync := isSyncRequest(r)
+
+	// A retry of an accepted asynchronous request gets the ID of the first one
+	var retryKey string
+	if !sync && h.results != nil {
+		if retryKey = requestKey(r, impedanceData.RequestKey); retryKey != "" {
+			retryKey = "fit/" + retryKey
+			if owner, claimed := h.results.ClaimKey(retryKey, requestID); !claimed {
+				h.writeRetriedFit(w, owner)
+				return
+			}
+		}
+	}
+
+	// An identical request in flight answers this one too
+	var call *dedup.Call
+	if h.dedup != nil {
+		key := dedup.Key(impedanceData.Frequencies, impData, impedanceData.Sigmas(), fitSettings(cfg, sync))
+		var first bool
+		if call, first = h.dedup.Join(key, requestID); !first {
+			if retryKey != "" {
+				// Retries get the ID of the request answering this one
+				h.results.ReleaseKey(retryKey)
+				h.results.ClaimKey(retryKey, call.ID)
+			}
+			h.joinRequest(w, r, call, sync)
+			return
+		}
+	}
+	pending := pendingResult(requestID)
+	pending.MeasuredAt = measuredAt(impedanceData)
+
+	if sync {
+		h.processSync(w, r, call, pending, impedanceData, impData, cfg)
+		return
+	}
 
 	// Process data asynchronously
-	go h.processAsync(requestID, impedanceData)
+	// The response is written before processing finishes, which cancels r.Context(),
+	// so keep its trace context but detach the cancellation from the request lifetime,
+	// DELETE /jobs/{request_id} cancels it instead
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	if h.jobs != nil {
+		if _, err := h.jobs.Add(requestID, jobs.KindFit, 1, cancel); err != nil {
+			cancel()
+			h.dedup.Done(call, nil)
+			releaseKey(h.results, retryKey)
+			h.writeError(w, fmt.Sprintf("Request %s: %v", requestID, err), http.StatusConflict)
+			return
+		}
+	}
+	if err := acceptJob(h.queue, requestID, jobs.KindFit, requestID, impedanceData); err != nil {
+		cancel()
+		h.jobs.Finish(requestID, jobs.StateFailed)
+		h.dedup.Done(call, nil)
+		releaseKey(h.results, retryKey)
+		h.writeError(w, fmt.Sprintf("Request %s not queued: %v", requestID, err), http.StatusInternalServerError)
+		return
+	}
+
+	if h.results != nil {
+		h.results.PutResult(pending)
+	}
+
+	go h.processAsync(ctx, cancel, call, pending, impedanceData, impData, cfg)
 
 	// Return immediate response
 	response := map[string]interface{}{
@@ -67,6 +222,12 @@ func (h *EISHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"request_id": requestID,
 		"message":    "Processing started",
 	}
+	if h.results != nil {
+		response["result_url"] = "/results/" + requestID
+	}
+	if h.jobs != nil {
+		response["job_url"] = "/jobs/" + requestID
+	}
 
 	if !h.config.Quiet {
 		log.Printf("HTTP Request received - ID: %s, Data points: %d", requestID, len(impedanceData.Frequencies))
@@ -76,47 +237,346 @@ func (h *EISHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// processAsync handles asynchronous processing of EIS data
-func (h *EISHandler) processAsync(requestID string, impedanceData models.ImpedanceData) {
-	// Convert ImpedanceData to internal format
-	freqs := impedanceData.Frequencies
-	impData := make([][2]float64, len(impedanceData.Impedance))
+// Resume restarts the asynchronous fit of job, accepted before a restart
+func (h *EISHandler) Resume(job queue.Job) error {
+	var impedanceData models.ImpedanceData
+	if err := json.Unmarshal(job.Payload, &impedanceData); err != nil {
+		return err
+	}
+	impData, err := impedanceData.Points()
+	if err != nil {
+		return err
+	}
 
-	for i, point := range impedanceData.Impedance {
-		impData[i] = [2]float64{point["real"], point["imag"]}
+	ctx, cancel := context.WithCancel(utils.WithRequestID(context.Background(), job.RequestID))
+	if h.jobs != nil {
+		if _, err := h.jobs.Add(job.ID, jobs.KindFit, 1, cancel); err != nil {
+			cancel()
+			return err
+		}
 	}
+	pending := pendingResult(job.ID)
+	pending.MeasuredAt = measuredAt(impedanceData)
+	if h.results != nil {
+		h.results.PutResult(pending)
+	}
+
+	go h.processAsync(ctx, cancel, nil, pending, impedanceData, impData, requestConfig(h.config, impedanceData))
+	return nil
+}
+
+// processAsync handles asynchronous processing of EIS data. impData comes from
+// ImpedanceData.Points, so magnitude/phase payloads arrive as real/imag pairs
+// and the webhook reports them as such. pending is the stored entry of the
+// request, replaced once the fit completes. call is the deduplicated call of
+// the request, nil when deduplication is off, cancelling it cancels the fit of
+// the requests that joined it too. A cancelled fit sends no webhook. The
+// queued job is finished once the webhook is queued.
+func (h *EISHandler) processAsync(ctx context.Context, cancel context.CancelFunc, call *dedup.Call, pending models.FitResult, impedanceData models.ImpedanceData, impData [][2]float64, cfg *config.Config) {
+	defer h.dedup.Done(call, nil)
+	defer cancel()
+	requestID := pending.RequestID
+	defer finishQueued(h.queue, requestID)
+	freqs := impedanceData.Frequencies
+	h.jobs.Start(requestID)
 
-	// Process EIS data
-	_ = h.processor(freqs, impData, h.config)
+	// Process EIS data, bounded by the job timeout like the fits of the pool
+	fitCtx, stop, timeout := h.workerPool.JobContext(ctx, cfg)
+	result, _ := h.processor(fitCtx, freqs, impData, impedanceData.Sigmas(), cfg).(goimpcore.Result)
+	timedOut := worker.TimedOut(fitCtx)
+	stop()
+	if timedOut {
+		log.Printf("⚠️ Request %s stopped at the %v job timeout, method %s", requestID, timeout, cfg.OptimMethod)
+		metrics.JobTimeouts.With(cfg.OptimMethod).Inc()
+		result.Status = goimpcore.TIMEOUT
+		result.SetPayload(goimpcore.PayloadError, fmt.Sprintf("fit exceeded the %v job timeout, parameters are the best found so far", timeout))
+	}
 
 	// Extract real and imaginary parts for webhook
-	realImp := make([]float64, len(impedanceData.Impedance))
-	imagImp := make([]float64, len(impedanceData.Impedance))
-	for i, imp := range impedanceData.Impedance {
-		realImp[i] = imp["real"]
-		imagImp[i] = imp["imag"]
+	realImp := make([]float64, len(impData))
+	imagImp := make([]float64, len(impData))
+	for i, imp := range impData {
+		realImp[i] = imp[0]
+		imagImp[i] = imp[1]
+	}
+
+	res := completeResult(pending, result.BestCircuit(cfg.Code), result, freqs, realImp, imagImp)
+	cancelled := errors.Is(ctx.Err(), context.Canceled)
+	if cancelled {
+		res = cancelledResult(res)
+	}
+	if h.results != nil {
+		h.results.PutResult(res)
+	}
+
+	state := resultState(result.Status == goimpcore.OK, cancelled)
+	h.jobs.Record(requestID, state)
+	h.jobs.Finish(requestID, state)
+	if cancelled {
+		if !h.config.Quiet {
+			log.Printf("🚫 Request %s cancelled", requestID)
+		}
+		return
 	}
 
-	// Create webhook item
-	// TODO: Integrate with proper EIS result processing
-	webhook := models.WebhookItem{
+	item := fitWebhook(requestID, result, result.BestCircuit(cfg.Code), freqs, realImp, imagImp, true)
+	item.Context = context.WithoutCancel(ctx) // sent after processAsync cancels ctx
+	h.workerPool.QueueWebhook(item)
+}
+
+// fitWebhook builds the webhook of the fit of code. Element impedances are
+// decomposed along the circuit topology when withElements is set, a failed
+// or timed out fit is sent without them.
+func fitWebhook(requestID string, result goimpcore.Result, code string, freqs, realImp, imagImp []float64, withElements bool) models.WebhookItem {
+	item := models.WebhookItem{
 		RequestID:   requestID,
-		ChiSquare:   0.0, // Will be extracted from result
+		ChiSquare:   result.Min,
 		RealImp:     realImp,
 		ImagImp:     imagImp,
 		Freqs:       freqs,
-		CircuitCode: h.config.Code,
+		Params:      result.Params,
+		Elements:    goimpcore.GetElements(strings.ToLower(code)),
+		CircuitCode: code,
+		Stats:       result.Stats,
+		Quality:     result.Quality,
+		Residuals:   result.Residuals,
+		Warnings:    result.Warnings,
+		Ranking:     result.Ranking(),
+		Sensitivity: result.Sensitivity(),
+		Failed:      result.Status != goimpcore.OK,
+		TimedOut:    result.Status == goimpcore.TIMEOUT,
+		Error:       failureMessage(result),
+
+		InductiveLoop: result.InductiveLoop,
+	}
+	if item.Failed || !withElements {
+		return item
 	}
 
-	h.workerPool.QueueWebhook(webhook)
+	elementImpedances, err := webhook.NewCalculator().DecomposeImpedances(code, freqs, result.Params)
+	if err != nil {
+		log.Printf("Warning: Element impedances of %s unavailable for %s: %v", code, requestID, err)
+	}
+	item.ElementImpedances = elementImpedances
+	return item
 }
 
-// setupCORS sets up CORS headers
-func (h *EISHandler) setupCORS(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+// isSyncRequest reports whether r asks for the result in the response, by
+// the /eis-data/sync route or ?sync=true
+func isSyncRequest(r *http.Request) bool {
+	if strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/sync") {
+		return true
+	}
+	sync, _ := strconv.ParseBool(r.URL.Query().Get("sync"))
+	return sync
+}
+
+// processSync fits the spectrum inline, outside the worker pool, and writes
+// the result with 200. When the fit does not finish within the sync timeout
+// the best parameters found so far are written with 504. No webhook is sent.
+// The response is shared with the requests that joined call.
+func (h *EISHandler) processSync(w http.ResponseWriter, r *http.Request, call *dedup.Call, pending models.FitResult, impedanceData models.ImpedanceData, impData [][2]float64, cfg *config.Config) {
+	timeout := h.syncTimeout()
+	// The server write timeout is shorter than a long fit
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + syncWriteMargin))
+
+	if !h.config.Quiet {
+		log.Printf("HTTP sync request received - ID: %s, Data points: %d, Timeout: %v", pending.RequestID, len(impedanceData.Frequencies), timeout)
+	}
+
+	// The joined requests are released even when the fit panics, without a
+	// response then
+	var response interface{}
+	defer func() { h.dedup.Done(call, response) }()
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	freqs := impedanceData.Frequencies
+	result, _ := h.processor(ctx, freqs, impData, impedanceData.Sigmas(), cfg).(goimpcore.Result)
+
+	realImp := make([]float64, len(impData))
+	imagImp := make([]float64, len(impData))
+	for i, imp := range impData {
+		realImp[i] = imp[0]
+		imagImp[i] = imp[1]
+	}
+	res := completeResult(pending, result.BestCircuit(cfg.Code), result, freqs, realImp, imagImp)
+
+	status := http.StatusOK
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+		res.Status = models.StatusTimeout
+		res.Error = fmt.Sprintf("fit did not finish within %v, parameters are the best found so far", timeout)
+	}
+	if h.results != nil {
+		h.results.PutResult(res)
+	}
+
+	if !h.config.Quiet {
+		log.Printf("HTTP sync request done - ID: %s, Status: %s, Chi-square: %.14e", res.RequestID, res.Status, res.ChiSquare)
+	}
+
+	response = syncResponse{status: status, result: res}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// syncTimeout returns the bound of synchronous fits
+func (h *EISHandler) syncTimeout() time.Duration {
+	if h.config.SyncTimeout <= 0 {
+		return DefaultSyncTimeout
+	}
+	return h.config.SyncTimeout
+}
+
+// syncResponse is the response of a synchronous fit, shared with the
+// identical requests that joined it
+type syncResponse struct {
+	status int
+	result models.FitResult
+}
+
+// joinRequest answers a request identical to the in-flight call with the
+// request ID of call. A synchronous request waits for the response of call,
+// an asynchronous one is accepted right away, the webhook of call being its
+// result.
+func (h *EISHandler) joinRequest(w http.ResponseWriter, r *http.Request, call *dedup.Call, sync bool) {
+	if !h.config.Quiet {
+		log.Printf("HTTP duplicate request joined - ID: %s", call.ID)
+	}
+
+	if !sync {
+		response := map[string]interface{}{
+			"success":    true,
+			"request_id": call.ID,
+			"message":    "Identical request already processing",
+		}
+		if h.results != nil {
+			response["result_url"] = "/results/" + call.ID
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(h.syncTimeout() + syncWriteMargin))
+	value, err := call.Wait(r.Context())
+	if err != nil {
+		h.writeError(w, fmt.Sprintf("Identical request %s did not finish: %v", call.ID, err), http.StatusGatewayTimeout)
+		return
+	}
+	response, ok := value.(syncResponse)
+	if !ok {
+		h.writeError(w, fmt.Sprintf("Identical request %s failed", call.ID), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(response.status)
+	json.NewEncoder(w).Encode(response.result)
+}
+
+// fitSettings describes the fit settings of cfg that change the result of a
+// request, and whether it is synchronous, for deduplication
+func fitSettings(cfg *config.Config, sync bool) string {
+	return fmt.Sprintf("%s|%v|%s|%s|%d|%v|%v|%v|%s|%s|%v", cfg.Code, []float64(cfg.InitValues), cfg.OptimMethod, cfg.Weighting,
+		cfg.MaxIterations, cfg.FreqMin, cfg.FreqMax, cfg.Robust, cfg.Space, cfg.Criterion, sync)
+}
+
+// requestConfig returns a copy of cfg with the fit settings overridden by the
+// request, when set
+func requestConfig(cfg *config.Config, data models.ImpedanceData) *config.Config {
+	reqCfg := *cfg
+	if data.FreqMin != 0 || data.FreqMax != 0 {
+		reqCfg.FreqMin = data.FreqMin
+		reqCfg.FreqMax = data.FreqMax
+	}
+	if data.Robust {
+		reqCfg.Robust = true
+	}
+	if data.Space != "" {
+		reqCfg.Space = data.Space
+	}
+	if len(data.CircuitCodes) > 0 {
+		reqCfg.Code = strings.Join(data.CircuitCodes, ",")
+	} else if data.CircuitCode != "" {
+		reqCfg.Code = data.CircuitCode
+	}
+	switch {
+	case len(data.InitValues) > 0:
+		reqCfg.InitValues = append(config.ArrayFlags(nil), data.InitValues...)
+	case reqCfg.Code != cfg.Code:
+		// The server initial values belong to the server circuit
+		reqCfg.InitValues = nil
+	}
+	if data.OptimMethod != "" {
+		reqCfg.OptimMethod = data.OptimMethod
+	}
+	if data.Weighting != "" {
+		reqCfg.Weighting = data.Weighting
+	}
+	if data.MaxIterations != 0 {
+		reqCfg.MaxIterations = data.MaxIterations
+	}
+	if data.TimeoutSeconds != 0 {
+		reqCfg.JobTimeout = time.Duration(data.TimeoutSeconds * float64(time.Second))
+	}
+	return &reqCfg
+}
+
+// validateFit checks that the fit window of cfg keeps at least one frequency,
+// that its fit space is known and its job timeout not negative
+func validateFit(freqs []float64, cfg *config.Config) error {
+	if cfg.FreqMin < 0 || cfg.FreqMax < 0 || (cfg.FreqMax > 0 && cfg.FreqMin > cfg.FreqMax) {
+		return fmt.Errorf("invalid frequency window freq_min %v freq_max %v", cfg.FreqMin, cfg.FreqMax)
+	}
+	if goimpcore.CountInWindow(freqs, cfg.FreqMin, cfg.FreqMax) == 0 {
+		return fmt.Errorf("%v: freq_min %v freq_max %v", goimpcore.ErrEmptyWindow, cfg.FreqMin, cfg.FreqMax)
+	}
+	if _, err := goimpcore.ParseSpace(cfg.Space); err != nil {
+		return err
+	}
+	if cfg.JobTimeout < 0 {
+		return fmt.Errorf("timeout_seconds must not be negative, got %v", cfg.JobTimeout.Seconds())
+	}
+	return validateFitSettings(cfg.Code, cfg.InitValues, cfg.OptimMethod, cfg.Weighting, cfg.MaxIterations)
+}
+
+// validateFitSettings checks the circuit codes, initial values, optimization
+// method, weighting and iteration count of a fit
+func validateFitSettings(code string, initValues []float64, method, weighting string, maxIterations int) error {
+	codes := goimpcore.ParseCircuitCodes(code)
+	if len(codes) == 0 {
+		return fmt.Errorf("no circuit code")
+	}
+	for _, c := range codes {
+		if err := goimpcore.ValidateCircuit(strings.ToLower(c)); err != nil {
+			return fmt.Errorf("invalid circuit code %q: %v", c, err)
+		}
+	}
+	if len(codes) == 1 && len(initValues) > 0 {
+		if n := len(goimpcore.GetElements(strings.ToLower(codes[0]))); len(initValues) != n {
+			return fmt.Errorf("init_values has %d values, circuit %s has %d parameters", len(initValues), codes[0], n)
+		}
+	}
+	if method != "" && !config.ValidOptimMethod(method) {
+		return fmt.Errorf("unknown optim_method %q, expected one of %s", method, strings.Join(config.OptimMethods, ", "))
+	}
+	if _, err := goimpcore.ParseWeighting(weighting); err != nil {
+		return err
+	}
+	if maxIterations < 0 {
+		return fmt.Errorf("max_iterations must not be negative, got %d", maxIterations)
+	}
+	return nil
+}
+
+// writeValidationErrors writes a 422 response listing every invalid field
+func writeValidationErrors(w http.ResponseWriter, errs []models.ValidationError) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":             "Invalid impedance data",
+		"validation_errors": errs,
+	})
 }
 
 // writeError writes an error response
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/dedup"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// waitResult waits for the fit requestID to leave the pending and running
+// statuses in results
+func waitResult(t *testing.T, results store.Store, requestID string) models.FitResult {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if res, ok := results.Result(requestID); ok && res.Status != models.StatusPending && res.Status != models.StatusRunning {
+			return res
+		}
+	}
+	t.Fatalf("fit %s did not finish", requestID)
+	return models.FitResult{}
+}
+
+// The timeout_seconds of an asynchronous fit stops it with the timeout status
+func TestProcessAsyncJobTimeout(t *testing.T) {
+	pool := worker.New(worker.Options{Workers: 1})
+	defer pool.Shutdown()
+	results := store.NewMemory(time.Minute, 100)
+
+	params := []float64{10, 1e-5, 0.9, 100}
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		<-ctx.Done()
+		return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: params}
+	}
+	h := NewEISHandler(testConfig(), pool, processor, results, Limits{}, nil, nil, nil)
+
+	data := testSpectrum(t)
+	data.TimeoutSeconds = 0.05
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data", bytes.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var accepted struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	res := waitResult(t, results, accepted.RequestID)
+	if res.Status != models.StatusTimeout {
+		t.Fatalf("status %s, want %s", res.Status, models.StatusTimeout)
+	}
+	if len(res.Parameters) != len(params) {
+		t.Errorf("%d parameters, want the %d found so far", len(res.Parameters), len(params))
+	}
+}
+
+// syncRequest is a synchronous fit request of data
+func syncRequest(t *testing.T, data models.ImpedanceData) *http.Request {
+	t.Helper()
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/eis-data/sync", bytes.NewReader(body))
+}
+
+// Identical concurrent requests share one fit and get the same response
+func TestDedupIdenticalSyncRequests(t *testing.T) {
+	var calls atomic.Int32
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		calls.Add(1)
+		time.Sleep(200 * time.Millisecond) // the other requests join meanwhile
+		return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{10, 1e-5, 0.9, 100}, Min: 1e-6}
+	}
+	h := NewEISHandler(testConfig(), nil, processor, nil, Limits{}, dedup.New(time.Minute), nil, nil)
+
+	data := testSpectrum(t)
+	const n = 5
+	recs := make([]*httptest.ResponseRecorder, n)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		req := syncRequest(t, data)
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			h.ServeHTTP(rec, req)
+		}(recs[i])
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("processor called %d times, want 1", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Errorf("response %d: status %d, body %s", i, rec.Code, rec.Body)
+		}
+		if rec.Body.String() != recs[0].Body.String() {
+			t.Errorf("response %d differs:\n%s\nwant\n%s", i, rec.Body, recs[0].Body)
+		}
+	}
+}
+
+// A panicking fit releases the requests that joined it
+func TestDedupPanicReleasesJoined(t *testing.T) {
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		close(started)
+		<-proceed
+		panic("fit failed")
+	}
+	h := NewEISHandler(testConfig(), nil, processor, nil, Limits{}, dedup.New(time.Minute), nil, nil)
+	data := testSpectrum(t)
+
+	first := syncRequest(t, data)
+	go func() {
+		defer func() { recover() }()
+		h.ServeHTTP(httptest.NewRecorder(), first)
+	}()
+	<-started
+
+	second := syncRequest(t, data)
+	joined := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, second)
+		joined <- rec
+	}()
+	time.Sleep(100 * time.Millisecond) // the second request joins the first
+	close(proceed)
+
+	select {
+	case rec := <-joined:
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("joined request: status %d, want %d", rec.Code, http.StatusInternalServerError)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("joined request still waiting for the panicked fit")
+	}
+}
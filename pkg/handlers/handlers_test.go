@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// testConfig is the quiet default configuration of the handler tests
+func testConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Quiet = true
+	return cfg
+}
+
+// testSpectrum is the noise-free spectrum of R(QR) from 1 Hz to 100 kHz
+func testSpectrum(t *testing.T) models.ImpedanceData {
+	t.Helper()
+	freqs, imp, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := models.ImpedanceData{Frequencies: freqs}
+	for _, z := range imp {
+		data.Impedance = append(data.Impedance, map[string]float64{"real": z[0], "imag": z[1]})
+	}
+	return data
+}
+
+// chdirTemp runs the test in a temporary directory, the handlers save their
+// reports in the working directory
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
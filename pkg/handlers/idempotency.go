@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+)
+
+// IdempotencyKeyHeader carries the idempotency key of a submission, a retry
+// with the same key is answered with the ID of the first one
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// requestKey returns the idempotency key of r, the header or else bodyKey,
+// empty when the request has none
+func requestKey(r *http.Request, bodyKey string) string {
+	if key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader)); key != "" {
+		return key
+	}
+	return strings.TrimSpace(bodyKey)
+}
+
+// batchKey returns the idempotency key of batch, its ID and a hash of its
+// content, so that a batch ID reused for other spectra is not taken for a
+// retry
+func batchKey(batch models.ImpedanceBatch) string {
+	data, _ := json.Marshal(batch) // decoded from JSON, it encodes back
+	sum := sha256.Sum256(data)
+	return "batch/" + batch.BatchID + "/" + hex.EncodeToString(sum[:])
+}
+
+// releaseKey removes the idempotency key of a submission not accepted after
+// all, empty keys are ignored
+func releaseKey(results store.Store, key string) {
+	if key != "" {
+		results.ReleaseKey(key)
+	}
+}
+
+// writeRetriedFit answers a retried fit with the request ID of the first one,
+// 200 with its result once complete, 202 before
+func (h *EISHandler) writeRetriedFit(w http.ResponseWriter, requestID string) {
+	response := map[string]interface{}{
+		"success":    true,
+		"request_id": requestID,
+		"message":    "Request already accepted",
+		"result_url": "/results/" + requestID,
+	}
+	if h.jobs != nil {
+		response["job_url"] = "/jobs/" + requestID
+	}
+	status := http.StatusAccepted
+	if res, ok := h.results.Result(requestID); ok && res.Status != models.StatusPending {
+		response["result"] = res
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeRetriedBatch answers a retried batch like writeRetriedFit
+func (h *BatchHandler) writeRetriedBatch(w http.ResponseWriter, batchID string) {
+	response := map[string]interface{}{
+		"success":    true,
+		"batch_id":   batchID,
+		"message":    "Batch already accepted",
+		"result_url": "/batches/" + batchID,
+	}
+	if h.jobs != nil {
+		response["job_url"] = "/jobs/" + batchID
+	}
+	status := http.StatusAccepted
+	if batch, ok := h.results.Batch(batchID); ok && batch.Status != models.StatusPending {
+		response["result"] = batch
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
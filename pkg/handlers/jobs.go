@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
+)
+
+// JobsHandler serves the status of the asynchronous fits and batches, GET
+// /jobs/{id}, and cancels them, DELETE /jobs/{id}. IDs are the request ID of
+// a fit and the batch ID of a batch. Synchronous and streamed fits end with
+// their request and are not tracked.
+type JobsHandler struct {
+	jobs *jobs.Registry
+}
+
+// NewJobsHandler creates a new handler of the jobs of registry
+func NewJobsHandler(registry *jobs.Registry) *JobsHandler {
+	return &JobsHandler{
+		jobs: registry,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		h.writeError(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		job, ok := h.jobs.Get(id)
+		if !ok {
+			h.writeError(w, jobs.ErrNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+
+	case "DELETE":
+		job, err := h.jobs.Cancel(id)
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			h.writeError(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, jobs.ErrFinished):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": err.Error(),
+				"job":   job,
+			})
+		default:
+			json.NewEncoder(w).Encode(job)
+		}
+
+	default:
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// resultState is the jobs state of a processed spectrum
+func resultState(success, cancelled bool) string {
+	switch {
+	case cancelled:
+		return jobs.StateCancelled
+	case success:
+		return jobs.StateDone
+	}
+	return jobs.StateFailed
+}
+
+// writeError writes an error response
+func (h *JobsHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
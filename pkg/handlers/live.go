@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+)
+
+// DefaultLiveMaxFits is how many fits one /ws connection may run at once when
+// the handler sets no limit
+const DefaultLiveMaxFits = 4
+
+// Timing of a /ws connection, pings detect clients gone without closing it
+const (
+	liveWriteWait  = 10 * time.Second
+	livePongWait   = time.Minute
+	livePingPeriod = livePongWait * 9 / 10
+)
+
+// Types of the /ws messages
+const (
+	liveFit      = "fit"      // client: fit Data, an ImpedanceData
+	liveCancel   = "cancel"   // client: cancel the fit ID
+	liveProgress = "progress" // server: best result so far of the fit ID
+	liveResult   = "result"   // server: final result of the fit ID
+	liveError    = "error"    // server: the message, of the fit ID if any, was rejected
+)
+
+// liveMessage is a /ws message, in either direction
+type liveMessage struct {
+	Type             string                   `json:"type"`
+	ID               string                   `json:"id,omitempty"`
+	Data             json.RawMessage          `json:"data,omitempty"`
+	Iteration        int                      `json:"iteration,omitempty"`
+	Result           *models.FitResult        `json:"result,omitempty"`
+	Error            string                   `json:"error,omitempty"`
+	ValidationErrors []models.ValidationError `json:"validation_errors,omitempty"`
+}
+
+// LiveHandler serves GET /ws, a WebSocket over which an interactive client
+// fits spectra. It sends {"type":"fit","id":...,"data":{...}} with the
+// ImpedanceData of /eis-data, init_values included, and receives a progress
+// message after every solver iteration, then the result. {"type":"cancel",
+// "id":...} stops a fit, whose result then holds the best parameters so far.
+// IDs are chosen by the client, generated when missing, and may be reused
+// once the fit ended. The fits of a connection are cancelled when it closes.
+type LiveHandler struct {
+	config   *config.Config
+	solve    IterativeSolveFunc
+	results  store.Store
+	limits   Limits
+	maxFits  int
+	upgrader websocket.Upgrader
+}
+
+// NewLiveHandler creates a new live fitting handler running at most maxFits
+// fits per connection, DefaultLiveMaxFits when 0. results may be nil when
+// completed fits are not kept for retrieval.
+func NewLiveHandler(cfg *config.Config, solve IterativeSolveFunc, results store.Store, limits Limits, maxFits int) *LiveHandler {
+	if maxFits <= 0 {
+		maxFits = DefaultLiveMaxFits
+	}
+	return &LiveHandler{
+		config:  cfg,
+		solve:   solve,
+		results: results,
+		limits:  limits,
+		maxFits: maxFits,
+		upgrader: websocket.Upgrader{
+			// The CORS middleware rejected the origins not allowed already
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// liveConn is one /ws connection, mu serializes its writes
+type liveConn struct {
+	h    *LiveHandler
+	conn *websocket.Conn
+	mu   sync.Mutex
+
+	fitsMu sync.Mutex
+	fits   map[string]context.CancelFunc // running fits by ID
+	wg     sync.WaitGroup
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *LiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade answered with the error
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	l := &liveConn{h: h, conn: conn, fits: make(map[string]context.CancelFunc)}
+	defer func() {
+		cancel()
+		l.wg.Wait()
+	}()
+
+	if h.limits.MaxLineBytes > 0 {
+		conn.SetReadLimit(h.limits.MaxLineBytes)
+	}
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+	go l.ping(ctx)
+
+	if !h.config.Quiet {
+		log.Printf("WebSocket connection opened - ID: %s", utils.RequestIDOrNew(ctx))
+	}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !h.config.Quiet {
+				log.Printf("WebSocket connection closed - ID: %s: %v", utils.RequestIDOrNew(ctx), err)
+			}
+			return
+		}
+		var msg liveMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			l.send(liveMessage{Type: liveError, Error: "Invalid JSON format"})
+			continue
+		}
+		switch msg.Type {
+		case liveFit:
+			l.startFit(ctx, msg)
+		case liveCancel:
+			l.cancelFit(msg.ID)
+		default:
+			l.send(liveMessage{Type: liveError, ID: msg.ID, Error: fmt.Sprintf("Unknown message type %q, expected fit or cancel", msg.Type)})
+		}
+	}
+}
+
+// ping pings the client until ctx is done
+func (l *liveConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(livePingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait))
+		}
+	}
+}
+
+// startFit validates the fit msg and runs it in the background
+func (l *liveConn) startFit(ctx context.Context, msg liveMessage) {
+	id := msg.ID
+	if id == "" {
+		id = utils.GenerateID()
+	}
+	reject := func(message string, errs []models.ValidationError) {
+		l.send(liveMessage{Type: liveError, ID: id, Error: message, ValidationErrors: errs})
+	}
+
+	var impedanceData models.ImpedanceData
+	if err := json.Unmarshal(msg.Data, &impedanceData); err != nil {
+		reject("Invalid JSON format of data", nil)
+		return
+	}
+	if err := l.h.limits.checkPoints(len(impedanceData.Frequencies)); err != nil {
+		reject(err.Error(), nil)
+		return
+	}
+	if errs := models.ValidateImpedanceData(impedanceData); len(errs) > 0 {
+		reject("Invalid impedance data", errs)
+		return
+	}
+	impData, err := impedanceData.Points()
+	if err != nil {
+		reject(err.Error(), nil)
+		return
+	}
+	cfg := requestConfig(l.h.config, impedanceData)
+	if err := validateFit(impedanceData.Frequencies, cfg); err != nil {
+		reject(err.Error(), nil)
+		return
+	}
+	if len(goimpcore.ParseCircuitCodes(cfg.Code)) > 1 || cfg.OptimMethod == "all" {
+		reject("Live fits fit one circuit with one optimization method", nil)
+		return
+	}
+
+	timeout := l.h.config.SyncTimeout
+	if timeout <= 0 {
+		timeout = DefaultSyncTimeout
+	}
+
+	l.fitsMu.Lock()
+	if _, running := l.fits[id]; running {
+		l.fitsMu.Unlock()
+		reject(fmt.Sprintf("Fit %s is already running", id), nil)
+		return
+	}
+	if len(l.fits) >= l.h.maxFits {
+		l.fitsMu.Unlock()
+		reject(fmt.Sprintf("At most %d fits run at once per connection", l.h.maxFits), nil)
+		return
+	}
+	fitCtx, cancel := context.WithTimeout(ctx, timeout)
+	l.fits[id] = cancel
+	l.fitsMu.Unlock()
+
+	l.wg.Add(1)
+	go l.runFit(fitCtx, id, impedanceData, impData, cfg, timeout)
+}
+
+// runFit fits the spectrum of the fit id and sends its progress and result.
+// The result is stored under a request ID of its own, the IDs of the client
+// are only unique within the connection.
+func (l *liveConn) runFit(ctx context.Context, id string, impedanceData models.ImpedanceData, impData [][2]float64, cfg *config.Config, timeout time.Duration) {
+	defer l.wg.Done()
+	defer func() {
+		l.fitsMu.Lock()
+		l.fits[id]()
+		delete(l.fits, id)
+		l.fitsMu.Unlock()
+	}()
+
+	pending := pendingResult(utils.GenerateID())
+	pending.MeasuredAt = measuredAt(impedanceData)
+
+	freqs := impedanceData.Frequencies
+	realImp := make([]float64, len(impData))
+	imagImp := make([]float64, len(impData))
+	for i, imp := range impData {
+		realImp[i] = imp[0]
+		imagImp[i] = imp[1]
+	}
+
+	// The channel is drained even after the client left, the fit stops with
+	// the cancelled context
+	for progress := range l.h.solve(ctx, freqs, impData, impedanceData.Sigmas(), cfg) {
+		res := completeResult(pending, progress.Result.BestCircuit(cfg.Code), progress.Result, freqs, realImp, imagImp)
+		msgType := liveProgress
+		if !progress.IsComplete {
+			res.Status = models.StatusRunning
+			res.CompletedAt = nil
+		} else {
+			msgType = liveResult
+			switch {
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				res.Status = models.StatusTimeout
+				res.Error = fmt.Sprintf("fit did not finish within %v, parameters are the best found so far", timeout)
+			case ctx.Err() != nil:
+				res = cancelledResult(res)
+			}
+			if l.h.results != nil {
+				l.h.results.PutResult(res)
+			}
+			if !l.h.config.Quiet {
+				log.Printf("Live fit done - ID: %s, Request ID: %s, Status: %s, Iterations: %d", id, res.RequestID, res.Status, progress.Iteration)
+			}
+		}
+		l.send(liveMessage{Type: msgType, ID: id, Iteration: progress.Iteration, Result: &res})
+	}
+}
+
+// cancelFit cancels the running fit id, which then sends its result
+func (l *liveConn) cancelFit(id string) {
+	l.fitsMu.Lock()
+	cancel, ok := l.fits[id]
+	l.fitsMu.Unlock()
+	if !ok {
+		l.send(liveMessage{Type: liveError, ID: id, Error: fmt.Sprintf("No running fit %s", id)})
+		return
+	}
+	cancel()
+}
+
+// send writes msg, errors of a connection gone are left to the read loop
+func (l *liveConn) send(msg liveMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+	if err := l.conn.WriteJSON(msg); err != nil && !l.h.config.Quiet {
+		log.Printf("WebSocket write of %s %s failed: %v", msg.Type, msg.ID, err)
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
+	"github.com/kacperjurak/goimpcore/pkg/middleware"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// streamIdleTimeout bounds the wait for the next line of a batch stream and
+// the write of each status line
+const streamIdleTimeout = time.Minute
+
+// Statuses of the lines of a batch stream
+const (
+	lineAccepted = "accepted"
+	lineRejected = "rejected"
+)
+
+// lineStatus answers one line of a batch stream, Line counts from 1
+type lineStatus struct {
+	Line             int                      `json:"line"`
+	Iteration        *int                     `json:"iteration,omitempty"`
+	Status           string                   `json:"status"`
+	Error            string                   `json:"error,omitempty"`
+	ValidationErrors []models.ValidationError `json:"validation_errors,omitempty"`
+}
+
+// streamSummary is the last line of the response to a batch stream
+type streamSummary struct {
+	BatchID   string `json:"batch_id"`
+	Accepted  int    `json:"accepted"`
+	Rejected  int    `json:"rejected"`
+	Error     string `json:"error,omitempty"` // why the stream ended early
+	ResultURL string `json:"result_url,omitempty"`
+	JobURL    string `json:"job_url,omitempty"`
+}
+
+// ServeNDJSON accepts a batch streamed as NDJSON, one BatchItem per line, for
+// batches too large to send as one document. Each spectrum is submitted to
+// the worker pool as its line arrives and answered by a status line, a
+// malformed line is rejected without ending the stream. The last line sums
+// up the stream. The batch ID is the batch_id query parameter, generated when
+// missing, and include_element_impedances=false omits the element impedances
+// from the webhooks. The batch completes, with its batch-complete webhook,
+// once the stream closed and its spectra were processed. Streamed batches are
+// fitted concurrently, never chained, and are not persisted in the job queue.
+func (h *BatchHandler) ServeNDJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batch := models.ImpedanceBatch{BatchID: r.URL.Query().Get("batch_id"), Timestamp: time.Now()}
+	if batch.BatchID == "" {
+		batch.BatchID = utils.GenerateID()
+	}
+	if value := r.URL.Query().Get("include_element_impedances"); value != "" {
+		include, err := strconv.ParseBool(value)
+		if err != nil {
+			h.writeError(w, fmt.Sprintf("Invalid include_element_impedances %q", value), http.StatusBadRequest)
+			return
+		}
+		batch.IncludeElementImpedances = &include
+	}
+
+	// The batch outlives the request, DELETE /jobs/{batch_id} cancels it
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	if h.jobs != nil {
+		if _, err := h.jobs.Add(batch.BatchID, jobs.KindBatch, 0, cancel); err != nil {
+			cancel()
+			h.writeError(w, fmt.Sprintf("Batch %s: %v", batch.BatchID, err), http.StatusConflict)
+			return
+		}
+	}
+	requestID := utils.RequestIDOrNew(ctx)
+
+	log.Printf("🔄 Batch stream started - ID: %s", batch.BatchID)
+
+	pending := models.BatchResult{
+		BatchID:     batch.BatchID,
+		Status:      models.StatusPending,
+		SubmittedAt: batch.Timestamp,
+	}
+	if h.results != nil {
+		h.results.PutBatch(pending)
+	}
+
+	rc := http.NewResponseController(w)
+	// Status lines are written while the body is still read. Reading before
+	// the header is written answers clients waiting for 100 Continue, whose
+	// body is closed otherwise.
+	rc.EnableFullDuplex()
+	rc.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+	reader := bufio.NewReader(r.Body)
+	reader.Peek(1)
+	w.Header().Set("Content-Type", middleware.NDJSONContentType)
+	w.Header().Set(utils.RequestIDHeader, requestID)
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	h.jobs.Start(batch.BatchID)
+	batchStartTime := time.Now()
+	spectrumTimings := newBatchTimings(batch)
+
+	// Results are collected while the stream is read, the number of spectra
+	// to wait for is known once it closed
+	results := make(chan models.WorkResult, h.getConcurrency())
+	submitted := make(chan int, 1)
+	collected := make(chan []models.WorkResult, 1)
+	batchID, withElements := batch.BatchID, batch.ElementImpedancesIncluded() // batch grows meanwhile
+	go func() {
+		collected <- h.collectStreamed(batchID, results, submitted, cancel, spectrumTimings, withElements)
+	}()
+
+	encoder := json.NewEncoder(w)
+	writeLine := func(v interface{}) {
+		rc.SetWriteDeadline(time.Now().Add(streamIdleTimeout))
+		if err := encoder.Encode(v); err == nil {
+			rc.Flush()
+		}
+	}
+
+	summary := streamSummary{BatchID: batch.BatchID}
+	seen := make(map[int]bool)
+	for n := 1; ; n++ {
+		rc.SetReadDeadline(time.Now().Add(streamIdleTimeout))
+		line, tooLong, err := readLine(reader, h.limits.MaxLineBytes)
+		if tooLong || len(bytes.TrimSpace(line)) > 0 {
+			status := lineStatus{Line: n, Status: lineAccepted}
+			item, validationErrs, itemErr := h.streamedItem(line, tooLong, seen, len(batch.Spectra))
+			if item != nil {
+				status.Iteration = &item.Iteration
+			}
+			if itemErr == nil && ctx.Err() != nil {
+				itemErr = errors.New("batch cancelled")
+			}
+			if itemErr == nil {
+				job := h.createWorkItem(*item, batch.BatchID, requestID)
+				job.Context = ctx
+				job.Results = results
+				h.jobs.Grow(batch.BatchID, 1)
+				if itemErr = h.workerPool.SubmitJob(job); itemErr != nil {
+					h.jobs.Grow(batch.BatchID, -1)
+				}
+			}
+			if itemErr != nil {
+				status.Status = lineRejected
+				status.Error = itemErr.Error()
+				status.ValidationErrors = validationErrs
+				summary.Rejected++
+			} else {
+				// Only the iteration is kept, the data went to the worker pool
+				seen[item.Iteration] = true
+				batch.Spectra = append(batch.Spectra, models.BatchItem{Iteration: item.Iteration})
+			}
+			writeLine(status)
+		}
+
+		if err != nil {
+			switch {
+			case err == io.EOF:
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				summary.Error = fmt.Sprintf("no line received within %v", streamIdleTimeout)
+			default:
+				summary.Error = "reading stream: " + err.Error()
+			}
+			break
+		}
+	}
+
+	submitted <- len(batch.Spectra)
+	summary.Accepted = len(batch.Spectra)
+	if h.results != nil {
+		summary.ResultURL = "/batches/" + batch.BatchID
+	}
+	if h.jobs != nil {
+		summary.JobURL = "/jobs/" + batch.BatchID
+	}
+	writeLine(summary)
+
+	log.Printf("📥 Batch stream closed - ID: %s, Accepted: %d, Rejected: %d", batch.BatchID, summary.Accepted, summary.Rejected)
+
+	go func() {
+		defer cancel()
+		results := <-collected
+		if len(batch.Spectra) == 0 {
+			h.finishEmptyStream(pending)
+			return
+		}
+		for _, item := range batch.Spectra {
+			spectrumTimings.add(item.Iteration)
+		}
+		pending.Spectra = len(batch.Spectra)
+		h.finishBatch(ctx, batch, pending, results, spectrumTimings, batchStartTime)
+	}()
+}
+
+// streamedItem decodes and validates one line of a batch stream, tooLong
+// when it exceeded the line size limit. seen holds the iterations accepted
+// so far, accepted their number. item is nil when the line is not a BatchItem.
+func (h *BatchHandler) streamedItem(line []byte, tooLong bool, seen map[int]bool, accepted int) (*models.BatchItem, []models.ValidationError, error) {
+	if tooLong {
+		return nil, nil, fmt.Errorf("line exceeds %d bytes", h.limits.MaxLineBytes)
+	}
+	var item models.BatchItem
+	if err := json.Unmarshal(line, &item); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if seen[item.Iteration] {
+		return &item, nil, fmt.Errorf("duplicates iteration %d", item.Iteration)
+	}
+	if h.limits.MaxBatchSpectra > 0 && accepted >= h.limits.MaxBatchSpectra {
+		return &item, nil, fmt.Errorf("batch already has %d spectra, at most %d are accepted", accepted, h.limits.MaxBatchSpectra)
+	}
+	if err := h.limits.checkPoints(len(item.ImpedanceData.Frequencies)); err != nil {
+		return &item, nil, err
+	}
+	if errs := models.ValidateImpedanceData(item.ImpedanceData); len(errs) > 0 {
+		return &item, errs, errors.New("Invalid impedance data")
+	}
+	if err := validateFit(item.ImpedanceData.Frequencies, requestConfig(h.config, item.ImpedanceData)); err != nil {
+		return &item, nil, err
+	}
+	if _, err := item.ImpedanceData.Points(); err != nil {
+		return &item, nil, err
+	}
+	return &item, nil, nil
+}
+
+// collectStreamed processes the results of a streamed batch as they arrive
+// until the number of spectra submitted, sent on submitted once the stream
+// closed, is reached or the batch timed out. A timed out batch is cancelled
+// with cancel and its remaining results are drained in the background, the
+// workers delivering them would block on results otherwise.
+func (h *BatchHandler) collectStreamed(batchID string, results <-chan models.WorkResult, submitted <-chan int, cancel context.CancelFunc, spectrumTimings *batchTimings, withElements bool) []models.WorkResult {
+	var collected []models.WorkResult
+	var deadline <-chan time.Time
+	expected := -1
+	for expected < 0 || len(collected) < expected {
+		select {
+		case result := <-results:
+			spectrumTimings.add(result.Iteration)
+			h.processResult(result, spectrumTimings, withElements)
+			collected = append(collected, result)
+		case expected = <-submitted:
+			timer := time.NewTimer(batchTimeout(expected))
+			defer timer.Stop()
+			deadline = timer.C
+		case <-deadline:
+			log.Printf("⚠️ Batch %s timed out after %v with %d of %d results",
+				batchID, batchTimeout(expected), len(collected), expected)
+			cancel()
+			go drainResults(results, expected-len(collected))
+			return collected
+		}
+	}
+	return collected
+}
+
+// drainResults discards the next n results
+func drainResults(results <-chan models.WorkResult, n int) {
+	for ; n > 0; n-- {
+		<-results
+	}
+}
+
+// finishEmptyStream records a batch stream that closed without an accepted
+// spectrum as failed
+func (h *BatchHandler) finishEmptyStream(pending models.BatchResult) {
+	if h.results != nil {
+		completed := time.Now()
+		pending.Status = models.StatusFailed
+		pending.CompletedAt = &completed
+		h.results.PutBatch(pending)
+	}
+	h.jobs.Finish(pending.BatchID, jobs.StateFailed)
+	log.Printf("⚠️ Batch stream %s closed without a valid spectrum", pending.BatchID)
+}
+
+// readLine reads the next line of r, without its size limit when max <= 0.
+// A longer line is skipped up to its end and reported tooLong.
+func readLine(r *bufio.Reader, max int64) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if max > 0 && int64(len(line)+len(chunk)) > max {
+			tooLong = true
+			line = nil
+		} else if !tooLong {
+			line = append(line, chunk...)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, tooLong, err
+		}
+	}
+}
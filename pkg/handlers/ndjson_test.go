@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// A stream whose batch times out with jobs still running and queued must not
+// leave the workers blocked on its results
+func TestServeNDJSONTimeoutReleasesWorkers(t *testing.T) {
+	chdirTemp(t)
+	base, perSpectrum := batchTimeoutBase, batchTimeoutPerSpectrum
+	batchTimeoutBase, batchTimeoutPerSpectrum = 100*time.Millisecond, 0
+	defer func() { batchTimeoutBase, batchTimeoutPerSpectrum = base, perSpectrum }()
+
+	// Fits run until cancelled, or released at the end of the test
+	release := make(chan struct{})
+	pool := worker.New(worker.Options{
+		Workers: 1,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			select {
+			case <-ctx.Done():
+			case <-release:
+			}
+			return goimpcore.Result{Status: goimpcore.OK, Params: []float64{}}
+		},
+	})
+
+	cfg := testConfig()
+	cfg.Threads = 1 // the smallest result buffer
+	h := NewBatchHandler(cfg, pool, nil, nil, nil, Limits{}, nil, nil)
+
+	var body strings.Builder
+	for i := 1; i <= 3; i++ {
+		line, err := json.Marshal(models.BatchItem{ImpedanceData: testSpectrum(t), Iteration: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	rec := httptest.NewRecorder()
+	h.ServeNDJSON(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch/stream", strings.NewReader(body.String())))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// Past the batch deadline, the pool still fits new jobs
+	time.Sleep(300 * time.Millisecond)
+	close(release)
+	freqs := testSpectrum(t).Frequencies
+	probe := make(chan models.WorkResult, 1)
+	if err := pool.SubmitJob(models.WorkItem{RequestID: "probe", Freqs: freqs, ImpData: make([][2]float64, len(freqs)), Config: cfg, Results: probe}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-probe:
+		if result.RequestID != "probe" {
+			t.Fatalf("got result %s, want probe", result.RequestID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the worker is blocked on the results of the timed out stream")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pool.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocked")
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// polarSpectrum is the spectrum of a CPE with n = 0.9, -81° at every
+// frequency, as magnitude and phase in degrees without a unit
+func polarSpectrum(t *testing.T) (models.ImpedanceData, [][2]float64) {
+	t.Helper()
+	freqs, points, err := goimpcore.Simulate("Q", []float64{1e-5, 0.9}, goimpcore.SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := models.ImpedanceData{Frequencies: freqs}
+	for _, z := range points {
+		data.Magnitude = append(data.Magnitude, math.Hypot(z[0], z[1]))
+		data.Phase = append(data.Phase, math.Atan2(z[1], z[0])*180/math.Pi)
+	}
+	return data, points
+}
+
+// checkPoints fails unless got equals want to 1e-12 relative
+func checkPoints(t *testing.T, got, want [][2]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d points fitted, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.Hypot(got[i][0]-want[i][0], got[i][1]-want[i][1]) > 1e-12*math.Hypot(want[i][0], want[i][1]) {
+			t.Fatalf("point %d fitted as %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+// Magnitude and phase in degrees reach the solver as the impedance points,
+// for single fits and for batches
+func TestPolarDataFitted(t *testing.T) {
+	data, want := polarSpectrum(t)
+	var (
+		mu     sync.Mutex
+		fitted [][2]float64
+	)
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		mu.Lock()
+		fitted = append([][2]float64(nil), impData...)
+		mu.Unlock()
+		return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{1e-5, 0.9}}
+	}
+	cfg := testConfig()
+	cfg.Code = "Q"
+
+	t.Run("single", func(t *testing.T) {
+		h := NewEISHandler(cfg, nil, processor, nil, Limits{}, nil, nil, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, syncRequest(t, data))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		checkPoints(t, fitted, want)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		pool := worker.New(worker.Options{Workers: 1, Processor: processor})
+		defer pool.Shutdown()
+		h := NewBatchHandler(cfg, pool, nil, nil, nil, Limits{}, nil, nil)
+		batch := models.ImpedanceBatch{BatchID: "polar", Spectra: []models.BatchItem{{ImpedanceData: data, Iteration: 1}}}
+		results := h.processConcurrent(context.Background(), batch, newBatchTimings(batch))
+		if len(results) != 1 {
+			t.Fatalf("%d results, want 1", len(results))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		checkPoints(t, fitted, want)
+	})
+}
+
+// A spectrum with neither impedance nor magnitude and phase fails validation
+func TestNoImpedanceRejected(t *testing.T) {
+	h := NewEISHandler(testConfig(), nil, nil, nil, Limits{}, nil, nil, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, syncRequest(t, models.ImpedanceData{Frequencies: testSpectrum(t).Frequencies}))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/queue"
+)
+
+// acceptJob stores the request payload of the job id of kind in q, nil when
+// q is nil
+func acceptJob(q *queue.Queue, id, kind, requestID string, payload interface{}) error {
+	if q == nil {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return q.Accept(queue.Job{ID: id, Kind: kind, RequestID: requestID, Payload: data, AcceptedAt: time.Now()})
+}
+
+// finishQueued removes the processed job id from q
+func finishQueued(q *queue.Queue, id string) {
+	if err := q.Finish(id); err != nil {
+		log.Printf("⚠️ Job %s processed but still queued, it runs again after a restart: %v", id, err)
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/plot"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+)
+
+// ResultsHandler serves stored results, /results/{request_id} a single fit,
+// /batches/{batch_id} a whole batch, /batch/{batch_id}/timeseries the
+// parameter time series of a completed batch and /batch/{batch_id}/evolution
+// its parameters over the acquisition time. Pending entries are returned with
+// 202.
+type ResultsHandler struct {
+	store store.Store
+}
+
+// NewResultsHandler creates a new stored results handler
+func NewResultsHandler(s store.Store) *ResultsHandler {
+	return &ResultsHandler{
+		store: s,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *ResultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/evolution") {
+		h.serveEvolution(w, r.URL.Path)
+		return
+	}
+
+	var (
+		value  interface{}
+		status string
+		found  bool
+	)
+	switch {
+	case strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/timeseries"):
+		value, status, found = h.timeSeries(r.URL.Path)
+	case strings.HasPrefix(r.URL.Path, "/results/"):
+		var res models.FitResult
+		res, found = h.store.Result(strings.Trim(strings.TrimPrefix(r.URL.Path, "/results/"), "/"))
+		value, status = res, res.Status
+	case strings.HasPrefix(r.URL.Path, "/batches/"):
+		var batch models.BatchResult
+		batch, found = h.store.Batch(strings.Trim(strings.TrimPrefix(r.URL.Path, "/batches/"), "/"))
+		value, status = batch, batch.Status
+	}
+	if !found {
+		h.writeError(w, "Unknown or expired ID", http.StatusNotFound)
+		return
+	}
+
+	if status == models.StatusPending {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(value)
+}
+
+// timeSeries looks up the time series of /batch/{batch_id}/timeseries or
+// /batches/{batch_id}/timeseries, the pending batch while it is processed
+func (h *ResultsHandler) timeSeries(path string) (interface{}, string, bool) {
+	id := strings.TrimSuffix(strings.TrimRight(path, "/"), "/timeseries")
+	id = strings.TrimPrefix(strings.TrimPrefix(id, "/batches/"), "/batch/")
+	if series, ok := h.store.TimeSeries(id); ok {
+		return series, models.StatusCompleted, true
+	}
+	if batch, ok := h.store.Batch(id); ok && batch.Status == models.StatusPending {
+		return batch, batch.Status, true
+	}
+	return nil, "", false
+}
+
+// serveEvolution writes the parameter evolution of /batch/{batch_id}/evolution
+// or /batches/{batch_id}/evolution, the pending batch while it is processed
+func (h *ResultsHandler) serveEvolution(w http.ResponseWriter, path string) {
+	id := strings.TrimSuffix(strings.TrimRight(path, "/"), "/evolution")
+	id = strings.TrimPrefix(strings.TrimPrefix(id, "/batches/"), "/batch/")
+	batch, ok := h.store.Batch(id)
+	if !ok {
+		h.writeError(w, "Unknown or expired ID", http.StatusNotFound)
+		return
+	}
+	if batch.Status == models.StatusPending {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(batch)
+		return
+	}
+
+	evolution, err := EvolutionFromBatch(batch)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	json.NewEncoder(w).Encode(evolution)
+}
+
+// writeError writes an error response
+func (h *ResultsHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// StoredBodeLookup returns a BodeLookup plotting the completed fits of s
+func StoredBodeLookup(s store.Store) BodeLookup {
+	return func(requestID string) (plot.FittedBodePlot, bool) {
+		res, ok := s.Result(requestID)
+		if !ok || res.Status != models.StatusCompleted {
+			return plot.FittedBodePlot{}, false
+		}
+
+		code := strings.ToLower(res.CircuitCode)
+		impData := make([][2]float64, len(res.Frequencies))
+		for i := range impData {
+			impData[i] = [2]float64{res.RealImpedance[i], res.ImaginaryImpedance[i]}
+		}
+		var fitted [][2]float64
+		if len(res.Parameters) == len(goimpcore.GetElements(code)) {
+			fitted = goimpcore.CircuitImpedance(code, res.Frequencies, res.Parameters)
+		}
+
+		bode := plot.FittedBodeData(res.Frequencies, impData, fitted)
+		bode.Code = res.CircuitCode
+		bode.Params = res.Parameters
+		return bode, true
+	}
+}
+
+// pendingResult is the stored entry of an accepted request
+func pendingResult(requestID string) models.FitResult {
+	return models.FitResult{
+		RequestID:   requestID,
+		Status:      models.StatusPending,
+		SubmittedAt: time.Now(),
+	}
+}
+
+// measuredAt returns the acquisition time of data, nil when it has no
+// timestamp. Timestamps are validated when the request is accepted.
+func measuredAt(data models.ImpedanceData) *time.Time {
+	t, ok, _ := data.MeasuredAt()
+	if !ok {
+		return nil
+	}
+	return &t
+}
+
+// completeResult fills in the outcome of the fit of code into pending. Values
+// that cannot be encoded as JSON are replaced by 0. A fit stopped at its job
+// timeout keeps the parameters found so far with StatusTimeout.
+func completeResult(pending models.FitResult, code string, result goimpcore.Result, freqs, realImp, imagImp []float64) models.FitResult {
+	res := pending
+	completed := time.Now()
+	res.CompletedAt = &completed
+	res.CircuitCode = code
+	res.Frequencies = freqs
+	res.RealImpedance = realImp
+	res.ImaginaryImpedance = imagImp
+	res.CircuitRanking = sanitizeRanking(result.Ranking())
+	res.Warnings = result.Warnings
+
+	timedOut := result.Status == goimpcore.TIMEOUT
+	if result.Status != goimpcore.OK && (!timedOut || len(result.Params) == 0) {
+		res.Status = models.StatusFailed
+		if timedOut {
+			res.Status = models.StatusTimeout
+		}
+		res.Error = "fit failed with status " + result.Status
+		if result.Status == "" {
+			res.Error = "fit failed"
+		}
+		if msg := failureMessage(result); msg != "" {
+			res.Error = msg
+		}
+		return res
+	}
+
+	res.Status = models.StatusCompleted
+	if timedOut {
+		res.Status = models.StatusTimeout
+		res.Error = failureMessage(result)
+	}
+	res.ChiSquare = sanitizeFloat(result.Min)
+	res.Parameters = sanitizeSlice(append([]float64(nil), result.Params...))
+	res.ParameterInfo = goimpcore.ParameterInfo(code)
+	if labels := goimpcore.ParamLabels(code); len(labels) == len(res.Parameters) {
+		res.NamedParameters = make(map[string]float64, len(labels))
+		for i, label := range labels {
+			res.NamedParameters[label] = res.Parameters[i]
+		}
+	}
+	if result.Stats.N > 0 {
+		stats := result.Stats
+		stats.WeightedSSR = sanitizeFloat(stats.WeightedSSR)
+		stats.ReducedChiSq = sanitizeFloat(stats.ReducedChiSq)
+		stats.RSquared = sanitizeFloat(stats.RSquared)
+		stats.AIC = sanitizeFloat(stats.AIC)
+		stats.BIC = sanitizeFloat(stats.BIC)
+		stats.RMSE = sanitizeFloat(stats.RMSE)
+		stats.NRMSE = sanitizeFloat(stats.NRMSE)
+		res.FitStats = &stats
+	}
+	if len(result.Residuals) > 0 {
+		res.ResidualsReal = make([]float64, len(result.Residuals))
+		res.ResidualsImag = make([]float64, len(result.Residuals))
+		for i, r := range result.Residuals {
+			res.ResidualsReal[i] = sanitizeFloat(r[0])
+			res.ResidualsImag[i] = sanitizeFloat(r[1])
+		}
+	}
+	return res
+}
+
+// completedBatch builds the stored entry of a processed batch, its results
+// ordered by Iteration. A batch with cancelled spectra is stored cancelled.
+func completedBatch(pending models.BatchResult, results []models.WorkResult, event models.BatchCompleteEvent) models.BatchResult {
+	batch := pending
+	batch.Status = models.StatusCompleted
+	if event.Cancelled > 0 {
+		batch.Status = models.StatusCancelled
+	}
+	completed := time.Now()
+	batch.CompletedAt = &completed
+	batch.Summary = &event
+	batch.Results = make([]models.FitResult, len(results))
+	for i, r := range results {
+		fit := models.FitResult{
+			RequestID:   r.RequestID,
+			BatchID:     r.BatchID,
+			Iteration:   r.Iteration,
+			SubmittedAt: pending.SubmittedAt,
+			InitSource:  r.InitSource,
+		}
+		if !r.MeasuredAt.IsZero() {
+			measured := r.MeasuredAt
+			fit.MeasuredAt = &measured
+		}
+		batch.Results[i] = completeResult(fit, r.CircuitCode, r.Result, r.Freqs, r.RealImp, r.ImagImp)
+		if r.Cancelled {
+			batch.Results[i] = cancelledResult(batch.Results[i])
+		}
+	}
+	sort.SliceStable(batch.Results, func(i, j int) bool {
+		return batch.Results[i].Iteration < batch.Results[j].Iteration
+	})
+	batch.TimeSeries = chronological(batch.Results)
+	return batch
+}
+
+// cancelledResult marks res as cancelled, it keeps the parameters found
+// before the fit stopped, if any
+func cancelledResult(res models.FitResult) models.FitResult {
+	res.Status = models.StatusCancelled
+	res.Error = "cancelled"
+	return res
+}
+
+// failureMessage returns the goimpcore.PayloadError message of result, empty
+// when there is none
+func failureMessage(result goimpcore.Result) string {
+	payload, _ := result.Payload.(map[string]interface{})
+	msg, _ := payload[goimpcore.PayloadError].(string)
+	return msg
+}
+
+// sanitizeFloat replaces NaN and infinities by 0 for JSON encoding
+func sanitizeFloat(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// sanitizeSlice sanitizes every value of values in place
+func sanitizeSlice(values []float64) []float64 {
+	for i, v := range values {
+		values[i] = sanitizeFloat(v)
+	}
+	return values
+}
+
+// sanitizeRanking sanitizes the statistics of a circuit comparison
+func sanitizeRanking(ranking []goimpcore.CircuitCandidate) []goimpcore.CircuitCandidate {
+	for i := range ranking {
+		ranking[i].ChiSquare = sanitizeFloat(ranking[i].ChiSquare)
+		ranking[i].AIC = sanitizeFloat(ranking[i].AIC)
+		ranking[i].BIC = sanitizeFloat(ranking[i].BIC)
+	}
+	return ranking
+}
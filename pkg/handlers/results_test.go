@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// getJSON serves GET path with h and decodes the response into v
+func getJSON(t *testing.T, h http.Handler, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil && rec.Code != http.StatusNotFound {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v, body %s", path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+// An accepted fit is pending at /results/{request_id} until it completes
+// with its parameters, residuals and statistics
+func TestResultsRetrievable(t *testing.T) {
+	pool := worker.New(worker.Options{Workers: 1})
+	defer pool.Shutdown()
+	results := store.NewMemory(time.Minute, 100)
+	release := make(chan struct{})
+	params := []float64{10, 1e-5, 0.9, 100}
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		<-release
+		return goimpcore.Result{
+			Status:    goimpcore.OK,
+			Code:      cfg.Code,
+			Params:    params,
+			Min:       1e-6,
+			Residuals: goimpcore.Residuals(impData, goimpcore.CircuitImpedance("r(qr)", freqs, params)),
+			Stats:     goimpcore.FitStats{N: len(freqs), RSquared: 1},
+		}
+	}
+	cfg := testConfig()
+	cfg.Code = "R(QR)"
+	h := NewEISHandler(cfg, pool, processor, results, Limits{}, nil, nil, nil)
+	rh := NewResultsHandler(results)
+
+	body, err := json.Marshal(testSpectrum(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data", bytes.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var accepted struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+
+	var res models.FitResult
+	if code := getJSON(t, rh, "/results/"+accepted.RequestID, &res); code != http.StatusAccepted || res.Status != models.StatusPending {
+		t.Fatalf("running fit: status %d, %s, want %d and pending", code, res.Status, http.StatusAccepted)
+	}
+	close(release)
+	waitResult(t, results, accepted.RequestID)
+
+	res = models.FitResult{}
+	if code := getJSON(t, rh, "/results/"+accepted.RequestID, &res); code != http.StatusOK || res.Status != models.StatusCompleted {
+		t.Fatalf("completed fit: status %d, %s", code, res.Status)
+	}
+	n := len(testSpectrum(t).Frequencies)
+	if len(res.Parameters) != len(params) || len(res.NamedParameters) != len(params) || res.NamedParameters["Q1_n"] != 0.9 {
+		t.Errorf("parameters %v, named %v", res.Parameters, res.NamedParameters)
+	}
+	if res.ChiSquare != 1e-6 || len(res.ResidualsReal) != n || len(res.ResidualsImag) != n {
+		t.Errorf("chi-square %v with %d and %d residuals, want 1e-6 and %d", res.ChiSquare, len(res.ResidualsReal), len(res.ResidualsImag), n)
+	}
+	if res.FitStats == nil || res.FitStats.N != n {
+		t.Errorf("fit statistics %+v, want %d points", res.FitStats, n)
+	}
+
+	if code := getJSON(t, rh, "/results/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("unknown request: status %d, want %d", code, http.StatusNotFound)
+	}
+	if code := getJSON(t, rh, "/batches/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("unknown batch: status %d, want %d", code, http.StatusNotFound)
+	}
+	rec = httptest.NewRecorder()
+	rh.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/results/"+accepted.RequestID, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestBatchesRetrievable(t *testing.T) {
+	results := store.NewMemory(time.Minute, 100)
+	rh := NewResultsHandler(results)
+	results.PutBatch(models.BatchResult{BatchID: "b", Status: models.StatusPending, Spectra: 2})
+
+	var batch models.BatchResult
+	if code := getJSON(t, rh, "/batches/b", &batch); code != http.StatusAccepted || batch.Spectra != 2 {
+		t.Fatalf("pending batch: status %d, %+v", code, batch)
+	}
+	results.PutBatch(models.BatchResult{BatchID: "b", Status: models.StatusCompleted, Spectra: 2, Results: []models.FitResult{{Iteration: 1}, {Iteration: 2}}})
+	if code := getJSON(t, rh, "/batches/b/", &batch); code != http.StatusOK || len(batch.Results) != 2 {
+		t.Errorf("completed batch: status %d, %d results", code, len(batch.Results))
+	}
+}
+
+// A batch of 20 spectra taken 10 s apart, submitted out of order, has its
+// parameter evolution at /batch/{batch_id}/evolution in acquisition order
+func TestBatchEvolution(t *testing.T) {
+	chdirTemp(t)
+	const spectra = 10 * 2
+	// R of every fit is the real part of the first point, telling the
+	// spectra apart
+	pool := worker.New(worker.Options{
+		Workers: 4,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{impData[0][0], 1e-5, 0.9, 100}, Min: 1e-6}
+		},
+	})
+	defer pool.Shutdown()
+	results := store.NewMemory(time.Minute, 100)
+	cfg := testConfig()
+	cfg.Code = "R(QR)"
+	h := NewBatchHandler(cfg, pool, nil, nil, results, Limits{}, nil, nil)
+	rh := NewResultsHandler(results)
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	batch := models.ImpedanceBatch{BatchID: "cell-7"}
+	for i := 0; i < spectra; i++ {
+		k := (7 * i) % spectra // acquisition index, a permutation of the iterations
+		data := testSpectrum(t)
+		data.Timestamp = start.Add(time.Duration(k) * 10 * time.Second).Format(time.RFC3339Nano)
+		data.Impedance[0]["real"] = float64(1000 + k)
+		batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: data, Iteration: i + 1})
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data/batch", bytes.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	waitBatch(t, results, batch.BatchID)
+
+	var evolution models.Evolution
+	if code := getJSON(t, rh, "/batch/cell-7/evolution", &evolution); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(evolution.Timestamps) != spectra || len(evolution.ParamNames) != 4 || len(evolution.Params) != 4 {
+		t.Fatalf("%d timestamps of %d parameters, want %d of 4", len(evolution.Timestamps), len(evolution.ParamNames), spectra)
+	}
+	for k, ts := range evolution.Timestamps {
+		if want := start.Add(time.Duration(k) * 10 * time.Second); !ts.Equal(want) {
+			t.Errorf("timestamp %d is %v, want %v", k, ts, want)
+		}
+		if evolution.Params[0][k] != float64(1000+k) || len(evolution.Params[3]) != spectra {
+			t.Errorf("%s at %d is %v, want %d", evolution.ParamNames[0], k, evolution.Params[0][k], 1000+k)
+		}
+		if i := evolution.Iterations[k] - 1; (7*i)%spectra != k {
+			t.Errorf("iteration %d at position %d", evolution.Iterations[k], k)
+		}
+	}
+
+	var done models.BatchResult
+	getJSON(t, rh, "/batches/cell-7", &done)
+	if len(done.TimeSeries) != spectra || !done.TimeSeries[0].Timestamp.Equal(start) {
+		t.Errorf("batch time series of %d spectra", len(done.TimeSeries))
+	}
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/webhook"
+)
+
+// SimulateHandler serves POST /simulate, the spectrum of a circuit with given
+// parameters for a models.SimulateRequest, to overlay on measured data. It is
+// computed inline, outside the worker pool.
+type SimulateHandler struct {
+	limits Limits
+}
+
+// NewSimulateHandler creates a new simulation handler
+func NewSimulateHandler(limits Limits) *SimulateHandler {
+	return &SimulateHandler{
+		limits: limits,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *SimulateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+
+	circuit, err := goimpcore.CompileCircuit(req.CircuitCode)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if len(req.Parameters) != circuit.NumParams() {
+		h.writeError(w, fmt.Sprintf("%s needs %d parameters, got %d", circuit.Code(), circuit.NumParams(), len(req.Parameters)), http.StatusUnprocessableEntity)
+		return
+	}
+	for i, p := range req.Parameters {
+		if math.IsNaN(p) || math.IsInf(p, 0) {
+			h.writeError(w, fmt.Sprintf("parameter %d is not finite", i), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// The grid is bounded like a measured spectrum before it is allocated
+	ppd := req.PointsPerDecade
+	if ppd == 0 {
+		ppd = goimpcore.DefaultPointsPerDecade
+	}
+	if req.FreqMin > 0 && req.FreqMax > req.FreqMin && ppd > 0 {
+		if err := h.limits.checkPoints(int(math.Round(math.Log10(req.FreqMax/req.FreqMin)*float64(ppd))) + 1); err != nil {
+			h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	dist := goimpcore.UNIFORM
+	if req.Noise != "" {
+		if dist, err = goimpcore.ParseNoiseDistribution(req.Noise); err != nil {
+			h.writeError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	seed := req.Seed
+	if req.NoiseLevel > 0 && seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	freqs, imp, err := goimpcore.Simulate(circuit.Code(), req.Parameters, goimpcore.SimOptions{
+		FreqMin:         req.FreqMin,
+		FreqMax:         req.FreqMax,
+		PointsPerDecade: req.PointsPerDecade,
+		Noise:           dist,
+		NoiseLevel:      req.NoiseLevel,
+		Rand:            rand.New(rand.NewSource(seed)),
+	})
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	res := models.SimulateResponse{
+		CircuitCode: circuit.Code(),
+		Frequencies: freqs,
+		Impedance:   make([]map[string]float64, len(imp)),
+	}
+	if req.NoiseLevel > 0 {
+		res.Seed = seed
+	}
+	for i, z := range imp {
+		if math.IsNaN(z[0]) || math.IsInf(z[0], 0) || math.IsNaN(z[1]) || math.IsInf(z[1], 0) {
+			h.writeError(w, fmt.Sprintf("the parameters give no finite impedance at %g Hz", freqs[i]), http.StatusUnprocessableEntity)
+			return
+		}
+		res.Impedance[i] = map[string]float64{"real": z[0], "imag": z[1]}
+	}
+	if req.IncludeElements {
+		if res.ElementImpedances, err = webhook.NewCalculator().DecomposeImpedances(circuit.Code(), freqs, req.Parameters); err != nil {
+			h.writeError(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+// writeError writes an error response
+func (h *SimulateHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+)
+
+// IterativeSolveFunc starts the fit of a spectrum and returns the channel of
+// its progress, see goimpcore.Solver.SolveIterative
+type IterativeSolveFunc func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) <-chan goimpcore.IterativeResult
+
+// StreamHandler fits a spectrum inline like a synchronous request and
+// streams its progress as Server-Sent Events, so that browsers can follow it
+// with EventSource. Every event carries the best fit so far, the last one has
+// is_complete set and the final result.
+type StreamHandler struct {
+	config  *config.Config
+	solve   IterativeSolveFunc
+	results store.Store
+	limits  Limits
+}
+
+// streamEvent is the data of one event of a streamed fit
+type streamEvent struct {
+	Iteration  int              `json:"iteration"`
+	IsComplete bool             `json:"is_complete"`
+	Result     models.FitResult `json:"result"`
+}
+
+// NewStreamHandler creates a new stream handler, results may be nil when
+// completed fits are not kept for retrieval
+func NewStreamHandler(cfg *config.Config, solve IterativeSolveFunc, results store.Store, limits Limits) *StreamHandler {
+	return &StreamHandler{
+		config:  cfg,
+		solve:   solve,
+		results: results,
+		limits:  limits,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var impedanceData models.ImpedanceData
+	if err := json.NewDecoder(r.Body).Decode(&impedanceData); err != nil {
+		message, status := decodeError(err)
+		h.writeError(w, message, status)
+		return
+	}
+	if err := h.limits.checkPoints(len(impedanceData.Frequencies)); err != nil {
+		h.writeError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if errs := models.ValidateImpedanceData(impedanceData); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	impData, err := impedanceData.Points()
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg := requestConfig(h.config, impedanceData)
+	if err := validateFit(impedanceData.Frequencies, cfg); err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(goimpcore.ParseCircuitCodes(cfg.Code)) > 1 || cfg.OptimMethod == "all" {
+		h.writeError(w, "Streaming fits one circuit with one optimization method", http.StatusBadRequest)
+		return
+	}
+
+	timeout := h.config.SyncTimeout
+	if timeout <= 0 {
+		timeout = DefaultSyncTimeout
+	}
+	rc := http.NewResponseController(w)
+	// The server write timeout is shorter than a long fit
+	rc.SetWriteDeadline(time.Now().Add(timeout + syncWriteMargin))
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	pending := pendingResult(utils.RequestIDOrNew(r.Context()))
+	pending.MeasuredAt = measuredAt(impedanceData)
+	if !h.config.Quiet {
+		log.Printf("HTTP stream request received - ID: %s, Data points: %d, Timeout: %v", pending.RequestID, len(impedanceData.Frequencies), timeout)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(utils.RequestIDHeader, pending.RequestID)
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	freqs := impedanceData.Frequencies
+	realImp := make([]float64, len(impData))
+	imagImp := make([]float64, len(impData))
+	for i, imp := range impData {
+		realImp[i] = imp[0]
+		imagImp[i] = imp[1]
+	}
+
+	// The channel is drained even after the client left, the fit stops with
+	// the cancelled request context
+	for progress := range h.solve(ctx, freqs, impData, impedanceData.Sigmas(), cfg) {
+		res := completeResult(pending, progress.Result.BestCircuit(cfg.Code), progress.Result, freqs, realImp, imagImp)
+		if !progress.IsComplete {
+			res.Status = models.StatusRunning
+			res.CompletedAt = nil
+		} else {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				res.Status = models.StatusTimeout
+				res.Error = fmt.Sprintf("fit did not finish within %v, parameters are the best found so far", timeout)
+			}
+			if h.results != nil {
+				h.results.PutResult(res)
+			}
+			if !h.config.Quiet {
+				log.Printf("HTTP stream request done - ID: %s, Status: %s, Iterations: %d, Chi-square: %.14e", res.RequestID, res.Status, progress.Iteration, res.ChiSquare)
+			}
+		}
+
+		data, err := json.Marshal(streamEvent{Iteration: progress.Iteration, IsComplete: progress.IsComplete, Result: res})
+		if err != nil {
+			log.Printf("Stream %s: failed to encode iteration %d: %v", pending.RequestID, progress.Iteration, err)
+			continue
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		rc.Flush()
+	}
+}
+
+// writeError writes an error response
+func (h *StreamHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
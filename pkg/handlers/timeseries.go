@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// DefaultDriftThreshold is the parameter drift above which a batch is
+// reported as not at steady state, 0.5 for 50%
+const DefaultDriftThreshold = 0.5
+
+// TimeSeriesFromBatch arranges the completed fits of a batch by Iteration as
+// one row per spectrum. paramNames selects the parameters by their
+// goimpcore.ParamLabels, nil for every parameter in order of appearance.
+func TimeSeriesFromBatch(results []models.FitResult, paramNames []string) models.TimeSeries {
+	completed := make([]models.FitResult, 0, len(results))
+	for _, r := range results {
+		if r.Status == models.StatusCompleted {
+			completed = append(completed, r)
+		}
+	}
+	sort.SliceStable(completed, func(i, j int) bool {
+		return completed[i].Iteration < completed[j].Iteration
+	})
+
+	if paramNames == nil {
+		paramNames = fittedParamNames(completed)
+	}
+
+	series := models.TimeSeries{ParamNames: paramNames}
+	if len(completed) > 0 {
+		series.BatchID = completed[0].BatchID
+	}
+	for _, r := range completed {
+		row, ok := paramRow(r, paramNames)
+		if !ok {
+			continue
+		}
+
+		var completedAt time.Time
+		if r.CompletedAt != nil {
+			completedAt = *r.CompletedAt
+		}
+		series.Iterations = append(series.Iterations, r.Iteration)
+		series.Params = append(series.Params, row)
+		series.ChiSquares = append(series.ChiSquares, r.ChiSquare)
+		series.Timestamps = append(series.Timestamps, completedAt)
+	}
+
+	series.ParameterDrift = make([]float64, len(paramNames))
+	for j := range paramNames {
+		series.ParameterDrift[j] = parameterDrift(series.Params, j)
+	}
+	return series
+}
+
+// fittedParamNames returns the labels of the parameters of results in order
+// of appearance
+func fittedParamNames(results []models.FitResult) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, r := range results {
+		for _, info := range r.ParameterInfo {
+			if _, ok := r.NamedParameters[info.Label]; ok && !seen[info.Label] {
+				seen[info.Label] = true
+				names = append(names, info.Label)
+			}
+		}
+	}
+	return names
+}
+
+// paramRow returns the parameters of r named by paramNames, ok is false when
+// r lacks one of them
+func paramRow(r models.FitResult, paramNames []string) (row []float64, ok bool) {
+	row = make([]float64, len(paramNames))
+	for j, name := range paramNames {
+		if row[j], ok = r.NamedParameters[name]; !ok {
+			return nil, false
+		}
+	}
+	return row, true
+}
+
+// chronological returns results in acquisition order, nil unless every one of
+// them has a timestamp
+func chronological(results []models.FitResult) []models.TimestampedResult {
+	if len(results) == 0 {
+		return nil
+	}
+	series := make([]models.TimestampedResult, 0, len(results))
+	for _, r := range results {
+		if r.MeasuredAt == nil {
+			return nil
+		}
+		series = append(series, models.TimestampedResult{Timestamp: *r.MeasuredAt, Result: r})
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].Timestamp.Before(series[j].Timestamp)
+	})
+	return series
+}
+
+// errNoTimestamps is returned for the evolution of a batch whose spectra are
+// not all timestamped
+var errNoTimestamps = errors.New("the spectra of the batch do not all have a timestamp")
+
+// EvolutionFromBatch returns the fitted parameters of a completed
+// time-resolved batch as functions of the acquisition time
+func EvolutionFromBatch(batch models.BatchResult) (models.Evolution, error) {
+	evolution := models.Evolution{BatchID: batch.BatchID}
+	if batch.TimeSeries == nil {
+		return evolution, errNoTimestamps
+	}
+
+	completed := make([]models.FitResult, 0, len(batch.TimeSeries))
+	for _, entry := range batch.TimeSeries {
+		if entry.Result.Status == models.StatusCompleted {
+			completed = append(completed, entry.Result)
+		}
+	}
+	evolution.ParamNames = fittedParamNames(completed)
+	evolution.Params = make([][]float64, len(evolution.ParamNames))
+	for _, r := range completed {
+		row, ok := paramRow(r, evolution.ParamNames)
+		if !ok {
+			continue
+		}
+		evolution.Timestamps = append(evolution.Timestamps, *r.MeasuredAt)
+		evolution.Iterations = append(evolution.Iterations, r.Iteration)
+		evolution.ChiSquares = append(evolution.ChiSquares, r.ChiSquare)
+		for j, v := range row {
+			evolution.Params[j] = append(evolution.Params[j], v)
+		}
+	}
+	return evolution, nil
+}
+
+// parameterDrift returns the total variation of column j of rows relative to
+// its first value, relative to its largest magnitude when the first is 0
+func parameterDrift(rows [][]float64, j int) float64 {
+	if len(rows) < 2 {
+		return 0
+	}
+	var variation, scale float64
+	for i := range rows {
+		scale = math.Max(scale, math.Abs(rows[i][j]))
+		if i > 0 {
+			variation += math.Abs(rows[i][j] - rows[i-1][j])
+		}
+	}
+	if first := math.Abs(rows[0][j]); first > 0 {
+		scale = first
+	}
+	if scale == 0 {
+		return 0
+	}
+	return variation / scale
+}
+
+// warnDrift logs the parameters of series that drifted above threshold,
+// DefaultDriftThreshold when threshold <= 0
+func warnDrift(series models.TimeSeries, threshold float64) {
+	if threshold <= 0 {
+		threshold = DefaultDriftThreshold
+	}
+	for j, drift := range series.ParameterDrift {
+		if drift > threshold {
+			log.Printf("⚠️ Parameter %s of batch %s drifted %.0f%% over %d spectra, the system may not be at steady state",
+				series.ParamNames[j], series.BatchID, drift*100, len(series.Iterations))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/fileio"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// uploadMemory is how much of an uploaded form is kept in memory, larger
+// files are buffered in temporary files
+const uploadMemory = 1 << 20
+
+// ServeUpload fits a measurement file uploaded as multipart/form-data in the
+// file field, for clients without JSON:
+//
+//	curl -F "file=@data.dta" -F "circuit=R(QR)" http://localhost:8080/eis-data/upload
+//
+// The format is detected from the file name, see fileio.DetectFormat, and
+// read as by the CLI. The circuit, method and initvalues (comma separated)
+// fields override the server settings, the circuit of a ZView file is fitted
+// when circuit is not given. The response is the one of /eis-data, ?sync=true
+// included. A file exceeding the body size limit gets 413, one that fails to
+// parse 400 naming its first bad line.
+func (h *EISHandler) ServeUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "multipart/form-data" {
+		h.writeError(w, "Expected a multipart/form-data upload with a file field", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err := r.ParseMultipartForm(uploadMemory); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			h.writeError(w, fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.writeError(w, "Invalid multipart form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.writeError(w, "Missing file field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	impedanceData, err := uploadedData(r, file, header)
+	if err != nil {
+		h.writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.serveData(w, r, impedanceData)
+}
+
+// uploadedData reads the measurement file of an upload and applies the fit
+// settings of its form fields
+func uploadedData(r *http.Request, file multipart.File, header *multipart.FileHeader) (models.ImpedanceData, error) {
+	m, err := fileio.Read(fileio.DetectFormat(header.Filename), file)
+	if err != nil {
+		return models.ImpedanceData{}, fmt.Errorf("%s: %v", header.Filename, err)
+	}
+
+	data := models.ImpedanceData{
+		Frequencies: m.Freqs,
+		Impedance:   make([]map[string]float64, len(m.ImpData)),
+		OptimMethod: r.FormValue("method"),
+	}
+	for i, point := range m.ImpData {
+		data.Impedance[i] = map[string]float64{"real": point[0], "imag": point[1]}
+	}
+	for _, sigma := range m.Sigmas {
+		data.Sigma = append(data.Sigma, map[string]float64{"real": sigma[0], "imag": sigma[1]})
+	}
+	switch circuit := r.FormValue("circuit"); {
+	case circuit != "":
+		data.CircuitCode = circuit
+	case m.CircuitCode != "" && goimpcore.ValidateCircuit(m.CircuitCode) == nil:
+		data.CircuitCode = m.CircuitCode
+	}
+	if values := r.FormValue("initvalues"); values != "" {
+		for _, value := range strings.Split(values, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return models.ImpedanceData{}, fmt.Errorf("invalid initvalues %q: %v", values, err)
+			}
+			data.InitValues = append(data.InitValues, v)
+		}
+	}
+	return data, nil
+}
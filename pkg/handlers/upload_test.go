@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// gamryFile is a Gamry .dta file of the spectrum of data
+func gamryFile(data models.ImpedanceData) string {
+	var b strings.Builder
+	b.WriteString("EXPLAIN\nTAG\tEISPOT\nTITLE\tLABEL\tPotentiostatic EIS\tTest &Identifier\n")
+	b.WriteString("ZCURVE\tTABLE\n")
+	b.WriteString("\tPt\tTime\tFreq\tZreal\tZimag\tZsig\tZmod\tZphz\n")
+	b.WriteString("\t#\ts\tHz\tohm\tohm\tV\tohm\t°\n")
+	for i, f := range data.Frequencies {
+		fmt.Fprintf(&b, "\t%d\t%d\t%.17g\t%.17g\t%.17g\t1\t0\t0\n", i, i, f, data.Impedance[i]["real"], data.Impedance[i]["imag"])
+	}
+	return b.String()
+}
+
+// uploadRequest is a multipart upload of the file content named filename
+// with the form fields
+func uploadRequest(t *testing.T, filename, content string, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if filename != "" {
+		fw, err := mw.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/eis-data/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// An uploaded .dta file is fitted like the JSON request of its spectrum,
+// with the circuit, method and initvalues fields as the fit settings
+func TestUploadDTA(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fitted  [][2]float64
+		freqs   []float64
+		usedCfg config.Config
+	)
+	processor := func(ctx context.Context, f []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		freqs, fitted, usedCfg = f, impData, *cfg
+		return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{10, 1e-5, 0.9, 100}}
+	}
+	pool := worker.New(worker.Options{Workers: 1})
+	defer pool.Shutdown()
+	results := store.NewMemory(time.Minute, 100)
+	cfg := testConfig()
+	cfg.Code = "R"
+	h := NewEISHandler(cfg, pool, processor, results, Limits{}, nil, nil, nil)
+
+	data := testSpectrum(t)
+	rec := httptest.NewRecorder()
+	h.ServeUpload(rec, uploadRequest(t, "data.dta", gamryFile(data), map[string]string{
+		"circuit":    "R(QR)",
+		"method":     "lm",
+		"initvalues": "5, 1e-4, 0.8, 50",
+	}))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+	}
+	var accepted struct {
+		Success   bool   `json:"success"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &accepted); err != nil {
+		t.Fatal(err)
+	}
+	if !accepted.Success || accepted.RequestID == "" {
+		t.Fatalf("response %s, want success and a request_id", rec.Body)
+	}
+	if res := waitResult(t, results, accepted.RequestID); res.Status != models.StatusCompleted {
+		t.Fatalf("status %s, want %s", res.Status, models.StatusCompleted)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(freqs, data.Frequencies) {
+		t.Errorf("frequencies %v, want %v", freqs, data.Frequencies)
+	}
+	want := make([][2]float64, len(data.Impedance))
+	for i, z := range data.Impedance {
+		want[i] = [2]float64{z["real"], z["imag"]}
+	}
+	checkPoints(t, fitted, want)
+	if usedCfg.Code != "R(QR)" || usedCfg.OptimMethod != "lm" || !slices.Equal(usedCfg.InitValues, []float64{5, 1e-4, 0.8, 50}) {
+		t.Errorf("fitted %s by %s from %v, want R(QR) by lm from the initvalues field", usedCfg.Code, usedCfg.OptimMethod, usedCfg.InitValues)
+	}
+}
+
+// Uploads that are not a readable measurement file are rejected
+func TestUploadRejected(t *testing.T) {
+	h := NewEISHandler(testConfig(), nil, nil, nil, Limits{}, nil, nil, nil)
+	dta := gamryFile(testSpectrum(t))
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"json", syncRequest(t, testSpectrum(t)), http.StatusUnsupportedMediaType},
+		{"no file", uploadRequest(t, "", "", map[string]string{"circuit": "R(QR)"}), http.StatusBadRequest},
+		{"no table", uploadRequest(t, "data.dta", "EXPLAIN\nTAG\tEISPOT\n", nil), http.StatusBadRequest},
+		{"bad initvalues", uploadRequest(t, "data.dta", dta, map[string]string{"initvalues": "1,x"}), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeUpload(rec, tt.req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d, body %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
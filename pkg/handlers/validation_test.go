@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// Invalid spectra are answered with 422 and the list of invalid fields, by
+// the single fit and the batch endpoints
+func TestInvalidDataUnprocessable(t *testing.T) {
+	data := testSpectrum(t)
+	data.Frequencies[0] = -1
+	data.Impedance[3] = map[string]float64{"real": 1}
+
+	batch := models.ImpedanceBatch{BatchID: "invalid", Spectra: []models.BatchItem{
+		{ImpedanceData: testSpectrum(t), Iteration: 1},
+		{ImpedanceData: data, Iteration: 2},
+	}}
+	tests := []struct {
+		name    string
+		handler http.Handler
+		path    string
+		body    interface{}
+		want    []models.ValidationError
+	}{
+		{"single", NewEISHandler(testConfig(), nil, nil, nil, Limits{}, nil, nil, nil), "/eis-data", data, []models.ValidationError{
+			{Field: "frequencies[0]", Message: "must be a positive finite number, got -1"},
+			{Field: "impedance[3]", Message: `missing "imag"`},
+		}},
+		{"batch", NewBatchHandler(testConfig(), nil, nil, nil, nil, Limits{}, nil, nil), "/eis-data/batch", batch, []models.ValidationError{
+			{Field: "spectra[1].frequencies[0]", Message: "must be a positive finite number, got -1"},
+			{Field: "spectra[1].impedance[3]", Message: `missing "imag"`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body)))
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status %d, want %d, body %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+			}
+			var resp struct {
+				ValidationErrors []models.ValidationError `json:"validation_errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.ValidationErrors, tt.want) {
+				t.Errorf("validation errors %v, want %v", resp.ValidationErrors, tt.want)
+			}
+		})
+	}
+}
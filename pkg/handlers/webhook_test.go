@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/webhook"
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// The webhook of an asynchronous fit carries the fitted parameters, the
+// elements and their impedances, and a failed fit is sent as failed
+func TestFitWebhookPayload(t *testing.T) {
+	params := []float64{10, 1e-5, 0.9, 100}
+	tests := []struct {
+		name   string
+		result goimpcore.Result
+		status string
+	}{
+		{"completed", goimpcore.Result{Status: goimpcore.OK, Code: "R(QR)", Params: params, Min: 2.5e-6}, models.StatusCompleted},
+		{"failed", goimpcore.Result{Status: "ERROR", Code: "R(QR)"}, models.StatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads := make(chan models.WebhookResponse, 1)
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var payload models.WebhookResponse
+				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+					t.Errorf("webhook body: %v", err)
+				}
+				payloads <- payload
+			}))
+			defer sink.Close()
+
+			cfg := testConfig()
+			cfg.Code = "R(QR)"
+			client := webhook.NewClient(sink.URL, cfg)
+			pool := worker.New(worker.Options{Workers: 1, Webhook: client.Send})
+			defer pool.Shutdown()
+			processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+				return tt.result
+			}
+			h := NewEISHandler(cfg, pool, processor, nil, Limits{}, nil, nil, nil)
+
+			body, err := json.Marshal(testSpectrum(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/eis-data", bytes.NewReader(body)))
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("status %d, body %s", rec.Code, rec.Body)
+			}
+
+			var payload models.WebhookResponse
+			select {
+			case payload = <-payloads:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no webhook")
+			}
+			if payload.Status != tt.status {
+				t.Errorf("status %s, want %s", payload.Status, tt.status)
+			}
+			if tt.status != models.StatusCompleted {
+				return
+			}
+			if payload.ChiSquare != tt.result.Min {
+				t.Errorf("chi-square %v, want %v", payload.ChiSquare, tt.result.Min)
+			}
+			if len(payload.Parameters) != len(params) {
+				t.Fatalf("parameters %v, want %v", payload.Parameters, params)
+			}
+			for i, p := range params {
+				if payload.Parameters[i] != p {
+					t.Errorf("parameter %d = %v, want %v", i, payload.Parameters[i], p)
+				}
+			}
+			if len(payload.ElementNames) != len(params) {
+				t.Errorf("element names %v, want one per parameter", payload.ElementNames)
+			}
+			n := len(testSpectrum(t).Frequencies)
+			if len(payload.ElementImpedances) == 0 {
+				t.Fatal("no element impedances")
+			}
+			for _, e := range payload.ElementImpedances {
+				if len(e.Impedances) != n {
+					t.Errorf("element %s has %d impedances for %d frequencies", e.Name, len(e.Impedances), n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/webhook"
+)
+
+// WebhooksHandler serves the webhooks that could not be delivered, GET
+// /webhooks/failed lists them and POST /webhooks/failed/replay resends them
+type WebhooksHandler struct {
+	client *webhook.Client
+}
+
+// NewWebhooksHandler creates a new handler of the dead letters of client
+func NewWebhooksHandler(client *webhook.Client) *WebhooksHandler {
+	return &WebhooksHandler{
+		client: client,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *WebhooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch strings.TrimRight(r.URL.Path, "/") {
+	case "/webhooks/failed":
+		if r.Method != "GET" {
+			h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		letters := []webhook.DeadLetter{}
+		if h.client.DeadLetters != nil {
+			letters = append(letters, h.client.DeadLetters.List()...)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":    len(letters),
+			"webhooks": letters,
+		})
+
+	case "/webhooks/failed/replay":
+		if r.Method != "POST" {
+			h.writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		delivered, failed := h.client.Replay(r.Context())
+		json.NewEncoder(w).Encode(map[string]int{
+			"delivered": delivered,
+			"failed":    failed,
+		})
+
+	default:
+		h.writeError(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// writeError writes an error response
+func (h *WebhooksHandler) writeError(w http.ResponseWriter, message string, statusCode int) {
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,119 @@
+package health
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// Readiness defaults
+const (
+	DefaultGracePeriod    = 2 * time.Second
+	DefaultWebhookWindow  = 5
+	DefaultQueueWatermark = 0.9
+)
+
+// Checker reports whether one dependency of the server is ready
+type Checker interface {
+	Name() string
+	Check() error
+}
+
+// JobQueue is implemented by the worker pool
+type JobQueue interface {
+	Stats() worker.Stats
+}
+
+// WebhookHistory is implemented by the webhook client
+type WebhookHistory interface {
+	// RecentFailures returns how many of the last n sends failed and how many were made
+	RecentFailures(n int) (failed, total int)
+}
+
+// WorkerPoolChecker fails once the worker pool is shut down or has no worker
+// left, and while its jobs queue is filled above Watermark
+type WorkerPoolChecker struct {
+	Pool      JobQueue
+	Watermark float64 // fraction of the jobs queue capacity, DefaultQueueWatermark when 0
+}
+
+// Name implements Checker
+func (c WorkerPoolChecker) Name() string { return "worker_pool" }
+
+// Check implements Checker
+func (c WorkerPoolChecker) Check() error {
+	stats := c.Pool.Stats()
+	if stats.Closed {
+		return fmt.Errorf("worker pool is shut down")
+	}
+	if stats.AliveWorkers == 0 {
+		return fmt.Errorf("no worker running")
+	}
+	watermark := c.Watermark
+	if watermark <= 0 {
+		watermark = DefaultQueueWatermark
+	}
+	if float64(stats.QueuedJobs) > watermark*float64(stats.JobsCapacity) {
+		return fmt.Errorf("jobs queue at %d/%d, above the %g watermark", stats.QueuedJobs, stats.JobsCapacity, watermark)
+	}
+	return nil
+}
+
+// WebhookChecker fails when any of the last Window webhook sends failed
+type WebhookChecker struct {
+	Webhooks WebhookHistory
+	Window   int // DefaultWebhookWindow when 0
+}
+
+// Name implements Checker
+func (c WebhookChecker) Name() string { return "webhook" }
+
+// Check implements Checker
+func (c WebhookChecker) Check() error {
+	window := c.Window
+	if window <= 0 {
+		window = DefaultWebhookWindow
+	}
+	if failed, total := c.Webhooks.RecentFailures(window); failed > 0 {
+		return fmt.Errorf("%d of the last %d webhook sends failed", failed, total)
+	}
+	return nil
+}
+
+// StartupChecker fails until the grace period after Started has passed
+type StartupChecker struct {
+	Started time.Time
+	Grace   time.Duration // DefaultGracePeriod when 0
+}
+
+// Name implements Checker
+func (c StartupChecker) Name() string { return "startup" }
+
+// Check implements Checker
+func (c StartupChecker) Check() error {
+	grace := c.Grace
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	if up := time.Since(c.Started); up < grace {
+		return fmt.Errorf("starting up, running for %v of %v grace period", up.Round(time.Millisecond), grace)
+	}
+	return nil
+}
+
+// Ready runs every checker and returns the outcome per checker name, "ok" for
+// passing checks, and whether all of them passed
+func Ready(checkers ...Checker) (map[string]string, bool) {
+	results := make(map[string]string, len(checkers))
+	ready := true
+	for _, c := range checkers {
+		if err := c.Check(); err != nil {
+			results[c.Name()] = err.Error()
+			ready = false
+		} else {
+			results[c.Name()] = "ok"
+		}
+	}
+	return results, ready
+}
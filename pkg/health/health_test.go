@@ -0,0 +1,70 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/worker"
+)
+
+// fakeQueue is a JobQueue with fixed stats
+type fakeQueue worker.Stats
+
+func (q fakeQueue) Stats() worker.Stats { return worker.Stats(q) }
+
+// fakeWebhooks is a WebhookHistory with fixed failures
+type fakeWebhooks struct{ failed, total int }
+
+func (w fakeWebhooks) RecentFailures(n int) (int, int) { return w.failed, w.total }
+
+func TestCheckers(t *testing.T) {
+	idle := fakeQueue{Workers: 2, AliveWorkers: 2, JobsCapacity: 4}
+	saturated := idle
+	saturated.QueuedJobs = 4
+	belowWatermark := idle
+	belowWatermark.QueuedJobs = 3
+	closed := idle
+	closed.Closed = true
+	dead := idle
+	dead.AliveWorkers = 0
+
+	tests := []struct {
+		name    string
+		checker Checker
+		ok      bool
+	}{
+		{"idle pool", WorkerPoolChecker{Pool: idle}, true},
+		{"saturated pool", WorkerPoolChecker{Pool: saturated}, false},
+		{"pool below the watermark", WorkerPoolChecker{Pool: belowWatermark}, true},
+		{"pool above a lower watermark", WorkerPoolChecker{Pool: belowWatermark, Watermark: 0.5}, false},
+		{"closed pool", WorkerPoolChecker{Pool: closed}, false},
+		{"pool without workers", WorkerPoolChecker{Pool: dead}, false},
+		{"webhooks delivered", WebhookChecker{Webhooks: fakeWebhooks{0, 5}}, true},
+		{"webhook failed", WebhookChecker{Webhooks: fakeWebhooks{1, 5}}, false},
+		{"starting up", StartupChecker{Started: time.Now()}, false},
+		{"started", StartupChecker{Started: time.Now().Add(-time.Minute)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.checker.Check(); (err == nil) != tt.ok {
+				t.Errorf("Check() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReady(t *testing.T) {
+	pool := WorkerPoolChecker{Pool: fakeQueue{Workers: 1, AliveWorkers: 1, JobsCapacity: 2, QueuedJobs: 2}}
+	webhooks := WebhookChecker{Webhooks: fakeWebhooks{0, 0}}
+
+	checks, ready := Ready(pool, webhooks)
+	if ready {
+		t.Error("ready with a saturated pool")
+	}
+	if checks["webhook"] != "ok" || checks["worker_pool"] == "ok" {
+		t.Errorf("checks %v, want only the worker pool failing", checks)
+	}
+	if _, ready := Ready(webhooks); !ready {
+		t.Error("not ready with every check passing")
+	}
+}
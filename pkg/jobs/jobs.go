@@ -0,0 +1,222 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a finished job stays in the registry
+const DefaultTTL = time.Hour
+
+// States of a job
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+// Kinds of jobs
+const (
+	KindFit   = "fit"   // asynchronous single spectrum request, ID is its request ID
+	KindBatch = "batch" // batch request, ID is its batch ID
+)
+
+var (
+	// ErrExists is returned by Add while a job with the same ID is active
+	ErrExists = errors.New("a job with this ID is already active")
+	// ErrNotFound is returned for unknown or expired job IDs
+	ErrNotFound = errors.New("unknown or expired job ID")
+	// ErrFinished is returned by Cancel for a job that already finished
+	ErrFinished = errors.New("job already finished")
+)
+
+// Job is the status of a submitted request. Spectra counts the spectra of the
+// job, 1 for a fit, and Succeeded, Failed and Cancelled those processed so far.
+type Job struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	State       string     `json:"state"`
+	Spectra     int        `json:"spectra"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Cancelled   int        `json:"cancelled"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the processing of the job ended, a cancelled job
+// only finishes once its running spectra stopped
+func (j Job) Finished() bool {
+	return j.FinishedAt != nil
+}
+
+// Registry tracks the submitted jobs and cancels them on request. Finished
+// jobs expire ttl after they finished. Start, Record, Grow and Finish do
+// nothing on a nil Registry.
+type Registry struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+	now  func() time.Time
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+// NewRegistry creates a registry keeping finished jobs for ttl, DefaultTTL
+// when ttl <= 0
+func NewRegistry(ttl time.Duration) *Registry {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Registry{
+		ttl:  ttl,
+		jobs: make(map[string]*entry),
+		now:  time.Now,
+	}
+}
+
+// Add registers a queued job of kind with spectra spectra, cancel stops its
+// processing. It fails with ErrExists while a job with the same ID is active,
+// a finished one is replaced.
+func (r *Registry) Add(id, kind string, spectra int, cancel context.CancelFunc) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	if e, ok := r.jobs[id]; ok && !e.job.Finished() {
+		return e.job, ErrExists
+	}
+	e := &entry{
+		job: Job{
+			ID:          id,
+			Kind:        kind,
+			State:       StateQueued,
+			Spectra:     spectra,
+			SubmittedAt: r.now(),
+		},
+		cancel: cancel,
+	}
+	r.jobs[id] = e
+	return e.job, nil
+}
+
+// Get returns the job registered under id
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// Start marks a queued job as running
+func (r *Registry) Start(id string) {
+	r.update(id, func(job *Job) {
+		if job.State == StateQueued {
+			now := r.now()
+			job.State = StateRunning
+			job.StartedAt = &now
+		}
+	})
+}
+
+// Record counts a processed spectrum of the job, state being StateDone,
+// StateFailed or StateCancelled
+func (r *Registry) Record(id, state string) {
+	r.update(id, func(job *Job) {
+		switch state {
+		case StateDone:
+			job.Succeeded++
+		case StateFailed:
+			job.Failed++
+		case StateCancelled:
+			job.Cancelled++
+		}
+	})
+}
+
+// Grow counts n more spectra of the job, a streamed batch learns its size as
+// its spectra arrive
+func (r *Registry) Grow(id string, n int) {
+	r.update(id, func(job *Job) {
+		job.Spectra += n
+	})
+}
+
+// Finish marks the job as finished in state. A cancelled job stays cancelled
+// unless all its spectra were processed before the cancellation took effect.
+func (r *Registry) Finish(id, state string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return
+	}
+	if e.job.State != StateCancelled || e.job.Cancelled == 0 {
+		e.job.State = state
+	}
+	now := r.now()
+	e.job.FinishedAt = &now
+	e.cancel = nil
+}
+
+// Cancel cancels an active job, its queued spectra are skipped and the
+// running ones stopped with the best result found so far. The job is reported
+// cancelled right away, FinishedAt is set once its processing ended. Cancelling
+// a job again before then is a no-op.
+func (r *Registry) Cancel(id string) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire()
+
+	e, ok := r.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if e.job.Finished() {
+		return e.job, ErrFinished
+	}
+	e.job.State = StateCancelled
+	if e.cancel != nil {
+		e.cancel()
+	}
+	return e.job, nil
+}
+
+func (r *Registry) update(id string, f func(job *Job)) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.jobs[id]; ok {
+		f(&e.job)
+	}
+}
+
+// expire drops the jobs finished more than ttl ago, r.mu must be held
+func (r *Registry) expire() {
+	cutoff := r.now().Add(-r.ttl)
+	for id, e := range r.jobs {
+		if e.job.FinishedAt != nil && e.job.FinishedAt.Before(cutoff) {
+			delete(r.jobs, id)
+		}
+	}
+}
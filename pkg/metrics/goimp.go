@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default is the registry of the metrics of the solver, served on /metrics
+var Default = &Registry{}
+
+// The metrics of the solver, updated by the worker pools, the webhook
+// clients and Middleware of both servers
+var (
+	HTTPRequests = Default.CounterVec("goimp_http_requests_total",
+		"HTTP requests by endpoint and status code.", "endpoint", "status")
+
+	JobsSubmitted = Default.Counter("goimp_jobs_submitted_total", "Fits submitted to the worker pool.")
+	JobsCompleted = Default.Counter("goimp_jobs_completed_total", "Fits finished with status OK.")
+	JobsFailed    = Default.Counter("goimp_jobs_failed_total", "Fits finished with an error or a panic.")
+	JobTimeouts   = Default.CounterVec("goimp_job_timeouts_total",
+		"Fits stopped at the job timeout by optimization method, counted as failed too.", "method")
+
+	SolveDuration = Default.HistogramVec("goimp_solve_duration_seconds",
+		"Duration of a fit by optimization method.",
+		[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "method")
+	ChiSquare = Default.Histogram("goimp_fit_chi_square",
+		"Chi-square of the successful fits.",
+		[]float64{1e-8, 1e-7, 1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 1e-1, 1, 10, 100})
+
+	WebhooksDelivered = Default.Counter("goimp_webhooks_delivered_total", "Webhooks delivered, retries included.")
+	WebhooksFailed    = Default.Counter("goimp_webhooks_failed_total", "Webhooks undelivered after every attempt.")
+	WebhookRetries    = Default.Counter("goimp_webhook_retries_total", "Webhook delivery attempts repeated after a failure.")
+
+	QueueDepth    = Default.Gauge("goimp_queue_depth", "Fits waiting for a worker.")
+	ActiveWorkers = Default.Gauge("goimp_active_workers", "Workers running a fit.")
+)
+
+// ObserveJob records a finished fit of method, its chi-square only counting
+// for successful fits
+func ObserveJob(method string, duration time.Duration, success bool, chiSq float64) {
+	SolveDuration.With(method).Observe(duration.Seconds())
+	if !success {
+		JobsFailed.Inc()
+		return
+	}
+	JobsCompleted.Inc()
+	if !math.IsNaN(chiSq) && !math.IsInf(chiSq, 0) {
+		ChiSquare.Observe(chiSq)
+	}
+}
+
+// ObserveWebhook records the outcome of a webhook delivery
+func ObserveWebhook(err error) {
+	if err != nil {
+		WebhooksFailed.Inc()
+		return
+	}
+	WebhooksDelivered.Inc()
+}
+
+// Handler serves the Default registry
+func Handler() http.Handler {
+	return Default
+}
+
+// Middleware counts the requests to next in HTTPRequests. The endpoint is
+// the pattern of mux routing the request, so that IDs in paths do not make a
+// series each, "other" for the requests mux does not route.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, endpoint := mux.Handler(r)
+		if endpoint == "" {
+			endpoint = "other"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		HTTPRequests.With(endpoint, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
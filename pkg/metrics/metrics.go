@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value, safe for concurrent use
+// without locks
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc adds 1 to the counter
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add adds n to the counter
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// Gauge is a value going up and down, safe for concurrent use without locks
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Inc adds 1 to the gauge
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec subtracts 1 from the gauge
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Set replaces the value of the gauge
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// Histogram counts observations in fixed buckets, safe for concurrent use
+// without locks
+type Histogram struct {
+	bounds []float64       // upper bounds of the buckets, ascending
+	counts []atomic.Uint64 // observations per bucket, the last one above every bound
+	sum    atomic.Uint64   // float64 bits of the sum of the observations
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+// Observe records v in the first bucket whose bound is at least v
+func (h *Histogram) Observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)].Add(1)
+	for {
+		old := h.sum.Load()
+		if h.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// CounterVec is a family of counters told apart by label values
+type CounterVec struct {
+	labels []string
+	series sync.Map // joined label values -> *Counter
+}
+
+// With returns the counter of the label values, in the order of the labels
+// of the family. Existing series are found without taking a lock.
+func (v *CounterVec) With(values ...string) *Counter {
+	key := seriesKey(values)
+	if c, ok := v.series.Load(key); ok {
+		return c.(*Counter)
+	}
+	c, _ := v.series.LoadOrStore(key, &Counter{})
+	return c.(*Counter)
+}
+
+// HistogramVec is a family of histograms told apart by label values
+type HistogramVec struct {
+	labels []string
+	bounds []float64
+	series sync.Map // joined label values -> *Histogram
+}
+
+// With returns the histogram of the label values, in the order of the labels
+// of the family. Existing series are found without taking a lock.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := seriesKey(values)
+	if h, ok := v.series.Load(key); ok {
+		return h.(*Histogram)
+	}
+	h, _ := v.series.LoadOrStore(key, newHistogram(v.bounds))
+	return h.(*Histogram)
+}
+
+// labelSeparator joins label values into series keys, it cannot appear in
+// valid UTF-8
+const labelSeparator = "\xff"
+
+func seriesKey(values []string) string {
+	return strings.Join(values, labelSeparator)
+}
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+// entry is a registered metric family
+type entry struct {
+	name, help, kind string
+	metric           interface{}
+}
+
+// Counter registers and returns a new counter
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// CounterVec registers and returns a new family of counters with labels
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{labels: labels}
+	r.register(name, help, "counter", v)
+	return v
+}
+
+// Gauge registers and returns a new gauge
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Histogram registers and returns a new histogram with the bucket bounds
+func (r *Registry) Histogram(name, help string, bounds []float64) *Histogram {
+	h := newHistogram(bounds)
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// HistogramVec registers and returns a new family of histograms with labels
+func (r *Registry) HistogramVec(name, help string, bounds []float64, labels ...string) *HistogramVec {
+	v := &HistogramVec{labels: labels, bounds: bounds}
+	r.register(name, help, "histogram", v)
+	return v
+}
+
+func (r *Registry) register(name, help, kind string, metric interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		if e.name == name {
+			panic(fmt.Sprintf("metrics: %s registered twice", name))
+		}
+	}
+	r.entries = append(r.entries, entry{name: name, help: help, kind: kind, metric: metric})
+}
+
+// Write writes every metric to w in the Prometheus text exposition format,
+// families in registration order and series sorted by their labels
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	entries := append([]entry(nil), r.entries...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", e.name, e.help, e.name, e.kind)
+		switch m := e.metric.(type) {
+		case *Counter:
+			fmt.Fprintf(bw, "%s %d\n", e.name, m.Value())
+		case *Gauge:
+			fmt.Fprintf(bw, "%s %d\n", e.name, m.Value())
+		case *Histogram:
+			writeHistogram(bw, e.name, "", m)
+		case *CounterVec:
+			for _, s := range sortedSeries(&m.series) {
+				fmt.Fprintf(bw, "%s{%s} %d\n", e.name, formatLabels(m.labels, s.key), s.metric.(*Counter).Value())
+			}
+		case *HistogramVec:
+			for _, s := range sortedSeries(&m.series) {
+				writeHistogram(bw, e.name, formatLabels(m.labels, s.key), s.metric.(*Histogram))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// writeHistogram writes the cumulative buckets, sum and count of h, labels
+// being the formatted labels of its series, empty for none
+func writeHistogram(w io.Writer, name, labels string, h *Histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, prefix, formatFloat(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, cumulative)
+
+	braced := ""
+	if labels != "" {
+		braced = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braced, formatFloat(math.Float64frombits(h.sum.Load())))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braced, cumulative)
+}
+
+type series struct {
+	key    string
+	metric interface{}
+}
+
+func sortedSeries(m *sync.Map) []series {
+	var all []series
+	m.Range(func(key, value interface{}) bool {
+		all = append(all, series{key: key.(string), metric: value})
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].key < all[j].key })
+	return all
+}
+
+// formatLabels pairs the label names with the values joined in key
+func formatLabels(names []string, key string) string {
+	values := strings.Split(key, labelSeparator)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// NDJSONContentType is the media type of newline delimited JSON streams
+const NDJSONContentType = "application/x-ndjson"
+
+// BodyLimitMiddleware caps request bodies at maxBytes. Requests declaring a
+// larger Content-Length get 413 before the next handler runs, the bodies of
+// all others are wrapped in http.MaxBytesReader so reading past the limit
+// fails. NDJSON bodies posted to streamPaths are not capped as a whole, their
+// handler caps each line.
+func BodyLimitMiddleware(maxBytes int64, streamPaths ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if IsNDJSON(r) {
+				for _, path := range streamPaths {
+					if r.URL.Path == path {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			if r.ContentLength > maxBytes {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Connection", "close")
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				w.Write([]byte(`{"error":"Request body exceeds ` + strconv.FormatInt(maxBytes, 10) + ` bytes"}` + "\n"))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsNDJSON reports whether the body of r is an NDJSON stream
+func IsNDJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == NDJSONContentType
+}
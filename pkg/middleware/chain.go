@@ -0,0 +1,17 @@
+package middleware
+
+import "net/http"
+
+// Middleware wraps a handler with processing common to all requests
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares into one, the first one wrapping the others and
+// seeing the request first
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
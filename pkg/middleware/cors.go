@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// Defaults used for empty CORS settings of the ServerConfig
+var (
+	DefaultCORSAllowedOrigins = []string{"*"}
+	DefaultCORSAllowedMethods = []string{"GET", "POST", "DELETE", "OPTIONS"}
+	DefaultCORSAllowHeaders   = []string{"Content-Type", "Idempotency-Key"}
+)
+
+// CORSMiddleware answers cross-origin requests for the origins allowed by cfg,
+// "*" allowing any. Requests from other origins get 403, preflight requests
+// of allowed origins are answered here and never reach the next handler.
+// Requests without an Origin header are not cross-origin and pass through
+// unchanged.
+func CORSMiddleware(cfg *config.ServerConfig) Middleware {
+	origins := orDefault(cfg.CORSAllowedOrigins, DefaultCORSAllowedOrigins)
+	methods := strings.Join(orDefault(cfg.CORSAllowedMethods, DefaultCORSAllowedMethods), ", ")
+	headers := strings.Join(orDefault(cfg.CORSAllowHeaders, DefaultCORSAllowHeaders), ", ")
+
+	anyOrigin := false
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		allowed[strings.TrimRight(o, "/")] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !anyOrigin && !allowed[origin] {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"Origin not allowed"}` + "\n"))
+				return
+			}
+
+			h := w.Header()
+			if anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+			}
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// orDefault returns values, or def when values is empty
+func orDefault(values, def []string) []string {
+	if len(values) == 0 {
+		return def
+	}
+	return values
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	restricted := &config.ServerConfig{
+		CORSAllowedOrigins: []string{"https://app.example.com/"},
+		CORSAllowedMethods: []string{"GET", "POST"},
+		CORSAllowHeaders:   []string{"Content-Type"},
+	}
+	tests := []struct {
+		name        string
+		cfg         *config.ServerConfig
+		method      string
+		origin      string
+		preflight   bool
+		status      int
+		allowOrigin string
+		reached     bool // the request gets to the next handler
+	}{
+		{"allowed origin", restricted, http.MethodPost, "https://app.example.com", false, http.StatusOK, "https://app.example.com", true},
+		{"unallowed origin", restricted, http.MethodPost, "https://evil.example.com", false, http.StatusForbidden, "", false},
+		{"unallowed preflight", restricted, http.MethodOptions, "https://evil.example.com", true, http.StatusForbidden, "", false},
+		{"allowed preflight", restricted, http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", false},
+		{"no origin", restricted, http.MethodGet, "", false, http.StatusOK, "", true},
+		{"default wildcard", &config.ServerConfig{}, http.MethodGet, "https://any.example.com", false, http.StatusOK, "*", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			handler := CORSMiddleware(tt.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(tt.method, "/eis-data", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d", rec.Code, tt.status)
+			}
+			if reached != tt.reached {
+				t.Errorf("next handler reached %v, want %v", reached, tt.reached)
+			}
+			h := rec.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if tt.allowOrigin == "" {
+				return
+			}
+			wantMethods, wantHeaders := "GET, POST", "Content-Type"
+			if tt.allowOrigin == "*" {
+				wantMethods, wantHeaders = "GET, POST, DELETE, OPTIONS", "Content-Type, Idempotency-Key"
+			} else if h.Get("Vary") != "Origin" {
+				t.Errorf("Vary %q, want Origin", h.Get("Vary"))
+			}
+			if got := h.Get("Access-Control-Allow-Methods"); got != wantMethods {
+				t.Errorf("Access-Control-Allow-Methods %q, want %q", got, wantMethods)
+			}
+			if got := h.Get("Access-Control-Allow-Headers"); got != wantHeaders {
+				t.Errorf("Access-Control-Allow-Headers %q, want %q", got, wantHeaders)
+			}
+		})
+	}
+}
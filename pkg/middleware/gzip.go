@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GzipMiddleware decompresses request bodies sent with Content-Encoding: gzip,
+// so that next reads them as if they had been sent uncompressed. Bodies that
+// are not valid gzip get 400 before next runs, other encodings get 415.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		switch encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			w.Write([]byte(`{"error":"Unsupported Content-Encoding, expected gzip"}` + "\n"))
+			return
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Invalid gzip request body"}` + "\n"))
+			return
+		}
+		r.Body = &gzipBody{Reader: zr, body: r.Body}
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1 // unknown once decompressed
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipBody is a decompressed request body, closing it closes the original one
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
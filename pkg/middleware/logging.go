@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// maxRequestIDLength bounds the IDs accepted from clients
+const maxRequestIDLength = 64
+
+// RequestIDMiddleware gives every request an ID, the X-Request-ID of the
+// client when it is a valid one, echoed in the response and carried by the
+// request context to the workers and webhooks, see utils.RequestID. The
+// handlers use it as the request ID of the fits they accept, so that the
+// request log and the processing logs share it.
+func RequestIDMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(utils.RequestIDHeader)
+			if !validRequestID(id) {
+				id = utils.GenerateID()
+			}
+			w.Header().Set(utils.RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(utils.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+// RequestLogger returns the logger of the request log, writing to the
+// standard logger output in cfg.LogFormat, only the failed requests with
+// cfg.QuietRequests
+func RequestLogger(cfg *config.ServerConfig) *slog.Logger {
+	level := slog.LevelInfo
+	if cfg.QuietRequests {
+		level = slog.LevelWarn
+	}
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+			}
+			return a
+		},
+	}
+	if cfg.LogFormat == config.LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(log.Writer(), opts))
+	}
+	return slog.New(slog.NewTextHandler(log.Writer(), opts))
+}
+
+// LoggingMiddleware logs every request once done to logger, at the info
+// level, warn for 4xx and error for 5xx statuses, with its request ID when
+// RequestIDMiddleware runs before it
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stats := serveCounted(next, w, r)
+			logger.LogAttrs(r.Context(), statusLevel(stats.status), "request",
+				slog.String("request_id", utils.RequestID(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", stats.status),
+				slog.Float64("duration_ms", stats.durationMs()),
+				slog.Int64("bytes_in", stats.bytesIn),
+				slog.Int64("bytes_out", stats.bytesOut),
+				slog.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
+
+// requestStats are the figures of a served request
+type requestStats struct {
+	start             time.Time
+	status            int
+	bytesIn, bytesOut int64
+}
+
+func (s requestStats) durationMs() float64 {
+	return float64(time.Since(s.start).Microseconds()) / 1000
+}
+
+// serveCounted serves r with next, counting the bytes read and written
+func serveCounted(next http.Handler, w http.ResponseWriter, r *http.Request) requestStats {
+	start := time.Now()
+	body := &countingReader{ReadCloser: r.Body}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = body
+	}
+	rec := &loggingWriter{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+	return requestStats{start: start, status: rec.status, bytesIn: body.n, bytesOut: rec.bytes}
+}
+
+// statusLevel is the log level of a request answered with status
+func statusLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// validRequestID reports whether a client request ID is short and made of
+// characters safe in logs and file names
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// loggingWriter remembers the status code and counts the bytes written
+// through it
+type loggingWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *loggingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (w *loggingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/kacperjurak/goimpcore/pkg/profiling"
+)
+
+// ProfilingMiddleware profiles every request with p under the name route
+// gives it, typically from the mux pattern serving the request. Requests
+// named "" are served without profiling.
+func ProfilingMiddleware(p *profiling.Middleware, route func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := route(r)
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p.ProfiledHandler(name, next).ServeHTTP(w, r)
+		})
+	}
+}
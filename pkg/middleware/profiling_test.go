@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore/pkg/profiling"
+)
+
+// Requests are profiled under the name of their route, unnamed routes and a
+// disabled profiler leave the response alone
+func TestProfilingMiddleware(t *testing.T) {
+	route := func(r *http.Request) string {
+		if r.URL.Path == "/eis-data" {
+			return "eis-single"
+		}
+		return ""
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		enabled  bool
+		path     string
+		wantName string
+	}{
+		{true, "/eis-data", "eis-single"},
+		{true, "/health", ""},
+		{false, "/eis-data", ""},
+	}
+	for _, tt := range tests {
+		handler := ProfilingMiddleware(profiling.NewMiddleware(tt.enabled), route)(ok)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s, profiling %v: status %d", tt.path, tt.enabled, rec.Code)
+		}
+		if got := rec.Header().Get("X-Handler-Name"); got != tt.wantName {
+			t.Errorf("%s, profiling %v: handler name %q, want %q", tt.path, tt.enabled, got, tt.wantName)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// RateLimiter is a token bucket per client IP. Every bucket holds up to
+// BurstSize tokens and gets one back per 1/RequestsPerSecond, a request takes
+// one token and is answered with 429 when its bucket is empty.
+type RateLimiter struct {
+	RequestsPerSecond float64
+	BurstSize         int
+
+	clients  sync.Map // client IP -> *bucket
+	interval time.Duration
+	nextTick atomic.Int64 // unix nanoseconds of the next refill
+	ticker   *time.Ticker
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type bucket struct {
+	tokens   chan struct{}
+	lastSeen atomic.Int64 // unix nanoseconds
+}
+
+// idleBucketTTL is how long the full bucket of a silent client is kept
+const idleBucketTTL = 10 * time.Minute
+
+// NewRateLimiter creates a rate limiter refilling the buckets in the
+// background until Stop is called, burst is at least 1
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	interval := time.Duration(float64(time.Second) / requestsPerSecond)
+	if interval <= 0 {
+		interval = time.Nanosecond
+	}
+
+	rl := &RateLimiter{
+		RequestsPerSecond: requestsPerSecond,
+		BurstSize:         burst,
+		interval:          interval,
+		ticker:            time.NewTicker(interval),
+		done:              make(chan struct{}),
+	}
+	rl.nextTick.Store(time.Now().Add(interval).UnixNano())
+	go rl.refill()
+	return rl
+}
+
+// RateLimitMiddleware limits the requests of every client IP to
+// cfg.RateLimit per second with bursts of cfg.RateBurst, it limits nothing
+// when cfg.RateLimit is 0. The returned stop function ends the background
+// refill.
+func RateLimitMiddleware(cfg *config.ServerConfig) (Middleware, func()) {
+	if cfg.RateLimit <= 0 {
+		return func(next http.Handler) http.Handler { return next }, func() {}
+	}
+	rl := NewRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	return rl.Middleware, rl.Stop
+}
+
+// Middleware wraps next, health checks are never limited so probes keep
+// working under load
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/health") || rl.Allow(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(rl.retryAfter()))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"Too many requests"}` + "\n"))
+	})
+}
+
+// Allow takes a token from the bucket of ip, false when it is empty
+func (rl *RateLimiter) Allow(ip string) bool {
+	b := rl.bucket(ip)
+	b.lastSeen.Store(time.Now().UnixNano())
+	select {
+	case <-b.tokens:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop ends the background refill
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		rl.ticker.Stop()
+		close(rl.done)
+	})
+}
+
+// bucket returns the bucket of ip, a full one for a new client
+func (rl *RateLimiter) bucket(ip string) *bucket {
+	if b, ok := rl.clients.Load(ip); ok {
+		return b.(*bucket)
+	}
+	b := &bucket{tokens: make(chan struct{}, rl.BurstSize)}
+	for i := 0; i < rl.BurstSize; i++ {
+		b.tokens <- struct{}{}
+	}
+	actual, _ := rl.clients.LoadOrStore(ip, b)
+	return actual.(*bucket)
+}
+
+// refill returns one token to every bucket per tick and forgets clients
+// whose bucket has been full for idleBucketTTL
+func (rl *RateLimiter) refill() {
+	for {
+		select {
+		case now := <-rl.ticker.C:
+			rl.nextTick.Store(now.Add(rl.interval).UnixNano())
+			rl.clients.Range(func(key, value interface{}) bool {
+				b := value.(*bucket)
+				select {
+				case b.tokens <- struct{}{}:
+				default:
+					if now.Sub(time.Unix(0, b.lastSeen.Load())) > idleBucketTTL {
+						rl.clients.Delete(key)
+					}
+				}
+				return true
+			})
+		case <-rl.done:
+			return
+		}
+	}
+}
+
+// retryAfter returns the whole seconds until the next refill, at least 1
+func (rl *RateLimiter) retryAfter() int {
+	wait := time.Until(time.Unix(0, rl.nextTick.Load())).Seconds()
+	return int(math.Max(1, math.Ceil(wait)))
+}
+
+// clientIP returns the IP of the remote end of r, proxy headers are not
+// trusted
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
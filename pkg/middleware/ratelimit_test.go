@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore/pkg/config"
+)
+
+// fire sends n requests to path from ip through handler and returns how many
+// got through and the last response limited with 429
+func fire(handler http.Handler, ip, path string, n int) (int, *httptest.ResponseRecorder) {
+	passed := 0
+	var limited *httptest.ResponseRecorder
+	for i := 0; i < n; i++ {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		switch rec.Code {
+		case http.StatusOK:
+			passed++
+		case http.StatusTooManyRequests:
+			limited = rec
+		}
+	}
+	return passed, limited
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// Of 100 requests fired at once the burst gets through and the rest get 429
+// with a Retry-After, other clients and health checks are not affected
+func TestRateLimitBurst(t *testing.T) {
+	// one token every 10s, none comes back during the test
+	middleware, stop := RateLimitMiddleware(&config.ServerConfig{RateLimit: 0.1, RateBurst: 10})
+	defer stop()
+	handler := middleware(okHandler)
+
+	passed, limited := fire(handler, "192.0.2.1", "/eis-data", 100)
+	if passed != 10 {
+		t.Errorf("%d of 100 requests passed, want the burst of 10", passed)
+	}
+	if limited == nil {
+		t.Fatal("no request got 429")
+	}
+	if retry, err := strconv.Atoi(limited.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 10 {
+		t.Errorf("Retry-After %q, want 1..10 seconds", limited.Header().Get("Retry-After"))
+	}
+	if limited.Body.String() != `{"error":"Too many requests"}`+"\n" {
+		t.Errorf("429 body %q", limited.Body.String())
+	}
+
+	if passed, _ := fire(handler, "192.0.2.2", "/eis-data", 10); passed != 10 {
+		t.Errorf("another client got %d of 10 through", passed)
+	}
+	if passed, _ := fire(handler, "192.0.2.1", "/health", 10); passed != 10 {
+		t.Errorf("%d of 10 health checks passed", passed)
+	}
+}
+
+// A rate limit of 0 limits nothing
+func TestRateLimitDisabled(t *testing.T) {
+	middleware, stop := RateLimitMiddleware(&config.ServerConfig{RateBurst: 1})
+	defer stop()
+	if passed, _ := fire(middleware(okHandler), "192.0.2.1", "/eis-data", 100); passed != 100 {
+		t.Errorf("%d of 100 requests passed", passed)
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/kacperjurak/goimpcore/internal/utils"
+)
+
+// RecoveryMiddleware answers a request whose handler panicked with 500 and
+// logs the panic with its stack, instead of dropping the connection. Panics
+// with http.ErrAbortHandler, which abort a response on purpose, pass through.
+func RecoveryMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("❌ Panic serving %s %s - ID: %s: %v\n%s", r.Method, r.URL.Path, utils.RequestID(r.Context()), err, debug.Stack())
+				// Too late when the handler already wrote its header
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error":"Internal server error"}` + "\n"))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
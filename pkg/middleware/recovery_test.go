@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore/internal/utils"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	handler := RecoveryMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/simulate", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+		t.Errorf("body %q, want a JSON error", rec.Body)
+	}
+}
+
+func TestRecoveryMiddlewareAbortHandler(t *testing.T) {
+	handler := RecoveryMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// The request log carries the ID of RequestIDMiddleware, and a panic is
+// logged with status 500 when the recovery runs inside the logging
+func TestChainLogsRecoveredPanic(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	var out bytes.Buffer
+	handler := Chain(
+		RequestIDMiddleware(),
+		LoggingMiddleware(slog.New(slog.NewJSONHandler(&out, nil))),
+		RecoveryMiddleware(),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/eis-data", nil)
+	req.Header.Set(utils.RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(utils.RequestIDHeader); got != "req-1" {
+		t.Errorf("%s %q, want req-1", utils.RequestIDHeader, got)
+	}
+	var entry struct {
+		Level     string `json:"level"`
+		RequestID string `json:"request_id"`
+		Status    int    `json:"status"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("request log %q: %v", out.String(), err)
+	}
+	if entry.RequestID != "req-1" || entry.Status != http.StatusInternalServerError || entry.Level != "ERROR" {
+		t.Errorf("request log %+v, want request req-1 with status 500 at level ERROR", entry)
+	}
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// TimeoutMiddleware cancels the context of every request d after it arrived,
+// handlers stop at their next check of the context. Unlike
+// http.TimeoutHandler the response is not buffered, so streamed responses
+// keep working until the deadline.
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/kacperjurak/goimpcore"
@@ -8,11 +11,206 @@ import (
 
 // ImpedanceData represents incoming impedance measurement data
 type ImpedanceData struct {
-	Timestamp   string               `json:"timestamp"`
-	Frequencies []float64            `json:"frequencies"`
-	Magnitude   []float64            `json:"magnitude"`
-	Phase       []float64            `json:"phase"`
-	Impedance   []map[string]float64 `json:"impedance"`
+	Timestamp    string               `json:"timestamp"` // acquisition time, RFC 3339
+	Frequencies  []float64            `json:"frequencies"`
+	Magnitude    []float64            `json:"magnitude"`
+	Phase        []float64            `json:"phase"`
+	PhaseUnit    string               `json:"phase_unit,omitempty"` // deg or rad, detected from the values when empty
+	Impedance    []map[string]float64 `json:"impedance"`
+	Sigma        []map[string]float64 `json:"sigma,omitempty"`    // optional standard deviations per point
+	FreqMin      float64              `json:"freq_min,omitempty"` // optional fit window, overrides the server default
+	FreqMax      float64              `json:"freq_max,omitempty"`
+	Robust       bool                 `json:"robust,omitempty"`        // reject outliers, overrides the server default when set
+	Space        string               `json:"space,omitempty"`         // impedance or admittance, overrides the server default when set
+	CircuitCodes []string             `json:"circuit_codes,omitempty"` // candidate circuits to fit and rank by AIC instead of the configured one
+
+	// Fit settings overriding the server defaults when set
+	CircuitCode   string    `json:"circuit_code,omitempty"`
+	InitValues    []float64 `json:"init_values,omitempty"` // one per parameter of the circuit
+	OptimMethod   string    `json:"optim_method,omitempty"`
+	Weighting     string    `json:"weighting,omitempty"`
+	MaxIterations int       `json:"max_iterations,omitempty"` // solver restarts
+
+	// TimeoutSeconds bounds the fit of a batch spectrum in the worker pool,
+	// the server job timeout when 0
+	TimeoutSeconds float64 `json:"timeout_seconds,omitempty"`
+
+	// RequestKey is the idempotency key of an asynchronous request, in place
+	// of the Idempotency-Key header. A retry with the same key is answered
+	// with the request ID of the first one instead of fitting again.
+	RequestKey string `json:"request_key,omitempty"`
+}
+
+// Sigmas returns the per-point standard deviations as {real, imag} pairs,
+// nil when the request carries no uncertainties
+func (d ImpedanceData) Sigmas() [][2]float64 {
+	if len(d.Sigma) == 0 {
+		return nil
+	}
+	sigmas := make([][2]float64, len(d.Sigma))
+	for i, point := range d.Sigma {
+		sigmas[i] = [2]float64{point["real"], point["imag"]}
+	}
+	return sigmas
+}
+
+// MeasuredAt returns the acquisition time of the spectrum parsed from
+// Timestamp, ok is false when the request carries none
+func (d ImpedanceData) MeasuredAt() (t time.Time, ok bool, err error) {
+	if d.Timestamp == "" {
+		return time.Time{}, false, nil
+	}
+	t, err = time.Parse(time.RFC3339Nano, d.Timestamp)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("timestamp %q is not an RFC 3339 time", d.Timestamp)
+	}
+	return t, true, nil
+}
+
+// polarTolerance is the relative difference allowed between the impedance
+// points and their magnitude and phase when a request carries both
+const polarTolerance = 1e-2
+
+// Phase units accepted in ImpedanceData.PhaseUnit
+const (
+	PhaseDegrees = "deg"
+	PhaseRadians = "rad"
+)
+
+// PhasesInDegrees returns the phases in degrees. Without a PhaseUnit they are
+// taken as radians, unless every |phase| exceeds π, which only degrees can.
+func (d ImpedanceData) PhasesInDegrees() ([]float64, error) {
+	unit := d.PhaseUnit
+	if unit == "" {
+		unit = PhaseDegrees
+		for _, p := range d.Phase {
+			if math.Abs(p) <= math.Pi {
+				unit = PhaseRadians
+				break
+			}
+		}
+	}
+
+	switch unit {
+	case PhaseDegrees:
+		return d.Phase, nil
+	case PhaseRadians:
+		degrees := make([]float64, len(d.Phase))
+		for i, p := range d.Phase {
+			degrees[i] = p * 180 / math.Pi
+		}
+		return degrees, nil
+	}
+	return nil, fmt.Errorf("unknown phase_unit %q, expected deg or rad", d.PhaseUnit)
+}
+
+// Points returns the impedance as {real, imag} pairs. Requests may give the
+// impedance, magnitude and phase, or both, in which case they have to agree
+// within polarTolerance. See PhasesInDegrees for the phase unit.
+func (d ImpedanceData) Points() ([][2]float64, error) {
+	n := len(d.Frequencies)
+	hasPolar := len(d.Magnitude) > 0 || len(d.Phase) > 0
+	var phase []float64
+	if hasPolar {
+		if len(d.Magnitude) != n {
+			return nil, fmt.Errorf("magnitude has %d values for %d frequencies", len(d.Magnitude), n)
+		}
+		if len(d.Phase) != n {
+			return nil, fmt.Errorf("phase has %d values for %d frequencies", len(d.Phase), n)
+		}
+		var err error
+		if phase, err = d.PhasesInDegrees(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(d.Impedance) == 0 {
+		if !hasPolar {
+			return nil, fmt.Errorf("no impedance or magnitude and phase provided")
+		}
+		return goimpcore.FromPolar(d.Magnitude, phase), nil
+	}
+	if len(d.Impedance) != n {
+		return nil, fmt.Errorf("impedance has %d values for %d frequencies", len(d.Impedance), n)
+	}
+
+	points := make([][2]float64, n)
+	for i, point := range d.Impedance {
+		re, reOk := point["real"]
+		im, imOk := point["imag"]
+		if !reOk || !imOk {
+			return nil, fmt.Errorf("impedance point %d lacks real or imag", i)
+		}
+		points[i] = [2]float64{re, im}
+	}
+
+	if hasPolar {
+		polar := goimpcore.FromPolar(d.Magnitude, phase)
+		for i, p := range points {
+			diff := math.Hypot(p[0]-polar[i][0], p[1]-polar[i][1])
+			if diff > polarTolerance*math.Hypot(p[0], p[1]) {
+				return nil, fmt.Errorf("impedance and magnitude/phase disagree at %g Hz", d.Frequencies[i])
+			}
+		}
+	}
+	return points, nil
+}
+
+// BodeRequest asks for the Bode plot of a completed request or of inline data.
+// Inline data is fitted with Code unless Params are given.
+type BodeRequest struct {
+	RequestID string `json:"request_id,omitempty"`
+	ImpedanceData
+	Code   string    `json:"code,omitempty"`
+	Params []float64 `json:"params,omitempty"`
+}
+
+// SemicirclePoint is one impedance point of the arc fitted by
+// /analysis/semicircle, zi being negative for capacitive arcs. Frequency is
+// optional, the capacitance of the arc is only estimated when every point
+// has one.
+type SemicirclePoint struct {
+	ZR        float64 `json:"zr"`
+	ZI        float64 `json:"zi"`
+	Frequency float64 `json:"frequency,omitempty"`
+}
+
+// ArrheniusRequest is the body of /analysis/arrhenius and /analysis/vtf, the
+// fits of one spectrum per temperature, in kelvin, of which the parameter
+// ParamIndex is analysed. Only the params of the results are used. Inverse
+// analyses 1/param instead, the conductance of a fitted resistance.
+type ArrheniusRequest struct {
+	Temperatures []float64          `json:"temperatures"`
+	Results      []goimpcore.Result `json:"results"`
+	ParamIndex   int                `json:"param_index"`
+	Inverse      bool               `json:"inverse,omitempty"`
+}
+
+// SimulateRequest is the body of /simulate, the circuit CircuitCode with
+// Parameters evaluated from FreqMin to FreqMax in Hz. PointsPerDecade is
+// goimpcore.DefaultPointsPerDecade when 0. NoiseLevel adds relative noise of
+// the Noise distribution, uniform or gaussian, from Seed, the clock when 0.
+type SimulateRequest struct {
+	CircuitCode     string    `json:"circuit_code"`
+	Parameters      []float64 `json:"parameters"`
+	FreqMin         float64   `json:"freq_min"`
+	FreqMax         float64   `json:"freq_max"`
+	PointsPerDecade int       `json:"points_per_decade,omitempty"`
+	NoiseLevel      float64   `json:"noise_level,omitempty"`
+	Noise           string    `json:"noise,omitempty"`
+	Seed            int64     `json:"seed,omitempty"`
+	IncludeElements bool      `json:"include_elements,omitempty"` // decompose the impedance by element
+}
+
+// SimulateResponse is the simulated spectrum, its impedance in the format of
+// ImpedanceData so that it can be posted to /eis-data as it is. Seed is the
+// seed of the noise, set when noise was added.
+type SimulateResponse struct {
+	CircuitCode       string               `json:"circuit_code"`
+	Frequencies       []float64            `json:"frequencies"`
+	Impedance         []map[string]float64 `json:"impedance"`
+	Seed              int64                `json:"seed,omitempty"`
+	ElementImpedances []ElementImpedance   `json:"element_impedances,omitempty"`
 }
 
 // BatchItem represents a single spectrum with iteration number
@@ -26,8 +224,29 @@ type ImpedanceBatch struct {
 	BatchID   string      `json:"batch_id"`
 	Timestamp time.Time   `json:"timestamp"`
 	Spectra   []BatchItem `json:"spectra"`
+	ChainInit bool        `json:"chain_init,omitempty"` // fit in Iteration order, each spectrum starting from the previous fit
+
+	// IncludeElementImpedances set to false omits the element impedances from
+	// the webhooks of the batch, they dominate the payload of large batches
+	IncludeElementImpedances *bool `json:"include_element_impedances,omitempty"`
+
+	// RequestKey, like the Idempotency-Key header, answers a retry of the
+	// batch, same ID and spectra, with the first one instead of fitting again
+	RequestKey string `json:"request_key,omitempty"`
 }
 
+// ElementImpedancesIncluded reports whether the webhooks of the batch carry
+// element impedances, true unless the request turned them off
+func (b ImpedanceBatch) ElementImpedancesIncluded() bool {
+	return b.IncludeElementImpedances == nil || *b.IncludeElementImpedances
+}
+
+// Initial values of a fit, reported for chained batches
+const (
+	InitDefault = "default" // configured or circuit default initial values
+	InitChained = "chained" // parameters of the previous spectrum's fit
+)
+
 // WorkItem represents a single EIS processing task
 type WorkItem struct {
 	ID        int
@@ -36,8 +255,17 @@ type WorkItem struct {
 	Iteration int
 	Freqs     []float64
 	ImpData   [][2]float64
+	Sigmas    [][2]float64
 	Config    interface{} // Will be properly typed when config package is created
 	StartTime time.Time
+	Context   context.Context // trace context of the request, may be nil
+	// InitSource tells where the initial values of a chained batch fit come
+	// from, InitDefault or InitChained, empty outside chained batches
+	InitSource string
+	MeasuredAt time.Time // acquisition time of the spectrum, zero when unknown
+	// Results, when set, receives the WorkResult instead of the pool's shared
+	// results channel so concurrent batches never see each other's results
+	Results chan<- WorkResult
 }
 
 // WorkResult contains the result of EIS processing
@@ -53,6 +281,12 @@ type WorkResult struct {
 	RealImp        []float64
 	ImagImp        []float64
 	CircuitCode    string
+	InitSource     string          // copied from the WorkItem
+	MeasuredAt     time.Time       // copied from the WorkItem
+	Context        context.Context // trace context of the request, may be nil
+	// Cancelled is set when the job was cancelled before or while it ran,
+	// Result then holds the best parameters found so far, if any
+	Cancelled bool
 }
 
 // WebhookItem represents a webhook task
@@ -66,26 +300,61 @@ type WebhookItem struct {
 	Elements          []string
 	ElementImpedances []ElementImpedance
 	CircuitCode       string
+	Stats             goimpcore.FitStats
+	Quality           goimpcore.FitQuality
+	Residuals         [][2]float64
+	Warnings          []string
+	Ranking           []goimpcore.CircuitCandidate // set for circuit comparisons
+	Sensitivity       []goimpcore.Sensitivity      // set when the sensitivity analysis is enabled
+	InitSource        string                       // set for chained batches
+	Failed            bool                         // the fit ended with status ERROR or TIMEOUT
+	TimedOut          bool                         // the fit was stopped at the job timeout
+	Error             string                       // why a failed fit failed, empty when it did not converge
+	Context           context.Context              // trace context of the request, may be nil
+	InductiveLoop     *goimpcore.InductiveLoopInfo // set when the spectrum has an inductive loop
 }
 
 // ElementImpedance represents impedance data for a circuit element
 type ElementImpedance struct {
 	Name       string               `json:"name"`
+	Path       string               `json:"path,omitempty"` // enclosing groups, see goimpcore.ElementContribution
 	Impedances []map[string]float64 `json:"impedances"`
 }
 
 // WebhookResponse represents the webhook payload structure
 type WebhookResponse struct {
-	ID                 string             `json:"id"`
-	Time               string             `json:"time"`
-	ChiSquare          float64            `json:"chi_square"`
-	RealImpedance      []float64          `json:"real_impedance"`
-	ImaginaryImpedance []float64          `json:"imaginary_impedance"`
-	Frequencies        []float64          `json:"frequencies"`
-	Parameters         []float64          `json:"parameters"`
-	ElementNames       []string           `json:"element_names"`
-	ElementImpedances  []ElementImpedance `json:"element_impedances"`
-	CircuitType        string             `json:"circuit_type"`
+	Type               string                `json:"type"` // EventSpectrumResult
+	ID                 string                `json:"id"`
+	Status             string                `json:"status"`          // StatusCompleted, StatusFailed or StatusTimeout
+	Error              string                `json:"error,omitempty"` // why a failed fit failed
+	Time               string                `json:"time"`
+	ChiSquare          float64               `json:"chi_square"`
+	RealImpedance      []float64             `json:"real_impedance"`
+	ImaginaryImpedance []float64             `json:"imaginary_impedance"`
+	Frequencies        []float64             `json:"frequencies"`
+	Parameters         []float64             `json:"parameters"`
+	NamedParameters    map[string]float64    `json:"named_parameters,omitempty"` // Parameters keyed by goimpcore.ParamLabels
+	ElementNames       []string              `json:"element_names"`
+	ElementImpedances  []ElementImpedance    `json:"element_impedances"`
+	CircuitType        string                `json:"circuit_type"`
+	Formalism          string                `json:"formalism"`
+	FitStats           *goimpcore.FitStats   `json:"fit_stats,omitempty"`
+	FitQuality         *goimpcore.FitQuality `json:"fit_quality,omitempty"`
+	ResidualsReal      []float64             `json:"residuals_real,omitempty"`
+	ResidualsImag      []float64             `json:"residuals_imag,omitempty"`
+	Warnings           []string              `json:"warnings,omitempty"`
+
+	ElementContributions []goimpcore.ElementContrib   `json:"element_contributions,omitempty"` // always impedance, whatever the formalism
+	ParameterInfo        []goimpcore.ParamInfo        `json:"parameter_info,omitempty"`        // names and units of Parameters
+	CircuitRanking       []goimpcore.CircuitCandidate `json:"circuit_ranking,omitempty"`       // candidates of a circuit comparison, best first
+	Sensitivity          []goimpcore.Sensitivity      `json:"sensitivity,omitempty"`           // chi-square sensitivity to each parameter
+	InitSource           string                       `json:"init_source,omitempty"`           // default or chained, for chained batches
+	RequestID            string                       `json:"request_id,omitempty"`            // X-Request-ID of the HTTP request that submitted the fit
+
+	// InductiveLoopDetected and EstimatedL report an inductive loop of the
+	// spectrum, see goimpcore.DetectInductiveLoop
+	InductiveLoopDetected bool     `json:"inductive_loop_detected,omitempty"`
+	EstimatedL            *float64 `json:"estimated_L,omitempty"`
 }
 
 // SpectrumTiming tracks performance metrics for individual spectrum processing
@@ -95,6 +364,52 @@ type SpectrumTiming struct {
 	ChiSquare      float64       `json:"chi_square"`
 	Success        bool          `json:"success"`
 	CircuitCode    string        `json:"circuit_code"`
+	Outliers       int           `json:"outliers"`              // points rejected by the robust mode
+	InitSource     string        `json:"init_source,omitempty"` // default or chained, for chained batches
+	Status         string        `json:"status,omitempty"`      // goimpcore Result status, CANCELLED for cancelled spectra
+}
+
+// ParamSeries is the value of one fitted parameter at every iteration of a
+// batch, nil where the fit failed or its circuit lacks the parameter
+type ParamSeries struct {
+	Name   string     `json:"name"`
+	Values []*float64 `json:"values"`
+}
+
+// BatchSummary is the parameter drift report of a completed batch. The
+// slices are indexed alike, one entry per spectrum in Iteration order.
+type BatchSummary struct {
+	BatchID    string        `json:"batch_id"`
+	Iterations []int         `json:"iterations"`
+	ChiSquare  []*float64    `json:"chi_square"`
+	Success    []bool        `json:"success"`
+	Parameters []ParamSeries `json:"parameters"`
+}
+
+// Webhook event types, sent in the "type" field of every webhook payload
+const (
+	EventSpectrumResult = "spectrum_result"
+	EventBatchComplete  = "batch_complete"
+)
+
+// BatchCompleteEvent is the webhook sent once every spectrum of a batch has
+// been processed, after their own webhooks were queued
+type BatchCompleteEvent struct {
+	Type           string          `json:"type"` // EventBatchComplete
+	BatchID        string          `json:"batch_id"`
+	Time           string          `json:"time"`
+	Spectra        int             `json:"spectra"`
+	Succeeded      int             `json:"succeeded"`
+	Failed         int             `json:"failed"`
+	Cancelled      int             `json:"cancelled"`
+	WallTimeMs     float64         `json:"wall_time_ms"`
+	AvgChiSquare   float64         `json:"avg_chi_square"`            // over the successful fits
+	BestIteration  *int            `json:"best_iteration,omitempty"`  // lowest chi-square, nil when every fit failed
+	WorstIteration *int            `json:"worst_iteration,omitempty"` // highest chi-square of the successful fits
+	Drift          BatchSummary    `json:"drift"`
+	Context        context.Context `json:"-"` // trace context of the request, may be nil
+
+	CancelledIterations []int `json:"cancelled_iterations,omitempty"` // spectra skipped or stopped by a cancellation
 }
 
 // BufferSet contains reusable buffers to reduce allocations
@@ -103,3 +418,93 @@ type BufferSet struct {
 	Imag []float64
 	Imp  [][2]float64
 }
+
+// Statuses of stored results
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running" // intermediate result of a streamed fit
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusTimeout   = "timeout"   // fit stopped at its deadline, synchronous or in the worker pool
+	StatusCancelled = "cancelled" // cancelled through DELETE /jobs/{id}
+)
+
+// FitResult is the outcome of one fit kept for retrieval over HTTP
+type FitResult struct {
+	RequestID   string     `json:"request_id"`
+	BatchID     string     `json:"batch_id,omitempty"`
+	Iteration   int        `json:"iteration"` // position in the batch, 0 outside batches
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	MeasuredAt  *time.Time `json:"measured_at,omitempty"` // acquisition time of the spectrum, from its timestamp
+
+	CircuitCode     string                       `json:"circuit_code,omitempty"`
+	ChiSquare       float64                      `json:"chi_square"`
+	Parameters      []float64                    `json:"parameters,omitempty"`
+	NamedParameters map[string]float64           `json:"named_parameters,omitempty"` // keyed by goimpcore.ParamLabels
+	ParameterInfo   []goimpcore.ParamInfo        `json:"parameter_info,omitempty"`
+	FitStats        *goimpcore.FitStats          `json:"fit_stats,omitempty"`
+	ResidualsReal   []float64                    `json:"residuals_real,omitempty"`
+	ResidualsImag   []float64                    `json:"residuals_imag,omitempty"`
+	Warnings        []string                     `json:"warnings,omitempty"`
+	CircuitRanking  []goimpcore.CircuitCandidate `json:"circuit_ranking,omitempty"`
+	InitSource      string                       `json:"init_source,omitempty"`
+
+	// Measured data, kept for the Bode plot of stored results
+	Frequencies        []float64 `json:"frequencies,omitempty"`
+	RealImpedance      []float64 `json:"real_impedance,omitempty"`
+	ImaginaryImpedance []float64 `json:"imaginary_impedance,omitempty"`
+}
+
+// TimeSeries is the evolution of the fitted parameters over the iterations
+// of a batch. Only spectra whose fit succeeded with every parameter of
+// ParamNames have a row. Params[i][j] is ParamNames[j] at Iterations[i].
+type TimeSeries struct {
+	BatchID    string      `json:"batch_id"`
+	ParamNames []string    `json:"param_names"`
+	Iterations []int       `json:"iterations"`
+	Params     [][]float64 `json:"params"`
+	ChiSquares []float64   `json:"chi_squares"`
+	Timestamps []time.Time `json:"timestamps"` // completion time of each fit
+
+	// ParameterDrift is the total variation of each parameter relative to
+	// its first value, sum(|p[i+1]-p[i]|) / |p[0]|
+	ParameterDrift []float64 `json:"parameter_drift"`
+}
+
+// BatchResult is the outcome of a batch kept for retrieval over HTTP, Results
+// ordered by Iteration once the batch is completed
+type BatchResult struct {
+	BatchID     string              `json:"batch_id"`
+	Status      string              `json:"status"`
+	Spectra     int                 `json:"spectra"`
+	SubmittedAt time.Time           `json:"submitted_at"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+	Results     []FitResult         `json:"results,omitempty"`
+	Summary     *BatchCompleteEvent `json:"summary,omitempty"`
+
+	// TimeSeries holds Results in acquisition order when every spectrum of
+	// the batch has a timestamp, as for time-resolved EIS
+	TimeSeries []TimestampedResult `json:"time_series,omitempty"`
+}
+
+// TimestampedResult is a fit of a time-resolved batch with the acquisition
+// time of its spectrum
+type TimestampedResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Result    FitResult `json:"result"`
+}
+
+// Evolution is the fitted parameters of a time-resolved batch as functions of
+// the acquisition time, ready for plotting. Params[j][i] is ParamNames[j] at
+// Timestamps[i], only the fits that succeeded with every parameter are kept.
+type Evolution struct {
+	BatchID    string      `json:"batch_id"`
+	ParamNames []string    `json:"param_names"`
+	Timestamps []time.Time `json:"timestamps"`
+	Iterations []int       `json:"iterations"`
+	Params     [][]float64 `json:"params"`
+	ChiSquares []float64   `json:"chi_squares"`
+}
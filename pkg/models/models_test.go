@@ -0,0 +1,134 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+)
+
+// polarSpectrum is the R(QR) spectrum from 1 Hz to 100 kHz with its points,
+// phases in degrees
+func polarSpectrum(t *testing.T) (data ImpedanceData, points [][2]float64, degrees []float64) {
+	t.Helper()
+	freqs, points, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Frequencies = freqs
+	for _, z := range points {
+		data.Magnitude = append(data.Magnitude, math.Hypot(z[0], z[1]))
+		degrees = append(degrees, math.Atan2(z[1], z[0])*180/math.Pi)
+	}
+	return data, points, degrees
+}
+
+func TestPhasesInDegrees(t *testing.T) {
+	tests := []struct {
+		name  string
+		unit  string
+		phase []float64
+		want  []float64
+	}{
+		{"radians detected", "", []float64{-0.5, -1, -1.5}, []float64{-0.5 * 180 / math.Pi, -180 / math.Pi, -1.5 * 180 / math.Pi}},
+		{"degrees detected", "", []float64{-10, -45, -80}, []float64{-10, -45, -80}},
+		// One phase within ±π makes them all radians
+		{"mixed magnitudes", "", []float64{-3, -45}, []float64{-3 * 180 / math.Pi, -45 * 180 / math.Pi}},
+		{"degrees given", PhaseDegrees, []float64{-1, -2}, []float64{-1, -2}},
+		{"radians given", PhaseRadians, []float64{-10}, []float64{-10 * 180 / math.Pi}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImpedanceData{Phase: tt.phase, PhaseUnit: tt.unit}.PhasesInDegrees()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.want {
+				if math.Abs(got[i]-tt.want[i]) > 1e-12 {
+					t.Fatalf("phases %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+	if _, err := (ImpedanceData{Phase: []float64{1}, PhaseUnit: "grad"}).PhasesInDegrees(); err == nil {
+		t.Error("no error for an unknown phase unit")
+	}
+}
+
+// Magnitude and phase in either unit convert back to the impedance points
+func TestPointsFromPolar(t *testing.T) {
+	data, want, degrees := polarSpectrum(t)
+	radians := make([]float64, len(degrees))
+	for i, d := range degrees {
+		radians[i] = d * math.Pi / 180
+	}
+
+	// R(QR) stays below 90°, so its phases in degrees need the explicit unit
+	tests := []struct {
+		name  string
+		unit  string
+		phase []float64
+	}{
+		{"radians detected", "", radians},
+		{"radians", PhaseRadians, radians},
+		{"degrees", PhaseDegrees, degrees},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := data
+			d.Phase, d.PhaseUnit = tt.phase, tt.unit
+			got, err := d.Points()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range want {
+				if math.Hypot(got[i][0]-want[i][0], got[i][1]-want[i][1]) > 1e-12*math.Hypot(want[i][0], want[i][1]) {
+					t.Fatalf("point %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+
+	// Phases beyond ±π are degrees without a unit
+	inductive := ImpedanceData{Frequencies: []float64{1, 10}, Magnitude: []float64{2, 4}, Phase: []float64{60, -150}}
+	got, err := inductive.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPoints := [][2]float64{{1, math.Sqrt(3)}, {-2 * math.Sqrt(3), -2}}
+	for i := range wantPoints {
+		if math.Hypot(got[i][0]-wantPoints[i][0], got[i][1]-wantPoints[i][1]) > 1e-12 {
+			t.Errorf("point %d = %v, want %v", i, got[i], wantPoints[i])
+		}
+	}
+}
+
+func TestPointsErrors(t *testing.T) {
+	data, points, degrees := polarSpectrum(t)
+	data.Phase, data.PhaseUnit = degrees, PhaseDegrees
+	for _, z := range points {
+		data.Impedance = append(data.Impedance, map[string]float64{"real": z[0], "imag": z[1]})
+	}
+	if _, err := data.Points(); err != nil {
+		t.Fatalf("agreeing impedance and polar data: %v", err)
+	}
+
+	disagree := data
+	disagree.Magnitude = append([]float64(nil), data.Magnitude...)
+	disagree.Magnitude[2] *= 1.1
+	shortPhase := data
+	shortPhase.Phase = degrees[1:]
+	tests := []struct {
+		name string
+		data ImpedanceData
+	}{
+		{"neither", ImpedanceData{Frequencies: data.Frequencies}},
+		{"disagreeing", disagree},
+		{"phase length", shortPhase},
+	}
+	for _, tt := range tests {
+		if _, err := tt.data.Points(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
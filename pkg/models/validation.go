@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// MinFrequencies is the number of points below which a fit is not meaningful
+const MinFrequencies = 5
+
+// ValidationError describes a problem with one field of a request
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidateImpedanceData checks a spectrum before it is queued for fitting and
+// returns every problem found, nil for valid data. The impedance may be given
+// as real/imag points or as magnitude and phase.
+func ValidateImpedanceData(data ImpedanceData) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	n := len(data.Frequencies)
+	switch {
+	case n == 0:
+		add("frequencies", "no frequencies provided")
+	case n < MinFrequencies:
+		add("frequencies", "at least %d frequencies are required, got %d", MinFrequencies, n)
+	}
+
+	increasing, decreasing := true, true
+	for i, f := range data.Frequencies {
+		if math.IsNaN(f) || math.IsInf(f, 0) || f <= 0 {
+			add(fmt.Sprintf("frequencies[%d]", i), "must be a positive finite number, got %v", f)
+		}
+		if i > 0 {
+			increasing = increasing && f > data.Frequencies[i-1]
+			decreasing = decreasing && f < data.Frequencies[i-1]
+		}
+	}
+	if n > 1 && !increasing && !decreasing {
+		add("frequencies", "must be strictly increasing or decreasing")
+	}
+
+	if len(data.Impedance) == 0 && (len(data.Magnitude) > 0 || len(data.Phase) > 0) {
+		validateSeries(add, "magnitude", data.Magnitude, n)
+		validateSeries(add, "phase", data.Phase, n)
+	} else if len(data.Impedance) == 0 {
+		add("impedance", "neither impedance nor magnitude and phase provided")
+	} else {
+		if len(data.Impedance) != n {
+			add("impedance", "has %d points for %d frequencies", len(data.Impedance), n)
+		}
+		validatePoints(add, "impedance", data.Impedance)
+	}
+
+	if data.PhaseUnit != "" && data.PhaseUnit != PhaseDegrees && data.PhaseUnit != PhaseRadians {
+		add("phase_unit", "must be %q or %q, got %q", PhaseDegrees, PhaseRadians, data.PhaseUnit)
+	}
+
+	if _, _, err := data.MeasuredAt(); err != nil {
+		add("timestamp", "%v", err)
+	}
+
+	if len(data.Sigma) > 0 {
+		if len(data.Sigma) != n {
+			add("sigma", "has %d points for %d frequencies", len(data.Sigma), n)
+		}
+		validatePoints(add, "sigma", data.Sigma)
+	}
+
+	return errs
+}
+
+// ValidateImpedanceBatch validates every spectrum of batch, fields are
+// prefixed with the spectrum position, e.g. spectra[2].frequencies.
+// Iteration numbers may start anywhere and have gaps but must be unique.
+func ValidateImpedanceBatch(batch ImpedanceBatch) []ValidationError {
+	if len(batch.Spectra) == 0 {
+		return []ValidationError{{Field: "spectra", Message: "no spectra provided"}}
+	}
+
+	var errs []ValidationError
+	first := make(map[int]int, len(batch.Spectra))
+	for i, item := range batch.Spectra {
+		if j, dup := first[item.Iteration]; dup {
+			errs = append(errs, ValidationError{
+				Field:   fmt.Sprintf("spectra[%d].iteration", i),
+				Message: fmt.Sprintf("duplicates iteration %d of spectra[%d]", item.Iteration, j),
+			})
+		} else {
+			first[item.Iteration] = i
+		}
+		for _, e := range ValidateImpedanceData(item.ImpedanceData) {
+			e.Field = fmt.Sprintf("spectra[%d].%s", i, e.Field)
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// validatePoints checks that every point has finite real and imag values
+func validatePoints(add func(field, format string, args ...interface{}), field string, points []map[string]float64) {
+	for i, point := range points {
+		for _, key := range []string{"real", "imag"} {
+			v, ok := point[key]
+			switch {
+			case !ok:
+				add(fmt.Sprintf("%s[%d]", field, i), "missing %q", key)
+			case math.IsNaN(v) || math.IsInf(v, 0):
+				add(fmt.Sprintf("%s[%d].%s", field, i, key), "must be finite, got %v", v)
+			}
+		}
+	}
+}
+
+// validateSeries checks that values has one finite value per frequency
+func validateSeries(add func(field, format string, args ...interface{}), field string, values []float64, n int) {
+	if len(values) != n {
+		add(field, "has %d values for %d frequencies", len(values), n)
+	}
+	for i, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			add(fmt.Sprintf("%s[%d]", field, i), "must be finite, got %v", v)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// validData is a valid spectrum of n points from 1 Hz upwards
+func validData(n int) ImpedanceData {
+	data := ImpedanceData{}
+	for i := 0; i < n; i++ {
+		data.Frequencies = append(data.Frequencies, math.Pow(10, float64(i)))
+		data.Impedance = append(data.Impedance, map[string]float64{"real": 100, "imag": -10})
+	}
+	return data
+}
+
+func TestValidateImpedanceData(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(d *ImpedanceData)
+		want   []ValidationError
+	}{
+		{"no frequencies", func(d *ImpedanceData) { *d = ImpedanceData{Impedance: d.Impedance} },
+			[]ValidationError{{"frequencies", "no frequencies provided"}, {"impedance", "has 5 points for 0 frequencies"}}},
+		{"too few frequencies", func(d *ImpedanceData) { d.Frequencies, d.Impedance = d.Frequencies[:3], d.Impedance[:3] },
+			[]ValidationError{{"frequencies", "at least 5 frequencies are required, got 3"}}},
+		{"zero frequency", func(d *ImpedanceData) { d.Frequencies[0] = 0 },
+			[]ValidationError{{"frequencies[0]", "must be a positive finite number, got 0"}}},
+		{"negative frequency", func(d *ImpedanceData) { d.Frequencies = []float64{-1, -10, -100, -1000, -1e4} },
+			[]ValidationError{
+				{"frequencies[0]", "must be a positive finite number, got -1"},
+				{"frequencies[1]", "must be a positive finite number, got -10"},
+				{"frequencies[2]", "must be a positive finite number, got -100"},
+				{"frequencies[3]", "must be a positive finite number, got -1000"},
+				{"frequencies[4]", "must be a positive finite number, got -10000"},
+			}},
+		{"infinite frequency", func(d *ImpedanceData) { d.Frequencies[4] = math.Inf(1) },
+			[]ValidationError{{"frequencies[4]", "must be a positive finite number, got +Inf"}}},
+		{"unsorted frequencies", func(d *ImpedanceData) { d.Frequencies[1], d.Frequencies[2] = d.Frequencies[2], d.Frequencies[1] },
+			[]ValidationError{{"frequencies", "must be strictly increasing or decreasing"}}},
+		{"repeated frequency", func(d *ImpedanceData) { d.Frequencies[2] = d.Frequencies[1] },
+			[]ValidationError{{"frequencies", "must be strictly increasing or decreasing"}}},
+		{"fewer impedance points", func(d *ImpedanceData) { d.Impedance = d.Impedance[:4] },
+			[]ValidationError{{"impedance", "has 4 points for 5 frequencies"}}},
+		{"no impedance", func(d *ImpedanceData) { d.Impedance = nil },
+			[]ValidationError{{"impedance", "neither impedance nor magnitude and phase provided"}}},
+		{"missing imag", func(d *ImpedanceData) { d.Impedance[3] = map[string]float64{"real": 1} },
+			[]ValidationError{{"impedance[3]", `missing "imag"`}}},
+		{"missing real", func(d *ImpedanceData) { d.Impedance[0] = map[string]float64{"re": 1, "imag": 1} },
+			[]ValidationError{{"impedance[0]", `missing "real"`}}},
+		{"NaN real", func(d *ImpedanceData) { d.Impedance[2] = map[string]float64{"real": math.NaN(), "imag": 1} },
+			[]ValidationError{{"impedance[2].real", "must be finite, got NaN"}}},
+		{"infinite imag", func(d *ImpedanceData) { d.Impedance[1] = map[string]float64{"real": 1, "imag": math.Inf(-1)} },
+			[]ValidationError{{"impedance[1].imag", "must be finite, got -Inf"}}},
+		{"magnitude without phase", func(d *ImpedanceData) { d.Impedance, d.Magnitude = nil, []float64{1, 2, 3, 4, 5} },
+			[]ValidationError{{"phase", "has 0 values for 5 frequencies"}}},
+		{"unknown phase unit", func(d *ImpedanceData) { d.PhaseUnit = "grad" },
+			[]ValidationError{{"phase_unit", `must be "deg" or "rad", got "grad"`}}},
+		{"bad timestamp", func(d *ImpedanceData) { d.Timestamp = "yesterday" },
+			[]ValidationError{{"timestamp", `timestamp "yesterday" is not an RFC 3339 time`}}},
+		{"sigma length", func(d *ImpedanceData) { d.Sigma = d.Impedance[:2] },
+			[]ValidationError{{"sigma", "has 2 points for 5 frequencies"}}},
+	}
+	if errs := ValidateImpedanceData(validData(5)); errs != nil {
+		t.Fatalf("valid data: %v", errs)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := validData(5)
+			tt.modify(&data)
+			if got := ValidateImpedanceData(data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateImpedanceBatch(t *testing.T) {
+	bad := validData(5)
+	bad.Frequencies[0] = -1
+	batch := ImpedanceBatch{Spectra: []BatchItem{
+		{ImpedanceData: validData(5), Iteration: 3},
+		{ImpedanceData: bad, Iteration: 7},
+		{ImpedanceData: validData(5), Iteration: 3},
+	}}
+	want := []ValidationError{
+		{"spectra[1].frequencies[0]", "must be a positive finite number, got -1"},
+		{"spectra[2].iteration", "duplicates iteration 3 of spectra[0]"},
+	}
+	if got := ValidateImpedanceBatch(batch); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if got := ValidateImpedanceBatch(ImpedanceBatch{}); len(got) != 1 || got[0].Field != "spectra" {
+		t.Errorf("empty batch: %v", got)
+	}
+}
@@ -0,0 +1,60 @@
+package plot
+
+import (
+	"math"
+)
+
+// Image formats selected with the -format flag
+const (
+	FormatNyquist = "nyquist"
+	FormatBode    = "bode"
+)
+
+// BodePlot holds the series of a Bode plot together with the raw impedance
+type BodePlot struct {
+	Frequencies []float64 `json:"frequencies"`
+	Magnitude   []float64 `json:"magnitude"` // |Z| in Ohms
+	Phase       []float64 `json:"phase"`     // degrees, negative for capacitive behaviour
+	ZReal       []float64 `json:"z_real"`
+	ZImag       []float64 `json:"z_imag"`
+}
+
+// FittedBodePlot holds the measured spectrum and the spectrum of the fitted circuit
+type FittedBodePlot struct {
+	Code     string    `json:"code,omitempty"`
+	Params   []float64 `json:"params,omitempty"`
+	Measured BodePlot  `json:"measured"`
+	Fitted   *BodePlot `json:"fitted,omitempty"`
+}
+
+// BodeData converts impedance data into Bode plot series
+func BodeData(freqs []float64, impData [][2]float64) BodePlot {
+	if len(freqs) != len(impData) {
+		panic("plot: slice length mismatch")
+	}
+	p := BodePlot{
+		Frequencies: make([]float64, len(freqs)),
+		Magnitude:   make([]float64, len(impData)),
+		Phase:       make([]float64, len(impData)),
+		ZReal:       make([]float64, len(impData)),
+		ZImag:       make([]float64, len(impData)),
+	}
+	copy(p.Frequencies, freqs)
+	for i, z := range impData {
+		p.Magnitude[i] = math.Hypot(z[0], z[1])
+		p.Phase[i] = math.Atan2(z[1], z[0]) * 180 / math.Pi
+		p.ZReal[i] = z[0]
+		p.ZImag[i] = z[1]
+	}
+	return p
+}
+
+// FittedBodeData builds a FittedBodePlot, fitted may be nil when there is no fit
+func FittedBodeData(freqs []float64, measured, fitted [][2]float64) FittedBodePlot {
+	p := FittedBodePlot{Measured: BodeData(freqs, measured)}
+	if fitted != nil {
+		f := BodeData(freqs, fitted)
+		p.Fitted = &f
+	}
+	return p
+}
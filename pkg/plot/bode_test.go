@@ -0,0 +1,44 @@
+package plot
+
+import (
+	"math"
+	"testing"
+)
+
+// A pure capacitor falls by 20 dB a decade at a constant phase of -90°
+func TestBodeDataCapacitor(t *testing.T) {
+	const c = 1e-6
+	var freqs []float64
+	var impData [][2]float64
+	for e := -2; e <= 6; e++ {
+		f := math.Pow(10, float64(e))
+		freqs = append(freqs, f)
+		impData = append(impData, [2]float64{0, -1 / (2 * math.Pi * f * c)})
+	}
+
+	p := BodeData(freqs, impData)
+	for i := range freqs {
+		if math.Abs(p.Phase[i]+90) > 1e-9 {
+			t.Errorf("phase at %v Hz = %v°, want -90°", freqs[i], p.Phase[i])
+		}
+		if i == 0 {
+			continue
+		}
+		slope := 20 * math.Log10(p.Magnitude[i]/p.Magnitude[i-1]) / math.Log10(freqs[i]/freqs[i-1])
+		if math.Abs(slope+20) > 1e-9 {
+			t.Errorf("slope at %v Hz = %v dB/decade, want -20", freqs[i], slope)
+		}
+	}
+}
+
+func TestFittedBodeData(t *testing.T) {
+	freqs := []float64{1, 10}
+	measured := [][2]float64{{3, -4}, {1, 0}}
+	if p := FittedBodeData(freqs, measured, nil); p.Fitted != nil {
+		t.Errorf("fitted series without a fit")
+	}
+	p := FittedBodeData(freqs, measured, measured)
+	if p.Fitted == nil || p.Fitted.Magnitude[0] != 5 || p.Measured.Magnitude[0] != 5 {
+		t.Errorf("magnitudes %v and %v, want 5 at 1 Hz", p.Measured.Magnitude, p.Fitted)
+	}
+}
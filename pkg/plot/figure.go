@@ -0,0 +1,201 @@
+package plot
+
+import (
+	"fmt"
+	"math"
+)
+
+// Panel layout in pixels
+const (
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 20
+	marginBottom = 45
+	panelGap     = 50
+)
+
+// Series colors
+const (
+	measuredColor = "#1f77b4"
+	fittedColor   = "#d62728"
+)
+
+// figure is a plot laid out in pixels, rendered by writeSVG or writePNG
+type figure struct {
+	width, height int
+	xLabel        string
+	panels        []panel
+}
+
+type panel struct {
+	left, top, width, height int
+	xMin, xMax, yMin, yMax   float64
+	logX                     bool // x values are log10 already, grid lines are drawn at decades
+	yLabel                   string
+	series                   []series
+}
+
+// series is one data set of a panel, drawn as markers or as a line
+type series struct {
+	xs, ys []float64
+	color  string
+	line   bool
+}
+
+func (p panel) x(v float64) float64 {
+	return float64(p.left) + (v-p.xMin)/(p.xMax-p.xMin)*float64(p.width)
+}
+
+func (p panel) y(v float64) float64 {
+	return float64(p.top+p.height) - (v-p.yMin)/(p.yMax-p.yMin)*float64(p.height)
+}
+
+// xTicks returns the decades within the x range of log panels, the ends and
+// the middle of linear ones
+func (p panel) xTicks() []float64 {
+	if !p.logX {
+		return []float64{p.xMin, (p.xMin + p.xMax) / 2, p.xMax}
+	}
+	var ticks []float64
+	for d := math.Ceil(p.xMin); d <= p.xMax; d++ {
+		ticks = append(ticks, d)
+	}
+	return ticks
+}
+
+func (p panel) yTicks() []float64 {
+	return []float64{p.yMin, (p.yMin + p.yMax) / 2, p.yMax}
+}
+
+// bodeFigure lays out p as two stacked panels, log|Z| and phase against log f
+func bodeFigure(p FittedBodePlot, width, height int) (figure, error) {
+	if len(p.Measured.Frequencies) == 0 {
+		return figure{}, fmt.Errorf("plot: no data points")
+	}
+
+	panelHeight := (height - marginTop - marginBottom - panelGap) / 2
+	if panelHeight <= 0 || width <= marginLeft+marginRight {
+		return figure{}, fmt.Errorf("plot: image size %dx%d is too small", width, height)
+	}
+
+	data := []BodePlot{p.Measured}
+	if p.Fitted != nil {
+		data = append(data, *p.Fitted)
+	}
+
+	xMin, xMax := logRange(p.Measured.Frequencies)
+	magMin, magMax := math.Inf(1), math.Inf(-1)
+	phaseMin, phaseMax := math.Inf(1), math.Inf(-1)
+	for _, s := range data {
+		lo, hi := logRange(s.Magnitude)
+		magMin, magMax = math.Min(magMin, lo), math.Max(magMax, hi)
+		lo, hi = valueRange(s.Phase)
+		phaseMin, phaseMax = math.Min(phaseMin, lo), math.Max(phaseMax, hi)
+	}
+
+	mag := panel{left: marginLeft, top: marginTop, width: width - marginLeft - marginRight, height: panelHeight,
+		xMin: xMin, xMax: xMax, yMin: magMin, yMax: magMax, logX: true, yLabel: "log10 |Z| [Ohm]"}
+	phase := panel{left: marginLeft, top: marginTop + panelHeight + panelGap, width: mag.width, height: panelHeight,
+		xMin: xMin, xMax: xMax, yMin: phaseMin, yMax: phaseMax, logX: true, yLabel: "Phase [deg]"}
+
+	for i, s := range data {
+		logF := log10s(s.Frequencies)
+		color := measuredColor
+		if i > 0 {
+			color = fittedColor
+		}
+		mag.series = append(mag.series, series{xs: logF, ys: log10s(s.Magnitude), color: color, line: i > 0})
+		phase.series = append(phase.series, series{xs: logF, ys: s.Phase, color: color, line: i > 0})
+	}
+
+	return figure{width: width, height: height, xLabel: "log10 f [Hz]", panels: []panel{mag, phase}}, nil
+}
+
+// nyquistFigure lays out p as the negated imaginary part of the impedance
+// against the real part with equal scales on both axes, the imaginary part
+// itself when flip is false
+func nyquistFigure(p FittedBodePlot, width, height int, flip bool) (figure, error) {
+	if len(p.Measured.Frequencies) == 0 {
+		return figure{}, fmt.Errorf("plot: no data points")
+	}
+
+	pw, ph := width-marginLeft-marginRight, height-marginTop-marginBottom
+	if pw <= 0 || ph <= 0 {
+		return figure{}, fmt.Errorf("plot: image size %dx%d is too small", width, height)
+	}
+
+	data := []BodePlot{p.Measured}
+	if p.Fitted != nil {
+		data = append(data, *p.Fitted)
+	}
+
+	yLabel := "Z'' [Ohm]"
+	if flip {
+		yLabel = "-Z'' [Ohm]"
+	}
+	nyquist := panel{left: marginLeft, top: marginTop, width: pw, height: ph, yLabel: yLabel}
+
+	var allX, allY []float64
+	for i, s := range data {
+		ys := make([]float64, len(s.ZImag))
+		for j, v := range s.ZImag {
+			if flip {
+				v = -v
+			}
+			ys[j] = v
+		}
+		color := measuredColor
+		if i > 0 {
+			color = fittedColor
+		}
+		nyquist.series = append(nyquist.series, series{xs: s.ZReal, ys: ys, color: color, line: i > 0})
+		allX = append(allX, s.ZReal...)
+		allY = append(allY, ys...)
+	}
+
+	// Equal aspect ratio: both ranges are widened around their centres to the
+	// scale of the tighter one
+	xMin, xMax := valueRange(allX)
+	yMin, yMax := valueRange(allY)
+	scale := math.Min(float64(pw)/(xMax-xMin), float64(ph)/(yMax-yMin))
+	xPad := (float64(pw)/scale - (xMax - xMin)) / 2
+	yPad := (float64(ph)/scale - (yMax - yMin)) / 2
+	nyquist.xMin, nyquist.xMax = xMin-xPad, xMax+xPad
+	nyquist.yMin, nyquist.yMax = yMin-yPad, yMax+yPad
+
+	return figure{width: width, height: height, xLabel: "Z' [Ohm]", panels: []panel{nyquist}}, nil
+}
+
+// logRange returns the range of log10 of the positive values, padded so it is never empty
+func logRange(values []float64) (float64, float64) {
+	return valueRange(log10s(values))
+}
+
+// valueRange returns the range of the finite values, padded so it is never empty
+func valueRange(values []float64) (float64, float64) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if finite(v) {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return 0, 1
+	}
+	if hi-lo < 1e-9 {
+		return lo - 0.5, hi + 0.5
+	}
+	return lo, hi
+}
+
+func log10s(values []float64) []float64 {
+	res := make([]float64, len(values))
+	for i, v := range values {
+		res[i] = math.Log10(v)
+	}
+	return res
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
@@ -0,0 +1,87 @@
+package plot
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// writePNG rasterizes f. The standard library has no font rendering, so PNG
+// images carry the frames, grid and data only, use SVG for labelled axes.
+func (f figure) writePNG(w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, f.width, f.height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	grid := color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	for _, p := range f.panels {
+		for _, t := range p.xTicks() {
+			x := p.x(t)
+			drawLine(img, x, float64(p.top), x, float64(p.top+p.height), grid)
+		}
+
+		left, top := float64(p.left), float64(p.top)
+		right, bottom := float64(p.left+p.width), float64(p.top+p.height)
+		drawLine(img, left, top, right, top, color.Black)
+		drawLine(img, right, top, right, bottom, color.Black)
+		drawLine(img, right, bottom, left, bottom, color.Black)
+		drawLine(img, left, bottom, left, top, color.Black)
+
+		for _, s := range p.series {
+			c := parseHexColor(s.color)
+			prevOK := false
+			var px, py float64
+			for i := range s.xs {
+				if !finite(s.xs[i]) || !finite(s.ys[i]) {
+					prevOK = false
+					continue
+				}
+				x, y := p.x(s.xs[i]), p.y(s.ys[i])
+				if !s.line {
+					drawCircle(img, x, y, 2.5, c)
+				} else if prevOK {
+					drawLine(img, px, py, x, y, c)
+				}
+				px, py, prevOK = x, y, true
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// drawLine draws a one pixel line from (x0, y0) to (x1, y1)
+func drawLine(img *image.RGBA, x0, y0, x1, y1 float64, c color.Color) {
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	if steps == 0 {
+		img.Set(int(math.Round(x0)), int(math.Round(y0)), c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		img.Set(int(math.Round(x0+t*(x1-x0))), int(math.Round(y0+t*(y1-y0))), c)
+	}
+}
+
+// drawCircle draws the outline of a circle of radius r around (cx, cy)
+func drawCircle(img *image.RGBA, cx, cy, r float64, c color.Color) {
+	steps := int(math.Ceil(2 * math.Pi * r * 2))
+	for i := 0; i < steps; i++ {
+		a := 2 * math.Pi * float64(i) / float64(steps)
+		img.Set(int(math.Round(cx+r*math.Cos(a))), int(math.Round(cy+r*math.Sin(a))), c)
+	}
+}
+
+// parseHexColor parses a #rrggbb color, black when it cannot be parsed
+func parseHexColor(s string) color.Color {
+	if len(s) != 7 || s[0] != '#' {
+		return color.Black
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.Black
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
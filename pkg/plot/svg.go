@@ -0,0 +1,169 @@
+package plot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Image encodings written by GeneratePlot, chosen by the file extension
+const (
+	EncodingSVG = "svg"
+	EncodingPNG = "png"
+)
+
+// Options configures GeneratePlot
+type Options struct {
+	Path   string // .svg or .png
+	Format string // FormatNyquist or FormatBode
+	DPI    uint
+	Size   uint // edge length of the square image in inches
+	Flip   bool // plot -Z'' upwards in Nyquist plots
+}
+
+// EncodingOf returns the image encoding of path by its extension
+func EncodingOf(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".svg":
+		return EncodingSVG, nil
+	case ".png":
+		return EncodingPNG, nil
+	}
+	return "", fmt.Errorf("plot: unsupported image file %q, expected .svg or .png", path)
+}
+
+// GeneratePlot writes the Nyquist or Bode plot of the observed spectrum and
+// the fitted one to opts.Path, fitted may be nil when there is no fit
+func GeneratePlot(opts Options, freqs []float64, observed, fitted [][2]float64) error {
+	encoding, err := EncodingOf(opts.Path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(opts.Path)
+	if err != nil {
+		return err
+	}
+	if err := WritePlot(f, encoding, opts, freqs, observed, fitted); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// WritePlot is GeneratePlot writing to w in encoding, opts.Path is not used
+func WritePlot(w io.Writer, encoding string, opts Options, freqs []float64, observed, fitted [][2]float64) error {
+	size := int(opts.Size * opts.DPI)
+	p := FittedBodeData(freqs, observed, fitted)
+
+	var (
+		fig figure
+		err error
+	)
+	switch opts.Format {
+	case FormatBode:
+		fig, err = bodeFigure(p, size, size)
+	case FormatNyquist, "":
+		fig, err = nyquistFigure(p, size, size, opts.Flip)
+	default:
+		err = fmt.Errorf("plot: unknown format %q, expected nyquist or bode", opts.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if encoding == EncodingPNG {
+		return fig.writePNG(w)
+	}
+	return fig.writeSVG(w)
+}
+
+// WriteBodeSVG renders p as two stacked panels, log|Z| and phase against log f.
+// Measured points are drawn as markers, the fitted spectrum as a line.
+func WriteBodeSVG(w io.Writer, p FittedBodePlot, width, height int) error {
+	fig, err := bodeFigure(p, width, height)
+	if err != nil {
+		return err
+	}
+	return fig.writeSVG(w)
+}
+
+// SaveBodeSVG writes the Bode plot of p to path
+func SaveBodeSVG(path string, p FittedBodePlot, width, height int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteBodeSVG(f, p, width, height); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (f figure) writeSVG(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", f.width, f.height, f.width, f.height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", f.width, f.height)
+
+	for _, p := range f.panels {
+		p.axesSVG(&b)
+		for _, s := range p.series {
+			if s.line {
+				p.lineSVG(&b, s.xs, s.ys, s.color)
+			} else {
+				p.markersSVG(&b, s.xs, s.ys, s.color)
+			}
+		}
+	}
+	if len(f.panels) > 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="12" text-anchor="middle">%s</text>`+"\n",
+			marginLeft+f.panels[0].width/2, f.height-10, escapeXML(f.xLabel))
+	}
+
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (p panel) axesSVG(b *strings.Builder) {
+	fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="black"/>`+"\n",
+		p.left, p.top, p.width, p.height)
+	for _, t := range p.yTicks() {
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">%.3g</text>`+"\n",
+			p.left-5, p.y(t)+3, t)
+	}
+	for _, t := range p.xTicks() {
+		fmt.Fprintf(b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#ddd"/>`+"\n",
+			p.x(t), p.top, p.x(t), p.top+p.height)
+		fmt.Fprintf(b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%.3g</text>`+"\n",
+			p.x(t), p.top+p.height+14, t)
+	}
+	fmt.Fprintf(b, `<text x="15" y="%d" font-size="12" text-anchor="middle" transform="rotate(-90 15 %d)">%s</text>`+"\n",
+		p.top+p.height/2, p.top+p.height/2, escapeXML(p.yLabel))
+}
+
+func (p panel) markersSVG(b *strings.Builder, xs, ys []float64, color string) {
+	for i := range xs {
+		if !finite(xs[i]) || !finite(ys[i]) {
+			continue
+		}
+		fmt.Fprintf(b, `<circle cx="%.1f" cy="%.1f" r="2.5" fill="none" stroke="%s"/>`+"\n", p.x(xs[i]), p.y(ys[i]), color)
+	}
+}
+
+func (p panel) lineSVG(b *strings.Builder, xs, ys []float64, color string) {
+	points := make([]string, 0, len(xs))
+	for i := range xs {
+		if !finite(xs[i]) || !finite(ys[i]) {
+			continue
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", p.x(xs[i]), p.y(ys[i])))
+	}
+	fmt.Fprintf(b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`+"\n", strings.Join(points, " "), color)
+}
+
+// escapeXML escapes the label text, Nyquist labels hold quotes
+var escapeXML = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "'", "&apos;", `"`, "&quot;").Replace
@@ -0,0 +1,127 @@
+package plot
+
+import (
+	"encoding/xml"
+	"errors"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSpectrum is a parallel RC arc from 1 Hz to 1 MHz and a slightly
+// different fit of it
+func testSpectrum() (freqs []float64, observed, fitted [][2]float64) {
+	arc := func(f, r float64) [2]float64 {
+		wrc := 2 * math.Pi * f * r * 1e-6
+		d := 1 + wrc*wrc
+		return [2]float64{10 + r/d, -r * wrc / d}
+	}
+	for e := 0.0; e <= 6; e += 0.25 {
+		f := math.Pow(10, e)
+		freqs = append(freqs, f)
+		observed = append(observed, arc(f, 100))
+		fitted = append(fitted, arc(f, 98))
+	}
+	return freqs, observed, fitted
+}
+
+// parseSVG checks that the file path is XML with an svg root of the given
+// size and returns its number of elements
+func parseSVG(t *testing.T, path string, size string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	elements := 0
+	d := xml.NewDecoder(f)
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s is not valid XML: %v", path, err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if elements == 0 {
+			attrs := make(map[string]string)
+			for _, a := range start.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			if start.Name.Local != "svg" || attrs["width"] != size || attrs["height"] != size {
+				t.Fatalf("root %s %v, want svg of %s pixels", start.Name.Local, attrs, size)
+			}
+		}
+		elements++
+	}
+	return elements
+}
+
+func TestGeneratePlotSVG(t *testing.T) {
+	freqs, observed, fitted := testSpectrum()
+	dir := t.TempDir()
+	for _, format := range []string{FormatNyquist, FormatBode} {
+		for _, flip := range []bool{false, true} {
+			path := filepath.Join(dir, format+".svg")
+			opts := Options{Path: path, Format: format, DPI: 50, Size: 6, Flip: flip}
+			if err := GeneratePlot(opts, freqs, observed, fitted); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() == 0 {
+				t.Fatalf("%s is empty", path)
+			}
+			// The background, axes and one element per point at least
+			if n := parseSVG(t, path, "300"); n < len(freqs) {
+				t.Errorf("%s: %d elements for %d points", format, n, len(freqs))
+			}
+		}
+	}
+}
+
+func TestGeneratePlotPNG(t *testing.T) {
+	freqs, observed, _ := testSpectrum()
+	path := filepath.Join(t.TempDir(), "nyquist.png")
+	if err := GeneratePlot(Options{Path: path, DPI: 40, Size: 5}, freqs, observed, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 200 || b.Dy() != 200 {
+		t.Errorf("image of %v, want 200x200", b)
+	}
+}
+
+func TestGeneratePlotErrors(t *testing.T) {
+	freqs, observed, _ := testSpectrum()
+	dir := t.TempDir()
+	tests := []Options{
+		{Path: filepath.Join(dir, "plot.jpg"), DPI: 50, Size: 4},
+		{Path: filepath.Join(dir, "plot.svg"), Format: "smith", DPI: 50, Size: 4},
+		{Path: filepath.Join(dir, "missing", "plot.svg"), DPI: 50, Size: 4},
+	}
+	for _, opts := range tests {
+		if err := GeneratePlot(opts, freqs, observed, nil); err == nil {
+			t.Errorf("%+v: no error", opts)
+		}
+	}
+}
@@ -89,6 +89,11 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return rw.ResponseWriter.Write(b)
 }
 
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // RequestProfiler provides per-request profiling information
 type RequestProfiler struct {
 	StartTime   time.Time
@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	_ "net/http/pprof" // Import pprof handlers
+	"net/http/pprof"
 	"runtime"
 	"time"
 
@@ -27,29 +27,18 @@ func New(cfg *config.ServerConfig) *Profiler {
 
 // Start starts the profiling server on a separate port
 func (p *Profiler) Start() error {
+	if p.config.EnableProfilingOnMainPort {
+		log.Println("📊 Profiling served on the main port, not starting a profiling server")
+		return nil
+	}
 	if !p.config.EnableProfiling {
 		log.Println("📊 Profiling disabled")
 		return nil
 	}
 
-	// Enable more detailed profiling
-	runtime.SetBlockProfileRate(1)
-	runtime.SetMutexProfileFraction(1)
-
 	// Create profiling server with custom routes
 	mux := http.NewServeMux()
-
-	// Default pprof endpoints are automatically registered at import
-	// Add custom profiling endpoints
-	mux.HandleFunc("/debug/pprof/", http.DefaultServeMux.ServeHTTP)
-	mux.HandleFunc("/debug/pprof/cmdline", http.DefaultServeMux.ServeHTTP)
-	mux.HandleFunc("/debug/pprof/profile", http.DefaultServeMux.ServeHTTP)
-	mux.HandleFunc("/debug/pprof/symbol", http.DefaultServeMux.ServeHTTP)
-	mux.HandleFunc("/debug/pprof/trace", http.DefaultServeMux.ServeHTTP)
-
-	// Add custom profiling info endpoint
-	mux.HandleFunc("/debug/info", p.infoHandler)
-	mux.HandleFunc("/debug/stats", p.statsHandler)
+	p.Mount(mux)
 
 	p.server = &http.Server{
 		Addr:    ":" + p.config.ProfilingPort,
@@ -77,6 +66,25 @@ func (p *Profiler) Start() error {
 	return nil
 }
 
+// Mount registers the pprof endpoints and the runtime info endpoints under
+// /debug/ on mux and enables block and mutex profiling
+func (p *Profiler) Mount(mux *http.ServeMux) {
+	// Enable more detailed profiling
+	runtime.SetBlockProfileRate(1)
+	runtime.SetMutexProfileFraction(1)
+
+	// Named profiles (heap, goroutine, block, ...) are served by the index handler
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// Add custom profiling info endpoint
+	mux.HandleFunc("/debug/info", p.infoHandler)
+	mux.HandleFunc("/debug/stats", p.statsHandler)
+}
+
 // Stop gracefully stops the profiling server
 func (p *Profiler) Stop() error {
 	if p.server == nil {
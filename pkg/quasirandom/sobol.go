@@ -0,0 +1,242 @@
+package quasirandom
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// bits is the resolution of the generated coordinates
+const bits = 32
+
+// directionParams holds the primitive polynomial and the initial direction
+// numbers of one Sobol dimension, from Joe and Kuo's new-joe-kuo-6 table
+type directionParams struct {
+	s int      // degree of the primitive polynomial
+	a uint32   // its inner coefficients
+	m []uint32 // initial direction numbers m_1..m_s
+}
+
+// embedded are the first dimensions of the new-joe-kuo-6.21201 table from 2
+// up, dimension 1 is the van der Corput sequence
+var embedded = []directionParams{
+	{1, 0, []uint32{1}},
+	{2, 1, []uint32{1, 3}},
+	{3, 1, []uint32{1, 3, 1}},
+	{3, 2, []uint32{1, 1, 1}},
+	{4, 1, []uint32{1, 1, 3, 3}},
+	{4, 4, []uint32{1, 3, 5, 13}},
+	{5, 2, []uint32{1, 1, 5, 5, 17}},
+	{5, 4, []uint32{1, 1, 5, 5, 5}},
+	{5, 7, []uint32{1, 1, 7, 11, 19}},
+	{5, 11, []uint32{1, 1, 5, 1, 1}},
+	{5, 13, []uint32{1, 1, 1, 3, 11}},
+	{5, 14, []uint32{1, 3, 5, 5, 31}},
+	{6, 1, []uint32{1, 3, 3, 9, 7, 49}},
+	{6, 13, []uint32{1, 1, 1, 15, 21, 21}},
+	{6, 16, []uint32{1, 3, 1, 13, 27, 49}},
+	{6, 19, []uint32{1, 1, 1, 15, 7, 5}},
+	{6, 22, []uint32{1, 3, 1, 15, 13, 25}},
+	{6, 25, []uint32{1, 1, 5, 5, 19, 61}},
+	{7, 1, []uint32{1, 3, 7, 11, 23, 15, 103}},
+	{7, 4, []uint32{1, 3, 7, 13, 13, 15, 69}},
+	{7, 7, []uint32{1, 1, 3, 13, 7, 35, 63}},
+	{7, 8, []uint32{1, 3, 5, 9, 1, 25, 53}},
+	{7, 14, []uint32{1, 3, 1, 13, 9, 35, 107}},
+	{7, 19, []uint32{1, 3, 1, 5, 27, 61, 31}},
+	{7, 21, []uint32{1, 1, 5, 11, 19, 41, 61}},
+	{7, 28, []uint32{1, 3, 5, 3, 3, 13, 69}},
+	{7, 31, []uint32{1, 1, 7, 13, 1, 19, 1}},
+	{7, 32, []uint32{1, 3, 7, 5, 13, 19, 59}},
+	{7, 37, []uint32{1, 1, 3, 9, 25, 29, 41}},
+	{7, 41, []uint32{1, 3, 5, 13, 23, 1, 55}},
+	{7, 42, []uint32{1, 3, 7, 3, 13, 59, 17}},
+	{7, 50, []uint32{1, 3, 1, 3, 5, 53, 69}},
+	{7, 55, []uint32{1, 1, 5, 5, 23, 33, 13}},
+	{7, 56, []uint32{1, 1, 7, 7, 1, 61, 123}},
+	{7, 59, []uint32{1, 1, 7, 9, 13, 61, 49}},
+	{7, 62, []uint32{1, 3, 3, 5, 3, 55, 33}},
+}
+
+// Dimensions of the direction numbers. The first EmbeddedDimensions of the
+// new-joe-kuo-6.21201 table are compiled in, they cover the parameter count
+// of any practical equivalent circuit. LoadDirectionNumbers reads the full
+// table, up to MaxDimension, from the file published by Joe and Kuo.
+const (
+	EmbeddedDimensions = 37
+	MaxDimension       = 21201
+)
+
+// ErrDimension is returned for a dimension outside 1..Dimensions()
+var ErrDimension = errors.New("quasirandom: unsupported dimension")
+
+// joeKuo holds the direction numbers in use, the embedded ones until a table
+// is loaded
+var joeKuo atomic.Pointer[[]directionParams]
+
+func init() {
+	joeKuo.Store(&embedded)
+}
+
+// Dimensions returns the highest dimension NewSobol supports, EmbeddedDimensions
+// unless a larger table was loaded
+func Dimensions() int {
+	return len(*joeKuo.Load()) + 1
+}
+
+// LoadDirectionNumbersFile loads the direction numbers of the Joe-Kuo table
+// file at path, see LoadDirectionNumbers
+func LoadDirectionNumbersFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := LoadDirectionNumbers(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadDirectionNumbers replaces the embedded direction numbers with the table
+// read from r, in the format of Joe and Kuo's new-joe-kuo-6.21201: a header
+// line then one line "d s a m_1 .. m_s" per dimension d from 2 up. Every
+// dimension of the table, at most MaxDimension, can be sampled afterwards.
+// Generators created before keep their direction numbers.
+func LoadDirectionNumbers(r io.Reader) error {
+	var table []directionParams
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || (line == 1 && fields[0] == "d") {
+			continue
+		}
+		p, err := parseDirectionParams(fields, len(table)+2)
+		if err != nil {
+			return fmt.Errorf("quasirandom: line %d: %w", line, err)
+		}
+		table = append(table, p)
+		if len(table)+1 > MaxDimension {
+			return fmt.Errorf("quasirandom: line %d: more than %d dimensions", line, MaxDimension)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(table) == 0 {
+		return errors.New("quasirandom: no direction numbers")
+	}
+	joeKuo.Store(&table)
+	return nil
+}
+
+// parseDirectionParams parses the fields "d s a m_1 .. m_s" of dimension dim
+func parseDirectionParams(fields []string, dim int) (directionParams, error) {
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return directionParams{}, err
+		}
+		values[i] = v
+	}
+	if len(values) < 3 || values[0] != uint64(dim) {
+		return directionParams{}, fmt.Errorf("want the fields d s a m_1 .. m_s of dimension %d", dim)
+	}
+	p := directionParams{s: int(values[1]), a: uint32(values[2])}
+	if p.s < 1 || p.s >= bits || len(values) != 3+p.s || p.a >= 1<<(p.s-1) {
+		return directionParams{}, fmt.Errorf("invalid polynomial of degree %d with coefficients %d and %d direction numbers", p.s, p.a, len(values)-3)
+	}
+	for i, m := range values[3:] {
+		// m_i is odd and below 2^i
+		if m&1 == 0 || m >= 1<<(i+1) {
+			return directionParams{}, fmt.Errorf("invalid direction number m_%d = %d", i+1, m)
+		}
+		p.m = append(p.m, uint32(m))
+	}
+	return p, nil
+}
+
+// Sobol generates points of the Sobol low-discrepancy sequence in the unit
+// hypercube using the Gray code construction of Antonov and Saleev
+type Sobol struct {
+	dim   int
+	index uint32
+	v     [][bits]uint32 // direction numbers scaled to 32 bits
+	x     []uint32
+}
+
+// NewSobol creates a generator of dim-dimensional Sobol points
+func NewSobol(dim int) (*Sobol, error) {
+	table := *joeKuo.Load()
+	if dim < 1 || dim > len(table)+1 {
+		return nil, fmt.Errorf("%w %d, outside 1..%d", ErrDimension, dim, len(table)+1)
+	}
+
+	s := &Sobol{dim: dim, v: make([][bits]uint32, dim), x: make([]uint32, dim)}
+	for i := 0; i < bits; i++ {
+		s.v[0][i] = 1 << (bits - 1 - i)
+	}
+	for j := 1; j < dim; j++ {
+		p := table[j-1]
+		for i := 0; i < p.s; i++ {
+			s.v[j][i] = p.m[i] << (bits - 1 - i)
+		}
+		for i := p.s; i < bits; i++ {
+			v := s.v[j][i-p.s] ^ s.v[j][i-p.s]>>p.s
+			for k := 1; k < p.s; k++ {
+				v ^= (p.a >> (p.s - 1 - k) & 1) * s.v[j][i-k]
+			}
+			s.v[j][i] = v
+		}
+	}
+	return s, nil
+}
+
+// Next writes the next point into dst, which must hold dim values, and
+// returns it. The first point is the origin.
+func (s *Sobol) Next(dst []float64) []float64 {
+	for j := range dst[:s.dim] {
+		dst[j] = float64(s.x[j]) / (1 << bits)
+	}
+
+	// Flip the direction number of the rightmost zero bit of the index
+	c := 0
+	for n := s.index; n&1 == 1; n >>= 1 {
+		c++
+	}
+	for j := range s.x {
+		s.x[j] ^= s.v[j][c]
+	}
+	s.index++
+	return dst
+}
+
+// GenerateSobolSamples returns nSamples points covering the box [lo, hi]
+// uniformly. The origin of the sequence is skipped, so the first sample is the
+// centre of the box. It returns nil for a dimension outside 1..Dimensions()
+// or bounds not of dim values, NewSobol tells the reason.
+func GenerateSobolSamples(dim, nSamples int, lo, hi []float64) [][]float64 {
+	s, err := NewSobol(dim)
+	if err != nil || len(lo) != dim || len(hi) != dim {
+		return nil
+	}
+
+	u := make([]float64, dim)
+	s.Next(u)
+
+	samples := make([][]float64, nSamples)
+	for i := range samples {
+		s.Next(u)
+		samples[i] = make([]float64, dim)
+		for j := range u {
+			samples[i][j] = lo[j] + u[j]*(hi[j]-lo[j])
+		}
+	}
+	return samples
+}
@@ -0,0 +1,203 @@
+package quasirandom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSobolFirstPoints(t *testing.T) {
+	want := [][2]float64{
+		{0, 0}, {0.5, 0.5}, {0.75, 0.25}, {0.25, 0.75},
+		{0.375, 0.375}, {0.875, 0.875}, {0.625, 0.125}, {0.125, 0.625},
+	}
+	s, err := NewSobol(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]float64, 2)
+	for i, w := range want {
+		s.Next(p)
+		if p[0] != w[0] || p[1] != w[1] {
+			t.Errorf("point %d = %v, want %v", i, p, w)
+		}
+	}
+}
+
+func TestSobolDimensions(t *testing.T) {
+	if got := Dimensions(); got != EmbeddedDimensions {
+		t.Fatalf("Dimensions() = %d, want %d", got, EmbeddedDimensions)
+	}
+	for _, dim := range []int{0, -1, EmbeddedDimensions + 1, MaxDimension} {
+		if _, err := NewSobol(dim); !errors.Is(err, ErrDimension) {
+			t.Errorf("NewSobol(%d) error = %v, want ErrDimension", dim, err)
+		}
+		lo, hi := make([]float64, max(dim, 0)), make([]float64, max(dim, 0))
+		if samples := GenerateSobolSamples(dim, 4, lo, hi); samples != nil {
+			t.Errorf("GenerateSobolSamples(%d) = %v, want nil", dim, samples)
+		}
+	}
+	if _, err := NewSobol(EmbeddedDimensions); err != nil {
+		t.Errorf("NewSobol(%d): %v", EmbeddedDimensions, err)
+	}
+	if samples := GenerateSobolSamples(2, 4, []float64{0}, []float64{1, 1}); samples != nil {
+		t.Error("bounds of the wrong length accepted")
+	}
+}
+
+// joeKuoTable writes the embedded direction numbers in the format of the
+// new-joe-kuo-6.21201 file, followed by extra lines
+func joeKuoTable(extra ...string) string {
+	var b strings.Builder
+	b.WriteString("d       s       a       m_i\n")
+	for i, p := range embedded {
+		fmt.Fprintf(&b, "%d\t%d\t%d", i+2, p.s, p.a)
+		for _, m := range p.m {
+			fmt.Fprintf(&b, "\t%d", m)
+		}
+		b.WriteString("\n")
+	}
+	for _, line := range extra {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// A loaded table extends the dimensions, it gives the embedded ones the same
+// points and every further one is stratified like any Sobol dimension
+func TestLoadDirectionNumbers(t *testing.T) {
+	t.Cleanup(func() { joeKuo.Store(&embedded) })
+	const n = 256
+	before, err := NewSobol(EmbeddedDimensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// x^8 + x^4 + x^3 + x^2 + 1 is primitive
+	err = LoadDirectionNumbers(strings.NewReader(joeKuoTable(
+		"38\t8\t14\t1\t1\t1\t1\t1\t1\t1\t1",
+		"39\t8\t14\t1\t3\t5\t7\t9\t11\t13\t15",
+		"40\t8\t14\t1\t3\t7\t15\t31\t63\t127\t255",
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Dimensions(); got != 40 {
+		t.Fatalf("Dimensions() = %d, want 40", got)
+	}
+	after, err := NewSobol(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, got := make([]float64, EmbeddedDimensions), make([]float64, 40)
+	strata := make([][n]bool, 40)
+	for i := 0; i < n; i++ {
+		before.Next(want)
+		after.Next(got)
+		for j := range want {
+			if got[j] != want[j] {
+				t.Fatalf("point %d, dimension %d = %v, want %v", i, j+1, got[j], want[j])
+			}
+		}
+		for j, u := range got {
+			if k := int(u * n); strata[j][k] {
+				t.Fatalf("dimension %d: two of the first %d points in [%d/%d, %d/%d)", j+1, n, k, n, k+1, n)
+			} else {
+				strata[j][k] = true
+			}
+		}
+	}
+}
+
+// A malformed table is rejected and the direction numbers in use are kept
+func TestLoadDirectionNumbersInvalid(t *testing.T) {
+	t.Cleanup(func() { joeKuo.Store(&embedded) })
+	for name, table := range map[string]string{
+		"empty":           "d s a m_i\n",
+		"not numeric":     joeKuoTable("38 8 x 1"),
+		"wrong dimension": joeKuoTable("39 8 14 1 1 1 1 1 1 1 1"),
+		"missing m":       joeKuoTable("38 8 14 1 1 1"),
+		"even m":          joeKuoTable("38 8 14 1 2 1 1 1 1 1 1"),
+		"m too large":     joeKuoTable("38 8 14 1 5 1 1 1 1 1 1"),
+		"coefficients":    joeKuoTable("38 8 200 1 1 1 1 1 1 1 1"),
+	} {
+		if err := LoadDirectionNumbers(strings.NewReader(table)); err == nil {
+			t.Errorf("%s: table accepted", name)
+		}
+		if got := Dimensions(); got != EmbeddedDimensions {
+			t.Fatalf("%s: Dimensions() = %d after a failed load, want %d", name, got, EmbeddedDimensions)
+		}
+	}
+	if err := LoadDirectionNumbersFile("no-such-file"); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+func TestGenerateSobolSamplesBounds(t *testing.T) {
+	lo, hi := []float64{-2, 10}, []float64{2, 20}
+	samples := GenerateSobolSamples(2, 64, lo, hi)
+	if len(samples) != 64 {
+		t.Fatalf("%d samples, want 64", len(samples))
+	}
+	if samples[0][0] != 0 || samples[0][1] != 15 {
+		t.Errorf("first sample %v, want the centre [0 15]", samples[0])
+	}
+	for _, sample := range samples {
+		for j, v := range sample {
+			if v < lo[j] || v >= hi[j] {
+				t.Errorf("sample %v outside [%v, %v)", sample, lo, hi)
+			}
+		}
+	}
+}
+
+// starDiscrepancy returns the star discrepancy of points in the unit square,
+// the largest deviation of the fraction of points in a box [0,x)x[0,y) from
+// its area, over the boxes with corners at the point coordinates
+func starDiscrepancy(points [][]float64) float64 {
+	xs, ys := []float64{1}, []float64{1}
+	for _, p := range points {
+		xs, ys = append(xs, p[0]), append(ys, p[1])
+	}
+	n := float64(len(points))
+	d := 0.0
+	for _, x := range xs {
+		for _, y := range ys {
+			open, closed := 0, 0
+			for _, p := range points {
+				if p[0] < x && p[1] < y {
+					open++
+				}
+				if p[0] <= x && p[1] <= y {
+					closed++
+				}
+			}
+			d = math.Max(d, math.Max(float64(closed)/n-x*y, x*y-float64(open)/n))
+		}
+	}
+	return d
+}
+
+// 16 Sobol points cover the unit square more evenly than 16 random ones
+func TestSobolDiscrepancy(t *testing.T) {
+	const n, trials = 16, 50
+	sobol := GenerateSobolSamples(2, n, []float64{0, 0}, []float64{1, 1})
+	got := starDiscrepancy(sobol)
+
+	rnd := rand.New(rand.NewSource(1))
+	random := 0.0
+	for i := 0; i < trials; i++ {
+		points := make([][]float64, n)
+		for j := range points {
+			points[j] = []float64{rnd.Float64(), rnd.Float64()}
+		}
+		random += starDiscrepancy(points) / trials
+	}
+	if got >= random {
+		t.Errorf("Sobol star discrepancy %.3f, not below the %.3f of random points", got, random)
+	}
+}
@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the queue file
+var (
+	jobsBucket     = []byte("jobs")
+	webhooksBucket = []byte("webhooks")
+)
+
+// openTimeout bounds the wait for the lock of a queue file held by another
+// server
+const openTimeout = time.Second
+
+// Queue keeps the accepted jobs and the webhooks not delivered yet in a bbolt
+// file, so that a restarted server resumes them. A job is removed once it is
+// processed and its webhooks queued, a webhook once it is delivered. Methods
+// on a nil Queue do nothing, the server then keeps its jobs in memory only.
+type Queue struct {
+	db *bolt.DB
+}
+
+// Job is an accepted request, Payload is its JSON body
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`       // jobs.KindFit or jobs.KindBatch
+	RequestID  string          `json:"request_id"` // X-Request-ID of the request that submitted it
+	Payload    json.RawMessage `json:"payload"`
+	AcceptedAt time.Time       `json:"accepted_at"`
+}
+
+// webhookRecord is a queued webhook, its context reduced to the request ID
+type webhookRecord struct {
+	Item      models.WebhookItem
+	RequestID string
+}
+
+// Open opens the queue file at path, creating it when missing
+func Open(path string) (*Queue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("opening queue %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, webhooksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening queue %s: %w", path, err)
+	}
+	return &Queue{db: db}, nil
+}
+
+// Accept stores job until Finish is called with its ID
+func (q *Queue) Accept(job Job) error {
+	if q == nil {
+		return nil
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.put(jobsBucket, job.ID, data)
+}
+
+// Finish removes the job id
+func (q *Queue) Finish(id string) error {
+	if q == nil {
+		return nil
+	}
+	return q.delete(jobsBucket, id)
+}
+
+// SaveWebhook stores webhook until Delivered is called with its RequestID. Its
+// context is not kept, only the request ID it carries.
+func (q *Queue) SaveWebhook(webhook models.WebhookItem) error {
+	if q == nil {
+		return nil
+	}
+	record := webhookRecord{Item: webhook, RequestID: utils.RequestID(webhook.Context)}
+	record.Item.Context = nil
+
+	// gob keeps the NaN and Inf values of failed fits, JSON rejects them
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return err
+	}
+	return q.put(webhooksBucket, webhook.RequestID, buf.Bytes())
+}
+
+// Delivered removes the webhook id
+func (q *Queue) Delivered(id string) error {
+	if q == nil {
+		return nil
+	}
+	return q.delete(webhooksBucket, id)
+}
+
+// Pending returns the jobs not finished and the webhooks not delivered, in
+// ID order. The context of the webhooks carries their request ID again.
+func (q *Queue) Pending() ([]Job, []models.WebhookItem, error) {
+	if q == nil {
+		return nil, nil, nil
+	}
+	var pendingJobs []Job
+	var webhooks []models.WebhookItem
+	err := q.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("job %s: %w", k, err)
+			}
+			pendingJobs = append(pendingJobs, job)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(webhooksBucket).ForEach(func(k, v []byte) error {
+			var record webhookRecord
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&record); err != nil {
+				return fmt.Errorf("webhook %s: %w", k, err)
+			}
+			if record.RequestID != "" {
+				record.Item.Context = utils.WithRequestID(context.Background(), record.RequestID)
+			}
+			webhooks = append(webhooks, record.Item)
+			return nil
+		})
+	})
+	return pendingJobs, webhooks, err
+}
+
+// Close closes the queue file
+func (q *Queue) Close() error {
+	if q == nil {
+		return nil
+	}
+	return q.db.Close()
+}
+
+func (q *Queue) put(bucket []byte, key string, value []byte) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), value)
+	})
+}
+
+func (q *Queue) delete(bucket []byte, key string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
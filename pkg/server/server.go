@@ -1,17 +1,34 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"net"
 	"net/http"
+	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/processing"
+	"github.com/kacperjurak/goimpcore/internal/utils"
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/dedup"
 	"github.com/kacperjurak/goimpcore/pkg/handlers"
+	"github.com/kacperjurak/goimpcore/pkg/health"
+	"github.com/kacperjurak/goimpcore/pkg/jobs"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
+	"github.com/kacperjurak/goimpcore/pkg/middleware"
 	"github.com/kacperjurak/goimpcore/pkg/profiling"
+	"github.com/kacperjurak/goimpcore/pkg/queue"
+	"github.com/kacperjurak/goimpcore/pkg/store"
+	"github.com/kacperjurak/goimpcore/pkg/telemetry"
 	"github.com/kacperjurak/goimpcore/pkg/webhook"
 	"github.com/kacperjurak/goimpcore/pkg/worker"
 )
@@ -27,13 +44,32 @@ type Server struct {
 	serverConfig  *config.ServerConfig
 	workerPool    *worker.Pool
 	webhookClient *webhook.Client
+	results       store.Store
+	jobs          *jobs.Registry
+	queue         *queue.Queue // nil when jobs are kept in memory only
+	queueErr      error        // failure to open the queue file, returned by Start
+	eisHandler    *handlers.EISHandler
+	batchHandler  *handlers.BatchHandler
 	httpServer    *http.Server
 	profiler      *profiling.Profiler
 	middleware    *profiling.Middleware
+	readiness     []health.Checker
+	started       time.Time
+	stopTracing   func(context.Context) error
+	stopRateLimit func()
+	port          atomic.Int64  // bound port, set by Start
+	shutdownCh    chan struct{} // closed when Start returns, see Done
 }
 
+// httpShutdownTimeout is how long Shutdown waits for open requests
+const httpShutdownTimeout = 15 * time.Second
+
+// writeTimeout bounds the write of a response, handlers with longer fits
+// extend it
+const writeTimeout = 15 * time.Second
+
 // ProcessorFunc defines the signature for EIS data processing
-type ProcessorFunc func(freqs []float64, impData [][2]float64, config *config.Config) interface{}
+type ProcessorFunc func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) interface{}
 
 // Options holds configuration for creating a new server
 type Options struct {
@@ -50,15 +86,41 @@ func New(opts Options) *Server {
 	if opts.ServerConfig == nil {
 		opts.ServerConfig = config.DefaultServerConfig()
 	}
+	started := time.Now()
+
+	// Create webhook client
+	webhookClient := webhook.NewClient(opts.ServerConfig.WebhookURL, opts.Config, webhook.WithCompression(opts.ServerConfig.WebhookCompression))
+	if opts.ServerConfig.WebhookMaxAttempts > 0 {
+		webhookClient.Retry.MaxAttempts = opts.ServerConfig.WebhookMaxAttempts
+	}
+	if opts.ServerConfig.WebhookInitialBackoff > 0 {
+		webhookClient.Retry.InitialBackoff = opts.ServerConfig.WebhookInitialBackoff
+	}
+	webhookClient.DeadLetters = webhook.NewDeadLetterQueue(0, opts.ServerConfig.WebhookDeadLetterFile)
+	webhookClient.Secret = opts.ServerConfig.WebhookSecret
+
+	// Persist accepted jobs when a queue file is configured
+	var jobQueue *queue.Queue
+	var webhookStore worker.WebhookStore
+	var queueErr error
+	if opts.ServerConfig.QueueFile != "" {
+		if jobQueue, queueErr = queue.Open(opts.ServerConfig.QueueFile); queueErr == nil {
+			webhookStore = jobQueue
+		}
+	}
 
 	// Create worker pool
 	workerPool := worker.New(worker.Options{
 		Workers:   opts.ServerConfig.WorkerCount,
 		Processor: worker.ProcessorFunc(opts.Processor),
-	})
+		Webhook:   webhookClient.Send,
+		Webhooks:  webhookStore,
 
-	// Create webhook client
-	webhookClient := webhook.NewClient(opts.ServerConfig.WebhookURL, opts.Config)
+		WebhookConcurrency: opts.ServerConfig.WebhookConcurrency,
+
+		ShutdownTimeout: opts.ServerConfig.ShutdownTimeout,
+		JobTimeout:      opts.ServerConfig.JobTimeout,
+	})
 
 	// Create profiler and middleware
 	profiler := profiling.New(opts.ServerConfig)
@@ -70,8 +132,20 @@ func New(opts Options) *Server {
 		serverConfig:  opts.ServerConfig,
 		workerPool:    workerPool,
 		webhookClient: webhookClient,
+		results:       store.NewMemory(opts.ServerConfig.ResultTTL, opts.ServerConfig.ResultMaxEntries),
+		jobs:          jobs.NewRegistry(opts.ServerConfig.ResultTTL),
+		queue:         jobQueue,
+		queueErr:      queueErr,
 		profiler:      profiler,
 		middleware:    middleware,
+		readiness: []health.Checker{
+			health.WorkerPoolChecker{Pool: workerPool, Watermark: opts.ServerConfig.ReadyQueueWatermark},
+			health.WebhookChecker{Webhooks: webhookClient},
+			health.StartupChecker{Started: started},
+		},
+		started:     started,
+		stopTracing: telemetry.Init(opts.ServerConfig.OTELEndpoint, "goimpsolver"),
+		shutdownCh:  make(chan struct{}),
 	}
 
 	server.setupRoutes()
@@ -83,47 +157,192 @@ func (s *Server) setupRoutes() {
 	mux := http.NewServeMux()
 
 	// Create handlers
-	eisHandler := handlers.NewEISHandler(s.config, s.workerPool, s.getProcessorFunc())
-	batchHandler := handlers.NewBatchHandler(s.config, s.workerPool, s.getProcessorFunc())
+	maxBodyBytes, maxBatchSpectra, maxFrequencyPoints := s.serverConfig.RequestLimits()
+	limits := handlers.Limits{MaxBatchSpectra: maxBatchSpectra, MaxFrequencyPoints: maxFrequencyPoints, MaxLineBytes: maxBodyBytes}
+
+	var deduplicator *dedup.Deduplicator
+	if s.serverConfig.EnableDedup {
+		deduplicator = dedup.New(dedup.DefaultWindow)
+	}
 
-	// Register routes with profiling middleware
-	mux.Handle("/eis-data", s.middleware.ProfiledHandler("eis-single", eisHandler))
-	mux.Handle("/eis-data/batch", s.middleware.ProfiledHandler("eis-batch", batchHandler))
+	eisHandler := handlers.NewEISHandler(s.config, s.workerPool, s.getProcessorFunc(), s.results, limits, deduplicator, s.jobs, s.queue)
+	batchHandler := handlers.NewBatchHandler(s.config, s.workerPool, s.getProcessorFunc(), s.webhookClient.SendBatchComplete, s.results, limits, s.jobs, s.queue)
+	s.eisHandler, s.batchHandler = eisHandler, batchHandler
+	bodeHandler := handlers.NewBodeHandler(s.config, s.getProcessorFunc(), handlers.StoredBodeLookup(s.results), limits)
+	resultsHandler := handlers.NewResultsHandler(s.results)
+	circuitsHandler := handlers.NewCircuitsHandler(circuits.Default())
+	webhooksHandler := handlers.NewWebhooksHandler(s.webhookClient)
+	jobsHandler := handlers.NewJobsHandler(s.jobs)
+	analysisHandler := handlers.NewAnalysisHandler(limits)
+	simulateHandler := handlers.NewSimulateHandler(limits)
+	streamHandler := handlers.NewStreamHandler(s.config, s.getIterativeSolveFunc(), s.results, limits)
+	wsHandler := handlers.NewLiveHandler(s.config, s.getIterativeSolveFunc(), s.results, limits, s.config.LiveMaxFits)
+
+	// The handlers answering inline without a deadline of their own stop
+	// computing once their response could not be written anymore
+	bounded := middleware.TimeoutMiddleware(writeTimeout)
+
+	// Register routes, profiled by the chain under profiledRoutes
+	mux.Handle("/eis-data", eisHandler)
+	mux.Handle("/eis-data/sync", eisHandler)
+	mux.Handle("/eis-data/upload", http.HandlerFunc(eisHandler.ServeUpload))
+	// NDJSON posted to /eis-data/stream is a streamed batch, not a streamed fit
+	mux.Handle("/eis-data/stream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if middleware.IsNDJSON(r) {
+			batchHandler.ServeNDJSON(w, r)
+			return
+		}
+		streamHandler.ServeHTTP(w, r)
+	}))
+	mux.Handle("/eis-data/batch", batchHandler)
+	mux.Handle("/eis-data/batch/", batchHandler)
+	mux.Handle("/eis-data/bode", bounded(bodeHandler))
+	mux.Handle("/ws", wsHandler)
+	mux.Handle("/results/", resultsHandler)
+	mux.Handle("/batches/", resultsHandler)
+	mux.Handle("/batch/", resultsHandler)
+	mux.Handle("/circuits", circuitsHandler)
+	mux.Handle("/circuits/", circuitsHandler)
+	mux.Handle("/webhooks/", webhooksHandler)
+	mux.Handle("/jobs/", jobsHandler)
+	mux.Handle("/analysis/", bounded(analysisHandler))
+	mux.Handle("/simulate", bounded(simulateHandler))
 	mux.HandleFunc("/health", s.healthHandler)
+	mux.HandleFunc("/health/live", s.liveHandler)
+	mux.HandleFunc("/health/ready", s.readyHandler)
+	mux.HandleFunc("/ready", s.readyHandler)
 	mux.HandleFunc("/debug/gc", s.gcHandler)
 	mux.HandleFunc("/debug/memory", s.memoryHandler)
+	if s.serverConfig.EnableMetrics {
+		mux.Handle("/metrics", metrics.Handler())
+	}
+	if s.serverConfig.EnableProfilingOnMainPort {
+		s.profiler.Mount(mux)
+		log.Printf("📊 Profiling endpoints at http://localhost:%s/debug/pprof/", s.serverConfig.Port)
+	}
+
+	rateLimit, stopRateLimit := middleware.RateLimitMiddleware(s.serverConfig)
+	s.stopRateLimit = stopRateLimit
+
+	// Outermost first. Bodies are decompressed before the size limit so that
+	// it bounds the decompressed size.
+	chain := []middleware.Middleware{
+		middleware.RequestIDMiddleware(),
+		middleware.LoggingMiddleware(middleware.RequestLogger(s.serverConfig)),
+		middleware.RecoveryMiddleware(),
+		telemetry.Middleware,
+		middleware.CORSMiddleware(s.serverConfig),
+		rateLimit,
+		middleware.GzipMiddleware,
+		middleware.BodyLimitMiddleware(maxBodyBytes, "/eis-data/stream"),
+		middleware.ProfilingMiddleware(s.middleware, func(r *http.Request) string {
+			return profileName(mux, r)
+		}),
+	}
+	if s.serverConfig.EnableMetrics {
+		chain = append([]middleware.Middleware{func(next http.Handler) http.Handler {
+			return metrics.Middleware(mux, next)
+		}}, chain...)
+	}
+	handler := middleware.Chain(chain...)(mux)
 
 	s.httpServer = &http.Server{
 		Addr:         ":" + s.serverConfig.Port,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: writeTimeout,
 		IdleTimeout:  60 * time.Second,
 	}
 }
 
+// profiledRoutes are the profile names of the fitting routes by mux pattern,
+// the other routes are not profiled
+var profiledRoutes = map[string]string{
+	"/eis-data":        "eis-single",
+	"/eis-data/sync":   "eis-sync",
+	"/eis-data/upload": "eis-upload",
+	"/eis-data/stream": "eis-stream",
+	"/eis-data/batch":  "eis-batch",
+	"/eis-data/bode":   "eis-bode",
+}
+
+// profileName returns the profile name of the route of r in mux, empty for
+// routes not profiled
+func profileName(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "/eis-data/stream" && middleware.IsNDJSON(r) {
+		return "eis-batch-stream"
+	}
+	return profiledRoutes[pattern]
+}
+
 // getProcessorFunc returns the actual EIS processor function
 func (s *Server) getProcessorFunc() handlers.ProcessorFunc {
-	return func(freqs []float64, impData [][2]float64, cfg *config.Config) interface{} {
-		return s.processEISData(freqs, impData, cfg)
+	return func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		return s.processEISData(ctx, freqs, impData, sigmas, cfg)
 	}
 }
 
 // processEISData performs actual EIS processing using goimpcore
-func (s *Server) processEISData(freqs []float64, impData [][2]float64, cfg *config.Config) goimpcore.Result {
-	log.Printf("🔥 DEBUG: processEISData called with %d frequencies, config: %+v", len(freqs), cfg)
-	log.Printf("🔥 DEBUG: Starting actual EIS processing...")
+func (s *Server) processEISData(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) goimpcore.Result {
+	if !cfg.Quiet {
+		log.Printf("🔥 DEBUG: request_id=%s processEISData called with %d frequencies, config: %+v", utils.RequestID(ctx), len(freqs), cfg)
+	}
+
+	if codes := goimpcore.ParseCircuitCodes(cfg.Code); len(codes) > 1 {
+		return processing.CompareCircuits(ctx, freqs, impData, sigmas, codes, cfg).Best
+	}
 
 	code := strings.ToLower(cfg.Code)
 
 	if cfg.OptimMethod == "all" {
-		return s.runAllOptimizationMethods(code, freqs, impData, cfg)
+		return s.runAllOptimizationMethods(ctx, code, freqs, impData, sigmas, cfg)
 	}
 
-	return s.runSingleOptimizationMethod(code, freqs, impData, cfg, cfg.OptimMethod)
+	return s.runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, cfg.OptimMethod)
 }
 
-func (s *Server) runSingleOptimizationMethod(code string, freqs []float64, impData [][2]float64, cfg *config.Config, method string) goimpcore.Result {
+func (s *Server) runSingleOptimizationMethod(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config, method string) goimpcore.Result {
+	solver := s.newSolver(code, freqs, impData, sigmas, cfg, method)
+
+	// Time the optimization
+	startTime := time.Now()
+	res, _ := solver.SolveWithContext(ctx, minFunc, iterationLimit(cfg))
+	duration := time.Since(startTime)
+
+	if res.Status == "ERROR" {
+		log.Printf("EIS processing FAILED - Method: %s, Status: %s", method, res.Status)
+	} else {
+		log.Printf("EIS processing completed - Method: %s, Chi-square: %.14e", method, res.Min)
+	}
+
+	if !cfg.Quiet {
+		if res.Status == "ERROR" {
+			log.Printf("Method: %s FAILED - Status=%s", method, res.Status)
+		} else {
+			log.Printf("Method: %s, Min=%.12e, Params=%v, Status=%s", method, res.Min, res.Params, res.Status)
+			log.Printf("Method: %s, RedChiSq=%.6e, R2=%.6f, AIC=%.4f, BIC=%.4f, DoF=%d",
+				method, res.Stats.ReducedChiSq, res.Stats.RSquared, res.Stats.AIC, res.Stats.BIC, res.Stats.DoF)
+		}
+	}
+
+	if cfg.Sensitivity && res.Status == goimpcore.OK && len(res.Params) > 0 {
+		sensitivity := goimpcore.SensitivityAnalysis(solver, res, goimpcore.DefaultSensitivityStep)
+		res.SetPayload(goimpcore.PayloadSensitivity, sensitivity)
+		if !cfg.Quiet {
+			for _, sens := range sensitivity {
+				log.Printf("Sensitivity param %s: dChiSq/dParam=%.6e, relative=%.6e",
+					sens.ParamName, sens.DChiSqDParam, sens.RelativeSensitivity)
+			}
+		}
+	}
+
+	log.Printf("Processing time: %v", duration)
+	return res
+}
+
+// newSolver returns the solver of code configured by cfg for method
+func (s *Server) newSolver(code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config, method string) *goimpcore.Solver {
 	solver := goimpcore.NewSolver(code, freqs, impData)
 
 	// Use provided InitValues or generate automatic ones
@@ -131,20 +350,48 @@ func (s *Server) runSingleOptimizationMethod(code string, freqs []float64, impDa
 		solver.InitValues = []float64(cfg.InitValues)
 		log.Printf("Using provided initial values: %v", solver.InitValues)
 	} else {
-		solver.InitValues = s.generateInitialValues(code)
+		solver.InitValues = s.generateInitialValues(code, freqs, impData)
 		log.Printf("Using auto-generated initial values: %v", solver.InitValues)
 	}
 
-	if cfg.Unity {
-		solver.Weighting = goimpcore.UNITY
-	} else {
+	if err := solver.SetWeighting(cfg.Weighting, sigmas); err != nil {
+		log.Printf("%v, using modulus weighting", err)
 		solver.Weighting = goimpcore.MODULUS
 	}
+	log.Printf("Using %s weighting", solver.Weighting)
+
+	space, err := goimpcore.ParseSpace(cfg.Space)
+	if err != nil {
+		log.Printf("%v, fitting impedances", err)
+	}
+	solver.Space = space
+
+	if cfg.Starts > 0 {
+		solver.Starts = int(cfg.Starts)
+	}
+	if cfg.Concurrency {
+		solver.Concurrency = runtime.NumCPU()
+	}
+	solver.Robust = goimpcore.RobustSettings{Enabled: cfg.Robust, K: cfg.RobustK, Passes: int(cfg.RobustPasses)}
+	solver.FreqMin = cfg.FreqMin
+	solver.FreqMax = cfg.FreqMax
+	solver.Regularization = cfg.Regularization
+	solver.Diagnostics.MaxCorrelation = cfg.MaxCorrelation
+
+	if cfg.LogScale {
+		solver.LogScale = goimpcore.DefaultLogScale(code)
+		log.Printf("Using log-scale parameters: %v", solver.LogScale)
+	}
 
 	// Set the solver method based on the optimization method
 	switch method {
 	case "nelder-mead":
 		solver.SmartMode = "eis" // Use EIS smart mode for multi-try approach
+		if cfg.Concurrency {
+			solver.SmartMode = "parallel"
+		}
+	case "parallel", "multi-start":
+		solver.SmartMode = "parallel" // EIS smart mode from several starting points at once
 	case "levenberg-marquardt", "lm":
 		solver.SmartMode = "lm"
 	case "gradient-descent", "gd":
@@ -153,72 +400,45 @@ func (s *Server) runSingleOptimizationMethod(code string, freqs []float64, impDa
 		solver.SmartMode = "lbfgs"
 	case "newton":
 		solver.SmartMode = "newton"
+	case "hybrid", "nm+lm":
+		solver.SmartMode = "nm+lm" // NM exploration followed by LM refinement
 	default:
 		log.Printf("Unknown optimization method '%s', using Nelder-Mead", method)
 		solver.SmartMode = "eis"
 	}
 
 	log.Printf("Using optimization method: %s", method)
+	return solver
+}
 
-	// Time the optimization
-	startTime := time.Now()
-	res := solver.Solve(minFunc, maxIterations)
-	duration := time.Since(startTime)
-
-	// Ensure consistent chi-square calculation for all methods
-	// Skip recalculation for EIS mode as it handles scaling internally
-	if res.Status != "ERROR" && len(res.Params) > 0 && (res.MinUnit != "ChiSq" || method != "levenberg-marquardt") && cfg.SmartMode != "eis" {
-		// Debug the recalculation process
-		theoreticalImp := goimpcore.CircuitImpedance(code, freqs, res.Params)
-
-		actualChiSq := goimpcore.ChiSq(impData, theoreticalImp, solver.Weighting)
-		log.Printf("DEBUG: ChiSq calculation result: %v (weighting: %v)", actualChiSq, solver.Weighting)
-
-		// Check if recalculation produces NaN
-		if math.IsNaN(actualChiSq) || math.IsInf(actualChiSq, 0) {
-			log.Printf("WARNING: Recalculated chi-square is invalid (%v), keeping original result.Min (%v)", actualChiSq, res.Min)
-		} else {
-			log.Printf("INFO: Using recalculated chi-square (%v) instead of original (%v)", actualChiSq, res.Min)
-			res.Min = actualChiSq
-			res.MinUnit = "ChiSq"
-		}
-	} else if cfg.SmartMode == "eis" {
-		log.Printf("INFO: Skipping chi-square recalculation for EIS mode (scaling handled internally)")
-	}
-
-	if res.Status == "ERROR" {
-		log.Printf("EIS processing FAILED - Method: %s, Status: %s", method, res.Status)
-	} else {
-		log.Printf("EIS processing completed - Method: %s, Chi-square: %.14e", method, res.Min)
-	}
-
-	if !cfg.Quiet {
-		if res.Status == "ERROR" {
-			log.Printf("Method: %s FAILED - Status=%s", method, res.Status)
-		} else {
-			log.Printf("Method: %s, Min=%.12e, Params=%v, Status=%s", method, res.Min, res.Params, res.Status)
-		}
+// getIterativeSolveFunc returns the streamed fit of the stream handler
+func (s *Server) getIterativeSolveFunc() handlers.IterativeSolveFunc {
+	return func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) <-chan goimpcore.IterativeResult {
+		solver := s.newSolver(strings.ToLower(cfg.Code), freqs, impData, sigmas, cfg, cfg.OptimMethod)
+		return solver.SolveIterative(ctx, minFunc, iterationLimit(cfg))
 	}
-
-	log.Printf("Processing time: %v", duration)
-	return res
 }
 
-func (s *Server) runAllOptimizationMethods(code string, freqs []float64, impData [][2]float64, cfg *config.Config) goimpcore.Result {
+func (s *Server) runAllOptimizationMethods(ctx context.Context, code string, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) goimpcore.Result {
 	methods := []string{"nelder-mead", "levenberg-marquardt", "gradient-descent", "lbfgs", "newton"}
 	var bestResult goimpcore.Result
-	bestChiSq := math.Inf(1)
+	bestScore := math.Inf(1)
 
 	log.Printf("Running all optimization methods for comparison...")
 
 	for _, method := range methods {
+		if ctx.Err() != nil {
+			// Cancelled, keep the best of the methods run so far
+			log.Printf("Method comparison stopped before %s: %v", method, ctx.Err())
+			break
+		}
 		log.Printf("Testing method: %s", method)
-		result := s.runSingleOptimizationMethod(code, freqs, impData, cfg, method)
+		result := s.runSingleOptimizationMethod(ctx, code, freqs, impData, sigmas, cfg, method)
 
-		if result.Status != "ERROR" && result.Min < bestChiSq {
+		if score := result.Score(cfg.Criterion); result.Status != "ERROR" && score < bestScore {
 			bestResult = result
-			bestChiSq = result.Min
-			log.Printf("New best method: %s with chi-square: %.12e", method, result.Min)
+			bestScore = score
+			log.Printf("New best method: %s with chi-square: %.12e, AIC: %.4f", method, result.Min, result.Stats.AIC)
 		}
 	}
 
@@ -231,40 +451,64 @@ func (s *Server) runAllOptimizationMethods(code string, freqs []float64, impData
 		}
 	}
 
-	log.Printf("Best overall result: chi-square=%.12e", bestResult.Min)
+	log.Printf("Best overall result: chi-square=%.12e, AIC=%.4f, BIC=%.4f", bestResult.Min, bestResult.Stats.AIC, bestResult.Stats.BIC)
 	return bestResult
 }
 
-// generateInitialValues creates reasonable default initial values for different circuit codes
-func (s *Server) generateInitialValues(code string) []float64 {
-	switch strings.ToLower(code) {
-	case "r(cr)":
-		// R1, C1, R2
-		return []float64{50.0, 1e-6, 100.0}
-	case "r(qr)":
-		// R1, Q1_Y0, Q1_n, R2
-		return []float64{50.0, 1e-6, 0.8, 100.0}
-	case "r(cr)(cr)":
-		// R1, C1, R2, C2, R3 (5 parameters)
-		return []float64{50.0, 1e-6, 100.0, 1e-6, 100.0}
-	case "r(q(r(qr)))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	case "r(q(r(q(r(qr)))))":
-		// R1, Q1_Y0, Q1_n, R2, Q2_Y0, Q2_n, R3, Q3_Y0, Q3_n, R4
-		return []float64{50.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0, 1e-6, 0.8, 100.0}
-	default:
-		// Generic fallback: assume 4 parameters for R(QR) since that's our default
-		log.Printf("Warning: Unknown circuit code '%s', using R(QR) 4-parameter defaults", code)
-		return []float64{50.0, 1e-6, 0.8, 100.0}
+// generateInitialValues returns the circuit library defaults for code, or generic defaults for unknown circuits.
+// The first L element starts from the inductance of the inductive loop of the spectrum when it has one.
+func (s *Server) generateInitialValues(code string, freqs []float64, impData [][2]float64) []float64 {
+	if params := circuits.Default().DefaultParams(code); params != nil {
+		return goimpcore.InitInductance(code, params, freqs, impData)
 	}
+	// Generic fallback: assume 4 parameters for R(QR) since that's our default
+	log.Printf("Warning: Unknown circuit code '%s', using R(QR) 4-parameter defaults", code)
+	return []float64{50.0, 1e-6, 0.8, 100.0}
 }
 
-// healthHandler provides a simple health check endpoint
+// healthHandler reports the state of the worker pool, 503 once it is shut
+// down or has no worker left
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	stats := s.workerPool.Stats()
+
+	status, code := "healthy", http.StatusOK
+	if stats.Closed || stats.AliveWorkers == 0 {
+		status, code = "unhealthy", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         status,
+		"timestamp":      time.Now().Format(time.RFC3339),
+		"uptime_seconds": time.Since(s.started).Seconds(),
+		"worker_pool":    stats,
+	})
+}
+
+// liveHandler reports that the process is running
+func (s *Server) liveHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"healthy","timestamp":"%s"}`, time.Now().Format(time.RFC3339))
+	fmt.Fprint(w, `{"status":"alive"}`)
+}
+
+// readyHandler reports whether the server can accept jobs, 503 with the failing
+// checks when it cannot
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	checks, ready := health.Ready(s.readiness...)
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
 }
 
 // gcHandler triggers garbage collection and returns stats
@@ -302,37 +546,146 @@ func (s *Server) memoryHandler(w http.ResponseWriter, r *http.Request) {
 		time.Now().Format(time.RFC3339))
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server and blocks until it stops serving, returning
+// nil once Shutdown closed it. The jobs and webhooks left in the queue file by
+// the previous run are resumed first.
 func (s *Server) Start() error {
+	defer close(s.shutdownCh)
+
+	if s.queueErr != nil {
+		return s.queueErr
+	}
+	if err := s.resumeQueued(); err != nil {
+		return err
+	}
+
 	// Start profiling server
 	if err := s.profiler.Start(); err != nil {
 		log.Printf("❌ Failed to start profiler: %v", err)
 	}
 
-	log.Println("🚀 Starting HTTP server on port", s.serverConfig.Port)
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	s.port.Store(int64(port))
+
+	log.Println("🚀 Starting HTTP server on port", port)
 	log.Println("📡 Endpoints available:")
-	log.Printf("  - Single: http://localhost:%s/eis-data", s.serverConfig.Port)
-	log.Printf("  - Batch:  http://localhost:%s/eis-data/batch", s.serverConfig.Port)
-	log.Printf("  - Health: http://localhost:%s/health", s.serverConfig.Port)
-	log.Printf("  - GC:     http://localhost:%s/debug/gc", s.serverConfig.Port)
-	log.Printf("  - Memory: http://localhost:%s/debug/memory", s.serverConfig.Port)
+	log.Printf("  - Single: http://localhost:%d/eis-data", port)
+	log.Printf("  - Batch:  http://localhost:%d/eis-data/batch", port)
+	log.Printf("  - Upload: http://localhost:%d/eis-data/upload", port)
+	log.Printf("  - Stream: http://localhost:%d/eis-data/stream (SSE fit, or an NDJSON batch)", port)
+	log.Printf("  - Live:   ws://localhost:%d/ws", port)
+	log.Printf("  - Health: http://localhost:%d/health", port)
+	log.Printf("  - Ready:  http://localhost:%d/ready", port)
+	log.Printf("  - GC:     http://localhost:%d/debug/gc", port)
+	log.Printf("  - Memory: http://localhost:%d/debug/memory", port)
+	if s.serverConfig.EnableMetrics {
+		log.Printf("  - Metrics: http://localhost:%d/metrics", port)
+	}
+
+	if err := s.httpServer.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// resumeQueued restarts the jobs left in the queue and queues its undelivered
+// webhooks again. A job that cannot be resumed is dropped.
+func (s *Server) resumeQueued() error {
+	pending, webhooks, err := s.queue.Pending()
+	if err != nil {
+		return fmt.Errorf("reading queue: %w", err)
+	}
+	if len(pending) > 0 || len(webhooks) > 0 {
+		log.Printf("♻️ Resuming %d queued jobs and %d undelivered webhooks", len(pending), len(webhooks))
+	}
+
+	for _, job := range pending {
+		var err error
+		switch job.Kind {
+		case jobs.KindFit:
+			err = s.eisHandler.Resume(job)
+		case jobs.KindBatch:
+			err = s.batchHandler.Resume(job)
+		default:
+			err = fmt.Errorf("unknown job kind %q", job.Kind)
+		}
+		if err != nil {
+			log.Printf("⚠️ Queued job %s not resumed, dropped: %v", job.ID, err)
+			if err := s.queue.Finish(job.ID); err != nil {
+				log.Printf("⚠️ Failed to drop queued job %s: %v", job.ID, err)
+			}
+		}
+	}
+	for _, item := range webhooks {
+		s.workerPool.QueueWebhook(item)
+	}
+	return nil
+}
+
+// Port returns the port the server listens on, the bound one when port 0
+// was configured. It is 0 until Start has opened the listener.
+func (s *Server) Port() int {
+	return int(s.port.Load())
+}
 
-	return s.httpServer.ListenAndServe()
+// Done returns a channel closed when Start returns, after Shutdown closed
+// the listener or the server failed
+func (s *Server) Done() <-chan struct{} {
+	return s.shutdownCh
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. It stops accepting requests,
+// waits up to 15s for the open ones, then blocks until the worker pool has
+// drained. The errors of the steps that failed are joined.
 func (s *Server) Shutdown() error {
 	log.Println("🛑 Shutting down server...")
+	var errs []error
+
+	// Stop accepting requests and let the open ones finish
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer httpCancel()
+	if err := s.httpServer.Shutdown(httpCtx); err != nil {
+		log.Printf("⚠️ HTTP server shutdown error: %v", err)
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+	s.stopRateLimit()
 
 	// Shutdown profiler
 	if err := s.profiler.Stop(); err != nil {
 		log.Printf("⚠️ Profiler shutdown error: %v", err)
+		errs = append(errs, fmt.Errorf("profiler: %w", err))
 	}
 
-	// Shutdown worker pool
+	// Finish the queued fits and send their webhooks
 	s.workerPool.Shutdown()
 
-	// TODO: Shutdown HTTP server gracefully
+	// Export the remaining spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.stopTracing(ctx); err != nil {
+		log.Printf("⚠️ Tracing shutdown error: %v", err)
+		errs = append(errs, fmt.Errorf("tracing: %w", err))
+	}
+
+	// The webhooks are sent, the jobs not finished stay for the next run
+	if err := s.queue.Close(); err != nil {
+		log.Printf("⚠️ Queue close error: %v", err)
+		errs = append(errs, fmt.Errorf("queue: %w", err))
+	}
+
 	log.Println("✅ Server shutdown complete")
-	return nil
+	return errors.Join(errs...)
+}
+
+// iterationLimit returns the solver restarts of a fit, maxIterations unless
+// cfg sets them
+func iterationLimit(cfg *config.Config) int {
+	if cfg.MaxIterations > 0 {
+		return cfg.MaxIterations
+	}
+	return maxIterations
 }
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/telemetry"
+)
+
+// startServer starts a quiet server on a random port with processor fitting
+// the queued jobs, its webhooks going to a sink that accepts them. configure
+// adjusts the server configuration before the start. It returns the server
+// and its base URL, the server is shut down at the end of the test. The
+// test runs in a temporary directory, batches save their reports in the
+// working directory.
+func startServer(t *testing.T, processor ProcessorFunc, configure func(*config.ServerConfig)) (*Server, string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(sink.Close)
+
+	cfg := config.DefaultConfig()
+	cfg.Quiet = true
+	serverConfig := config.DefaultServerConfig()
+	serverConfig.Port = "0"
+	serverConfig.WorkerCount = 2
+	serverConfig.WebhookURL = sink.URL
+	serverConfig.EnableMetrics = false
+	serverConfig.QuietRequests = true
+	if configure != nil {
+		configure(serverConfig)
+	}
+
+	s := New(Options{Config: cfg, ServerConfig: serverConfig, Processor: processor})
+	go func() {
+		if err := s.Start(); err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	}()
+	for deadline := time.Now().Add(5 * time.Second); s.Port() == 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+	}
+	t.Cleanup(func() { s.Shutdown() })
+	return s, fmt.Sprintf("http://127.0.0.1:%d", s.Port())
+}
+
+// testSpectrum is the JSON request of the noise-free spectrum of R(QR) from
+// 1 Hz to 100 kHz
+func testSpectrum(t *testing.T) []byte {
+	t.Helper()
+	freqs, imp, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := models.ImpedanceData{Frequencies: freqs}
+	for _, z := range imp {
+		data.Impedance = append(data.Impedance, map[string]float64{"real": z[0], "imag": z[1]})
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// readiness returns the status code and the checks of /health/ready
+func readiness(t *testing.T, baseURL string) (int, map[string]string) {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/health/ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body.Checks
+}
+
+// A worker pool with its jobs queue full makes the server not ready
+func TestReadySaturatedPool(t *testing.T) {
+	release := make(chan struct{})
+	processor := func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+		<-release
+		return goimpcore.Result{Status: goimpcore.OK, Code: cfg.Code, Params: []float64{10, 1e-5, 0.9, 100}}
+	}
+	_, baseURL := startServer(t, processor, func(c *config.ServerConfig) { c.WorkerCount = 1 })
+	var once sync.Once
+	releaseBatch := func() { once.Do(func() { close(release) }) }
+	defer releaseBatch()
+
+	if _, checks := readiness(t, baseURL); checks["worker_pool"] != "ok" {
+		t.Fatalf("idle worker pool check %q, want ok", checks["worker_pool"])
+	}
+
+	// One spectrum of a batch running and two filling the queue of the single worker
+	var data models.ImpedanceData
+	if err := json.Unmarshal(testSpectrum(t), &data); err != nil {
+		t.Fatal(err)
+	}
+	batch := models.ImpedanceBatch{BatchID: "saturating"}
+	for i := 1; i <= 3; i++ {
+		batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: data, Iteration: i})
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(baseURL+"/eis-data/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("batch status %d", resp.StatusCode)
+	}
+
+	var code int
+	var checks map[string]string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if code, checks = readiness(t, baseURL); checks["worker_pool"] != "ok" {
+			break
+		}
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status %d with a saturated pool, want %d", code, http.StatusServiceUnavailable)
+	}
+	if checks["worker_pool"] == "ok" {
+		t.Errorf("worker pool check ok with its queue full")
+	}
+
+	// The batch saves its reports in the test directory before the test ends
+	releaseBatch()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var batch models.BatchResult
+		resp, err := http.Get(baseURL + "/batches/saturating")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err == nil && batch.CompletedAt != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch did not complete")
+		}
+	}
+}
+
+// The pprof index is served on the main port only when enabled
+func TestPprofOnMainPort(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprint(enabled), func(t *testing.T) {
+			_, baseURL := startServer(t, nil, func(c *config.ServerConfig) { c.EnableProfilingOnMainPort = enabled })
+			resp, err := http.Get(baseURL + "/debug/pprof/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !enabled {
+				if resp.StatusCode != http.StatusNotFound {
+					t.Errorf("status %d with profiling disabled, want %d", resp.StatusCode, http.StatusNotFound)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("Content-Type %q, want text/html", ct)
+			}
+			for _, want := range []string{"<html>", "/debug/pprof/", "heap", "goroutine"} {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("index without %q:\n%s", want, body)
+				}
+			}
+		})
+	}
+}
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []telemetry.SpanData
+}
+
+func (e *memoryExporter) Export(ctx context.Context, spans []telemetry.SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+// An asynchronous fit continues the trace of the traceparent header: the
+// request span is the child of the remote span, the solve and the webhook
+// are children of the request, and the webhook carries its own span
+func TestTracingParentChild(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := telemetry.NewTracer(exporter)
+	telemetry.SetTracer(tracer)
+	defer telemetry.SetTracer(nil)
+
+	traceparents := make(chan string, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get(telemetry.TraceparentHeader)
+	}))
+	defer sink.Close()
+	s, baseURL := startServer(t, nil, func(c *config.ServerConfig) { c.WebhookURL = sink.URL })
+
+	const traceID, remoteID = "4bf92f3577b34ca7b2a7b1c0f4e8a9d1", "00f067aa0ba902b7"
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/eis-data", bytes.NewReader(testSpectrum(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(telemetry.TraceparentHeader, "00-"+traceID+"-"+remoteID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+
+	var traceparent string
+	select {
+	case traceparent = <-traceparents:
+	case <-time.After(30 * time.Second):
+		t.Fatal("no webhook")
+	}
+	// The webhook span ends once the client has read the response
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, total := s.webhookClient.RecentFailures(1); total > 0 {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string][]telemetry.SpanData)
+	for _, span := range exporter.spans {
+		if span.Context.TraceID.String() != traceID {
+			t.Errorf("span %s in trace %s, want %s", span.Name, span.Context.TraceID, traceID)
+		}
+		byName[span.Name] = append(byName[span.Name], span)
+	}
+	requests, solves, webhooks := byName[telemetry.SpanHTTPRequest], byName[telemetry.SpanSolve], byName[telemetry.SpanWebhookSend]
+	if len(requests) != 1 || len(solves) == 0 || len(webhooks) != 1 {
+		t.Fatalf("%d request, %d solve and %d webhook spans, want 1, some and 1", len(requests), len(solves), len(webhooks))
+	}
+	request := requests[0]
+	if request.Parent.String() != remoteID {
+		t.Errorf("request span parent %s, want the remote span %s", request.Parent, remoteID)
+	}
+	for _, solve := range solves {
+		if solve.Parent != request.Context.SpanID {
+			t.Errorf("solve span parent %s, want the request span %s", solve.Parent, request.Context.SpanID)
+		}
+	}
+	solveIDs := make(map[telemetry.SpanID]bool)
+	for _, solve := range solves {
+		solveIDs[solve.Context.SpanID] = true
+	}
+	for _, eval := range byName[telemetry.SpanChiSquareEval] {
+		if !solveIDs[eval.Parent] {
+			t.Errorf("chi-square span parent %s, want a solve span", eval.Parent)
+		}
+	}
+	webhook := webhooks[0]
+	if webhook.Parent != request.Context.SpanID {
+		t.Errorf("webhook span parent %s, want the request span %s", webhook.Parent, request.Context.SpanID)
+	}
+	if want := "00-" + traceID + "-" + webhook.Context.SpanID.String() + "-01"; traceparent != want {
+		t.Errorf("webhook traceparent %q, want %q", traceparent, want)
+	}
+}
+
+// bigSpectrum is a JSON spectrum request of about size bytes
+func bigSpectrum(size int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"frequencies":[`)
+	for b.Len() < size {
+		b.WriteString("1000.5,")
+	}
+	b.WriteString(`1],"impedance":[]}`)
+	return b.Bytes()
+}
+
+// A 15 MB body gets 413 before it is decoded, whether it declares its length
+// or is sent in chunks, and the server keeps serving
+func TestRequestBodyTooLarge(t *testing.T) {
+	_, baseURL := startServer(t, nil, nil)
+	body := bigSpectrum(15 << 20)
+
+	for _, chunked := range []bool{false, true} {
+		t.Run(fmt.Sprintf("chunked=%v", chunked), func(t *testing.T) {
+			var r io.Reader = bytes.NewReader(body)
+			if chunked {
+				r = io.MultiReader(r) // hides the length
+			}
+			req, err := http.NewRequest(http.MethodPost, baseURL+"/eis-data", r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+			}
+			var errBody map[string]string
+			if err := json.NewDecoder(resp.Body).Decode(&errBody); err != nil || errBody["error"] == "" {
+				t.Errorf("body %v (%v), want a JSON error", errBody, err)
+			}
+		})
+	}
+
+	resp, err := http.Post(baseURL+"/eis-data", "application/json", bytes.NewReader(testSpectrum(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status %d after the large requests, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+// Requests within the body limit are still capped in spectra and points
+func TestDecodedRequestLimits(t *testing.T) {
+	_, baseURL := startServer(t, nil, func(c *config.ServerConfig) {
+		c.MaxBatchSpectra = 2
+		c.MaxFrequencyPoints = 10
+	})
+	var data models.ImpedanceData
+	if err := json.Unmarshal(testSpectrum(t), &data); err != nil {
+		t.Fatal(err)
+	}
+	batch := models.ImpedanceBatch{BatchID: "limited"}
+	for i := 1; i <= 3; i++ {
+		batch.Spectra = append(batch.Spectra, models.BatchItem{ImpedanceData: data, Iteration: i})
+	}
+	batchBody, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, body := range map[string][]byte{"/eis-data": testSpectrum(t), "/eis-data/batch": batchBody} {
+		resp, err := http.Post(baseURL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+// A server on port 0 binds a free port, reports it and fits a spectrum
+// posted to it
+func TestServerRandomPort(t *testing.T) {
+	s, _ := startServer(t, nil, nil)
+	port := s.Port()
+	if port <= 0 || port > 65535 {
+		t.Fatalf("Port() = %d", port)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%d/eis-data/sync", port), "application/json", bytes.NewReader(testSpectrum(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var res models.FitResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != models.StatusCompleted || len(res.Parameters) != 4 {
+		t.Errorf("status %s with parameters %v, want a completed R(QR) fit", res.Status, res.Parameters)
+	}
+}
+
+// A request still being received when Shutdown is called completes with its
+// fit before Shutdown returns, and the server takes no new connections
+func TestShutdownWaitsForRequest(t *testing.T) {
+	s, baseURL := startServer(t, nil, nil)
+	body := testSpectrum(t)
+
+	// Half the body is sent, the request is in flight
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /eis-data/sync HTTP/1.1\r\nHost: %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n", conn.RemoteAddr(), len(body))
+	if _, err := conn.Write(body[:len(body)/2]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // for the server to read the headers
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown() }()
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if _, err := conn.Write(body[len(body)/2:]); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res models.FitResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || resp.StatusCode != http.StatusOK || res.Status != models.StatusCompleted {
+		t.Fatalf("in-flight request: status %d, fit %s, %v", resp.StatusCode, res.Status, err)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+
+	if resp, err := http.Get(baseURL + "/health"); err == nil {
+		resp.Body.Close()
+		t.Error("the server still accepts requests")
+	}
+}
+
+// The fitting routes are profiled under their names, streamed batches apart
+// from streamed fits, and the other routes not at all
+func TestProfileName(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/eis-data", "/eis-data/sync", "/eis-data/stream", "/eis-data/batch", "/eis-data/batch/", "/health"} {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+
+	tests := []struct {
+		path, contentType, want string
+	}{
+		{"/eis-data", "application/json", "eis-single"},
+		{"/eis-data/sync", "application/json", "eis-sync"},
+		{"/eis-data/stream", "application/json", "eis-stream"},
+		{"/eis-data/stream", "application/x-ndjson", "eis-batch-stream"},
+		{"/eis-data/batch", "application/json", "eis-batch"},
+		{"/eis-data/batch/b1", "", ""},
+		{"/health", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := profileName(mux, r); got != tt.want {
+			t.Errorf("%s (%s): profile %q, want %q", tt.path, tt.contentType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// Defaults of the in-memory store
+const (
+	DefaultTTL        = time.Hour
+	DefaultMaxEntries = 1000
+)
+
+// Store keeps fit results for retrieval over HTTP, keyed by request and
+// batch ID. Results are put once when accepted, pending, and again when done.
+// It also maps the idempotency keys of submissions to the ID they were
+// accepted under.
+type Store interface {
+	PutResult(result models.FitResult)
+	Result(requestID string) (models.FitResult, bool)
+	PutBatch(batch models.BatchResult)
+	Batch(batchID string) (models.BatchResult, bool)
+	PutTimeSeries(series models.TimeSeries)
+	TimeSeries(batchID string) (models.TimeSeries, bool)
+	// ClaimKey stores id under the idempotency key unless another ID is
+	// stored under it, that one is then returned with claimed false
+	ClaimKey(key, id string) (owner string, claimed bool)
+	// ReleaseKey removes the idempotency key, for a submission that was not
+	// accepted after all
+	ReleaseKey(key string)
+}
+
+// Memory is an in-memory Store. Entries expire ttl after their last put and
+// the least recently used entry is evicted beyond maxEntries, results,
+// batches and idempotency keys counted together.
+type Memory struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // most recently used first
+	now     func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewMemory creates an in-memory store, DefaultTTL and DefaultMaxEntries are
+// used for values <= 0
+func NewMemory(ttl time.Duration, maxEntries int) *Memory {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Memory{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// PutResult stores result under its RequestID
+func (m *Memory) PutResult(result models.FitResult) {
+	m.put("result/"+result.RequestID, result)
+}
+
+// Result returns the result stored under requestID
+func (m *Memory) Result(requestID string) (models.FitResult, bool) {
+	v, ok := m.get("result/" + requestID)
+	if !ok {
+		return models.FitResult{}, false
+	}
+	return v.(models.FitResult), true
+}
+
+// PutBatch stores batch under its BatchID
+func (m *Memory) PutBatch(batch models.BatchResult) {
+	m.put("batch/"+batch.BatchID, batch)
+}
+
+// Batch returns the batch stored under batchID
+func (m *Memory) Batch(batchID string) (models.BatchResult, bool) {
+	v, ok := m.get("batch/" + batchID)
+	if !ok {
+		return models.BatchResult{}, false
+	}
+	return v.(models.BatchResult), true
+}
+
+// PutTimeSeries stores the parameter time series of a batch under its BatchID
+func (m *Memory) PutTimeSeries(series models.TimeSeries) {
+	m.put("timeseries/"+series.BatchID, series)
+}
+
+// TimeSeries returns the parameter time series stored under batchID
+func (m *Memory) TimeSeries(batchID string) (models.TimeSeries, bool) {
+	v, ok := m.get("timeseries/" + batchID)
+	if !ok {
+		return models.TimeSeries{}, false
+	}
+	return v.(models.TimeSeries), true
+}
+
+// ClaimKey stores id under the idempotency key unless another ID is stored
+// under it
+func (m *Memory) ClaimKey(key, id string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries["key/"+key]; ok {
+		if e := el.Value.(*entry); !m.now().After(e.expires) {
+			m.lru.MoveToFront(el)
+			return e.value.(string), false
+		}
+	}
+	m.putLocked("key/"+key, id)
+	return id, true
+}
+
+// ReleaseKey removes the idempotency key
+func (m *Memory) ReleaseKey(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries["key/"+key]; ok {
+		m.remove(el)
+	}
+}
+
+// Len returns the number of stored entries, expired ones included until they
+// are looked up or evicted
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *Memory) put(key string, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.putLocked(key, value)
+}
+
+func (m *Memory) putLocked(key string, value interface{}) {
+	expires := m.now().Add(m.ttl)
+	if el, ok := m.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		m.lru.MoveToFront(el)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(&entry{key: key, value: value, expires: expires})
+	for m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *Memory) get(key string) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if m.now().After(e.expires) {
+		m.remove(el)
+		return nil, false
+	}
+	m.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.entries, el.Value.(*entry).key)
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+func TestMemoryExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Minute, 10)
+	m.now = func() time.Time { return now }
+
+	m.PutResult(models.FitResult{RequestID: "a", Status: models.StatusPending})
+	now = now.Add(50 * time.Second)
+	// A second put, the completed fit, restarts the TTL
+	m.PutResult(models.FitResult{RequestID: "a", Status: models.StatusCompleted})
+	now = now.Add(50 * time.Second)
+	if res, ok := m.Result("a"); !ok || res.Status != models.StatusCompleted {
+		t.Fatalf("Result(a) = %v, %v, want the completed fit", res.Status, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := m.Result("a"); ok {
+		t.Error("expired result still found")
+	}
+	if m.Len() != 0 {
+		t.Errorf("%d entries after the expired lookup, want 0", m.Len())
+	}
+}
+
+// Beyond maxEntries the least recently put or looked up entry goes
+func TestMemoryLRUEviction(t *testing.T) {
+	m := NewMemory(time.Hour, 3)
+	m.PutResult(models.FitResult{RequestID: "a"})
+	m.PutBatch(models.BatchResult{BatchID: "b"})
+	m.PutResult(models.FitResult{RequestID: "c"})
+	m.Result("a") // b is now the least recently used
+	m.PutResult(models.FitResult{RequestID: "d"})
+
+	if m.Len() != 3 {
+		t.Errorf("%d entries, want 3", m.Len())
+	}
+	if _, ok := m.Batch("b"); ok {
+		t.Error("least recently used batch b not evicted")
+	}
+	for _, id := range []string{"a", "c", "d"} {
+		if _, ok := m.Result(id); !ok {
+			t.Errorf("result %s evicted", id)
+		}
+	}
+}
+
+// Results and batches with the same ID do not overwrite each other
+func TestMemoryKinds(t *testing.T) {
+	m := NewMemory(0, 0)
+	m.PutResult(models.FitResult{RequestID: "x", Status: models.StatusCompleted})
+	m.PutBatch(models.BatchResult{BatchID: "x", Status: models.StatusPending})
+	if res, ok := m.Result("x"); !ok || res.Status != models.StatusCompleted {
+		t.Errorf("Result(x) = %v, %v", res.Status, ok)
+	}
+	if batch, ok := m.Batch("x"); !ok || batch.Status != models.StatusPending {
+		t.Errorf("Batch(x) = %v, %v", batch.Status, ok)
+	}
+	if key, ok := m.ClaimKey("k", "x"); !ok || key != "x" {
+		t.Errorf("ClaimKey(k, x) = %v, %v", key, ok)
+	}
+	if key, ok := m.ClaimKey("k", "y"); ok || key != "x" {
+		t.Errorf("second ClaimKey(k, y) = %v, %v, want x and false", key, ok)
+	}
+}
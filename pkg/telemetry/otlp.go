@@ -0,0 +1,166 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector, Jaeger or any other
+// backend accepting OTLP/HTTP with JSON encoding
+type OTLPExporter struct {
+	url        string
+	service    string
+	httpClient *http.Client
+}
+
+// NewOTLPExporter creates an exporter for endpoint, e.g. http://jaeger:4318.
+// The /v1/traces path is appended unless endpoint already has it.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &OTLPExporter{
+		url:        url,
+		service:    service,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export implements the Exporter interface
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of ExportTraceServiceRequest, identifiers are hex and
+// 64 bit integers decimal strings
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// OTLP span kinds and status codes
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+
+	statusOK    = 1
+	statusError = 2
+)
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              spanKind(s.Name),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        keyValues(s.Attributes),
+			Status:            otlpStatus{Code: statusOK},
+		}
+		if s.Parent.IsValid() {
+			out[i].ParentSpanID = s.Parent.String()
+		}
+		if s.Err != nil {
+			out[i].Status = otlpStatus{Code: statusError, Message: s.Err.Error()}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: keyValues([]Attribute{String("service.name", e.service)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/kacperjurak/goimpcore"}, Spans: out}},
+	}}}
+}
+
+func spanKind(name string) int {
+	switch name {
+	case SpanHTTPRequest:
+		return kindServer
+	case SpanWebhookSend:
+		return kindClient
+	}
+	return kindInternal
+}
+
+func keyValues(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var value map[string]interface{}
+		switch v := a.Value.(type) {
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: value})
+	}
+	return kvs
+}
@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// Extract returns ctx carrying the remote span context of a valid traceparent
+// header, ctx itself when there is none
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := parseTraceparent(header.Get(TraceparentHeader))
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Inject sets the traceparent header for the span context of ctx
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags))
+}
+
+// parseTraceparent parses version 00 "00-<trace id>-<span id>-<flags>" values,
+// later versions are read the same way as the spec asks
+func parseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Middleware starts an http_request span for every request, continuing the
+// trace of an incoming traceparent header. Handlers find the span context in
+// r.Context().
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, span := Start(Extract(r.Context(), r.Header), SpanHTTPRequest,
+			String("http.method", r.Method),
+			String("http.target", r.URL.Path),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(Int("http.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", rec.status, http.StatusText(rec.status)))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush supports streaming handlers
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,50 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-01-extra", true, true},
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34ca7b2a7b1c0f4e8a9zz-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := parseTraceparent(tt.value)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("parseTraceparent(%q) = %+v, %v, want ok %v sampled %v", tt.value, sc, ok, tt.ok, tt.sampled)
+		}
+	}
+}
+
+// Inject writes back the span context Extract read
+func TestInjectExtract(t *testing.T) {
+	const value = "00-4bf92f3577b34ca7b2a7b1c0f4e8a9d1-00f067aa0ba902b7-01"
+	in := http.Header{}
+	in.Set(TraceparentHeader, value)
+	out := http.Header{}
+	Inject(Extract(context.Background(), in), out)
+	if got := out.Get(TraceparentHeader); got != value {
+		t.Errorf("traceparent %q, want %q", got, value)
+	}
+
+	none := http.Header{}
+	Inject(context.Background(), none)
+	if got := none.Get(TraceparentHeader); got != "" {
+		t.Errorf("traceparent %q without a span context", got)
+	}
+}
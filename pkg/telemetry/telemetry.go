@@ -0,0 +1,332 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	mrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span names used by the server and the solver
+const (
+	SpanHTTPRequest   = "http_request"
+	SpanSolve         = "eis_solve"
+	SpanChiSquareEval = "chi_square_eval"
+	SpanWebhookSend   = "webhook_send"
+)
+
+// ChiSquareSampleRate is the fraction of objective evaluations that get a span
+const ChiSquareSampleRate = 0.01
+
+// Batching of finished spans
+const (
+	queueSize     = 2048
+	batchSize     = 256
+	flushInterval = 5 * time.Second
+)
+
+// TraceID identifies a trace, W3C trace context format
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether t is not all zeros
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// IsValid reports whether s is not all zeros
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both identifiers are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Attribute is a key-value pair attached to a span
+type Attribute struct {
+	Key   string
+	Value interface{} // string, int64, float64 or bool
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return Attribute{key, int64(value)} }
+
+// Float64 returns a floating point attribute
+func Float64(key string, value float64) Attribute { return Attribute{key, value} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// SpanData is a finished span as handed to an Exporter
+type SpanData struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Err        error
+}
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Span is an operation in progress. A nil Span, returned while tracing is
+// disabled, ignores every call.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the identifiers of s
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttributes adds attributes to s
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks s as failed with err, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Err = err
+	s.mu.Unlock()
+}
+
+// End finishes s and queues it for export, later calls do nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+// Tracer batches finished spans and hands them to an Exporter
+type Tracer struct {
+	exporter Exporter
+	queue    chan SpanData
+	done     chan struct{}
+	wg       sync.WaitGroup
+	dropped  atomic.Int64
+}
+
+// NewTracer creates a tracer exporting through exporter
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	t.wg.Add(1)
+	go t.run()
+	return t
+}
+
+// enqueue queues a finished span, spans are dropped while the queue is full
+// so tracing never blocks request processing
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports queued spans in batches until Shutdown
+func (t *Tracer) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			log.Printf("⚠️ Trace export of %d spans failed: %v", len(batch), err)
+		}
+		cancel()
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			if n := t.dropped.Swap(0); n > 0 {
+				log.Printf("⚠️ Trace queue full, dropped %d spans", n)
+			}
+		case <-t.done:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown exports the remaining spans and stops the tracer
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	close(t.done)
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start creates a span below the span context of ctx, or a new trace
+func (t *Tracer) start(ctx context.Context, name string, attrs []Attribute) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() && !parent.Sampled {
+		return ctx, nil
+	}
+
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: true}
+	if !parent.IsValid() {
+		rand.Read(sc.TraceID[:])
+	}
+	rand.Read(sc.SpanID[:])
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Context:    sc,
+			Parent:     parent.SpanID,
+			Start:      time.Now(),
+			Attributes: attrs,
+		},
+	}
+	return ContextWithSpanContext(ctx, sc), span
+}
+
+var global atomic.Pointer[Tracer]
+
+// SetTracer installs t as the tracer used by Start, nil disables tracing
+func SetTracer(t *Tracer) {
+	global.Store(t)
+}
+
+// Enabled reports whether a tracer is installed
+func Enabled() bool {
+	return global.Load() != nil
+}
+
+// Init installs a tracer exporting to the OTLP/HTTP endpoint. With an empty
+// endpoint tracing stays disabled and the returned shutdown does nothing.
+func Init(endpoint, service string) (shutdown func(context.Context) error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	t := NewTracer(NewOTLPExporter(endpoint, service))
+	SetTracer(t)
+	log.Printf("🔭 Tracing enabled, exporting to %s", endpoint)
+
+	return func(ctx context.Context) error {
+		SetTracer(nil)
+		return t.Shutdown(ctx)
+	}
+}
+
+// Start begins a span named name as a child of the span in ctx. It returns
+// ctx unchanged and a nil span while tracing is disabled.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	t := global.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	return t.start(ctx, name, attrs)
+}
+
+// StartSampled is Start for hot paths, only a fraction rate of the calls
+// create a span
+func StartSampled(ctx context.Context, name string, rate float64, attrs ...Attribute) (context.Context, *Span) {
+	t := global.Load()
+	if t == nil || mrand.Float64() >= rate {
+		return ctx, nil
+	}
+	return t.start(ctx, name, attrs)
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, the zero
+// value when there is none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
@@ -2,6 +2,7 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,11 +10,17 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/internal/utils"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/formalism"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
 	"github.com/kacperjurak/goimpcore/pkg/models"
+	"github.com/kacperjurak/goimpcore/pkg/telemetry"
 )
 
 // Client handles webhook HTTP requests with optimized connection pooling
@@ -22,10 +29,33 @@ type Client struct {
 	httpClient *http.Client
 	config     *config.Config
 	bufferPool sync.Pool // Pool for JSON marshaling buffers
+
+	historyMu sync.Mutex
+	history   []bool // outcomes of the most recent sends, true for failures
+
+	// Retry controls the delivery attempts of every webhook, the webhooks
+	// still failing after them are kept in DeadLetters
+	Retry       RetryPolicy
+	DeadLetters *DeadLetterQueue
+
+	// Secret, when set, signs every webhook with SignatureHeader and
+	// TimestampHeader so that receivers can check it with VerifyRequest
+	Secret string
+
+	compress    bool // see WithCompression
+	compression compression
 }
 
+// IdempotencyKeyHeader carries the ID of a webhook, the same for its retries,
+// its replays and its redelivery after a restart, so that receivers can drop
+// the ones already processed
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// historySize is the number of send outcomes kept for RecentFailures
+const historySize = 100
+
 // NewClient creates a new webhook client with optimized connection pooling
-func NewClient(url string, cfg *config.Config) *Client {
+func NewClient(url string, cfg *config.Config, opts ...ClientOption) *Client {
 	// Create optimized transport with connection pooling
 	transport := &http.Transport{
 		// Connection pooling settings
@@ -56,8 +86,10 @@ func NewClient(url string, cfg *config.Config) *Client {
 	}
 
 	client := &Client{
-		url:    url,
-		config: cfg,
+		url:         url,
+		config:      cfg,
+		Retry:       DefaultRetryPolicy(),
+		DeadLetters: NewDeadLetterQueue(0, ""),
 		httpClient: &http.Client{
 			Timeout:   45 * time.Second, // Total request timeout
 			Transport: transport,
@@ -69,40 +101,113 @@ func NewClient(url string, cfg *config.Config) *Client {
 			},
 		},
 	}
+	for _, opt := range opts {
+		opt(client)
+	}
 
 	return client
 }
 
 // Send sends a webhook with the provided data
 func (c *Client) Send(webhook models.WebhookItem) error {
+	ctx := webhook.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := telemetry.Start(ctx, telemetry.SpanWebhookSend,
+		telemetry.String("request_id", webhook.RequestID),
+		telemetry.String("http.url", c.url),
+	)
+	defer span.End()
+
+	err := c.send(ctx, webhook)
+	span.RecordError(err)
+	c.record(err)
+	metrics.ObserveWebhook(err)
+	return err
+}
+
+// RecentFailures returns how many of the last n sends failed and how many sends
+// that window holds
+func (c *Client) RecentFailures(n int) (failed, total int) {
+	c.historyMu.Lock()
+	defer c.historyMu.Unlock()
+
+	if n > len(c.history) {
+		n = len(c.history)
+	}
+	for _, f := range c.history[len(c.history)-n:] {
+		if f {
+			failed++
+		}
+	}
+	return failed, n
+}
+
+// record appends the outcome of a send to the history
+func (c *Client) record(err error) {
+	c.historyMu.Lock()
+	defer c.historyMu.Unlock()
+
+	c.history = append(c.history, err != nil)
+	if len(c.history) > historySize {
+		c.history = c.history[len(c.history)-historySize:]
+	}
+}
+
+func (c *Client) send(ctx context.Context, webhook models.WebhookItem) error {
 	// Validate and clean data for JSON marshaling
 	validChiSquare := c.sanitizeFloat(webhook.ChiSquare)
 	if validChiSquare != webhook.ChiSquare {
 		log.Printf("Warning: Chi-square sanitized from %v to %v", webhook.ChiSquare, validChiSquare)
 	}
 
+	// Transform data into the configured formalism
+	realImp, imagImp, elementImpedances, outFormalism := c.convertFormalism(webhook)
+
+	residualsReal, residualsImag := c.splitResiduals(webhook.Residuals)
+
 	// Create webhook response payload
 	payload := models.WebhookResponse{
+		Type:               models.EventSpectrumResult,
 		ID:                 webhook.RequestID,
+		RequestID:          utils.RequestID(ctx),
+		Status:             models.StatusCompleted,
+		Error:              webhook.Error,
 		Time:               time.Now().Format(time.RFC3339Nano),
 		ChiSquare:          validChiSquare,
-		RealImpedance:      webhook.RealImp,
-		ImaginaryImpedance: webhook.ImagImp,
+		RealImpedance:      realImp,
+		ImaginaryImpedance: imagImp,
 		Frequencies:        webhook.Freqs,
 		Parameters:         webhook.Params,
+		NamedParameters:    c.namedParameters(webhook.CircuitCode, webhook.Params),
 		ElementNames:       webhook.Elements,
-		ElementImpedances:  webhook.ElementImpedances,
+		ElementImpedances:  elementImpedances,
 		CircuitType:        webhook.CircuitCode,
+		Formalism:          outFormalism,
+		FitStats:           c.sanitizeStats(webhook.Stats),
+		FitQuality:         c.sanitizeQuality(webhook.Quality),
+		ResidualsReal:      residualsReal,
+		ResidualsImag:      residualsImag,
+		Warnings:           webhook.Warnings,
+
+		ElementContributions: c.sanitizeContributions(goimpcore.ElementContributions(webhook.CircuitCode, webhook.Freqs, webhook.Params)),
+		ParameterInfo:        goimpcore.ParameterInfo(webhook.CircuitCode),
+		CircuitRanking:       c.sanitizeRanking(webhook.Ranking),
+		Sensitivity:          c.sanitizeSensitivity(webhook.Sensitivity),
+		InitSource:           webhook.InitSource,
 	}
 
-	// Get buffer from pool and marshal to JSON
-	buf := c.bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()                 // Clear buffer
-	defer c.bufferPool.Put(buf) // Return to pool
+	if webhook.InductiveLoop != nil {
+		inductance := c.sanitizeFloat(webhook.InductiveLoop.Inductance)
+		payload.InductiveLoopDetected, payload.EstimatedL = true, &inductance
+	}
 
-	encoder := json.NewEncoder(buf)
-	if err := encoder.Encode(payload); err != nil {
-		return fmt.Errorf("failed to marshal webhook data: %w", err)
+	switch {
+	case webhook.TimedOut:
+		payload.Status = models.StatusTimeout
+	case webhook.Failed:
+		payload.Status = models.StatusFailed
 	}
 
 	// Log debug information if not in quiet mode
@@ -111,25 +216,300 @@ func (c *Client) Send(webhook models.WebhookItem) error {
 			payload.CircuitType, payload.ElementNames)
 	}
 
-	// Send HTTP request with pooled buffer
-	resp, err := c.httpClient.Post(c.url, "application/json", bytes.NewReader(buf.Bytes()))
+	status, err := c.deliver(ctx, webhook.RequestID, payload)
 	if err != nil {
-		return fmt.Errorf("failed to send webhook: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
 	// Log success if not in quiet mode
 	if !c.config.Quiet {
 		log.Printf("Webhook sent - ID: %s, Chi-square: %.14e, CircuitType: %s, Status: %d",
-			webhook.RequestID, webhook.ChiSquare, webhook.CircuitCode, resp.StatusCode)
+			webhook.RequestID, webhook.ChiSquare, webhook.CircuitCode, status)
+	}
+	return nil
+}
+
+// SendBatchComplete sends the aggregate statistics of a completed batch
+func (c *Client) SendBatchComplete(event models.BatchCompleteEvent) error {
+	ctx := event.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := telemetry.Start(ctx, telemetry.SpanWebhookSend,
+		telemetry.String("batch_id", event.BatchID),
+		telemetry.String("http.url", c.url),
+	)
+	defer span.End()
+
+	status, err := c.deliver(ctx, event.BatchID, event)
+	span.RecordError(err)
+	c.record(err)
+	metrics.ObserveWebhook(err)
+	if err == nil && !c.config.Quiet {
+		log.Printf("Batch complete sent - ID: %s, Succeeded: %d/%d, Status: %d", event.BatchID, event.Succeeded, event.Spectra, status)
+	}
+	return err
+}
+
+// deliver sends payload as JSON to the webhook URL following the retry
+// policy and returns the last response status. The payload of id goes to the
+// dead letters when every attempt failed.
+func (c *Client) deliver(ctx context.Context, id string, payload interface{}) (int, error) {
+	// Get buffer from pool and marshal to JSON
+	buf := c.bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()                 // Clear buffer
+	defer c.bufferPool.Put(buf) // Return to pool
+
+	encoder := json.NewEncoder(buf)
+	if err := encoder.Encode(payload); err != nil {
+		return 0, fmt.Errorf("failed to marshal webhook data: %w", err)
+	}
+	return c.deliverBody(ctx, id, buf.Bytes())
+}
+
+// deliverBody sends the JSON body of id following the retry policy
+func (c *Client) deliverBody(ctx context.Context, id string, body []byte) (int, error) {
+	maxAttempts := c.Retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	attempt := 1
+	status, err := c.post(ctx, id, body)
+	for err != nil && attempt < maxAttempts && retryable(ctx, status, err) {
+		wait := c.Retry.backoff(attempt)
+		if !c.config.Quiet {
+			log.Printf("Webhook %s attempt %d/%d failed: %v, retrying in %v", id, attempt, maxAttempts, err, wait)
+		}
+		if waitErr := sleepContext(ctx, wait); waitErr != nil {
+			err = fmt.Errorf("%v, retry abandoned: %w", err, waitErr)
+			break
+		}
+		attempt++
+		metrics.WebhookRetries.Inc()
+		status, err = c.post(ctx, id, body)
+	}
+
+	if err != nil && c.DeadLetters != nil {
+		log.Printf("⚠️  Webhook %s undelivered after %d attempts, kept as dead letter: %v", id, attempt, err)
+		c.DeadLetters.Add(DeadLetter{
+			ID:       id,
+			Time:     time.Now(),
+			Attempts: attempt,
+			Error:    err.Error(),
+			Payload:  json.RawMessage(bytes.TrimSpace(bytes.Clone(body))),
+		})
+	}
+	return status, err
+}
+
+// Replay resends the dead letters, the ones failing again are kept as new
+// dead letters
+func (c *Client) Replay(ctx context.Context) (delivered, failed int) {
+	if c.DeadLetters == nil {
+		return 0, 0
+	}
+	for _, letter := range c.DeadLetters.take() {
+		if _, err := c.deliverBody(ctx, letter.ID, letter.Payload); err != nil {
+			failed++
+			continue
+		}
+		delivered++
+	}
+	return delivered, failed
+}
+
+// post sends the JSON body of id to the webhook URL, compressed when the
+// client compresses, and returns the response status, statuses from 400 up
+// are errors
+func (c *Client) post(ctx context.Context, id string, body []byte) (int, error) {
+	if !c.shouldCompress(len(body)) {
+		return c.postEncoded(ctx, id, body, false)
+	}
+	status, err := c.postEncoded(ctx, id, body, true)
+	if err != nil && rejectsCompression(status) {
+		log.Printf("Webhook receiver %s does not accept gzip bodies, sending them uncompressed", c.url)
+		c.compression.unsupported.Store(true)
+		return c.postEncoded(ctx, id, body, false)
+	}
+	return status, err
+}
+
+// postEncoded sends the body of id once, gzip compressed when gzipped is set.
+// The signature always covers the uncompressed body.
+func (c *Client) postEncoded(ctx context.Context, id string, body []byte, gzipped bool) (int, error) {
+	content := body
+	if gzipped {
+		compressed, err := gzipBody(body)
+		if err != nil {
+			return 0, fmt.Errorf("failed to compress webhook: %w", err)
+		}
+		content = compressed
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(content))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
 	}
+	req.Header.Set(IdempotencyKeyHeader, id)
+	if requestID := utils.RequestID(ctx); requestID != "" {
+		req.Header.Set(utils.RequestIDHeader, requestID)
+	}
+	if c.Secret != "" {
+		// Signed per attempt, a retry carries the time it was sent at
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(body, c.Secret))
+	}
+	telemetry.Inject(ctx, req.Header)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
 
 	// Check for HTTP errors
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("webhook request failed with status %d", resp.StatusCode)
+		return resp.StatusCode, fmt.Errorf("webhook request failed with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// convertFormalism transforms measured and element impedances into the configured formalism.
+// On failure the data is sent unchanged as impedance.
+func (c *Client) convertFormalism(webhook models.WebhookItem) ([]float64, []float64, []models.ElementImpedance, string) {
+	f := c.config.Formalism
+	if f == "" || f == formalism.Impedance {
+		return webhook.RealImp, webhook.ImagImp, webhook.ElementImpedances, formalism.Impedance
 	}
 
-	return nil
+	realImp, imagImp, err := formalism.ConvertParts(f, webhook.RealImp, webhook.ImagImp, webhook.Freqs, c.config.C0)
+	if err != nil {
+		log.Printf("Warning: Formalism conversion failed (%v), sending impedance data", err)
+		return webhook.RealImp, webhook.ImagImp, webhook.ElementImpedances, formalism.Impedance
+	}
+	c.sanitizeSlice(realImp)
+	c.sanitizeSlice(imagImp)
+
+	elementImpedances := make([]models.ElementImpedance, len(webhook.ElementImpedances))
+	for i, elem := range webhook.ElementImpedances {
+		data := make([][2]float64, len(elem.Impedances))
+		for j, imp := range elem.Impedances {
+			data[j] = [2]float64{imp["real"], imp["imag"]}
+		}
+		converted, err := formalism.Convert(f, data, webhook.Freqs, c.config.C0)
+		if err != nil {
+			log.Printf("Warning: Formalism conversion failed for element %s (%v), sending impedance data", elem.Name, err)
+			return webhook.RealImp, webhook.ImagImp, webhook.ElementImpedances, formalism.Impedance
+		}
+		impedances := make([]map[string]float64, len(converted))
+		for j, v := range converted {
+			impedances[j] = map[string]float64{
+				"real": c.sanitizeFloat(v[0]),
+				"imag": c.sanitizeFloat(v[1]),
+			}
+		}
+		elementImpedances[i] = models.ElementImpedance{Name: elem.Name, Path: elem.Path, Impedances: impedances}
+	}
+
+	return realImp, imagImp, elementImpedances, f
+}
+
+// splitResiduals splits residuals into sanitized real and imaginary slices
+func (c *Client) splitResiduals(residuals [][2]float64) ([]float64, []float64) {
+	if len(residuals) == 0 {
+		return nil, nil
+	}
+	realPart := make([]float64, len(residuals))
+	imagPart := make([]float64, len(residuals))
+	for i, r := range residuals {
+		realPart[i] = c.sanitizeFloat(r[0])
+		imagPart[i] = c.sanitizeFloat(r[1])
+	}
+	return realPart, imagPart
+}
+
+// sanitizeStats cleans fit statistics for JSON compatibility, nil when no statistics were computed
+func (c *Client) sanitizeStats(stats goimpcore.FitStats) *goimpcore.FitStats {
+	if stats.N == 0 {
+		return nil
+	}
+	stats.WeightedSSR = c.sanitizeFloat(stats.WeightedSSR)
+	stats.ReducedChiSq = c.sanitizeFloat(stats.ReducedChiSq)
+	stats.RSquared = c.sanitizeFloat(stats.RSquared)
+	stats.AIC = c.sanitizeFloat(stats.AIC)
+	stats.BIC = c.sanitizeFloat(stats.BIC)
+	stats.RMSE = c.sanitizeFloat(stats.RMSE)
+	stats.NRMSE = c.sanitizeFloat(stats.NRMSE)
+	return &stats
+}
+
+// sanitizeQuality cleans the fit quality for JSON compatibility, nil when it was not rated
+func (c *Client) sanitizeQuality(quality goimpcore.FitQuality) *goimpcore.FitQuality {
+	if quality.Rating == "" {
+		return nil
+	}
+	quality.ChiSq = c.sanitizeFloat(quality.ChiSq)
+	quality.ReducedChiSq = c.sanitizeFloat(quality.ReducedChiSq)
+	quality.RMSE = c.sanitizeFloat(quality.RMSE)
+	quality.NRMSE = c.sanitizeFloat(quality.NRMSE)
+	quality.R2 = c.sanitizeFloat(quality.R2)
+	return &quality
+}
+
+// sanitizeSlice cleans every value of a slice in place for JSON compatibility
+// namedParameters keys params by the labels of code, nil when they do not match
+func (c *Client) namedParameters(code string, params []float64) map[string]float64 {
+	labels := goimpcore.ParamLabels(code)
+	if len(labels) == 0 || len(labels) != len(params) {
+		return nil
+	}
+	named := make(map[string]float64, len(labels))
+	for i, label := range labels {
+		named[label] = c.sanitizeFloat(params[i])
+	}
+	return named
+}
+
+// sanitizeContributions replaces invalid values of the element contributions
+func (c *Client) sanitizeContributions(contributions []goimpcore.ElementContrib) []goimpcore.ElementContrib {
+	for _, ec := range contributions {
+		for i, z := range ec.Impedances {
+			ec.Impedances[i] = [2]float64{c.sanitizeFloat(z[0]), c.sanitizeFloat(z[1])}
+		}
+		c.sanitizeSlice(ec.MagnitudeFraction)
+	}
+	return contributions
+}
+
+// sanitizeRanking replaces invalid statistics of the circuit candidates
+func (c *Client) sanitizeRanking(ranking []goimpcore.CircuitCandidate) []goimpcore.CircuitCandidate {
+	for i := range ranking {
+		ranking[i].ChiSquare = c.sanitizeFloat(ranking[i].ChiSquare)
+		ranking[i].AIC = c.sanitizeFloat(ranking[i].AIC)
+		ranking[i].BIC = c.sanitizeFloat(ranking[i].BIC)
+	}
+	return ranking
+}
+
+// sanitizeSensitivity replaces invalid derivatives of the parameter sensitivities
+func (c *Client) sanitizeSensitivity(sensitivity []goimpcore.Sensitivity) []goimpcore.Sensitivity {
+	for i := range sensitivity {
+		sensitivity[i].DChiSqDParam = c.sanitizeFloat(sensitivity[i].DChiSqDParam)
+		sensitivity[i].RelativeSensitivity = c.sanitizeFloat(sensitivity[i].RelativeSensitivity)
+	}
+	return sensitivity
+}
+
+func (c *Client) sanitizeSlice(values []float64) {
+	for i, v := range values {
+		values[i] = c.sanitizeFloat(v)
+	}
 }
 
 // sanitizeFloat cleans float64 values for JSON compatibility
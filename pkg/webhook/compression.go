@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"sync/atomic"
+)
+
+// minCompressSize is the smallest body worth compressing, smaller ones are
+// sent as they are
+const minCompressSize = 1024
+
+// ClientOption configures a Client created by NewClient
+type ClientOption func(*Client)
+
+// WithCompression sends webhook bodies of at least 1 KiB gzip compressed with
+// Content-Encoding: gzip. The first compressed delivery probes the receiver:
+// when it answers 415 Unsupported Media Type the body is resent uncompressed
+// and compression stays off for the client.
+func WithCompression(enabled bool) ClientOption {
+	return func(c *Client) {
+		c.compress = enabled
+	}
+}
+
+// compression tracks whether the receiver accepts compressed webhooks
+type compression struct {
+	unsupported atomic.Bool // the receiver answered 415 to a compressed body
+}
+
+// shouldCompress reports whether a body of n bytes is sent compressed
+func (c *Client) shouldCompress(n int) bool {
+	return c.compress && n >= minCompressSize && !c.compression.unsupported.Load()
+}
+
+// gzipBody returns body gzip compressed
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// rejectsCompression reports whether status tells that the receiver does not
+// accept compressed bodies
+func rejectsCompression(status int) bool {
+	return status == http.StatusUnsupportedMediaType
+}
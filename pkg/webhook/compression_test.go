@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/middleware"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// spectrumPayload is the JSON of an R(QR) spectrum of 200 points, over 10 KB
+func spectrumPayload(t *testing.T) []byte {
+	t.Helper()
+	freqs, imp, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 0.01, FreqMax: 1e6, PointsPerDecade: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string][]float64{"frequencies": freqs}
+	for _, z := range imp {
+		payload["real_impedance"] = append(payload["real_impedance"], z[0])
+		payload["imaginary_impedance"] = append(payload["imaginary_impedance"], z[1])
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) < 10*1024 {
+		t.Fatalf("payload of %d bytes, want 10 KB", len(body))
+	}
+	return body
+}
+
+// A 10 KB payload compressed by the client comes out of the server's gzip
+// middleware unchanged, at well under half the size on the wire
+func TestGzipRoundTrip(t *testing.T) {
+	body := spectrumPayload(t)
+	compressed, err := gzipBody(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) > len(body)/2 {
+		t.Errorf("compressed %d of %d bytes", len(compressed), len(body))
+	}
+
+	var received []byte
+	handler := middleware.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Content-Encoding %q left for the handler", r.Header.Get("Content-Encoding"))
+		}
+		received, _ = io.ReadAll(r.Body)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/eis-data", bytes.NewReader(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !bytes.Equal(received, body) {
+		t.Errorf("status %d, received %d bytes differing from the %d sent", rec.Code, len(received), len(body))
+	}
+}
+
+// receiver records the Content-Encoding and decoded body of every webhook,
+// answering compressed ones with 415 unless it accepts them
+type receiver struct {
+	mu        sync.Mutex
+	encodings []string
+	bodies    [][]byte
+}
+
+func (rc *receiver) handler(acceptGzip bool) http.Handler {
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		rc.bodies = append(rc.bodies, body)
+		rc.mu.Unlock()
+	})
+	gzipped := middleware.GzipMiddleware(record)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get("Content-Encoding")
+		rc.mu.Lock()
+		rc.encodings = append(rc.encodings, encoding)
+		rc.mu.Unlock()
+		if encoding != "" && !acceptGzip {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		gzipped.ServeHTTP(w, r)
+	})
+}
+
+// webhookItem is the webhook of an R(QR) fit of a 200-point spectrum
+func webhookItem(t *testing.T) models.WebhookItem {
+	t.Helper()
+	freqs, imp, err := goimpcore.Simulate("R(QR)", []float64{10, 1e-5, 0.9, 100}, goimpcore.SimOptions{FreqMin: 0.01, FreqMax: 1e6, PointsPerDecade: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := models.WebhookItem{RequestID: "r1", Freqs: freqs, Params: []float64{10, 1e-5, 0.9, 100}, CircuitCode: "R(QR)", ChiSquare: 1e-6}
+	for _, z := range imp {
+		item.RealImp = append(item.RealImp, z[0])
+		item.ImagImp = append(item.ImagImp, z[1])
+	}
+	return item
+}
+
+// A compressing client sends gzip to a receiver that accepts it, and falls
+// back to plain bodies for good after a 415
+func TestClientCompression(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		rc := &receiver{}
+		server := httptest.NewServer(rc.handler(accept))
+		client := NewClient(server.URL, config.DefaultConfig(), WithCompression(true))
+		for i := 0; i < 2; i++ {
+			if err := client.Send(webhookItem(t)); err != nil {
+				t.Fatalf("accept gzip %v: %v", accept, err)
+			}
+		}
+		server.Close()
+
+		want := []string{"gzip", "gzip"}
+		if !accept {
+			want = []string{"gzip", "", ""} // rejected, resent plain, then plain
+		}
+		if len(rc.encodings) != len(want) {
+			t.Fatalf("accept gzip %v: encodings %q, want %q", accept, rc.encodings, want)
+		}
+		for i := range want {
+			if rc.encodings[i] != want[i] {
+				t.Errorf("accept gzip %v: encodings %q, want %q", accept, rc.encodings, want)
+				break
+			}
+		}
+		for _, body := range rc.bodies {
+			var webhook map[string]interface{}
+			if err := json.Unmarshal(body, &webhook); err != nil || len(webhook["frequencies"].([]interface{})) != 201 {
+				t.Errorf("accept gzip %v: webhook of %d bytes does not decode: %v", accept, len(body), err)
+			}
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"log"
 	"math"
 	"math/cmplx"
+	"strings"
 
+	"github.com/kacperjurak/goimpcore"
 	"github.com/kacperjurak/goimpcore/pkg/models"
 )
 
@@ -17,6 +19,8 @@ func NewCalculator() *Calculator {
 }
 
 // CalculateElementImpedances calculates impedance for each circuit element
+//
+// Deprecated: elements are evaluated in isolation, use DecomposeImpedances
 func (c *Calculator) CalculateElementImpedances(frequencies []float64, parameters []float64, elementNames []string) []models.ElementImpedance {
 	var result []models.ElementImpedance
 
@@ -24,10 +28,17 @@ func (c *Calculator) CalculateElementImpedances(frequencies []float64, parameter
 		if i >= len(parameters) {
 			break
 		}
+		// Leading parameters are combined with the last one of their element
+		if leadingParams[elementName] {
+			continue
+		}
 
-		impedances := c.calculateImpedanceForElement(elementName, parameters[i], frequencies, parameters, elementNames, i)
+		lookback := elementLookback[elementName]
+		if i < lookback {
+			continue
+		}
+		impedances := c.calculateImpedanceForElement(elementName, frequencies, parameters, i, lookback)
 
-		// Only add element if we calculated impedances (skip qy parameter)
 		if len(impedances) > 0 {
 			displayName := c.getDisplayName(elementName)
 			result = append(result, models.ElementImpedance{
@@ -40,13 +51,75 @@ func (c *Calculator) CalculateElementImpedances(frequencies []float64, parameter
 	return result
 }
 
-// calculateImpedanceForElement calculates impedance for a specific element
-func (c *Calculator) calculateImpedanceForElement(elementName string, parameter float64, frequencies []float64, parameters []float64, elementNames []string, index int) []map[string]float64 {
+// leadingParams are the parameters of multi-parameter elements followed by
+// further parameters of the same element, see goimpcore.GetElements
+var leadingParams = map[string]bool{
+	"qy": true, "oy": true, "ty": true, "gy": true, "pr": true, "fy": true, "fk": true,
+}
+
+// elementLookback is the number of parameters preceding the last parameter
+// of a multi-parameter element
+var elementLookback = map[string]int{
+	"qn": 1, "ob": 1, "tb": 1, "gk": 1, "py": 1, "fa": 2,
+}
+
+// elementTokens maps the last parameter of an element evaluated by the core
+// to its circuit code token
+var elementTokens = map[string]string{
+	"ob": "o", // FLW, tanh(sqrt(jw)B) / (sqrt(jw)Y0)
+	"tb": "t", // FSW, coth(sqrt(jw)B) / (sqrt(jw)Y0)
+	"gk": "g", // Gerischer, (k+jw)^-0.5 / Y0
+	"py": "p", // De Levie porous electrode
+	"fa": "f", // Fractal Gerischer, (k+jw)^-a / Y0
+}
+
+// DecomposeImpedances calculates the impedance of every element and bracketed
+// group of the circuit code, following the circuit topology
+func (c *Calculator) DecomposeImpedances(code string, frequencies []float64, parameters []float64) ([]models.ElementImpedance, error) {
+	contributions, err := goimpcore.DecomposeImpedance(code, frequencies, parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.ElementImpedance, 0, len(contributions))
+	for _, contribution := range contributions {
+		impedances := make([]map[string]float64, len(frequencies))
+		for i, z := range contribution.Impedance {
+			realPart, imagPart := c.sanitizeImpedance(complex(z[0], z[1]), contribution.Name, frequencies[i])
+			impedances[i] = map[string]float64{
+				"real": realPart,
+				"imag": imagPart,
+			}
+		}
+		result = append(result, models.ElementImpedance{
+			Name:       contribution.Name,
+			Path:       contribution.Path,
+			Impedances: impedances,
+		})
+	}
+	return result, nil
+}
+
+// calculateImpedanceForElement calculates impedance for a specific element,
+// whose parameters are parameters[index-lookback : index+1]
+func (c *Calculator) calculateImpedanceForElement(elementName string, frequencies []float64, parameters []float64, index int, lookback int) []map[string]float64 {
+	params := parameters[index-lookback : index+1]
+
 	var impedances []map[string]float64
+	if token, ok := elementTokens[elementName]; ok {
+		for i, z := range goimpcore.CircuitImpedance(token, frequencies, params) {
+			realPart, imagPart := c.sanitizeImpedance(complex(z[0], z[1]), elementName, frequencies[i])
+			impedances = append(impedances, map[string]float64{
+				"real": realPart,
+				"imag": imagPart,
+			})
+		}
+		return impedances
+	}
 
 	for _, freq := range frequencies {
 		w := 2 * math.Pi * freq
-		impedance := c.calculateElementImpedance(elementName, parameter, w, parameters, elementNames, index)
+		impedance := c.calculateElementImpedance(elementName, params, w)
 
 		// Sanitize impedance values for JSON compatibility
 		realPart, imagPart := c.sanitizeImpedance(impedance, elementName, freq)
@@ -61,7 +134,9 @@ func (c *Calculator) calculateImpedanceForElement(elementName string, parameter
 }
 
 // calculateElementImpedance calculates impedance based on element type
-func (c *Calculator) calculateElementImpedance(elementName string, parameter float64, w float64, parameters []float64, elementNames []string, index int) complex128 {
+func (c *Calculator) calculateElementImpedance(elementName string, params []float64, w float64) complex128 {
+	parameter := params[len(params)-1]
+
 	switch elementName {
 	case "r": // Resistance
 		return complex(parameter, 0)
@@ -80,18 +155,12 @@ func (c *Calculator) calculateElementImpedance(elementName string, parameter flo
 			return complex(1, 0) / (complex(parameter, 0) * sqrt_jw)
 		}
 
-	case "qy": // CPE Y parameter - skip, will be combined with qn
-		return complex(0, 0)
-
-	case "qn": // CPE n parameter - calculate full CPE impedance
-		if index > 0 && elementNames[index-1] == "qy" {
-			qY := parameters[index-1] // Previous parameter is Y0
-			qN := parameter           // Current parameter is n
-			if qY != 0 {
-				// Z_CPE = 1 / (Q * (jω)^n)
-				jwPowN := cmplx.Pow(complex(0, w), complex(qN, 0))
-				return complex(1, 0) / (complex(qY, 0) * jwPowN)
-			}
+	case "qn": // CPE, the preceding parameter is Y0
+		qY, qN := params[0], parameter
+		if qY != 0 {
+			// Z_CPE = 1 / (Q * (jω)^n)
+			jwPowN := cmplx.Pow(complex(0, w), complex(qN, 0))
+			return complex(1, 0) / (complex(qY, 0) * jwPowN)
 		}
 
 	default:
@@ -144,10 +213,14 @@ func (c *Calculator) sanitizeImpedance(impedance complex128, elementName string,
 	return realPart, imagPart
 }
 
-// getDisplayName returns the display name for an element
+// getDisplayName returns the display name for an element, multi-parameter
+// elements by their circuit code letter, e.g. Q instead of qn
 func (c *Calculator) getDisplayName(elementName string) string {
 	if elementName == "qn" {
-		return "Q" // Show CPE as Q instead of qn
+		return "Q"
+	}
+	if token, ok := elementTokens[elementName]; ok {
+		return strings.ToUpper(token)
 	}
 	return elementName
 }
@@ -0,0 +1,71 @@
+package webhook
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// elementCases are single-element circuits of multi-parameter elements with
+// their parameters
+var elementCases = []struct {
+	code   string
+	params []float64
+}{
+	{"Q", []float64{1e-5, 0.85}},
+	{"G", []float64{2e-3, 50}},
+	{"O", []float64{1e-2, 0.5}},
+	{"T", []float64{1e-2, 0.5}},
+	{"F", []float64{2e-3, 50, 0.4}},
+	{"P", []float64{10, 1e-3}},
+}
+
+// checkElement compares the impedances of element name in elements with the
+// impedance of the single-element circuit code
+func checkElement(t *testing.T, elements []models.ElementImpedance, name, code string, freqs, params []float64) {
+	t.Helper()
+	want := goimpcore.CircuitImpedance(strings.ToLower(code), freqs, params)
+	for _, element := range elements {
+		if element.Name != name {
+			continue
+		}
+		for i, z := range element.Impedances {
+			got := complex(z["real"], z["imag"])
+			exp := complex(want[i][0], want[i][1])
+			if d := got - exp; math.Hypot(real(d), imag(d)) > 1e-12*math.Hypot(real(exp), imag(exp)) {
+				t.Errorf("%s at %g Hz: %v, want %v", name, freqs[i], got, exp)
+			}
+		}
+		return
+	}
+	t.Errorf("no element %s in %d elements", name, len(elements))
+}
+
+// Every multi-parameter element is evaluated from all of its parameters,
+// matching the core impedance of the element alone at 10 frequencies
+func TestCalculateElementImpedances(t *testing.T) {
+	freqs := []float64{1e-2, 1e-1, 1, 10, 100, 1e3, 1e4, 1e5, 1e6, 1e7}
+	c := NewCalculator()
+	for _, tt := range elementCases {
+		t.Run(tt.code, func(t *testing.T) {
+			// Behind a resistor, so the element's parameters do not start the list
+			code := "r" + strings.ToLower(tt.code)
+			params := append([]float64{100}, tt.params...)
+			elements := c.CalculateElementImpedances(freqs, params, goimpcore.GetElements(code))
+			if len(elements) != 2 {
+				t.Fatalf("%d elements, want R and %s", len(elements), tt.code)
+			}
+			checkElement(t, elements, "r", "r", freqs, params[:1])
+			checkElement(t, elements, tt.code, tt.code, freqs, tt.params)
+
+			decomposed, err := c.DecomposeImpedances(code, freqs, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkElement(t, decomposed, tt.code+"1", tt.code, freqs, tt.params)
+		})
+	}
+}
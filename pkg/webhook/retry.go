@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default retry policy of the webhook client
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 30 * time.Second
+	DefaultJitter         = 0.2
+)
+
+// DefaultDeadLetterCapacity is the number of undelivered webhooks kept in memory
+const DefaultDeadLetterCapacity = 1000
+
+// RetryPolicy controls the delivery attempts of a webhook. Network errors and
+// 5xx responses are retried, waiting InitialBackoff doubled after every
+// attempt up to MaxBackoff, randomized by ±Jitter of the wait. Other errors
+// fail the delivery right away.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64 // fraction of the backoff, 0 to 1
+}
+
+// DefaultRetryPolicy returns the retry policy used unless configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		Jitter:         DefaultJitter,
+	}
+}
+
+// backoff returns the wait before the attempt following attempt, counted from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+	}
+	return wait
+}
+
+// retryable reports whether a failed post may succeed when repeated: network
+// errors and 5xx responses, unless ctx is done
+func retryable(ctx context.Context, status int, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if status >= 500 {
+		return true
+	}
+	var urlErr *url.Error
+	return status == 0 && errors.As(err, &urlErr)
+}
+
+// sleepContext waits for d, or returns ctx.Err() when ctx ends first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DeadLetter is a webhook that could not be delivered
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// DeadLetterQueue keeps the most recent undelivered webhooks so that they can
+// be listed and replayed. When a file is set they are also appended to it as
+// JSON lines, which outlive a restart.
+type DeadLetterQueue struct {
+	mu       sync.Mutex
+	letters  []DeadLetter
+	capacity int
+	file     string
+}
+
+// NewDeadLetterQueue creates a queue keeping the last capacity webhooks,
+// DefaultDeadLetterCapacity when 0, and appending them to file when not empty
+func NewDeadLetterQueue(capacity int, file string) *DeadLetterQueue {
+	if capacity <= 0 {
+		capacity = DefaultDeadLetterCapacity
+	}
+	return &DeadLetterQueue{capacity: capacity, file: file}
+}
+
+// Add records an undelivered webhook, dropping the oldest one when full
+func (q *DeadLetterQueue) Add(letter DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.letters = append(q.letters, letter)
+	if len(q.letters) > q.capacity {
+		q.letters = q.letters[len(q.letters)-q.capacity:]
+	}
+	if q.file != "" {
+		if err := appendJSONLine(q.file, letter); err != nil {
+			log.Printf("Warning: Dead letter of %s not written to %s: %v", letter.ID, q.file, err)
+		}
+	}
+}
+
+// List returns the undelivered webhooks, oldest first
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter(nil), q.letters...)
+}
+
+// Len returns the number of undelivered webhooks kept
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.letters)
+}
+
+// take removes and returns the undelivered webhooks
+func (q *DeadLetterQueue) take() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	letters := q.letters
+	q.letters = nil
+	return letters
+}
+
+// appendJSONLine appends v to file as one line of JSON
+func appendJSONLine(file string, v interface{}) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of signed webhooks. SignatureHeader carries "sha256=<hex>", the
+// HMAC-SHA256 of the exact JSON body with the shared secret, and
+// TimestampHeader the unix time in seconds the request was sent at.
+const (
+	SignatureHeader = "X-Goimp-Signature"
+	TimestampHeader = "X-Goimp-Timestamp"
+)
+
+// DefaultMaxSkew is the age beyond which VerifyTimestamp rejects a webhook
+const DefaultMaxSkew = 5 * time.Minute
+
+// signaturePrefix prefixes the hex HMAC in SignatureHeader
+const signaturePrefix = "sha256="
+
+// Sign returns the SignatureHeader value of body for secret
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether header is the "sha256=<hex>" signature of
+// the exact webhook body for secret, compared in constant time. Receivers
+// rejecting replays check the TimestampHeader with VerifyTimestamp too, or
+// call VerifyRequest doing both.
+func VerifySignature(body []byte, header, secret string) bool {
+	if secret == "" || !strings.HasPrefix(header, signaturePrefix) {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(header, signaturePrefix))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// ErrInvalidSignature is returned by VerifyRequest for a body that does not
+// match its signature
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// VerifyTimestamp checks that timestamp, a TimestampHeader value, is within
+// maxSkew of now either way, DefaultMaxSkew when maxSkew is 0
+func VerifyTimestamp(timestamp string, maxSkew time.Duration) error {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", TimestampHeader, timestamp)
+	}
+	if skew := time.Since(time.Unix(seconds, 0)); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("webhook timestamp %s is %v off, at most %v is accepted", timestamp, skew.Round(time.Second), maxSkew)
+	}
+	return nil
+}
+
+// VerifyRequest checks a received webhook, its signature header against the
+// body with VerifySignature and its timestamp header with VerifyTimestamp
+func VerifyRequest(body []byte, signature, timestamp, secret string, maxSkew time.Duration) error {
+	if !VerifySignature(body, signature, secret) {
+		return ErrInvalidSignature
+	}
+	return VerifyTimestamp(timestamp, maxSkew)
+}
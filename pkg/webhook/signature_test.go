@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"request_id":"r1","chi_square":0.001}`)
+	header := Sign(body, "secret")
+
+	tests := []struct {
+		name   string
+		body   []byte
+		header string
+		secret string
+		want   bool
+	}{
+		{"valid", body, header, "secret", true},
+		{"tampered body", []byte(`{"request_id":"r1","chi_square":0.002}`), header, "secret", false},
+		{"appended byte", append(append([]byte{}, body...), ' '), header, "secret", false},
+		{"wrong secret", body, header, "other", false},
+		{"no secret", body, header, "", false},
+		{"no prefix", body, header[len(signaturePrefix):], "secret", false},
+		{"not hex", body, signaturePrefix + "zz", "secret", false},
+		{"empty header", body, "", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifySignature(tt.body, tt.header, tt.secret); got != tt.want {
+				t.Errorf("VerifySignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	unix := func(d time.Duration) string {
+		return strconv.FormatInt(time.Now().Add(d).Unix(), 10)
+	}
+	tests := []struct {
+		name      string
+		timestamp string
+		maxSkew   time.Duration
+		wantErr   bool
+	}{
+		{"now", unix(0), 0, false},
+		{"within default skew", unix(-4 * time.Minute), 0, false},
+		{"replayed late", unix(-10 * time.Minute), 0, true},
+		{"clock ahead", unix(10 * time.Minute), 0, true},
+		{"within custom skew", unix(-10 * time.Minute), 15 * time.Minute, false},
+		{"beyond custom skew", unix(-2 * time.Minute), time.Minute, true},
+		{"not a number", "yesterday", 0, true},
+		{"empty", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyTimestamp(tt.timestamp, tt.maxSkew); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyTimestamp() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`{"request_id":"r1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := VerifyRequest(body, Sign(body, "secret"), now, "secret", 0); err != nil {
+		t.Errorf("valid request: %v", err)
+	}
+	if err := VerifyRequest([]byte(`{"request_id":"r2"}`), Sign(body, "secret"), now, "secret", 0); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body: got %v, want ErrInvalidSignature", err)
+	}
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := VerifyRequest(body, Sign(body, "secret"), old, "secret", 0); err == nil {
+		t.Error("replayed request accepted")
+	}
+}
+
+// The signature of a sent webhook covers exactly the body received
+func TestClientSignsBody(t *testing.T) {
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = VerifyRequest(body, r.Header.Get(SignatureHeader), r.Header.Get(TimestampHeader), "secret", 0)
+		}
+		verified <- err
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, config.DefaultConfig())
+	client.Secret = "secret"
+	if err := client.Send(models.WebhookItem{RequestID: "r1", ChiSquare: 1e-3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-verified; err != nil {
+		t.Errorf("receiver rejected the webhook: %v", err)
+	}
+}
@@ -1,15 +1,36 @@
 package worker
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kacperjurak/goimpcore"
 	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/metrics"
 	"github.com/kacperjurak/goimpcore/pkg/models"
 )
 
+// DefaultShutdownTimeout is how long Shutdown waits for queued jobs
+const DefaultShutdownTimeout = 30 * time.Second
+
+// DefaultJobTimeout bounds the processing of a job when neither the pool nor
+// the job config sets a timeout
+const DefaultJobTimeout = 5 * time.Minute
+
+// errJobTimeout is the cause of the context of a job stopped at its timeout,
+// telling it from a deadline of the job context itself
+var errJobTimeout = errors.New("job timeout exceeded")
+
+// ErrPoolClosed is returned by SubmitJob once Shutdown has been called
+var ErrPoolClosed = errors.New("worker pool is shutting down")
+
 // Pool manages concurrent EIS processing workers
 type Pool struct {
 	jobs         chan models.WorkItem
@@ -18,17 +39,70 @@ type Pool struct {
 	workers      int
 	bufferPool   sync.Pool
 	shutdown     chan struct{}
-	wg           sync.WaitGroup
+	wg           sync.WaitGroup // webhook processor
+	sendersWg    sync.WaitGroup // webhooks being sent
+	webhookSlots chan struct{}  // bounds the webhooks sent at once
+	workersWg    sync.WaitGroup
 	processor    ProcessorFunc
+	webhook      WebhookFunc
+	store        WebhookStore
+
+	// ShutdownTimeout bounds the drain of the queued jobs in Shutdown, the
+	// jobs still running after it are cancelled
+	ShutdownTimeout time.Duration
+	// JobTimeout bounds the processing of a job whose config sets no
+	// JobTimeout
+	JobTimeout time.Duration
+
+	mu     sync.RWMutex // held for reading while submitting, for writing to close jobs
+	closed bool
+	ctx    context.Context // cancelled when the drain times out
+	cancel context.CancelFunc
+
+	alive       atomic.Int32 // workers still taking jobs
+	active      atomic.Int32 // jobs being processed
+	lastSuccess atomic.Int64 // unix nanoseconds of the last successful fit, 0 before the first
+}
+
+// Stats is a snapshot of the state of a Pool
+type Stats struct {
+	Workers        int        `json:"workers"`         // workers started
+	AliveWorkers   int        `json:"alive_workers"`   // workers still taking jobs
+	ActiveJobs     int        `json:"active_jobs"`     // jobs being processed
+	QueuedJobs     int        `json:"queued_jobs"`     // jobs waiting for a worker
+	JobsCapacity   int        `json:"jobs_capacity"`   // size of the jobs queue
+	QueuedResults  int        `json:"queued_results"`  // results of jobs submitted without a result channel, not yet read
+	QueuedWebhooks int        `json:"queued_webhooks"` // webhooks waiting to be sent
+	Closed         bool       `json:"closed"`          // Shutdown has been called
+	LastSuccess    *time.Time `json:"last_success,omitempty"`
 }
 
 // ProcessorFunc defines the signature for EIS data processing
-type ProcessorFunc func(freqs []float64, impData [][2]float64, config *config.Config) interface{}
+type ProcessorFunc func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, config *config.Config) interface{}
+
+// WebhookFunc delivers a queued webhook
+type WebhookFunc func(webhook models.WebhookItem) error
+
+// WebhookStore keeps the queued webhooks until they are delivered, so that
+// they survive a restart
+type WebhookStore interface {
+	SaveWebhook(webhook models.WebhookItem) error
+	Delivered(id string) error
+}
 
 // Options holds configuration for creating a new worker pool
 type Options struct {
 	Workers   int
 	Processor ProcessorFunc
+	Webhook   WebhookFunc  // webhooks are only logged when nil
+	Webhooks  WebhookStore // webhooks are kept in memory only when nil
+	// WebhookConcurrency bounds the webhooks sent at once, retries included,
+	// twice the workers when 0
+	WebhookConcurrency int
+	// ShutdownTimeout bounds the drain in Shutdown, DefaultShutdownTimeout when 0
+	ShutdownTimeout time.Duration
+	// JobTimeout bounds each job, DefaultJobTimeout when 0
+	JobTimeout time.Duration
 }
 
 // New creates a new worker pool with specified configuration
@@ -36,6 +110,16 @@ func New(opts Options) *Pool {
 	if opts.Workers <= 0 {
 		opts.Workers = 5
 	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if opts.WebhookConcurrency <= 0 {
+		opts.WebhookConcurrency = opts.Workers * 2
+	}
+	if opts.JobTimeout <= 0 {
+		opts.JobTimeout = DefaultJobTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// do not block queueing new jobs, and results even if the workers are already busy jobs/results * 2
 	pool := &Pool{
@@ -45,6 +129,14 @@ func New(opts Options) *Pool {
 		workers:      opts.Workers,
 		shutdown:     make(chan struct{}),
 		processor:    opts.Processor,
+		webhook:      opts.Webhook,
+		store:        opts.Webhooks,
+		webhookSlots: make(chan struct{}, opts.WebhookConcurrency),
+
+		ShutdownTimeout: opts.ShutdownTimeout,
+		JobTimeout:      opts.JobTimeout,
+		ctx:             ctx,
+		cancel:          cancel,
 		bufferPool: sync.Pool{
 			New: func() interface{} {
 				// Enhanced buffer pooling with larger initial capacity
@@ -66,7 +158,8 @@ func New(opts Options) *Pool {
 func (p *Pool) start() {
 	// Start processing workers
 	for i := 0; i < p.workers; i++ {
-		p.wg.Add(1)
+		p.workersWg.Add(1)
+		p.alive.Add(1)
 		go p.worker(i)
 	}
 
@@ -77,23 +170,108 @@ func (p *Pool) start() {
 	log.Printf("🔧 Worker pool started with %d workers", p.workers)
 }
 
-// worker processes EIS jobs from the jobs channel
+// worker processes EIS jobs from the jobs channel until it is closed and empty
 func (p *Pool) worker(id int) {
-	defer p.wg.Done()
-
-	for {
-		select {
-		case job := <-p.jobs:
-			result := p.processJob(job)
-			p.results <- result
+	defer p.workersWg.Done()
+	defer p.alive.Add(-1)
+
+	for job := range p.jobs {
+		metrics.QueueDepth.Dec()
+		if cancelled(job) {
+			// Cancelled while queued, never processed
+			p.deliver(job, CancelledResult(job))
+			continue
+		}
 
-		case <-p.shutdown:
-			return
+		metrics.ActiveWorkers.Inc()
+		p.active.Add(1)
+		result := p.safeProcessJob(job)
+		p.active.Add(-1)
+		metrics.ActiveWorkers.Dec()
+		if cancelled(job) {
+			result.Cancelled, result.Success = true, false
+		}
+		if result.Success {
+			p.lastSuccess.Store(time.Now().UnixNano())
 		}
+		p.deliver(job, result)
 	}
 }
 
-// processJob handles the actual EIS processing with buffer reuse
+// deliver sends result to the result channel of job, or the shared one
+func (p *Pool) deliver(job models.WorkItem, result models.WorkResult) {
+	if job.Results != nil {
+		job.Results <- result
+	} else {
+		p.results <- result
+	}
+}
+
+// cancelled reports whether the context of job was cancelled. The pool's own
+// cancellation at shutdown does not count, the jobs it stops keep their
+// results.
+func cancelled(job models.WorkItem) bool {
+	return job.Context != nil && errors.Is(job.Context.Err(), context.Canceled)
+}
+
+// CancelledResult is the failed result of a job cancelled before it ran
+func CancelledResult(job models.WorkItem) models.WorkResult {
+	result := failedResult(job, "cancelled before processing")
+	result.Cancelled = true
+	return result
+}
+
+// failedResult is the result of a job that produced none, message telling why
+func failedResult(job models.WorkItem, message string) models.WorkResult {
+	failed := goimpcore.Result{
+		Params:  []float64{},
+		Min:     math.Inf(1),
+		MinUnit: "ChiSq",
+		Status:  "ERROR",
+	}
+	failed.SetPayload(goimpcore.PayloadError, message)
+
+	code := ""
+	if cfg, ok := job.Config.(*config.Config); ok {
+		code = cfg.Code
+	}
+	realImp := make([]float64, len(job.ImpData))
+	imagImp := make([]float64, len(job.ImpData))
+	for i, imp := range job.ImpData {
+		realImp[i], imagImp[i] = imp[0], imp[1]
+	}
+	return models.WorkResult{
+		ID:          job.ID,
+		RequestID:   job.RequestID,
+		BatchID:     job.BatchID,
+		Iteration:   job.Iteration,
+		Result:      failed,
+		Freqs:       job.Freqs,
+		RealImp:     realImp,
+		ImagImp:     imagImp,
+		CircuitCode: code,
+		InitSource:  job.InitSource,
+		MeasuredAt:  job.MeasuredAt,
+		Context:     job.Context,
+	}
+}
+
+// safeProcessJob is processJob converting a panic into a failed result, so a
+// malformed job cannot kill the worker
+func (p *Pool) safeProcessJob(job models.WorkItem) (result models.WorkResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Job %s (batch %s, iteration %d) panicked: %v\n%s", job.RequestID, job.BatchID, job.Iteration, r, debug.Stack())
+			metrics.JobsFailed.Inc()
+			result = failedResult(job, fmt.Sprintf("processing panicked: %v", r))
+		}
+	}()
+	return p.processJob(job)
+}
+
+// processJob handles the actual EIS processing with buffer reuse. A job
+// running longer than its timeout is stopped and fails with status
+// goimpcore.TIMEOUT, keeping the best parameters found so far.
 func (p *Pool) processJob(job models.WorkItem) models.WorkResult {
 	// Get buffer from pool
 	buffers := p.bufferPool.Get().(*models.BufferSet)
@@ -105,10 +283,22 @@ func (p *Pool) processJob(job models.WorkItem) models.WorkResult {
 
 	// Process EIS data
 	startTime := time.Now()
-	log.Printf("DEBUG: About to call processor with %d frequencies, config: %+v", len(job.Freqs), job.Config.(*config.Config))
-	result := p.processor(job.Freqs, job.ImpData, job.Config.(*config.Config))
+	cfg := job.Config.(*config.Config)
+	if !cfg.Quiet {
+		log.Printf("DEBUG: request_id=%s About to call processor with %d frequencies, config: %+v", job.RequestID, len(job.Freqs), cfg)
+	}
+	ctx := job.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel, timeout := p.JobContext(ctx, cfg)
+	result := p.processor(ctx, job.Freqs, job.ImpData, job.Sigmas, cfg)
 	processingTime := time.Since(startTime)
-	log.Printf("DEBUG: Processor returned result type: %T, value: %+v", result, result)
+	timedOut := TimedOut(ctx)
+	cancel()
+	if !cfg.Quiet {
+		log.Printf("DEBUG: request_id=%s Processor returned result type: %T, value: %+v", job.RequestID, result, result)
+	}
 
 	// Extract impedance data with pre-allocated buffers
 	p.extractImpedanceData(job.ImpData, buffers)
@@ -129,6 +319,14 @@ func (p *Pool) processJob(job models.WorkItem) models.WorkResult {
 			Params: []float64{},
 		}
 	}
+	if timedOut {
+		log.Printf("⚠️ Job %s (batch %s, iteration %d) stopped at the %v job timeout, method %s",
+			job.RequestID, job.BatchID, job.Iteration, timeout, cfg.OptimMethod)
+		metrics.JobTimeouts.With(cfg.OptimMethod).Inc()
+		eisResult.Status = goimpcore.TIMEOUT
+		eisResult.SetPayload(goimpcore.PayloadError, fmt.Sprintf("fit exceeded the %v job timeout, parameters are the best found so far", timeout))
+	}
+	metrics.ObserveJob(job.Config.(*config.Config).OptimMethod, processingTime, eisResult.Status == goimpcore.OK, eisResult.Min)
 
 	return models.WorkResult{
 		ID:             job.ID,
@@ -141,8 +339,31 @@ func (p *Pool) processJob(job models.WorkItem) models.WorkResult {
 		Freqs:          job.Freqs,
 		RealImp:        realCopy,
 		ImagImp:        imagCopy,
-		CircuitCode:    job.Config.(*config.Config).Code,
+		CircuitCode:    eisResult.BestCircuit(job.Config.(*config.Config).Code),
+		InitSource:     job.InitSource,
+		MeasuredAt:     job.MeasuredAt,
+		Context:        job.Context,
+	}
+}
+
+// JobContext derives from ctx the context of a fit with config cfg, done at
+// its job timeout, which is returned, or once the drain of Shutdown timed out
+func (p *Pool) JobContext(ctx context.Context, cfg *config.Config) (context.Context, context.CancelFunc, time.Duration) {
+	timeout := p.JobTimeout
+	if cfg.JobTimeout > 0 {
+		timeout = cfg.JobTimeout
 	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, errJobTimeout)
+	stop := context.AfterFunc(p.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}, timeout
+}
+
+// TimedOut reports whether ctx, from JobContext, reached its job timeout
+func TimedOut(ctx context.Context) bool {
+	return context.Cause(ctx) == errJobTimeout
 }
 
 // extractImpedanceData extracts real and imaginary parts from impedance data
@@ -172,30 +393,71 @@ func (p *Pool) extractImpedanceData(impData [][2]float64, buffers *models.Buffer
 	}
 }
 
-// webhookProcessor handles webhook requests asynchronously
+// webhookProcessor handles webhook requests asynchronously. Sends are
+// retried by the webhook client, so at most cap(webhookSlots) run at once and
+// the queue absorbs the rest, the workers never wait for them.
 func (p *Pool) webhookProcessor() {
 	defer p.wg.Done()
+	defer p.sendersWg.Wait()
 
 	for {
 		select {
 		case webhook := <-p.webhookQueue:
-			// Process webhook asynchronously without blocking workers
-			go p.sendWebhook(webhook)
+			p.goSendWebhook(webhook)
 
 		case <-p.shutdown:
-			return
+			// Deliver what the drained jobs queued
+			for {
+				select {
+				case webhook := <-p.webhookQueue:
+					p.goSendWebhook(webhook)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
-// sendWebhook is a placeholder for webhook sending logic
+// goSendWebhook sends webhook in its own goroutine once a send slot is free
+func (p *Pool) goSendWebhook(webhook models.WebhookItem) {
+	p.webhookSlots <- struct{}{}
+	p.sendersWg.Add(1)
+	go func() {
+		defer func() {
+			<-p.webhookSlots
+			p.sendersWg.Done()
+		}()
+		p.sendWebhook(webhook)
+	}()
+}
+
+// sendWebhook delivers a webhook through the configured WebhookFunc
 func (p *Pool) sendWebhook(webhook models.WebhookItem) {
-	// This will be moved to the webhook package
-	log.Printf("Processing webhook for %s", webhook.RequestID)
+	if p.webhook == nil {
+		log.Printf("Processing webhook for %s", webhook.RequestID)
+		return
+	}
+	if err := p.webhook(webhook); err != nil {
+		log.Printf("Webhook error for %s: %v", webhook.RequestID, err)
+		return
+	}
+	if p.store != nil {
+		if err := p.store.Delivered(webhook.RequestID); err != nil {
+			log.Printf("⚠️ Webhook %s delivered but still stored, it is sent again after a restart: %v", webhook.RequestID, err)
+		}
+	}
 }
 
-// SubmitJob submits a job to the worker pool
-func (p *Pool) SubmitJob(job models.WorkItem) {
+// SubmitJob submits a job to the worker pool, it fails with ErrPoolClosed
+// once Shutdown has been called
+func (p *Pool) SubmitJob(job models.WorkItem) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
 	select {
 	case p.jobs <- job:
 		// Job submitted successfully
@@ -203,6 +465,42 @@ func (p *Pool) SubmitJob(job models.WorkItem) {
 		log.Printf("⚠️  Worker pool jobs channel full, job may be delayed")
 		p.jobs <- job // Block until space available
 	}
+	metrics.JobsSubmitted.Inc()
+	metrics.QueueDepth.Inc()
+	return nil
+}
+
+// QueueLength returns the number of jobs waiting for a worker
+func (p *Pool) QueueLength() int {
+	return len(p.jobs)
+}
+
+// QueueCapacity returns the size of the jobs channel
+func (p *Pool) QueueCapacity() int {
+	return cap(p.jobs)
+}
+
+// Stats returns the current state of the pool
+func (p *Pool) Stats() Stats {
+	p.mu.RLock()
+	closed := p.closed
+	p.mu.RUnlock()
+
+	stats := Stats{
+		Workers:        p.workers,
+		AliveWorkers:   int(p.alive.Load()),
+		ActiveJobs:     int(p.active.Load()),
+		QueuedJobs:     len(p.jobs),
+		JobsCapacity:   cap(p.jobs),
+		QueuedResults:  len(p.results),
+		QueuedWebhooks: len(p.webhookQueue),
+		Closed:         closed,
+	}
+	if ns := p.lastSuccess.Load(); ns != 0 {
+		last := time.Unix(0, ns)
+		stats.LastSuccess = &last
+	}
+	return stats
 }
 
 // GetResult retrieves a result from the worker pool (non-blocking)
@@ -215,8 +513,26 @@ func (p *Pool) GetResult() (models.WorkResult, bool) {
 	}
 }
 
-// QueueWebhook queues a webhook for async processing
+// GetResultContext waits for a result of a job submitted without a result
+// channel, it returns ctx.Err() once ctx is done
+func (p *Pool) GetResultContext(ctx context.Context) (models.WorkResult, error) {
+	select {
+	case result := <-p.results:
+		return result, nil
+	case <-ctx.Done():
+		return models.WorkResult{}, ctx.Err()
+	}
+}
+
+// QueueWebhook queues a webhook for async processing. With a WebhookStore
+// it is stored first, a webhook dropped by a full queue is then sent after a
+// restart.
 func (p *Pool) QueueWebhook(webhook models.WebhookItem) {
+	if p.store != nil {
+		if err := p.store.SaveWebhook(webhook); err != nil {
+			log.Printf("⚠️ Webhook %s not stored, it is lost on a restart: %v", webhook.RequestID, err)
+		}
+	}
 	select {
 	case p.webhookQueue <- webhook:
 		// Webhook queued successfully
@@ -225,9 +541,38 @@ func (p *Pool) QueueWebhook(webhook models.WebhookItem) {
 	}
 }
 
-// Shutdown gracefully shuts down the worker pool
+// Shutdown stops accepting jobs and blocks until the running and queued jobs
+// are done and their webhooks sent. Jobs still running after ShutdownTimeout
+// are cancelled, they finish with the best result found so far.
 func (p *Pool) Shutdown() {
-	log.Printf("🛑 Shutting down worker pool...")
+	log.Printf("🛑 Shutting down worker pool, draining %d queued jobs...", len(p.jobs))
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.workersWg.Wait()
+		close(drained)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.ShutdownTimeout)
+	defer cancel()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		log.Printf("⚠️  Worker pool drain exceeded %v, cancelling the remaining jobs (%d queued)", p.ShutdownTimeout, len(p.jobs))
+		p.cancel()
+		<-drained
+	}
+	p.cancel()
+
 	close(p.shutdown)
 	p.wg.Wait()
 	log.Printf("✅ Worker pool shutdown complete")
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacperjurak/goimpcore"
+	"github.com/kacperjurak/goimpcore/pkg/config"
+	"github.com/kacperjurak/goimpcore/pkg/models"
+)
+
+// receive waits for the result on results
+func receive(t *testing.T, results chan models.WorkResult) models.WorkResult {
+	t.Helper()
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result within 5s, the worker died")
+		return models.WorkResult{}
+	}
+}
+
+// A job whose processor panics fails, and the single worker goes on to
+// process the next job
+func TestPanickingJobKeepsWorker(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	pool := New(Options{
+		Workers: 1,
+		Processor: func(ctx context.Context, freqs []float64, impData [][2]float64, sigmas [][2]float64, cfg *config.Config) interface{} {
+			if len(freqs) == 0 {
+				panic("no frequencies")
+			}
+			return goimpcore.Result{Status: goimpcore.OK, Params: []float64{1}}
+		},
+	})
+	defer pool.Shutdown()
+
+	cfg := &config.Config{Code: "R", Quiet: true}
+	bad := make(chan models.WorkResult, 1)
+	good := make(chan models.WorkResult, 1)
+	if err := pool.SubmitJob(models.WorkItem{RequestID: "bad", Config: cfg, Results: bad}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.SubmitJob(models.WorkItem{RequestID: "good", Freqs: []float64{1}, ImpData: [][2]float64{{1, 0}}, Config: cfg, Results: good}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := receive(t, bad)
+	if failed.Success || failed.Result.Status != "ERROR" || failed.RequestID != "bad" {
+		t.Errorf("panicking job: success %v, status %s, request %q", failed.Success, failed.Result.Status, failed.RequestID)
+	}
+	message, _ := failed.Result.Payload.(map[string]interface{})[goimpcore.PayloadError].(string)
+	if !strings.Contains(message, "panicked: no frequencies") {
+		t.Errorf("panicking job error %q", message)
+	}
+
+	ok := receive(t, good)
+	if !ok.Success || ok.RequestID != "good" || len(ok.Result.Params) != 1 {
+		t.Errorf("next job: success %v, request %q, params %v", ok.Success, ok.RequestID, ok.Result.Params)
+	}
+}
@@ -0,0 +1,158 @@
+package goimpcore
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+// Robust mode defaults
+const (
+	DefaultRobustK      = 3.0
+	DefaultRobustPasses = 3
+)
+
+// madScale converts the median absolute deviation into a standard deviation
+// estimate for normally distributed residuals
+const madScale = 1.4826
+
+// RobustSettings configures outlier rejection. After the initial fit points whose
+// weighted residual lies more than K robust standard deviations (MAD based) from
+// the median are dropped and the circuit is refitted, up to Passes times.
+type RobustSettings struct {
+	Enabled bool
+	K       float64 // rejection threshold, DefaultRobustK when 0
+	Passes  int     // maximum number of refits, DefaultRobustPasses when 0
+}
+
+// robustSolve rejects outliers of res and refits until no outliers remain or
+// the pass limit is reached. Must be called with the full data set in place.
+func (s *Solver) robustSolve(res Result, minFunc float64, maxIterations int) Result {
+	k := s.Robust.K
+	if k <= 0 {
+		k = DefaultRobustK
+	}
+	passes := s.Robust.Passes
+	if passes <= 0 {
+		passes = DefaultRobustPasses
+	}
+
+	if s.excluded == nil {
+		s.excluded = make([]bool, len(s.Observed))
+	}
+
+	for pass := 0; pass < passes; pass++ {
+		if err := s.context().Err(); err != nil {
+			log.Printf("Robust solve cancelled after %d passes: %v", pass, err)
+			break
+		}
+
+		outliers, fitted := s.findOutliers(res.Params, k)
+		if len(outliers) == 0 {
+			break
+		}
+		// Real and imaginary parts count separately, keep more observations than parameters
+		if 2*(fitted-len(outliers)) <= len(res.Params) {
+			log.Printf("Robust pass %d: rejecting %d outliers would leave too few points, stopping", pass+1, len(outliers))
+			break
+		}
+
+		for _, i := range outliers {
+			s.excluded[i] = true
+		}
+		log.Printf("Robust pass %d: rejected points %v", pass+1, outliers)
+
+		s.InitValues = make([]float64, len(res.Params))
+		copy(s.InitValues, res.Params)
+
+		restore, err := s.applyWindow()
+		if err != nil {
+			break
+		}
+		refit := s.solve(minFunc, maxIterations)
+		restore()
+
+		if refit.Status != OK || len(refit.Params) == 0 {
+			log.Printf("Robust pass %d: refit failed, keeping the previous fit", pass+1)
+			for _, i := range outliers {
+				s.excluded[i] = false
+			}
+			break
+		}
+		res = refit
+	}
+
+	res.Excluded = []int{}
+	res.Weights = make([]float64, len(s.Observed))
+	for i, in := range s.fitMask() {
+		if in {
+			res.Weights[i] = 1
+		}
+		if s.excluded[i] {
+			res.Excluded = append(res.Excluded, i)
+		}
+	}
+	return res
+}
+
+// findOutliers returns the indices of fitted points whose weighted real or
+// imaginary residual has a robust z-score above k, and the number of fitted points
+func (s *Solver) findOutliers(params []float64, k float64) ([]int, int) {
+	calculated := s.impedance(s.Freqs, params)
+
+	var (
+		indices []int
+		resRe   []float64
+		resIm   []float64
+	)
+	for i, in := range s.fitMask() {
+		if !in {
+			continue
+		}
+		o, c := s.Observed[i], calculated[i]
+		dRe, dIm, wRe, wIm := pointResidual(o, c, s.Sigmas, i, s.Weighting, s.Space)
+		indices = append(indices, i)
+		resRe = append(resRe, math.Sqrt(wRe)*dRe)
+		resIm = append(resIm, math.Sqrt(wIm)*dIm)
+	}
+
+	zRe := robustZ(resRe)
+	zIm := robustZ(resIm)
+
+	var outliers []int
+	for j, i := range indices {
+		if math.Abs(zRe[j]) > k || math.Abs(zIm[j]) > k {
+			outliers = append(outliers, i)
+		}
+	}
+	return outliers, len(indices)
+}
+
+// robustZ returns (v - median) / (madScale * MAD) for every value, all zeros
+// when the MAD vanishes
+func robustZ(values []float64) []float64 {
+	z := make([]float64, len(values))
+	med := median(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+	mad := madScale * median(deviations)
+	if mad == 0 || math.IsNaN(mad) {
+		return z
+	}
+	for i, v := range values {
+		z[i] = (v - med) / mad
+	}
+	return z
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return percentile(sorted, 0.5)
+}
@@ -0,0 +1,89 @@
+package goimpcore
+
+import (
+	"math"
+	"strconv"
+)
+
+// PayloadSensitivity is the Result payload key of the parameter
+// sensitivities of a fit, a []Sensitivity
+const PayloadSensitivity = "sensitivity"
+
+// DefaultSensitivityStep is the relative parameter step of
+// SensitivityAnalysis when none is given
+const DefaultSensitivityStep = 0.01
+
+// Sensitivity is the response of the chi-square of a fit to one parameter
+type Sensitivity struct {
+	ParamIndex int    `json:"param_index"`
+	ParamName  string `json:"param_name"` // see ParamLabels
+	// DChiSqDParam is the derivative of the chi-square at the optimum,
+	// close to 0 for every parameter of a converged fit
+	DChiSqDParam float64 `json:"dchisq_dparam"`
+	// RelativeSensitivity is the mean rise of the chi-square when the
+	// parameter moves by the relative step either way, relative to the
+	// chi-square at the optimum, absolute when that is 0. Well constrained
+	// parameters rise it steeply, poorly identifiable ones barely move it.
+	RelativeSensitivity float64 `json:"relative_sensitivity"`
+}
+
+// SensitivityAnalysis differentiates the chi-square of result with respect
+// to each of its parameters by central differences with the step relStep *
+// |param|, DefaultSensitivityStep when relStep is 0. The chi-square is taken
+// over the fit window of s without regularization, as reported in
+// result.Min. It returns nil for a result without parameters.
+func SensitivityAnalysis(s *Solver, result Result, relStep float64) []Sensitivity {
+	if len(result.Params) == 0 {
+		return nil
+	}
+	if relStep <= 0 {
+		relStep = DefaultSensitivityStep
+	}
+	freqs, observed, sigmas, err := s.windowData()
+	if err != nil {
+		return nil
+	}
+	chiSq := func(params []float64) float64 {
+		return s.chiSq(observed, s.impedance(freqs, params), sigmas)
+	}
+
+	labels := ParamLabels(s.code)
+	params := append([]float64(nil), result.Params...)
+	optimum := chiSq(params)
+	sensitivities := make([]Sensitivity, len(params))
+	for i, p := range result.Params {
+		h := relStep * math.Abs(p)
+		if h == 0 {
+			h = relStep
+		}
+		params[i] = p + h
+		up := chiSq(params)
+		params[i] = p - h
+		down := chiSq(params)
+		params[i] = p
+
+		rise := (up+down)/2 - optimum
+		if optimum > 0 {
+			rise /= optimum
+		}
+		name := strconv.Itoa(i)
+		if i < len(labels) {
+			name = labels[i]
+		}
+		sensitivities[i] = Sensitivity{
+			ParamIndex:          i,
+			ParamName:           name,
+			DChiSqDParam:        (up - down) / (2 * h),
+			RelativeSensitivity: rise,
+		}
+	}
+	return sensitivities
+}
+
+// Sensitivity returns the parameter sensitivities stored in the payload of
+// r, nil when they were not computed
+func (r Result) Sensitivity() []Sensitivity {
+	payload, _ := r.Payload.(map[string]interface{})
+	sensitivity, _ := payload[PayloadSensitivity].([]Sensitivity)
+	return sensitivity
+}
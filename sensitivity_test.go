@@ -0,0 +1,60 @@
+package goimpcore
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+// resistorData is a 100 Ω resistor from 1 Hz to 100 kHz with 1% noise
+func resistorData(t *testing.T) ([]float64, [][2]float64) {
+	t.Helper()
+	freqs, err := LogFrequencies(1, 1e5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return freqs, CircuitImpedanceNoisySeeded("r", freqs, []float64{100}, 0, 0, true, 3)
+}
+
+// The fitted R of a pure resistor is well constrained: the chi-square rises
+// steeply either way and is flat at the optimum
+func TestSensitivityResistor(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	freqs, impData := resistorData(t)
+	s := NewSolver("R", freqs, impData)
+	s.InitValues = []float64{50}
+	s.Diagnostics.Disabled = true
+	res := s.Solve(0, 1)
+	if res.Status != OK {
+		t.Fatalf("fit failed: %s", res.Status)
+	}
+
+	sensitivity := SensitivityAnalysis(s, res, 0)
+	if len(sensitivity) != 1 || sensitivity[0].ParamName != ParamLabels("r")[0] {
+		t.Fatalf("sensitivities %+v, want one for R", sensitivity)
+	}
+	if sensitivity[0].RelativeSensitivity < 1 {
+		t.Errorf("R relative sensitivity %v, want above 1", sensitivity[0].RelativeSensitivity)
+	}
+	// Central differences at the optimum, against the change of a 1% step
+	if slope := math.Abs(sensitivity[0].DChiSqDParam) * 0.01 * res.Params[0]; slope > 1e-3*res.Min {
+		t.Errorf("chi-square slope %v at the optimum", sensitivity[0].DChiSqDParam)
+	}
+}
+
+// Next to the resistance, a negligible series inductance barely moves the
+// chi-square of resistor data
+func TestSensitivityNegligibleElement(t *testing.T) {
+	freqs, impData := resistorData(t)
+	s := NewSolver("RL", freqs, impData)
+	sensitivity := SensitivityAnalysis(s, Result{Params: []float64{100, 1e-12}}, 0)
+	if len(sensitivity) != 2 {
+		t.Fatalf("%d sensitivities, want 2", len(sensitivity))
+	}
+	if r, l := sensitivity[0].RelativeSensitivity, sensitivity[1].RelativeSensitivity; r < 1 || math.Abs(l) > 1e-6 {
+		t.Errorf("relative sensitivities R %v, L %v, want R above 1 and L near 0", r, l)
+	}
+}
@@ -0,0 +1,123 @@
+package goimpcore
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// NoiseDistribution selects how noise is added to simulated impedance
+type NoiseDistribution int
+
+const (
+	// UNIFORM draws each component uniformly within ±level·|component|
+	UNIFORM NoiseDistribution = iota
+	// GAUSSIAN draws each component from a normal distribution with relative
+	// standard deviation level
+	GAUSSIAN
+)
+
+func (d NoiseDistribution) String() string {
+	switch d {
+	case UNIFORM:
+		return "uniform"
+	case GAUSSIAN:
+		return "gaussian"
+	}
+	return fmt.Sprintf("NoiseDistribution(%d)", int(d))
+}
+
+// ParseNoiseDistribution converts a noise distribution name into a NoiseDistribution
+func ParseNoiseDistribution(name string) (NoiseDistribution, error) {
+	switch name {
+	case "uniform":
+		return UNIFORM, nil
+	case "gaussian", "normal":
+		return GAUSSIAN, nil
+	}
+	return UNIFORM, fmt.Errorf("unknown noise distribution '%s', expected uniform or gaussian", name)
+}
+
+// DefaultPointsPerDecade is the frequency grid density used when SimOptions leaves it unset
+const DefaultPointsPerDecade = 10
+
+// SimOptions configures Simulate
+type SimOptions struct {
+	FreqMin         float64 // lowest frequency in Hz
+	FreqMax         float64 // highest frequency in Hz
+	PointsPerDecade int     // DefaultPointsPerDecade when 0
+	Noise           NoiseDistribution
+	NoiseLevel      float64    // relative noise level, 0 for noise-free data
+	Rand            *rand.Rand // source of the noise, seeded from the clock when nil
+}
+
+// Simulate generates the spectrum of the circuit described by code on a
+// log-spaced frequency grid, optionally with noise
+func Simulate(code string, params []float64, opts SimOptions) ([]float64, [][2]float64, error) {
+	circuit, err := CompileCircuit(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(params) != circuit.NumParams() {
+		return nil, nil, fmt.Errorf("simulate: %s needs %d parameters, got %d", circuit.Code(), circuit.NumParams(), len(params))
+	}
+	if opts.NoiseLevel < 0 {
+		return nil, nil, fmt.Errorf("simulate: negative noise level %v", opts.NoiseLevel)
+	}
+
+	freqs, err := LogFrequencies(opts.FreqMin, opts.FreqMax, opts.PointsPerDecade)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imp := circuit.Impedance(freqs, params)
+	if opts.NoiseLevel > 0 {
+		rnd := opts.Rand
+		if rnd == nil {
+			rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		for i := range imp {
+			addNoise(&imp[i], opts.NoiseLevel, opts.Noise, rnd)
+		}
+	}
+	return freqs, imp, nil
+}
+
+// LogFrequencies returns an ascending log-spaced frequency grid from fmin to
+// fmax inclusive with pointsPerDecade points per decade
+func LogFrequencies(fmin, fmax float64, pointsPerDecade int) ([]float64, error) {
+	if pointsPerDecade == 0 {
+		pointsPerDecade = DefaultPointsPerDecade
+	}
+	if fmin <= 0 || fmax <= fmin || pointsPerDecade < 0 {
+		return nil, fmt.Errorf("simulate: invalid frequency range %v..%v Hz with %d points per decade", fmin, fmax, pointsPerDecade)
+	}
+
+	decades := math.Log10(fmax / fmin)
+	n := int(math.Round(decades*float64(pointsPerDecade))) + 1
+	if n < 2 {
+		n = 2
+	}
+
+	freqs := make([]float64, n)
+	for i := range freqs {
+		freqs[i] = fmin * math.Pow(10, decades*float64(i)/float64(n-1))
+	}
+	// Avoid rounding drift at the end of the grid
+	freqs[n-1] = fmax
+	return freqs, nil
+}
+
+// addNoise perturbs both components of v relative to their magnitude
+func addNoise(v *[2]float64, level float64, dist NoiseDistribution, rnd *rand.Rand) {
+	for i := range v {
+		scale := math.Abs(v[i]) * level
+		switch dist {
+		case GAUSSIAN:
+			v[i] += rnd.NormFloat64() * scale
+		default:
+			v[i] += (2*rnd.Float64() - 1) * scale
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package goimpcore
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLogFrequencies(t *testing.T) {
+	freqs, err := LogFrequencies(0.01, 1e5, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(freqs) != 71 || freqs[0] != 0.01 || freqs[len(freqs)-1] != 1e5 {
+		t.Fatalf("%d frequencies from %v to %v, want 71 from 0.01 to 1e5", len(freqs), freqs[0], freqs[len(freqs)-1])
+	}
+	for i := 1; i < len(freqs); i++ {
+		if step := math.Log10(freqs[i] / freqs[i-1]); math.Abs(step-0.1) > 1e-9 {
+			t.Fatalf("step %d of %v decades, want 0.1", i, step)
+		}
+	}
+
+	for _, bad := range [][2]float64{{0, 10}, {10, 10}, {10, 1}, {-1, 10}} {
+		if _, err := LogFrequencies(bad[0], bad[1], 10); err == nil {
+			t.Errorf("range %v accepted", bad)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	params := []float64{10, 1e-5, 0.9, 100}
+	opts := SimOptions{FreqMin: 1, FreqMax: 1e5, PointsPerDecade: 5}
+
+	freqs, exact, err := Simulate("R(QR)", params, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CircuitImpedance("r(qr)", freqs, params)
+	for i := range want {
+		if exact[i] != want[i] {
+			t.Fatalf("noise-free point %d = %v, want %v", i, exact[i], want[i])
+		}
+	}
+
+	// The same seed gives the same spectrum, another seed another one
+	noisy := func(seed int64, dist NoiseDistribution) [][2]float64 {
+		o := opts
+		o.Noise, o.NoiseLevel, o.Rand = dist, 0.01, rand.New(rand.NewSource(seed))
+		_, imp, err := Simulate("R(QR)", params, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return imp
+	}
+	for _, dist := range []NoiseDistribution{UNIFORM, GAUSSIAN} {
+		a, b, c := noisy(1, dist), noisy(1, dist), noisy(2, dist)
+		same, other := true, false
+		for i := range a {
+			same = same && a[i] == b[i]
+			other = other || a[i] != c[i]
+		}
+		if !same || !other {
+			t.Errorf("%v noise: same seed equal %v, other seed different %v", dist, same, other)
+		}
+	}
+
+	errorCases := []struct {
+		name   string
+		code   string
+		params []float64
+		opts   SimOptions
+	}{
+		{"invalid circuit", "R(QR", params, opts},
+		{"missing parameter", "R(QR)", params[:3], opts},
+		{"negative noise", "R(QR)", params, SimOptions{FreqMin: 1, FreqMax: 1e5, NoiseLevel: -0.1}},
+		{"empty range", "R(QR)", params, SimOptions{FreqMin: 1e5, FreqMax: 1}},
+	}
+	for _, tt := range errorCases {
+		if _, _, err := Simulate(tt.code, tt.params, tt.opts); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+// The 1 % noise of CircuitImpedanceNoisyRand is bounded for the uniform
+// distribution and has the level as standard deviation for the Gaussian one
+func TestCircuitImpedanceNoiseStatistics(t *testing.T) {
+	const level, n = 0.01, 20000
+	freqs := make([]float64, n)
+	for i := range freqs {
+		freqs[i] = 1
+	}
+	tests := []struct {
+		dist    NoiseDistribution
+		wantStd float64
+	}{
+		{UNIFORM, level / math.Sqrt(3)},
+		{GAUSSIAN, level},
+	}
+	for _, tt := range tests {
+		// A resistor of 100 Ohm has no imaginary part to perturb
+		imp := CircuitImpedanceNoisyRand("r", freqs, []float64{100}, 0, 0, true, tt.dist, rand.New(rand.NewSource(1)))
+		var sum, sumSq, maxDev float64
+		for _, z := range imp {
+			dev := z[0]/100 - 1
+			sum += dev
+			sumSq += dev * dev
+			maxDev = math.Max(maxDev, math.Abs(dev))
+			if z[1] != 0 {
+				t.Fatalf("%v noise: imaginary part %v", tt.dist, z[1])
+			}
+		}
+		mean := sum / n
+		std := math.Sqrt(sumSq/n - mean*mean)
+		if math.Abs(mean) > 4*tt.wantStd/math.Sqrt(n) || math.Abs(std/tt.wantStd-1) > 0.03 {
+			t.Errorf("%v noise: mean %v, standard deviation %v, want 0 and %v", tt.dist, mean, std, tt.wantStd)
+		}
+		if tt.dist == UNIFORM && maxDev > level {
+			t.Errorf("uniform noise deviates by %v, above the level %v", maxDev, level)
+		}
+	}
+}
+
+// The same seed gives the same noisy spectrum
+func TestCircuitImpedanceNoisySeeded(t *testing.T) {
+	freqs, _ := LogFrequencies(1, 1e5, 5)
+	params := []float64{10, 1e-5, 0.9, 100}
+	a := CircuitImpedanceNoisySeeded("r(qr)", freqs, params, 3, 0.1, true, 7)
+	b := CircuitImpedanceNoisySeeded("r(qr)", freqs, params, 3, 0.1, true, 7)
+	c := CircuitImpedanceNoisySeeded("r(qr)", freqs, params, 3, 0.1, true, 8)
+	differs := false
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("point %d differs with the same seed: %v and %v", i, a[i], b[i])
+		}
+		differs = differs || a[i] != c[i]
+	}
+	if !differs {
+		t.Error("another seed gives the same spectrum")
+	}
+}
+
+// The noisy points of a seeded spectrum are the same on every run and off
+// by at most the noise level, the others are untouched
+func TestCircuitImpedanceNoisyPoints(t *testing.T) {
+	const points, level = 3, 0.2
+	freqs, _ := LogFrequencies(1, 1e5, 5)
+	params := []float64{10, 1e-5, 0.9, 100}
+	clean := CircuitImpedance("r(qr)", freqs, params)
+	a := CircuitImpedanceNoisySeeded("r(qr)", freqs, params, points, level, false, 42)
+	b := CircuitImpedanceNoisyRand("r(qr)", freqs, params, points, level, false, UNIFORM, rand.New(rand.NewSource(42)))
+
+	changed := 0
+	for i := range clean {
+		if a[i] != b[i] {
+			t.Fatalf("point %d: %v seeded, %v from the same source", i, a[i], b[i])
+		}
+		if a[i] == clean[i] {
+			continue
+		}
+		changed++
+		for j := range a[i] {
+			// A point drawn twice carries the noise twice
+			if math.Abs(a[i][j]/clean[i][j]-1) > level*(2+level) {
+				t.Errorf("point %d off by %v, above the level %v", i, a[i][j]/clean[i][j]-1, level)
+			}
+		}
+	}
+	if changed == 0 || changed > points {
+		t.Errorf("%d noisy points, want 1 to %d", changed, points)
+	}
+}
@@ -1,15 +1,24 @@
 package goimpcore
 
 import (
+	"context"
 	"fmt"
+	"github.com/kacperjurak/goimpcore/pkg/circuits"
+	"github.com/kacperjurak/goimpcore/pkg/quasirandom"
+	"github.com/kacperjurak/goimpcore/pkg/telemetry"
 	"github.com/maorshutman/lm"
 	"gonum.org/v1/gonum/diff/fd"
 	"gonum.org/v1/gonum/mat"
 	"gonum.org/v1/gonum/optimize"
 	"log"
 	"math"
+	"math/rand"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Weighting int
@@ -17,9 +26,68 @@ type Weighting int
 const (
 	MODULUS Weighting = iota
 	UNITY
+	SIGMA        // 1/σ² per point from Solver.Sigmas, modulus for points without sigma
+	PROPORTIONAL // real residuals by 1/Z'², imaginary by 1/Z''², unity for a zero component
 )
 
+// weightingNames maps the Weighting values to their configuration names
+var weightingNames = map[Weighting]string{
+	MODULUS:      "modulus",
+	UNITY:        "unity",
+	SIGMA:        "sigma",
+	PROPORTIONAL: "proportional",
+}
+
+func (w Weighting) String() string {
+	if name, ok := weightingNames[w]; ok {
+		return name
+	}
+	return fmt.Sprintf("Weighting(%d)", int(w))
+}
+
+// ParseWeighting returns the Weighting for a configuration name, an empty name is MODULUS
+func ParseWeighting(name string) (Weighting, error) {
+	if name == "" {
+		return MODULUS, nil
+	}
+	for w, n := range weightingNames {
+		if strings.EqualFold(n, name) {
+			return w, nil
+		}
+	}
+	return MODULUS, fmt.Errorf("unknown weighting %q", name)
+}
+
+// hybridRelaxFactor loosens minFunc for the Nelder-Mead phase of hybrid mode
+const hybridRelaxFactor = 10
+
+// suggestedRegularization is the SuggestRegularization strength at one
+// parameter per 5 points
+const suggestedRegularization = 0.01
+
+// DefaultStarts is the number of starting points used by parallel mode
+const DefaultStarts = 8
+
+// ParallelThreshold is the number of frequencies from which the model is
+// evaluated concurrently when Concurrency is above 1
+const ParallelThreshold = 500
+
+// startSpread is the half-width in decades of the log-uniform perturbation
+// applied to the initial values of each parallel start
+const startSpread = 2.0
+
+// ctxCheckInterval is how many objective evaluations pass between context checks
+const ctxCheckInterval = 100
+
+// logScaleFloor replaces non-positive initial values of log-scaled parameters
+const logScaleFloor = 1e-12
+
 // Result replacement for removed goimp.Result
+//
+// Min is the weighted chi-square of Params against the original, unscaled
+// data over the fitted frequency window, whatever the solve mode. Modes that
+// normalize the data internally and penalty terms of the objective do not
+// show in it, so results of different modes can be compared directly.
 type Result struct {
 	Min      float64
 	Params   []float64
@@ -31,52 +99,368 @@ type Result struct {
 	MinUnit  string
 	Payload  interface{}
 	Runtime  float64
+	Stats    FitStats
+	Quality  FitQuality // see GoodnessFit
+	// Residuals are observed - calculated per frequency, in original units
+	Residuals [][2]float64
+	// Excluded lists the indices rejected as outliers by the robust mode and
+	// Weights the final weight of every point, 0 for rejected points
+	Excluded []int
+	Weights  []float64
+	// Identifiability holds the parameter diagnostics of a successful fit and
+	// Warnings the problems they revealed
+	Identifiability *Identifiability
+	Warnings        []string
+	// InductiveLoop is set when the spectrum has one, see DetectInductiveLoop
+	InductiveLoop *InductiveLoopInfo
+}
+
+// PayloadError is the Payload key of the message of a fit that failed with an
+// error rather than by not converging, a string
+const PayloadError = "error"
+
+// SetPayload stores value under key in the Payload map, creating the map if needed
+func (r *Result) SetPayload(key string, value interface{}) {
+	payload, ok := r.Payload.(map[string]interface{})
+	if !ok {
+		payload = make(map[string]interface{})
+		r.Payload = payload
+	}
+	payload[key] = value
 }
 
 // Status constants replacement for removed goimp status constants
 const (
 	OK = "OK"
+	// TIMEOUT is the Status of a fit stopped at its deadline by its caller,
+	// Params being the best found so far, if any
+	TIMEOUT = "TIMEOUT"
 )
 
 type Solver struct {
-	code       string
-	Freqs      []float64
-	Observed   [][2]float64
-	InitValues []float64
-	SmartMode  string
-	Weighting  Weighting
+	code        string
+	circuit     *CompiledCircuit // nil when code does not compile
+	Freqs       []float64
+	Observed    [][2]float64
+	InitValues  []float64
+	SmartMode   string
+	Weighting   Weighting
+	Space       Space        // representation the residuals are minimized in, results stay impedances
+	Sigmas      [][2]float64 // standard deviations of the real and imaginary part per point
+	LogScale    []bool       // optimize parameter i as ln(p) when LogScale[i] is set
+	FreqMin     float64      // lower bound of the fitted frequency window, 0 for none
+	FreqMax     float64      // upper bound of the fitted frequency window, 0 for none
+	Starts      int          // number of starting points in parallel mode
+	Concurrency int          // goroutines evaluating the model on large spectra, serial when <= 1
+	Seed        int64        // seed of the multi-start and bootstrap random sources, the clock when 0
+	Robust      RobustSettings
+	// Regularization adds Regularization * sum((p_i/InitValues_i - 1)^2) to the
+	// objective, pulling parameters towards their starting values. Increase it
+	// when fits of ill-conditioned problems wander to unreasonable values.
+	// Not applied by Levenberg-Marquardt.
+	Regularization float64
+	Diagnostics    IdentifiabilitySettings
+	excluded       []bool // points rejected as outliers by the robust mode
+	elements       []string
+	scratch        *sync.Pool // reusable impedance buffers for objective evaluations
+	ctx            context.Context
+	progress       func(Result) // receives the best result after every EIS mode iteration, see SolveIterative
+	omegas         []float64    // angular frequencies of omegaFreqs
+	omegaFreqs     []float64    // the Freqs slice omegas were computed from
+}
+
+// seed returns Seed, or the clock when it is 0
+func (s *Solver) seed() int64 {
+	if s.Seed != 0 {
+		return s.Seed
+	}
+	return time.Now().UnixNano()
 }
 
 func NewSolver(code string, freqs []float64, observed [][2]float64) *Solver {
-	return &Solver{strings.ToLower(code), freqs, observed, make([]float64, 0), "", MODULUS}
+	circuit, err := CompileCircuit(code)
+	if err != nil {
+		log.Printf("Solver: %v", err)
+	}
+	// Weighting MODULUS and Space IMPEDANCE are the zero values
+	return &Solver{
+		code:       strings.ToLower(code),
+		circuit:    circuit,
+		Freqs:      freqs,
+		Observed:   observed,
+		InitValues: make([]float64, 0),
+		Starts:     DefaultStarts,
+		elements:   GetElements(strings.ToLower(code)),
+		scratch:    newScratchPool(),
+		omegas:     PrecomputeOmegas(freqs),
+		omegaFreqs: freqs,
+	}
+}
+
+// NewSolverFromLibrary creates a solver for the named circuit of the default
+// circuit library, initialized with the circuit's default parameters and its
+// first L element with the inductance of the inductive loop of observed
+func NewSolverFromLibrary(name string, freqs []float64, observed [][2]float64) (*Solver, error) {
+	def, ok := circuits.Default().Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown circuit '%s'", name)
+	}
+	circuit, err := CompileCircuit(def.Code)
+	if err != nil {
+		return nil, fmt.Errorf("circuit %s: %w", def.Name, err)
+	}
+	if len(def.DefaultParams) != circuit.NumParams() {
+		return nil, fmt.Errorf("circuit %s: %d default parameters, %s needs %d", def.Name, len(def.DefaultParams), def.Code, circuit.NumParams())
+	}
+
+	s := NewSolver(def.Code, freqs, observed)
+	s.InitValues = InitInductance(def.Code, append([]float64(nil), def.DefaultParams...), freqs, observed)
+	return s, nil
+}
+
+// context returns the context of the running solve, never nil
+func (s *Solver) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// omegasOf returns the precomputed angular frequencies of freqs, nil unless
+// freqs is the slice they were computed from
+func (s *Solver) omegasOf(freqs []float64) []float64 {
+	if len(freqs) == 0 || len(freqs) != len(s.omegaFreqs) || &freqs[0] != &s.omegaFreqs[0] {
+		return nil
+	}
+	return s.omegas
+}
+
+// setFreqs replaces Freqs and the angular frequencies precomputed from them
+func (s *Solver) setFreqs(freqs []float64) {
+	s.Freqs, s.omegas, s.omegaFreqs = freqs, PrecomputeOmegas(freqs), freqs
+}
+
+// impedance evaluates the solver's circuit with params at freqs
+func (s *Solver) impedance(freqs []float64, params []float64) [][2]float64 {
+	if s.circuit == nil {
+		return CircuitImpedance(s.code, freqs, params)
+	}
+	res := make([][2]float64, len(freqs))
+	s.impedanceInto(res, freqs, params)
+	return res
+}
+
+// impedanceInto evaluates the solver's circuit with params at freqs into res,
+// at the precomputed angular frequencies when freqs has them
+func (s *Solver) impedanceInto(res [][2]float64, freqs []float64, params []float64) {
+	if len(params) < s.circuit.NumParams() {
+		panic(fmt.Sprintf("circuit: %s needs %d values, got %d", s.circuit.Code(), s.circuit.NumParams(), len(params)))
+	}
+	parallel := s.Concurrency > 1 && len(freqs) >= ParallelThreshold
+	if omegas := s.omegasOf(freqs); omegas != nil {
+		if parallel {
+			s.circuit.impedanceOmegasParallelInto(res, omegas, params, s.Concurrency)
+		} else {
+			s.circuit.impedanceOmegasInto(res, omegas, params)
+		}
+		return
+	}
+	if parallel {
+		s.circuit.impedanceParallelInto(res, freqs, params, s.Concurrency)
+	} else {
+		s.circuit.impedanceInto(res, freqs, params)
+	}
+}
+
+func newScratchPool() *sync.Pool {
+	return &sync.Pool{New: func() interface{} { return new([][2]float64) }}
+}
+
+// evaluate calculates the impedance at s.Freqs into a pooled buffer, the
+// buffer must be handed back with release once the caller is done with it
+func (s *Solver) evaluate(params []float64) *[][2]float64 {
+	if s.scratch == nil || s.circuit == nil {
+		calculated := s.impedance(s.Freqs, params)
+		return &calculated
+	}
+
+	buf := s.scratch.Get().(*[][2]float64)
+	if cap(*buf) < len(s.Freqs) {
+		*buf = make([][2]float64, len(s.Freqs))
+	}
+	*buf = (*buf)[:len(s.Freqs)]
+
+	s.impedanceInto(*buf, s.Freqs, params)
+	return buf
+}
+
+// release returns a buffer obtained from evaluate to the pool
+func (s *Solver) release(buf *[][2]float64) {
+	if s.scratch != nil {
+		s.scratch.Put(buf)
+	}
 }
 
 func (s *Solver) problem(x []float64) float64 {
-	calculated := CircuitImpedance(s.code, s.Freqs, x)
-	return ChiSq(s.Observed, calculated, s.Weighting)
+	span := s.traceEval()
+	defer span.End()
+
+	x = s.fromLogSpace(x)
+	calculated := s.evaluate(x)
+	defer s.release(calculated)
+	return s.chiSq(s.Observed, *calculated, s.Sigmas) + s.regularizationPenalty(x)
+}
+
+// traceEval starts a chi_square_eval span for a sample of the objective
+// evaluations, it returns nil for all others
+func (s *Solver) traceEval() *telemetry.Span {
+	_, span := telemetry.StartSampled(s.context(), telemetry.SpanChiSquareEval, telemetry.ChiSquareSampleRate)
+	return span
+}
+
+// regularizationPenalty returns the Tikhonov term of params relative to the
+// current initial values, 0 when regularization is off
+func (s *Solver) regularizationPenalty(params []float64) float64 {
+	if s.Regularization == 0 {
+		return 0
+	}
+	penalty := 0.0
+	for i, p := range params {
+		if i >= len(s.InitValues) || s.InitValues[i] == 0 {
+			continue
+		}
+		d := p/s.InitValues[i] - 1
+		penalty += d * d
+	}
+	return s.Regularization * penalty
+}
+
+// SuggestRegularization returns a regularization strength for a fit of nParams
+// parameters to nPoints complex data points: 0 for more than 10 points per
+// parameter, growing as the data gets sparser
+func SuggestRegularization(nPoints, nParams int) float64 {
+	if nParams <= 0 || nPoints > 10*nParams {
+		return 0
+	}
+	ratio := float64(nPoints) / float64(nParams)
+	if ratio < 1 {
+		ratio = 1
+	}
+	return suggestedRegularization * (10/ratio - 1)
 }
 
 func (s *Solver) problemWithQnConstraints(x []float64) float64 {
-	calculated := CircuitImpedance(s.code, s.Freqs, x)
-	chiSq := ChiSq(s.Observed, calculated, s.Weighting)
+	span := s.traceEval()
+	defer span.End()
+
+	x = s.fromLogSpace(x)
+	calculated := s.evaluate(x)
+	chiSq := s.chiSq(s.Observed, *calculated, s.Sigmas)
+	s.release(calculated)
 
 	// Add penalty for Qn parameters outside [0.1, 1.0]
 	penalty := 0.0
-	elements := GetElements(s.code)
-	for i, elem := range elements {
+	for i, elem := range s.elements {
 		if elem == "qn" && i < len(x) {
 			if x[i] < 0.1 {
-				penalty += 1e6 * math.Pow(0.1-x[i], 2)
+				d := 0.1 - x[i]
+				penalty += 1e6 * d * d
 			} else if x[i] > 1.0 {
-				penalty += 1e6 * math.Pow(x[i]-1.0, 2)
+				d := x[i] - 1.0
+				penalty += 1e6 * d * d
 			}
 		}
 	}
 
-	return chiSq + penalty
+	return chiSq + penalty + s.regularizationPenalty(x)
 }
 
 func (s *Solver) Solve(minFunc float64, maxIterations int) Result {
+	res, _ := s.SolveWithContext(context.Background(), minFunc, maxIterations)
+	return res
+}
+
+// SolveWithContext is Solve with cancellation support. When ctx is done the
+// best result found so far is returned together with ctx.Err().
+// Only points within FreqMin..FreqMax that are not rejected as outliers are
+// fitted, residuals cover all points.
+func (s *Solver) SolveWithContext(ctx context.Context, minFunc float64, maxIterations int) (Result, error) {
+	ctx, span := telemetry.Start(ctx, telemetry.SpanSolve,
+		telemetry.String("method", s.SmartMode),
+		telemetry.String("circuit", s.code),
+		telemetry.Int("n_params", len(s.elements)),
+		telemetry.Int("n_points", len(s.Observed)),
+	)
+	defer span.End()
+
+	res, err := s.solveWithContext(ctx, minFunc, maxIterations)
+	span.SetAttributes(telemetry.String("status", res.Status), telemetry.Float64("chi_square", res.Min))
+	span.RecordError(err)
+	return res, err
+}
+
+func (s *Solver) solveWithContext(ctx context.Context, minFunc float64, maxIterations int) (Result, error) {
+	s.ctx = ctx
+	defer func() { s.ctx = nil }()
+
+	s.excluded = nil
+	restore, err := s.applyWindow()
+	if err != nil {
+		log.Printf("Solve: %v (window %v..%v Hz)", err, s.FreqMin, s.FreqMax)
+		return Result{
+			Params:  []float64{},
+			Min:     math.Inf(1),
+			MinUnit: "ChiSq",
+			Status:  "ERROR",
+		}, err
+	}
+
+	if s.Weighting == SIGMA {
+		s.checkSigmas()
+	}
+
+	res := s.solve(minFunc, maxIterations)
+	restore()
+
+	if s.Robust.Enabled && len(res.Params) > 0 && res.Status == OK {
+		res = s.robustSolve(res, minFunc, maxIterations)
+	}
+
+	if len(res.Params) > 0 && res.Status == OK {
+		// All modes have restored the original data scale at this point, so
+		// Min is reported without the penalties of the objective
+		freqs, observed, sigmas, _ := s.windowData()
+		calculated := s.impedance(freqs, res.Params)
+		res.Min = s.chiSq(observed, calculated, sigmas)
+		res.MinUnit = "ChiSq"
+		res.Stats = ComputeFitStats(observed, calculated, sigmas, len(res.Params), s.Weighting)
+		res.Quality = GoodnessFit(res, len(observed))
+
+		if !s.Diagnostics.Disabled {
+			if ident, err := s.Identifiability(res.Params); err != nil {
+				log.Printf("Identifiability diagnostics failed: %v", err)
+			} else {
+				res.Identifiability = ident
+				res.Warnings = append(res.Warnings, ident.Warnings...)
+				for _, w := range ident.Warnings {
+					log.Printf("WARNING: %s", w)
+				}
+			}
+		}
+	}
+
+	if len(res.Params) > 0 {
+		res.Residuals = Residuals(s.Observed, s.impedance(s.Freqs, res.Params))
+	}
+	if loop := DetectInductiveLoop(s.Freqs, s.Observed); loop.Detected {
+		res.InductiveLoop = &loop
+		res.Warnings = append(res.Warnings, loop.Warning(s.code))
+		log.Printf("WARNING: %s", loop.Warning(s.code))
+	}
+	return res, ctx.Err()
+}
+
+func (s *Solver) solve(minFunc float64, maxIterations int) Result {
 	if s.SmartMode == "eis" {
 		return s.eisSolve(minFunc, maxIterations)
 	} else if s.SmartMode == "gd" {
@@ -87,6 +471,10 @@ func (s *Solver) Solve(minFunc float64, maxIterations int) Result {
 		return s.baseLBFGSSolve()
 	} else if s.SmartMode == "newton" {
 		return s.baseNewtonSolve()
+	} else if s.SmartMode == "nm+lm" {
+		return s.hybridSolve(minFunc, maxIterations)
+	} else if s.SmartMode == "parallel" {
+		return s.ParallelSolve(minFunc, maxIterations, s.Starts)
 	}
 	return s.baseNMSolve()
 }
@@ -110,8 +498,26 @@ func (s *Solver) baseNMSolve() Result {
 
 	log.Printf("Using initial values: %v", s.InitValues)
 
+	// Check the context every ctxCheckInterval evaluations, the objective may be
+	// evaluated concurrently so the counter has to be atomic
+	ctx := s.context()
+	var (
+		evals     atomic.Int64
+		cancelled atomic.Bool
+	)
 	problem := optimize.Problem{
-		Func: s.problemWithQnConstraints,
+		Func: func(x []float64) float64 {
+			if evals.Add(1)%ctxCheckInterval == 0 && ctx.Err() != nil {
+				cancelled.Store(true)
+			}
+			return s.problemWithQnConstraints(x)
+		},
+		Status: func() (optimize.Status, error) {
+			if cancelled.Load() {
+				return optimize.Failure, ctx.Err()
+			}
+			return optimize.NotTerminated, nil
+		},
 	}
 
 	settings := &optimize.Settings{
@@ -127,8 +533,11 @@ func (s *Solver) baseNMSolve() Result {
 		Concurrent:        10000,
 	}
 
-	res, err := optimize.Minimize(problem, s.InitValues, settings, &optimize.NelderMead{})
-	if err != nil {
+	res, err := optimize.Minimize(problem, s.toLogSpace(s.InitValues), settings, &optimize.NelderMead{})
+	if err != nil && cancelled.Load() && res != nil && len(res.X) > 0 {
+		// Cancelled: keep the best point found so far
+		log.Printf("Nelder-Mead optimization cancelled: %v", err)
+	} else if err != nil {
 		log.Printf("Nelder-Mead optimization failed: %v", err)
 		return Result{
 			Params:  []float64{},
@@ -147,7 +556,7 @@ func (s *Solver) baseNMSolve() Result {
 
 	return Result{
 		Code:    s.code,
-		Params:  res.X,
+		Params:  s.fromLogSpace(res.X),
 		Min:     res.F,
 		MinUnit: "ChiSq",
 		Payload: payload,
@@ -156,22 +565,33 @@ func (s *Solver) baseNMSolve() Result {
 	}
 }
 
-func (s *Solver) baseLMSolve() Result {
+func (s *Solver) baseLMSolve() (result Result) {
 	log.Println("Base LM Solve Mode")
+	funcEvals := 0
+	// lm.LM cannot be stopped, so the objective checks the context every
+	// ctxCheckInterval evaluations and aborts it with a panic, the best point
+	// evaluated so far being the result
+	ctx := s.context()
+	bestX, bestSum := []float64(nil), math.Inf(1)
 	fnc := func(dst, x []float64) {
-		calculated := CircuitImpedance(s.code, s.Freqs, x)
-		if len(calculated) != len(s.Observed) {
+		funcEvals++
+		if funcEvals%ctxCheckInterval == 0 && ctx.Err() != nil {
+			panic(lmCancelled{ctx.Err()})
+		}
+		calculated := s.evaluate(s.fromLogSpace(x))
+		defer s.release(calculated)
+		if len(*calculated) != len(s.Observed) {
 			panic("solver: slice length mismatch")
 		}
+		var sum float64
 		for i, o := range s.Observed {
-			c := calculated[i]
-			d2 := math.Pow(o[0]-c[0], 2) + math.Pow(o[1]-c[1], 2)
-			if s.Weighting == UNITY {
-				dst[i] = math.Abs(d2)
-			} else if s.Weighting == MODULUS {
-				weight := math.Sqrt(math.Pow(o[0], 2) + math.Pow(o[1], 2))
-				dst[i] = math.Abs(d2) / math.Pow(weight, 2)
-			}
+			c := (*calculated)[i]
+			dRe, dIm, wRe, wIm := pointResidual(o, c, s.Sigmas, i, s.Weighting, s.Space)
+			dst[i] = wRe*dRe*dRe + wIm*dIm*dIm
+			sum += dst[i]
+		}
+		if sum < bestSum {
+			bestX, bestSum = append(bestX[:0], x...), sum
 		}
 	}
 
@@ -182,16 +602,38 @@ func (s *Solver) baseLMSolve() Result {
 		Size:       len(s.Observed),
 		Func:       fnc,
 		Jac:        jac.Jac,
-		InitParams: s.InitValues,
+		InitParams: s.toLogSpace(s.InitValues),
 		Tau:        1e-13,
 		Eps1:       1e-8,
 		Eps2:       1e-8,
 	}
 
-	// Recover from LM panics (e.g., singular matrix)
+	// Recover from LM panics (e.g., singular matrix) and report them as a failed run
 	defer func() {
 		if r := recover(); r != nil {
+			if cancelled, ok := r.(lmCancelled); ok && bestX != nil {
+				log.Printf("LM optimization cancelled after %d evaluations: %v", funcEvals, cancelled.err)
+				params := s.fromLogSpace(bestX)
+				result = Result{
+					Params:  params,
+					Min:     s.chiSq(s.Observed, s.impedance(s.Freqs, params), s.Sigmas),
+					MinUnit: "ChiSq",
+					Status:  OK,
+					Payload: map[string]interface{}{
+						"funcEvaluations": funcEvals,
+					},
+				}
+				return
+			}
 			log.Printf("LM optimization panicked: %v", r)
+			result = Result{
+				Params:  []float64{},
+				Min:     math.Inf(1),
+				MinUnit: "ChiSq",
+				Runtime: 0,
+				Status:  "ERROR",
+				Payload: nil,
+			}
 		}
 	}()
 
@@ -208,16 +650,25 @@ func (s *Solver) baseLMSolve() Result {
 		}
 	}
 
+	params := s.fromLogSpace(res.X)
 	return Result{
-		Params:  res.X,
-		Min:     ChiSq(s.Observed, CircuitImpedance(s.code, s.Freqs, res.X), s.Weighting),
+		Params:  params,
+		Min:     s.chiSq(s.Observed, s.impedance(s.Freqs, params), s.Sigmas),
 		MinUnit: "ChiSq",
 		Runtime: 0,
 		Status:  OK,
-		Payload: nil,
+		Payload: map[string]interface{}{
+			"funcEvaluations": funcEvals,
+		},
 	}
 }
 
+// lmCancelled aborts lm.LM from the objective of baseLMSolve once the context
+// of the solver is done
+type lmCancelled struct {
+	err error
+}
+
 func (s *Solver) baseGDSolve() Result {
 	log.Println("Base GD Solve Mode")
 	// https://sbinet.github.io/posts/2017-10-09-intro-to-minimization/
@@ -259,7 +710,7 @@ func (s *Solver) baseGDSolve() Result {
 		Concurrent:        10000,
 	}
 
-	res, err := optimize.Minimize(problem, s.InitValues, settings, &optimize.GradientDescent{})
+	res, err := optimize.Minimize(problem, s.toLogSpace(s.InitValues), settings, &optimize.GradientDescent{})
 	if err != nil {
 		panic(err)
 	}
@@ -270,7 +721,7 @@ func (s *Solver) baseGDSolve() Result {
 	}
 
 	return Result{
-		Params:  res.X,
+		Params:  s.fromLogSpace(res.X),
 		Min:     res.F,
 		MinUnit: "ChiSq",
 		Runtime: float64(res.Runtime / 1000),
@@ -285,11 +736,21 @@ func (s *Solver) eisSolve(minFunc float64, maxIterations int) Result {
 	// normalizes the input impedance data so that it is in the range [0, 1]
 	scaleCoef := prepareData(&s.Observed)
 
+	// sigmas have to follow the data into normalized units
+	origSigmas := s.Sigmas
+	if len(s.Sigmas) > 0 {
+		s.Sigmas = make([][2]float64, len(origSigmas))
+		for i, v := range origSigmas {
+			s.Sigmas[i] = [2]float64{v[0] / scaleCoef, v[1] / scaleCoef}
+		}
+	}
+	defer func() { s.Sigmas = origSigmas }()
+
 	if len(s.InitValues) == 0 {
 		s.InitValues = s.findInitValues(s.Freqs, s.Observed)
 	}
 
-	fmt.Println("InitValues:", s.InitValues)
+	log.Printf("InitValues: %v", s.InitValues)
 
 	var (
 		lastMin    = math.Inf(1)
@@ -303,6 +764,11 @@ func (s *Solver) eisSolve(minFunc float64, maxIterations int) Result {
 	log.Println("elements:", elements)
 
 	for iterations < maxIterations {
+		if err := s.context().Err(); err != nil {
+			log.Printf("EIS solve cancelled after %d iterations: %v", iterations, err)
+			break
+		}
+
 		res := s.baseNMSolve()
 		log.Println("init:", s.InitValues)
 		log.Println("resl:", res)
@@ -310,6 +776,7 @@ func (s *Solver) eisSolve(minFunc float64, maxIterations int) Result {
 		if res.Min < bestRes.Min {
 			bestRes = res
 		}
+		s.reportProgress(bestRes, elements, scaleCoef, origSigmas)
 
 		log.Println("iter:", iterations, "res:", res.Min, "bestRes", bestRes.Min)
 
@@ -323,8 +790,16 @@ func (s *Solver) eisSolve(minFunc float64, maxIterations int) Result {
 		iterations++
 	}
 
-	scaleParams(&bestRes.Params, elements, scaleCoef)
 	scaleData(&s.Observed, scaleCoef)
+	s.Sigmas = origSigmas
+
+	// No parameters when every attempt failed or the solve was cancelled early
+	if len(bestRes.Params) == len(elements) {
+		scaleParams(&bestRes.Params, elements, scaleCoef)
+		// Min was taken on the normalized data, report it on the original
+		// data like every other mode
+		bestRes.Min = s.chiSq(s.Observed, s.impedance(s.Freqs, bestRes.Params), s.Sigmas)
+	}
 
 	return bestRes
 }
@@ -339,15 +814,28 @@ func (s *Solver) lmSolve(minFunc float64, maxIterations int) Result {
 	var (
 		lastMin    = math.Inf(1)
 		lastValues = make([]float64, len(s.InitValues))
-		bestRes    = Result{Min: math.Inf(1)}
+		bestRes    = Result{Params: []float64{}, Min: math.Inf(1), MinUnit: "ChiSq", Status: "ERROR"}
 	)
 
 	primaryInitValues := s.InitValues
 	iterations := 0
 
 	for iterations < maxIterations {
+		if err := s.context().Err(); err != nil {
+			log.Printf("LM solve cancelled after %d iterations: %v", iterations, err)
+			break
+		}
+
 		res := s.baseLMSolve()
 
+		if res.Status != OK {
+			// A failed run has no parameters, retry from perturbed init values
+			log.Println("iter:", iterations, "LM failed, modifying init values")
+			s.InitValues = modifyParams(s.InitValues, false, primaryInitValues, lastValues, GetElements(s.code))
+			iterations++
+			continue
+		}
+
 		if res.Min < bestRes.Min {
 			bestRes = res
 		}
@@ -366,6 +854,199 @@ func (s *Solver) lmSolve(minFunc float64, maxIterations int) Result {
 	return bestRes
 }
 
+// hybridSolve uses the EIS Nelder-Mead loop to find the basin of the minimum
+// and then refines the best parameters with Levenberg-Marquardt
+func (s *Solver) hybridSolve(minFunc float64, maxIterations int) Result {
+	log.Println("Hybrid NM+LM Solve Mode")
+
+	// NM only has to get close, LM does the final convergence
+	nmStart := time.Now()
+	nmRes := s.eisSolve(minFunc*hybridRelaxFactor, maxIterations)
+	nmDuration := time.Since(nmStart)
+
+	if len(nmRes.Params) == 0 {
+		log.Printf("Hybrid: Nelder-Mead phase produced no parameters, skipping LM refinement")
+		return nmRes
+	}
+
+	if err := s.context().Err(); err != nil {
+		log.Printf("Hybrid: cancelled after Nelder-Mead phase, skipping LM refinement: %v", err)
+		return nmRes
+	}
+
+	s.InitValues = make([]float64, len(nmRes.Params))
+	copy(s.InitValues, nmRes.Params)
+
+	lmStart := time.Now()
+	lmRes := s.baseLMSolve()
+	lmDuration := time.Since(lmStart)
+
+	nmIters, nmFuncEvals := payloadCounts(nmRes.Payload)
+	_, lmFuncEvals := payloadCounts(lmRes.Payload)
+
+	bestRes := nmRes
+	if lmRes.Status == OK && lmRes.Min <= nmRes.Min {
+		bestRes = lmRes
+	} else {
+		log.Printf("Hybrid: LM refinement did not improve ChiSq (%v -> %v), keeping NM result", nmRes.Min, lmRes.Min)
+	}
+
+	bestRes.Code = s.code
+	bestRes.MinUnit = "ChiSq"
+	bestRes.Runtime = float64((nmDuration + lmDuration) / 1000)
+	bestRes.Payload = map[string]interface{}{
+		"majorIterations":   nmIters,
+		"funcEvaluations":   nmFuncEvals + lmFuncEvals,
+		"nmFuncEvaluations": nmFuncEvals,
+		"lmFuncEvaluations": lmFuncEvals,
+		"nmDuration":        nmDuration,
+		"lmDuration":        lmDuration,
+	}
+
+	log.Println("hybrid: NM ChiSq", nmRes.Min, "in", nmDuration, "LM ChiSq", lmRes.Min, "in", lmDuration)
+	return bestRes
+}
+
+// ParallelSolve runs eisSolve from nStarts starting points concurrently and
+// returns the best result. The first start uses the solver's initial values,
+// the others perturb every scale parameter by up to startSpread decades,
+// following a Sobol sequence over the perturbation exponents so that the
+// starts cover the parameter space evenly. At most runtime.NumCPU() starts
+// run at once.
+func (s *Solver) ParallelSolve(minFunc float64, maxIterations, nStarts int) Result {
+	log.Println("Parallel multi-start Solve Mode")
+
+	if nStarts <= 0 {
+		nStarts = DefaultStarts
+	}
+
+	base := s.InitValues
+	if len(base) == 0 {
+		base = s.findInitValues(s.Freqs, s.Observed)
+	}
+	elements := GetElements(s.code)
+	offsets := startOffsets(len(base), elements, nStarts)
+
+	workers := runtime.NumCPU()
+	if nStarts < workers {
+		workers = nStarts
+	}
+	sem := make(chan struct{}, workers)
+	results := make(chan Result, nStarts)
+	seed := s.seed()
+
+	var wg sync.WaitGroup
+	for i := 0; i < nStarts; i++ {
+		wg.Add(1)
+		go func(start int, rnd *rand.Rand) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			sCopy := s.Clone()
+			if workers >= runtime.NumCPU() {
+				sCopy.Concurrency = 1 // the starts already occupy every core
+			}
+			sCopy.InitValues = make([]float64, len(base))
+			copy(sCopy.InitValues, base)
+			switch {
+			case start == 0:
+			case offsets != nil:
+				for j, offset := range offsets[start] {
+					sCopy.InitValues[j] *= math.Pow(10, offset)
+				}
+			default:
+				perturbParams(sCopy.InitValues, elements, rnd)
+			}
+
+			res := sCopy.eisSolve(minFunc, maxIterations)
+			res.SetPayload("start", start)
+			results <- res
+		}(i, rand.New(rand.NewSource(seed+int64(i))))
+	}
+
+	wg.Wait()
+	close(results)
+
+	bestRes := Result{Params: []float64{}, Min: math.Inf(1), MinUnit: "ChiSq", Status: "ERROR"}
+	for res := range results {
+		if res.Status == OK && res.Min < bestRes.Min {
+			bestRes = res
+		}
+	}
+	bestRes.SetPayload("starts", nStarts)
+
+	log.Println("parallel: best ChiSq", bestRes.Min, "from", nStarts, "starts")
+	return bestRes
+}
+
+// startOffsets returns the decimal exponents by which the parameters of every
+// start are scaled, taken from a Sobol sequence over [-startSpread,
+// startSpread] for scale parameters. Exponents get 0. It returns nil, with
+// a warning, when the scale parameters exceed quasirandom.Dimensions(),
+// perturbParams is used then.
+func startOffsets(nParams int, elements []string, nStarts int) [][]float64 {
+	var scaled []int
+	for i := 0; i < nParams; i++ {
+		if i < len(elements) && (elements[i] == "qn" || elements[i] == "fa") {
+			continue
+		}
+		scaled = append(scaled, i)
+	}
+	if len(scaled) == 0 {
+		return nil
+	}
+
+	lo := make([]float64, len(scaled))
+	hi := make([]float64, len(scaled))
+	for j := range scaled {
+		lo[j], hi[j] = -startSpread, startSpread
+	}
+	samples := quasirandom.GenerateSobolSamples(len(scaled), nStarts, lo, hi)
+	if samples == nil {
+		log.Printf("parallel: no Sobol samples for %d parameters, %d dimensions loaded, starts perturbed at random",
+			len(scaled), quasirandom.Dimensions())
+		return nil
+	}
+
+	offsets := make([][]float64, nStarts)
+	for i, sample := range samples {
+		offsets[i] = make([]float64, nParams)
+		for j, p := range scaled {
+			offsets[i][p] = sample[j]
+		}
+	}
+	return offsets
+}
+
+// perturbParams multiplies every scale parameter by 10^u, u uniform in
+// [-startSpread, startSpread]. Exponents are bounded and kept as they are.
+func perturbParams(params []float64, elements []string, rnd *rand.Rand) {
+	for i := range params {
+		if i < len(elements) {
+			switch elements[i] {
+			case "qn", "fa":
+				continue
+			}
+		}
+		params[i] *= math.Pow(10, (2*rnd.Float64()-1)*startSpread)
+	}
+}
+
+// payloadCounts extracts iteration and function evaluation counts from a solver payload
+func payloadCounts(payload interface{}) (iterations, funcEvals int) {
+	p, ok := payload.(map[string]interface{})
+	if !ok {
+		return 0, 0
+	}
+	iterations, _ = p["majorIterations"].(int)
+	funcEvals, _ = p["funcEvaluations"].(int)
+	return iterations, funcEvals
+}
+
+// prepareData divides impData by its largest real part and returns it as the
+// scale coefficient. Data without a positive real part is left as it is and 1
+// returned.
 func prepareData(impData *[][2]float64) float64 {
 	maxZr := float64(0)
 	// TODO: Think about negative elements
@@ -374,6 +1055,9 @@ func prepareData(impData *[][2]float64) float64 {
 			maxZr = v[0]
 		}
 	}
+	if maxZr == 0 {
+		return 1
+	}
 	for i, v := range *impData {
 		(*impData)[i] = [2]float64{v[0] / maxZr, v[1] / maxZr}
 	}
@@ -383,7 +1067,7 @@ func prepareData(impData *[][2]float64) float64 {
 func (s *Solver) findInitValues(freqs []float64, impData [][2]float64) []float64 {
 	initValues := make([]float64, 0)
 
-	for _, char := range s.code {
+	for _, char := range circuitElements(s.code) {
 		switch char {
 		case 114: // R
 			min, max := minMax(freqs)
@@ -391,8 +1075,8 @@ func (s *Solver) findInitValues(freqs []float64, impData [][2]float64) []float64
 			initValues = append(initValues, impData[findClosest(freqs, freqAver)][0])
 		case 99: // C
 			initValues = append(initValues, 1e-5)
-		case 108: // L
-			initValues = append(initValues, 1e-5)
+		case 108: // L from the inductive loop, Im(Z) = wL
+			initValues = append(initValues, inductanceInit(freqs, impData))
 		case 119: // W (Infinite Warburg)
 			initValues = append(initValues, 1e-5)
 		case 113: // Q (CPE)
@@ -407,6 +1091,11 @@ func (s *Solver) findInitValues(freqs []float64, impData [][2]float64) []float64
 		case 103: // G (Gerischer) first parameter Y0, second k
 			initValues = append(initValues, 1)
 			initValues = append(initValues, 1)
+		case 112: // P (De Levie porous electrode) first parameter Ri, second Yi
+			min, max := minMax(freqs)
+			freqAver := math.Pow(10, (math.Log10(min)+math.Log10(max))/2)
+			initValues = append(initValues, impData[findClosest(freqs, freqAver)][0])
+			initValues = append(initValues, 1e-5)
 		case 102: // F (Fractal Gerischer) first parameter Y0, second k, third a
 			initValues = append(initValues, 1)
 			initValues = append(initValues, 1)
@@ -417,6 +1106,25 @@ func (s *Solver) findInitValues(freqs []float64, impData [][2]float64) []float64
 	return initValues
 }
 
+// inductanceInit estimates an inductance from the inductive loop of the
+// spectrum, from the highest frequency point when it has none and that point
+// is inductive, falling back to 1e-5
+func inductanceInit(freqs []float64, impData [][2]float64) float64 {
+	if loop := DetectInductiveLoop(freqs, impData); loop.Detected {
+		return loop.Inductance
+	}
+	top := -1
+	for i, f := range freqs {
+		if top < 0 || f > freqs[top] {
+			top = i
+		}
+	}
+	if top < 0 || impData[top][1] <= 0 {
+		return 1e-5
+	}
+	return impData[top][1] / (2 * math.Pi * freqs[top])
+}
+
 func minMax(a []float64) (float64, float64) {
 	if len(a) < 1 {
 		return 0, 0
@@ -438,7 +1146,10 @@ func findClosest(a []float64, x float64) int {
 	return index
 }
 
+// modifyParams returns a perturbed copy of values, values itself is left untouched
+// as it is still referenced by the solver results
 func modifyParams(values []float64, diff bool, primaryValues []float64, lastValues []float64, elements []string) []float64 {
+	values = append([]float64(nil), values...)
 	for i, n := range values {
 		// Safety check: skip if element index is out of bounds
 		if i >= len(elements) {
@@ -476,39 +1187,144 @@ func modifyParams(values []float64, diff bool, primaryValues []float64, lastValu
 }
 
 func ChiSq(observed, calculated [][2]float64, weighting Weighting) float64 {
+	return WeightedChiSq(observed, calculated, nil, weighting)
+}
+
+// WeightedChiSq is ChiSq with per-point standard deviations used by SIGMA weighting
+func WeightedChiSq(observed, calculated, sigmas [][2]float64, weighting Weighting) float64 {
 	if len(observed) != len(calculated) {
 		panic("solver chiSq: slice length mismatch")
 	}
 	chiSq := 0.0
 	for i, o := range observed {
 		c := calculated[i]
-		d2 := math.Pow(o[0]-c[0], 2) + math.Pow(o[1]-c[1], 2)
-		if weighting == UNITY {
-			chiSq += d2
-		} else if weighting == MODULUS {
-			weight := math.Sqrt(math.Pow(o[0], 2) + math.Pow(o[1], 2))
-			if weight > 0 {
-				chiSq += d2 / math.Pow(weight, 2)
-			} else {
-				chiSq += d2
-			}
-		}
+		wRe, wIm := pointWeights(o, sigmas, i, weighting)
+		dRe, dIm := o[0]-c[0], o[1]-c[1]
+		chiSq += wRe*dRe*dRe + wIm*dIm*dIm
 	}
 	// Normalize by number of data points
 	return chiSq / float64(len(observed))
 }
 
+// pointWeights returns the weights of the real and imaginary residual of point i
+func pointWeights(o [2]float64, sigmas [][2]float64, i int, weighting Weighting) (float64, float64) {
+	switch weighting {
+	case UNITY:
+		return 1, 1
+	case SIGMA:
+		if hasSigma(sigmas, i) {
+			return 1 / (sigmas[i][0] * sigmas[i][0]), 1 / (sigmas[i][1] * sigmas[i][1])
+		}
+	case PROPORTIONAL:
+		// A component that is exactly zero cannot be used as its own scale,
+		// that component falls back to unity weighting
+		wRe, wIm := 1.0, 1.0
+		if o[0] != 0 {
+			wRe = 1 / (o[0] * o[0])
+		}
+		if o[1] != 0 {
+			wIm = 1 / (o[1] * o[1])
+		}
+		return wRe, wIm
+	}
+	// Modulus weighting, also the fallback for points without a sigma
+	mod2 := o[0]*o[0] + o[1]*o[1]
+	if mod2 > 0 {
+		return 1 / mod2, 1 / mod2
+	}
+	return 1, 1
+}
+
+// SetWeighting configures the weighting by name. An empty name selects SIGMA when
+// sigmas are available and MODULUS otherwise.
+func (s *Solver) SetWeighting(name string, sigmas [][2]float64) error {
+	weighting, err := ParseWeighting(name)
+	if err != nil {
+		return err
+	}
+	if name == "" && len(sigmas) > 0 {
+		weighting = SIGMA
+	}
+	if weighting == SIGMA {
+		if len(sigmas) == 0 {
+			log.Printf("WARNING: Sigma weighting requested without uncertainties, all points use modulus weighting")
+		}
+		s.Sigmas = sigmas
+	}
+	s.Weighting = weighting
+	return nil
+}
+
+// hasSigma reports whether point i has usable standard deviations
+func hasSigma(sigmas [][2]float64, i int) bool {
+	return i < len(sigmas) && sigmas[i][0] > 0 && sigmas[i][1] > 0
+}
+
+// checkSigmas warns about points that fall back to modulus weighting
+func (s *Solver) checkSigmas() {
+	missing := 0
+	for i := range s.Observed {
+		if !hasSigma(s.Sigmas, i) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		log.Printf("WARNING: %d of %d points have no valid sigma, using modulus weighting for them", missing, len(s.Observed))
+	}
+}
+
+// Residuals returns observed - calculated for every data point
+func Residuals(observed, calculated [][2]float64) [][2]float64 {
+	if len(observed) != len(calculated) {
+		panic("solver residuals: slice length mismatch")
+	}
+	res := make([][2]float64, len(observed))
+	for i, o := range observed {
+		res[i] = [2]float64{o[0] - calculated[i][0], o[1] - calculated[i][1]}
+	}
+	return res
+}
+
 func GetModulo(data [][2]float64) []float64 {
-	var res []float64
-	for _, v := range data {
-		res = append(res, math.Sqrt(math.Pow(v[0], 2)+math.Pow(v[1], 2)))
+	res := make([]float64, len(data))
+	for i, v := range data {
+		res[i] = math.Sqrt(v[0]*v[0] + v[1]*v[1])
+	}
+	return res
+}
+
+// GetPhase returns the phase angle of every point in degrees, in (-180, 180]
+func GetPhase(data [][2]float64) []float64 {
+	res := make([]float64, len(data))
+	for i, v := range data {
+		res[i] = math.Atan2(v[1], v[0]) * 180 / math.Pi
+	}
+	return res
+}
+
+// ToPolar returns the magnitude and the phase in degrees of every point
+func ToPolar(data [][2]float64) (magnitude, phase []float64) {
+	return GetModulo(data), GetPhase(data)
+}
+
+// FromPolar converts magnitudes and phases in degrees into {real, imag}
+// pairs, extra values of the longer slice are ignored
+func FromPolar(magnitude, phase []float64) [][2]float64 {
+	n := len(magnitude)
+	if len(phase) < n {
+		n = len(phase)
+	}
+	res := make([][2]float64, n)
+	for i := range res {
+		sin, cos := math.Sincos(phase[i] * math.Pi / 180)
+		res[i] = [2]float64{magnitude[i] * cos, magnitude[i] * sin}
 	}
 	return res
 }
 
 func GetElements(code string) []string {
 	var elements []string
-	for _, char := range code {
+	for _, char := range circuitElements(code) {
 		switch char {
 		case 114, 99, 108, 119: // r, c, l ,w
 			elements = append(elements, string(char))
@@ -524,6 +1340,9 @@ func GetElements(code string) []string {
 		case 103: // G
 			elements = append(elements, "gy")
 			elements = append(elements, "gk")
+		case 112: // P
+			elements = append(elements, "pr")
+			elements = append(elements, "py")
 		case 102: // F
 			elements = append(elements, "fy")
 			elements = append(elements, "fk")
@@ -533,18 +1352,19 @@ func GetElements(code string) []string {
 	return elements
 }
 
+// scaleParams works on linear parameters, log-scaled parameters are mapped back
+// by the base solvers before results get here
 func scaleParams(params *[]float64, elements []string, scale float64) {
 	if len(*params) != len(elements) {
 		panic("solver: slice length mismatch")
 	}
-	// TODO: implement "gy", "gk", "fy", "fk", "fa"
 	for i, v := range elements {
 		switch v {
-		case "r":
-			// Resistance scales with impedance
+		case "r", "pr", "l":
+			// Resistance, De Levie ionic resistance and inductance scale with impedance
 			(*params)[i] = (*params)[i] * scale
-		case "c", "w", "qy", "oy":
-			// Capacitance, Warburg, CPE Y0 scale inversely with impedance
+		case "c", "w", "qy", "oy", "py":
+			// Capacitance, Warburg, CPE Y0, De Levie Yi scale inversely with impedance
 			(*params)[i] = (*params)[i] * 1 / scale
 		case "qn", "ob", "tb":
 			// CPE exponent n, Warburg length parameters B are dimensionless - no scaling
@@ -552,6 +1372,11 @@ func scaleParams(params *[]float64, elements []string, scale float64) {
 		case "ty":
 			// Transmission line Y0 scales inversely like admittance
 			(*params)[i] = (*params)[i] * 1 / scale
+		case "gy", "fy":
+			// Gerischer Z = (k+jw)^-a / Y0, only Y0 carries the impedance scale
+			(*params)[i] = (*params)[i] * 1 / scale
+		case "gk", "fk", "fa":
+			// Rate constant k is in 1/s like w and exponent a is dimensionless - no scaling
 		}
 	}
 }
@@ -597,7 +1422,7 @@ func (s *Solver) baseLBFGSSolve() Result {
 		Concurrent:        10000,
 	}
 
-	res, err := optimize.Minimize(problem, s.InitValues, settings, &optimize.LBFGS{})
+	res, err := optimize.Minimize(problem, s.toLogSpace(s.InitValues), settings, &optimize.LBFGS{})
 	if err != nil {
 		log.Printf("LBFGS optimization error: %v", err)
 		return Result{Min: math.Inf(1), Status: "ERROR"}
@@ -609,7 +1434,7 @@ func (s *Solver) baseLBFGSSolve() Result {
 	}
 
 	return Result{
-		Params:  res.X,
+		Params:  s.fromLogSpace(res.X),
 		Min:     res.F,
 		MinUnit: "ChiSq",
 		Runtime: float64(res.Runtime / 1000),
@@ -658,7 +1483,7 @@ func (s *Solver) baseNewtonSolve() Result {
 		Concurrent:        10000,
 	}
 
-	res, err := optimize.Minimize(problem, s.InitValues, settings, &optimize.Newton{})
+	res, err := optimize.Minimize(problem, s.toLogSpace(s.InitValues), settings, &optimize.Newton{})
 	if err != nil {
 		log.Printf("Newton optimization error: %v", err)
 		return Result{Min: math.Inf(1), Status: "ERROR"}
@@ -670,7 +1495,7 @@ func (s *Solver) baseNewtonSolve() Result {
 	}
 
 	return Result{
-		Params:  res.X,
+		Params:  s.fromLogSpace(res.X),
 		Min:     res.F,
 		MinUnit: "ChiSq",
 		Runtime: float64(res.Runtime / 1000),
@@ -681,14 +1506,88 @@ func (s *Solver) baseNewtonSolve() Result {
 
 func (s *Solver) Clone() *Solver {
 	newS := *s
+	newS.scratch = newScratchPool()
+	newS.progress = nil // clones run concurrently, only the original reports
 	newS.Observed = make([][2]float64, len(s.Observed))
 	copy(newS.Observed, s.Observed)
 
 	newS.Freqs = make([]float64, len(s.Freqs))
 	copy(newS.Freqs, s.Freqs)
+	if s.omegasOf(s.Freqs) != nil {
+		newS.omegaFreqs = newS.Freqs // omegas are read only and shared
+	}
 
 	newS.InitValues = make([]float64, len(s.InitValues))
 	copy(newS.InitValues, s.InitValues)
 
+	if s.excluded != nil {
+		newS.excluded = make([]bool, len(s.excluded))
+		copy(newS.excluded, s.excluded)
+	}
+
+	if s.Sigmas != nil {
+		newS.Sigmas = make([][2]float64, len(s.Sigmas))
+		copy(newS.Sigmas, s.Sigmas)
+	}
+
+	if s.LogScale != nil {
+		newS.LogScale = make([]bool, len(s.LogScale))
+		copy(newS.LogScale, s.LogScale)
+	}
+
 	return &newS
 }
+
+// DefaultLogScale marks the positive parameters of code that span many orders of
+// magnitude (capacitances and admittance-like Y0 values) for log-space optimization
+func DefaultLogScale(code string) []bool {
+	elements := GetElements(code)
+	logScale := make([]bool, len(elements))
+	for i, e := range elements {
+		switch e {
+		case "c", "w", "qy", "oy", "ty", "gy", "fy", "py":
+			logScale[i] = true
+		}
+	}
+	return logScale
+}
+
+func (s *Solver) isLogScaled(i int) bool {
+	return i < len(s.LogScale) && s.LogScale[i]
+}
+
+// toLogSpace maps linear parameters into the space seen by the optimizer.
+// Non-positive values of log-scaled parameters can't be represented and are
+// replaced by logScaleFloor.
+func (s *Solver) toLogSpace(params []float64) []float64 {
+	if len(s.LogScale) == 0 {
+		return params
+	}
+	res := make([]float64, len(params))
+	for i, v := range params {
+		if s.isLogScaled(i) {
+			if v <= 0 {
+				log.Printf("Log-scaled parameter %d has non-positive value %v, using %v", i, v, logScaleFloor)
+				v = logScaleFloor
+			}
+			v = math.Log(v)
+		}
+		res[i] = v
+	}
+	return res
+}
+
+// fromLogSpace maps optimizer parameters back into linear parameters
+func (s *Solver) fromLogSpace(x []float64) []float64 {
+	if len(s.LogScale) == 0 {
+		return x
+	}
+	res := make([]float64, len(x))
+	for i, v := range x {
+		if s.isLogScaled(i) {
+			v = math.Exp(v)
+		}
+		res[i] = v
+	}
+	return res
+}
@@ -0,0 +1,365 @@
+package goimpcore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpectrum is the spectrum of R(QR) with 1 % seeded noise
+func testSpectrum() (freqs []float64, impData [][2]float64) {
+	freqs, _ = LogFrequencies(1, 1e5, 5)
+	impData = CircuitImpedanceNoisyRand("r(qr)", freqs, []float64{10, 1e-5, 0.9, 100}, 0, 0, true, UNIFORM, rand.New(rand.NewSource(1)))
+	return freqs, impData
+}
+
+// The Min reported by the solver is the chi-square of its parameters on the
+// original data, whatever the mode, so callers never recalculate it
+func TestSolverMinConsistent(t *testing.T) {
+	freqs, impData := testSpectrum()
+	for _, mode := range []string{"eis", "lm", "nm+lm"} {
+		t.Run(mode, func(t *testing.T) {
+			s := NewSolver("R(QR)", freqs, impData)
+			s.SmartMode = mode
+			s.InitValues = []float64{5, 1e-6, 0.8, 50}
+			s.Seed = 1
+			s.Diagnostics.Disabled = true
+			res := s.Solve(1e-9, 10)
+			if res.Status != OK {
+				t.Fatalf("status %s", res.Status)
+			}
+			chiSq := ChiSq(impData, CircuitImpedance("r(qr)", freqs, res.Params), MODULUS)
+			if diff := math.Abs(chiSq - res.Min); diff > 1e-12*math.Max(1, res.Min) {
+				t.Errorf("solver Min %v, recalculated %v", res.Min, chiSq)
+			}
+		})
+	}
+}
+
+// A solve cancelled by its context returns the best parameters found so far
+// instead of running to its iteration limit
+func TestSolveWithContextCancel(t *testing.T) {
+	freqs, impData := testSpectrum()
+	s := NewSolver("R(QR)", freqs, impData)
+	s.SmartMode = "eis"
+	s.InitValues = []float64{5, 1e-6, 0.8, 50}
+	s.Diagnostics.Disabled = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err := s.SolveWithContext(ctx, 0, math.MaxInt32) // never converges
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("returned %v after the cancellation", elapsed-100*time.Millisecond)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(res.Params) != 4 || math.IsInf(res.Min, 0) {
+		t.Errorf("partial result %v with Min %v, want the 4 best parameters so far", res.Params, res.Min)
+	}
+}
+
+// Optimizing ln(p) fits a CPE of a tiny Y0 that the linear parameters miss
+// from the same initial values
+func TestLogScaleSmallY0(t *testing.T) {
+	params := []float64{10, 1e-8, 0.9, 1e5}
+	freqs, _ := LogFrequencies(0.1, 1e5, 5)
+	impData := CircuitImpedance("r(qr)", freqs, params)
+
+	solve := func(logScale []bool) Result {
+		s := NewSolver("R(QR)", freqs, impData)
+		s.InitValues = []float64{20, 1e-5, 0.8, 1e4}
+		s.LogScale = logScale
+		s.Diagnostics.Disabled = true
+		return s.Solve(0, 1)
+	}
+	linear := solve(nil)
+	logScaled := solve(DefaultLogScale("R(QR)"))
+	if linear.Status != OK || logScaled.Status != OK {
+		t.Fatalf("status %s linear, %s log-scaled", linear.Status, logScaled.Status)
+	}
+	if !(logScaled.Min < linear.Min) {
+		t.Errorf("chi-square %v log-scaled, not below %v linear", logScaled.Min, linear.Min)
+	}
+	if math.Abs(logScaled.Params[1]/params[1]-1) > 0.01 {
+		t.Errorf("log-scaled Y0 = %v, want %v", logScaled.Params[1], params[1])
+	}
+}
+
+// readSpectrum reads a measurement of frequency, real and imaginary columns
+func readSpectrum(t *testing.T, name string) (freqs []float64, impData [][2]float64) {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		var v [3]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		freqs = append(freqs, v[0])
+		impData = append(impData, [2]float64{v[1], v[2]})
+	}
+	return freqs, impData
+}
+
+// From random initial values, the parallel multi-start reaches the target
+// chi-square of the ASTM0 case more often than a single start
+func TestParallelSolveReliability(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	const trials, target = 20, 1.35e-2
+	freqs, impData := readSpectrum(t, "cmd/goimpsolver/ASTM0.txt")
+	rnd := rand.New(rand.NewSource(1))
+
+	single, multi := 0, 0
+	for trial := 0; trial < trials; trial++ {
+		// Scale parameters up to two decades off, n from 0.5 to 1
+		init := []float64{10, 1e-4, 1, 100}
+		for i := range init {
+			if i == 2 {
+				init[i] = 0.5 + 0.5*rnd.Float64()
+			} else {
+				init[i] *= math.Pow(10, 4*rnd.Float64()-2)
+			}
+		}
+		solve := func(mode string) Result {
+			s := NewSolver("R(QR)", freqs, impData)
+			s.SmartMode = mode
+			s.Starts = 8
+			s.InitValues = append([]float64(nil), init...)
+			s.Seed = int64(trial + 1)
+			s.Diagnostics.Disabled = true
+			return s.Solve(target, 1)
+		}
+		if solve("eis").Min <= target {
+			single++
+		}
+		if solve("parallel").Min <= target {
+			multi++
+		}
+	}
+	if multi <= single || multi < trials*9/10 {
+		t.Errorf("%d of %d multi-start fits reached %v, %d single-start", multi, trials, target, single)
+	}
+}
+
+// Fitting data normalized by prepareData and scaling the parameters back
+// recovers the parameters of the original data
+func TestScaleParamsRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	tests := []struct {
+		code   string
+		params []float64
+	}{
+		{"R(CR)G", []float64{10, 1e-6, 50, 1e-2, 10}},
+		{"R(QR)", []float64{10, 1e-5, 0.9, 100}},
+		{"R(CR)W", []float64{10, 1e-6, 50, 1e-2}},
+		{"R(LR)", []float64{10, 1e-3, 100}},
+		{"R(L)", []float64{10, 1e-3}},
+	}
+	freqs, _ := LogFrequencies(1e-2, 1e5, 10)
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			elements := GetElements(strings.ToLower(tt.code))
+			impData := CircuitImpedanceNoisySeeded(strings.ToLower(tt.code), freqs, tt.params, 0, 0, true, 1)
+			observed := append([][2]float64(nil), impData...)
+			coef := prepareData(&observed)
+
+			// Start 20 % off the true parameters in the normalized units
+			init := append([]float64(nil), tt.params...)
+			scaleParams(&init, elements, 1/coef)
+			for i, e := range elements {
+				if e != "qn" {
+					init[i] *= 1.2
+				}
+			}
+			s := NewSolver(tt.code, freqs, observed)
+			s.InitValues = init
+			s.Diagnostics.Disabled = true
+			res := s.Solve(0, 1)
+			if res.Status != OK {
+				t.Fatalf("status %s", res.Status)
+			}
+
+			scaleParams(&res.Params, elements, coef)
+			scaleData(&observed, coef)
+			for i, p := range tt.params {
+				if math.Abs(res.Params[i]/p-1) > 0.05 {
+					t.Errorf("parameter %d (%s) = %v, want %v within 5%%", i, elements[i], res.Params[i], p)
+				}
+			}
+			for i := range impData {
+				if math.Abs(observed[i][0]-impData[i][0]) > 1e-12*math.Abs(impData[i][0]) {
+					t.Fatalf("point %d scaled back to %v, want %v", i, observed[i], impData[i])
+				}
+			}
+		})
+	}
+}
+
+// An LM run stopped by a singular Jacobian fails with the ERROR status
+// instead of panicking or returning empty parameters as a fit
+func TestLMSingularJacobian(t *testing.T) {
+	freqs, _ := LogFrequencies(1, 1e5, 4)
+	impData := CircuitImpedance("r(cr)", freqs, []float64{10, 1e-5, 100})
+	// From these initial values LM hits singular normal equations at once
+	s := NewSolver("R(CR)", freqs, impData)
+	s.SmartMode = "lm"
+	s.InitValues = []float64{20, 1e-4, 200}
+	s.Diagnostics.Disabled = true
+
+	if res := s.baseLMSolve(); res.Status != "ERROR" || len(res.Params) != 0 || !math.IsInf(res.Min, 1) {
+		t.Fatalf("LM run %s with Min %v and parameters %v, want ERROR", res.Status, res.Min, res.Params)
+	}
+	res := s.Solve(1e-9, 1)
+	if res.Status != "ERROR" || len(res.Params) != 0 {
+		t.Errorf("solve %s with parameters %v, want ERROR without parameters", res.Status, res.Params)
+	}
+}
+
+// The objective evaluations of a fit make no allocations once warmed up
+func TestObjectiveAllocs(t *testing.T) {
+	freqs, impData := testSpectrum()
+	s := NewSolver("R(QR)", freqs, impData)
+	x := []float64{10, 1e-5, 0.9, 100}
+	objectives := map[string]func([]float64) float64{
+		"problem":                  s.problem,
+		"problemWithQnConstraints": s.problemWithQnConstraints,
+	}
+	for name, objective := range objectives {
+		objective(x) // warm up the buffer pool
+		if allocs := testing.AllocsPerRun(100, func() { objective(x) }); allocs > 0 {
+			t.Errorf("%s: %v allocations per evaluation, want 0", name, allocs)
+		}
+	}
+}
+
+func BenchmarkObjective(b *testing.B) {
+	freqs, impData := testSpectrum()
+	s := NewSolver("R(QR)", freqs, impData)
+	x := []float64{10, 1e-5, 0.9, 100}
+	s.problemWithQnConstraints(x)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.problemWithQnConstraints(x)
+	}
+}
+
+func TestNewSolverFromLibrary(t *testing.T) {
+	freqs, impData := testSpectrum()
+	s, err := NewSolverFromLibrary("Randles", freqs, impData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rs, Cdl, Rct and W_Y0 of R(C(RW))
+	if s.code != "r(c(rw))" || len(s.InitValues) != 4 {
+		t.Errorf("solver of %s with %d initial values, want r(c(rw)) with 4", s.code, len(s.InitValues))
+	}
+	if _, err := NewSolverFromLibrary("NoSuchCircuit", freqs, impData); err == nil {
+		t.Error("no error for an unknown circuit")
+	}
+}
+
+// A 10-parameter fit to 15 points wanders off by decades from random starts,
+// the suggested regularization keeps it within one decade
+func TestRegularizationSpread(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	params := []float64{10, 1e-5, 0.9, 100, 1e-4, 0.8, 1000, 1e-6, 50, 1e-3}
+	freqs, _ := LogFrequencies(0.1, 1e6, 2)
+	impData := CircuitImpedanceNoisySeeded("r(qr)(qr)(cr)", freqs, params, 0, 0.01, true, 1)
+
+	// spread returns the largest range, in decades, of a parameter fitted
+	// from 10 random starts within half a decade of params
+	spread := func(regularization float64) float64 {
+		rnd := rand.New(rand.NewSource(1))
+		lo, hi := make([]float64, len(params)), make([]float64, len(params))
+		for i := range params {
+			lo[i], hi[i] = math.Inf(1), math.Inf(-1)
+		}
+		for trial := 0; trial < 10; trial++ {
+			init := append([]float64(nil), params...)
+			for i := range init {
+				if i == 2 || i == 5 {
+					init[i] = 0.6 + 0.4*rnd.Float64()
+				} else {
+					init[i] *= math.Pow(10, rnd.Float64()-0.5)
+				}
+			}
+			s := NewSolver("R(QR)(QR)(CR)", freqs, impData)
+			s.InitValues = init
+			s.Regularization = regularization
+			s.Diagnostics.Disabled = true
+			res := s.Solve(0, 1)
+			if len(res.Params) != len(params) {
+				t.Fatalf("trial %d: status %s with %d parameters", trial, res.Status, len(res.Params))
+			}
+			for i, p := range res.Params {
+				l := math.Log10(math.Abs(p))
+				lo[i], hi[i] = math.Min(lo[i], l), math.Max(hi[i], l)
+			}
+		}
+		max := 0.0
+		for i := range lo {
+			max = math.Max(max, hi[i]-lo[i])
+		}
+		return max
+	}
+
+	regularization := SuggestRegularization(len(freqs), len(params))
+	if regularization == 0 {
+		t.Fatalf("no regularization suggested for %d points and %d parameters", len(freqs), len(params))
+	}
+	free, regularized := spread(0), spread(regularization)
+	if free < 3 || regularized > 1 {
+		t.Errorf("spread %.2f decades without regularization, %.2f with %v", free, regularized, regularization)
+	}
+}
+
+func TestSuggestRegularization(t *testing.T) {
+	if r := SuggestRegularization(101, 10); r != 0 {
+		t.Errorf("SuggestRegularization(101, 10) = %v, want 0", r)
+	}
+	if a, b := SuggestRegularization(50, 10), SuggestRegularization(15, 10); !(0 < a && a < b) {
+		t.Errorf("SuggestRegularization 50 points %v, 15 points %v, want growing as points drop", a, b)
+	}
+}
+
+// The same Seed gives the same multi-start fit
+func TestSolverSeedReproducible(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	freqs, impData := testSpectrum()
+	solve := func() Result {
+		s := NewSolver("R(QR)", freqs, impData)
+		s.SmartMode = "parallel"
+		s.Starts = 4
+		s.Seed = 7
+		s.Diagnostics.Disabled = true
+		return s.Solve(0, 1)
+	}
+	a, b := solve(), solve()
+	if a.Status != OK || !reflect.DeepEqual(a.Params, b.Params) || a.Min != b.Min {
+		t.Errorf("fits with the same seed differ: %v (%v) and %v (%v)", a.Params, a.Min, b.Params, b.Min)
+	}
+}
@@ -0,0 +1,107 @@
+package goimpcore
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Space is the representation in which the residuals are minimized
+type Space int
+
+const (
+	IMPEDANCE  Space = iota
+	ADMITTANCE       // Y = 1/Z, better conditioned for highly capacitive samples
+)
+
+// spaceNames maps the Space values to their configuration names
+var spaceNames = map[Space]string{
+	IMPEDANCE:  "impedance",
+	ADMITTANCE: "admittance",
+}
+
+func (sp Space) String() string {
+	if name, ok := spaceNames[sp]; ok {
+		return name
+	}
+	return fmt.Sprintf("Space(%d)", int(sp))
+}
+
+// ParseSpace returns the Space for a configuration name, an empty name is IMPEDANCE
+func ParseSpace(name string) (Space, error) {
+	if name == "" {
+		return IMPEDANCE, nil
+	}
+	for sp, n := range spaceNames {
+		if strings.EqualFold(n, name) {
+			return sp, nil
+		}
+	}
+	return IMPEDANCE, fmt.Errorf("unknown space %q", name)
+}
+
+// zeroModulus is the |Z| below which an impedance counts as a short circuit
+// in admittance space. Observed points that small are left out of the fit and
+// calculated ones are clamped, so 1/Z stays finite.
+const zeroModulus = 1e-12
+
+// pointResidual returns the residual of point i, observed minus calculated,
+// and its weights in the given space. In admittance space the weights are
+// those of the admittance, sigmas are propagated as σ/|Z|². Observed
+// short-circuit points get zero weights.
+func pointResidual(o, c [2]float64, sigmas [][2]float64, i int, weighting Weighting, space Space) (dRe, dIm, wRe, wIm float64) {
+	if space != ADMITTANCE {
+		wRe, wIm = pointWeights(o, sigmas, i, weighting)
+		return o[0] - c[0], o[1] - c[1], wRe, wIm
+	}
+
+	mod2 := o[0]*o[0] + o[1]*o[1]
+	if mod2 < zeroModulus*zeroModulus {
+		return 0, 0, 0, 0
+	}
+	yo := [2]float64{o[0] / mod2, -o[1] / mod2}
+	yc := toAdmittance(c)
+
+	if weighting == SIGMA && hasSigma(sigmas, i) {
+		wRe = mod2 * mod2 / (sigmas[i][0] * sigmas[i][0])
+		wIm = mod2 * mod2 / (sigmas[i][1] * sigmas[i][1])
+	} else {
+		wRe, wIm = pointWeights(yo, nil, i, weighting)
+	}
+	return yo[0] - yc[0], yo[1] - yc[1], wRe, wIm
+}
+
+// toAdmittance returns 1/z with |z| clamped to at least zeroModulus
+func toAdmittance(z [2]float64) [2]float64 {
+	mod2 := z[0]*z[0] + z[1]*z[1]
+	if mod2 < zeroModulus*zeroModulus {
+		if mod2 == 0 {
+			return [2]float64{1 / zeroModulus, 0}
+		}
+		scale := zeroModulus / math.Sqrt(mod2)
+		z = [2]float64{z[0] * scale, z[1] * scale}
+		mod2 = zeroModulus * zeroModulus
+	}
+	return [2]float64{z[0] / mod2, -z[1] / mod2}
+}
+
+// SpaceChiSq is WeightedChiSq computed in the given space
+func SpaceChiSq(observed, calculated, sigmas [][2]float64, weighting Weighting, space Space) float64 {
+	if space == IMPEDANCE {
+		return WeightedChiSq(observed, calculated, sigmas, weighting)
+	}
+	if len(observed) != len(calculated) {
+		panic("solver chiSq: slice length mismatch")
+	}
+	chiSq := 0.0
+	for i, o := range observed {
+		dRe, dIm, wRe, wIm := pointResidual(o, calculated[i], sigmas, i, weighting, space)
+		chiSq += wRe*dRe*dRe + wIm*dIm*dIm
+	}
+	return chiSq / float64(len(observed))
+}
+
+// chiSq is the objective without penalties in the solver's space
+func (s *Solver) chiSq(observed, calculated, sigmas [][2]float64) float64 {
+	return SpaceChiSq(observed, calculated, sigmas, s.Weighting, s.Space)
+}
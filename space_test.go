@@ -0,0 +1,66 @@
+package goimpcore
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"testing"
+)
+
+// Fitting R(CR) in admittance space recovers the parameters found in
+// impedance space, with residuals and chi-square reported for impedances
+func TestAdmittanceSpaceFit(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	params := []float64{10, 1e-6, 1000}
+	freqs, _ := LogFrequencies(1e-1, 1e5, 5)
+	impData := CircuitImpedanceNoisySeeded("r(cr)", freqs, params, 0, 0.01, true, 1)
+
+	results := make(map[Space]Result)
+	for _, space := range []Space{IMPEDANCE, ADMITTANCE} {
+		s := NewSolver("R(CR)", freqs, impData)
+		s.InitValues = []float64{20, 1e-5, 500}
+		s.Space = space
+		s.Diagnostics.Disabled = true
+		res := s.Solve(0, 1)
+		if res.Status != OK {
+			t.Fatalf("%v: status %s", space, res.Status)
+		}
+		for i, p := range params {
+			if math.Abs(res.Params[i]/p-1) > 0.02 {
+				t.Errorf("%v: parameter %d = %v, want %v within 2%%", space, i, res.Params[i], p)
+			}
+		}
+		results[space] = res
+	}
+
+	z, y := results[IMPEDANCE], results[ADMITTANCE]
+	for i := range z.Params {
+		if math.Abs(y.Params[i]/z.Params[i]-1) > 0.02 {
+			t.Errorf("parameter %d: %v in admittance space, %v in impedance space", i, y.Params[i], z.Params[i])
+		}
+	}
+	// Residuals stay in impedance units
+	want := Residuals(impData, CircuitImpedance("r(cr)", freqs, y.Params))
+	for i := range want {
+		if y.Residuals[i] != want[i] {
+			t.Fatalf("admittance residual %d = %v, want the impedance residual %v", i, y.Residuals[i], want[i])
+		}
+	}
+}
+
+func TestToAdmittanceShortCircuit(t *testing.T) {
+	for _, z := range [][2]float64{{0, 0}, {1e-20, 0}, {0, -1e-15}} {
+		y := toAdmittance(z)
+		if math.IsNaN(y[0]) || math.IsNaN(y[1]) || math.IsInf(y[0], 0) || math.IsInf(y[1], 0) {
+			t.Errorf("toAdmittance(%v) = %v", z, y)
+		}
+	}
+	// An observed short circuit drops out of the admittance chi-square
+	observed := [][2]float64{{0, 0}, {100, -50}}
+	calculated := [][2]float64{{1, 0}, {100, -50}}
+	if chiSq := SpaceChiSq(observed, calculated, nil, MODULUS, ADMITTANCE); chiSq != 0 {
+		t.Errorf("chi-square %v with only the short circuit off", chiSq)
+	}
+}
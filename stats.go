@@ -0,0 +1,211 @@
+package goimpcore
+
+import (
+	"math"
+)
+
+// Model selection criteria accepted by Result.Score
+const (
+	CriterionChiSq = "chisq"
+	CriterionAIC   = "aic"
+	CriterionBIC   = "bic"
+)
+
+// FitStats holds goodness-of-fit and model selection statistics of a solve.
+// Real and imaginary parts are counted as separate observations, so N is 2x the
+// number of frequencies.
+type FitStats struct {
+	N            int     `json:"n"`
+	Params       int     `json:"params"`
+	DoF          int     `json:"dof"`
+	WeightedSSR  float64 `json:"weighted_ssr"`
+	ReducedChiSq float64 `json:"reduced_chi_square"`
+	RSquared     float64 `json:"r_squared"`
+	AIC          float64 `json:"aic"`
+	BIC          float64 `json:"bic"`
+	// RMSE is the unweighted root mean square residual in ohms and NRMSE
+	// the same relative to the RMS of the observed parts
+	RMSE      float64 `json:"rmse"`
+	NRMSE     float64 `json:"nrmse"`
+	Weighting string  `json:"weighting"` // weighting of WeightedSSR, see Weighting.String
+}
+
+// ComputeFitStats calculates fit statistics of calculated against observed data
+// using the same weighting as the objective function
+func ComputeFitStats(observed, calculated, sigmas [][2]float64, nParams int, weighting Weighting) FitStats {
+	if len(observed) != len(calculated) {
+		panic("solver fitStats: slice length mismatch")
+	}
+
+	n := 2 * len(observed)
+	stats := FitStats{
+		N:         n,
+		Params:    nParams,
+		DoF:       n - nParams,
+		Weighting: weighting.String(),
+	}
+	if len(observed) == 0 {
+		return stats
+	}
+
+	weights := make([][2]float64, len(observed))
+	var sumWRe, sumWIm, meanRe, meanIm float64
+	for i, o := range observed {
+		wRe, wIm := pointWeights(o, sigmas, i, weighting)
+		weights[i] = [2]float64{wRe, wIm}
+		sumWRe += wRe
+		sumWIm += wIm
+		meanRe += wRe * o[0]
+		meanIm += wIm * o[1]
+	}
+	meanRe /= sumWRe
+	meanIm /= sumWIm
+
+	var ssr, sst, unweighted, magnitude float64
+	for i, o := range observed {
+		c := calculated[i]
+		w := weights[i]
+		dRe, dIm := o[0]-c[0], o[1]-c[1]
+		ssr += w[0]*dRe*dRe + w[1]*dIm*dIm
+		unweighted += dRe*dRe + dIm*dIm
+		magnitude += o[0]*o[0] + o[1]*o[1]
+		dRe, dIm = o[0]-meanRe, o[1]-meanIm
+		sst += w[0]*dRe*dRe + w[1]*dIm*dIm
+	}
+
+	stats.RMSE = math.Sqrt(unweighted / float64(n))
+	if magnitude > 0 {
+		stats.NRMSE = math.Sqrt(unweighted / magnitude)
+	}
+	stats.WeightedSSR = ssr
+	if stats.DoF > 0 {
+		stats.ReducedChiSq = ssr / float64(stats.DoF)
+	} else {
+		stats.ReducedChiSq = math.Inf(1)
+	}
+	if sst > 0 {
+		stats.RSquared = 1 - ssr/sst
+	}
+
+	// Gaussian likelihood with unknown variance
+	logLik := float64(n) * math.Log(ssr/float64(n))
+	stats.AIC = logLik + 2*float64(nParams)
+	stats.BIC = logLik + float64(nParams)*math.Log(float64(n))
+
+	return stats
+}
+
+// Score returns the value used to rank results by the given criterion, lower is better.
+// Unknown criteria fall back to the objective minimum.
+func (r Result) Score(criterion string) float64 {
+	if criterion != CriterionChiSq && criterion != "" && r.Stats.N == 0 {
+		// No statistics, the solve failed
+		return math.Inf(1)
+	}
+	switch criterion {
+	case CriterionAIC:
+		return r.Stats.AIC
+	case CriterionBIC:
+		return r.Stats.BIC
+	}
+	return r.Min
+}
+
+// ReducedChiSq returns the weighted sum of squared residuals of calculated
+// against observed per degree of freedom, the real and imaginary parts
+// counting as separate observations. With SIGMA weighting a value near 1
+// means the model describes the data within its noise, much more than 1
+// underfitting or underestimated errors and much less than 1 overfitting.
+// It is +Inf when there are no more observations than parameters.
+func ReducedChiSq(observed, calculated [][2]float64, weighting Weighting, nParams int) float64 {
+	dof := 2*len(observed) - nParams
+	if dof <= 0 {
+		return math.Inf(1)
+	}
+	return WeightedChiSq(observed, calculated, nil, weighting) * float64(len(observed)) / float64(dof)
+}
+
+// Fit quality ratings of FitQuality.Rating, best first
+const (
+	RatingExcellent  = "excellent"
+	RatingGood       = "good"
+	RatingAcceptable = "acceptable"
+	RatingPoor       = "poor"
+)
+
+// FitQuality summarizes how well a fit describes its data
+type FitQuality struct {
+	ChiSq        float64 `json:"chi_square"`         // weighted sum of squared residuals
+	ReducedChiSq float64 `json:"reduced_chi_square"` // ChiSq per degree of freedom
+	RMSE         float64 `json:"rmse"`               // unweighted, in ohms
+	NRMSE        float64 `json:"nrmse"`              // RMSE relative to the RMS of the observed parts
+	R2           float64 `json:"r2"`
+	Rating       string  `json:"rating"` // excellent, good, acceptable or poor, empty without statistics
+}
+
+// Relative residuals rated by FitQuality when the weighting carries no noise
+// estimate, about 1% for data with 1% proportional noise
+const (
+	excellentRelativeError  = 0.005
+	goodRelativeError       = 0.02
+	acceptableRelativeError = 0.05
+)
+
+// GoodnessFit returns the fit quality of result, fitted to nPoints
+// frequencies. The rating follows the reduced chi-square: with SIGMA
+// weighting by how far it is from 1, with MODULUS and PROPORTIONAL weighting,
+// where its square root is the relative residual, by that residual, and with
+// UNITY weighting by NRMSE. A result without statistics has an empty rating.
+func GoodnessFit(result Result, nPoints int) FitQuality {
+	stats := result.Stats
+	if stats.N == 0 {
+		return FitQuality{}
+	}
+	quality := FitQuality{
+		ChiSq:        stats.WeightedSSR,
+		ReducedChiSq: math.Inf(1),
+		RMSE:         stats.RMSE,
+		NRMSE:        stats.NRMSE,
+		R2:           stats.RSquared,
+	}
+	if dof := 2*nPoints - len(result.Params); dof > 0 {
+		quality.ReducedChiSq = stats.WeightedSSR / float64(dof)
+	}
+	quality.Rating = rateFit(quality, stats.Weighting)
+	return quality
+}
+
+// rateFit rates quality fitted with the named weighting
+func rateFit(quality FitQuality, weighting string) string {
+	chi := quality.ReducedChiSq
+	if math.IsNaN(chi) || math.IsInf(chi, 0) {
+		return RatingPoor
+	}
+
+	if weighting == SIGMA.String() {
+		deviation := math.Max(chi, 1/chi)
+		switch {
+		case deviation <= 2:
+			return RatingExcellent
+		case deviation <= 5:
+			return RatingGood
+		case deviation <= 10:
+			return RatingAcceptable
+		}
+		return RatingPoor
+	}
+
+	relative := math.Sqrt(chi)
+	if weighting == UNITY.String() {
+		relative = quality.NRMSE
+	}
+	switch {
+	case relative <= excellentRelativeError:
+		return RatingExcellent
+	case relative <= goodRelativeError:
+		return RatingGood
+	case relative <= acceptableRelativeError:
+		return RatingAcceptable
+	}
+	return RatingPoor
+}
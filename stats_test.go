@@ -0,0 +1,46 @@
+package goimpcore
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// A fit of R(QR) data carrying the 1% Gaussian noise of a typical
+// potentiostat is rated "good"; noise-free data is rated "excellent"
+func TestGoodnessFitRating(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	freqs, err := LogFrequencies(0.1, 1e5, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := []float64{10, 1e-5, 0.85, 1000}
+
+	tests := []struct {
+		noisy bool // 1% Gaussian noise on every point
+		want  string
+	}{
+		{false, RatingExcellent},
+		{true, RatingGood},
+	}
+	for _, tt := range tests {
+		for seed := int64(1); seed <= 5; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			impData := CircuitImpedanceNoisyRand("r(qr)", freqs, params, 0, 0, tt.noisy, GAUSSIAN, rnd)
+			s := NewSolver("R(QR)", freqs, impData)
+			s.InitValues = []float64{5, 1e-4, 0.8, 500}
+			s.Diagnostics.Disabled = true
+			res := s.Solve(0, 1)
+			if res.Status != OK {
+				t.Fatalf("noisy %v, seed %d: fit failed with %s", tt.noisy, seed, res.Status)
+			}
+			quality := GoodnessFit(res, len(freqs))
+			if quality.Rating != tt.want || res.Quality.Rating != tt.want {
+				t.Errorf("noisy %v, seed %d: rated %s (reduced chi-square %v), want %s", tt.noisy, seed, quality.Rating, quality.ReducedChiSq, tt.want)
+			}
+		}
+	}
+}
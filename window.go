@@ -0,0 +1,101 @@
+package goimpcore
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrEmptyWindow is returned when the frequency window excludes every data point
+var ErrEmptyWindow = errors.New("frequency window excludes all data points")
+
+// FrequencyMask reports which frequencies lie within [fmin, fmax].
+// A zero bound leaves that side of the window open.
+func FrequencyMask(freqs []float64, fmin, fmax float64) []bool {
+	mask := make([]bool, len(freqs))
+	for i, f := range freqs {
+		mask[i] = (fmin <= 0 || f >= fmin) && (fmax <= 0 || f <= fmax)
+	}
+	return mask
+}
+
+// CountInWindow returns the number of frequencies within [fmin, fmax]
+func CountInWindow(freqs []float64, fmin, fmax float64) int {
+	n := 0
+	for _, in := range FrequencyMask(freqs, fmin, fmax) {
+		if in {
+			n++
+		}
+	}
+	return n
+}
+
+// hasWindow reports whether the solver restricts the fitted points
+func (s *Solver) hasWindow() bool {
+	return s.FreqMin > 0 || s.FreqMax > 0 || s.excluded != nil
+}
+
+// fitMask reports which points are fitted: within the frequency window and
+// not rejected as outliers
+func (s *Solver) fitMask() []bool {
+	mask := FrequencyMask(s.Freqs, s.FreqMin, s.FreqMax)
+	for i := range mask {
+		if i < len(s.excluded) && s.excluded[i] {
+			mask[i] = false
+		}
+	}
+	return mask
+}
+
+// windowData returns the fitted frequencies, observations and sigmas
+func (s *Solver) windowData() ([]float64, [][2]float64, [][2]float64, error) {
+	if !s.hasWindow() {
+		return s.Freqs, s.Observed, s.Sigmas, nil
+	}
+
+	mask := s.fitMask()
+	freqs := make([]float64, 0, len(s.Freqs))
+	observed := make([][2]float64, 0, len(s.Observed))
+	var sigmas [][2]float64
+	for i, in := range mask {
+		if !in {
+			continue
+		}
+		freqs = append(freqs, s.Freqs[i])
+		observed = append(observed, s.Observed[i])
+		if i < len(s.Sigmas) {
+			sigmas = append(sigmas, s.Sigmas[i])
+		}
+	}
+	if len(freqs) == 0 {
+		return nil, nil, nil, ErrEmptyWindow
+	}
+	return freqs, observed, sigmas, nil
+}
+
+// applyWindow replaces the solver data with the in-window points and returns
+// a function restoring the full data set
+func (s *Solver) applyWindow() (func(), error) {
+	freqs, observed, sigmas, err := s.windowData()
+	if err != nil {
+		return func() {}, err
+	}
+
+	fullFreqs, fullObserved, fullSigmas := s.Freqs, s.Observed, s.Sigmas
+	fullOmegas, fullOmegaFreqs := s.omegas, s.omegaFreqs
+	s.setFreqs(freqs)
+	s.Observed, s.Sigmas = observed, sigmas
+	return func() {
+		s.Freqs, s.Observed, s.Sigmas = fullFreqs, fullObserved, fullSigmas
+		s.omegas, s.omegaFreqs = fullOmegas, fullOmegaFreqs
+	}, nil
+}
+
+// Objective returns the weighted chi-square of params over the fitted frequency
+// window, the same value the solvers minimize
+func (s *Solver) Objective(params []float64) float64 {
+	freqs, observed, sigmas, err := s.windowData()
+	if err != nil {
+		return math.NaN()
+	}
+	return s.chiSq(observed, s.impedance(freqs, params), sigmas)
+}